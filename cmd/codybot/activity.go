@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// activityRecord is one completed agent task, appended to a per-repo log so
+// the dashboard can summarize usage across sessions.
+type activityRecord struct {
+	At           time.Time `json:"at"`
+	Session      string    `json:"session"`
+	Repo         string    `json:"repo"`
+	Model        string    `json:"model"`
+	Prompt       string    `json:"prompt"`
+	InputTokens  int       `json:"input_tokens"`
+	OutputTokens int       `json:"output_tokens"`
+	Cost         float64   `json:"cost,omitempty"`
+	Files        []string  `json:"files,omitempty"`
+	Tests        string    `json:"tests,omitempty"`
+}
+
+const (
+	testsPassed = "passed"
+	testsFailed = "failed"
+)
+
+// turnUsage accumulates estimated token counts across the model rounds of one
+// user turn.
+type turnUsage struct {
+	input  int
+	output int
+	// rounds and tools are the turn's model responses and tool calls, for
+	// the usage database.
+	rounds []usageRound
+	tools  []usageTool
+}
+
+func (cfg config) cost(u turnUsage) float64 {
+	return (float64(u.input)*cfg.InputPrice + float64(u.output)*cfg.OutputPrice) / 1e6
+}
+
+func repoRoot() string {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "."
+	}
+	for d := dir; ; d = filepath.Dir(d) {
+		// .git is a directory, or a file in worktrees and submodules.
+		if _, err := os.Stat(filepath.Join(d, ".git")); err == nil {
+			return d
+		}
+		if filepath.Dir(d) == d {
+			return dir
+		}
+	}
+}
+
+func activityPath(repo string) string {
+	sum := sha256.Sum256([]byte(repo))
+	return filepath.Join(codybotHome(), "activity", filepath.Base(repo)+"-"+hex.EncodeToString(sum[:6])+".jsonl")
+}
+
+func appendActivity(rec activityRecord) error {
+	rec.Prompt, _ = logRedactor().redact(rec.Prompt)
+	path := activityPath(rec.Repo)
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+func loadActivity(repo string) ([]activityRecord, error) {
+	f, err := os.Open(activityPath(repo))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var records []activityRecord
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	for scanner.Scan() {
+		var rec activityRecord
+		if json.Unmarshal(scanner.Bytes(), &rec) == nil {
+			records = append(records, rec)
+		}
+	}
+	return records, scanner.Err()
+}
+
+func (j *editJournal) turnFiles(turn int) []string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, cp := range j.checkpoints[:j.applied] {
+		if cp.Session == j.session && cp.Turn == turn {
+			return cp.files()
+		}
+	}
+	return nil
+}
+
+func (t *testLoop) outcome() string {
+	if t == nil {
+		return ""
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch {
+	case t.attempts == 0 || t.running:
+		return ""
+	case t.passed:
+		return testsPassed
+	}
+	return testsFailed
+}
+
+// recordActivity logs the finished turn; failures only surface as a notice
+// because the dashboard is best-effort.
+func (m *model) recordActivity() {
+	if m.cfg.Safe {
+		return
+	}
+	prompt, _ := truncateRunes(m.turnPrompt, 200)
+	rec := activityRecord{
+		At:           time.Now(),
+		Session:      m.sessionID,
+		Repo:         repoRoot(),
+		Model:        m.cfg.Model,
+		Prompt:       prompt,
+		InputTokens:  m.usage.input,
+		OutputTokens: m.usage.output,
+		Cost:         m.cfg.cost(m.usage),
+		Files:        m.journal.turnFiles(m.turn),
+		Tests:        m.tests.outcome(),
+	}
+	err := appendActivity(rec)
+	if err == nil {
+		err = recordUsage(rec, m.usage)
+	}
+	if err != nil {
+		m.notice = "Activity not recorded: " + err.Error()
+	}
+}
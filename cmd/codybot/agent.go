@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+type agentEvent struct {
+	// start opens an assistant message and end closes it with its full
+	// content and tool calls, before any of those tools run.
+	start  bool
+	end    *message
+	token  string
+	call   *toolCall
+	result *toolResult
+	info   string
+}
+
+// runAgentLoop drives one prompt to completion outside the TUI: it streams a
+// response, executes any requested tools, and feeds their results back until
+// the model answers without calling tools. It returns the extended history
+// and the final answer.
+func runAgentLoop(ctx context.Context, cfg config, registry *toolRegistry, env *toolEnv, history []message, onEvent func(agentEvent)) ([]message, string, error) {
+	if onEvent == nil {
+		onEvent = func(agentEvent) {}
+	}
+	for round := 0; round < maxToolRounds; round++ {
+		onEvent(agentEvent{start: true})
+		ch := make(chan streamMsg)
+		go streamCached(ctx, cfg, history, registry.definitions(), ch)
+
+		var response strings.Builder
+		var calls []toolCall
+		for {
+			msg := <-ch
+			if msg.err != nil {
+				return history, response.String(), classifyError(cfg, msg.err)
+			}
+			if msg.info != "" {
+				onEvent(agentEvent{info: msg.info})
+			}
+			if msg.token != "" {
+				response.WriteString(msg.token)
+				onEvent(agentEvent{token: msg.token})
+			}
+			if msg.done {
+				calls = msg.toolCalls
+				break
+			}
+		}
+
+		if registry == nil {
+			calls = nil
+		}
+		history = append(history, message{Role: "assistant", Content: response.String(), ToolCalls: calls})
+		onEvent(agentEvent{end: &history[len(history)-1]})
+		if len(calls) == 0 {
+			return history, response.String(), nil
+		}
+		for i := range calls {
+			onEvent(agentEvent{call: &calls[i]})
+			result := registry.execute(ctx, env, calls[i])
+			onEvent(agentEvent{result: &result})
+			history = append(history, result.message())
+		}
+	}
+	return history, "", fmt.Errorf("stopped after %d tool rounds", maxToolRounds)
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// agentFileNames are the instruction file names looked for in each
+// directory, in order of preference.
+var agentFileNames = []string{"AGENTS.md", "agents.md"}
+
+// agentFile is one instruction file merged into the system prompt.
+type agentFile struct {
+	path    string
+	scope   string
+	content string
+}
+
+// resolveAgentPath uses AGENTS.md, the name most tools now agree on, when
+// the default agents.md is missing beside it.
+func resolveAgentPath(path string) string {
+	if path != "agents.md" || fileExists(path) {
+		return path
+	}
+	if fileExists("AGENTS.md") {
+		return "AGENTS.md"
+	}
+	return path
+}
+
+// loadAgentFiles finds the instruction files that apply here, from the most
+// general to the most specific: the user's file in the codybot home, then
+// one per directory from the repository root down, then agentPath. Later
+// files are read last, so the model gives their instructions precedence.
+func loadAgentFiles(agentPath string) []agentFile {
+	var files []agentFile
+	var seen []os.FileInfo
+	add := func(path, scope string) {
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() {
+			return
+		}
+		for _, other := range seen {
+			if os.SameFile(info, other) {
+				return
+			}
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return
+		}
+		seen = append(seen, info)
+		files = append(files, agentFile{path: path, scope: scope, content: string(data)})
+	}
+	addDir := func(dir, scope string) {
+		for _, name := range agentFileNames {
+			if fileExists(filepath.Join(dir, name)) {
+				add(filepath.Join(dir, name), scope)
+				return
+			}
+		}
+	}
+
+	addDir(codybotHome(), "user")
+	cwd, err := os.Getwd()
+	if err == nil {
+		root := repoRoot()
+		var dirs []string
+		for d := cwd; within(root, d); d = filepath.Dir(d) {
+			dirs = append(dirs, d)
+			if d == root || filepath.Dir(d) == d {
+				break
+			}
+		}
+		primary, _ := filepath.Abs(agentPath)
+		for i := len(dirs) - 1; i >= 0; i-- {
+			scope := "directory"
+			if dirs[i] == root {
+				scope = "repository"
+			}
+			if dirs[i] == filepath.Dir(primary) {
+				// agentPath speaks for its own directory.
+				continue
+			}
+			addDir(dirs[i], scope)
+		}
+	}
+	add(agentPath, "workspace")
+	return files
+}
+
+// hasProjectAgents reports whether a file besides the user's applies, which
+// skips the offer to create agents.md.
+func hasProjectAgents(files []agentFile) bool {
+	for _, f := range files {
+		if f.scope != "user" {
+			return true
+		}
+	}
+	return false
+}
+
+// inheritsAgents reports whether instructions come from files other than
+// agentPath, which changes how they are merged.
+func inheritsAgents(files []agentFile, agentPath string) bool {
+	for _, f := range files {
+		if f.path != agentPath {
+			return true
+		}
+	}
+	return false
+}
+
+func agentFileMarker(path string) string {
+	return fmt.Sprintf("<!-- from %s -->\n", displayPath(path))
+}
+
+// mergeAgentFiles joins the files for the system prompt. A lone agentPath is
+// used as is; otherwise each file is headed by a marker naming it, and
+// agentPath comes last, marked even while it does not exist, so /system can
+// write back just its part.
+func mergeAgentFiles(files []agentFile, agentPath string) string {
+	if !inheritsAgents(files, agentPath) {
+		if len(files) == 0 {
+			return ""
+		}
+		return files[0].content
+	}
+	var b strings.Builder
+	local := ""
+	for _, f := range files {
+		if f.path == agentPath {
+			local = f.content
+			continue
+		}
+		b.WriteString(agentFileMarker(f.path) + strings.TrimSpace(f.content) + "\n\n")
+	}
+	b.WriteString(agentFileMarker(agentPath) + local)
+	return b.String()
+}
+
+// displayPath shortens path for showing: relative to the working directory
+// when inside it, or with ~ for the home directory.
+func displayPath(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	if cwd, err := os.Getwd(); err == nil && within(cwd, abs) {
+		if rel, err := filepath.Rel(cwd, abs); err == nil {
+			return rel
+		}
+	}
+	if home, err := os.UserHomeDir(); err == nil && within(home, abs) {
+		if rel, err := filepath.Rel(home, abs); err == nil {
+			return filepath.Join("~", rel)
+		}
+	}
+	return abs
+}
+
+// reloadAgents reads the instruction files again and rebuilds the system
+// prompt from them.
+func (m *model) reloadAgents() {
+	m.agentFiles = loadAgentFiles(m.cfg.AgentPath)
+	m.agentContent = mergeAgentFiles(m.agentFiles, m.cfg.AgentPath)
+	m.refreshSystemPrompt()
+}
+
+// cmdContext lists the instruction files in the system prompt, in the order
+// they were merged.
+func (m *model) cmdContext(string) tea.Cmd {
+	if m.cfg.Safe {
+		m.appendNote("Safe mode: no instruction files are loaded.")
+		return nil
+	}
+	var b strings.Builder
+	if len(m.agentFiles) == 0 {
+		fmt.Fprintf(&b, "No instruction files loaded. codybot looks for AGENTS.md or agents.md in %s, in each directory from the repository root down, and at %s.\n", displayPath(codybotHome()), m.cfg.AgentPath)
+	} else {
+		b.WriteString("Instruction files, in the order they are merged (later ones take precedence):\n")
+		for i, f := range m.agentFiles {
+			fmt.Fprintf(&b, "  %d. %s (%s, %d tokens)\n", i+1, displayPath(f.path), f.scope, estimateTokens(f.content))
+		}
+	}
+	if m.profile != nil {
+		fmt.Fprintf(&b, "Profile: %s\n", m.profile.Name)
+	}
+	if m.systemOverride != "" {
+		b.WriteString("The system prompt was edited with /system, so these files are not used until /system reset.\n")
+	}
+	m.appendNote(strings.TrimRight(b.String(), "\n"))
+	return nil
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// diffLanguages are the fence info strings that mark a unified diff.
+var diffLanguages = map[string]bool{"diff": true, "patch": true, "udiff": true}
+
+var (
+	fenceOpen  = regexp.MustCompile("^\\s*(```+|~~~+)\\s*(.*)$")
+	hunkHeader = regexp.MustCompile(`^@@ -(\d+)(?:,\d+)? \+(\d+)(?:,\d+)? @@`)
+	// pathLabel is a "path: main.go" line naming the file the next fenced
+	// block holds, as models without tool calls often write it, in markdown
+	// emphasis, a heading, or a list item.
+	pathLabel = regexp.MustCompile("(?i)^\\s*(?:#+\\s*|[-*]\\s+)?(?:\\*\\*|__)?(?:file ?path|path|file ?name|file)(?:\\*\\*|__)?\\s*:\\s*(?:\\*\\*|__)?\\s*`?([^`*\\s]+)`?\\s*(?:\\*\\*|__)?\\s*:?\\s*$")
+	// commentPathLabel is the same label as a comment on a block's first line.
+	commentPathLabel = regexp.MustCompile(`(?i)^\s*(?://|#|--|;|/\*|<!--)\s*(?:file ?path|path|file ?name|file)\s*:\s*(\S+?)\s*(?:\*/|-->)?\s*$`)
+)
+
+// filePatch is one file's part of a unified diff.
+type filePatch struct {
+	oldPath, newPath string
+	hunks            []diffHunk
+}
+
+type diffHunk struct {
+	oldStart int
+	ops      []diffOp
+}
+
+// stageAnswerEdits collects the file changes an answer spells out, for
+// models that cannot call write_file: unified diffs, fenced or not, and
+// fenced blocks labelled with a path, like ```go main.go or ```main.go, or
+// by a "path: main.go" line before the block or a comment on its first
+// line, which replace the whole file. Changes that cannot be staged are reported
+// as problems.
+func stageAnswerEdits(response string) (*stagedEdits, []string) {
+	staged := &stagedEdits{fromAnswer: true}
+	var problems []string
+	stage := func(path string, content func([]byte, bool) ([]byte, error)) {
+		rel, err := resolveWritablePath(path)
+		if err != nil {
+			problems = append(problems, err.Error())
+			return
+		}
+		current, existed := staged.read(rel)
+		if !existed {
+			current, err = os.ReadFile(rel)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				problems = append(problems, err.Error())
+				return
+			}
+			existed = err == nil
+		}
+		after, err := content(current, existed)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", rel, err))
+			return
+		}
+		if err := staged.write(rel, after); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	stageDiff := func(text, defaultPath string) {
+		for _, patch := range parseUnifiedDiff(text, defaultPath) {
+			if patch.newPath == "/dev/null" {
+				problems = append(problems, patch.oldPath+": deleting files is not supported here")
+				continue
+			}
+			newFile := patch.oldPath == "/dev/null"
+			stage(patch.newPath, func(current []byte, existed bool) ([]byte, error) {
+				if !existed && !newFile {
+					return nil, errors.New("the diff edits a file that does not exist")
+				}
+				return applyHunks(current, patch.hunks)
+			})
+		}
+	}
+
+	lines := strings.Split(response, "\n")
+	var loose []string
+	label := ""
+	for i := 0; i < len(lines); i++ {
+		match := fenceOpen.FindStringSubmatch(lines[i])
+		if match == nil {
+			loose = append(loose, lines[i])
+			if strings.TrimSpace(lines[i]) != "" {
+				label = labelledPath(pathLabel, lines[i])
+			}
+			continue
+		}
+		marker, info := match[1], strings.TrimSpace(match[2])
+		var body []string
+		for i++; i < len(lines); i++ {
+			if trimmed := strings.TrimSpace(lines[i]); strings.HasPrefix(trimmed, marker) && strings.Trim(trimmed, marker[:1]) == "" {
+				break
+			}
+			body = append(body, lines[i])
+		}
+		language, path := fenceInfo(info)
+		if path == "" && len(body) > 0 {
+			if path = labelledPath(commentPathLabel, body[0]); path != "" {
+				body = body[1:]
+			}
+		}
+		if path == "" {
+			path = label
+		}
+		label = ""
+		text := strings.Join(body, "\n")
+		switch {
+		case diffLanguages[language] || looksLikeDiff(text):
+			stageDiff(text, path)
+		case path != "":
+			stage(path, func([]byte, bool) ([]byte, error) {
+				return []byte(text + "\n"), nil
+			})
+		}
+	}
+	stageDiff(strings.Join(loose, "\n"), "")
+	return staged, problems
+}
+
+// fenceInfo splits a fence's info string into its language and the file
+// path it is labelled with, if any: "go main.go", "go:main.go",
+// "main.go", and `go title="main.go"` all name main.go.
+func fenceInfo(info string) (language, path string) {
+	fields := strings.FieldsFunc(info, func(r rune) bool { return r == ' ' || r == '\t' || r == ':' })
+	for i, field := range fields {
+		if _, value, ok := strings.Cut(field, "="); ok {
+			field = value
+		}
+		field = strings.Trim(field, `"'`+"`")
+		if i == 0 && !strings.ContainsAny(field, "./") && !fileExists(field) {
+			language = strings.ToLower(field)
+			continue
+		}
+		// A URL splits at its colon into a scheme and "//host".
+		if path == "" && field != "" && !strings.HasPrefix(field, "//") && (strings.ContainsAny(field, "./") || fileExists(field)) {
+			path = field
+		}
+	}
+	return language, path
+}
+
+// labelledPath returns the path a label line names, if it looks like one.
+func labelledPath(label *regexp.Regexp, line string) string {
+	match := label.FindStringSubmatch(line)
+	if match == nil {
+		return ""
+	}
+	path := strings.TrimRight(match[1], ".,;:")
+	if !strings.ContainsAny(path, "./") && !fileExists(path) {
+		return ""
+	}
+	return path
+}
+
+func looksLikeDiff(text string) bool {
+	return strings.HasPrefix(text, "--- ") || strings.HasPrefix(text, "diff --git ") || strings.HasPrefix(text, "@@ ")
+}
+
+// parseUnifiedDiff reads the file patches in text. Models get hunk line
+// counts wrong often enough that they are ignored: a hunk runs until the
+// first line that is not part of one. Hunks before any file header belong
+// to defaultPath.
+func parseUnifiedDiff(text, defaultPath string) []filePatch {
+	var patches []filePatch
+	var current *filePatch
+	var hunk *diffHunk
+	lines := strings.Split(text, "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		if strings.HasPrefix(line, "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ ") {
+			patches = append(patches, filePatch{oldPath: diffPath(line[4:]), newPath: diffPath(lines[i+1][4:])})
+			current, hunk = &patches[len(patches)-1], nil
+			i++
+			continue
+		}
+		if match := hunkHeader.FindStringSubmatch(line); match != nil {
+			if current == nil {
+				if defaultPath == "" {
+					continue
+				}
+				patches = append(patches, filePatch{oldPath: defaultPath, newPath: defaultPath})
+				current = &patches[len(patches)-1]
+			}
+			start, _ := strconv.Atoi(match[1])
+			current.hunks = append(current.hunks, diffHunk{oldStart: start})
+			hunk = &current.hunks[len(current.hunks)-1]
+			continue
+		}
+		if hunk == nil {
+			continue
+		}
+		switch {
+		case line == "":
+			// Blank context lines often lose their leading space.
+			hunk.ops = append(hunk.ops, diffOp{kind: ' '})
+		case line[0] == ' ' || line[0] == '-' || line[0] == '+':
+			hunk.ops = append(hunk.ops, diffOp{kind: line[0], line: line[1:]})
+		case line[0] == '\\':
+			// "\ No newline at end of file"
+		default:
+			hunk = nil
+		}
+	}
+	// The blank lines that end a hunk are usually the gap before the next
+	// file or the end of the block; as context they only anchor, so drop them.
+	for p := range patches {
+		for h := range patches[p].hunks {
+			ops := patches[p].hunks[h].ops
+			for len(ops) > 0 && ops[len(ops)-1] == (diffOp{kind: ' '}) {
+				ops = ops[:len(ops)-1]
+			}
+			patches[p].hunks[h].ops = ops
+		}
+	}
+	return patches
+}
+
+// diffPath strips the a/ and b/ prefixes and any timestamp from a diff
+// header's path.
+func diffPath(header string) string {
+	path, _, _ := strings.Cut(header, "\t")
+	path = strings.TrimSpace(path)
+	if path == "/dev/null" {
+		return path
+	}
+	for _, prefix := range []string{"a/", "b/"} {
+		if strings.HasPrefix(path, prefix) {
+			return path[len(prefix):]
+		}
+	}
+	return path
+}
+
+// applyHunks patches content. Each hunk's old lines are looked up nearest
+// the line its header names, and after the previous hunk, ignoring trailing
+// whitespace when an exact match fails, since models rarely reproduce line
+// numbers or whitespace faithfully.
+func applyHunks(content []byte, hunks []diffHunk) ([]byte, error) {
+	text := string(content)
+	trailingNewline := text == "" || strings.HasSuffix(text, "\n")
+	var lines []string
+	if text != "" {
+		lines = strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	}
+	from := 0
+	for n, hunk := range hunks {
+		var old []string
+		for _, op := range hunk.ops {
+			if op.kind != '+' {
+				old = append(old, op.line)
+			}
+		}
+		var at int
+		if len(old) == 0 {
+			at = min(max(hunk.oldStart, from), len(lines))
+		} else {
+			at = findLines(lines, old, from, hunk.oldStart-1)
+		}
+		if at < 0 {
+			return nil, fmt.Errorf("hunk %d does not match the file", n+1)
+		}
+		// Context lines keep the file's text, which may differ from the
+		// hunk's in trailing whitespace.
+		var replacement []string
+		k := at
+		for _, op := range hunk.ops {
+			switch op.kind {
+			case ' ':
+				replacement = append(replacement, lines[k])
+				k++
+			case '-':
+				k++
+			default:
+				replacement = append(replacement, op.line)
+			}
+		}
+		lines = append(lines[:at], append(replacement, lines[at+len(old):]...)...)
+		from = at + len(replacement)
+	}
+	result := strings.Join(lines, "\n")
+	if trailingNewline && result != "" {
+		result += "\n"
+	}
+	return []byte(result), nil
+}
+
+// findLines returns where want occurs in lines at or after from, choosing
+// the occurrence nearest near, or -1.
+func findLines(lines, want []string, from, near int) int {
+	for _, equal := range []func(a, b string) bool{
+		func(a, b string) bool { return a == b },
+		func(a, b string) bool { return strings.TrimRight(a, " \t\r") == strings.TrimRight(b, " \t\r") },
+	} {
+		best := -1
+		for at := from; at+len(want) <= len(lines); at++ {
+			match := true
+			for i := range want {
+				if !equal(lines[at+i], want[i]) {
+					match = false
+					break
+				}
+			}
+			if match && (best < 0 || abs(at-near) < abs(best-near)) {
+				best = at
+			}
+		}
+		if best >= 0 {
+			return best
+		}
+	}
+	return -1
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// offerAnswerEdits stages the file changes in a finished answer and offers
+// them for review; nothing touches disk until the user applies them.
+func (m *model) offerAnswerEdits(response string) {
+	if m.cfg.ReadOnly {
+		return
+	}
+	staged, problems := stageAnswerEdits(response)
+	files := staged.changed()
+	if len(files) == 0 && len(problems) == 0 {
+		return
+	}
+	var note strings.Builder
+	if len(files) > 0 {
+		staged.description = firstLine(strings.TrimSpace(m.turnPrompt))
+		m.answerEdits = staged
+		paths := make([]string, len(files))
+		for i, f := range files {
+			paths[i] = f.path
+		}
+		fmt.Fprintf(&note, "The answer changes %s. Ctrl+Y or /apply previews the diff before anything is written.", strings.Join(paths, ", "))
+	}
+	if len(problems) > 0 {
+		fmt.Fprintf(&note, "\nCould not stage from the answer:\n- %s", strings.Join(problems, "\n- "))
+	}
+	m.appendNote(strings.TrimSpace(note.String()))
+}
+
+func (m *model) cmdApply(string) tea.Cmd {
+	if m.answerEdits == nil {
+		m.notice = "The last answer has no file changes to apply"
+		return nil
+	}
+	if m.preview != nil {
+		m.notice = "A refactor preview is pending; apply or discard it first (/refactor-preview)"
+		return nil
+	}
+	m.preview, m.answerEdits = m.answerEdits, nil
+	m.state = statePreview
+	m.previewCursor, m.previewScroll = 0, 0
+	return nil
+}
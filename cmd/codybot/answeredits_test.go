@@ -0,0 +1,292 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const fence = "```"
+
+func TestApplyHunks(t *testing.T) {
+	const file = "package main\n\nfunc a() {\n\treturn\n}\n\nfunc b() {\n\treturn\n}\n"
+	tests := []struct {
+		name    string
+		content string
+		diff    string
+		want    string
+		err     string
+	}{
+		{
+			name:    "exact",
+			content: file,
+			diff:    "@@ -3,3 +3,3 @@\n func a() {\n-\treturn\n+\treturn 1\n }",
+			want:    "package main\n\nfunc a() {\n\treturn 1\n}\n\nfunc b() {\n\treturn\n}\n",
+		},
+		{
+			name:    "wrong line numbers",
+			content: file,
+			diff:    "@@ -40,3 +40,3 @@\n func b() {\n-\treturn\n+\treturn 2\n }",
+			want:    "package main\n\nfunc a() {\n\treturn\n}\n\nfunc b() {\n\treturn 2\n}\n",
+		},
+		{
+			name:    "nearest of two matches",
+			content: file,
+			diff:    "@@ -8 +8 @@\n-\treturn\n+\treturn 2",
+			want:    "package main\n\nfunc a() {\n\treturn\n}\n\nfunc b() {\n\treturn 2\n}\n",
+		},
+		{
+			name:    "two hunks in order",
+			content: file,
+			diff:    "@@ -4 +4 @@\n-\treturn\n+\treturn 1\n@@ -8 +8 @@\n-\treturn\n+\treturn 2",
+			want:    "package main\n\nfunc a() {\n\treturn 1\n}\n\nfunc b() {\n\treturn 2\n}\n",
+		},
+		{
+			name:    "trailing whitespace ignored",
+			content: "a  \nb\n",
+			diff:    "@@ -1,2 +1,2 @@\n a\n-b\n+c",
+			want:    "a  \nc\n",
+		},
+		{
+			name:    "blank context without its space",
+			content: "a\n\nb\n",
+			diff:    "@@ -1,3 +1,3 @@\n a\n\n-b\n+c",
+			want:    "a\n\nc\n",
+		},
+		{
+			name:    "pure insertion",
+			content: "a\nb\n",
+			diff:    "@@ -1,0 +2 @@\n+inserted",
+			want:    "a\ninserted\nb\n",
+		},
+		{
+			name:    "new file",
+			content: "",
+			diff:    "@@ -0,0 +1,2 @@\n+one\n+two",
+			want:    "one\ntwo\n",
+		},
+		{
+			name:    "no trailing newline kept",
+			content: "a\nb",
+			diff:    "@@ -2 +2 @@\n-b\n+c\n\\ No newline at end of file",
+			want:    "a\nc",
+		},
+		{
+			name:    "context missing",
+			content: file,
+			diff:    "@@ -3,3 +3,3 @@\n func c() {\n-\treturn\n+\treturn 3\n }",
+			err:     "hunk 1 does not match",
+		},
+		{
+			name:    "hunks out of order",
+			content: "a\nb\nc\n",
+			diff:    "@@ -3 +3 @@\n-c\n+C\n@@ -1 +1 @@\n-a\n+A",
+			err:     "hunk 2 does not match",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patches := parseUnifiedDiff(tt.diff, "file.go")
+			if len(patches) != 1 {
+				t.Fatalf("parsed %d patches, want 1", len(patches))
+			}
+			got, err := applyHunks([]byte(tt.content), patches[0].hunks)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("applyHunks error = %v, want one mentioning %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("applyHunks = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name  string
+		diff  string
+		paths [][2]string
+		hunks []int
+	}{
+		{
+			name:  "git headers",
+			diff:  "diff --git a/x.go b/x.go\n--- a/x.go\n+++ b/x.go\n@@ -1 +1 @@\n-a\n+b",
+			paths: [][2]string{{"x.go", "x.go"}},
+			hunks: []int{1},
+		},
+		{
+			name:  "timestamps and two files",
+			diff:  "--- x.go\t2024-01-01\n+++ x.go\t2024-01-02\n@@ -1 +1 @@\n-a\n+b\n--- /dev/null\n+++ b/y.go\n@@ -0,0 +1 @@\n+y",
+			paths: [][2]string{{"x.go", "x.go"}, {"/dev/null", "y.go"}},
+			hunks: []int{1, 1},
+		},
+		{
+			name:  "hunk without a header uses the default path",
+			diff:  "@@ -1 +1 @@\n-a\n+b\n@@ -5 +5 @@\n-c\n+d",
+			paths: [][2]string{{"default.go", "default.go"}},
+			hunks: []int{2},
+		},
+		{
+			name: "prose is not a diff",
+			diff: "Change a to b in the first line.",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patches := parseUnifiedDiff(tt.diff, "default.go")
+			if len(patches) != len(tt.paths) {
+				t.Fatalf("parsed %d patches, want %d", len(patches), len(tt.paths))
+			}
+			for i, p := range patches {
+				if p.oldPath != tt.paths[i][0] || p.newPath != tt.paths[i][1] || len(p.hunks) != tt.hunks[i] {
+					t.Errorf("patch %d: %s -> %s with %d hunks, want %s -> %s with %d", i, p.oldPath, p.newPath, len(p.hunks), tt.paths[i][0], tt.paths[i][1], tt.hunks[i])
+				}
+			}
+		})
+	}
+}
+
+// TestStageAnswerEdits stages answers against a workspace holding main.go,
+// checking what would be written and what is refused.
+func TestStageAnswerEdits(t *testing.T) {
+	tests := []struct {
+		name     string
+		answer   string
+		files    map[string]string
+		problems []string
+	}{
+		{
+			name:   "fenced diff",
+			answer: "Here:\n" + fence + "diff\n--- a/main.go\n+++ b/main.go\n@@ -1 +1 @@\n-package main\n+package app\n" + fence,
+			files:  map[string]string{"main.go": "package app\n"},
+		},
+		{
+			name:   "loose diff",
+			answer: "--- a/main.go\n+++ b/main.go\n@@ -1 +1 @@\n-package main\n+package app\n\nThat renames the package.",
+			files:  map[string]string{"main.go": "package app\n"},
+		},
+		{
+			name:   "labelled block",
+			answer: "**File: `src/util.go`**\n" + fence + "go\npackage src\n" + fence,
+			files:  map[string]string{"src/util.go": "package src\n"},
+		},
+		{
+			name:   "path in the fence info",
+			answer: fence + "go main.go\npackage other\n" + fence,
+			files:  map[string]string{"main.go": "package other\n"},
+		},
+		{
+			name:   "diff and block for one file",
+			answer: fence + "go new.go\nline one\n" + fence + "\n" + fence + "diff\n--- a/new.go\n+++ b/new.go\n@@ -1 +1 @@\n-line one\n+line two\n" + fence,
+			files:  map[string]string{"new.go": "line two\n"},
+		},
+		{
+			name:     "hunk does not match",
+			answer:   fence + "diff\n--- a/main.go\n+++ b/main.go\n@@ -1 +1 @@\n-package lib\n+package app\n" + fence,
+			problems: []string{"main.go: hunk 1 does not match"},
+		},
+		{
+			name:     "missing file",
+			answer:   fence + "diff\n--- a/gone.go\n+++ b/gone.go\n@@ -1 +1 @@\n-a\n+b\n" + fence,
+			problems: []string{"does not exist"},
+		},
+		{
+			name:     "deletion",
+			answer:   fence + "diff\n--- a/main.go\n+++ /dev/null\n@@ -1 +0,0 @@\n-package main\n" + fence,
+			problems: []string{"deleting files is not supported"},
+		},
+		{
+			name:     "outside the workspace",
+			answer:   fence + "diff\n--- /dev/null\n+++ b/../escape.go\n@@ -0,0 +1 @@\n+package escape\n" + fence,
+			problems: []string{"outside the workspace"},
+		},
+		{
+			name:     "protected directory",
+			answer:   fence + "sh .git/hooks/pre-commit\nexit 0\n" + fence,
+			problems: []string{"inside .git"},
+		},
+		{
+			name:   "unchanged block",
+			answer: fence + "go main.go\npackage main\n" + fence,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Chdir(t.TempDir())
+			if err := os.WriteFile("main.go", []byte("package main\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			staged, problems := stageAnswerEdits(tt.answer)
+			if len(problems) != len(tt.problems) {
+				t.Fatalf("problems %q, want %d mentioning %q", problems, len(tt.problems), tt.problems)
+			}
+			for i, want := range tt.problems {
+				if !strings.Contains(problems[i], want) {
+					t.Errorf("problem %q lacks %q", problems[i], want)
+				}
+			}
+			changed := staged.changed()
+			if len(changed) != len(tt.files) {
+				t.Fatalf("%d files staged, want %d", len(changed), len(tt.files))
+			}
+			for _, f := range changed {
+				want, ok := tt.files[filepath.ToSlash(f.path)]
+				if !ok || string(f.after) != want {
+					t.Errorf("%s staged as %q, want %q", f.path, f.after, want)
+				}
+			}
+			if data, _ := os.ReadFile("main.go"); string(data) != "package main\n" {
+				t.Errorf("staging wrote main.go: %q", data)
+			}
+		})
+	}
+}
+
+// TestApplyStagedEdits checks that staged edits land through the journal,
+// and are refused when the file changed after staging.
+func TestApplyStagedEdits(t *testing.T) {
+	tests := []struct {
+		name     string
+		tamper   string
+		conflict bool
+		want     string
+	}{
+		{name: "clean", want: "package app\n"},
+		{name: "changed after staging", tamper: "package other\n", conflict: true, want: "package other\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Chdir(t.TempDir())
+			if err := os.WriteFile("main.go", []byte("package main\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			staged, problems := stageAnswerEdits(fence + "diff\n--- a/main.go\n+++ b/main.go\n@@ -1 +1 @@\n-package main\n+package app\n" + fence)
+			if len(problems) > 0 {
+				t.Fatal(problems)
+			}
+			if tt.tamper != "" {
+				if err := os.WriteFile("main.go", []byte(tt.tamper), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			journal := newEditJournal()
+			err := staged.apply(journal, journal.nextTurn(), "rename")
+			if (err != nil) != tt.conflict {
+				t.Fatalf("apply error = %v, want conflict %v", err, tt.conflict)
+			}
+			if data, _ := os.ReadFile("main.go"); string(data) != tt.want {
+				t.Errorf("main.go = %q, want %q", data, tt.want)
+			}
+			if _, applied := journal.snapshot(); applied != map[bool]int{false: 1}[tt.conflict] {
+				t.Errorf("%d checkpoints applied", applied)
+			}
+		})
+	}
+}
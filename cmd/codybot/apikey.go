@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+const apiKeyCommandTimeout = 30 * time.Second
+
+// rotatedKeys holds the key most recently loaded for each endpoint, keyed by
+// base URL and the key it was started with, so a key reloaded after a 401
+// reaches every config copy: the TUI, failover, self-check, and serve.
+var rotatedKeys = struct {
+	sync.Mutex
+	keys map[string]string
+}{keys: map[string]string{}}
+
+func (cfg config) keySlot() string {
+	return strings.TrimRight(cfg.BaseURL, "/") + "\x00" + cfg.APIKey
+}
+
+// apiKey returns the key to send: the rotated one when a reload happened,
+// otherwise the configured key.
+func (cfg config) apiKey() string {
+	rotatedKeys.Lock()
+	defer rotatedKeys.Unlock()
+	if key, ok := rotatedKeys.keys[cfg.keySlot()]; ok {
+		return key
+	}
+	return cfg.APIKey
+}
+
+func apiKeyAccount(baseURL string) string {
+	return "api-key:" + strings.TrimRight(baseURL, "/")
+}
+
+func (cfg config) apiKeyEnvName() string {
+	switch {
+	case cfg.apiKeyEnv != "":
+		return cfg.apiKeyEnv
+	case cfg.Provider == providerOpenRouter && envOrDefault("OPENAI_API_KEY", "") == "":
+		return "OPENROUTER_API_KEY"
+	}
+	return "OPENAI_API_KEY"
+}
+
+// loadAPIKey reads the key from the first source that has one:
+// --api-key-command, the keyring entry for the endpoint, then the
+// environment. It returns the key and where it came from.
+func (cfg config) loadAPIKey(ctx context.Context) (string, string, error) {
+	if cfg.APIKeyCommand != "" {
+		ctx, cancel := context.WithTimeout(ctx, apiKeyCommandTimeout)
+		defer cancel()
+		out, err := shellCommand(ctx, cfg.APIKeyCommand).Output()
+		if err != nil {
+			var exit *exec.ExitError
+			if errors.As(err, &exit) && len(exit.Stderr) > 0 {
+				err = fmt.Errorf("%w: %s", err, strings.TrimSpace(string(exit.Stderr)))
+			}
+			return "", "", fmt.Errorf("--api-key-command: %w", err)
+		}
+		if key := strings.TrimSpace(string(out)); key != "" {
+			return key, "--api-key-command", nil
+		}
+		return "", "", errors.New("--api-key-command printed no key")
+	}
+	if key, err := keyringGet(apiKeyAccount(cfg.BaseURL)); err == nil && strings.TrimSpace(key) != "" {
+		return strings.TrimSpace(key), "the keyring", nil
+	}
+	name := cfg.apiKeyEnvName()
+	if key := envOrDefault(name, ""); key != "" {
+		return key, name, nil
+	}
+	return "", "", fmt.Errorf("no key found in --api-key-command, the keyring, or %s", name)
+}
+
+// refreshCredentials reloads the credentials after the endpoint rejected
+// them. It reports where the new ones came from and whether they differ from
+// the ones just sent, so callers retry only when retrying can help.
+func (cfg config) refreshCredentials(ctx context.Context) (string, bool, error) {
+	if cfg.tokens != nil {
+		changed, err := cfg.tokens.forceRefresh(ctx)
+		return "the token endpoint", changed, err
+	}
+	key, source, err := cfg.loadAPIKey(ctx)
+	if err != nil {
+		return "", false, err
+	}
+	rotatedKeys.Lock()
+	defer rotatedKeys.Unlock()
+	slot := cfg.keySlot()
+	current, ok := rotatedKeys.keys[slot]
+	if !ok {
+		current = cfg.APIKey
+	}
+	rotatedKeys.keys[slot] = key
+	return source, key != current, nil
+}
+
+// retryUnauthorized reloads the credentials after a 401 and reports whether
+// the request is worth sending again, explaining the outcome on ch.
+func (cfg config) retryUnauthorized(ctx context.Context, ch chan<- streamMsg) bool {
+	source, changed, err := cfg.refreshCredentials(ctx)
+	switch {
+	case err != nil:
+		ch <- streamMsg{info: fmt.Sprintf("%s rejected the credentials and reloading them failed: %v", cfg.Model, err)}
+	case !changed:
+		ch <- streamMsg{info: fmt.Sprintf("%s rejected the credentials; %s has the same ones", cfg.Model, source)}
+	default:
+		ch <- streamMsg{info: fmt.Sprintf("%s rejected the credentials; retrying with fresh ones from %s", cfg.Model, source)}
+	}
+	return err == nil && changed
+}
+
+// forceRefresh replaces a token the server rejected even though it had not
+// expired yet: first with a newer one another process saved to the keyring,
+// then through the refresh token.
+func (ts *tokenSource) forceRefresh(ctx context.Context) (bool, error) {
+	ts.mu.Lock()
+	old := ts.token.AccessToken
+	stored := &tokenSource{oauth: ts.oauth}
+	if err := stored.load(); err == nil && stored.token.AccessToken != old && stored.token.valid() {
+		ts.token = stored.token
+		ts.mu.Unlock()
+		return true, nil
+	}
+	ts.token.Expiry = time.Now()
+	ts.mu.Unlock()
+	token, err := ts.accessToken(ctx)
+	return err == nil && token != old, err
+}
+
+func maskKey(key string) string {
+	if len(key) <= 8 {
+		return strings.Repeat("•", len(key))
+	}
+	return "…" + key[len(key)-4:]
+}
+
+func (m *model) cmdAuth(args string) tea.Cmd {
+	if args != "" && args != "refresh" {
+		m.notice = "Usage: /auth refresh"
+		return nil
+	}
+	source, changed, err := m.cfg.refreshCredentials(context.Background())
+	switch {
+	case err != nil:
+		m.lastErr = fmt.Errorf("auth refresh: %w", err)
+	case m.cfg.tokens != nil && changed:
+		m.lastErr = nil
+		m.notice = "Refreshed the access token"
+	case m.cfg.tokens != nil:
+		m.lastErr = nil
+		m.notice = "The access token is unchanged"
+	case changed:
+		m.lastErr = nil
+		m.notice = fmt.Sprintf("Loaded a new API key (%s) from %s", maskKey(m.cfg.apiKey()), source)
+	default:
+		m.lastErr = nil
+		m.notice = fmt.Sprintf("The API key from %s is unchanged (%s)", source, maskKey(m.cfg.apiKey()))
+	}
+	return nil
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// Command policies: auto runs session tool commands without asking, ask
+// shows each one with its risk for approval, and strict also refuses
+// destructive ones outright.
+const (
+	commandsAuto   = "auto"
+	commandsAsk    = "ask"
+	commandsStrict = "strict"
+)
+
+const (
+	riskReadOnly    = "read-only"
+	riskMutating    = "mutating"
+	riskDestructive = "destructive"
+)
+
+var commandPolicies = []string{commandsAuto, commandsAsk, commandsStrict}
+
+const classifyPrompt = `Classify the risk of running the shell command below in the user's project. Reply with JSON only: {"risk": "read-only" | "mutating" | "destructive", "reason": "<one short sentence saying what it does>"}. read-only: only reads files or state, such as listing, searching, or running tests. mutating: changes files, installs packages, commits, or calls services in ways that can be undone. destructive: deletes data or history, overwrites without a backup, force-pushes, or changes deployed systems or credentials. When unsure between two, pick the riskier.`
+
+// commandsPolicy is the "commands" object of a policy file:
+//
+//	{"commands": {"policy": "strict"}}
+type commandsPolicy struct {
+	Policy string `json:"policy"`
+}
+
+func checkCommandPolicy(name string) error {
+	for _, policy := range commandPolicies {
+		if name == policy {
+			return nil
+		}
+	}
+	return fmt.Errorf("--command-policy: unknown policy %q; use one of %s", name, strings.Join(commandPolicies, ", "))
+}
+
+// commandPolicy is the strictest of the flag and the policy files. A policy
+// file that cannot be read makes it strict rather than quietly lax.
+func commandPolicy(flag string) string {
+	rank := map[string]int{commandsAuto: 0, commandsAsk: 1, commandsStrict: 2}
+	policy := flag
+	files, err := readPolicyFiles()
+	if err != nil {
+		return commandsStrict
+	}
+	for _, file := range files {
+		if rank[file.Commands.Policy] > rank[policy] {
+			policy = file.Commands.Policy
+		}
+	}
+	return policy
+}
+
+type commandRisk struct {
+	Level  string `json:"risk"`
+	Reason string `json:"reason"`
+}
+
+// classifyCommand asks the check model, or the main model without one, how
+// much damage command can do.
+func classifyCommand(ctx context.Context, cfg config, command string) (commandRisk, error) {
+	if cfg.CheckModel != "" {
+		cfg.Model = cfg.CheckModel
+	}
+	cfg.Temperature = 0
+	request := []message{
+		{Role: "system", Content: classifyPrompt},
+		{Role: "user", Content: command},
+	}
+	_, reply, err := runAgentLoop(ctx, cfg, nil, nil, request, nil)
+	if err != nil {
+		return commandRisk{}, err
+	}
+	var risk commandRisk
+	if err := json.Unmarshal([]byte(extractJSON(reply)), &risk); err != nil {
+		return commandRisk{}, fmt.Errorf("unreadable classification %q", firstLine(reply))
+	}
+	switch risk.Level {
+	case riskReadOnly, riskMutating, riskDestructive:
+		return risk, nil
+	}
+	return commandRisk{}, fmt.Errorf("unknown risk %q", risk.Level)
+}
+
+// sessionCommand returns the shell command a call of a session tool would
+// run, or false when the call is not one.
+func (r *toolRegistry) sessionCommand(call toolCall) (string, bool) {
+	spec, ok := r.specs[call.Function.Name]
+	if !ok {
+		spec, ok = r.granted(call.Function.Name)
+	}
+	if !ok || spec.template == "" {
+		return "", false
+	}
+	var args map[string]any
+	if err := json.Unmarshal([]byte(call.Function.Arguments), &args); err != nil {
+		return "", false
+	}
+	command, err := expandTemplate(spec.template, args)
+	if err != nil {
+		return "", false
+	}
+	return command, true
+}
+
+// checkCommandRisk enforces the strict policy wherever tools run, including
+// codybot run and serve, where nobody is asked. A command is classified here
+// unless the approval already did it.
+func (r *toolRegistry) checkCommandRisk(ctx context.Context, env *toolEnv, call toolCall) error {
+	command, ok := r.sessionCommand(call)
+	if !ok || commandPolicy(r.cfg.CommandPolicy) != commandsStrict {
+		return nil
+	}
+	if prefix, ok := r.grantedCommand(command); ok {
+		appendAudit(auditEntry{At: time.Now(), Event: "use", Tool: call.Function.Name, Command: prefix, Overrides: "--command-policy strict", Call: command})
+		return nil
+	}
+	risk, ok := commandRisk{}, false
+	if env != nil {
+		risk, ok = env.risks[call.ID]
+	}
+	if !ok {
+		var err error
+		if risk, err = classifyCommand(ctx, r.cfg, command); err != nil {
+			return fmt.Errorf("the strict command policy blocks commands that cannot be classified: %v", err)
+		}
+	}
+	if risk.Level == riskDestructive {
+		return fmt.Errorf("the strict command policy blocks destructive commands (%s). If it is needed, tell the user the command so they can run it themselves", risk.Reason)
+	}
+	return nil
+}
+
+// commandApproval holds a round of tool calls while the user decides on the
+// session tool commands among them.
+type commandApproval struct {
+	env    toolEnv
+	calls  []toolCall
+	items  []approvalItem
+	strict bool
+}
+
+type approvalItem struct {
+	call    toolCall
+	command string
+	risk    *commandRisk
+	err     error
+	// granted is set when an /allow grant exempts the command from the
+	// strict policy.
+	granted bool
+}
+
+type commandRiskMsg struct {
+	id   string
+	risk commandRisk
+	err  error
+}
+
+// requestApproval starts the approval of calls, classifying each session
+// tool command in the background. It returns nil when nothing needs
+// approval, and the calls run right away.
+func (m *model) requestApproval(env toolEnv, calls []toolCall) tea.Cmd {
+	policy := commandPolicy(m.cfg.CommandPolicy)
+	if policy == commandsAuto {
+		return nil
+	}
+	approval := &commandApproval{env: env, calls: calls, strict: policy == commandsStrict}
+	var cmds []tea.Cmd
+	for _, call := range calls {
+		command, ok := m.tools.sessionCommand(call)
+		if !ok {
+			continue
+		}
+		_, granted := m.tools.grantedCommand(command)
+		approval.items = append(approval.items, approvalItem{call: call, command: command, granted: granted})
+		cfg, id := m.cfg, call.ID
+		cmds = append(cmds, func() tea.Msg {
+			risk, err := classifyCommand(context.Background(), cfg, command)
+			return commandRiskMsg{id: id, risk: risk, err: err}
+		})
+	}
+	if len(approval.items) == 0 {
+		return nil
+	}
+	approval.env.risks = map[string]commandRisk{}
+	m.approval = approval
+	return tea.Batch(cmds...)
+}
+
+func (m model) handleCommandRiskMsg(msg commandRiskMsg) (tea.Model, tea.Cmd) {
+	if m.approval == nil {
+		return m, nil
+	}
+	for i := range m.approval.items {
+		if item := &m.approval.items[i]; item.call.ID == msg.id {
+			if item.err = msg.err; msg.err == nil {
+				item.risk = &msg.risk
+				m.approval.env.risks[msg.id] = msg.risk
+			}
+		}
+	}
+	return m, nil
+}
+
+// classified reports whether every command has its risk, or failed to get
+// one.
+func (a *commandApproval) classified() bool {
+	for _, item := range a.items {
+		if item.risk == nil && item.err == nil && !item.granted {
+			return false
+		}
+	}
+	return true
+}
+
+// blocked says why the strict policy refuses an item, or "".
+func (a *commandApproval) blocked(item approvalItem) string {
+	switch {
+	case !a.strict || item.granted:
+		return ""
+	case item.err != nil:
+		return "blocked: the strict command policy needs a classification"
+	case item.risk != nil && item.risk.Level == riskDestructive:
+		return "blocked: the strict command policy refuses destructive commands"
+	}
+	return ""
+}
+
+func (m model) updateApproval(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	a := m.approval
+	switch msg.String() {
+	case "y", "enter":
+		if a.strict && !a.classified() {
+			m.notice = "Waiting for the risk classification under the strict command policy"
+			return m, nil
+		}
+		declined := map[string]error{}
+		for _, item := range a.items {
+			if reason := a.blocked(item); reason != "" {
+				declined[item.call.ID] = fmt.Errorf("%s was not run: %s", item.command, strings.TrimPrefix(reason, "blocked: "))
+			}
+		}
+		m.approval = nil
+		m.notice = ""
+		return m, runApprovedTools(m.tools, a.env, a.calls, declined)
+	case "n", "esc":
+		declined := map[string]error{}
+		for _, item := range a.items {
+			declined[item.call.ID] = fmt.Errorf("the user declined to run %s; ask them how to proceed instead of retrying", item.command)
+		}
+		m.approval = nil
+		m.notice = "Declined; the agent is told the commands did not run"
+		return m, runApprovedTools(m.tools, a.env, a.calls, declined)
+	case "ctrl+c":
+		return m, tea.Quit
+	}
+	return m, nil
+}
+
+// runApprovedTools runs calls like runTools, answering the declined ones
+// with their reason instead.
+func runApprovedTools(registry *toolRegistry, env toolEnv, calls []toolCall, declined map[string]error) tea.Cmd {
+	return func() tea.Msg {
+		results := make([]toolResult, 0, len(calls))
+		for _, call := range calls {
+			if err, ok := declined[call.ID]; ok {
+				results = append(results, toolResult{call: call, err: err})
+				continue
+			}
+			results = append(results, registry.execute(context.Background(), &env, call))
+		}
+		return toolResultsMsg{results: results}
+	}
+}
+
+func riskLabel(risk string) string {
+	switch risk {
+	case riskReadOnly:
+		return diffAddStyle.Render(risk)
+	case riskMutating:
+		return diffHunkStyle.Render(risk)
+	}
+	return errorStyle.Render(risk)
+}
+
+// viewApproval shows the commands awaiting approval under the end of the
+// transcript, each with its risk and what it does.
+func (m model) viewApproval(border lipgloss.Style) string {
+	a := m.approval
+	var panel []string
+	panel = append(panel, headerStyle.Render(fmt.Sprintf("Run %d command(s)?", len(a.items))))
+	for _, item := range a.items {
+		panel = append(panel, m.fitLine("  $ "+item.command))
+		var detail string
+		switch {
+		case item.err != nil:
+			detail = errorStyle.Render("unclassified") + subtleStyle.Render(": "+item.err.Error())
+		case item.risk == nil:
+			detail = m.spinner.View() + subtleStyle.Render(" classifying…")
+		default:
+			detail = riskLabel(item.risk.Level) + subtleStyle.Render(": "+item.risk.Reason)
+		}
+		if reason := a.blocked(item); reason != "" {
+			detail += " " + errorStyle.Render("("+reason+")")
+		} else if a.strict && item.granted {
+			detail += " " + subtleStyle.Render("(allowed by /allow)")
+		}
+		panel = append(panel, m.fitLine("    "+detail))
+	}
+	panel = append(panel, subtleStyle.Render("y/Enter run • n/Esc decline, telling the agent"))
+
+	lines := strings.Split(m.viewport.View(), "\n")
+	keep := max(m.viewport.Height-len(panel)-1, 0)
+	lines = lines[max(len(lines)-keep, 0):]
+	lines = append(lines, subtleStyle.Render(strings.Repeat("─", max(m.viewport.Width, 1))))
+	lines = append(lines, panel...)
+	if len(lines) > m.viewport.Height {
+		lines = lines[len(lines)-m.viewport.Height:]
+	}
+	return border.Width(m.width).Render(strings.Join(lines, "\n"))
+}
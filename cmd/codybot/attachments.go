@@ -0,0 +1,492 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+const (
+	maxAttachmentBytes   = 10 << 20
+	maxAttachmentChars   = 24000
+	maxPendingAttachment = 8
+	logTailLines         = 200
+	logMatchLines        = 20
+	csvSampleRows        = 5
+)
+
+type attachmentKind string
+
+const (
+	attachText attachmentKind = "text"
+	attachPDF  attachmentKind = "pdf"
+	attachCSV  attachmentKind = "csv"
+	attachLog  attachmentKind = "log"
+)
+
+type attachment struct {
+	Name      string
+	Path      string
+	Kind      attachmentKind
+	MIME      string
+	Size      int64
+	Content   string
+	Truncated bool
+}
+
+type attachmentIngestor func(data []byte) (string, error)
+
+var attachmentIngestors = map[attachmentKind]attachmentIngestor{
+	attachText: ingestText,
+	attachPDF:  ingestPDF,
+	attachCSV:  ingestCSV,
+	attachLog:  ingestLog,
+}
+
+func loadAttachment(path string) (attachment, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return attachment{}, err
+	}
+	if info.IsDir() {
+		return attachment{}, fmt.Errorf("%s is a directory", path)
+	}
+	if info.Size() > maxAttachmentBytes {
+		return attachment{}, fmt.Errorf("%s is %s; attachments are limited to %s", path, formatBytes(info.Size()), formatBytes(maxAttachmentBytes))
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return attachment{}, err
+	}
+
+	kind, mimeType, err := detectAttachmentKind(path, data)
+	if err != nil {
+		return attachment{}, err
+	}
+	content, err := attachmentIngestors[kind](data)
+	if err != nil {
+		return attachment{}, fmt.Errorf("%s: %w", filepath.Base(path), err)
+	}
+
+	att := attachment{
+		Name: filepath.Base(path),
+		Path: path,
+		Kind: kind,
+		MIME: mimeType,
+		Size: info.Size(),
+	}
+	att.Content, att.Truncated = truncateRunes(content, maxAttachmentChars)
+	return att, nil
+}
+
+func detectAttachmentKind(path string, data []byte) (attachmentKind, string, error) {
+	ext := strings.ToLower(filepath.Ext(path))
+	mimeType := mime.TypeByExtension(ext)
+	if mimeType == "" {
+		mimeType = http.DetectContentType(data)
+	}
+	mediaType, _, _ := mime.ParseMediaType(mimeType)
+
+	switch {
+	case ext == ".pdf" || mediaType == "application/pdf" || bytes.HasPrefix(data, []byte("%PDF-")):
+		return attachPDF, "application/pdf", nil
+	case ext == ".csv" || ext == ".tsv" || mediaType == "text/csv":
+		return attachCSV, "text/csv", nil
+	case ext == ".log" || strings.HasSuffix(strings.ToLower(path), ".log.1"):
+		return attachLog, "text/plain", nil
+	}
+
+	if bytes.IndexByte(data, 0) >= 0 || !utf8.Valid(data) {
+		return "", mediaType, fmt.Errorf("%s looks binary (%s); only text, PDF, CSV, and log files can be attached", filepath.Base(path), mediaType)
+	}
+	if mediaType == "" {
+		mediaType = "text/plain"
+	}
+	return attachText, mediaType, nil
+}
+
+func ingestText(data []byte) (string, error) {
+	return string(data), nil
+}
+
+func ingestCSV(data []byte) (string, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	if bytes.Count(data[:min(len(data), 4096)], []byte("\t")) > bytes.Count(data[:min(len(data), 4096)], []byte(",")) {
+		reader.Comma = '\t'
+	}
+	records, err := reader.ReadAll()
+	if err != nil {
+		return "", fmt.Errorf("parse csv: %w", err)
+	}
+	if len(records) == 0 {
+		return "", errors.New("csv is empty")
+	}
+
+	header := records[0]
+	rows := records[1:]
+	var b strings.Builder
+	fmt.Fprintf(&b, "CSV summary: %d rows x %d columns\n\nColumns:\n", len(rows), len(header))
+	for col, name := range header {
+		fmt.Fprintf(&b, "- %s: %s\n", name, summarizeColumn(rows, col))
+	}
+	b.WriteString("\nFirst rows:\n")
+	b.WriteString(strings.Join(header, ", "))
+	b.WriteString("\n")
+	for _, row := range rows[:min(len(rows), csvSampleRows)] {
+		b.WriteString(strings.Join(row, ", "))
+		b.WriteString("\n")
+	}
+	return b.String(), nil
+}
+
+func summarizeColumn(rows [][]string, col int) string {
+	numeric := true
+	seen := 0
+	empty := 0
+	minVal, maxVal := 0.0, 0.0
+	distinct := map[string]struct{}{}
+	for _, row := range rows {
+		if col >= len(row) || strings.TrimSpace(row[col]) == "" {
+			empty++
+			continue
+		}
+		value := strings.TrimSpace(row[col])
+		if len(distinct) <= 1000 {
+			distinct[value] = struct{}{}
+		}
+		if numeric {
+			f, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				numeric = false
+				continue
+			}
+			if seen == 0 || f < minVal {
+				minVal = f
+			}
+			if seen == 0 || f > maxVal {
+				maxVal = f
+			}
+			seen++
+		}
+	}
+	summary := fmt.Sprintf("%d distinct", len(distinct))
+	if numeric && seen > 0 {
+		summary = fmt.Sprintf("numeric, min %g, max %g", minVal, maxVal)
+	}
+	if empty > 0 {
+		summary += fmt.Sprintf(", %d empty", empty)
+	}
+	return summary
+}
+
+var logSignal = regexp.MustCompile(`(?i)\b(fatal|panic|error|exception|warn(?:ing)?|traceback)\b`)
+
+func ingestLog(data []byte) (string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+
+	counts := map[string]int{}
+	var matches []string
+	for i, line := range lines {
+		found := logSignal.FindString(line)
+		if found == "" {
+			continue
+		}
+		level := strings.ToLower(found)
+		if strings.HasPrefix(level, "warn") {
+			level = "warn"
+		}
+		counts[level]++
+		matches = append(matches, fmt.Sprintf("%d: %s", i+1, line))
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Log summary: %d lines", len(lines))
+	for _, level := range []string{"fatal", "panic", "error", "exception", "traceback", "warn"} {
+		if counts[level] > 0 {
+			fmt.Fprintf(&b, ", %d %s", counts[level], level)
+		}
+	}
+	b.WriteString("\n")
+	if len(matches) > 0 {
+		fmt.Fprintf(&b, "\nLast %d notable lines:\n", min(len(matches), logMatchLines))
+		for _, line := range matches[max(0, len(matches)-logMatchLines):] {
+			b.WriteString(line)
+			b.WriteString("\n")
+		}
+	}
+	tail := lines[max(0, len(lines)-logTailLines):]
+	fmt.Fprintf(&b, "\nLast %d lines:\n%s\n", len(tail), strings.Join(tail, "\n"))
+	return b.String(), nil
+}
+
+var (
+	pdfStream = regexp.MustCompile(`(?s)<<(.*?)>>\s*stream\r?\n`)
+	// pdfToken splits a content stream into whole tokens: literal strings,
+	// allowing one level of unescaped nested parentheses, dictionary
+	// delimiters, hex strings, comments, array and procedure delimiters,
+	// names, and the words that are numbers or operators. Matching whole
+	// tokens keeps an operator such as Tj from matching inside another.
+	pdfToken = regexp.MustCompile(`(?s)\((?:\\.|[^\\()]|\((?:\\.|[^\\()])*\))*\)|<<|>>|<[0-9A-Fa-f\s]*>|%[^\r\n]*|[\[\]{}]|/[^\s()<>\[\]{}/%]*|[^\s()<>\[\]{}/%]+`)
+)
+
+func ingestPDF(data []byte) (string, error) {
+	if bytes.Contains(data, []byte("/Encrypt")) {
+		return "", errors.New("encrypted PDFs are not supported")
+	}
+
+	var b strings.Builder
+	for _, loc := range pdfStream.FindAllSubmatchIndex(data, -1) {
+		dict := data[loc[2]:loc[3]]
+		start := loc[1]
+		end := bytes.Index(data[start:], []byte("endstream"))
+		if end < 0 {
+			break
+		}
+		raw := data[start : start+end]
+		if bytes.Contains(dict, []byte("/FlateDecode")) {
+			zr, err := zlib.NewReader(bytes.NewReader(raw))
+			if err != nil {
+				continue
+			}
+			raw, _ = io.ReadAll(zr)
+			zr.Close()
+		} else if bytes.Contains(dict, []byte("/Filter")) {
+			continue
+		}
+		extractPDFText(&b, raw)
+	}
+
+	text := strings.TrimSpace(b.String())
+	if text == "" {
+		return "", errors.New("no extractable text (scanned or image-only PDF?)")
+	}
+	return text, nil
+}
+
+// extractPDFText writes the text a content stream's text operators show.
+// String operands are held until their operator, so strings given to other
+// operators, such as marked-content properties, are left out.
+func extractPDFText(b *strings.Builder, content []byte) {
+	var operands []string
+	newline := func() {
+		if b.Len() > 0 && !strings.HasSuffix(b.String(), "\n") {
+			b.WriteString("\n")
+		}
+	}
+	for _, token := range pdfToken.FindAll(content, -1) {
+		tok := string(token)
+		switch {
+		case tok[0] == '(':
+			operands = append(operands, pdfStringText(unescapePDFString(tok[1:len(tok)-1])))
+		case tok[0] == '<' && tok != "<<":
+			operands = append(operands, pdfStringText(decodePDFHex(tok[1:len(tok)-1])))
+		case tok == ">>" || strings.ContainsAny(tok[:1], "<[]{}/%+-.0123456789"):
+			// Delimiters, names, comments, and numbers.
+		default:
+			switch tok {
+			case "Tj", "TJ":
+				b.WriteString(strings.Join(operands, ""))
+			case "'", "\"":
+				newline()
+				b.WriteString(strings.Join(operands, ""))
+			case "T*", "Td", "TD", "ET":
+				newline()
+			}
+			operands = nil
+		}
+	}
+}
+
+// decodePDFHex decodes a hex string's digits, ignoring white space; a
+// missing final digit is 0.
+func decodePDFHex(digits string) string {
+	digits = strings.Join(strings.Fields(digits), "")
+	if len(digits)%2 == 1 {
+		digits += "0"
+	}
+	data, err := hex.DecodeString(digits)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// pdfStringText decodes a string operand: as UTF-16 after a byte order mark,
+// otherwise as UTF-8 when it is valid and Latin-1 when not. Strings with
+// control bytes are glyph IDs, as Identity-H fonts show, which cannot be
+// read without the font's CMap, so they are dropped.
+func pdfStringText(s string) string {
+	if strings.HasPrefix(s, "\xfe\xff") {
+		units := make([]uint16, 0, len(s)/2)
+		for i := 2; i+1 < len(s); i += 2 {
+			units = append(units, uint16(s[i])<<8|uint16(s[i+1]))
+		}
+		return string(utf16.Decode(units))
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < ' ' && s[i] != '\n' && s[i] != '\r' && s[i] != '\t' {
+			return ""
+		}
+	}
+	if utf8.ValidString(s) {
+		return s
+	}
+	runes := make([]rune, len(s))
+	for i := 0; i < len(s); i++ {
+		runes[i] = rune(s[i])
+	}
+	return string(runes)
+}
+
+func unescapePDFString(s string) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 >= len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case 'r', 't':
+			b.WriteByte(' ')
+		case '\n':
+			// A backslash at the end of a line continues the string.
+		case '\r':
+			if i+1 < len(s) && s[i+1] == '\n' {
+				i++
+			}
+		case '0', '1', '2', '3', '4', '5', '6', '7':
+			j := i
+			for j < len(s) && j < i+3 && s[j] >= '0' && s[j] <= '7' {
+				j++
+			}
+			code, _ := strconv.ParseUint(s[i:j], 8, 8)
+			b.WriteByte(byte(code))
+			i = j - 1
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+func truncateRunes(s string, limit int) (string, bool) {
+	if utf8.RuneCountInString(s) <= limit {
+		return s, false
+	}
+	runes := []rune(s)
+	return string(runes[:limit]), true
+}
+
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1fMB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1fKB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%dB", n)
+}
+
+func (a attachment) chip() string {
+	label := fmt.Sprintf("%s · %s · %s", a.Name, a.Kind, formatBytes(a.Size))
+	if a.Truncated {
+		label += " · truncated"
+	}
+	return chipStyle.Render(label)
+}
+
+func renderChips(attachments []attachment) string {
+	chips := make([]string, 0, len(attachments))
+	for _, att := range attachments {
+		chips = append(chips, att.chip())
+	}
+	return lipgloss.JoinHorizontal(lipgloss.Left, chips...)
+}
+
+func attachmentContext(attachments []attachment) string {
+	var b strings.Builder
+	for _, att := range attachments {
+		fmt.Fprintf(&b, "\n\nAttachment: %s (%s, %s, %s)\n```\n%s\n```", att.Name, att.Kind, att.MIME, formatBytes(att.Size), att.Content)
+		if att.Truncated {
+			fmt.Fprintf(&b, "\n(truncated to %d characters)", maxAttachmentChars)
+		}
+	}
+	return b.String()
+}
+
+func (m *model) cmdAttach(args string) tea.Cmd {
+	if args == "" {
+		m.notice = "Usage: /attach <path>"
+		return nil
+	}
+	if len(m.attachments) >= maxPendingAttachment {
+		m.notice = fmt.Sprintf("At most %d attachments per message", maxPendingAttachment)
+		return nil
+	}
+	att, err := loadAttachment(args)
+	if err != nil {
+		m.lastErr = err
+		return nil
+	}
+	m.lastErr = nil
+	m.attachments = append(m.attachments, att)
+	m.notice = fmt.Sprintf("Attached %s", att.Name)
+	*m = m.applySize(m.width, m.height)
+	return nil
+}
+
+func (m *model) cmdDetach(args string) tea.Cmd {
+	if args == "" {
+		m.attachments = nil
+		m.notice = "Cleared attachments"
+	} else {
+		kept := m.attachments[:0]
+		for _, att := range m.attachments {
+			if att.Name != args {
+				kept = append(kept, att)
+			}
+		}
+		if len(kept) == len(m.attachments) {
+			m.notice = fmt.Sprintf("No attachment named %s", args)
+			return nil
+		}
+		m.attachments = kept
+		m.notice = fmt.Sprintf("Detached %s", args)
+	}
+	*m = m.applySize(m.width, m.height)
+	return nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestExtractPDFText(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"literal", "BT /F1 12 Tf 72 712 Td (Hello, world) Tj ET", "Hello, world"},
+		{"lines", "BT (one) Tj 0 -14 Td (two) Tj T* (three) Tj ET", "one\ntwo\nthree"},
+		{"array with kerning", "BT [(Hel) -20 (lo)] TJ ET", "Hello"},
+		{"quote operators", "BT (one) Tj (two) ' 1 2 (three) \" ET", "one\ntwo\nthree"},
+		{"escapes", `BT (a \(b\) \\ c\101\n) Tj ET`, "a (b) \\ cA"},
+		{"nested parentheses", "BT (f(x) = y) Tj ET", "f(x) = y"},
+		{"line continuation", "BT (split \\\nline) Tj ET", "split line"},
+		{"hex", "BT <48656C6C6F> Tj ET", "Hello"},
+		{"hex with spaces and odd digits", "BT <48 69 4> Tj ET", "Hi@"},
+		{"hex in an array", "BT [<4869> 120 (!)] TJ ET", "Hi!"},
+		{"hex utf-16", "BT <FEFF00E9007400E9> Tj ET", "été"},
+		{"latin-1", "BT (caf\\351) Tj ET", "café"},
+		{"glyph ids dropped", "BT /F2 12 Tf <002B0048> Tj ET", ""},
+		{"operators inside words", "/Tjx BMC /ETC 1 0 0 1 0 0 cm EMC BT (x) Tj ET", "x"},
+		{"strings of other operators", "/Span <</ActualText (hidden)>> BDC BT (shown) Tj ET EMC", "shown"},
+		{"dictionary is not hex", "<</Type /Page>> BT (x) Tj ET", "x"},
+		{"comment", "% (comment) Tj\nBT (x) Tj ET", "x"},
+		{"string without operator", "BT (dangling) ET", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b strings.Builder
+			extractPDFText(&b, []byte(tt.content))
+			if got := strings.TrimSpace(b.String()); got != tt.want {
+				t.Errorf("extractPDFText(%q) = %q, want %q", tt.content, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIngestPDF(t *testing.T) {
+	content := "BT /F1 12 Tf 72 712 Td (Hello) Tj 0 -14 Td <776F726C64> Tj ET"
+	var compressed bytes.Buffer
+	zw := zlib.NewWriter(&compressed)
+	zw.Write([]byte(content))
+	zw.Close()
+	pdf := func(dict, stream string) []byte {
+		return []byte(fmt.Sprintf("%%PDF-1.4\n4 0 obj\n<< %s >>\nstream\n%s\nendstream\nendobj\n%%%%EOF\n", dict, stream))
+	}
+	tests := []struct {
+		name string
+		data []byte
+		want string
+		err  string
+	}{
+		{name: "plain", data: pdf(fmt.Sprintf("/Length %d", len(content)), content), want: "Hello\nworld"},
+		{name: "flate", data: pdf("/Filter /FlateDecode", compressed.String()), want: "Hello\nworld"},
+		{name: "other filter", data: pdf("/Filter /DCTDecode", content), err: "no extractable text"},
+		{name: "encrypted", data: []byte("%PDF-1.4\ntrailer << /Encrypt 5 0 R >>"), err: "encrypted"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ingestPDF(tt.data)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("ingestPDF error = %v, want one mentioning %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("ingestPDF = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
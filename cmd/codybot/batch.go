@@ -0,0 +1,55 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+// streamBatchInterval is how often streamed tokens reach the Update loop.
+// Fast providers send hundreds of deltas a second, and re-rendering a long
+// transcript for each one makes the UI lag behind the stream.
+const streamBatchInterval = 30 * time.Millisecond
+
+// batchStream merges consecutive token messages from in into one message per
+// interval. Anything else (notices, the final message, errors) flushes the
+// pending text first so ordering is kept. The first token is passed on at
+// once so time to first token stays accurate.
+func batchStream(in <-chan streamMsg, interval time.Duration) <-chan streamMsg {
+	out := make(chan streamMsg)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		var pending strings.Builder
+		chunks := 0
+		first := true
+		flush := func() {
+			if chunks > 0 {
+				out <- streamMsg{token: pending.String(), chunks: chunks}
+				pending.Reset()
+				chunks = 0
+			}
+		}
+		for {
+			select {
+			case msg := <-in:
+				if msg.token != "" && msg.info == "" && !msg.done && msg.err == nil {
+					pending.WriteString(msg.token)
+					chunks++
+					if first {
+						first = false
+						flush()
+					}
+					continue
+				}
+				flush()
+				out <- msg
+				if msg.done || msg.err != nil {
+					return
+				}
+			case <-ticker.C:
+				flush()
+			}
+		}
+	}()
+	return out
+}
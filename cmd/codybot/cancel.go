@@ -0,0 +1,72 @@
+package main
+
+import (
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+const continuePrompt = `Your previous answer was cut off. Continue exactly where it stopped, without repeating anything you already wrote and without a preamble.`
+
+// cancelTurn stops the running turn. The stream's context is cancelled, and
+// the partial answer is kept once its error arrives, so every token that was
+// already received is in it. Tools that are running finish first, but no
+// further round starts.
+func (m *model) cancelTurn() {
+	m.cancelled = true
+	m.count(usageCancel)
+	if m.cancelStream != nil {
+		m.cancelStream()
+	}
+	m.notice = "Stopping…"
+}
+
+// stopTurn ends a cancelled turn, saving whatever of it reached the history.
+func (m *model) stopTurn(notice string) {
+	m.streaming = false
+	m.journal.closeTurn()
+	m.finishPreview()
+	m.jsonTurn = nil
+	m.promptQueue = nil
+	m.persistSession()
+	m.notice = notice
+}
+
+// keepPartial ends a cancelled stream, keeping what the model wrote so far in
+// the history, marked as truncated, for /continue to resume.
+func (m model) keepPartial() (tea.Model, tea.Cmd) {
+	m.stats.finish()
+	m.currentResponseMutex.Lock()
+	response := m.currentResponse.String()
+	m.currentResponseMutex.Unlock()
+	if strings.TrimSpace(response) == "" {
+		m.transcript.dropEmpty(blockAssistant)
+		m.control.publish(m.sessionID, "message.end", m.controlMessage, messageEndData{Role: "assistant", FinishReason: "cancelled"})
+		m.stopTurn("Stopped before the model answered")
+		return m, m.steerAfterStop()
+	}
+	m.usage.output += estimateTokens(response)
+	meta := m.answerMeta(response, "")
+	meta.Truncated = true
+	if last := m.transcript.last(); last != nil && last.kind == blockAssistant {
+		last.setMeta(meta)
+	}
+	m.history = append(m.history, message{Role: "assistant", Content: response, Meta: meta})
+	m.control.publish(m.sessionID, "message.end", m.controlMessage, messageEndData{Role: "assistant", Content: response, FinishReason: "cancelled"})
+	m.appendNote("Stopped. The partial answer is kept in the conversation; /continue resumes it.")
+	m.stopTurn("Stopped • /continue resumes the answer")
+	return m, m.steerAfterStop()
+}
+
+func (m *model) cmdContinue(string) tea.Cmd {
+	if m.streaming {
+		m.notice = "Wait for the current response to finish"
+		return nil
+	}
+	last := m.history[len(m.history)-1]
+	if last.Role != "assistant" || last.Meta == nil || !last.Meta.Truncated {
+		m.notice = "Nothing to continue; /continue resumes an answer stopped with Esc"
+		return nil
+	}
+	return m.send("/continue", continuePrompt)
+}
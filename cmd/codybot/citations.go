@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+type citations struct {
+	sources []string
+}
+
+func (c *citations) add(source string) int {
+	for i, existing := range c.sources {
+		if existing == source {
+			return i + 1
+		}
+	}
+	c.sources = append(c.sources, source)
+	return len(c.sources)
+}
+
+var citationMarker = regexp.MustCompile(`\[(\d+)\]`)
+
+// footnotes lists the sources the answer cited with [n] markers. When the
+// model used tools but cited nothing, every consulted source is listed so the
+// answer can still be traced back to what the agent read.
+func (c citations) footnotes(response string) string {
+	if len(c.sources) == 0 {
+		return ""
+	}
+	cited := map[int]bool{}
+	for _, match := range citationMarker.FindAllStringSubmatch(response, -1) {
+		var n int
+		fmt.Sscanf(match[1], "%d", &n)
+		if n >= 1 && n <= len(c.sources) {
+			cited[n] = true
+		}
+	}
+
+	title := "Sources:"
+	if len(cited) == 0 {
+		title = "Sources consulted:"
+	}
+	var b strings.Builder
+	b.WriteString(title)
+	for i, source := range c.sources {
+		if len(cited) > 0 && !cited[i+1] {
+			continue
+		}
+		fmt.Fprintf(&b, "\n[%d] %s", i+1, source)
+	}
+	return b.String()
+}
+
+func readFileSource(raw json.RawMessage, output string) string {
+	var args struct {
+		Path      string `json:"path"`
+		StartLine int    `json:"start_line"`
+	}
+	if json.Unmarshal(raw, &args) != nil || args.Path == "" {
+		return ""
+	}
+	start := max(args.StartLine, 1)
+	end := start + strings.Count(strings.TrimSuffix(output, "\n"), "\n")
+	return fmt.Sprintf("%s#L%d-L%d", args.Path, start, end)
+}
+
+func listDirSource(raw json.RawMessage, _ string) string {
+	var args struct {
+		Path string `json:"path"`
+	}
+	if json.Unmarshal(raw, &args) != nil {
+		return ""
+	}
+	if args.Path == "" {
+		args.Path = "."
+	}
+	return strings.TrimSuffix(args.Path, "/") + "/"
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// secretFlags are masked by codybot config.
+var secretFlags = map[string]bool{"api-key": true, "fallback-api-key": true, "transcribe-api-key": true}
+
+// writeUsage is codybot's top-level help: the subcommands, then the flags of
+// the TUI, which is what runs without one.
+func writeUsage(w io.Writer, fs *flag.FlagSet) {
+	fmt.Fprintln(w, "usage: codybot [chat] [flags]")
+	fmt.Fprintln(w, "       codybot <command> [args] [flags]")
+	fmt.Fprintln(w, "\nCommands:")
+	for _, sub := range subcommands() {
+		if sub.help != "" {
+			fmt.Fprintf(w, "  %-13s %s\n", sub.name, sub.help)
+		}
+	}
+	fmt.Fprintln(w, "\nRun codybot help <command> for a command's flags.")
+	fmt.Fprintln(w, "\nFlags:")
+	fs.PrintDefaults()
+}
+
+func runHelpCommand(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "--help" {
+		return runChatCommand([]string{"-h"}, stdout, stdout)
+	}
+	for _, sub := range subcommands() {
+		if sub.name == args[0] && sub.help != "" {
+			// Subcommands print their usage to stderr when asked for -h; help
+			// was asked for, so it goes to stdout.
+			return sub.run(append(append([]string(nil), args[1:]...), "-h"), stdout, stdout)
+		}
+	}
+	fmt.Fprintf(stderr, "codybot help: unknown command %q\n", args[0])
+	return 2
+}
+
+// configSetting is one flag as codybot config reports it.
+type configSetting struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+	// Source is "flag", "environment", or "default".
+	Source string `json:"source"`
+	Usage  string `json:"usage"`
+}
+
+// runConfigCommand prints every setting the given flags and environment
+// resolve to, and which of the two set it, so a surprising endpoint or model
+// can be traced without reading the environment by hand.
+func runConfigCommand(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("config", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var cfg config
+	registerTUIFlags(fs, &cfg)
+	asJSON := fs.Bool("json", false, "Print the settings as JSON")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintln(stderr, "usage: codybot config [--json] [flags]")
+		return 2
+	}
+
+	// The same flags registered with the environment hidden hold the
+	// built-in defaults.
+	getenv = func(string) string { return "" }
+	defaults := flag.NewFlagSet("defaults", flag.ContinueOnError)
+	registerTUIFlags(defaults, &config{})
+	getenv = os.Getenv
+
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	var settings []configSetting
+	fs.VisitAll(func(f *flag.Flag) {
+		if f.Name == "json" || f.Name == "o" {
+			return
+		}
+		setting := configSetting{Name: f.Name, Value: f.Value.String(), Source: "default", Usage: f.Usage}
+		switch {
+		case set[f.Name]:
+			setting.Source = "flag"
+		case setting.Value != defaults.Lookup(f.Name).Value.String():
+			setting.Source = "environment"
+		}
+		if secretFlags[f.Name] && setting.Value != "" {
+			setting.Value = maskKey(setting.Value)
+		}
+		settings = append(settings, setting)
+	})
+
+	if *asJSON {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(settings); err != nil {
+			fmt.Fprintf(stderr, "codybot config: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+	fmt.Fprintf(stdout, "%-32s %-12s %s\n", "NAME", "SOURCE", "VALUE")
+	for _, s := range settings {
+		fmt.Fprintf(stdout, "%-32s %-12s %s\n", s.Name, s.Source, s.Value)
+	}
+	return 0
+}
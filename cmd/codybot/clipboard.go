@@ -0,0 +1,20 @@
+package main
+
+import (
+	"runtime"
+	"strings"
+
+	"github.com/atotto/clipboard"
+	"github.com/muesli/termenv"
+)
+
+// copyText puts text on the clipboard with OSC 52, which works over SSH and
+// inside tmux. On Windows it also uses the native clipboard, since the
+// classic console host ignores OSC 52; Windows line endings are used there so
+// the text pastes intact into editors like Notepad.
+func copyText(text string) {
+	termenv.Copy(text)
+	if runtime.GOOS == "windows" {
+		clipboard.WriteAll(strings.ReplaceAll(strings.ReplaceAll(text, "\r\n", "\n"), "\n", "\r\n"))
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// codeBlock is one fenced block of an answer, numbered from 1 in the order
+// it appears.
+type codeBlock struct {
+	language string
+	// path is the file the fence info names, if any, and the default
+	// destination of /saveblock.
+	path string
+	text string
+}
+
+// codeBlocks returns the fenced blocks in text. An unclosed fence, as in a
+// stopped answer, runs to the end.
+func codeBlocks(text string) []codeBlock {
+	var blocks []codeBlock
+	lines := strings.Split(text, "\n")
+	for i := 0; i < len(lines); i++ {
+		match := fenceOpen.FindStringSubmatch(lines[i])
+		if match == nil {
+			continue
+		}
+		marker := match[1]
+		var body []string
+		for i++; i < len(lines); i++ {
+			if trimmed := strings.TrimSpace(lines[i]); strings.HasPrefix(trimmed, marker) && strings.Trim(trimmed, marker[:1]) == "" {
+				break
+			}
+			body = append(body, lines[i])
+		}
+		language, path := fenceInfo(strings.TrimSpace(match[2]))
+		if path == "" && len(body) > 0 {
+			if path = labelledPath(commentPathLabel, body[0]); path != "" {
+				body = body[1:]
+			}
+		}
+		blocks = append(blocks, codeBlock{language: language, path: path, text: strings.Join(body, "\n")})
+	}
+	return blocks
+}
+
+func (b codeBlock) summary() string {
+	label := b.language
+	if label == "" {
+		label = "text"
+	}
+	if b.path != "" {
+		label += " " + b.path
+	}
+	return fmt.Sprintf("%s, %d lines", label, strings.Count(b.text, "\n")+1)
+}
+
+// lastAnswerBlocks returns the code blocks of the latest answer with any text.
+func (m *model) lastAnswerBlocks() ([]codeBlock, error) {
+	for i := len(m.history) - 1; i > 0; i-- {
+		if msg := m.history[i]; msg.Role == "assistant" && strings.TrimSpace(msg.Content) != "" {
+			if blocks := codeBlocks(msg.Content); len(blocks) > 0 {
+				return blocks, nil
+			}
+			return nil, errors.New("the last answer has no code blocks")
+		}
+	}
+	return nil, errors.New("no answer yet")
+}
+
+// codeBlockArg picks block n of the last answer. Without n it picks the only
+// block, or lists them all so the user can choose.
+func (m *model) codeBlockArg(arg, usage string) (codeBlock, int, bool) {
+	blocks, err := m.lastAnswerBlocks()
+	if err != nil {
+		m.lastErr = err
+		return codeBlock{}, 0, false
+	}
+	if arg == "" {
+		if len(blocks) == 1 {
+			return blocks[0], 1, true
+		}
+		var b strings.Builder
+		b.WriteString("Code blocks in the last answer:\n")
+		for i, block := range blocks {
+			fmt.Fprintf(&b, "  %d. %s\n", i+1, block.summary())
+		}
+		m.appendNote(b.String())
+		m.notice = "Usage: " + usage
+		return codeBlock{}, 0, false
+	}
+	n, err := strconv.Atoi(strings.TrimPrefix(arg, "#"))
+	if err != nil || n < 1 || n > len(blocks) {
+		m.lastErr = fmt.Errorf("expected a block number from 1 to %d, got %q", len(blocks), arg)
+		return codeBlock{}, 0, false
+	}
+	return blocks[n-1], n, true
+}
+
+func (m *model) cmdCopyBlock(args string) tea.Cmd {
+	block, n, ok := m.codeBlockArg(args, "/copyblock <n>")
+	if !ok {
+		return nil
+	}
+	m.lastErr = nil
+	copyText(block.text + "\n")
+	m.notice = fmt.Sprintf("Copied code block %d (%s)", n, block.summary())
+	return nil
+}
+
+// cmdSaveBlock writes a code block of the last answer to a file through the
+// edit journal, so /undo reverts it like an agent edit.
+func (m *model) cmdSaveBlock(args string) tea.Cmd {
+	if m.streaming {
+		m.notice = "Wait for the current response to finish before saving a block"
+		return nil
+	}
+	if m.cfg.ReadOnly {
+		m.notice = "--read-only: /saveblock cannot write files"
+		return nil
+	}
+	arg, path, _ := strings.Cut(args, " ")
+	block, n, ok := m.codeBlockArg(arg, "/saveblock <n> [path]")
+	if !ok {
+		return nil
+	}
+	path = strings.TrimSpace(path)
+	if path == "" {
+		path = block.path
+	}
+	if path == "" {
+		m.notice = fmt.Sprintf("Code block %d names no file; give one: /saveblock %d <path>", n, n)
+		return nil
+	}
+	rel, err := resolveWritablePath(path)
+	if err != nil {
+		m.lastErr = err
+		return nil
+	}
+	if err := m.journal.writeFile(m.turn, m.turnPrompt, rel, []byte(block.text+"\n")); err != nil {
+		m.lastErr = err
+		return nil
+	}
+	m.journal.closeTurn()
+	m.lastErr = nil
+	m.notice = fmt.Sprintf("Saved code block %d to %s • /undo reverts it", n, displayPath(rel))
+	return m.filesChanged([]string{rel})
+}
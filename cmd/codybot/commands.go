@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+type slashCommand struct {
+	name  string
+	usage string
+	help  string
+	run   func(m *model, args string) tea.Cmd
+}
+
+type noticeMsg struct {
+	text string
+	err  error
+}
+
+func slashCommands() []slashCommand {
+	commands := []slashCommand{
+		{name: "help", usage: "/help", help: "List available commands", run: (*model).cmdHelp},
+		{name: "attach", usage: "/attach <path>", help: "Attach a text, PDF, CSV, or log file to the next message", run: (*model).cmdAttach},
+		{name: "auth", usage: "/auth refresh", help: "Reload the API key or refresh the OAuth token without restarting", run: (*model).cmdAuth},
+		{name: "detach", usage: "/detach [name]", help: "Remove a pending attachment (all when no name is given)", run: (*model).cmdDetach},
+		{name: "trash", usage: "/trash [all]", help: "List the files the agent deleted this session (all: every session)", run: (*model).cmdTrash},
+		{name: "restore-file", usage: "/restore-file <#|path>", help: "Move a file the agent deleted back out of the trash", run: (*model).cmdRestoreFile},
+		{name: "undo", usage: "/undo", help: "Revert the file changes from the latest agent checkpoint", run: (*model).cmdUndo},
+		{name: "redo", usage: "/redo", help: "Re-apply the most recently undone checkpoint", run: (*model).cmdRedo},
+		{name: "commit", usage: "/commit [guidance]", help: "Draft a conventional commit message for the staged changes, then edit and commit it", run: (*model).cmdCommit},
+		{name: "explain", usage: "/explain", help: "Ask the model to diagnose the latest failed tool call and propose a fix (Ctrl+X)", run: (*model).cmdExplain},
+		{name: "compact", usage: "/compact [turns]", help: "Replace all but the last turns (default 2) with a model-written summary", run: (*model).cmdCompact},
+		{name: "copyblock", usage: "/copyblock [n]", help: "Copy code block n of the last answer to the clipboard; without n, list the blocks", run: (*model).cmdCopyBlock},
+		{name: "saveblock", usage: "/saveblock [n] [path]", help: "Write code block n of the last answer to path (default: the file its fence names); /undo reverts it", run: (*model).cmdSaveBlock},
+		{name: "compare", usage: "/compare [--with <model>] <prompt>", help: "Stream a prompt to --model and --compare-model side by side, then keep one answer", run: (*model).cmdCompare},
+		{name: "copy", usage: "/copy [n]", help: "Copy message #n (default: the latest answer) to the clipboard", run: (*model).cmdCopy},
+		{name: "dashboard", usage: "/dashboard", help: "Show sessions, spend, most edited files, and test pass rate for this repo", run: (*model).cmdDashboard},
+		{name: "edit", usage: "/edit [n]", help: "Edit one of your messages (default: the latest) and resend it, dropping what came after", run: (*model).cmdEdit},
+		{name: "editor", usage: "/editor [on|off]", help: "Show files your editor has open, or toggle including them in context", run: (*model).cmdEditor},
+		{name: "fold", usage: "/fold [n|all]", help: "Collapse message #n (default: the latest answer) to one line", run: (*model).cmdFold},
+		{name: "find", usage: "/find <text>", help: "Search the transcript (Ctrl+F); n/N jump between matches", run: (*model).cmdFind},
+		{name: "profile", usage: "/profile [name|none]", help: "List profiles or switch persona, model, and temperature", run: (*model).cmdProfile},
+		{name: "prompt", usage: "/prompt [list|save <name> [text]|use <name> [var=value ...]|rm <name>]", help: "Save and reuse prompts with {{file}}, {{selection}}, and other placeholders", run: (*model).cmdPrompt},
+		{name: "pull", usage: "/pull [model]", help: "Download a model through Ollama and show progress (ollama provider)", run: (*model).cmdPull},
+		{name: "keep-alive", usage: "/keep-alive <duration>", help: "Set how long Ollama keeps the model loaded; 0 unloads it (ollama provider)", run: (*model).cmdKeepAlive},
+		{name: "leftovers", usage: "/leftovers [show|diff|commit|revert|keep]", help: "Review, commit, or revert the uncommitted edits an earlier session left behind", run: (*model).cmdLeftovers},
+		{name: "recover", usage: "/recover [show|complete|revert|keep]", help: "Resolve a change left half-applied by a crash or interruption", run: (*model).cmdRecover},
+		{name: "refactor-preview", usage: "/refactor-preview <description>", help: "Have the agent stage a repo-wide change as a reviewable patch series, applied only on a", run: (*model).cmdRefactorPreview},
+		{name: "reroll", usage: "/reroll", help: "Discard the latest answer and ask again", run: (*model).cmdReroll},
+		{name: "unfold", usage: "/unfold [n|all]", help: "Expand a folded message", run: (*model).cmdUnfold},
+		{name: "sessions", usage: "/sessions [list|search <text>|show <id>]", help: "Browse and search saved sessions; works while the model is unreachable", run: (*model).cmdSessions},
+		{name: "export", usage: "/export [#a..#b] [path|dir]", help: "Save this conversation, or messages #a through #b, as markdown, named after the session title by default", run: (*model).cmdExport},
+		{name: "export-script", usage: "/export-script <path>", help: "Write the session's applied edits and commands as a replayable script (.sh) or patch bundle (.patch)", run: (*model).cmdExportScript},
+		{name: "meta", usage: "/meta [on|off]", help: "Show each message's time, model, latency, and token counts in the transcript", run: (*model).cmdMeta},
+		{name: "pane", usage: "/pane diff [#]|file <path>|tests", help: "Open a checkpoint's diff, a file, or the last test output in a tmux or zellij pane", run: (*model).cmdPane},
+		{name: "remember", usage: "/remember [--global] [fact]", help: "Keep a fact for future sessions' system prompt (--global: every project); without one, list them", run: (*model).cmdRemember},
+		{name: "hooks", usage: "/hooks [approve]", help: "List the hooks that run around each turn, or approve new or changed project hooks", run: (*model).cmdHooks},
+		{name: "pin", usage: "/pin <path>", help: "Keep a file's current content in every request, with a diff when it changes", run: (*model).cmdPin},
+		{name: "unpin", usage: "/unpin <path>|all", help: "Stop sending a pinned file", run: (*model).cmdUnpin},
+		{name: "pins", usage: "/pins", help: "List the pinned files and the tokens they add to each request", run: (*model).cmdPins},
+		{name: "allow", usage: "/allow [<tool|command> [--for 10m] | revoke <tool|command|all>]", help: "Grant a tool --read-only or --offline withholds, or a command strict blocks, for a limited time, with an audit log", run: (*model).cmdAllow},
+		{name: "apply", usage: "/apply", help: "Preview and apply the diffs and fenced file blocks in the last answer (Ctrl+Y)", run: (*model).cmdApply},
+		{name: "web", usage: "/web", help: "Copy the URL of the --web live view", run: (*model).cmdWeb},
+		{name: "fork", usage: "/fork [open]", help: "Copy the conversation into a new session to try another approach; open starts it in a new tmux window or zellij pane", run: (*model).cmdFork},
+		{name: "tab", usage: "/tab [new|next|prev|close|n]", help: "List the chat tabs, open one (Ctrl+T), switch to one (Ctrl+Tab), or close this one", run: (*model).cmdTab},
+		{name: "tasks", usage: "/tasks [<id>|cancel <id>]", help: "List background tasks, show one's output, or cancel one", run: (*model).cmdTasks},
+		{name: "stats", usage: "/stats", help: "Show each tool's calls, errors, and wall time this session", run: (*model).cmdStats},
+		{name: "system", usage: "/system [reset]", help: "Edit the system prompt for this session, optionally saving it to agents.md; reset restores it", run: (*model).cmdSystem},
+		{name: "ids", usage: "/ids [on|off]", help: "Show or hide the #n message numbers that commands take", run: (*model).cmdIDs},
+		{name: "quote", usage: "/quote [n]", help: "Quote message #n (default: the latest answer) in the input to reply to it", run: (*model).cmdQuote},
+		{name: "rewind-to", usage: "/rewind-to <n>", help: "Drop every message after message #n's turn; file edits are kept", run: (*model).cmdRewindTo},
+		{name: "context", usage: "/context", help: "List the AGENTS.md files in the system prompt, in the order they were merged", run: (*model).cmdContext},
+		{name: "voice", usage: "/voice", help: "Start or stop push-to-talk voice input (Ctrl+R); the transcription is added to the prompt", run: (*model).cmdVoice},
+		{name: "worktree", usage: "/worktree [list|new <branch>|open <branch>|merge <branch>|remove <branch>]", help: "Run another agent task on its own branch in a git worktree, in a new tmux window or zellij pane, and merge it back", run: (*model).cmdWorktree},
+		{name: "tips", usage: "/tips [on|off]", help: "List the usage tips, or turn them on or off for this session", run: (*model).cmdTips},
+		{name: "continue", usage: "/continue", help: "Resume an answer stopped with Esc from where it stopped", run: (*model).cmdContinue},
+		{name: "json", usage: "/json <schema.json|{schema}> <prompt>|copy", help: "Ask for an answer that matches a JSON Schema, shown pretty-printed and validated", run: (*model).cmdJSON},
+		{name: "title", usage: "/title [text]", help: "Show or rename the session's title, used by /sessions and /export", run: (*model).cmdTitle},
+		{name: "select", usage: "/select", help: "Move a cursor over the transcript (Ctrl+S); v selects, y copies", run: (*model).cmdSelect},
+		{name: "theme", usage: "/theme [name]", help: "Switch the color theme: dark, light, solarized, auto, or a theme file", run: (*model).cmdTheme},
+		{name: "tee", usage: "/tee [-a] <path>|off", help: "Mirror streamed answers into a file as they arrive (-a appends)", run: (*model).cmdTee},
+		{name: "tool", usage: "/tool [list|add <name> -- <cmd>|rm <name>|export <path>]", help: "Add a shell command as a tool for this session; {arg} placeholders become parameters. export documents every tool", run: (*model).cmdTool},
+		{name: "timeline", usage: "/timeline", help: "Browse and restore file checkpoints", run: (*model).cmdTimeline},
+	}
+	sort.Slice(commands, func(i, j int) bool { return commands[i].name < commands[j].name })
+	return commands
+}
+
+func findSlashCommand(name string) (slashCommand, bool) {
+	for _, cmd := range slashCommands() {
+		if cmd.name == name {
+			return cmd, true
+		}
+	}
+	return slashCommand{}, false
+}
+
+func (m *model) runSlashCommand(text string) tea.Cmd {
+	name, args, _ := strings.Cut(strings.TrimPrefix(text, "/"), " ")
+	cmd, ok := findSlashCommand(strings.ToLower(name))
+	if !ok {
+		m.notice = fmt.Sprintf("Unknown command /%s (try /help)", name)
+		return nil
+	}
+	m.notice = ""
+	return cmd.run(m, strings.TrimSpace(args))
+}
+
+func (m *model) cmdHelp(string) tea.Cmd {
+	var b strings.Builder
+	b.WriteString("Commands:\n")
+	for _, cmd := range slashCommands() {
+		fmt.Fprintf(&b, "  %-28s %s\n", cmd.usage, cmd.help)
+	}
+	m.appendNote(b.String())
+	return nil
+}
+
+func (m *model) appendNote(text string) {
+	m.addBlock(blockNote, strings.TrimRight(text, "\n"))
+}
+
+func (m model) handleNoticeMsg(msg noticeMsg) (tea.Model, tea.Cmd) {
+	if msg.err != nil {
+		m.lastErr = msg.err
+		return m, nil
+	}
+	m.lastErr = nil
+	m.notice = msg.text
+	return m, nil
+}
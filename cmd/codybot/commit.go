@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+const (
+	maxCommitDiff    = 30000
+	maxCommitPrompts = 5
+)
+
+const commitPrompt = `Write a git commit message for the staged diff below, in the Conventional Commits format: a subject line "type(scope): summary" of at most 72 characters, where type is one of feat, fix, refactor, perf, test, docs, build, ci, style, or chore and the scope is optional; then, if the change is not obvious from the subject, a blank line and a body wrapped at 72 columns that explains what changed and why. Use the imperative mood. Reply with the message only, without code fences or commentary.`
+
+// commitFlow tracks /commit between drafting the message and running git:
+// while reviewing, the draft sits in the input for editing.
+type commitFlow struct {
+	drafting  bool
+	reviewing bool
+}
+
+type commitDraftMsg struct {
+	draft string
+	stat  string
+	// local is set when the model was unreachable and the user writes the
+	// message instead.
+	local bool
+	err   error
+}
+
+type commitDoneMsg struct {
+	output string
+	err    error
+}
+
+// runGit runs git in the working directory, feeding it stdin when given, and
+// returns its combined output.
+func runGit(stdin string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	if stdin != "" {
+		cmd.Stdin = strings.NewReader(stdin)
+	}
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	err := cmd.Run()
+	output := strings.TrimSpace(out.String())
+	if err != nil && output != "" {
+		err = fmt.Errorf("%w: %s", err, lastLine(output))
+	}
+	return output, err
+}
+
+func lastLine(s string) string {
+	s = strings.TrimSpace(s)
+	return s[strings.LastIndexByte(s, '\n')+1:]
+}
+
+// recentPrompts lists the latest user requests, so the message can say why
+// the change was made and not only what it is.
+func recentPrompts(history []message) []string {
+	var prompts []string
+	for i := len(history) - 1; i >= 0 && len(prompts) < maxCommitPrompts; i-- {
+		if history[i].Role != "user" {
+			continue
+		}
+		prompt, _, _ := strings.Cut(strings.TrimSpace(history[i].Content), "\n")
+		prompt, _ = truncateRunes(prompt, 200)
+		if prompt != "" {
+			prompts = append([]string{prompt}, prompts...)
+		}
+	}
+	return prompts
+}
+
+// cleanCommitMessage drops the code fence models tend to wrap the message
+// in despite being asked not to.
+func cleanCommitMessage(draft string) string {
+	draft = strings.TrimSpace(draft)
+	if strings.HasPrefix(draft, "```") {
+		draft = strings.TrimPrefix(draft[strings.IndexByte(draft+"\n", '\n'):], "\n")
+		draft = strings.TrimSuffix(strings.TrimSpace(draft), "```")
+	}
+	return strings.TrimSpace(draft)
+}
+
+// cmdCommit has the model draft a message for the staged changes and puts it
+// in the input for editing; Enter then commits with it.
+func (m *model) cmdCommit(args string) tea.Cmd {
+	if m.commit.drafting || m.commit.reviewing {
+		m.notice = "A commit message is already being drafted"
+		return nil
+	}
+	diff, err := runGit("", "diff", "--cached", "--no-color", "--no-ext-diff")
+	if err != nil {
+		m.lastErr = fmt.Errorf("commit: %w", err)
+		return nil
+	}
+	if diff == "" {
+		m.notice = "Nothing staged • git add the changes to commit first"
+		return nil
+	}
+	stat, _ := runGit("", "diff", "--cached", "--stat", "--no-color")
+	diff, truncated := truncateRunes(diff, maxCommitDiff)
+	var b strings.Builder
+	if prompts := recentPrompts(m.history); len(prompts) > 0 {
+		b.WriteString("The changes were made for these requests:\n")
+		for _, p := range prompts {
+			b.WriteString("- " + p + "\n")
+		}
+		b.WriteString("\n")
+	}
+	if args != "" {
+		b.WriteString("Guidance for the message: " + args + "\n\n")
+	}
+	if truncated {
+		b.WriteString("The diff is cut short; the full change touches:\n" + stat + "\n\n")
+	}
+	b.WriteString("Staged diff:\n" + diff)
+	request := []message{{Role: "system", Content: commitPrompt}, {Role: "user", Content: b.String()}}
+	m.commit.drafting = true
+	m.lastErr = nil
+	m.notice = "Drafting a commit message…"
+	cfg := m.cfg
+	return func() tea.Msg {
+		_, draft, err := runAgentLoop(context.Background(), cfg, nil, nil, request, nil)
+		if endpointUnreachable(err) {
+			return commitDraftMsg{stat: stat, local: true}
+		}
+		draft = cleanCommitMessage(draft)
+		if err == nil && draft == "" {
+			err = errors.New("the model returned an empty message")
+		}
+		return commitDraftMsg{draft: draft, stat: stat, err: err}
+	}
+}
+
+func (m model) handleCommitDraftMsg(msg commitDraftMsg) (tea.Model, tea.Cmd) {
+	m.commit.drafting = false
+	if msg.err != nil {
+		m.lastErr = fmt.Errorf("commit: %w", msg.err)
+		return m, nil
+	}
+	m.commit.reviewing = true
+	m.input.SetValue(msg.draft)
+	if msg.local {
+		m.markEndpointDown()
+		m.appendNote("Staged for commit:\n" + msg.stat)
+		m.notice = "The model is unreachable; type the commit message • Enter commits • Esc cancels"
+		return m, nil
+	}
+	m.appendNote("Commit message draft for the staged changes:\n" + msg.stat + "\n\n" + msg.draft)
+	m.notice = "Edit the message • Enter commits • Ctrl+E opens it in git's editor • Esc cancels"
+	return m, nil
+}
+
+// updateCommitKeys handles the keys that act on a draft under review; the
+// rest edit it in the input.
+func (m *model) updateCommitKeys(msg tea.KeyMsg) (bool, tea.Cmd) {
+	switch msg.String() {
+	case "esc":
+		m.commit.reviewing = false
+		m.input.Reset()
+		m.notice = "Commit cancelled; the changes stay staged"
+		return true, nil
+	case "enter":
+		text := strings.TrimSpace(m.input.Value())
+		if text == "" {
+			m.notice = "The commit message is empty • Esc cancels"
+			return true, nil
+		}
+		m.commit.reviewing = false
+		m.input.Reset()
+		m.notice = "Committing…"
+		return true, func() tea.Msg {
+			output, err := runGit(text+"\n", "commit", "--file=-")
+			return commitDoneMsg{output: output, err: err}
+		}
+	case "ctrl+e":
+		tmp, err := os.CreateTemp("", "codybot-commit-*.txt")
+		if err != nil {
+			m.lastErr = err
+			return true, nil
+		}
+		_, err = tmp.WriteString(strings.TrimSpace(m.input.Value()) + "\n")
+		tmp.Close()
+		if err != nil {
+			os.Remove(tmp.Name())
+			m.lastErr = err
+			return true, nil
+		}
+		m.commit.reviewing = false
+		m.input.Reset()
+		// git opens its configured editor on the draft and commits when it
+		// is saved; clearing the message aborts.
+		cmd := exec.Command("git", "commit", "--edit", "--file="+tmp.Name())
+		return true, tea.ExecProcess(cmd, func(err error) tea.Msg {
+			os.Remove(tmp.Name())
+			return commitDoneMsg{err: err}
+		})
+	}
+	return false, nil
+}
+
+func (m model) handleCommitDoneMsg(msg commitDoneMsg) (tea.Model, tea.Cmd) {
+	if msg.err != nil {
+		m.lastErr = fmt.Errorf("git commit: %w", msg.err)
+		return m, nil
+	}
+	m.lastErr = nil
+	summary, err := runGit("", "log", "-1", "--format=%h %s")
+	if err != nil {
+		summary = lastLine(msg.output)
+	}
+	m.notice = "Committed " + summary
+	return m, nil
+}
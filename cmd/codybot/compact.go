@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+const defaultCompactKeep = 2
+
+const compactPrompt = `Summarize the conversation so far so it can replace it. Keep the task and its constraints, decisions made and why, the current plan and what is done, files changed, and open questions. Leave out pleasantries and superseded ideas. Write it as notes to yourself, not as a reply to the user.`
+
+type compactMsg struct {
+	summary string
+	// keepFrom is the first history index kept verbatim, and length the
+	// history length when compaction started.
+	keepFrom int
+	length   int
+	// local is set when the model was unreachable and the summary was
+	// built from the history instead.
+	local bool
+	err   error
+}
+
+// cmdCompact replaces everything but the last n turns (default 2) with a
+// summary written by the model. It is the manual counterpart to the
+// automatic eviction in fitContext, and unlike it, frees the context for
+// good.
+func (m *model) cmdCompact(args string) tea.Cmd {
+	if m.streaming || m.compacting {
+		m.notice = "Wait for the current response to finish before compacting"
+		return nil
+	}
+	keep := defaultCompactKeep
+	if args != "" {
+		n, err := strconv.Atoi(args)
+		if err != nil || n < 0 {
+			m.notice = "Usage: /compact [turns to keep]"
+			return nil
+		}
+		keep = n
+	}
+	keepFrom := len(m.history)
+	for i := len(m.history) - 1; i > 0 && keep > 0; i-- {
+		if m.history[i].Role == "user" {
+			keepFrom = i
+			keep--
+		}
+	}
+	if keepFrom <= 1 {
+		m.notice = "Nothing to compact"
+		return nil
+	}
+	request := append(append([]message(nil), m.history[:keepFrom]...), message{Role: "user", Content: compactPrompt})
+	m.compacting = true
+	m.notice = fmt.Sprintf("Compacting %d messages…", keepFrom-1)
+	cfg, length := m.cfg, len(m.history)
+	return func() tea.Msg {
+		_, summary, err := runAgentLoop(context.Background(), cfg, nil, nil, request, nil)
+		local := false
+		if endpointUnreachable(err) {
+			summary, local, err = localSummary(request[1:keepFrom]), true, nil
+		}
+		if err == nil && strings.TrimSpace(summary) == "" {
+			err = errors.New("the model returned an empty summary")
+		}
+		return compactMsg{summary: strings.TrimSpace(summary), local: local, keepFrom: keepFrom, length: length, err: err}
+	}
+}
+
+func (m model) handleCompactMsg(msg compactMsg) (tea.Model, tea.Cmd) {
+	m.compacting = false
+	if msg.err != nil {
+		m.lastErr = fmt.Errorf("compact: %w", msg.err)
+		return m, nil
+	}
+	if len(m.history) != msg.length {
+		m.notice = "The conversation changed while compacting; run /compact again"
+		return m, nil
+	}
+	before := historyTokens(m.history)
+	fillBefore := contextFill(m.history, m.cfg.ContextWindow)
+	summary := message{Role: "system", Content: "[Summary of the earlier conversation]\n" + msg.summary}
+	history := append([]message{m.system, summary}, m.history[msg.keepFrom:]...)
+	shift := msg.keepFrom - 2
+	for _, b := range m.transcript.blocks {
+		switch {
+		case b.history >= msg.keepFrom:
+			b.history -= shift
+		case b.history > 0:
+			b.history = -1
+		}
+	}
+	m.history = history
+	reclaimed := before - historyTokens(m.history)
+	if msg.local {
+		m.markEndpointDown()
+		m.appendNote("The model is unreachable, so the summary lists the task, requests, files written, and last answer. Summary:\n" + msg.summary)
+	} else {
+		m.appendNote("Conversation compacted. Summary:\n" + msg.summary)
+	}
+	m.lastErr = nil
+	m.notice = fmt.Sprintf("Compacted %d messages into a summary • reclaimed ~%s tokens • ctx %d%% → %d%%",
+		msg.keepFrom-1, formatCount(max(reclaimed, 0)), fillBefore, contextFill(m.history, m.cfg.ContextWindow))
+	m.persistSession()
+	return m, nil
+}
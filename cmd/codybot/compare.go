@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/ansi"
+)
+
+// comparison is a /compare run: one prompt streamed to two models at once,
+// shown side by side until the user keeps one answer or discards both.
+type comparison struct {
+	prompt string
+	panes  [2]*comparePane
+	focus  int
+	cancel context.CancelFunc
+}
+
+type comparePane struct {
+	model    string
+	text     strings.Builder
+	stats    streamStats
+	viewport viewport.Model
+	done     bool
+	err      error
+}
+
+// compareMsg is a stream message for one pane of a comparison.
+type compareMsg struct {
+	c    *comparison
+	pane int
+	msg  streamMsg
+	ch   <-chan streamMsg
+}
+
+func waitCompare(c *comparison, pane int, ch <-chan streamMsg) tea.Cmd {
+	return func() tea.Msg {
+		return compareMsg{c: c, pane: pane, msg: <-ch, ch: ch}
+	}
+}
+
+// cmdCompare sends a prompt to --model and a second model without tools,
+// so neither answer touches the workspace.
+func (m *model) cmdCompare(args string) tea.Cmd {
+	other := m.cfg.CompareModel
+	if rest, ok := strings.CutPrefix(args, "--with "); ok {
+		other, args, _ = strings.Cut(strings.TrimSpace(rest), " ")
+	}
+	prompt := strings.TrimSpace(args)
+	if prompt == "" || other == "" {
+		m.notice = "Usage: /compare [--with <model>] <prompt> (default model: --compare-model)"
+		return nil
+	}
+	if m.streaming {
+		m.notice = "Wait for the current response to finish before comparing"
+		return nil
+	}
+	if other == m.cfg.Model {
+		m.notice = "Pick a model other than " + m.cfg.Model + " to compare with"
+		return nil
+	}
+	history := append(m.withPins(m.history), message{Role: "user", Content: prompt})
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &comparison{prompt: prompt, cancel: cancel}
+	var cmds []tea.Cmd
+	for i, name := range []string{m.cfg.Model, other} {
+		cfg := m.cfg
+		cfg.Model = name
+		// Failing over would compare against the fallback instead.
+		cfg.FallbackBaseURL, cfg.FallbackModel = "", ""
+		c.panes[i] = &comparePane{model: name}
+		c.panes[i].stats.begin()
+		ch := make(chan streamMsg)
+		go streamWithFailover(ctx, cfg, history, nil, ch)
+		cmds = append(cmds, waitCompare(c, i, batchStream(ch, streamBatchInterval)))
+	}
+	m.compare = c
+	m.state = stateCompare
+	m.compare.resize(m.width, m.height)
+	return tea.Batch(cmds...)
+}
+
+func (m model) handleCompareMsg(msg compareMsg) (tea.Model, tea.Cmd) {
+	if msg.c != m.compare {
+		return m, nil
+	}
+	pane := msg.c.panes[msg.pane]
+	switch {
+	case msg.msg.err != nil:
+		cfg := m.cfg
+		cfg.Model = pane.model
+		pane.stats.finish()
+		pane.done, pane.err = true, classifyError(cfg, msg.msg.err)
+	case msg.msg.done:
+		pane.stats.finish()
+		pane.done = true
+		if msg.msg.model != "" {
+			pane.model = msg.msg.model
+		}
+	default:
+		pane.stats.observe(msg.msg.token, msg.msg.chunks)
+		pane.text.WriteString(msg.msg.token)
+	}
+	pane.refresh()
+	if pane.done {
+		return m, nil
+	}
+	return m, waitCompare(msg.c, msg.pane, msg.ch)
+}
+
+func (c *comparison) running() bool {
+	return !c.panes[0].done || !c.panes[1].done
+}
+
+// resize splits the window between the two panes.
+func (c *comparison) resize(width, height int) {
+	if c == nil {
+		return
+	}
+	paneWidth := max(width/2-4, 1)
+	paneHeight := max(height-6, 1)
+	for _, p := range c.panes {
+		following := p.viewport.Height == 0 || p.viewport.AtBottom()
+		offset := p.viewport.YOffset
+		p.viewport = newChatViewport(paneWidth, paneHeight)
+		p.refresh()
+		if following {
+			p.viewport.GotoBottom()
+		} else {
+			p.viewport.SetYOffset(offset)
+		}
+	}
+}
+
+func (p *comparePane) refresh() {
+	following := p.viewport.AtBottom()
+	text := p.text.String()
+	if p.err != nil {
+		text += "\n\n" + errorStyle.Render("Error: "+p.err.Error())
+	}
+	p.viewport.SetContent(ansi.Wrap(text, p.viewport.Width, ""))
+	if following {
+		p.viewport.GotoBottom()
+	}
+}
+
+func (p *comparePane) title(n int) string {
+	status := p.stats.summary()
+	switch {
+	case p.err != nil:
+		status = "failed"
+	case p.done:
+		status += " • done"
+	}
+	return fmt.Sprintf("%d %s  %s", n, p.model, subtleStyle.Render(status))
+}
+
+func (m model) updateCompare(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	c := m.compare
+	switch key := msg.String(); key {
+	case "1", "2":
+		if c.running() {
+			m.notice = "Wait for both answers, or Esc to stop and discard them"
+			return m, nil
+		}
+		pane := c.panes[key[0]-'1']
+		if pane.err != nil || strings.TrimSpace(pane.text.String()) == "" {
+			m.notice = "That model gave no answer to keep"
+			return m, nil
+		}
+		m.keepComparison(pane)
+		return m, nil
+	case "tab", "left", "right":
+		c.focus = 1 - c.focus
+	case "esc":
+		c.cancel()
+		m.compare = nil
+		m.state = stateChat
+		m.notice = "Comparison discarded"
+	case "ctrl+c":
+		c.cancel()
+		return m, tea.Quit
+	default:
+		var cmd tea.Cmd
+		c.panes[c.focus].viewport, cmd = c.panes[c.focus].viewport.Update(msg)
+		return m, cmd
+	}
+	return m, nil
+}
+
+// keepComparison adds the prompt and the chosen answer to the conversation
+// as an ordinary turn.
+func (m *model) keepComparison(pane *comparePane) {
+	c := m.compare
+	m.compare = nil
+	m.state = stateChat
+	user := m.transcript.add(blockUser, c.prompt)
+	m.history = append(m.history, message{Role: "user", Content: c.prompt, Meta: &messageMeta{At: time.Now(), Tokens: estimateTokens(c.prompt)}})
+	user.history = len(m.history) - 1
+	user.meta = m.history[user.history].Meta
+	response := pane.text.String()
+	meta := &messageMeta{
+		At:           time.Now(),
+		Model:        pane.model,
+		LatencyMS:    pane.stats.ttft().Milliseconds(),
+		DurationMS:   pane.stats.elapsed().Milliseconds(),
+		Tokens:       estimateTokens(response),
+		PromptTokens: historyTokens(m.history),
+	}
+	m.history = append(m.history, message{Role: "assistant", Content: response, Meta: meta})
+	answer := m.transcript.add(blockAssistant, response)
+	answer.setMeta(meta)
+	m.scrollLock = false
+	m.refreshTranscript()
+	m.persistSession()
+	m.notice = "Kept the answer from " + pane.model
+}
+
+func (m model) viewCompare() string {
+	c := m.compare
+	prompt, _ := truncateRunes(firstLine(c.prompt), max(m.width-20, 10))
+	header := m.fitLine(headerStyle.Render("codybot compare") + " " + subtleStyle.Render(prompt))
+	var boxes []string
+	for i, p := range c.panes {
+		title := "  " + p.title(i+1)
+		if i == c.focus {
+			title = headerStyle.Render("▸ ") + p.title(i+1)
+		}
+		title = ansi.Truncate(title, p.viewport.Width, "…")
+		boxes = append(boxes, borderStyle.Width(p.viewport.Width+2).Render(title+"\n"+p.viewport.View()))
+	}
+	help := "Tab switches pane • ↑/↓ PgUp/PgDn scroll • Esc stops and discards"
+	if !c.running() {
+		help = "1 or 2 keeps that answer • Tab switches pane • Esc discards both"
+	}
+	if m.notice != "" {
+		help = m.notice + " • " + help
+	}
+	return lipgloss.JoinVertical(lipgloss.Left, header, lipgloss.JoinHorizontal(lipgloss.Top, boxes...), m.fitLine(subtleStyle.Render(help)))
+}
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// completeCommand is the hidden subcommand the completion scripts call
+	// with the words typed so far, the word being completed last.
+	completeCommand = "__complete"
+
+	modelCacheTTL  = time.Hour
+	modelsTimeout  = 2 * time.Second
+	maxModelsBytes = 4 << 20
+)
+
+var completionShells = []string{"bash", "zsh", "fish", "powershell"}
+
+// flagValues are the fixed choices of enumerated flags.
+var flagValues = map[string][]string{
+	"provider":          {providerOpenAI, providerOllama, providerOpenRouter},
+	"fallback-provider": {providerOpenAI, providerOllama, providerOpenRouter},
+	"theme":             {"auto", "dark", "light", "solarized"},
+	"images":            {"auto", "kitty", "iterm2", "sixel", "off"},
+	"multiplexer":       {multiplexerAuto, multiplexerTmux, multiplexerZellij},
+	"pane-direction":    {"right", "down"},
+	"openrouter-sort":   {"price", "throughput", "latency"},
+	"lsp":               {"auto", "off"},
+	"sandbox":           sandboxNames,
+	"command-policy":    commandPolicies,
+	"history-policy":    historyPolicyNames(),
+	"format":            {"auto", importAider, importChatGPT, importClaude},
+}
+
+// modelFlags complete from the endpoint's model list.
+var modelFlags = map[string]bool{"model": true, "check-model": true, "compare-model": true, "fallback-model": true}
+
+// flagUsage matches the flag package's help output: "  -name type" with the
+// usage after a tab or on the next line.
+var flagUsage = regexp.MustCompile(`(?m)^  -(\S+)( \S+)?(?:\t|\n    \t)(.*)$`)
+
+const bashCompletion = `# bash completion for codybot; load with: source <(codybot completion bash)
+_codybot() {
+    local IFS=$'\n'
+    COMPREPLY=($(codybot __complete "${COMP_WORDS[@]:1:COMP_CWORD}" 2>/dev/null | cut -f1))
+}
+complete -o default -F _codybot codybot
+`
+
+const zshCompletion = `#compdef codybot
+# zsh completion for codybot; load with: source <(codybot completion zsh)
+_codybot() {
+    local -a candidates
+    local line name
+    for line in "${(@f)$(codybot __complete "${(@)words[2,CURRENT]}" 2>/dev/null)}"; do
+        [[ -z $line ]] && continue
+        name=${line%%$'\t'*}
+        name=${name//:/\\:}
+        if [[ $line == *$'\t'* ]]; then
+            candidates+=("$name:${line#*$'\t'}")
+        else
+            candidates+=("$name")
+        fi
+    done
+    if (( ${#candidates} )); then
+        _describe codybot candidates
+    else
+        _files
+    fi
+}
+compdef _codybot codybot
+`
+
+const fishCompletion = `# fish completion for codybot; load with: codybot completion fish | source
+function __codybot_complete
+    set -l out (codybot __complete (commandline -opc)[2..-1] (commandline -ct) 2>/dev/null)
+    if test (count $out) -eq 0
+        __fish_complete_path (commandline -ct)
+    else
+        printf '%s\n' $out
+    end
+end
+complete -c codybot -f -a '(__codybot_complete)'
+`
+
+// Windows PowerShell drops empty arguments to native commands, so an empty
+// word goes as '""', which the completer treats as empty.
+const powershellCompletion = `# PowerShell completion for codybot; load with: codybot completion powershell | Out-String | Invoke-Expression
+Register-ArgumentCompleter -Native -CommandName codybot -ScriptBlock {
+    param($wordToComplete, $commandAst, $cursorPosition)
+    $words = @($commandAst.CommandElements | Select-Object -Skip 1 | ForEach-Object { $_.ToString() })
+    if ($wordToComplete -eq '') { $words += '""' }
+    & codybot __complete @words 2>$null | ForEach-Object {
+        $name, $description = $_ -split "` + "`" + `t", 2
+        if (-not $description) { $description = $name }
+        [System.Management.Automation.CompletionResult]::new($name, $name, 'ParameterValue', $description)
+    }
+}
+`
+
+func runCompletionCommand(args []string, stdout, stderr io.Writer) int {
+	scripts := map[string]string{
+		"bash":       bashCompletion,
+		"zsh":        zshCompletion,
+		"fish":       fishCompletion,
+		"powershell": powershellCompletion,
+	}
+	if len(args) != 1 || scripts[args[0]] == "" {
+		fmt.Fprintf(stderr, "usage: codybot completion %s\n", strings.Join(completionShells, "|"))
+		return 2
+	}
+	fmt.Fprint(stdout, scripts[args[0]])
+	return 0
+}
+
+// completionFlag is a flag as shell completion offers it.
+type completionFlag struct {
+	name   string
+	usage  string
+	hasArg bool
+}
+
+// commandFlags lists the flags of the subcommand reached by path, or of the
+// TUI when path is empty. Subcommands build their flag sets as they run, so
+// they are asked for -h and the help output is parsed: the flags offered are
+// always the ones the subcommand accepts.
+func commandFlags(path []string) []completionFlag {
+	var help bytes.Buffer
+	if len(path) == 0 {
+		fs := flag.NewFlagSet("codybot", flag.ContinueOnError)
+		fs.SetOutput(&help)
+		registerTUIFlags(fs, &config{})
+		fs.PrintDefaults()
+	} else {
+		for _, sub := range subcommands() {
+			if sub.name == path[0] && sub.name != "completion" {
+				sub.run(append(append([]string(nil), path[1:]...), "-h"), io.Discard, &help)
+			}
+		}
+	}
+	var flags []completionFlag
+	for _, match := range flagUsage.FindAllStringSubmatch(help.String(), -1) {
+		flags = append(flags, completionFlag{name: match[1], usage: match[3], hasArg: match[2] != ""})
+	}
+	return flags
+}
+
+func runCompleteCommand(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		return 0
+	}
+	for _, candidate := range completeWords(args[:len(args)-1], strings.Trim(args[len(args)-1], `"`)) {
+		fmt.Fprintln(stdout, candidate)
+	}
+	return 0
+}
+
+// completeWords returns the candidates for cur, each optionally followed by a
+// tab and a description. No candidates lets the shell complete file names.
+func completeWords(words []string, cur string) []string {
+	var path []string
+	var sub *subcommand
+	if len(words) > 0 {
+		for _, s := range subcommands() {
+			if s.name == words[0] {
+				sub, path = &s, []string{s.name}
+				break
+			}
+		}
+	}
+	rest := words[len(path):]
+	if sub != nil && len(sub.args) > 0 {
+		// Flags come after the subcommand's own first word.
+		if len(rest) == 0 {
+			return filterCandidates(sub.args, cur)
+		}
+		path, rest = append(path, rest[0]), rest[1:]
+	}
+	flags := commandFlags(path)
+
+	takesValue := func(word string) bool {
+		name := strings.TrimLeft(word, "-")
+		for _, f := range flags {
+			if f.name == name && f.hasArg {
+				return strings.HasPrefix(word, "-")
+			}
+		}
+		return false
+	}
+	if len(rest) > 0 && takesValue(rest[len(rest)-1]) {
+		return completeFlagValue(strings.TrimLeft(rest[len(rest)-1], "-"), rest, cur)
+	}
+	if flagName, value, ok := strings.Cut(cur, "="); ok && takesValue(flagName) {
+		var out []string
+		for _, c := range completeFlagValue(strings.TrimLeft(flagName, "-"), rest, value) {
+			out = append(out, flagName+"="+c)
+		}
+		return out
+	}
+	if strings.HasPrefix(cur, "-") {
+		var out []string
+		for _, f := range flags {
+			out = append(out, "--"+f.name+"\t"+f.usage)
+		}
+		return filterCandidates(out, cur)
+	}
+	positional := 0
+	for i, word := range rest {
+		if !strings.HasPrefix(word, "-") && (i == 0 || !takesValue(rest[i-1])) {
+			positional++
+		}
+	}
+	switch {
+	case sub == nil && len(words) == 0:
+		var out []string
+		for _, s := range subcommands() {
+			if s.help != "" {
+				out = append(out, s.name+"\t"+s.help)
+			}
+		}
+		return filterCandidates(out, cur)
+	case len(path) == 1 && path[0] == "help" && positional == 0:
+		var out []string
+		for _, s := range subcommands() {
+			if s.help != "" && s.name != "help" {
+				out = append(out, s.name+"\t"+s.help)
+			}
+		}
+		return filterCandidates(out, cur)
+	case len(path) == 2 && path[0] == "sessions" && path[1] == "show" && positional == 0:
+		return filterCandidates(sessionCandidates(), cur)
+	}
+	return nil
+}
+
+func completeFlagValue(name string, words []string, cur string) []string {
+	if values, ok := flagValues[name]; ok {
+		return filterCandidates(values, cur)
+	}
+	if name == "resume" {
+		return filterCandidates(sessionCandidates(), cur)
+	}
+	if !modelFlags[name] {
+		return nil
+	}
+	// Fetch from the endpoint the command line points at, so a --base-url
+	// or --provider typed earlier is respected.
+	fs := flag.NewFlagSet("complete", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	var cfg config
+	registerConfigFlags(fs, &cfg)
+	for i, word := range words {
+		key, value, ok := strings.Cut(strings.TrimLeft(word, "-"), "=")
+		if !strings.HasPrefix(word, "-") || fs.Lookup(key) == nil {
+			continue
+		}
+		if !ok && i+1 < len(words) {
+			value, ok = words[i+1], true
+		}
+		if ok {
+			fs.Set(key, value)
+		}
+	}
+	cfg = cfg.normalized()
+	return filterCandidates(cachedModels(cfg), cur)
+}
+
+func sessionCandidates() []string {
+	paths, _ := listSessionFiles()
+	var out []string
+	for i := len(paths) - 1; i >= 0; i-- {
+		session, err := loadSession(paths[i])
+		if err != nil {
+			continue
+		}
+		out = append(out, session.ID+"\t"+sessionLabel(session))
+	}
+	return out
+}
+
+func filterCandidates(candidates []string, cur string) []string {
+	var out []string
+	for _, c := range candidates {
+		if strings.HasPrefix(c, cur) {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+type modelCache struct {
+	BaseURL   string    `json:"base_url"`
+	FetchedAt time.Time `json:"fetched_at"`
+	Models    []string  `json:"models"`
+}
+
+func modelCachePath(baseURL string) string {
+	sum := sha256.Sum256([]byte(baseURL))
+	return filepath.Join(codybotHome(), "cache", "models-"+hex.EncodeToString(sum[:6])+".json")
+}
+
+// cachedModels returns the endpoint's model names, refetching them when the
+// cache is older than an hour. A tab press cannot wait on a slow endpoint, so
+// the fetch is short, and a stale list beats none when it fails.
+func cachedModels(cfg config) []string {
+	path := modelCachePath(cfg.BaseURL)
+	var cache modelCache
+	if data, err := os.ReadFile(path); err == nil {
+		json.Unmarshal(data, &cache)
+	}
+	if time.Since(cache.FetchedAt) < modelCacheTTL {
+		return cache.Models
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), modelsTimeout)
+	defer cancel()
+	models, err := listModels(ctx, cfg)
+	if err != nil {
+		return cache.Models
+	}
+	cache = modelCache{BaseURL: cfg.BaseURL, FetchedAt: time.Now(), Models: models}
+	if data, err := json.Marshal(cache); err == nil {
+		if os.MkdirAll(filepath.Dir(path), 0o755) == nil {
+			writeFileAtomic(path, data, 0o600)
+		}
+	}
+	return models
+}
+
+// listModels asks the endpoint which models it serves: /models for
+// OpenAI-compatible APIs and /api/tags for Ollama.
+func listModels(ctx context.Context, cfg config) ([]string, error) {
+	url := strings.TrimRight(cfg.BaseURL, "/") + "/models"
+	if cfg.Provider == providerOllama {
+		url = ollamaBaseURL(cfg) + "/api/tags"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.authorize(req); err != nil {
+		return nil, err
+	}
+	setRequestExtras(req, cfg)
+	client, err := httpClientFor(cfg)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return nil, &apiError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+	var body struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+		Models []struct {
+			Name string `json:"name"`
+		} `json:"models"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxModelsBytes)).Decode(&body); err != nil {
+		return nil, err
+	}
+	var models []string
+	for _, m := range body.Data {
+		models = append(models, m.ID)
+	}
+	for _, m := range body.Models {
+		models = append(models, m.Name)
+	}
+	sort.Strings(models)
+	return models, nil
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// contextReserve is the share of the context window left free for the
+// answer when history has to be cut.
+const contextReserve = 4
+
+var (
+	planHeadingPattern = regexp.MustCompile(`(?im)^\s*(#+\s*|\*\*)?(the\s+)?plan\b`)
+	planStepPattern    = regexp.MustCompile(`(?m)^\s*(- \[[ xX]\]|\d+[.)])\s+\S`)
+	diffPattern        = regexp.MustCompile("(?m)^(```diff|diff --git |@@ -\\d)")
+)
+
+// fitContext returns the history to send when it no longer fits the
+// context window, as cut by cfg's history policy, and how many messages were
+// left out. The caller's history is not modified.
+func fitContext(ctx context.Context, cfg config, history []message, report func(string)) ([]message, int) {
+	window := cfg.ContextWindow
+	budget := window - window/contextReserve
+	if window <= 0 || historyTokens(history) <= budget {
+		return history, 0
+	}
+	policy, ok := historyPolicies()[cfg.HistoryPolicy]
+	if !ok {
+		policy = historyPolicies()[defaultHistoryPolicy]
+	}
+	return policy.fit(ctx, cfg, history, budget, report)
+}
+
+// fitPinned drops the oldest messages first, except the ones the agent is
+// working from: the system prompt, the task statement (the first user
+// message), the latest plan, and the latest diff. The files written by the
+// dropped messages are listed in a note so the model still knows what it
+// changed.
+func fitPinned(_ context.Context, _ config, history []message, budget int, _ func(string)) ([]message, int) {
+	start := historyStart(history)
+	pinned := map[int]bool{}
+	if start > 0 {
+		pinned[0] = true
+	}
+	task, plan, diff := workingMessages(history, start)
+	for _, i := range []int{task, plan, diff} {
+		if i >= 0 {
+			pinned[i] = true
+		}
+	}
+
+	dropped := map[int]bool{}
+	count := 0
+	total := historyTokens(history)
+	units := historyUnits(history, start)
+	for _, unit := range units[:max(len(units)-1, 0)] {
+		if total <= budget {
+			break
+		}
+		for i := unit[0]; i < unit[1]; i++ {
+			dropped[i] = true
+			if !pinned[i] {
+				total -= historyTokens(history[i : i+1])
+				count++
+			}
+		}
+	}
+	return omitMessages(history, dropped, pinned, count, "The task, the latest plan, and the latest diff are kept.")
+}
+
+// historyStart is the index of the first message after the system prompt.
+func historyStart(history []message) int {
+	if len(history) > 0 && history[0].Role == "system" {
+		return 1
+	}
+	return 0
+}
+
+// workingMessages finds the task statement, the latest plan, and the latest
+// diff from start on; each is -1 when there is none.
+func workingMessages(history []message, start int) (task, plan, diff int) {
+	task, plan, diff = -1, -1, -1
+	for i := start; i < len(history); i++ {
+		msg := history[i]
+		switch {
+		case msg.Role == "user" && task < 0:
+			task = i
+		case msg.Role == "assistant" && isPlan(msg.Content):
+			plan = i
+		}
+		if msg.Role != "user" && diffPattern.MatchString(msg.Content) {
+			diff = i
+		}
+	}
+	return task, plan, diff
+}
+
+// historyUnits splits history from start into the spans dropped whole, so a
+// tool result never outlives the assistant message that called it: a user
+// message alone, or an assistant message with the tool results that follow
+// it.
+func historyUnits(history []message, start int) [][2]int {
+	var units [][2]int
+	for i := start; i < len(history); {
+		j := i + 1
+		if history[i].Role == "assistant" {
+			for j < len(history) && history[j].Role == "tool" {
+				j++
+			}
+		}
+		units = append(units, [2]int{i, j})
+		i = j
+	}
+	return units
+}
+
+// omitMessages builds the history to send without the dropped messages, with
+// a note where the first of them were. A pinned message from a dropped unit
+// is kept as text; kept says what the policy keeps, for the note.
+func omitMessages(history []message, dropped, pinned map[int]bool, count int, kept string) ([]message, int) {
+	if count == 0 {
+		return history, 0
+	}
+	written := map[string]bool{}
+	out := make([]message, 0, len(history)-len(dropped)+1)
+	noted, gap := false, false
+	for i, msg := range history {
+		if !dropped[i] {
+			if !noted && gap {
+				out = append(out, evictionNote(count, written, kept))
+				noted = true
+			}
+			out = append(out, msg)
+			continue
+		}
+		gap = true
+		for _, call := range msg.ToolCalls {
+			if path := writtenPath(call); path != "" {
+				written[path] = true
+			}
+		}
+		if !pinned[i] {
+			continue
+		}
+		// A pinned message from a dropped unit loses its tool calls, whose
+		// results are gone, and keeps its text.
+		if msg.Role == "tool" {
+			msg = message{Role: "user", Content: "Result of " + msg.Name + ":\n" + msg.Content}
+		}
+		msg.ToolCalls = nil
+		out = append(out, msg)
+	}
+	return out, count
+}
+
+func isPlan(content string) bool {
+	if planHeadingPattern.MatchString(content) {
+		return true
+	}
+	return len(planStepPattern.FindAllString(content, 3)) >= 3
+}
+
+func writtenPath(call toolCall) string {
+	if call.Function.Name != "write_file" {
+		return ""
+	}
+	var args struct {
+		Path string `json:"path"`
+	}
+	if json.Unmarshal([]byte(call.Function.Arguments), &args) != nil {
+		return ""
+	}
+	return args.Path
+}
+
+func evictionNote(count int, written map[string]bool, kept string) message {
+	text := fmt.Sprintf("[%d earlier messages were left out to fit the context window.", count)
+	if kept != "" {
+		text += " " + kept
+	}
+	if len(written) > 0 {
+		paths := make([]string, 0, len(written))
+		for path := range written {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		text += " Files written in the omitted messages: " + strings.Join(paths, ", ") + "."
+	}
+	return message{Role: "system", Content: text + "]"}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+const (
+	controlQueue      = 256
+	maxControlRequest = 1 << 20
+)
+
+// controlServer is the --control-socket API: editors and scripts connect to a
+// Unix socket, send newline-delimited JSON requests, and receive the running
+// session's events as newline-delimited serveEvent objects, the same
+// envelope codybot serve uses.
+type controlServer struct {
+	path string
+	ln   net.Listener
+	send func(tea.Msg)
+
+	mu       sync.Mutex
+	conns    map[chan serveEvent]struct{}
+	seq      int
+	messages int
+}
+
+type controlRequest struct {
+	ID   string `json:"id,omitempty"`
+	Type string `json:"type"`
+	Text string `json:"text,omitempty"`
+}
+
+type controlReplyData struct {
+	ID string `json:"id,omitempty"`
+	OK bool   `json:"ok"`
+	// Turn is the turn an accepted prompt will run as.
+	Turn     int       `json:"turn,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Status   any       `json:"status,omitempty"`
+	Messages []message `json:"messages,omitempty"`
+}
+
+type controlStatus struct {
+	Session   string `json:"session"`
+	Model     string `json:"model"`
+	Busy      bool   `json:"busy"`
+	Turn      int    `json:"turn"`
+	Messages  int    `json:"messages"`
+	Context   int    `json:"context_percent"`
+	Workspace string `json:"workspace"`
+}
+
+// controlRequestMsg carries a request into the Update loop, which owns the
+// session. The reply is queued on the client's connection there, so it
+// arrives before the events of the turn it starts.
+type controlRequestMsg struct {
+	req  controlRequest
+	conn chan serveEvent
+}
+
+// listenControl opens the socket, replacing a stale one left by a crashed
+// instance but refusing to take over one that is still answering.
+func listenControl(path string) (*controlServer, error) {
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("%s is in use by another codybot", path)
+	}
+	os.Remove(path)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	hub := newControlHub()
+	hub.path, hub.ln = path, ln
+	return hub, nil
+}
+
+// newControlHub publishes events without a socket, for when only --web
+// follows the session.
+func newControlHub() *controlServer {
+	return &controlServer{conns: map[chan serveEvent]struct{}{}}
+}
+
+// subscribe returns a channel that receives every published event, and a
+// function that unsubscribes and closes it.
+func (c *controlServer) subscribe() (chan serveEvent, func()) {
+	ch := make(chan serveEvent, controlQueue)
+	c.mu.Lock()
+	c.conns[ch] = struct{}{}
+	c.mu.Unlock()
+	return ch, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if _, ok := c.conns[ch]; ok {
+			delete(c.conns, ch)
+			close(ch)
+		}
+	}
+}
+
+func (c *controlServer) serve(send func(tea.Msg)) {
+	c.send = send
+	if c.ln == nil {
+		return
+	}
+	for {
+		conn, err := c.ln.Accept()
+		if err != nil {
+			return
+		}
+		go c.handle(conn)
+	}
+}
+
+func (c *controlServer) close() {
+	if c == nil || c.ln == nil {
+		return
+	}
+	c.ln.Close()
+	os.Remove(c.path)
+}
+
+func (c *controlServer) handle(conn net.Conn) {
+	defer conn.Close()
+	events := make(chan serveEvent, controlQueue)
+	c.mu.Lock()
+	c.conns[events] = struct{}{}
+	c.mu.Unlock()
+	done := make(chan struct{})
+	defer func() {
+		c.mu.Lock()
+		delete(c.conns, events)
+		c.mu.Unlock()
+		close(done)
+	}()
+
+	go func() {
+		enc := json.NewEncoder(conn)
+		for {
+			select {
+			case <-done:
+				return
+			case ev := <-events:
+				if enc.Encode(ev) != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 64*1024), maxControlRequest)
+	for scanner.Scan() {
+		var req controlRequest
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			c.deliver(events, c.event("", "reply", "", controlReplyData{Error: "invalid JSON: " + err.Error()}))
+			continue
+		}
+		c.send(controlRequestMsg{req: req, conn: events})
+	}
+}
+
+// deliver queues ev for one connection, dropping it when the client is not
+// reading; the gap shows in seq.
+func (c *controlServer) deliver(ch chan serveEvent, ev serveEvent) {
+	select {
+	case ch <- ev:
+	default:
+	}
+}
+
+func (c *controlServer) event(session, typ, messageID string, data any) serveEvent {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seq++
+	return serveEvent{Type: typ, Session: session, Message: messageID, Seq: c.seq, Time: time.Now().UTC(), Data: data}
+}
+
+func (c *controlServer) nextMessageID() string {
+	if c == nil {
+		return ""
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.messages++
+	return "m" + strconv.Itoa(c.messages)
+}
+
+// publish sends a session event to every connected client.
+func (c *controlServer) publish(session, typ, messageID string, data any) {
+	if c == nil {
+		return
+	}
+	ev := c.event(session, typ, messageID, data)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for ch := range c.conns {
+		c.deliver(ch, ev)
+	}
+}
+
+func (m model) handleControlRequest(msg controlRequestMsg) (tea.Model, tea.Cmd) {
+	reply := controlReplyData{ID: msg.req.ID, OK: true}
+	start := false
+	switch msg.req.Type {
+	case "prompt":
+		switch {
+		case msg.req.Text == "":
+			reply.OK, reply.Error = false, "text is required"
+		case m.state != stateChat:
+			reply.OK, reply.Error = false, "codybot is not at the chat screen"
+		case m.streaming || m.compacting:
+			reply.OK, reply.Error = false, "busy with another turn"
+		default:
+			start = true
+			reply.Turn = m.journal.lastTurn() + 1
+		}
+	case "status":
+		reply.Status = controlStatus{
+			Session:   m.sessionID,
+			Model:     m.cfg.Model,
+			Busy:      m.streaming || m.compacting,
+			Turn:      m.turn,
+			Messages:  len(m.history) - 1,
+			Context:   contextFill(m.history, m.cfg.ContextWindow),
+			Workspace: m.workspace,
+		}
+	case "history":
+		reply.Messages = append([]message(nil), m.history[1:]...)
+	default:
+		reply.OK, reply.Error = false, fmt.Sprintf("unknown request type %q; use prompt, status, or history", msg.req.Type)
+	}
+	m.control.deliver(msg.conn, m.control.event(m.sessionID, "reply", "", reply))
+	if !start {
+		return m, nil
+	}
+	return m, m.send(msg.req.Text, msg.req.Text)
+}
+
+// publishEnd reports the end of an assistant message to control clients.
+func (m *model) publishEnd(content string, calls []toolCall) {
+	finish := "stop"
+	if len(calls) > 0 {
+		finish = "tool_calls"
+	}
+	m.control.publish(m.sessionID, "message.end", m.controlMessage, messageEndData{Role: "assistant", Content: content, ToolCalls: calls, FinishReason: finish})
+	for _, call := range calls {
+		m.control.publish(m.sessionID, "tool.call", m.controlMessage, toolCallData{ID: call.ID, Name: call.Function.Name, Arguments: call.Function.Arguments})
+	}
+}
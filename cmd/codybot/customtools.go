@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+const customToolTimeout = 5 * time.Minute
+
+var (
+	toolNamePattern   = regexp.MustCompile(`^[a-z][a-z0-9_]{0,63}$`)
+	placeholderRegexp = regexp.MustCompile(`\{([a-z][a-z0-9_]*)(\?)?\}`)
+)
+
+// templateParam is one {name} or {name?} placeholder in a command template.
+// flag is the option right before it, e.g. "--env" in "--env {env}", which
+// doubles as its description and is dropped along with an omitted optional.
+type templateParam struct {
+	name     string
+	optional bool
+	flag     string
+}
+
+func templateParams(template string) []templateParam {
+	var params []templateParam
+	seen := map[string]bool{}
+	for _, loc := range placeholderRegexp.FindAllStringSubmatchIndex(template, -1) {
+		name := template[loc[2]:loc[3]]
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		p := templateParam{name: name, optional: loc[4] >= 0}
+		before := strings.Fields(strings.TrimRight(template[:loc[0]], "="))
+		if n := len(before); n > 0 && strings.HasPrefix(before[n-1], "-") {
+			p.flag = strings.TrimRight(before[n-1], "=")
+		}
+		params = append(params, p)
+	}
+	return params
+}
+
+// customToolSpec builds a session tool whose schema comes from the template's
+// placeholders. Arguments are shell-quoted before substitution.
+func customToolSpec(name, template string) toolSpec {
+	props := map[string]FunctionProperty{}
+	var required []string
+	for _, p := range templateParams(template) {
+		desc := "Value for {" + p.name + "}"
+		if p.flag != "" {
+			desc = "Value passed as " + p.flag
+		}
+		if p.optional {
+			desc += " (optional)"
+		} else {
+			required = append(required, p.name)
+		}
+		props[p.name] = FunctionProperty{Type: "string", Description: desc}
+	}
+	if _, ok := props["background"]; !ok {
+		props["background"] = backgroundProperty
+	}
+	description := fmt.Sprintf("Project-specific command added for this session: %s", template)
+	return toolSpec{
+		def:        functionTool(name, description, props, required...),
+		mutating:   true,
+		template:   template,
+		network:    true,
+		background: true,
+		run: func(ctx context.Context, env *toolEnv, raw json.RawMessage) (string, error) {
+			var args map[string]any
+			if err := json.Unmarshal(raw, &args); err != nil {
+				return "", fmt.Errorf("invalid arguments: %w", err)
+			}
+			command, err := expandTemplate(template, args)
+			if err != nil {
+				return "", err
+			}
+			timeout := customToolTimeout
+			if env != nil && env.background {
+				timeout = maxTaskDuration
+			}
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			output, err := env.runCommand(ctx, command)
+			if err != nil {
+				return "", fmt.Errorf("%s: %v\n%s", command, err, tailLines(output, 80))
+			}
+			return output, nil
+		},
+	}
+}
+
+func expandTemplate(template string, args map[string]any) (string, error) {
+	for _, p := range templateParams(template) {
+		value, ok := args[p.name]
+		text := ""
+		if ok && value != nil {
+			text = fmt.Sprint(value)
+		}
+		if text == "" && !p.optional {
+			return "", fmt.Errorf("missing required argument %q", p.name)
+		}
+		placeholder := regexp.QuoteMeta("{" + p.name)
+		if p.optional {
+			placeholder += `\?`
+		}
+		placeholder += `\}`
+		if text == "" && p.flag != "" {
+			template = regexp.MustCompile(regexp.QuoteMeta(p.flag)+`[ =]`+placeholder).ReplaceAllString(template, "")
+			continue
+		}
+		template = regexp.MustCompile(placeholder).ReplaceAllLiteralString(template, shellQuote(text))
+	}
+	return template, nil
+}
+
+func (r *toolRegistry) customTools() []toolSpec {
+	var specs []toolSpec
+	for _, name := range r.names() {
+		if spec := r.specs[name]; spec.template != "" {
+			specs = append(specs, spec)
+		}
+	}
+	return specs
+}
+
+func (r *toolRegistry) dropCustomTools() {
+	if r == nil {
+		return
+	}
+	for name, spec := range r.specs {
+		if spec.template != "" {
+			delete(r.specs, name)
+		}
+	}
+}
+
+func (m *model) cmdTool(args string) tea.Cmd {
+	if m.tools == nil {
+		m.notice = "Tools are disabled (--no-tools)"
+		return nil
+	}
+	sub, rest, _ := strings.Cut(args, " ")
+	rest = strings.TrimSpace(rest)
+	switch sub {
+	case "add":
+		name, template, ok := strings.Cut(rest, "--")
+		name, template = strings.TrimSpace(name), strings.TrimSpace(template)
+		if !ok || name == "" || template == "" {
+			m.notice = "Usage: /tool add <name> -- <command with {arg} or {arg?} placeholders>"
+			return nil
+		}
+		if !toolNamePattern.MatchString(name) {
+			m.notice = fmt.Sprintf("Tool names are lowercase letters, digits, and underscores, got %q", name)
+			return nil
+		}
+		if existing, ok := m.tools.specs[name]; ok && existing.template == "" {
+			m.notice = fmt.Sprintf("%s is a built-in tool", name)
+			return nil
+		}
+		if m.tools.offline || m.tools.readOnly {
+			m.notice = "Session tools run arbitrary commands and are disabled in --offline and --read-only mode"
+			return nil
+		}
+		spec := customToolSpec(name, template)
+		m.tools.register(spec)
+		var params []string
+		for _, p := range templateParams(template) {
+			params = append(params, p.name)
+		}
+		sort.Strings(params)
+		m.notice = fmt.Sprintf("Added tool %s(%s) for this session", name, strings.Join(params, ", "))
+	case "rm":
+		spec, ok := m.tools.specs[rest]
+		if !ok || spec.template == "" {
+			m.notice = fmt.Sprintf("No session tool %q", rest)
+			return nil
+		}
+		delete(m.tools.specs, rest)
+		m.notice = "Removed tool " + rest
+	case "", "list":
+		custom := m.tools.customTools()
+		if len(custom) == 0 {
+			m.notice = "No session tools; add one with /tool add <name> -- <command>"
+			return nil
+		}
+		var b strings.Builder
+		b.WriteString("Session tools:\n")
+		for _, spec := range custom {
+			fmt.Fprintf(&b, "  %-20s %s\n", spec.def.Function.Name, spec.template)
+		}
+		m.appendNote(b.String())
+	case "export":
+		if rest == "" {
+			m.notice = "Usage: /tool export <path.json|path.md>"
+			return nil
+		}
+		tools := toolInventory(m.cfg, m.tools, "")
+		var b bytes.Buffer
+		if strings.EqualFold(filepath.Ext(rest), ".json") {
+			writeToolsJSON(&b, tools)
+		} else {
+			writeToolsMarkdown(&b, tools)
+		}
+		if err := writeFileAtomic(filepath.Clean(rest), b.Bytes(), 0o644); err != nil {
+			m.lastErr = fmt.Errorf("tool export: %w", err)
+			return nil
+		}
+		m.lastErr = nil
+		m.notice = fmt.Sprintf("Wrote %d tools to %s", len(tools), rest)
+	default:
+		m.notice = "Usage: /tool [list|add <name> -- <command>|rm <name>|export <path>]"
+	}
+	return nil
+}
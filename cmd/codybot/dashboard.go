@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+const (
+	dashboardDays     = 14
+	dashboardSessions = 8
+	dashboardFiles    = 10
+)
+
+type dashboard struct {
+	repo    string
+	records []activityRecord
+}
+
+func (m *model) cmdDashboard(string) tea.Cmd {
+	if err := m.loadDashboard(); err != nil {
+		m.lastErr = err
+		return nil
+	}
+	m.state = stateDashboard
+	return nil
+}
+
+func (m *model) loadDashboard() error {
+	repo := repoRoot()
+	records, err := loadActivity(repo)
+	if err != nil {
+		return err
+	}
+	m.dashboard = &dashboard{repo: repo, records: records}
+	return nil
+}
+
+func (m model) updateDashboard(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "r":
+		if err := m.loadDashboard(); err != nil {
+			m.lastErr = err
+		}
+	case "esc", "q":
+		m.state = stateChat
+		m.dashboard = nil
+	case "ctrl+c":
+		return m, tea.Quit
+	}
+	return m, nil
+}
+
+func (m model) viewDashboard() string {
+	d := m.dashboard
+	var b strings.Builder
+	b.WriteString(headerStyle.Render("codybot dashboard"))
+	b.WriteString(" " + subtleStyle.Render(filepath.Base(d.repo)) + "\n\n")
+	if len(d.records) == 0 {
+		b.WriteString("No agent activity recorded for this repo yet.\n")
+	} else {
+		sections := []string{d.totals(), d.spendByDay(time.Now()), d.topFiles(), d.recentSessions()}
+		b.WriteString(strings.Join(sections, "\n"))
+	}
+	if m.lastErr != nil {
+		b.WriteString("\nError: " + m.lastErr.Error() + "\n")
+	}
+	b.WriteString("\n" + subtleStyle.Render("r refresh • Esc back"))
+	return b.String()
+}
+
+func (d *dashboard) spent(records []activityRecord) (float64, int) {
+	cost, tokens := 0.0, 0
+	for _, rec := range records {
+		cost += rec.Cost
+		tokens += rec.InputTokens + rec.OutputTokens
+	}
+	return cost, tokens
+}
+
+func formatSpend(cost float64, tokens int) string {
+	if cost > 0 {
+		return fmt.Sprintf("$%.2f (%s tokens)", cost, formatCount(tokens))
+	}
+	return formatCount(tokens) + " tokens"
+}
+
+func formatCount(n int) string {
+	switch {
+	case n >= 1_000_000:
+		return fmt.Sprintf("%.1fM", float64(n)/1e6)
+	case n >= 1_000:
+		return fmt.Sprintf("%.1fk", float64(n)/1e3)
+	}
+	return fmt.Sprint(n)
+}
+
+func (d *dashboard) totals() string {
+	sessions := map[string]bool{}
+	tested, passed := 0, 0
+	for _, rec := range d.records {
+		sessions[rec.Session] = true
+		if rec.Tests != "" && len(rec.Files) > 0 {
+			tested++
+			if rec.Tests == testsPassed {
+				passed++
+			}
+		}
+	}
+	cost, tokens := d.spent(d.records)
+	rate := "n/a"
+	if tested > 0 {
+		rate = fmt.Sprintf("%d%% (%d/%d)", passed*100/tested, passed, tested)
+	}
+	var b strings.Builder
+	b.WriteString(dashboardHeading.Render("Overview") + "\n")
+	fmt.Fprintf(&b, "  %-22s %d across %d sessions\n", "Tasks completed", len(d.records), len(sessions))
+	fmt.Fprintf(&b, "  %-22s %s\n", "Spend", formatSpend(cost, tokens))
+	fmt.Fprintf(&b, "  %-22s %s\n", "Tests pass after edits", rate)
+	return b.String()
+}
+
+func (d *dashboard) spendByDay(now time.Time) string {
+	type day struct {
+		cost   float64
+		tokens int
+	}
+	days := make([]day, dashboardDays)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	for _, rec := range d.records {
+		at := rec.At.In(now.Location())
+		index := dashboardDays - 1 - int(today.Sub(time.Date(at.Year(), at.Month(), at.Day(), 0, 0, 0, 0, now.Location())).Hours()/24)
+		if index >= 0 && index < dashboardDays {
+			days[index].cost += rec.Cost
+			days[index].tokens += rec.InputTokens + rec.OutputTokens
+		}
+	}
+	value := func(d day) float64 {
+		if d.cost > 0 {
+			return d.cost
+		}
+		return float64(d.tokens)
+	}
+	peak := 0.0
+	for _, d := range days {
+		peak = max(peak, value(d))
+	}
+	var b strings.Builder
+	b.WriteString(dashboardHeading.Render(fmt.Sprintf("Spend, last %d days", dashboardDays)) + "\n")
+	for i, d := range days {
+		width := 0
+		if peak > 0 {
+			width = int(value(d) / peak * 30)
+		}
+		label := today.AddDate(0, 0, i-dashboardDays+1).Format("Mon 01/02")
+		bar := dashboardBar.Render(strings.Repeat("█", width))
+		if d.tokens == 0 {
+			fmt.Fprintf(&b, "  %s %s\n", label, subtleStyle.Render("·"))
+			continue
+		}
+		fmt.Fprintf(&b, "  %s %s %s\n", label, bar, subtleStyle.Render(formatSpend(d.cost, d.tokens)))
+	}
+	return b.String()
+}
+
+func (d *dashboard) topFiles() string {
+	counts := map[string]int{}
+	for _, rec := range d.records {
+		for _, file := range rec.Files {
+			counts[file]++
+		}
+	}
+	files := make([]string, 0, len(counts))
+	for file := range counts {
+		files = append(files, file)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		if counts[files[i]] != counts[files[j]] {
+			return counts[files[i]] > counts[files[j]]
+		}
+		return files[i] < files[j]
+	})
+	var b strings.Builder
+	b.WriteString(dashboardHeading.Render("Most edited files") + "\n")
+	if len(files) == 0 {
+		b.WriteString(subtleStyle.Render("  (no agent edits yet)") + "\n")
+	}
+	for _, file := range files[:min(len(files), dashboardFiles)] {
+		fmt.Fprintf(&b, "  %3d× %s\n", counts[file], file)
+	}
+	return b.String()
+}
+
+func (d *dashboard) recentSessions() string {
+	type session struct {
+		id     string
+		first  activityRecord
+		last   time.Time
+		tasks  int
+		cost   float64
+		tokens int
+	}
+	byID := map[string]*session{}
+	var order []*session
+	for _, rec := range d.records {
+		s, ok := byID[rec.Session]
+		if !ok {
+			s = &session{id: rec.Session, first: rec}
+			byID[rec.Session] = s
+			order = append(order, s)
+		}
+		s.tasks++
+		s.last = rec.At
+		s.cost += rec.Cost
+		s.tokens += rec.InputTokens + rec.OutputTokens
+	}
+	sort.Slice(order, func(i, j int) bool { return order[i].last.After(order[j].last) })
+	var b strings.Builder
+	b.WriteString(dashboardHeading.Render("Recent sessions") + "\n")
+	for _, s := range order[:min(len(order), dashboardSessions)] {
+		prompt, _ := truncateRunes(strings.ReplaceAll(s.first.Prompt, "\n", " "), 50)
+		fmt.Fprintf(&b, "  %s  %2d tasks  %-20s %q\n", s.last.Local().Format("01/02 15:04"), s.tasks, formatSpend(s.cost, s.tokens), prompt)
+	}
+	return b.String()
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	maxClusterSites = 40
+	// locationLookahead is how many lines after a warning are searched for its
+	// location, for compilers that print it on a line of its own.
+	locationLookahead = 3
+)
+
+// deprecationBuilds maps a project file to a build command that reports
+// deprecated API use, tried in order.
+var deprecationBuilds = []struct {
+	file    string
+	command string
+}{
+	{"go.mod", "staticcheck -checks SA1019 ./..."},
+	{"Cargo.toml", "cargo check --all-targets --message-format short"},
+	{"pom.xml", "mvn -q compile -Dmaven.compiler.showDeprecation=true"},
+	{"pyproject.toml", "python -W always::DeprecationWarning -m pytest -q"},
+	{"setup.py", "python -W always::DeprecationWarning -m pytest -q"},
+}
+
+var (
+	deprecationWord = regexp.MustCompile(`(?i)deprecat`)
+	// warningLocation is failureLocation plus javac's "File.java:[12,5]".
+	warningLocation = regexp.MustCompile(`([\w./\\-]+\.[A-Za-z0-9]+):\[?(\d+)(?:[:,]\d+)?\]?`)
+	warningPrefix   = regexp.MustCompile(`^(?:\[WARNING\]\s*|warning:\s*|-->\s*)+`)
+)
+
+type deprecationSite struct {
+	Path string
+	Line string
+}
+
+// deprecationCluster is one deprecation warning and every place it is
+// reported. Warnings cluster on their text, which names the deprecated API.
+type deprecationCluster struct {
+	Message string
+	Sites   []deprecationSite
+}
+
+func detectDeprecationBuild() (string, error) {
+	for _, b := range deprecationBuilds {
+		if _, err := os.Stat(b.file); err == nil {
+			return b.command, nil
+		}
+	}
+	return "", errors.New("no go.mod, Cargo.toml, pom.xml, pyproject.toml, or setup.py here; pass --build-command")
+}
+
+// clusterDeprecations groups the deprecation warnings in build output by
+// message, most frequent first.
+func clusterDeprecations(output, root string) []deprecationCluster {
+	lines := strings.Split(output, "\n")
+	index := map[string]int{}
+	seen := map[string]bool{}
+	var clusters []deprecationCluster
+	for i, line := range lines {
+		if !deprecationWord.MatchString(line) {
+			continue
+		}
+		text, loc := line, warningLocation.FindStringSubmatch(line)
+		if loc != nil {
+			text = strings.Replace(line, loc[0], "", 1)
+		}
+		for j := i + 1; loc == nil && j < min(i+1+locationLookahead, len(lines)); j++ {
+			if strings.Contains(lines[j], "-->") {
+				loc = warningLocation.FindStringSubmatch(lines[j])
+			}
+		}
+		if loc == nil {
+			continue
+		}
+		site := deprecationSite{Path: relativeToRoot(root, loc[1]), Line: loc[2]}
+		text = strings.TrimSpace(warningPrefix.ReplaceAllString(strings.Trim(strings.TrimSpace(text), ": "), ""))
+		if text == "" {
+			continue
+		}
+		key := text + "\x00" + site.Path + ":" + site.Line
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		n, ok := index[text]
+		if !ok {
+			n = len(clusters)
+			index[text] = n
+			clusters = append(clusters, deprecationCluster{Message: text})
+		}
+		clusters[n].Sites = append(clusters[n].Sites, site)
+	}
+	sort.SliceStable(clusters, func(i, j int) bool { return len(clusters[i].Sites) > len(clusters[j].Sites) })
+	return clusters
+}
+
+func relativeToRoot(root, path string) string {
+	if filepath.IsAbs(path) {
+		if rel, err := filepath.Rel(root, path); err == nil && !strings.HasPrefix(rel, "..") {
+			return filepath.ToSlash(rel)
+		}
+	}
+	return filepath.ToSlash(filepath.Clean(path))
+}
+
+func (c deprecationCluster) files() []string {
+	seen := map[string]bool{}
+	var files []string
+	for _, s := range c.Sites {
+		if !seen[s.Path] {
+			seen[s.Path] = true
+			files = append(files, s.Path)
+		}
+	}
+	return files
+}
+
+func deprecationPrompt(c deprecationCluster) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "The build reports this deprecation warning:\n%s\n\nAt:\n", c.Message)
+	for i, s := range c.Sites {
+		if i == maxClusterSites {
+			fmt.Fprintf(&b, "... %d more\n", len(c.Sites)-maxClusterSites)
+			break
+		}
+		fmt.Fprintf(&b, "%s:%s\n", s.Path, s.Line)
+	}
+	b.WriteString("\nMigrate every one of these sites to the recommended replacement. Read each file, change only what the migration needs with write_file, and keep behavior the same. Reply with a one-line summary of the migration. If a site cannot be migrated safely, leave it and say why.")
+	return b.String()
+}
+
+func runDeprecationsCommand(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("deprecations", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var cfg config
+	registerConfigFlags(fs, &cfg)
+	buildCommand := fs.String("build-command", "", "Command whose output reports deprecation warnings (default: detected from the project files)")
+	maxClusters := fs.Int("max-clusters", 0, "Fix at most this many clusters, most frequent first (0 for all)")
+	inventoryOnly := fs.Bool("inventory", false, "List the clustered warnings and exit without changing files")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	cfg = cfg.normalized()
+	root, err := enterWorkspace(cfg)
+	if err == nil {
+		err = requireTrust(cfg, root)
+	}
+	if err == nil {
+		err = checkSandbox(cfg.Sandbox)
+	}
+	if err == nil {
+		err = checkRequestExtras(cfg)
+	}
+	if err == nil {
+		err = checkCommandPolicy(cfg.CommandPolicy)
+	}
+	if err == nil && *buildCommand == "" {
+		*buildCommand, err = detectDeprecationBuild()
+	}
+	if err == nil {
+		err = cfg.attachTokenSource()
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "codybot deprecations: %v\n", err)
+		return 1
+	}
+
+	ctx := context.Background()
+	scan := func() ([]deprecationCluster, error) {
+		fmt.Fprintf(stdout, "==> running %s\n", *buildCommand)
+		output, err := runShellCommand(ctx, ".", *buildCommand)
+		clusters := clusterDeprecations(output, root)
+		// Linters exit non-zero when they report anything, so a failure only
+		// counts when it produced no warnings to work from.
+		if err != nil && len(clusters) == 0 {
+			return nil, fmt.Errorf("%s: %v\n%s", *buildCommand, err, tailLines(output, 40))
+		}
+		return clusters, nil
+	}
+	clusters, err := scan()
+	if err != nil {
+		fmt.Fprintf(stderr, "codybot deprecations: %v\n", err)
+		return 1
+	}
+	total := 0
+	for _, c := range clusters {
+		total += len(c.Sites)
+	}
+	fmt.Fprintf(stdout, "Found %d deprecation warnings in %d clusters\n", total, len(clusters))
+	for i, c := range clusters {
+		fmt.Fprintf(stdout, "  %d. [%d] %s\n", i+1, len(c.Sites), c.Message)
+	}
+	if *inventoryOnly || len(clusters) == 0 {
+		return 0
+	}
+	if *maxClusters > 0 && len(clusters) > *maxClusters {
+		clusters = clusters[:*maxClusters]
+	}
+
+	registry := newToolRegistry(cfg)
+	defer registry.close()
+	journal, err := openEditJournal(journalFileName)
+	if err != nil {
+		fmt.Fprintf(stderr, "warning: edit journal not loaded: %v\n", err)
+	}
+	if report := journal.recoveryReport(); report != "" {
+		fmt.Fprintf(stderr, "codybot deprecations: %s\nStart codybot and resolve it with /recover first.\n", report)
+		return 1
+	}
+	system := message{Role: "system", Content: buildSystemPrompt(cfg, "") + "\n\nYou are migrating code off deprecated APIs, one warning at a time. Keep edits minimal and behavior-preserving."}
+	var summary []string
+	for i, c := range clusters {
+		fmt.Fprintf(stdout, "==> [%d/%d] %s (%d sites in %d files)\n", i+1, len(clusters), c.Message, len(c.Sites), len(c.files()))
+		env := &toolEnv{journal: journal, tests: newTestLoop(cfg.TestCommand, cfg.TestAttempts), sandbox: registry.sandbox, turn: i + 1, prompt: "deprecation: " + c.Message}
+		history := []message{system, {Role: "user", Content: deprecationPrompt(c)}}
+		_, answer, err := runAgentLoop(ctx, cfg, registry, env, history, func(ev agentEvent) {
+			if ev.call != nil {
+				fmt.Fprintf(stdout, "    [tool] %s\n", ev.call.summary())
+			}
+		})
+		journal.closeTurn()
+		if err != nil {
+			fmt.Fprintf(stderr, "codybot deprecations: %v\n", err)
+			return 1
+		}
+		answer = strings.TrimSpace(answer)
+		fmt.Fprintf(stdout, "    %s\n", answer)
+		changed := clusterChanges(journal, i+1)
+		summary = append(summary, fmt.Sprintf("  %d. %s\n     %d files changed: %s", i+1, c.Message, len(changed), firstLine(answer)))
+		if cfg.TestCommand == "" || len(changed) == 0 {
+			continue
+		}
+		fmt.Fprintf(stdout, "==> running %s\n", cfg.TestCommand)
+		if output, err := registry.sandbox.run(ctx, cfg.TestCommand); err != nil {
+			fmt.Fprintf(stderr, "tests failed after migrating %q: %v\n%s\n", c.Message, err, tailLines(output, 40))
+			fmt.Fprintln(stderr, "Stopped; /undo in codybot reverts this cluster's edits.")
+			return 1
+		}
+	}
+
+	remaining, err := scan()
+	if err != nil {
+		fmt.Fprintf(stderr, "warning: could not rescan: %v\n", err)
+	}
+	left := 0
+	for _, c := range remaining {
+		left += len(c.Sites)
+	}
+	fmt.Fprintf(stdout, "\nMigrated %d clusters:\n%s\n", len(summary), strings.Join(summary, "\n"))
+	if err == nil {
+		fmt.Fprintf(stdout, "Deprecation warnings: %d before, %d after\n", total, left)
+	}
+	return 0
+}
+
+// clusterChanges lists the files this session's checkpoint for turn changed.
+func clusterChanges(journal *editJournal, turn int) []string {
+	checkpoints, _ := journal.snapshot()
+	var files []string
+	for _, cp := range checkpoints {
+		if cp.Session == journal.session && cp.Turn == turn {
+			files = append(files, cp.files()...)
+		}
+	}
+	return files
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
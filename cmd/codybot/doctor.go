@@ -0,0 +1,353 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// doctorTimeout bounds each network check, so an endpoint that hangs is
+// reported instead of waited on.
+const doctorTimeout = 15 * time.Second
+
+const (
+	checkOK   = "ok"
+	checkWarn = "warn"
+	checkFail = "fail"
+)
+
+// doctorCheck is one line of codybot doctor: what was checked, how it went,
+// and for anything short of ok, what to do about it.
+type doctorCheck struct {
+	name   string
+	status string
+	detail string
+	fix    string
+}
+
+func runDoctorCommand(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("doctor", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var cfg config
+	registerConfigFlags(fs, &cfg)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintln(stderr, "usage: codybot doctor [flags]")
+		return 2
+	}
+	cfg = cfg.normalized()
+	root, err := enterWorkspace(cfg)
+	if err != nil {
+		fmt.Fprintf(stderr, "codybot doctor: %v\n", err)
+		return 1
+	}
+	cfg.AgentPath = resolveAgentPath(cfg.AgentPath)
+
+	var checks []doctorCheck
+	checks = append(checks, checkSettings(cfg))
+	checks = append(checks, checkEndpoint(cfg, "endpoint", "--model")...)
+	if fallback, ok := cfg.fallbackConfig(); ok {
+		checks = append(checks, checkEndpoint(fallback, "fallback", "--fallback-model")...)
+	}
+	checks = append(checks, checkAgentFiles(cfg)...)
+	trusted := isTrusted(root)
+	checks = append(checks, checkTrust(cfg, root, trusted))
+	checks = append(checks, checkHooks(trusted))
+	checks = append(checks, checkGit(trusted))
+	checks = append(checks, checkSandboxRuntime(cfg)...)
+	if cfg.TestCommand != "" && cfg.Sandbox == sandboxNone {
+		checks = append(checks, checkTestCommand(cfg.TestCommand))
+	}
+
+	failed := 0
+	for _, c := range checks {
+		fmt.Fprintf(stdout, "%-4s  %-10s %s\n", c.status, c.name, c.detail)
+		if c.fix != "" {
+			fmt.Fprintf(stdout, "%-4s  %-10s fix: %s\n", "", "", c.fix)
+		}
+		if c.status == checkFail {
+			failed++
+		}
+	}
+	if failed > 0 {
+		fmt.Fprintf(stdout, "\n%d check(s) failed.\n", failed)
+		return 1
+	}
+	return 0
+}
+
+// checkSettings runs the validation the other commands do at startup, plus
+// the policy files they read.
+func checkSettings(cfg config) doctorCheck {
+	err := checkHistoryPolicy(cfg.HistoryPolicy)
+	if err == nil {
+		err = checkSandbox(cfg.Sandbox)
+	}
+	if err == nil {
+		err = checkCommandPolicy(cfg.CommandPolicy)
+	}
+	if err == nil {
+		err = checkRequestExtras(cfg)
+	}
+	if err != nil {
+		return doctorCheck{name: "settings", status: checkFail, detail: err.Error(), fix: "correct the flag or its CODYBOT_* variable; codybot config shows where each setting comes from"}
+	}
+	if _, err := readPolicyFiles(); err != nil {
+		return doctorCheck{name: "settings", status: checkFail, detail: err.Error(), fix: "fix the JSON in the policy file; until then the strictest policies apply"}
+	}
+	return doctorCheck{name: "settings", status: checkOK, detail: fmt.Sprintf("%s provider, model %s", cfg.Provider, cfg.Model)}
+}
+
+// checkEndpoint lists the endpoint's models, which shows at once whether it
+// is reachable, whether it takes the credentials, and whether it serves the
+// configured model.
+func checkEndpoint(cfg config, name, modelFlag string) []doctorCheck {
+	if err := cfg.attachTokenSource(); err != nil {
+		return []doctorCheck{{name: name, status: checkFail, detail: err.Error(), fix: "run codybot auth login"}}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), doctorTimeout)
+	defer cancel()
+	start := time.Now()
+	models, err := listModels(ctx, cfg)
+	var apiErr *apiError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusUnauthorized {
+		// The chat reloads the key after a 401 the same way.
+		if _, changed, refreshErr := cfg.refreshCredentials(ctx); refreshErr == nil && changed {
+			models, err = listModels(ctx, cfg)
+		}
+	}
+	elapsed := time.Since(start).Round(time.Millisecond)
+	key := credentialCheck(cfg, name, err)
+	if err != nil {
+		switch {
+		case errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden):
+			return []doctorCheck{{name: name, status: checkOK, detail: fmt.Sprintf("%s answered in %s", cfg.BaseURL, elapsed)}, key}
+		case errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound:
+			return []doctorCheck{
+				{name: name, status: checkWarn, detail: fmt.Sprintf("%s has no model list (%s)", cfg.BaseURL, apiErr.Status), fix: "check that --base-url ends in the API's version path, such as /v1; some gateways list no models and still chat"},
+				key,
+			}
+		case errors.As(err, &apiErr):
+			return []doctorCheck{{name: name, status: checkFail, detail: fmt.Sprintf("%s: %s", cfg.BaseURL, apiErr.Status), fix: "check the provider's status page, or --base-url and --provider"}}
+		}
+		return []doctorCheck{{name: name, status: checkFail, detail: fmt.Sprintf("cannot reach %s: %v", cfg.BaseURL, err), fix: unreachableFix(cfg)}}
+	}
+	checks := []doctorCheck{{name: name, status: checkOK, detail: fmt.Sprintf("%s answered in %s", cfg.BaseURL, elapsed)}, key}
+	served := false
+	for _, m := range models {
+		if m == cfg.Model || strings.TrimSuffix(m, ":latest") == cfg.Model {
+			served = true
+		}
+	}
+	switch {
+	case served:
+		checks = append(checks, doctorCheck{name: "models", status: checkOK, detail: fmt.Sprintf("%d served, including %s", len(models), cfg.Model)})
+	case len(models) == 0:
+		checks = append(checks, doctorCheck{name: "models", status: checkWarn, detail: "the endpoint lists no models", fix: pullFix(cfg, modelFlag)})
+	default:
+		shown := models
+		if len(shown) > 8 {
+			shown = append(shown[:8:8], fmt.Sprintf("and %d more", len(models)-8))
+		}
+		checks = append(checks, doctorCheck{name: "models", status: checkFail, detail: fmt.Sprintf("%s is not among the %d served: %s", cfg.Model, len(models), strings.Join(shown, ", ")), fix: pullFix(cfg, modelFlag)})
+	}
+	return checks
+}
+
+// credentialCheck reports on the key or token sent with the model list
+// request, given how that request went.
+func credentialCheck(cfg config, name string, err error) doctorCheck {
+	c := doctorCheck{name: name + " key"}
+	source := "the API key"
+	switch {
+	case cfg.tokens != nil:
+		source = "the OAuth token"
+	case cfg.apiKey() == "" && cfg.APIKeyCommand == "":
+		source = "no key"
+	case cfg.apiKey() == "":
+		source = "the key from --api-key-command"
+	}
+	var apiErr *apiError
+	if errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden) {
+		c.status, c.detail = checkFail, fmt.Sprintf("rejected %s (%s)", source, apiErr.Status)
+		c.fix = fmt.Sprintf("set %s, store one with codybot auth set-key, or set --api-key-command", cfg.apiKeyEnvName())
+		if cfg.tokens != nil {
+			c.fix = "run codybot auth login again"
+		}
+		return c
+	}
+	if err != nil {
+		c.status, c.detail = checkWarn, "not checked: the model list request failed"
+		return c
+	}
+	c.status, c.detail = checkOK, "accepted "+source
+	if source != "no key" && source != "the OAuth token" && cfg.APIKey != "" {
+		c.detail += " " + maskKey(cfg.APIKey)
+	}
+	return c
+}
+
+func unreachableFix(cfg config) string {
+	switch {
+	case cfg.Offline:
+		return "--offline only allows the endpoints themselves; check --base-url and --proxy"
+	case cfg.Provider == providerOllama || strings.Contains(cfg.BaseURL, ":11434"):
+		return "start Ollama with ollama serve, or point --base-url at your server"
+	}
+	return "check --base-url, your network, and --proxy or HTTPS_PROXY; --ca-bundle trusts a gateway's own certificates"
+}
+
+func pullFix(cfg config, modelFlag string) string {
+	if cfg.Provider == providerOllama || strings.Contains(cfg.BaseURL, ":11434") {
+		return fmt.Sprintf("run ollama pull %s, or pick a served model with %s", cfg.Model, modelFlag)
+	}
+	return "pick a served model with " + modelFlag
+}
+
+// checkAgentFiles loads the instruction files and profiles as the chat
+// would, reporting what it finds and what fails to parse.
+func checkAgentFiles(cfg config) []doctorCheck {
+	files := loadAgentFiles(cfg.AgentPath)
+	var checks []doctorCheck
+	if !hasProjectAgents(files) {
+		checks = append(checks, doctorCheck{name: "agents.md", status: checkWarn, detail: "no project instructions found", fix: "start codybot to create a starter agents.md, or write AGENTS.md yourself"})
+	} else {
+		tokens := estimateTokens(mergeAgentFiles(files, cfg.AgentPath))
+		var paths []string
+		for _, f := range files {
+			paths = append(paths, displayPath(f.path))
+		}
+		c := doctorCheck{name: "agents.md", status: checkOK, detail: fmt.Sprintf("%s (%d tokens)", strings.Join(paths, ", "), tokens)}
+		if cfg.ContextWindow > 0 && tokens > cfg.ContextWindow/4 {
+			c.status = checkWarn
+			c.fix = fmt.Sprintf("the instructions take over a quarter of the %d-token context window; trim them or raise --context-window", cfg.ContextWindow)
+		}
+		checks = append(checks, c)
+	}
+	names, err := listProfiles(cfg.AgentPath)
+	if err != nil {
+		return append(checks, doctorCheck{name: "profiles", status: checkFail, detail: err.Error(), fix: "check the permissions of " + profilesDir(cfg.AgentPath)})
+	}
+	for _, name := range names {
+		if _, err := loadProfile(cfg.AgentPath, name); err != nil {
+			checks = append(checks, doctorCheck{name: "profiles", status: checkFail, detail: err.Error(), fix: "fix the front matter of the profile"})
+		}
+	}
+	if cfg.Profile != "" {
+		if _, err := loadProfile(cfg.AgentPath, cfg.Profile); err != nil {
+			return append(checks, doctorCheck{name: "profiles", status: checkFail, detail: err.Error(), fix: "create it, or change --profile or CODYBOT_PROFILE"})
+		}
+	}
+	if len(names) > 0 {
+		checks = append(checks, doctorCheck{name: "profiles", status: checkOK, detail: strings.Join(names, ", ")})
+	}
+	return checks
+}
+
+func checkTrust(cfg config, root string, trusted bool) doctorCheck {
+	switch {
+	case cfg.NoTools:
+		return doctorCheck{name: "tools", status: checkOK, detail: "off (--no-tools)"}
+	case trusted:
+		return doctorCheck{name: "tools", status: checkOK, detail: root + " is trusted"}
+	}
+	return doctorCheck{name: "tools", status: checkWarn, detail: root + " is not trusted, so the agent has no tools", fix: "start codybot here and trust the workspace, or pass --trust"}
+}
+
+// checkHooks parses the hooks files the chat reads before each turn.
+func checkHooks(trusted bool) doctorCheck {
+	hooks, err := loadHooks(trusted)
+	if err != nil {
+		return doctorCheck{name: "hooks", status: checkFail, detail: err.Error(), fix: "fix the hooks file; until then no hooks run"}
+	}
+	if hooks.pending != nil {
+		return doctorCheck{name: "hooks", status: checkWarn, detail: projectHooksPath + " is new or changed and not approved", fix: "review it with /hooks in the chat, then /hooks approve"}
+	}
+	if len(hooks.PreRequest)+len(hooks.PostResponse) == 0 {
+		return doctorCheck{name: "hooks", status: checkOK, detail: "none"}
+	}
+	return doctorCheck{name: "hooks", status: checkOK, detail: fmt.Sprintf("%d pre-request, %d post-response", len(hooks.PreRequest), len(hooks.PostResponse))}
+}
+
+// checkGit looks for git, which the header, /commit, and /leftovers use. It
+// only asks about the repository in a trusted workspace: the repository's
+// config can run commands.
+func checkGit(trusted bool) doctorCheck {
+	path, err := exec.LookPath("git")
+	if err != nil {
+		return doctorCheck{name: "git", status: checkWarn, detail: "not installed", fix: "install git for the header's branch, /commit, /worktree, and /leftovers"}
+	}
+	version, _ := exec.Command(path, "--version").Output()
+	detail := strings.TrimSpace(string(version))
+	if !trusted {
+		return doctorCheck{name: "git", status: checkOK, detail: detail}
+	}
+	if _, err := runGit("", "rev-parse", "--git-dir"); err != nil {
+		return doctorCheck{name: "git", status: checkWarn, detail: detail + "; the workspace is not a repository", fix: "run git init so edits can be reviewed and committed"}
+	}
+	return doctorCheck{name: "git", status: checkOK, detail: detail + "; the workspace is a repository"}
+}
+
+// checkSandboxRuntime checks the container runtime --sandbox uses: that it
+// is installed, that its daemon answers, and that the image is there. Without
+// --sandbox it only says which runtimes could be used.
+func checkSandboxRuntime(cfg config) []doctorCheck {
+	if cfg.Sandbox == sandboxNone || cfg.Sandbox == "" {
+		var found []string
+		for _, runtime := range []string{sandboxDocker, sandboxPodman} {
+			if _, err := exec.LookPath(runtime); err == nil {
+				found = append(found, runtime)
+			}
+		}
+		if len(found) == 0 {
+			return []doctorCheck{{name: "sandbox", status: checkOK, detail: "off; neither docker nor podman is installed for --sandbox"}}
+		}
+		return []doctorCheck{{name: "sandbox", status: checkOK, detail: "off; --sandbox can use " + strings.Join(found, " or ")}}
+	}
+	runtime := cfg.Sandbox
+	path, err := exec.LookPath(runtime)
+	if err != nil {
+		return []doctorCheck{{name: "sandbox", status: checkFail, detail: runtime + " is not installed", fix: "install " + runtime + ", or drop --sandbox"}}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), doctorTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, path, "version", "--format", "{{.Server.Version}}").CombinedOutput()
+	if err != nil {
+		fix := "start the Docker daemon, or Docker Desktop"
+		if runtime == sandboxPodman {
+			fix = "run podman machine start, or check podman info"
+		}
+		return []doctorCheck{{name: "sandbox", status: checkFail, detail: fmt.Sprintf("%s does not answer: %s", runtime, lastLine(string(out))), fix: fix}}
+	}
+	checks := []doctorCheck{{name: "sandbox", status: checkOK, detail: fmt.Sprintf("%s %s", runtime, strings.TrimSpace(string(out)))}}
+	image := cfg.SandboxImage
+	if image == "" {
+		image = defaultSandboxImage
+	}
+	if err := exec.CommandContext(ctx, path, "image", "inspect", image).Run(); err != nil {
+		checks = append(checks, doctorCheck{name: "image", status: checkWarn, detail: image + " is not pulled yet", fix: fmt.Sprintf("run %s pull %s, or let the first command pull it", runtime, image)})
+	} else {
+		checks = append(checks, doctorCheck{name: "image", status: checkOK, detail: image})
+	}
+	return checks
+}
+
+// checkTestCommand looks for the program --test-command starts.
+func checkTestCommand(command string) doctorCheck {
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return doctorCheck{name: "tests", status: checkOK, detail: "no --test-command"}
+	}
+	if _, err := exec.LookPath(fields[0]); err != nil {
+		return doctorCheck{name: "tests", status: checkFail, detail: fmt.Sprintf("%s is not on PATH", fields[0]), fix: "install it, or fix --test-command"}
+	}
+	return doctorCheck{name: "tests", status: checkOK, detail: command}
+}
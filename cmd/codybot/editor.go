@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+const (
+	editorStateDir     = ".codybot/editors"
+	editorStaleAfter   = 5 * time.Minute
+	editorExcerptLines = 20
+	maxEditorFiles     = 12
+)
+
+// editorState is the handshake file an editor plugin keeps up to date in
+// .codybot/editors/<editor>.json, rewriting it whenever the open buffers,
+// focus, or cursor change. Files older than editorStaleAfter are ignored so a
+// closed editor stops contributing context.
+type editorState struct {
+	Editor    string       `json:"editor"`
+	UpdatedAt time.Time    `json:"updated_at"`
+	Files     []editorFile `json:"files"`
+}
+
+type editorFile struct {
+	Path      string       `json:"path"`
+	Active    bool         `json:"active,omitempty"`
+	Dirty     bool         `json:"dirty,omitempty"`
+	Cursor    *editorPos   `json:"cursor,omitempty"`
+	Selection *editorRange `json:"selection,omitempty"`
+	Visible   *editorLines `json:"visible,omitempty"`
+}
+
+type editorPos struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+type editorRange struct {
+	Start editorPos `json:"start"`
+	End   editorPos `json:"end"`
+}
+
+type editorLines struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// editorContext tracks whether editor buffers are included and what was sent
+// last, so an unchanged view is not repeated on every turn.
+type editorContext struct {
+	disabled bool
+	last     string
+}
+
+func loadEditorStates(root string) ([]editorState, error) {
+	paths, err := filepath.Glob(filepath.Join(root, editorStateDir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var states []editorState
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var state editorState
+		if err := json.Unmarshal(data, &state); err != nil {
+			continue
+		}
+		if state.UpdatedAt.IsZero() {
+			if info, err := os.Stat(path); err == nil {
+				state.UpdatedAt = info.ModTime()
+			}
+		}
+		if time.Since(state.UpdatedAt) > editorStaleAfter || len(state.Files) == 0 {
+			continue
+		}
+		if state.Editor == "" {
+			state.Editor = strings.TrimSuffix(filepath.Base(path), ".json")
+		}
+		states = append(states, state)
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].UpdatedAt.After(states[j].UpdatedAt) })
+	return states, nil
+}
+
+func (f editorFile) describe() string {
+	var details []string
+	if f.Active {
+		details = append(details, "focused")
+	}
+	if f.Cursor != nil {
+		details = append(details, fmt.Sprintf("cursor L%d:%d", f.Cursor.Line, f.Cursor.Column))
+	}
+	if f.Selection != nil {
+		details = append(details, fmt.Sprintf("selection L%d-L%d", f.Selection.Start.Line, f.Selection.End.Line))
+	}
+	if f.Visible != nil {
+		details = append(details, fmt.Sprintf("viewing L%d-L%d", f.Visible.Start, f.Visible.End))
+	}
+	if f.Dirty {
+		details = append(details, "unsaved changes")
+	}
+	if len(details) == 0 {
+		return f.Path
+	}
+	return fmt.Sprintf("%s (%s)", f.Path, strings.Join(details, ", "))
+}
+
+// excerpt returns the lines around the selection or cursor as saved on disk.
+func (f editorFile) excerpt() string {
+	center := 0
+	switch {
+	case f.Selection != nil:
+		center = f.Selection.Start.Line
+	case f.Cursor != nil:
+		center = f.Cursor.Line
+	default:
+		return ""
+	}
+	data, err := os.ReadFile(f.Path)
+	if err != nil {
+		return ""
+	}
+	lines := strings.Split(string(data), "\n")
+	start := max(center-editorExcerptLines/2, 1)
+	end := min(start+editorExcerptLines-1, len(lines))
+	if f.Selection != nil && f.Selection.End.Line > end {
+		end = min(f.Selection.End.Line, start+2*editorExcerptLines-1, len(lines))
+	}
+	if start > end {
+		return ""
+	}
+	var b strings.Builder
+	for i := start; i <= end; i++ {
+		fmt.Fprintf(&b, "%4d  %s\n", i, lines[i-1])
+	}
+	return b.String()
+}
+
+// editorContextText renders the open-buffer signal appended to user messages.
+func editorContextText(states []editorState) string {
+	if len(states) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("\n\nEditor context (what the user has open right now; line numbers are 1-based):")
+	count := 0
+	for _, state := range states {
+		fmt.Fprintf(&b, "\n%s:", state.Editor)
+		for _, file := range state.Files {
+			if count == maxEditorFiles {
+				break
+			}
+			count++
+			b.WriteString("\n- " + file.describe())
+		}
+		for _, file := range state.Files {
+			if !file.Active {
+				continue
+			}
+			if excerpt := file.excerpt(); excerpt != "" {
+				fmt.Fprintf(&b, "\n\nFocused file %s on disk:\n```\n%s```", file.Path, excerpt)
+			}
+			break
+		}
+	}
+	return b.String()
+}
+
+// nextEditorContext returns the editor context for a new user message, or ""
+// when it is disabled, unavailable, or unchanged since the last message.
+func (m *model) nextEditorContext() string {
+	if m.editor.disabled {
+		return ""
+	}
+	states, err := loadEditorStates(".")
+	if err != nil {
+		return ""
+	}
+	text := editorContextText(states)
+	if text == m.editor.last {
+		return ""
+	}
+	m.editor.last = text
+	return text
+}
+
+func (m *model) cmdEditor(args string) tea.Cmd {
+	switch args {
+	case "off":
+		m.editor = editorContext{disabled: true}
+		m.notice = "Editor context off"
+		return nil
+	case "on":
+		m.editor = editorContext{}
+		m.notice = "Editor context on"
+		return nil
+	case "":
+	default:
+		m.notice = "Usage: /editor [on|off]"
+		return nil
+	}
+	states, err := loadEditorStates(".")
+	if err != nil {
+		m.lastErr = err
+		return nil
+	}
+	if len(states) == 0 {
+		m.notice = fmt.Sprintf("No editor has advertised open files in %s", editorStateDir)
+		return nil
+	}
+	var b strings.Builder
+	status := "on"
+	if m.editor.disabled {
+		status = "off"
+	}
+	fmt.Fprintf(&b, "Editor context (%s):", status)
+	for _, state := range states {
+		fmt.Fprintf(&b, "\n%s, updated %s ago", state.Editor, time.Since(state.UpdatedAt).Round(time.Second))
+		for _, file := range state.Files {
+			b.WriteString("\n  " + file.describe())
+		}
+	}
+	m.appendNote(b.String())
+	return nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// In --offline mode the only hosts codybot may connect to are the configured
+// inference endpoints. The check sits in the HTTP transport, so every request
+// is covered, including ones added later, not just the chat calls.
+
+// egressAllowlist returns the host:port pairs of the inference endpoints, or
+// nil when codybot is not offline.
+func (cfg config) egressAllowlist() []string {
+	if !cfg.Offline {
+		return nil
+	}
+	seen := map[string]bool{}
+	endpoints := []string{cfg.BaseURL}
+	if fallback, ok := cfg.fallbackConfig(); ok {
+		endpoints = append(endpoints, fallback.BaseURL)
+	}
+	var hosts []string
+	for _, endpoint := range endpoints {
+		u, err := url.Parse(endpoint)
+		if err != nil || u.Host == "" {
+			continue
+		}
+		if host := endpointHost(u); !seen[host] {
+			seen[host] = true
+			hosts = append(hosts, host)
+		}
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+// endpointHost is the lower-cased host:port a URL connects to.
+func endpointHost(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	return net.JoinHostPort(strings.ToLower(u.Hostname()), port)
+}
+
+type egressError struct {
+	host    string
+	allowed []string
+}
+
+func (e *egressError) Error() string {
+	return fmt.Sprintf("offline: connection to %s blocked; only %s is allowed", e.host, strings.Join(e.allowed, ", "))
+}
+
+// restrictEgress limits transport to the allowed hosts. Requests are checked
+// by URL, and dials by address, so neither a redirect nor an environment
+// proxy can reach anything else. An explicit --proxy is the one extra host
+// that may be dialed.
+func restrictEgress(transport *http.Transport, allowed []string, proxy string) http.RoundTripper {
+	dialable := map[string]bool{}
+	for _, host := range allowed {
+		dialable[host] = true
+	}
+	if proxy != "" {
+		if u, err := url.Parse(proxy); err == nil {
+			dialable[endpointHost(u)] = true
+		}
+	} else {
+		transport.Proxy = nil
+	}
+	dial := transport.DialContext
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		if key := net.JoinHostPort(strings.ToLower(host), port); !dialable[key] {
+			return nil, &egressError{host: key, allowed: allowed}
+		}
+		return dial(ctx, network, addr)
+	}
+	return egressGuard{next: transport, allowed: allowed}
+}
+
+type egressGuard struct {
+	next    http.RoundTripper
+	allowed []string
+}
+
+func (g egressGuard) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := endpointHost(req.URL)
+	for _, allowed := range g.allowed {
+		if host == allowed {
+			return g.next.RoundTrip(req)
+		}
+	}
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	return nil, &egressError{host: host, allowed: g.allowed}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+const (
+	maxExplainFiles     = 3
+	explainContextLines = 15
+	explainHeadLines    = 60
+)
+
+const explainPrompt = `The tool call below failed. Diagnose it: say what went wrong and the root cause, pointing to the file and line responsible, then propose the smallest fix as a diff. Read more files if you need to, but do not edit anything yet; I will ask you to apply the fix.`
+
+// failureLocation finds path:line references in compiler, test, and stack
+// trace output.
+var failureLocation = regexp.MustCompile(`([\w./\\-]+\.[A-Za-z0-9]+):(\d+)`)
+
+// toolFailure is the latest failed tool call, kept for /explain.
+type toolFailure struct {
+	call   toolCall
+	output string
+}
+
+// failure reports whether the call failed and its error output. A test run
+// that ran but failed counts, even though the tool itself succeeded.
+func (r toolResult) failure() (string, bool) {
+	if r.err != nil {
+		return r.err.Error(), true
+	}
+	if r.call.Function.Name == "run_tests" && strings.HasPrefix(r.output, "Tests failed") {
+		return r.output, true
+	}
+	return "", false
+}
+
+// failureContext excerpts the workspace files a failure points at: the lines
+// around each path:line in the output, or the head of the file the call
+// targeted.
+func failureContext(call toolCall, output string) string {
+	type location struct {
+		path string
+		line int
+	}
+	var locations []location
+	seen := map[string]bool{}
+	add := func(path string, line int) {
+		if path == "" || seen[path] || len(locations) >= maxExplainFiles {
+			return
+		}
+		seen[path] = true
+		locations = append(locations, location{path, line})
+	}
+	for _, match := range failureLocation.FindAllStringSubmatch(output, -1) {
+		line, _ := strconv.Atoi(match[2])
+		add(match[1], line)
+	}
+	var args struct {
+		Path string `json:"path"`
+	}
+	if json.Unmarshal([]byte(call.Function.Arguments), &args) == nil {
+		add(args.Path, 0)
+	}
+
+	var b strings.Builder
+	for _, loc := range locations {
+		path, err := resolveWorkspacePath(loc.path)
+		if err != nil {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil || bytes.IndexByte(data, 0) >= 0 || !utf8.Valid(data) {
+			continue
+		}
+		lines := strings.Split(string(data), "\n")
+		start, end := 1, min(len(lines), explainHeadLines)
+		if loc.line > 0 {
+			start, end = max(loc.line-explainContextLines, 1), min(loc.line+explainContextLines, len(lines))
+		}
+		if start > end {
+			continue
+		}
+		fmt.Fprintf(&b, "\n%s (lines %d-%d):\n```\n", loc.path, start, end)
+		for i := start; i <= end; i++ {
+			fmt.Fprintf(&b, "%4d  %s\n", i, lines[i-1])
+		}
+		b.WriteString("```\n")
+	}
+	return b.String()
+}
+
+// cmdExplain sends the latest tool failure, with the files it points at, to
+// the model for a diagnosis.
+func (m *model) cmdExplain(string) tea.Cmd {
+	if m.streaming || m.compacting {
+		m.notice = "Wait for the current response to finish"
+		return nil
+	}
+	failure := m.lastFailure
+	if failure == nil {
+		m.notice = "No failed tool call to explain"
+		return nil
+	}
+	m.lastFailure = nil
+	output, _ := truncateRunes(tailLines(failure.output, 120), maxToolOutputSize)
+	var b strings.Builder
+	b.WriteString(explainPrompt + "\n\nCall: " + failure.call.summary() + "\n\nOutput:\n```\n" + output + "\n```\n")
+	if files := failureContext(failure.call, failure.output); files != "" {
+		b.WriteString("\nRelevant files:" + files)
+	}
+	return m.send("Explain the failed "+failure.call.Function.Name+" call and propose a fix", b.String())
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// replayStep is one action of the session that changed the tree or ran a
+// command, in the order the agent took it.
+type replayStep struct {
+	prompt  string
+	patch   string
+	command string
+	// check marks commands whose failure should not stop the replay, like
+	// test runs the agent used to find its next edit.
+	check   bool
+	skipped string
+}
+
+// replaySteps pairs the session's successful tool calls with what they did:
+// writes with the patch the journal recorded, commands with the command
+// line. Writes that were undone or never reached disk are reported as
+// skipped.
+func (m *model) replaySteps(root string) []replayStep {
+	results := map[string]message{}
+	for _, msg := range m.history {
+		if msg.Role == "tool" {
+			results[msg.ToolCallID] = msg
+		}
+	}
+	checkpoints, applied := m.journal.snapshot()
+	var versions []*fileVersion
+	for i := range checkpoints[:applied] {
+		if checkpoints[i].Session != m.journal.session {
+			continue
+		}
+		for j := range checkpoints[i].Changes {
+			versions = append(versions, &checkpoints[i].Changes[j])
+		}
+	}
+	used := map[*fileVersion]bool{}
+
+	var steps []replayStep
+	prompt := ""
+	for _, msg := range m.history {
+		if msg.Role == "user" {
+			prompt, _, _ = strings.Cut(strings.TrimSpace(msg.Content), "\n")
+			prompt, _ = truncateRunes(prompt, 72)
+			continue
+		}
+		for _, call := range msg.ToolCalls {
+			result, ok := results[call.ID]
+			if !ok || strings.HasPrefix(result.Content, "error: ") {
+				continue
+			}
+			step := replayStep{prompt: prompt}
+			switch name := call.Function.Name; {
+			case name == "write_file":
+				var args struct {
+					Path    string `json:"path"`
+					Content string `json:"content"`
+				}
+				if json.Unmarshal([]byte(call.Function.Arguments), &args) != nil {
+					continue
+				}
+				path, err := resolveWorkspacePath(args.Path)
+				if err != nil {
+					continue
+				}
+				var version *fileVersion
+				for _, v := range versions {
+					if !used[v] && v.Path == path && bytes.Equal(v.After, matchLineEndings(v.Before, []byte(args.Content))) {
+						version = v
+						break
+					}
+				}
+				if version == nil {
+					step.skipped = "write to " + args.Path + " was undone or only staged"
+					break
+				}
+				used[version] = true
+				rel, err := filepath.Rel(root, version.Path)
+				if err != nil {
+					rel = version.Path
+				}
+				step.patch = unifiedDiff(filepath.ToSlash(rel), version.Before, version.After, version.Existed)
+				if step.patch == "" {
+					continue
+				}
+			case name == "delete_file":
+				var args struct {
+					Path string `json:"path"`
+				}
+				if json.Unmarshal([]byte(call.Function.Arguments), &args) != nil {
+					continue
+				}
+				step.command = "rm -- " + shellQuote(filepath.ToSlash(filepath.Clean(args.Path)))
+			case name == "run_tests":
+				step.command, step.check = m.cfg.TestCommand, true
+			default:
+				if m.tools == nil {
+					continue
+				}
+				spec, ok := m.tools.specs[name]
+				if !ok || spec.template == "" {
+					continue
+				}
+				var args map[string]any
+				if json.Unmarshal([]byte(call.Function.Arguments), &args) != nil {
+					continue
+				}
+				command, err := expandTemplate(spec.template, args)
+				if err != nil {
+					continue
+				}
+				step.command = command
+			}
+			steps = append(steps, step)
+		}
+	}
+	return steps
+}
+
+func countSteps(steps []replayStep) (patches, commands int) {
+	for _, s := range steps {
+		switch {
+		case s.patch != "":
+			patches++
+		case s.command != "":
+			commands++
+		}
+	}
+	return patches, commands
+}
+
+// replayScript renders the steps as a POSIX shell script that applies the
+// patches with git apply and runs the commands in between.
+func replayScript(steps []replayStep, session, model string) string {
+	var b strings.Builder
+	patches, commands := countSteps(steps)
+	fmt.Fprintf(&b, "#!/bin/sh\n# Replays codybot session %s (%s), exported %s:\n", session, model, time.Now().Format("2006-01-02 15:04"))
+	fmt.Fprintf(&b, "# %d patches and %d commands, in the order the agent ran them.\n", patches, commands)
+	b.WriteString("# Run it from the root of a checkout at the revision the session started from.\nset -e\n")
+	prompt := "\x00"
+	n := 0
+	for _, s := range steps {
+		if s.prompt != prompt {
+			prompt = s.prompt
+			fmt.Fprintf(&b, "\n# Prompt: %s\n", prompt)
+		}
+		switch {
+		case s.skipped != "":
+			fmt.Fprintf(&b, "# skipped: %s\n", s.skipped)
+		case s.patch != "":
+			n++
+			marker := fmt.Sprintf("CODYBOT_PATCH_%d", n)
+			fmt.Fprintf(&b, "git apply --whitespace=nowarn <<'%s'\n%s%s\n", marker, s.patch, marker)
+		case s.check:
+			fmt.Fprintf(&b, "%s || echo 'codybot: the check above failed; the session went on to fix it' >&2\n", s.command)
+		default:
+			b.WriteString(s.command + "\n")
+		}
+	}
+	return b.String()
+}
+
+func (m *model) cmdExportScript(args string) tea.Cmd {
+	if args == "" {
+		m.notice = "Usage: /export-script <path.sh|path.patch>"
+		return nil
+	}
+	root, err := workspaceRoot()
+	if err != nil {
+		m.lastErr = err
+		return nil
+	}
+	steps := m.replaySteps(root)
+	if len(steps) == 0 {
+		m.notice = "No applied edits or commands to export in this session"
+		return nil
+	}
+	path := filepath.Clean(args)
+	patches, commands := countSteps(steps)
+	var content string
+	mode := os.FileMode(0o755)
+	switch filepath.Ext(path) {
+	case ".patch", ".diff":
+		if patches == 0 {
+			m.notice = "The session applied no edits; export a .sh to keep its commands"
+			return nil
+		}
+		var b strings.Builder
+		for _, s := range steps {
+			b.WriteString(s.patch)
+		}
+		content, mode, commands = b.String(), 0o644, 0
+	default:
+		content = replayScript(steps, m.sessionID, m.cfg.Model)
+	}
+	if err := writeFileAtomic(path, []byte(content), mode); err != nil {
+		m.lastErr = fmt.Errorf("export-script: %w", err)
+		return nil
+	}
+	m.lastErr = nil
+	m.notice = fmt.Sprintf("Exported %d patches and %d commands to %s", patches, commands, path)
+	return nil
+}
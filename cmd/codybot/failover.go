@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const standbyProbeInterval = 30 * time.Second
+
+type providerHealth struct {
+	mu      sync.Mutex
+	checked time.Time
+	latency time.Duration
+	err     error
+}
+
+func (h *providerHealth) set(latency time.Duration, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.checked = time.Now()
+	h.latency = latency
+	h.err = err
+}
+
+func (h *providerHealth) summary() string {
+	if h == nil {
+		return ""
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	switch {
+	case h.checked.IsZero():
+		return "standby …"
+	case h.err != nil:
+		return "standby ✗"
+	}
+	return fmt.Sprintf("standby ✓ %dms", h.latency.Milliseconds())
+}
+
+func (cfg config) fallbackConfig() (config, bool) {
+	if strings.TrimSpace(cfg.FallbackBaseURL) == "" && strings.TrimSpace(cfg.FallbackModel) == "" {
+		return config{}, false
+	}
+	fb := cfg
+	fb.FallbackBaseURL, fb.FallbackModel, fb.FallbackAPIKey, fb.FallbackProvider = "", "", "", ""
+	fb.FallbackHeaders, fb.FallbackQueryParams = nil, nil
+	if cfg.FallbackBaseURL != "" {
+		fb.BaseURL = cfg.FallbackBaseURL
+		fb.APIKey, fb.APIKeyCommand, fb.apiKeyEnv = cfg.FallbackAPIKey, "", "CODYBOT_FALLBACK_API_KEY"
+		fb.tokens = nil
+		fb.Headers, fb.QueryParams = cfg.FallbackHeaders, cfg.FallbackQueryParams
+	}
+	if cfg.FallbackModel != "" {
+		fb.Model = cfg.FallbackModel
+	}
+	if cfg.FallbackProvider != "" {
+		fb.Provider = cfg.FallbackProvider
+	}
+	return fb, true
+}
+
+// shouldFailover reports whether an error from the primary provider means the
+// fallback is worth trying: transport failures, rate limits, and server errors.
+// Client errors such as a bad request would fail the same way on the fallback.
+func shouldFailover(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode == http.StatusTooManyRequests || apiErr.StatusCode >= 500
+	}
+	return true
+}
+
+// streamWithFailover forwards the primary stream unless it fails before the
+// first token, in which case the whole request is replayed on the fallback.
+// Secrets are masked once, before either endpoint sees the history.
+func streamWithFailover(ctx context.Context, cfg config, history []message, tools []Tool, ch chan<- streamMsg) {
+	history, omitted := fitContext(ctx, cfg, history, func(text string) { ch <- streamMsg{info: text} })
+	if omitted > 0 {
+		ch <- streamMsg{info: trimNotice(cfg, omitted), trimmed: omitted}
+	}
+	history, err := cfg.redactHistory(history, ch)
+	if err != nil {
+		ch <- streamMsg{err: err}
+		return
+	}
+	fallback, ok := cfg.fallbackConfig()
+	if !ok {
+		streamProvider(ctx, cfg, history, tools, ch)
+		return
+	}
+
+	primary := make(chan streamMsg)
+	go streamProvider(ctx, cfg, history, tools, primary)
+	first := <-primary
+	// Notices such as a rate limit pause come before the outcome is known.
+	for first.info != "" && first.token == "" && !first.done && first.err == nil {
+		ch <- first
+		first = <-primary
+	}
+	if shouldFailover(first.err) {
+		ch <- streamMsg{info: fmt.Sprintf("%s failed (%s); using fallback %s", cfg.Model, first.err, fallback.Model)}
+		streamProvider(ctx, fallback, history, tools, ch)
+		return
+	}
+	msg := first
+	for {
+		ch <- msg
+		if msg.done || msg.err != nil {
+			return
+		}
+		msg = <-primary
+	}
+}
+
+func probeProvider(ctx context.Context, cfg config) error {
+	url := strings.TrimRight(cfg.BaseURL, "/") + "/models"
+	if cfg.Provider == providerOllama {
+		url = ollamaBaseURL(cfg) + "/api/tags"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	if err := cfg.authorize(req); err != nil {
+		return err
+	}
+	setOpenRouterHeaders(req, cfg)
+	setRequestExtras(req, cfg)
+	client, err := httpClientFor(cfg)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode >= 300 {
+		return &apiError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+	return nil
+}
+
+// watchStandby keeps the fallback provider warm: the probe holds a pooled
+// keep-alive connection open (so failover skips DNS and TLS), and for Ollama
+// it also keeps the fallback model resident so failover skips the model load.
+func watchStandby(ctx context.Context, cfg config, health *providerHealth) {
+	ticker := time.NewTicker(standbyProbeInterval)
+	defer ticker.Stop()
+	for {
+		start := time.Now()
+		probeCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		err := probeProvider(probeCtx, cfg)
+		if err == nil && cfg.Provider == providerOllama {
+			err = setOllamaKeepAlive(probeCtx, cfg, "5m")
+		}
+		cancel()
+		health.set(time.Since(start), err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
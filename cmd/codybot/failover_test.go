@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestFailoverRedactsOnce fails the primary endpoint so the request is
+// replayed on the fallback, and checks that both saw the history masked and
+// the user was told about it once.
+func TestFailoverRedactsOnce(t *testing.T) {
+	t.Setenv("CODYBOT_HOME", t.TempDir())
+	t.Chdir(t.TempDir())
+	var mu sync.Mutex
+	var bodies []string
+	record := func(r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		bodies = append(bodies, string(data))
+		mu.Unlock()
+	}
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		record(r)
+		http.Error(w, `{"error": {"message": "overloaded"}}`, http.StatusServiceUnavailable)
+	}))
+	defer primary.Close()
+	fallback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		record(r)
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "data: {\"choices\": [{\"delta\": {\"content\": \"ok\"}, \"finish_reason\": \"stop\"}]}\n\ndata: [DONE]\n\n")
+	}))
+	defer fallback.Close()
+
+	cfg := config{Provider: providerOpenAI, BaseURL: primary.URL, Model: "primary", FallbackBaseURL: fallback.URL, FallbackModel: "fallback"}
+	secret := "AKIA" + "ABCDEFGHIJKLMNOP"
+	history := []message{{Role: "user", Content: "my key is " + secret}}
+	ch := make(chan streamMsg, 64)
+	go func() {
+		streamWithFailover(context.Background(), cfg, history, nil, ch)
+		close(ch)
+	}()
+	masked, answer := 0, ""
+	for msg := range ch {
+		if msg.err != nil {
+			t.Fatal(msg.err)
+		}
+		if strings.HasPrefix(msg.info, "Masked") {
+			masked++
+		}
+		answer += msg.token
+	}
+	if masked != 1 {
+		t.Errorf("masked notice shown %d times, want once", masked)
+	}
+	if answer != "ok" {
+		t.Errorf("answer %q, want the fallback's", answer)
+	}
+	if len(bodies) < 2 {
+		t.Fatalf("%d requests, want the primary's and the fallback's", len(bodies))
+	}
+	for i, body := range bodies {
+		if strings.Contains(body, secret) || !strings.Contains(body, "[REDACTED:aws-access-key]") {
+			t.Errorf("request %d was not masked once: %s", i, body)
+		}
+		if strings.Count(body, redactedPrefix) != 1 {
+			t.Errorf("request %d has %d placeholders, want 1", i, strings.Count(body, redactedPrefix))
+		}
+	}
+}
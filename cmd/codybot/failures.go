@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const maxProviderDetail = 200
+
+// failureKind is what went wrong with a request to the model, as far as the
+// user can do something about it.
+type failureKind int
+
+const (
+	failureAuth failureKind = iota + 1
+	failureModelNotFound
+	failureEndpointNotFound
+	failureContextOverflow
+	failureRateLimit
+	failureNetwork
+	failureProviderDown
+)
+
+func (k failureKind) label() string {
+	switch k {
+	case failureAuth:
+		return "authentication failed"
+	case failureModelNotFound:
+		return "model not found"
+	case failureEndpointNotFound:
+		return "no API at --base-url"
+	case failureContextOverflow:
+		return "context too long"
+	case failureRateLimit:
+		return "rate limited"
+	case failureNetwork:
+		return "endpoint unreachable"
+	}
+	return "provider unavailable"
+}
+
+// requestError is a failed request classified by kind. Its message is the
+// provider's explanation rather than the raw response body, and fix says
+// what to do next; the original error stays reachable through Unwrap.
+type requestError struct {
+	kind   failureKind
+	detail string
+	fix    string
+	err    error
+}
+
+func (e *requestError) Error() string {
+	return e.kind.label() + ": " + e.detail
+}
+
+func (e *requestError) Unwrap() error {
+	return e.err
+}
+
+// hint is the status bar version: the kind and the fix, without the detail
+// the transcript already shows.
+func (e *requestError) hint() string {
+	if e.fix == "" {
+		return e.kind.label()
+	}
+	return e.kind.label() + " — " + e.fix
+}
+
+// classifyError turns an error from a request to cfg's endpoint into a
+// *requestError. Errors it does not recognize, including cancellation, are
+// returned as they are.
+func classifyError(cfg config, err error) error {
+	var classified *requestError
+	if err == nil || errors.Is(err, context.Canceled) || errors.As(err, &classified) {
+		return err
+	}
+	var apiErr *apiError
+	if !errors.As(err, &apiErr) {
+		var urlErr *url.Error
+		var netErr net.Error
+		if !errors.As(err, &urlErr) && !errors.As(err, &netErr) {
+			return err
+		}
+		cause := err
+		if urlErr != nil {
+			cause = urlErr.Err
+		}
+		return &requestError{kind: failureNetwork, detail: fmt.Sprintf("%s: %v", cfg.BaseURL, cause), fix: unreachableFix(cfg), err: err}
+	}
+
+	detail := providerMessage(apiErr.Body)
+	body := strings.ToLower(apiErr.Body)
+	e := &requestError{err: err}
+	_, hasFallback := cfg.fallbackConfig()
+	switch {
+	case apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden:
+		e.kind = failureAuth
+		e.fix = fmt.Sprintf("check the key in %s or --api-key-command; /auth refresh reloads it", cfg.apiKeyEnvName())
+		if cfg.OAuthClientID != "" {
+			e.fix = "run codybot auth login again"
+		}
+	case apiErr.StatusCode == http.StatusRequestEntityTooLarge || containsAny(body, "context_length_exceeded", "maximum context length", "context length", "context window", "too many tokens", "prompt is too long", "input is too long"):
+		e.kind = failureContextOverflow
+		e.fix = "run /compact, or set --context-window to the model's limit so older turns are dropped first"
+	case containsAny(body, "model_not_found", "model not found", "unknown model", "invalid model", "no such model") ||
+		(strings.Contains(body, "model") && containsAny(body, "not found", "does not exist")):
+		e.kind = failureModelNotFound
+		e.fix = pullFix(cfg, "--model")
+		if detail == "" {
+			detail = fmt.Sprintf("%s does not serve %s", cfg.BaseURL, cfg.Model)
+		}
+	case apiErr.StatusCode == http.StatusNotFound:
+		e.kind = failureEndpointNotFound
+		e.fix = "check that --base-url ends in the API's version path, such as /v1, and --provider"
+		if detail == "" {
+			detail = cfg.BaseURL + " has no chat completions endpoint"
+		}
+	case apiErr.StatusCode == http.StatusTooManyRequests:
+		e.kind = failureRateLimit
+		switch {
+		case containsAny(body, "insufficient_quota", "quota", "billing", "credits"):
+			e.fix = "the account is out of quota or credits; check its billing"
+		case apiErr.RetryAfter > 0:
+			e.fix = "send again in " + formatSeconds(apiErr.RetryAfter)
+		default:
+			e.fix = "wait a moment and send again"
+		}
+		if !hasFallback {
+			e.fix += ", or set --fallback-model to fail over"
+		}
+		if detail == "" {
+			detail = "too many requests"
+		}
+	case apiErr.StatusCode >= 500:
+		e.kind = failureProviderDown
+		e.fix = "try again shortly"
+		if detail == "" {
+			detail = cfg.BaseURL + " failed to answer"
+		}
+		if !hasFallback {
+			e.fix += ", or set --fallback-base-url to fail over to another endpoint"
+		}
+	default:
+		return err
+	}
+	if detail == "" {
+		detail = "the endpoint refused the request"
+	}
+	e.detail = fmt.Sprintf("%s (%s)", detail, apiErr.Status)
+	return e
+}
+
+// providerMessage extracts the explanation from an error body: the message
+// of an OpenAI-style {"error": {"message": ...}}, Ollama's {"error": ...},
+// or the first line of a body that is not JSON.
+func providerMessage(body string) string {
+	var parsed struct {
+		Error   json.RawMessage `json:"error"`
+		Message string          `json:"message"`
+		Detail  string          `json:"detail"`
+	}
+	message := ""
+	if json.Unmarshal([]byte(body), &parsed) == nil {
+		var nested struct {
+			Message string `json:"message"`
+		}
+		switch {
+		case json.Unmarshal(parsed.Error, &message) == nil && message != "":
+		case json.Unmarshal(parsed.Error, &nested) == nil && nested.Message != "":
+			message = nested.Message
+		case parsed.Message != "":
+			message = parsed.Message
+		default:
+			message = parsed.Detail
+		}
+	} else {
+		message = firstLine(body)
+		if strings.HasPrefix(strings.TrimSpace(message), "<") {
+			// An HTML error page from a proxy says nothing useful.
+			message = ""
+		}
+	}
+	message, truncated := truncateRunes(strings.TrimSpace(message), maxProviderDetail)
+	if truncated {
+		message += "…"
+	}
+	return message
+}
+
+func containsAny(s string, substrings ...string) bool {
+	for _, sub := range substrings {
+		if strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
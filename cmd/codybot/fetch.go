@@ -0,0 +1,597 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// projectPolicyPath is the workspace's policy file; the org's is
+	// policy.json in the codybot home.
+	projectPolicyPath = ".codybot/policy.json"
+	defaultFetchMax   = 2 << 20
+	fetchTimeout      = 30 * time.Second
+	maxFetchRedirects = 10
+	fetchUserAgent    = "codybot"
+)
+
+// egressPolicy is the "egress" object of a policy file. It governs what
+// fetch_url may reach:
+//
+//	{"egress": {"allow": ["go.dev", "*.github.com"], "deny": ["internal.example.com"],
+//	  "private": ["10.1.0.0/16"], "max_bytes": 1048576, "forward_auth": false,
+//	  "respect_robots": true}}
+//
+// A domain also covers its subdomains; "*." covers only the subdomains. A
+// rule may also be an IP address or CIDR block. Private lists the loopback,
+// link-local, and private addresses fetch_url may reach, which are otherwise
+// refused however they are named.
+type egressPolicy struct {
+	Allow         []string `json:"allow"`
+	Deny          []string `json:"deny"`
+	Private       []string `json:"private"`
+	MaxBytes      int64    `json:"max_bytes"`
+	ForwardAuth   *bool    `json:"forward_auth"`
+	RespectRobots *bool    `json:"respect_robots"`
+}
+
+// fetchPolicy is the org and project policies combined. The project can
+// only narrow the org's: a host must pass every allow list and no deny list,
+// a private address every private list,
+// the smaller size limit applies, credential headers are only forwarded when
+// one file turns that on and neither turns it off, and robots.txt is obeyed
+// unless one file turns it off and neither turns it on.
+type fetchPolicy struct {
+	allow       [][]string
+	deny        []string
+	private     [][]string
+	maxBytes    int64
+	forwardAuth bool
+	robots      bool
+}
+
+// policyFile is an org or project policy file.
+type policyFile struct {
+	Egress   egressPolicy   `json:"egress"`
+	Redact   redactPolicy   `json:"redact"`
+	Commands commandsPolicy `json:"commands"`
+}
+
+func policyPaths() []string {
+	return []string{filepath.Join(codybotHome(), "policy.json"), projectPolicyPath}
+}
+
+// readPolicyFiles reads the org and then the project policy, skipping
+// missing files. A file that cannot be read or parsed is an error, so a
+// broken policy blocks what it governs instead of lifting its limits.
+func readPolicyFiles() ([]policyFile, error) {
+	var files []policyFile
+	for _, path := range policyPaths() {
+		data, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var file policyFile
+		if err := json.Unmarshal(data, &file); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+// loadFetchPolicy combines the egress sections of the policy files.
+func loadFetchPolicy() (fetchPolicy, error) {
+	policy := fetchPolicy{maxBytes: defaultFetchMax, robots: true}
+	files, err := readPolicyFiles()
+	if err != nil {
+		return policy, err
+	}
+	authOn, authOff := false, false
+	robotsOn, robotsOff := false, false
+	for _, file := range files {
+		e := file.Egress
+		if len(e.Allow) > 0 {
+			policy.allow = append(policy.allow, e.Allow)
+		}
+		policy.deny = append(policy.deny, e.Deny...)
+		if len(e.Private) > 0 {
+			policy.private = append(policy.private, e.Private)
+		}
+		if e.MaxBytes > 0 && e.MaxBytes < policy.maxBytes {
+			policy.maxBytes = e.MaxBytes
+		}
+		if e.ForwardAuth != nil {
+			authOn = authOn || *e.ForwardAuth
+			authOff = authOff || !*e.ForwardAuth
+		}
+		if e.RespectRobots != nil && *e.RespectRobots {
+			robotsOn = true
+		}
+		if e.RespectRobots != nil && !*e.RespectRobots {
+			robotsOff = true
+		}
+	}
+	policy.forwardAuth = authOn && !authOff
+	policy.robots = robotsOn || !robotsOff
+	return policy, nil
+}
+
+// check returns why host is blocked, or "" when it may be fetched. A name
+// is checked again by checkAddr for each address it resolves to.
+func (p fetchPolicy) check(host string) string {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	ip := net.ParseIP(host)
+	for _, pattern := range p.deny {
+		if ruleMatches(host, ip, pattern) {
+			return fmt.Sprintf("%s is denied (%s)", host, pattern)
+		}
+	}
+	for _, list := range p.allow {
+		if !listMatches(host, ip, list) {
+			return fmt.Sprintf("%s is not in the allow list (%s)", host, strings.Join(list, ", "))
+		}
+	}
+	if ip != nil {
+		return p.checkPrivate(host, ip)
+	}
+	return ""
+}
+
+// checkAddr returns why host may not be reached at ip, the address about to
+// be dialed, or "". It catches what names alone cannot: a denied name reached
+// through its address or another name for it, and a public name that
+// resolves to a private address.
+func (p fetchPolicy) checkAddr(ctx context.Context, host string, ip net.IP) string {
+	for _, pattern := range p.deny {
+		if addrMatches(ip, pattern) {
+			return fmt.Sprintf("%s is denied (%s)", describeAddr(host, ip), pattern)
+		}
+		if net.ParseIP(pattern) != nil || strings.Contains(pattern, "/") || strings.HasPrefix(strings.TrimSpace(pattern), "*.") {
+			continue
+		}
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, strings.TrimSpace(pattern))
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if addr.IP.Equal(ip) {
+				return fmt.Sprintf("%s is the address of %s, which is denied", describeAddr(host, ip), pattern)
+			}
+		}
+	}
+	return p.checkPrivate(host, ip)
+}
+
+// checkPrivate refuses loopback, link-local, and private addresses, such as
+// a cloud metadata service, unless every private list covers them.
+func (p fetchPolicy) checkPrivate(host string, ip net.IP) string {
+	if !privateAddr(ip) {
+		return ""
+	}
+	if len(p.private) == 0 {
+		return fmt.Sprintf("%s is a private address; list it under \"private\" in the egress policy to fetch it", describeAddr(host, ip))
+	}
+	for _, list := range p.private {
+		if !listMatches(host, ip, list) {
+			return fmt.Sprintf("%s is a private address not in the private list (%s)", describeAddr(host, ip), strings.Join(list, ", "))
+		}
+	}
+	return ""
+}
+
+// describeAddr names host and the address it resolved to.
+func describeAddr(host string, ip net.IP) string {
+	if net.ParseIP(host).Equal(ip) {
+		return host
+	}
+	return fmt.Sprintf("%s (%s)", host, ip)
+}
+
+func privateAddr(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsPrivate() || ip.IsUnspecified()
+}
+
+func listMatches(host string, ip net.IP, list []string) bool {
+	for _, pattern := range list {
+		if ruleMatches(host, ip, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// ruleMatches reports whether an egress rule covers host by name, or ip,
+// when known, by address.
+func ruleMatches(host string, ip net.IP, pattern string) bool {
+	return (ip != nil && addrMatches(ip, pattern)) || domainMatches(host, pattern)
+}
+
+// addrMatches reports whether pattern is ip or a CIDR block holding it.
+func addrMatches(ip net.IP, pattern string) bool {
+	pattern = strings.TrimSpace(pattern)
+	if _, block, err := net.ParseCIDR(pattern); err == nil {
+		return block.Contains(ip)
+	}
+	addr := net.ParseIP(strings.Trim(pattern, "[]"))
+	return addr != nil && addr.Equal(ip)
+}
+
+func domainMatches(host, pattern string) bool {
+	pattern = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(pattern), "."))
+	if pattern == "" {
+		return false
+	}
+	if sub, ok := strings.CutPrefix(pattern, "*."); ok {
+		return strings.HasSuffix(host, "."+sub)
+	}
+	return host == pattern || strings.HasSuffix(host, "."+pattern)
+}
+
+// credentialHeader reports headers that carry credentials, which fetch_url
+// only sends when the policy sets forward_auth.
+func credentialHeader(name string) bool {
+	switch strings.ToLower(name) {
+	case "authorization", "proxy-authorization", "cookie", "x-api-key", "api-key":
+		return true
+	}
+	return false
+}
+
+// fetcher backs fetch_url with the configured transport and a per-host cache
+// of robots.txt rules.
+type fetcher struct {
+	cfg    config
+	mu     sync.Mutex
+	robots map[string]robotsRules
+}
+
+func newFetcher(cfg config) *fetcher {
+	// --offline withholds fetch_url, so under it the tool only runs while
+	// /allow grants it, and the grant lifts the restriction for its own
+	// requests. The egress policy still applies.
+	cfg.Offline = false
+	return &fetcher{cfg: cfg, robots: map[string]robotsRules{}}
+}
+
+func fetchURLSource(raw json.RawMessage, _ string) string {
+	var args struct {
+		URL string `json:"url"`
+	}
+	if json.Unmarshal(raw, &args) != nil {
+		return ""
+	}
+	return args.URL
+}
+
+// toolFetchURL fetches a page as text under the egress policy. Every fetch,
+// including a blocked one, is recorded in the audit log.
+func (f *fetcher) toolFetchURL(ctx context.Context, _ *toolEnv, raw json.RawMessage) (string, error) {
+	var args struct {
+		URL     string            `json:"url"`
+		Headers map[string]string `json:"headers"`
+	}
+	if err := decodeArgs(raw, &args); err != nil {
+		return "", err
+	}
+	entry := auditEntry{At: time.Now(), Event: "fetch", Tool: "fetch_url", URL: args.URL}
+	output, err := f.fetch(ctx, args.URL, args.Headers, &entry)
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	if auditErr := appendAudit(entry); auditErr != nil && err == nil {
+		return "", fmt.Errorf("audit log: %w", auditErr)
+	}
+	return output, err
+}
+
+func (f *fetcher) fetch(ctx context.Context, rawURL string, headers map[string]string, entry *auditEntry) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("%q is not an http or https URL", rawURL)
+	}
+	policy, err := loadFetchPolicy()
+	if err != nil {
+		return "", fmt.Errorf("egress policy: %w", err)
+	}
+	if reason := policy.check(u.Hostname()); reason != "" {
+		return "", fmt.Errorf("egress policy: %s", reason)
+	}
+	client, err := f.client(policy)
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+	if policy.robots {
+		if err := f.robotsAllow(ctx, client, u); err != nil {
+			return "", err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("User-Agent", fetchUserAgent)
+	for name, value := range headers {
+		if credentialHeader(name) && !policy.forwardAuth {
+			entry.Withheld = append(entry.Withheld, name)
+			continue
+		}
+		req.Header.Set(name, value)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	entry.Status = resp.StatusCode
+	if resp.ContentLength > policy.maxBytes {
+		return "", fmt.Errorf("%s is %d bytes, over the policy's %d byte limit", u, resp.ContentLength, policy.maxBytes)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, policy.maxBytes+1))
+	entry.Bytes = len(data)
+	if err != nil {
+		return "", err
+	}
+	if int64(len(data)) > policy.maxBytes {
+		return "", fmt.Errorf("%s is over the policy's %d byte limit", u, policy.maxBytes)
+	}
+	contentType := resp.Header.Get("Content-Type")
+	if bytes.IndexByte(data, 0) >= 0 || !utf8.Valid(data) {
+		return "", fmt.Errorf("%s is binary (%s); only text can be fetched", u, contentType)
+	}
+	text := string(data)
+	if strings.Contains(contentType, "html") {
+		text = htmlText(text)
+	}
+	if resp.StatusCode >= 400 {
+		snippet, _ := truncateRunes(strings.TrimSpace(text), 500)
+		return "", fmt.Errorf("%s: %s\n%s", u, resp.Status, snippet)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s (%s)\n", resp.Status, resp.Request.URL, contentType)
+	if len(entry.Withheld) > 0 {
+		fmt.Fprintf(&b, "Not sent, as the egress policy does not forward credentials: %s\n", strings.Join(entry.Withheld, ", "))
+	}
+	b.WriteString("\n" + text)
+	return b.String(), nil
+}
+
+// client is the configured HTTP client, checking each redirect against the
+// policy too, and every address it dials other than a proxy's.
+func (f *fetcher) client(policy fetchPolicy) (*http.Client, error) {
+	base, err := httpClientFor(f.cfg)
+	if err != nil {
+		return nil, err
+	}
+	shared, ok := base.Transport.(*http.Transport)
+	if !ok {
+		return nil, fmt.Errorf("fetch_url cannot check addresses through %T", base.Transport)
+	}
+	transport := shared.Clone()
+	transport.DisableKeepAlives = true
+	var mu sync.Mutex
+	proxies := map[string]bool{}
+	if proxy := transport.Proxy; proxy != nil {
+		// A proxy resolves the names it is asked for itself, so only the
+		// names are checked for requests through one.
+		transport.Proxy = func(req *http.Request) (*url.URL, error) {
+			u, err := proxy(req)
+			if u != nil {
+				mu.Lock()
+				proxies[endpointHost(u)] = true
+				mu.Unlock()
+			}
+			return u, err
+		}
+	}
+	dial := transport.DialContext
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		mu.Lock()
+		proxied := proxies[strings.ToLower(addr)]
+		mu.Unlock()
+		if proxied {
+			return dial(ctx, network, addr)
+		}
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		// Every address is checked before any is dialed, and the one dialed
+		// is the one checked, so a second lookup cannot swap it.
+		for _, a := range addrs {
+			if reason := policy.checkAddr(ctx, strings.ToLower(host), a.IP); reason != "" {
+				return nil, fmt.Errorf("egress policy: %s", reason)
+			}
+		}
+		var firstErr error
+		for _, a := range addrs {
+			conn, err := dial(ctx, network, net.JoinHostPort(a.IP.String(), port))
+			if err == nil {
+				return conn, nil
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+		return nil, firstErr
+	}
+	client := *base
+	client.Transport = transport
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) >= maxFetchRedirects {
+			return fmt.Errorf("stopped after %d redirects", maxFetchRedirects)
+		}
+		if reason := policy.check(req.URL.Hostname()); reason != "" {
+			return fmt.Errorf("egress policy: redirect to %s: %s", req.URL, reason)
+		}
+		return nil
+	}
+	return &client, nil
+}
+
+var (
+	htmlDropped = regexp.MustCompile(`(?is)<(script|style|noscript|svg)\b.*?</(script|style|noscript|svg)>|<!--.*?-->`)
+	htmlBreaks  = regexp.MustCompile(`(?i)<br\s*/?>|</(p|div|li|tr|h[1-6]|pre|blockquote|section|article)>`)
+	htmlTags    = regexp.MustCompile(`(?s)<[^>]*>`)
+	blankRuns   = regexp.MustCompile(`\n{3,}`)
+)
+
+// htmlText reduces a page to its readable text, one block per line.
+func htmlText(page string) string {
+	page = htmlDropped.ReplaceAllString(page, "")
+	page = htmlBreaks.ReplaceAllString(page, "\n")
+	page = html.UnescapeString(htmlTags.ReplaceAllString(page, ""))
+	lines := strings.Split(page, "\n")
+	for i, line := range lines {
+		lines[i] = strings.Join(strings.Fields(line), " ")
+	}
+	return strings.TrimSpace(blankRuns.ReplaceAllString(strings.Join(lines, "\n"), "\n\n"))
+}
+
+// robotsRule is one Allow or Disallow line of robots.txt.
+type robotsRule struct {
+	allow   bool
+	pattern string
+	re      *regexp.Regexp
+}
+
+type robotsRules []robotsRule
+
+// parseRobots keeps the rules of the group for codybot, or of the * group
+// when none names it.
+func parseRobots(body string) robotsRules {
+	var own, wildcard robotsRules
+	ownGroup := false
+	var agents []string
+	inAgents := false
+	for _, line := range strings.Split(body, "\n") {
+		line, _, _ = strings.Cut(line, "#")
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key, value = strings.ToLower(strings.TrimSpace(key)), strings.TrimSpace(value)
+		switch key {
+		case "user-agent":
+			if !inAgents {
+				agents = nil
+			}
+			inAgents = true
+			agent := strings.ToLower(value)
+			agents = append(agents, agent)
+			if agent == fetchUserAgent {
+				ownGroup = true
+			}
+		case "allow", "disallow":
+			inAgents = false
+			if value == "" {
+				continue
+			}
+			rule := robotsRule{allow: key == "allow", pattern: value, re: robotsPattern(value)}
+			for _, agent := range agents {
+				switch agent {
+				case fetchUserAgent:
+					own = append(own, rule)
+				case "*":
+					wildcard = append(wildcard, rule)
+				}
+			}
+		}
+	}
+	if ownGroup {
+		return own
+	}
+	return wildcard
+}
+
+// robotsPattern matches a path prefix, with * for any run of characters and
+// a trailing $ anchoring the end.
+func robotsPattern(pattern string) *regexp.Regexp {
+	anchored := strings.HasSuffix(pattern, "$")
+	expr := strings.ReplaceAll(regexp.QuoteMeta(strings.TrimSuffix(pattern, "$")), `\*`, ".*")
+	if anchored {
+		expr += "$"
+	}
+	return regexp.MustCompile("^" + expr)
+}
+
+// allows applies the longest matching rule; Allow wins a tie.
+func (rules robotsRules) allows(path string) bool {
+	best, allowed := -1, true
+	for _, rule := range rules {
+		if !rule.re.MatchString(path) {
+			continue
+		}
+		if n := len(rule.pattern); n > best || (n == best && rule.allow) {
+			best, allowed = n, rule.allow
+		}
+	}
+	return allowed
+}
+
+// robotsAllow checks u against its host's robots.txt. A missing file allows
+// everything; one that cannot be fetched blocks the host, as RFC 9309 asks.
+func (f *fetcher) robotsAllow(ctx context.Context, client *http.Client, u *url.URL) error {
+	origin := u.Scheme + "://" + u.Host
+	f.mu.Lock()
+	rules, ok := f.robots[origin]
+	f.mu.Unlock()
+	if !ok {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, origin+"/robots.txt", nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("User-Agent", fetchUserAgent)
+		resp, err := client.Do(req)
+		if err != nil {
+			return fmt.Errorf("robots.txt for %s could not be fetched: %w", u.Host, err)
+		}
+		data, err := io.ReadAll(io.LimitReader(resp.Body, 512<<10))
+		resp.Body.Close()
+		switch {
+		case resp.StatusCode >= 500 || err != nil:
+			return fmt.Errorf("robots.txt for %s could not be fetched: %s", u.Host, resp.Status)
+		case resp.StatusCode < 300:
+			rules = parseRobots(string(data))
+		}
+		f.mu.Lock()
+		f.robots[origin] = rules
+		f.mu.Unlock()
+	}
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+	if !rules.allows(path) {
+		return fmt.Errorf("robots.txt for %s disallows %s", u.Host, path)
+	}
+	return nil
+}
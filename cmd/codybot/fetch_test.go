@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDomainMatches(t *testing.T) {
+	tests := []struct {
+		host    string
+		pattern string
+		want    bool
+	}{
+		{"example.com", "example.com", true},
+		{"docs.example.com", "example.com", true},
+		{"a.b.example.com", "example.com", true},
+		{"example.com", "Example.COM", true},
+		{"example.com", " example.com ", true},
+		{"example.com", "example.com.", true},
+		{"badexample.com", "example.com", false},
+		{"example.com.evil.net", "example.com", false},
+		{"example.org", "example.com", false},
+		{"docs.example.com", "*.example.com", true},
+		{"example.com", "*.example.com", false},
+		{"badexample.com", "*.example.com", false},
+		{"example.com", "", false},
+		{"example.com", "*.", false},
+	}
+	for _, tt := range tests {
+		if got := domainMatches(tt.host, tt.pattern); got != tt.want {
+			t.Errorf("domainMatches(%q, %q) = %v, want %v", tt.host, tt.pattern, got, tt.want)
+		}
+	}
+}
+
+func TestFetchPolicyCheck(t *testing.T) {
+	policy := fetchPolicy{
+		allow: [][]string{{"example.com", "*.github.com"}, {"example.com", "api.github.com"}},
+		deny:  []string{"internal.example.com"},
+	}
+	tests := []struct {
+		host    string
+		blocked string
+	}{
+		{host: "example.com"},
+		{host: "EXAMPLE.com."},
+		{host: "docs.example.com"},
+		{host: "api.github.com"},
+		{host: "internal.example.com", blocked: "denied"},
+		{host: "Internal.Example.com.", blocked: "denied"},
+		{host: "db.internal.example.com", blocked: "denied"},
+		// Every allow list must pass, so the project can only narrow the org's.
+		{host: "raw.github.com", blocked: "not in the allow list"},
+		{host: "github.com", blocked: "not in the allow list"},
+		{host: "example.org", blocked: "not in the allow list"},
+	}
+	for _, tt := range tests {
+		got := policy.check(tt.host)
+		if (got == "") != (tt.blocked == "") || !strings.Contains(got, tt.blocked) {
+			t.Errorf("check(%q) = %q, want %q", tt.host, got, tt.blocked)
+		}
+	}
+}
+
+func TestFetchPolicyCheckAddresses(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  fetchPolicy
+		host    string
+		blocked string
+	}{
+		{name: "public address", host: "93.184.216.34"},
+		{name: "loopback", host: "127.0.0.1", blocked: "private address"},
+		{name: "other loopback", host: "127.1.2.3", blocked: "private address"},
+		{name: "ipv6 loopback", host: "::1", blocked: "private address"},
+		{name: "metadata service", host: "169.254.169.254", blocked: "private address"},
+		{name: "private network", host: "10.1.2.3", blocked: "private address"},
+		{name: "unspecified", host: "0.0.0.0", blocked: "private address"},
+		{name: "ipv4-mapped loopback", host: "::ffff:127.0.0.1", blocked: "private address"},
+		{name: "private by block", policy: fetchPolicy{private: [][]string{{"10.0.0.0/8"}}}, host: "10.1.2.3"},
+		{name: "private by address", policy: fetchPolicy{private: [][]string{{"127.0.0.1"}}}, host: "127.0.0.1"},
+		{name: "outside the private list", policy: fetchPolicy{private: [][]string{{"10.0.0.0/8"}}}, host: "169.254.169.254", blocked: "not in the private list"},
+		{name: "every private list", policy: fetchPolicy{private: [][]string{{"10.0.0.0/8"}, {"10.1.0.0/16"}}}, host: "10.2.0.1", blocked: "not in the private list"},
+		{name: "denied address", policy: fetchPolicy{deny: []string{"93.184.216.34"}}, host: "93.184.216.34", blocked: "denied"},
+		{name: "denied block", policy: fetchPolicy{deny: []string{"93.184.0.0/16"}}, host: "93.184.216.34", blocked: "denied"},
+		{name: "allowed block", policy: fetchPolicy{allow: [][]string{{"93.184.0.0/16"}}}, host: "93.184.216.34"},
+		{name: "address outside the allow list", policy: fetchPolicy{allow: [][]string{{"example.com"}}}, host: "93.184.216.34", blocked: "not in the allow list"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.policy.check(tt.host)
+			if (got == "") != (tt.blocked == "") || !strings.Contains(got, tt.blocked) {
+				t.Errorf("check(%q) = %q, want %q", tt.host, got, tt.blocked)
+			}
+		})
+	}
+}
+
+// TestFetchDialedAddress fetches from a server on 127.0.0.1, by address and
+// by name, checking that the policy applies to the address dialed however
+// the URL names it, redirects included.
+func TestFetchDialedAddress(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/robots.txt":
+			w.WriteHeader(http.StatusNotFound)
+		case "/metadata":
+			http.Redirect(w, r, "http://169.254.169.254/latest/meta-data/", http.StatusFound)
+		case "/loopback6":
+			http.Redirect(w, r, "http://[::1]:1/", http.StatusFound)
+		default:
+			w.Write([]byte("page"))
+		}
+	}))
+	defer server.Close()
+	port := server.Listener.Addr().(*net.TCPAddr).Port
+	byAddr := fmt.Sprintf("http://127.0.0.1:%d", port)
+	byName := fmt.Sprintf("http://localhost:%d", port)
+	tests := []struct {
+		name   string
+		policy string
+		url    string
+		err    string
+	}{
+		{name: "private by default", policy: `{}`, url: byAddr + "/page", err: "private address"},
+		{name: "name for a private address", policy: `{}`, url: byName + "/page", err: "private address"},
+		{name: "private list", policy: `{"egress": {"private": ["127.0.0.1"]}}`, url: byAddr + "/page"},
+		{name: "private block", policy: `{"egress": {"private": ["127.0.0.0/8"]}}`, url: byAddr + "/page"},
+		{name: "address of a denied name", policy: `{"egress": {"private": ["127.0.0.0/8"], "deny": ["localhost"]}}`, url: byAddr + "/page", err: "the address of localhost, which is denied"},
+		{name: "name for a denied address", policy: `{"egress": {"private": ["127.0.0.0/8", "::1"], "deny": ["127.0.0.1"]}}`, url: byName + "/page", err: "denied (127.0.0.1)"},
+		{name: "redirect to the metadata service", policy: `{"egress": {"private": ["127.0.0.1"]}}`, url: byAddr + "/metadata", err: "169.254.169.254 is a private address"},
+		{name: "redirect to another loopback address", policy: `{"egress": {"private": ["127.0.0.1"]}}`, url: byAddr + "/loopback6", err: "not in the private list"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := fetchTestURL(t, newFetcher(config{}), tt.url, tt.policy)
+			if tt.err == "" {
+				if err != nil || !strings.HasSuffix(out, "page") {
+					t.Fatalf("fetch = %q, %v", out, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("fetch error = %v, want one mentioning %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestRobotsRules(t *testing.T) {
+	const robots = `# comment
+User-agent: *
+Disallow: /private
+Allow: /private/public
+Disallow: /*.pdf$
+
+User-agent: otherbot
+Disallow: /
+`
+	const own = robots + `
+User-agent: codybot
+User-agent: anotherbot
+Disallow: /no-codybot
+Disallow:
+`
+	tests := []struct {
+		name  string
+		body  string
+		path  string
+		allow bool
+	}{
+		{"empty", "", "/anything", true},
+		{"open path", robots, "/docs", true},
+		{"disallowed prefix", robots, "/private/key", false},
+		{"longer allow wins", robots, "/private/public/page", true},
+		{"wildcard and anchor", robots, "/files/report.pdf", false},
+		{"anchor only at end", robots, "/files/report.pdf.html", true},
+		{"other agent's rules ignored", robots, "/", true},
+		{"own group replaces *", own, "/private/key", true},
+		{"own group applies", own, "/no-codybot/page", false},
+		{"empty disallow ignored", own, "/", true},
+		{"case-insensitive keys", "USER-AGENT: *\nDISALLOW: /x", "/x", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseRobots(tt.body).allows(tt.path); got != tt.allow {
+				t.Errorf("allows(%q) = %v, want %v", tt.path, got, tt.allow)
+			}
+		})
+	}
+}
+
+func TestRobotsAllow(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		path   string
+		err    string
+	}{
+		{name: "allowed", status: http.StatusOK, body: "User-agent: *\nDisallow: /private", path: "/docs"},
+		{name: "disallowed", status: http.StatusOK, body: "User-agent: *\nDisallow: /private", path: "/private/x", err: "disallows /private/x"},
+		{name: "query counts", status: http.StatusOK, body: "User-agent: *\nDisallow: /search?q=", path: "/search?q=go", err: "disallows"},
+		{name: "missing file allows everything", status: http.StatusNotFound, path: "/private/x"},
+		{name: "server error blocks the host", status: http.StatusServiceUnavailable, path: "/docs", err: "could not be fetched"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fetches := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/robots.txt" {
+					fetches++
+					if ua := r.Header.Get("User-Agent"); ua != fetchUserAgent {
+						t.Errorf("robots.txt requested as %q, want %q", ua, fetchUserAgent)
+					}
+					w.WriteHeader(tt.status)
+					w.Write([]byte(tt.body))
+					return
+				}
+				w.Write([]byte("page"))
+			}))
+			defer server.Close()
+			f := newFetcher(config{})
+			for range 2 {
+				_, err := fetchTestURL(t, f, server.URL+tt.path)
+				if tt.err == "" && err != nil {
+					t.Fatalf("fetch: %v", err)
+				}
+				if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+					t.Fatalf("fetch error = %v, want one mentioning %q", err, tt.err)
+				}
+			}
+			// Rules are cached per host; a failed fetch is tried again.
+			want := 1
+			if tt.status >= 500 {
+				want = 2
+			}
+			if fetches != want {
+				t.Errorf("robots.txt fetched %d times, want %d", fetches, want)
+			}
+		})
+	}
+}
+
+// TestRobotsPolicyOff checks that a policy turning robots.txt off skips it.
+func TestRobotsPolicyOff(t *testing.T) {
+	fetched := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			fetched = true
+			w.Write([]byte("User-agent: *\nDisallow: /"))
+			return
+		}
+		w.Write([]byte("page"))
+	}))
+	defer server.Close()
+	policy := `{"egress": {"respect_robots": false, "private": ["127.0.0.1"]}}`
+	out, err := fetchTestURL(t, newFetcher(config{}), server.URL+"/docs", policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fetched || !strings.HasSuffix(out, "page") {
+		t.Errorf("robots.txt fetched = %v, output %q", fetched, out)
+	}
+}
+
+// fetchTestURL fetches rawURL from an empty workspace and codybot home, with
+// the project policy given, or else one letting it reach the test server.
+func fetchTestURL(t *testing.T, f *fetcher, rawURL string, policy ...string) (string, error) {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("CODYBOT_HOME", filepath.Join(dir, "home"))
+	t.Chdir(dir)
+	if len(policy) == 0 {
+		policy = []string{`{"egress": {"private": ["127.0.0.1"]}}`}
+	}
+	for _, p := range policy {
+		if err := writeFileAtomic(projectPolicyPath, []byte(p), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return f.fetch(context.Background(), rawURL, nil, &auditEntry{})
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// ansiSequence matches CSI styling plus the OSC, APC, and DCS strings used for
+// hyperlinks and inline images, so search never matches inside them.
+var ansiSequence = regexp.MustCompile("\x1b\\[[0-9;?]*[a-zA-Z]|\x1b[\\]_P][^\x07\x1b]*(?:\x07|\x1b\\\\)")
+
+type findState struct {
+	active  bool
+	query   string
+	pattern *regexp.Regexp
+	matches []int
+	current int
+}
+
+func stripANSI(s string) string {
+	return ansiSequence.ReplaceAllString(s, "")
+}
+
+func (m *model) cmdFind(args string) tea.Cmd {
+	if args == "" {
+		m.closeFind()
+		m.input.SetValue("/find ")
+		m.input.CursorEnd()
+		return nil
+	}
+	m.find = findState{
+		active:  true,
+		query:   args,
+		pattern: regexp.MustCompile("(?i)" + regexp.QuoteMeta(args)),
+	}
+	m.input.Blur()
+	m.refreshFind()
+	if len(m.find.matches) == 0 {
+		m.notice = fmt.Sprintf("No matches for %q", args)
+		m.closeFind()
+		return nil
+	}
+	m.find.current = len(m.find.matches) - 1
+	m.jumpToMatch()
+	return nil
+}
+
+func (m *model) closeFind() {
+	if !m.find.active {
+		return
+	}
+	m.find = findState{}
+	m.input.Focus()
+	m.setViewportContent(m.viewportContent())
+}
+
+func (m *model) refreshFind() {
+	m.find.matches = m.find.matches[:0]
+	for i, line := range strings.Split(m.transcriptText(), "\n") {
+		if m.find.pattern.MatchString(stripANSI(line)) {
+			m.find.matches = append(m.find.matches, i)
+		}
+	}
+	if m.find.current >= len(m.find.matches) {
+		m.find.current = max(len(m.find.matches)-1, 0)
+	}
+	m.setViewportContent(m.viewportContent())
+}
+
+func (m *model) jumpToMatch() {
+	if len(m.find.matches) == 0 {
+		return
+	}
+	m.setViewportContent(m.viewportContent())
+	line := m.find.matches[m.find.current]
+	m.viewport.SetYOffset(max(line-m.viewport.Height/2, 0))
+	m.notice = fmt.Sprintf("Match %d/%d for %q • n/N next/prev • v select from here • Esc to close", m.find.current+1, len(m.find.matches), m.find.query)
+}
+
+func (m *model) updateFindKeys(msg tea.KeyMsg) (bool, tea.Cmd) {
+	switch msg.String() {
+	case "n", "enter":
+		if len(m.find.matches) > 0 {
+			m.find.current = (m.find.current + 1) % len(m.find.matches)
+			m.jumpToMatch()
+		}
+		return true, nil
+	case "N", "shift+enter":
+		if len(m.find.matches) > 0 {
+			m.find.current = (m.find.current - 1 + len(m.find.matches)) % len(m.find.matches)
+			m.jumpToMatch()
+		}
+		return true, nil
+	case "esc":
+		m.closeFind()
+		m.notice = ""
+		return true, nil
+	case "ctrl+f":
+		m.closeFind()
+		return true, m.cmdFind("")
+	case "v":
+		if len(m.find.matches) == 0 {
+			return true, nil
+		}
+		at := textPos{line: m.find.matches[m.find.current]}
+		plain := stripANSI(strings.Split(m.transcriptText(), "\n")[at.line])
+		if loc := m.find.pattern.FindStringIndex(plain); loc != nil {
+			at.col = len([]rune(plain[:loc[0]]))
+		}
+		m.openSelection(at)
+		return true, nil
+	}
+	return false, nil
+}
+
+// viewportContent returns the transcript with search matches highlighted.
+// Highlighting only touches text between ANSI sequences so existing styling
+// is never split.
+func (m model) viewportContent() string {
+	if m.selection.active {
+		return m.selectionContent(m.transcriptText())
+	}
+	if !m.find.active || m.find.pattern == nil {
+		return m.transcriptText()
+	}
+	currentLine := -1
+	if len(m.find.matches) > 0 {
+		currentLine = m.find.matches[m.find.current]
+	}
+	lines := strings.Split(m.transcriptText(), "\n")
+	for _, i := range m.find.matches {
+		style := findMatchStyle
+		if i == currentLine {
+			style = findCurrentStyle
+		}
+		lines[i] = highlightOutsideANSI(lines[i], m.find.pattern, style)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func highlightOutsideANSI(line string, pattern *regexp.Regexp, style lipgloss.Style) string {
+	var b strings.Builder
+	last := 0
+	highlight := func(text string) {
+		b.WriteString(pattern.ReplaceAllStringFunc(text, func(match string) string {
+			return style.Render(match)
+		}))
+	}
+	for _, loc := range ansiSequence.FindAllStringIndex(line, -1) {
+		highlight(line[last:loc[0]])
+		b.WriteString(line[loc[0]:loc[1]])
+		last = loc[1]
+	}
+	highlight(line[last:])
+	return b.String()
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// perProcessFlags hold resources a forked codybot cannot share with the one
+// that started it, so they are dropped from the arguments it inherits.
+var perProcessFlags = map[string]bool{"resume": true, "control-socket": true, "web": true, "output": true, "o": true}
+
+// forkSession copies the conversation so far into a new session file and
+// returns its ID. The copy and the original continue independently.
+func (m *model) forkSession() (string, error) {
+	id := newSessionID()
+	title := m.sessionTitle
+	if title == "" {
+		title = firstPrompt(m.history)
+	}
+	err := saveSession(sessionFile{
+		ID:         id,
+		Title:      strings.TrimSpace(title + " (fork)"),
+		ForkedFrom: m.sessionID,
+		CreatedAt:  time.Now(),
+		Model:      m.cfg.Model,
+		BaseURL:    m.cfg.BaseURL,
+		Messages:   m.history,
+	})
+	return id, err
+}
+
+// resumeSession replaces the empty conversation with the saved session id,
+// keeping its ID so further turns are saved back to it.
+func (m *model) resumeSession(id string) error {
+	session, err := loadSession(filepath.Join(sessionsDir(), filepath.Base(id)+".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("no saved session %q in %s (codybot sessions list shows them)", id, sessionsDir())
+	}
+	if err != nil {
+		return err
+	}
+	if len(session.Messages) == 0 {
+		return fmt.Errorf("session %s has no messages", session.ID)
+	}
+	m.sessionID, m.sessionCreated = session.ID, session.CreatedAt
+	m.sessionTitle, m.forkedFrom = session.Title, session.ForkedFrom
+	// The system prompt is rebuilt from the current agents.md rather than
+	// replayed, like a fresh session's.
+	m.history = append([]message{m.system}, session.Messages...)
+	if session.Messages[0].Role == "system" {
+		m.history = append([]message{m.system}, session.Messages[1:]...)
+	}
+	for _, msg := range m.history[1:] {
+		switch {
+		case msg.Role == "user":
+			m.transcript.add(blockUser, msg.Content)
+		case msg.Role == "assistant" && strings.TrimSpace(msg.Content) != "":
+			m.transcript.add(blockAssistant, msg.Content)
+		}
+	}
+	note := fmt.Sprintf("Resumed session %s: %s", session.ID, sessionLabel(session))
+	if session.ForkedFrom != "" {
+		note += fmt.Sprintf(" (forked from %s, which continues separately)", session.ForkedFrom)
+	}
+	m.transcript.add(blockNote, note)
+	return nil
+}
+
+// forkArgs are the arguments that start a codybot on session id: this
+// process's own, minus the flags naming per-process resources.
+func forkArgs(id string) []string {
+	return append([]string{"--resume", id}, inheritedArgs(perProcessFlags)...)
+}
+
+// inheritedArgs are this process's chat arguments without the flags in drop
+// and their values.
+func inheritedArgs(drop map[string]bool) []string {
+	var args []string
+	inherited := os.Args[1:]
+	if len(inherited) > 0 && inherited[0] == "chat" {
+		inherited = inherited[1:]
+	}
+	for i := 0; i < len(inherited); i++ {
+		arg := inherited[i]
+		name, _, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !strings.HasPrefix(arg, "-") || !drop[name] {
+			args = append(args, arg)
+			continue
+		}
+		if !hasValue {
+			i++
+		}
+	}
+	return args
+}
+
+// windowCommand builds the multiplexer call that runs argv in a new tmux
+// window or zellij pane, in dir.
+func windowCommand(cfg config, dir, title string, argv []string) (*exec.Cmd, error) {
+	mux, err := detectMultiplexer(cfg.Multiplexer)
+	if err != nil {
+		return nil, err
+	}
+	if mux == multiplexerTmux {
+		quoted := make([]string, len(argv))
+		for i, arg := range argv {
+			quoted[i] = shellQuote(arg)
+		}
+		return exec.Command("tmux", "new-window", "-c", dir, "-n", title, strings.Join(quoted, " ")), nil
+	}
+	return exec.Command("zellij", append([]string{"run", "--close-on-exit", "--cwd", dir, "--name", title, "--"}, argv...)...), nil
+}
+
+func (m *model) cmdFork(args string) tea.Cmd {
+	open := false
+	switch strings.TrimSpace(args) {
+	case "":
+	case "open":
+		open = true
+	default:
+		m.notice = "Usage: /fork [open]"
+		return nil
+	}
+	if m.streaming {
+		m.notice = "Wait for the answer to finish, or stop it with Esc, before forking"
+		return nil
+	}
+	if len(m.history) <= 1 {
+		m.notice = "Nothing to fork yet; send a prompt first"
+		return nil
+	}
+	m.persistSession()
+	id, err := m.forkSession()
+	if err != nil {
+		m.lastErr = fmt.Errorf("fork: %w", err)
+		return nil
+	}
+	resume := "codybot --resume " + id
+	if !open {
+		m.appendNote(fmt.Sprintf("Forked this conversation into session %s. Run %s in another terminal to explore it there, or /fork open to start one in a new tmux window or zellij pane.", id, resume))
+		m.notice = "Forked into " + id
+		return nil
+	}
+	self, err := os.Executable()
+	if err != nil {
+		self = os.Args[0]
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		m.lastErr = err
+		return nil
+	}
+	cmd, err := windowCommand(m.cfg, dir, "codybot "+id, append([]string{self}, forkArgs(id)...))
+	if err == nil {
+		if output, runErr := cmd.CombinedOutput(); runErr != nil {
+			err = fmt.Errorf("%s: %w: %s", cmd.Args[0], runErr, strings.TrimSpace(string(output)))
+		}
+	}
+	if err != nil {
+		m.appendNote(fmt.Sprintf("Forked this conversation into session %s, but could not open it: %v. Run %s in another terminal instead.", id, err, resume))
+		return nil
+	}
+	where := "tmux window"
+	if cmd.Args[0] == multiplexerZellij {
+		where = "zellij pane"
+	}
+	m.appendNote(fmt.Sprintf("Forked this conversation into session %s and opened it in a new %s. The two continue separately.", id, where))
+	m.notice = "Forked into " + id
+	return nil
+}
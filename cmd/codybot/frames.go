@@ -0,0 +1,265 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/ansi"
+	"github.com/muesli/termenv"
+)
+
+// renderFrameCommand is the hidden debug command that prints the UI states
+// the golden frame tests check, and rewrites their golden files.
+const renderFrameCommand = "--render-frame"
+
+// frameGoldenDir is where the frame tests keep their golden files, relative
+// to cmd/codybot.
+const frameGoldenDir = "testdata/TestFrames"
+
+// frameSizes are the terminal sizes each scenario is rendered at: a common
+// default, a large window, and one short enough for the compact layout.
+var frameSizes = []frameSize{{80, 24}, {120, 40}, {60, 16}}
+
+type frameSize struct{ width, height int }
+
+func (s frameSize) String() string { return fmt.Sprintf("%dx%d", s.width, s.height) }
+
+// frameScenario is one UI state rendered for the golden tests. setup puts a
+// fresh model into the state directly rather than driving a real stream, so
+// frames do not depend on an endpoint, the clock, or the working directory.
+type frameScenario struct {
+	name  string
+	state appState
+	setup func(m *model)
+	// sizes overrides frameSizes.
+	sizes []frameSize
+}
+
+// frameEpoch anchors the stream timings in frames. The stream clocks are
+// stopped so elapsed times do not depend on when the frame is rendered.
+var frameEpoch = time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC)
+
+func frameStats(ttft, total time.Duration, chunks int) streamStats {
+	s := streamStats{start: frameEpoch, end: frameEpoch.Add(total), chunks: chunks}
+	if chunks > 0 {
+		s.firstToken = frameEpoch.Add(ttft)
+	}
+	return s
+}
+
+func frameConversation(m *model) {
+	prompt := "Add a --verbose flag to the CLI"
+	answer := "I added the flag and wired it into the logger:\n\n```go\nfs.BoolVar(&cfg.Verbose, \"verbose\", false, \"Log every request\")\n```\n\nRun `go test ./...` to check it."
+	m.history = append(m.history,
+		message{Role: "user", Content: prompt},
+		message{Role: "assistant", Content: answer},
+	)
+	m.transcript.add(blockUser, prompt)
+	m.transcript.add(blockTool, "[tool] read_file main.go\n  ✓ package main\n")
+	m.transcript.add(blockAssistant, answer)
+}
+
+var frameScenarios = []frameScenario{
+	{name: "setup", state: stateSetup},
+	{name: "trust", state: stateTrust},
+	{name: "chat-empty", state: stateChat},
+	{name: "chat", state: stateChat, setup: func(m *model) {
+		frameConversation(m)
+		m.stats = frameStats(800*time.Millisecond, 3200*time.Millisecond, 42)
+	}},
+	{name: "streaming-waiting", state: stateChat, setup: func(m *model) {
+		prompt := "Why does the build fail on Windows?"
+		m.history = append(m.history, message{Role: "user", Content: prompt})
+		m.transcript.add(blockUser, prompt)
+		m.transcript.add(blockAssistant, "")
+		m.streaming = true
+		m.stats = frameStats(0, 1500*time.Millisecond, 0)
+	}},
+	{name: "streaming", state: stateChat, setup: func(m *model) {
+		frameConversation(m)
+		prompt := "Now document it in the README"
+		m.history = append(m.history, message{Role: "user", Content: prompt})
+		m.transcript.add(blockUser, prompt)
+		m.transcript.add(blockAssistant, "I'll add a row to the flags table under")
+		m.streaming = true
+		m.stats = frameStats(600*time.Millisecond, 2100*time.Millisecond, 9)
+	}},
+	{name: "error", state: stateChat, setup: func(m *model) {
+		prompt := "Summarize the open TODOs"
+		m.history = append(m.history, message{Role: "user", Content: prompt})
+		m.transcript.add(blockUser, prompt)
+		err := classifyError(m.cfg, &apiError{StatusCode: 401, Status: "401 Unauthorized", Body: `{"error":{"message":"invalid api key"}}`})
+		m.transcript.add(blockError, err.Error())
+		m.lastErr = err
+	}},
+	{name: "tool-failure", state: stateChat, setup: func(m *model) {
+		frameConversation(m)
+		m.transcript.add(blockTool, "[tool] run_tests\n  ✗ exit status 1\n")
+		m.lastFailure = &toolFailure{output: "--- FAIL: TestVerbose (0.00s)"}
+		m.notice = "Tests failed"
+	}},
+	{name: "too-small", state: stateChat, sizes: []frameSize{{28, 7}}},
+}
+
+// prepareFrames makes rendering reproducible: a fixed theme and no colors,
+// whatever the terminal running the tests or the command supports.
+func prepareFrames() error {
+	lipgloss.SetColorProfile(termenv.Ascii)
+	t, err := loadTheme("dark")
+	if err != nil {
+		return err
+	}
+	applyTheme(t)
+	return nil
+}
+
+// frameModel returns the model of a scenario before it is sized.
+func frameModel(s frameScenario) model {
+	cfg := config{
+		BaseURL:       defaultBaseURL,
+		Model:         defaultModel,
+		AgentPath:     "agents.md",
+		ContextWindow: defaultContextWindow,
+		TestAttempts:  defaultTestAttempts,
+		NoTools:       true,
+		NoTips:        true,
+	}
+	m := newModel(cfg, "", s.state)
+	// newModel reports an interrupted edit journal in the working directory;
+	// frames must not.
+	m.transcript, m.notice = newTranscript(), ""
+	m.workspace = "/home/dev/project"
+	if s.setup != nil {
+		s.setup(&m)
+	}
+	return m
+}
+
+// renderFrame renders the model at size as plain text. Styles are stripped:
+// the goldens pin layout and content, and the themes are checked elsewhere.
+func renderFrame(m tea.Model, size frameSize) string {
+	m, _ = m.Update(tea.WindowSizeMsg{Width: size.width, Height: size.height})
+	return plainFrame(m.View())
+}
+
+func plainFrame(view string) string {
+	lines := strings.Split(ansi.Strip(view), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " ")
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func findFrameScenario(name string) (frameScenario, bool) {
+	for _, s := range frameScenarios {
+		if s.name == name {
+			return s, true
+		}
+	}
+	return frameScenario{}, false
+}
+
+func (s frameScenario) frameSizes() []frameSize {
+	if s.sizes != nil {
+		return s.sizes
+	}
+	return frameSizes
+}
+
+func parseFrameSize(text string) (frameSize, error) {
+	w, h, ok := strings.Cut(text, "x")
+	width, err1 := strconv.Atoi(w)
+	height, err2 := strconv.Atoi(h)
+	if !ok || err1 != nil || err2 != nil || width <= 0 || height <= 0 {
+		return frameSize{}, fmt.Errorf("size must look like 80x24, got %q", text)
+	}
+	return frameSize{width, height}, nil
+}
+
+// runRenderFrameCommand prints a scenario's frame, lists the scenarios, or
+// with --write rewrites every golden file the frame tests compare against.
+func runRenderFrameCommand(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("codybot --render-frame", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	size := fs.String("size", "", "Terminal size to render at, e.g. 80x24 (default: every size the tests use)")
+	write := fs.String("write", "", "Write each frame to <dir>/<scenario>-<size>.golden instead of printing it, e.g. "+frameGoldenDir)
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: codybot --render-frame <scenario|all|list> [--size WxH] [--write dir]")
+		fs.PrintDefaults()
+	}
+	if len(args) == 0 {
+		fs.Usage()
+		return 2
+	}
+	name := args[0]
+	if err := fs.Parse(args[1:]); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+	if name == "list" {
+		for _, s := range frameScenarios {
+			sizes := make([]string, 0, len(s.frameSizes()))
+			for _, size := range s.frameSizes() {
+				sizes = append(sizes, size.String())
+			}
+			fmt.Fprintf(stdout, "%-18s %s\n", s.name, strings.Join(sizes, " "))
+		}
+		return 0
+	}
+	scenarios := frameScenarios
+	if name != "all" {
+		s, ok := findFrameScenario(name)
+		if !ok {
+			fmt.Fprintf(stderr, "codybot: unknown frame scenario %q; --render-frame list shows them\n", name)
+			return 2
+		}
+		scenarios = []frameScenario{s}
+	}
+	var only *frameSize
+	if *size != "" {
+		parsed, err := parseFrameSize(*size)
+		if err != nil {
+			fmt.Fprintf(stderr, "codybot: --size: %v\n", err)
+			return 2
+		}
+		only = &parsed
+	}
+	if err := prepareFrames(); err != nil {
+		fmt.Fprintf(stderr, "codybot: %v\n", err)
+		return 1
+	}
+	for _, s := range scenarios {
+		sizes := s.frameSizes()
+		if only != nil {
+			sizes = []frameSize{*only}
+		}
+		for _, size := range sizes {
+			frame := renderFrame(frameModel(s), size)
+			if *write == "" {
+				fmt.Fprintf(stdout, "── %s %s\n%s", s.name, size, frame)
+				continue
+			}
+			path := filepath.Join(*write, s.name+"-"+size.String()+".golden")
+			if err := os.MkdirAll(*write, 0o755); err != nil {
+				fmt.Fprintf(stderr, "codybot: %v\n", err)
+				return 1
+			}
+			if err := os.WriteFile(path, []byte(frame), 0o644); err != nil {
+				fmt.Fprintf(stderr, "codybot: %v\n", err)
+				return 1
+			}
+			fmt.Fprintln(stdout, path)
+		}
+	}
+	return 0
+}
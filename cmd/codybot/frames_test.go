@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/x/exp/golden"
+	"github.com/charmbracelet/x/exp/teatest"
+)
+
+// frameHarness runs a scenario's model in a real program but drops the
+// commands it returns, so no spinner tick, git poll, or stream changes the
+// frame between runs.
+type frameHarness struct{ model }
+
+func (h frameHarness) Init() tea.Cmd { return nil }
+
+func (h frameHarness) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	m, _ := h.model.Update(msg)
+	return frameHarness{m.(model)}, nil
+}
+
+// TestFrames compares every scenario at every size with its golden file in
+// testdata/TestFrames. After an intended UI change, regenerate them with
+// go test -run TestFrames -update, or codybot --render-frame all --write
+// testdata/TestFrames, and review the diff.
+func TestFrames(t *testing.T) {
+	if err := prepareFrames(); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range frameScenarios {
+		for _, size := range s.frameSizes() {
+			t.Run(s.name+"-"+size.String(), func(t *testing.T) {
+				tm := teatest.NewTestModel(t, frameHarness{frameModel(s)}, teatest.WithInitialTermSize(size.width, size.height))
+				tm.Quit()
+				final := tm.FinalModel(t, teatest.WithFinalTimeout(5*time.Second))
+				frame := plainFrame(final.View())
+				golden.RequireEqual(t, []byte(frame))
+				if direct := renderFrame(frameModel(s), size); direct != frame {
+					t.Errorf("--render-frame output differs from the program's frame:\n%s", direct)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+const (
+	defaultGrantDuration = 10 * time.Minute
+	maxGrantDuration     = 24 * time.Hour
+	// auditLogPath records every temporary grant and each call made under
+	// one, and every fetch_url request, relative to the workspace.
+	auditLogPath = ".codybot/audit.jsonl"
+)
+
+type auditEntry struct {
+	At time.Time `json:"at"`
+	// Event is grant, revoke, expire, use, or fetch.
+	Event string `json:"event"`
+	Tool  string `json:"tool,omitempty"`
+	// Command is the command prefix of a command grant.
+	Command string `json:"command,omitempty"`
+	// Overrides is the flag the grant lifts.
+	Overrides string     `json:"overrides,omitempty"`
+	Until     *time.Time `json:"until,omitempty"`
+	// Call summarizes a call made under the grant.
+	Call string `json:"call,omitempty"`
+	// URL, Status, and Bytes describe a fetch; Withheld names the credential
+	// headers the egress policy kept back, and Error why it failed or was
+	// blocked.
+	URL      string   `json:"url,omitempty"`
+	Status   int      `json:"status,omitempty"`
+	Bytes    int      `json:"bytes,omitempty"`
+	Withheld []string `json:"withheld_headers,omitempty"`
+	Error    string   `json:"error,omitempty"`
+}
+
+func appendAudit(entry auditEntry) error {
+	r := logRedactor()
+	entry.Call, _ = r.redact(entry.Call)
+	entry.URL, _ = r.redact(entry.URL)
+	entry.Error, _ = r.redact(entry.Error)
+	if err := os.MkdirAll(filepath.Dir(auditLogPath), 0o755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(auditLogPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+// grantExpiredMsg fires when a grant's time is up. It names a tool or, for a
+// command grant, a command prefix.
+type grantExpiredMsg struct {
+	tool    string
+	command string
+	until   time.Time
+}
+
+// grant re-enables a tool a mode withheld until the returned time.
+func (r *toolRegistry) grant(name string, d time.Duration) (time.Time, error) {
+	if _, ok := r.withheld[name]; !ok {
+		if _, ok := r.specs[name]; ok {
+			return time.Time{}, fmt.Errorf("%s is already enabled", name)
+		}
+		if by, ok := r.disabled[name]; ok {
+			return time.Time{}, fmt.Errorf("%s is disabled by %s, which /allow cannot lift", name, by)
+		}
+		return time.Time{}, fmt.Errorf("unknown tool %q (withheld: %s)", name, strings.Join(r.withheldNames(), ", "))
+	}
+	until := time.Now().Add(d)
+	r.grantMu.Lock()
+	r.grants[name] = until
+	r.grantMu.Unlock()
+	return until, appendAudit(auditEntry{At: time.Now(), Event: "grant", Tool: name, Overrides: r.disabled[name], Until: &until})
+}
+
+// grantCommand exempts the commands prefix covers from the strict command
+// policy until the returned time.
+func (r *toolRegistry) grantCommand(prefix string, d time.Duration) (time.Time, error) {
+	until := time.Now().Add(d)
+	r.grantMu.Lock()
+	r.commandGrants[prefix] = until
+	r.grantMu.Unlock()
+	return until, appendAudit(auditEntry{At: time.Now(), Event: "grant", Command: prefix, Overrides: "--command-policy strict", Until: &until})
+}
+
+// revokeCommand ends a command grant early, reporting whether there was one.
+func (r *toolRegistry) revokeCommand(prefix, event string) bool {
+	r.grantMu.Lock()
+	_, ok := r.commandGrants[prefix]
+	delete(r.commandGrants, prefix)
+	r.grantMu.Unlock()
+	if ok {
+		appendAudit(auditEntry{At: time.Now(), Event: event, Command: prefix, Overrides: "--command-policy strict"})
+	}
+	return ok
+}
+
+// grantedCommand returns the prefix of a live command grant that covers
+// command, or false.
+func (r *toolRegistry) grantedCommand(command string) (string, bool) {
+	r.grantMu.Lock()
+	defer r.grantMu.Unlock()
+	for prefix, until := range r.commandGrants {
+		if time.Now().Before(until) && grantCovers(prefix, command) {
+			return prefix, true
+		}
+	}
+	return "", false
+}
+
+// grantCovers reports whether a grant for prefix covers command: a single
+// command whose first words are the prefix's. Anything that could run a
+// second command or overwrite a file on the side, such as ;, &&, |, $(...),
+// or >, puts a command outside every grant.
+func grantCovers(prefix, command string) bool {
+	if strings.ContainsAny(command, ";&|`>\n") || strings.Contains(command, "$(") {
+		return false
+	}
+	want, words := strings.Fields(prefix), strings.Fields(command)
+	if len(words) < len(want) || len(want) == 0 {
+		return false
+	}
+	words[0] = filepath.Base(words[0])
+	for i := range want {
+		if words[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// revoke ends a grant early, reporting whether there was one.
+func (r *toolRegistry) revoke(name, event string) bool {
+	r.grantMu.Lock()
+	_, ok := r.grants[name]
+	delete(r.grants, name)
+	r.grantMu.Unlock()
+	if ok {
+		appendAudit(auditEntry{At: time.Now(), Event: event, Tool: name, Overrides: r.disabled[name]})
+	}
+	return ok
+}
+
+// granted returns the withheld spec for name while a grant for it lasts.
+func (r *toolRegistry) granted(name string) (toolSpec, bool) {
+	r.grantMu.Lock()
+	until, ok := r.grants[name]
+	r.grantMu.Unlock()
+	if !ok || time.Now().After(until) {
+		return toolSpec{}, false
+	}
+	return r.withheld[name], true
+}
+
+// activeGrants lists the granted tools and commands with their expiry,
+// soonest first. Commands are quoted, as in `rm`.
+func (r *toolRegistry) activeGrants() []string {
+	if r == nil {
+		return nil
+	}
+	r.grantMu.Lock()
+	defer r.grantMu.Unlock()
+	type active struct {
+		name  string
+		until time.Time
+	}
+	var grants []active
+	for name, until := range r.grants {
+		if time.Now().Before(until) {
+			grants = append(grants, active{name, until})
+		}
+	}
+	for prefix, until := range r.commandGrants {
+		if time.Now().Before(until) {
+			grants = append(grants, active{"`" + prefix + "`", until})
+		}
+	}
+	sort.Slice(grants, func(i, j int) bool {
+		if !grants[i].until.Equal(grants[j].until) {
+			return grants[i].until.Before(grants[j].until)
+		}
+		return grants[i].name < grants[j].name
+	})
+	names := make([]string, len(grants))
+	for i, g := range grants {
+		names[i] = fmt.Sprintf("%s until %s", g.name, g.until.Format("15:04"))
+	}
+	return names
+}
+
+// isTool reports whether name is one of the registry's tools, enabled or
+// not, as opposed to a command for /allow.
+func (r *toolRegistry) isTool(name string) bool {
+	_, enabled := r.specs[name]
+	_, withheld := r.withheld[name]
+	_, disabled := r.disabled[name]
+	return enabled || withheld || disabled
+}
+
+func (r *toolRegistry) withheldNames() []string {
+	names := make([]string, 0, len(r.withheld))
+	for name := range r.withheld {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (m *model) cmdAllow(args string) tea.Cmd {
+	if m.tools == nil {
+		m.notice = "Tools are disabled for this session; /allow only lifts --read-only and --offline"
+		return nil
+	}
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		grants := m.tools.activeGrants()
+		switch {
+		case len(grants) > 0:
+			m.notice = "Granted: " + strings.Join(grants, ", ")
+		case len(m.tools.withheld) == 0:
+			m.notice = "No grants; /allow lifts --read-only and --offline for a tool, or strict's block for a command, for a while"
+		default:
+			m.notice = "No grants • withheld: " + strings.Join(m.tools.withheldNames(), ", ")
+		}
+		return nil
+	}
+	if fields[0] == "revoke" {
+		if len(fields) < 2 {
+			m.notice = "Usage: /allow revoke <tool|command|all>"
+			return nil
+		}
+		target := strings.Join(fields[1:], " ")
+		revoked := 0
+		for _, name := range m.tools.withheldNames() {
+			if (target == "all" || target == name) && m.tools.revoke(name, "revoke") {
+				revoked++
+			}
+		}
+		m.tools.grantMu.Lock()
+		var prefixes []string
+		for prefix := range m.tools.commandGrants {
+			prefixes = append(prefixes, prefix)
+		}
+		m.tools.grantMu.Unlock()
+		for _, prefix := range prefixes {
+			if (target == "all" || target == prefix) && m.tools.revokeCommand(prefix, "revoke") {
+				revoked++
+			}
+		}
+		if revoked == 0 {
+			m.notice = "No grant to revoke for " + target
+			return nil
+		}
+		m.appendNote(fmt.Sprintf("Revoked %s; the model no longer has it.", target))
+		return nil
+	}
+
+	d := defaultGrantDuration
+	var target []string
+	for i := 0; i < len(fields); i++ {
+		if fields[i] != "--for" {
+			target = append(target, fields[i])
+			continue
+		}
+		if i+1 == len(fields) {
+			m.notice = "--for takes a duration like 10m or 1h"
+			return nil
+		}
+		i++
+		var err error
+		if d, err = time.ParseDuration(fields[i]); err != nil || d <= 0 {
+			m.notice = fmt.Sprintf("--for takes a duration like 10m or 1h, got %q", fields[i])
+			return nil
+		}
+	}
+	if len(target) == 0 {
+		m.notice = "Usage: /allow <tool|command> [--for 10m] • /allow revoke <tool|command|all>"
+		return nil
+	}
+	if d > maxGrantDuration {
+		m.notice = fmt.Sprintf("Grants last at most %s; restart without the flag for longer", maxGrantDuration)
+		return nil
+	}
+	if len(target) > 1 || !m.tools.isTool(target[0]) {
+		return m.allowCommand(strings.Join(target, " "), d)
+	}
+	name := target[0]
+	until, err := m.tools.grant(name, d)
+	if err != nil && until.IsZero() {
+		m.notice = err.Error()
+		return nil
+	}
+	note := fmt.Sprintf("Allowed %s until %s, overriding %s. Each call is logged to %s; /allow revoke %s ends it early.", name, until.Format("15:04:05"), m.tools.disabled[name], auditLogPath, name)
+	if err != nil {
+		note += fmt.Sprintf(" (audit log: %v)", err)
+	}
+	m.appendNote(note)
+	m.notice = fmt.Sprintf("Allowed %s until %s", name, until.Format("15:04:05"))
+	return tea.Tick(time.Until(until), func(time.Time) tea.Msg {
+		return grantExpiredMsg{tool: name, until: until}
+	})
+}
+
+// allowCommand exempts the session tool commands starting with prefix from
+// the strict command policy's block for d. Under the other policies nothing
+// is blocked, so there is nothing to lift.
+func (m *model) allowCommand(prefix string, d time.Duration) tea.Cmd {
+	if policy := commandPolicy(m.cfg.CommandPolicy); policy != commandsStrict {
+		m.notice = fmt.Sprintf("The command policy is %s, which blocks no commands; /allow <command> lifts strict's block", policy)
+		return nil
+	}
+	if strings.ContainsAny(prefix, ";&|`>") || strings.Contains(prefix, "$(") {
+		m.notice = "Allow one command, such as rm or git push, without ;, &&, |, or >"
+		return nil
+	}
+	until, err := m.tools.grantCommand(prefix, d)
+	note := fmt.Sprintf("Allowed `%s` commands until %s, overriding the strict command policy. They are still shown for approval, and each one run is logged to %s; /allow revoke %s ends it early.", prefix, until.Format("15:04:05"), auditLogPath, prefix)
+	if err != nil {
+		note += fmt.Sprintf(" (audit log: %v)", err)
+	}
+	m.appendNote(note)
+	m.notice = fmt.Sprintf("Allowed `%s` until %s", prefix, until.Format("15:04:05"))
+	return tea.Tick(time.Until(until), func(time.Time) tea.Msg {
+		return grantExpiredMsg{command: prefix, until: until}
+	})
+}
+
+// handleGrantExpiredMsg ends a grant once its time is up, unless it was
+// revoked or granted again meanwhile.
+func (m model) handleGrantExpiredMsg(msg grantExpiredMsg) (tea.Model, tea.Cmd) {
+	if m.tools == nil {
+		return m, nil
+	}
+	if msg.command != "" {
+		m.tools.grantMu.Lock()
+		current, ok := m.tools.commandGrants[msg.command]
+		m.tools.grantMu.Unlock()
+		if ok && current.Equal(msg.until) {
+			m.tools.revokeCommand(msg.command, "expire")
+			m.appendNote(fmt.Sprintf("The grant for `%s` expired; the strict command policy applies to it again.", msg.command))
+		}
+		return m, nil
+	}
+	m.tools.grantMu.Lock()
+	current, ok := m.tools.grants[msg.tool]
+	m.tools.grantMu.Unlock()
+	if !ok || !current.Equal(msg.until) {
+		return m, nil
+	}
+	m.tools.revoke(msg.tool, "expire")
+	m.appendNote(fmt.Sprintf("The grant for %s expired; %s applies again.", msg.tool, m.tools.disabled[msg.tool]))
+	return m, nil
+}
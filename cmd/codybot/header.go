@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// gitPollInterval is how often the header rereads the branch and dirty
+// files, which the agent's tools and the user's own terminal both change.
+const gitPollInterval = 3 * time.Second
+
+// gitState is the header's view of the repository; repo is false outside
+// one.
+type gitState struct {
+	repo   bool
+	branch string
+	dirty  int
+}
+
+type gitStateMsg gitState
+
+// readGitState runs git status without taking the index lock, so polling
+// never gets in the way of the user's own git commands.
+func readGitState() gitState {
+	out, err := runGit("", "--no-optional-locks", "status", "--porcelain", "--branch")
+	if err != nil {
+		return gitState{}
+	}
+	lines := strings.Split(out, "\n")
+	state := gitState{repo: true, dirty: len(lines) - 1}
+	head := strings.TrimPrefix(lines[0], "## ")
+	switch {
+	case strings.HasPrefix(head, "No commits yet on "):
+		state.branch = strings.TrimPrefix(head, "No commits yet on ")
+	case strings.HasPrefix(head, "HEAD (no branch)"):
+		state.branch = "detached"
+		if rev, err := runGit("", "rev-parse", "--short", "HEAD"); err == nil {
+			state.branch += " " + rev
+		}
+	default:
+		state.branch, _, _ = strings.Cut(head, "...")
+		state.branch, _, _ = strings.Cut(state.branch, " ")
+	}
+	return state
+}
+
+// pollGit reads the git state after delay. git can run hooks configured in
+// the repository, so it only starts once the workspace is trusted.
+func pollGit(delay time.Duration) tea.Cmd {
+	return tea.Tick(delay, func(time.Time) tea.Msg {
+		return gitStateMsg(readGitState())
+	})
+}
+
+func (m model) handleGitStateMsg(msg gitStateMsg) (tea.Model, tea.Cmd) {
+	m.git = gitState(msg)
+	return m, pollGit(gitPollInterval)
+}
+
+// sandboxMode says what the agent may do: which tools it has and whether it
+// can reach the network beyond the model.
+func (m model) sandboxMode() string {
+	if m.tools == nil {
+		if m.cfg.Safe {
+			return "safe mode, no tools"
+		}
+		if m.cfg.NoTools {
+			return "no tools"
+		}
+		return "untrusted, no tools"
+	}
+	var modes []string
+	if m.cfg.ReadOnly {
+		modes = append(modes, "read-only")
+	}
+	if m.cfg.Offline {
+		modes = append(modes, "offline")
+	}
+	if m.tools.sandbox != nil {
+		modes = append(modes, m.tools.sandbox.runtime+" sandbox")
+	}
+	if len(modes) == 0 {
+		modes = append(modes, "full access")
+	}
+	if grants := m.tools.activeGrants(); len(grants) > 0 {
+		modes = append(modes, "allowed "+strings.Join(grants, ", "))
+	}
+	return strings.Join(modes, ", ")
+}
+
+// taskName is the session title, or before the title arrives, the prompt
+// being worked on.
+func (m model) taskName() string {
+	task := m.sessionTitle
+	if task == "" && m.turnPrompt != "" {
+		task = firstLine(strings.TrimSpace(m.turnPrompt))
+	}
+	task, truncated := truncateRunes(task, maxTitleRunes)
+	if truncated {
+		task += "…"
+	}
+	return task
+}
+
+// headerText is the state the agent works in: task, branch, dirty files,
+// sandbox mode, then the endpoint, which is the first to be cut when the
+// window is narrow.
+func (m model) headerText() string {
+	var parts []string
+	if task := m.taskName(); task != "" {
+		parts = append(parts, "task: "+task)
+	}
+	if m.git.repo {
+		dirty := "clean"
+		if m.git.dirty > 0 {
+			dirty = fmt.Sprintf("%d changed", m.git.dirty)
+		}
+		parts = append(parts, fmt.Sprintf("%s, %s", m.git.branch, dirty))
+	}
+	parts = append(parts, m.sandboxMode(), fmt.Sprintf("%s @ %s", m.cfg.Model, m.cfg.BaseURL))
+	if m.profile != nil {
+		parts = append(parts, "profile "+m.profile.Name)
+	}
+	return strings.Join(parts, " • ")
+}
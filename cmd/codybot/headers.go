@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// listFlag is a flag that can be given more than once, each time adding an
+// item. The first one on the command line replaces the items the
+// environment set, one per line.
+type listFlag struct {
+	items *[]string
+	set   bool
+	// show formats an item for codybot config and the usage defaults.
+	show func(string) string
+}
+
+func newListFlag(items *[]string, env string, show func(string) string) *listFlag {
+	*items = nil
+	for _, line := range strings.Split(getenv(env), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			*items = append(*items, line)
+		}
+	}
+	return &listFlag{items: items, show: show}
+}
+
+func (f *listFlag) String() string {
+	if f == nil || f.items == nil {
+		return ""
+	}
+	shown := make([]string, len(*f.items))
+	for i, item := range *f.items {
+		shown[i] = item
+		if f.show != nil {
+			shown[i] = f.show(item)
+		}
+	}
+	return strings.Join(shown, "; ")
+}
+
+func (f *listFlag) Set(value string) error {
+	if !f.set {
+		*f.items, f.set = nil, true
+	}
+	*f.items = append(*f.items, value)
+	return nil
+}
+
+// maskHeader hides a header's value, which is often a token.
+func maskHeader(item string) string {
+	name, value, _ := strings.Cut(item, ":")
+	return name + ": " + maskKey(strings.TrimSpace(value))
+}
+
+// parseHeaders reads "Name: value" items.
+func parseHeaders(items []string) (http.Header, error) {
+	headers := http.Header{}
+	for _, item := range items {
+		name, value, ok := strings.Cut(item, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || strings.ContainsAny(name, " \t") {
+			return nil, fmt.Errorf("header %q: want Name: value", item)
+		}
+		headers.Set(name, strings.TrimSpace(value))
+	}
+	return headers, nil
+}
+
+// parseQueryParams reads "name=value" items.
+func parseQueryParams(items []string) (url.Values, error) {
+	params := url.Values{}
+	for _, item := range items {
+		name, value, ok := strings.Cut(item, "=")
+		if !ok || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("query parameter %q: want name=value", item)
+		}
+		params.Add(strings.TrimSpace(name), value)
+	}
+	return params, nil
+}
+
+func checkRequestExtras(cfg config) error {
+	for _, headers := range []struct {
+		flag  string
+		items []string
+	}{{"--header", cfg.Headers}, {"--fallback-header", cfg.FallbackHeaders}} {
+		if _, err := parseHeaders(headers.items); err != nil {
+			return fmt.Errorf("%s: %w", headers.flag, err)
+		}
+	}
+	for _, params := range []struct {
+		flag  string
+		items []string
+	}{{"--query-param", cfg.QueryParams}, {"--fallback-query-param", cfg.FallbackQueryParams}} {
+		if _, err := parseQueryParams(params.items); err != nil {
+			return fmt.Errorf("%s: %w", params.flag, err)
+		}
+	}
+	return nil
+}
+
+// setRequestExtras adds the --header and --query-param items to a request to
+// the endpoint, for gateways that want attribution, tags, or access tokens
+// of their own. They come last, so they override codybot's own headers.
+func setRequestExtras(req *http.Request, cfg config) {
+	if headers, err := parseHeaders(cfg.Headers); err == nil {
+		for name, values := range headers {
+			req.Header[name] = values
+		}
+	}
+	if params, err := parseQueryParams(cfg.QueryParams); err == nil && len(params) > 0 {
+		query := req.URL.Query()
+		for name, values := range params {
+			query[name] = values
+		}
+		req.URL.RawQuery = query.Encode()
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	// hintDelay is how long a request waits for its first token before the
+	// status bar says what the model is working on.
+	hintDelay = 2 * time.Second
+	// hintInterval throttles reasoning hints so a fast reasoning stream does
+	// not redraw the UI for every delta.
+	hintInterval   = 300 * time.Millisecond
+	maxHintRunes   = 80
+	maxHintContext = 4096
+)
+
+// reasoningHeading matches the bold headings reasoning summaries put before
+// each step, like "**Parsing the request**".
+var reasoningHeading = regexp.MustCompile(`\*\*([^*\n]+)\*\*`)
+
+// reasoningHint turns the reasoning a model streams before its answer into a
+// one-line progress hint.
+type reasoningHint struct {
+	text strings.Builder
+	sent time.Time
+	last string
+}
+
+// add takes a reasoning delta and returns the hint to show, or "" when it is
+// unchanged or the last one was sent too recently.
+func (h *reasoningHint) add(delta string) string {
+	if delta == "" {
+		return ""
+	}
+	h.text.WriteString(delta)
+	if h.text.Len() > 2*maxHintContext {
+		tail := h.text.String()[h.text.Len()-maxHintContext:]
+		h.text.Reset()
+		h.text.WriteString(tail)
+	}
+	if time.Since(h.sent) < hintInterval {
+		return ""
+	}
+	hint := reasoningLine(h.text.String())
+	if hint == "" || hint == h.last {
+		return ""
+	}
+	h.sent, h.last = time.Now(), hint
+	return hint
+}
+
+// reasoningLine picks the latest heading of the reasoning, or else its latest
+// line.
+func reasoningLine(text string) string {
+	line := ""
+	if headings := reasoningHeading.FindAllStringSubmatch(text, -1); len(headings) > 0 {
+		line = headings[len(headings)-1][1]
+	} else {
+		lines := strings.Split(strings.TrimSpace(text), "\n")
+		line = strings.Trim(lines[len(lines)-1], "#*-> \t")
+	}
+	line, truncated := truncateRunes(strings.TrimSpace(line), maxHintRunes)
+	if truncated {
+		line += "…"
+	}
+	return line
+}
+
+// waitHint says what the model is working on while no token has arrived:
+// the model's own reasoning when it streams any, and otherwise a local
+// guess from the request and how long it has been waiting.
+func (m model) waitHint() string {
+	if !m.streaming || !m.stats.firstToken.IsZero() {
+		return ""
+	}
+	waited := m.stats.elapsed()
+	if m.hint != "" {
+		return "working on: " + m.hint
+	}
+	if waited < hintDelay {
+		return ""
+	}
+	var tools []string
+	for i := len(m.history) - 1; i >= 0 && m.history[i].Role == "tool"; i-- {
+		tools = append(tools, m.history[i].Name)
+	}
+	switch {
+	case waited >= 30*time.Second:
+		return "working on: still thinking; reasoning models can take a minute or two before answering…"
+	case len(tools) > 0:
+		return fmt.Sprintf("working on: reviewing the %s results…", strings.Join(uniqueNames(tools), ", "))
+	case waited >= 8*time.Second:
+		return "working on: thinking it through…"
+	}
+	return fmt.Sprintf("working on: reading your request (~%s tokens of context)…", formatCount(historyTokens(m.history)))
+}
+
+func uniqueNames(names []string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for i := len(names) - 1; i >= 0; i-- {
+		if !seen[names[i]] {
+			seen[names[i]] = true
+			out = append(out, names[i])
+		}
+	}
+	return out
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	defaultHistoryPolicy = "pinned"
+	// summaryShare is the share of the budget left for the summary when the
+	// summary policy decides how much to fold.
+	summaryShare = 8
+	// maxSummaryChunk bounds, as a share of the budget, the messages folded
+	// into the summary in one call; longer runs take several calls.
+	maxSummaryChunk    = 2
+	maxSummaryMessage  = 8000
+	maxCachedSummaries = 32
+)
+
+const historySummaryPrompt = `You keep a running summary of a coding conversation that is too long to send whole. Update the summary so far with the messages below. Keep the task and its constraints, decisions made and why, the current plan and what is done, files changed, and open questions. Leave out pleasantries and superseded ideas. Write notes to yourself, not a reply to the user, and reply with the updated summary only.`
+
+// historyPolicy decides what to send once the history outgrows the context
+// window's budget. fit returns the messages to send and how many were left
+// out, without modifying history; report shows progress for slow policies.
+type historyPolicy struct {
+	fit    func(ctx context.Context, cfg config, history []message, budget int, report func(string)) ([]message, int)
+	notice string
+}
+
+// historyPolicies is a function, like slashCommands, because the summary
+// policy calls the model, whose request path cuts history through it.
+func historyPolicies() map[string]historyPolicy {
+	return map[string]historyPolicy{
+		"pinned": {
+			fit:    fitPinned,
+			notice: "left out %d older messages, kept the task, plan, and latest diff",
+		},
+		"window": {
+			fit:    fitWindow,
+			notice: "left out the %d oldest messages",
+		},
+		"importance": {
+			fit:    fitImportance,
+			notice: "left out %d low-importance messages",
+		},
+		"summary": {
+			fit:    fitSummary,
+			notice: "folded %d older messages into a running summary",
+		},
+	}
+}
+
+// historyPolicyNames lists the policies for help and completion.
+func historyPolicyNames() []string {
+	names := make([]string, 0, len(historyPolicies()))
+	for name := range historyPolicies() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func checkHistoryPolicy(name string) error {
+	if _, ok := historyPolicies()[name]; !ok {
+		return fmt.Errorf("--history-policy: unknown policy %q; use one of %s", name, strings.Join(historyPolicyNames(), ", "))
+	}
+	return nil
+}
+
+// trimNotice tells the user that the request left messages out.
+func trimNotice(cfg config, omitted int) string {
+	policy, ok := historyPolicies()[cfg.HistoryPolicy]
+	if !ok {
+		policy = historyPolicies()[defaultHistoryPolicy]
+	}
+	return "Context full: " + fmt.Sprintf(policy.notice, omitted)
+}
+
+// fitWindow keeps the system prompt and the most recent messages that fit.
+// Unlike fitPinned it can lose the task statement, in exchange for more
+// room for the recent turns.
+func fitWindow(_ context.Context, _ config, history []message, budget int, _ func(string)) ([]message, int) {
+	dropped := map[int]bool{}
+	count := 0
+	total := historyTokens(history)
+	units := historyUnits(history, historyStart(history))
+	for _, unit := range units[:max(len(units)-1, 0)] {
+		if total <= budget {
+			break
+		}
+		for i := unit[0]; i < unit[1]; i++ {
+			dropped[i] = true
+			total -= historyTokens(history[i : i+1])
+			count++
+		}
+	}
+	return omitMessages(history, dropped, nil, count, "")
+}
+
+// fitImportance drops the lowest-scoring units first, wherever they are in
+// the conversation. The latest unit is always kept.
+func fitImportance(_ context.Context, _ config, history []message, budget int, _ func(string)) ([]message, int) {
+	start := historyStart(history)
+	units := historyUnits(history, start)
+	if len(units) < 2 {
+		return history, 0
+	}
+	task, plan, diff := workingMessages(history, start)
+	working := map[int]bool{task: true, plan: true, diff: true}
+	type scored struct {
+		unit  [2]int
+		score float64
+	}
+	candidates := make([]scored, 0, len(units)-1)
+	for n, unit := range units[:len(units)-1] {
+		candidates = append(candidates, scored{unit, unitImportance(history, unit, working, n, len(units))})
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].score < candidates[j].score })
+
+	dropped := map[int]bool{}
+	count := 0
+	total := historyTokens(history)
+	for _, c := range candidates {
+		if total <= budget {
+			break
+		}
+		for i := c.unit[0]; i < c.unit[1]; i++ {
+			dropped[i] = true
+			total -= historyTokens(history[i : i+1])
+			count++
+		}
+	}
+	return omitMessages(history, dropped, nil, count, "The most important messages are kept: the task, plan, latest diff, failures, and recent turns rank highest.")
+}
+
+// unitImportance scores a unit for fitImportance. What the user asked ranks
+// above what the assistant said, which ranks above what tools returned;
+// the task, plan, and latest diff, failures, and recent turns rank higher;
+// long tool output is the cheapest to lose.
+func unitImportance(history []message, unit [2]int, working map[int]bool, position, units int) float64 {
+	score := 3 * float64(position) / float64(units)
+	for i := unit[0]; i < unit[1]; i++ {
+		msg := history[i]
+		switch msg.Role {
+		case "user":
+			score += 3
+		case "assistant":
+			score += 2
+		case "tool":
+			if strings.HasPrefix(msg.Content, "error: ") {
+				score++
+			}
+		}
+		if working[i] {
+			score += 10
+		}
+		for _, call := range msg.ToolCalls {
+			if writtenPath(call) != "" {
+				score++
+			}
+		}
+	}
+	return score - float64(historyTokens(history[unit[0]:unit[1]]))/2000
+}
+
+// summaryCache keeps the summaries fitSummary wrote, keyed by the hash of
+// the messages they replace, so later requests reuse them instead of
+// summarizing the same messages again.
+type summaryCache struct {
+	mu      sync.Mutex
+	entries map[string]string
+	order   []string
+}
+
+var historySummaries = &summaryCache{entries: map[string]string{}}
+
+func (c *summaryCache) get(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	summary, ok := c.entries[key]
+	return summary, ok
+}
+
+func (c *summaryCache) put(key, summary string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; !ok {
+		c.order = append(c.order, key)
+	}
+	c.entries[key] = summary
+	for len(c.order) > maxCachedSummaries {
+		delete(c.entries, c.order[0])
+		c.order = c.order[1:]
+	}
+}
+
+// chainHash extends prev with the messages in one unit, so equal hashes mean
+// equal conversation prefixes.
+func chainHash(prev string, msgs []message) string {
+	h := sha256.New()
+	h.Write([]byte(prev))
+	for _, msg := range msgs {
+		fmt.Fprintf(h, "%s\x00%s\x00%s\x00", msg.Role, msg.Name, msg.Content)
+		for _, call := range msg.ToolCalls {
+			fmt.Fprintf(h, "%s\x00%s\x00", call.Function.Name, call.Function.Arguments)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// fitSummary replaces the oldest units with a summary written by the model.
+// As the conversation grows, more units are folded into the summary: each
+// new summary is written from the previous one plus the newly folded
+// messages, a summary of summaries, so no call ever has to fit the whole
+// history. When the model cannot be reached, a local summary stands in.
+func fitSummary(ctx context.Context, cfg config, history []message, budget int, report func(string)) ([]message, int) {
+	start := historyStart(history)
+	units := historyUnits(history, start)
+	target := budget - budget/summaryShare
+	total := historyTokens(history)
+
+	// bounds are the unit boundaries that can end the folded prefix, with
+	// the hash of the prefix up to each.
+	type bound struct {
+		end  int
+		hash string
+	}
+	var bounds []bound
+	hash := ""
+	for _, unit := range units[:max(len(units)-1, 0)] {
+		if total <= target {
+			break
+		}
+		total -= historyTokens(history[unit[0]:unit[1]])
+		hash = chainHash(hash, history[unit[0]:unit[1]])
+		bounds = append(bounds, bound{unit[1], hash})
+	}
+	if len(bounds) == 0 {
+		return history, 0
+	}
+
+	summary, from, next := "", start, 0
+	for i := len(bounds) - 1; i >= 0; i-- {
+		if cached, ok := historySummaries.get(bounds[i].hash); ok {
+			summary, from, next = cached, bounds[i].end, i+1
+			break
+		}
+	}
+	cut := bounds[len(bounds)-1].end
+	if from < cut {
+		report(fmt.Sprintf("Summarizing %d older messages to fit the context window…", cut-from))
+	}
+	chunkStart, unitStart, chunkTokens := from, from, 0
+	for i := next; i < len(bounds); i++ {
+		end := bounds[i].end
+		chunkTokens += historyTokens(history[unitStart:end])
+		unitStart = end
+		if chunkTokens < budget/maxSummaryChunk && i < len(bounds)-1 {
+			continue
+		}
+		updated, err := summarizeMessages(ctx, cfg, summary, history[chunkStart:end])
+		if err != nil {
+			summary = strings.TrimSpace(summary + "\n\n" + localSummary(history[chunkStart:cut]))
+			report("The model is unreachable, so the summary of older messages was built locally")
+			break
+		}
+		summary = updated
+		historySummaries.put(bounds[i].hash, summary)
+		chunkStart, chunkTokens = end, 0
+	}
+
+	out := append([]message(nil), history[:start]...)
+	out = append(out, message{Role: "system", Content: fmt.Sprintf("[Summary of %d earlier messages, left out to fit the context window]\n%s", cut-start, summary)})
+	return append(out, history[cut:]...), cut - start
+}
+
+// summarizeMessages asks the model to fold msgs into summary. The messages
+// are sent as a plain transcript so tool calls need no matching results.
+func summarizeMessages(ctx context.Context, cfg config, summary string, msgs []message) (string, error) {
+	var b strings.Builder
+	if summary != "" {
+		fmt.Fprintf(&b, "Summary so far:\n%s\n\n", summary)
+	}
+	b.WriteString("Messages to fold in:\n")
+	for _, msg := range msgs {
+		content, _ := truncateRunes(msg.Content, maxSummaryMessage)
+		role := msg.Role
+		if msg.Role == "tool" {
+			role = "tool " + msg.Name
+		}
+		fmt.Fprintf(&b, "\n[%s]\n%s\n", role, content)
+		for _, call := range msg.ToolCalls {
+			args, _ := truncateRunes(call.Function.Arguments, 500)
+			fmt.Fprintf(&b, "(called %s %s)\n", call.Function.Name, args)
+		}
+	}
+	request := []message{
+		{Role: "system", Content: historySummaryPrompt},
+		{Role: "user", Content: b.String()},
+	}
+	cfg.schema = nil
+	_, updated, err := runAgentLoop(ctx, cfg, nil, nil, request, nil)
+	if err == nil && strings.TrimSpace(updated) == "" {
+		err = fmt.Errorf("the model returned an empty summary")
+	}
+	return strings.TrimSpace(updated), err
+}
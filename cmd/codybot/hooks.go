@@ -0,0 +1,359 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+const (
+	projectHooksPath   = ".codybot/hooks.json"
+	defaultHookTimeout = 30 * time.Second
+	maxHookOutput      = 4000
+
+	hookPreRequest   = "pre_request"
+	hookPostResponse = "post_response"
+)
+
+// hookSpec is one command in hooks.json. Run may use {files}, the files the
+// agent edited this turn, shell-quoted; a hook that does is skipped when
+// there are none. Context adds its output to the model's context.
+type hookSpec struct {
+	Run     string `json:"run"`
+	Context bool   `json:"context"`
+	Timeout string `json:"timeout"`
+}
+
+// hooksFile holds the commands run around each turn: pre_request ones
+// before the prompt is sent, post_response ones once the answer is done.
+type hooksFile struct {
+	PreRequest   []hookSpec `json:"pre_request"`
+	PostResponse []hookSpec `json:"post_response"`
+	// pending is set by loadHooks to project hooks left out because the
+	// user has not approved this version of the file.
+	pending *pendingHooks
+}
+
+type pendingHooks struct {
+	hash  string
+	hooks hooksFile
+}
+
+type hookResult struct {
+	hook   hookSpec
+	output string
+	err    error
+}
+
+// hooksMsg reports a stage's hooks once they have all run.
+type hooksMsg struct {
+	stage   string
+	results []hookResult
+}
+
+// loadHooks reads the user's hooks and then the project's, which only run
+// in a trusted workspace since they come with the checkout, and only once
+// the user approved the file's current content (see /hooks). Hooks are read
+// for every turn, so edits apply without a restart.
+func loadHooks(trusted bool) (hooksFile, error) {
+	paths := []string{filepath.Join(codybotHome(), "hooks.json")}
+	if trusted {
+		paths = append(paths, projectHooksPath)
+	}
+	var hooks hooksFile
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return hooks, err
+		}
+		var file hooksFile
+		if err := json.Unmarshal(data, &file); err != nil {
+			return hooks, fmt.Errorf("%s: %w", path, err)
+		}
+		for _, list := range [][]hookSpec{file.PreRequest, file.PostResponse} {
+			for i, hook := range list {
+				if strings.TrimSpace(hook.Run) == "" {
+					return hooks, fmt.Errorf("%s: hook %d has no run command", path, i+1)
+				}
+				if _, err := parseTaskDuration(hook.Timeout, defaultHookTimeout); err != nil {
+					return hooks, fmt.Errorf("%s: %s: timeout: %w", path, hook.Run, err)
+				}
+			}
+		}
+		if path == projectHooksPath {
+			sum := sha256.Sum256(data)
+			hash := hex.EncodeToString(sum[:])
+			if !hooksApproved(hash) {
+				hooks.pending = &pendingHooks{hash: hash, hooks: file}
+				continue
+			}
+		}
+		hooks.PreRequest = append(hooks.PreRequest, file.PreRequest...)
+		hooks.PostResponse = append(hooks.PostResponse, file.PostResponse...)
+	}
+	return hooks, nil
+}
+
+// hookApprovals maps a workspace root to the hash of the project hooks file
+// the user approved there.
+type hookApprovals struct {
+	Approved map[string]string `json:"approved"`
+}
+
+func hookApprovalsPath() string {
+	return filepath.Join(codybotHome(), "hooks-approved.json")
+}
+
+func loadHookApprovals() hookApprovals {
+	approvals := hookApprovals{Approved: map[string]string{}}
+	if data, err := os.ReadFile(hookApprovalsPath()); err == nil {
+		json.Unmarshal(data, &approvals)
+	}
+	if approvals.Approved == nil {
+		approvals.Approved = map[string]string{}
+	}
+	return approvals
+}
+
+func hooksApproved(hash string) bool {
+	root, err := workspaceRoot()
+	return err == nil && loadHookApprovals().Approved[root] == hash
+}
+
+func approveHooks(hash string) error {
+	root, err := workspaceRoot()
+	if err != nil {
+		return err
+	}
+	approvals := loadHookApprovals()
+	approvals.Approved[root] = hash
+	data, err := json.MarshalIndent(approvals, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(hookApprovalsPath(), data, 0o600)
+}
+
+// notePendingHooks tells the user, once per version of the file, that
+// project hooks were held back and what they would run.
+func (m *model) notePendingHooks(hooks hooksFile) {
+	if hooks.pending == nil || hooks.pending.hash == m.hooksNoted {
+		return
+	}
+	m.hooksNoted = hooks.pending.hash
+	m.appendNote(fmt.Sprintf("%s is new or changed since you last approved it, so its hooks did not run. They would run on the host:\n%s/hooks approve runs them from now on.", projectHooksPath, describeHooks(hooks.pending.hooks)))
+}
+
+func describeHooks(hooks hooksFile) string {
+	var b strings.Builder
+	for _, stage := range []struct {
+		name  string
+		hooks []hookSpec
+	}{{hookPreRequest, hooks.PreRequest}, {hookPostResponse, hooks.PostResponse}} {
+		for _, hook := range stage.hooks {
+			fmt.Fprintf(&b, "  %s: %s\n", stage.name, hook.Run)
+		}
+	}
+	return b.String()
+}
+
+// cmdHooks lists the hooks that run here, or approves the project's hooks
+// as last shown to the user; a file changed since then needs another look.
+func (m *model) cmdHooks(args string) tea.Cmd {
+	hooks, err := loadHooks(isTrusted(m.workspace))
+	if err != nil {
+		m.lastErr = err
+		return nil
+	}
+	switch args {
+	case "":
+		var b strings.Builder
+		if len(hooks.PreRequest)+len(hooks.PostResponse) == 0 {
+			b.WriteString("No hooks run here.\n")
+		} else {
+			b.WriteString("Hooks that run here:\n" + describeHooks(hooks))
+		}
+		if hooks.pending != nil {
+			m.hooksNoted = hooks.pending.hash
+			fmt.Fprintf(&b, "Not approved, from %s:\n%s/hooks approve runs them from now on.", projectHooksPath, describeHooks(hooks.pending.hooks))
+		}
+		m.appendNote(b.String())
+	case "approve":
+		switch {
+		case hooks.pending == nil:
+			m.notice = "No project hooks are waiting for approval"
+		case hooks.pending.hash != m.hooksNoted:
+			m.notice = projectHooksPath + " changed since it was shown; review it with /hooks first"
+		default:
+			if err := approveHooks(hooks.pending.hash); err != nil {
+				m.lastErr = err
+				return nil
+			}
+			m.notice = "Approved the project hooks; they run from the next turn"
+		}
+	default:
+		m.notice = "Usage: /hooks [approve]"
+	}
+	return nil
+}
+
+// runHooks runs the hooks in order from the workspace root. The prompt and
+// edited files are also passed in CODYBOT_PROMPT and CODYBOT_FILES (one per
+// line) for scripts that want them.
+func runHooks(ctx context.Context, stage string, hooks []hookSpec, prompt string, files []string) []hookResult {
+	var quoted []string
+	for _, f := range files {
+		quoted = append(quoted, shellQuote(f))
+	}
+	var results []hookResult
+	for _, hook := range hooks {
+		if strings.Contains(hook.Run, "{files}") && len(files) == 0 {
+			continue
+		}
+		timeout, _ := parseTaskDuration(hook.Timeout, defaultHookTimeout)
+		hookCtx, cancel := context.WithTimeout(ctx, timeout)
+		cmd := shellCommand(hookCtx, strings.ReplaceAll(hook.Run, "{files}", strings.Join(quoted, " ")))
+		cmd.Env = append(os.Environ(), "CODYBOT_HOOK="+stage, "CODYBOT_PROMPT="+prompt, "CODYBOT_FILES="+strings.Join(files, "\n"))
+		cmd.WaitDelay = shellWaitDelay
+		out, err := cmd.CombinedOutput()
+		cancel()
+		if hookCtx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("timed out after %s", timeout)
+		}
+		results = append(results, hookResult{hook: hook, output: strings.TrimRight(string(out), "\n"), err: err})
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return results
+}
+
+// hookContext formats the output of the results whose hooks asked for it
+// as context for the model.
+func hookContext(stage string, results []hookResult) string {
+	var b strings.Builder
+	for _, r := range results {
+		if !r.hook.Context {
+			continue
+		}
+		output, truncated := truncateRunes(r.output, maxHookOutput)
+		if truncated {
+			output += "\n(truncated)"
+		}
+		status := ""
+		if r.err != nil {
+			status = fmt.Sprintf(", which failed: %v", r.err)
+		}
+		fmt.Fprintf(&b, "\n\nOutput of the %s hook `%s`%s:\n```\n%s\n```", strings.ToLower(hookStageLabel(stage)), r.hook.Run, status, output)
+	}
+	return b.String()
+}
+
+func hookStageLabel(stage string) string {
+	if stage == hookPreRequest {
+		return "Pre-request"
+	}
+	return "Post-response"
+}
+
+// startPreHooks runs the pre-request hooks before the turn's request is
+// sent, or returns nil when there are none. Esc stops them like a request.
+func (m *model) startPreHooks() tea.Cmd {
+	if m.cfg.Safe {
+		return nil
+	}
+	hooks, err := loadHooks(isTrusted(m.workspace))
+	if err != nil {
+		m.transcript.dropEmpty(blockAssistant)
+		m.appendNote("Hooks not run: " + err.Error())
+		m.addBlock(blockAssistant, "")
+		return nil
+	}
+	if hooks.pending != nil && hooks.pending.hash != m.hooksNoted {
+		m.transcript.dropEmpty(blockAssistant)
+		m.notePendingHooks(hooks)
+		m.addBlock(blockAssistant, "")
+	}
+	if len(hooks.PreRequest) == 0 {
+		return nil
+	}
+	m.streaming = true
+	m.notice = "Running pre-request hooks…"
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancelStream = cancel
+	prompt := m.turnPrompt
+	return func() tea.Msg {
+		return hooksMsg{stage: hookPreRequest, results: runHooks(ctx, hookPreRequest, hooks.PreRequest, prompt, nil)}
+	}
+}
+
+// startPostHooks runs the post-response hooks for the turn that just
+// finished, with the files it edited.
+func (m *model) startPostHooks() tea.Cmd {
+	if m.cfg.Safe {
+		return nil
+	}
+	hooks, err := loadHooks(isTrusted(m.workspace))
+	if err != nil {
+		m.appendNote("Hooks not run: " + err.Error())
+		return nil
+	}
+	m.notePendingHooks(hooks)
+	if len(hooks.PostResponse) == 0 {
+		return nil
+	}
+	prompt, files := m.turnPrompt, m.journal.turnFiles(m.turn)
+	return func() tea.Msg {
+		return hooksMsg{stage: hookPostResponse, results: runHooks(context.Background(), hookPostResponse, hooks.PostResponse, prompt, files)}
+	}
+}
+
+func (m model) handleHooksMsg(msg hooksMsg) (tea.Model, tea.Cmd) {
+	pre := msg.stage == hookPreRequest
+	if pre {
+		// Notes go above the answer the turn is about to stream.
+		m.transcript.dropEmpty(blockAssistant)
+	}
+	failed := 0
+	for _, r := range msg.results {
+		if r.err != nil {
+			failed++
+			m.appendNote(fmt.Sprintf("%s hook `%s` failed: %v\n%s", hookStageLabel(msg.stage), r.hook.Run, r.err, tailLines(r.output, 20)))
+		}
+	}
+	extra := hookContext(msg.stage, msg.results)
+	if !pre {
+		// The next prompt carries it; this turn's answer is already done.
+		m.pendingHookContext += extra
+		m.notice = fmt.Sprintf("Ran %d post-response hook(s), %d failed", len(msg.results), failed)
+		return m, nil
+	}
+
+	m.cancelStream = nil
+	if m.cancelled {
+		m.stopTurn("Stopped before the request was sent")
+		return m, m.steerAfterStop()
+	}
+	m.addBlock(blockAssistant, "")
+	if n := len(m.history); extra != "" && n > 0 && m.history[n-1].Role == "user" {
+		last := &m.history[n-1]
+		last.Content += extra
+		if last.Meta != nil {
+			last.Meta.Tokens = estimateTokens(last.Content)
+		}
+	}
+	m.notice = ""
+	return m, m.startStream()
+}
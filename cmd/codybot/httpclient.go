@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultDialTimeout = 10 * time.Second
+	defaultTLSTimeout  = 10 * time.Second
+	// defaultIdleTimeout outlasts the pause while the user reads an answer
+	// and types the next prompt, so the next turn reuses the connection
+	// instead of paying for a new TCP and TLS handshake.
+	defaultIdleTimeout = 5 * time.Minute
+	// maxIdleConnsPerHost covers the parallel requests of a turn, such as
+	// titling the session and the self-check, alongside HTTP/1.1 endpoints.
+	maxIdleConnsPerHost = 8
+)
+
+var httpClients sync.Map
+
+type transportKey struct {
+	proxy    string
+	caBundle string
+	insecure bool
+	// allow is the comma-separated --offline egress allowlist.
+	allow string
+
+	dialTimeout, tlsTimeout, idleTimeout time.Duration
+}
+
+// httpClientFor returns the client for the transport settings in cfg. Clients
+// are cached per setting so connections are pooled across requests.
+func httpClientFor(cfg config) (*http.Client, error) {
+	key := transportKey{
+		proxy:       cfg.Proxy,
+		caBundle:    cfg.CABundle,
+		insecure:    cfg.InsecureSkipVerify,
+		allow:       strings.Join(cfg.egressAllowlist(), ","),
+		dialTimeout: cfg.DialTimeout,
+		tlsTimeout:  cfg.TLSTimeout,
+		idleTimeout: cfg.IdleTimeout,
+	}
+	if client, ok := httpClients.Load(key); ok {
+		return client.(*http.Client), nil
+	}
+	transport, err := newTransport(key)
+	if err != nil {
+		return nil, err
+	}
+	var roundTripper http.RoundTripper = transport
+	if cfg.Offline {
+		roundTripper = restrictEgress(transport, cfg.egressAllowlist(), cfg.Proxy)
+	}
+	client, _ := httpClients.LoadOrStore(key, &http.Client{Transport: roundTripper})
+	return client.(*http.Client), nil
+}
+
+// newTransport tunes the default transport for long-lived, repeated calls
+// to a few hosts: HTTP/2 where the server offers it, so a turn's requests
+// share one connection, and idle connections kept between turns, with pings
+// that drop a connection a NAT or load balancer silently closed before a
+// request is sent on it.
+func newTransport(key transportKey) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+	dialer := &net.Dialer{Timeout: key.dialTimeout, KeepAlive: 30 * time.Second}
+	transport.DialContext = dialer.DialContext
+	transport.TLSHandshakeTimeout = key.tlsTimeout
+	transport.ForceAttemptHTTP2 = true
+	transport.MaxIdleConnsPerHost = maxIdleConnsPerHost
+	transport.IdleConnTimeout = key.idleTimeout
+	transport.DisableKeepAlives = key.idleTimeout <= 0
+	transport.HTTP2 = &http.HTTP2Config{SendPingTimeout: 30 * time.Second, PingTimeout: 15 * time.Second}
+	if key.proxy != "" {
+		proxyURL, err := url.Parse(key.proxy)
+		if err != nil || proxyURL.Host == "" {
+			return nil, fmt.Errorf("invalid --proxy %q", key.proxy)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if key.caBundle == "" && !key.insecure {
+		return transport, nil
+	}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12, InsecureSkipVerify: key.insecure}
+	if key.caBundle != "" {
+		pem, err := os.ReadFile(key.caBundle)
+		if err != nil {
+			return nil, fmt.Errorf("read CA bundle: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("CA bundle contains no PEM certificates")
+		}
+		tlsConfig.RootCAs = pool
+	}
+	transport.TLSClientConfig = tlsConfig
+	return transport, nil
+}
@@ -0,0 +1,468 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	importChatGPT = "chatgpt"
+	importClaude  = "claude"
+	importAider   = "aider"
+)
+
+// importedConversation is one conversation read from another tool's export,
+// with its user and assistant turns in order.
+type importedConversation struct {
+	// source identifies it across imports, e.g. chatgpt:<id>.
+	source   string
+	title    string
+	model    string
+	created  time.Time
+	messages []message
+	// dropped counts the messages with no codybot equivalent, such as
+	// system prompts, tool output, and attachments.
+	dropped int
+}
+
+// runImportCommand converts exported conversations into saved sessions, which
+// --resume then continues.
+func runImportCommand(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	format := fs.String("format", "auto", "Export format: auto, chatgpt (conversations.json), claude (claude.ai export), or aider (.aider.chat.history.md)")
+	match := fs.String("match", "", "Only import conversations whose title contains this text (case-insensitive)")
+	dryRun := fs.Bool("dry-run", false, "List what would be imported without writing sessions")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: codybot import [--format auto|chatgpt|claude|aider] [--match text] [--dry-run] <file>")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	path := fs.Arg(0)
+	data, err := readExport(path)
+	if err != nil {
+		fmt.Fprintf(stderr, "codybot import: %v\n", err)
+		return 1
+	}
+	kind := *format
+	if kind == "auto" {
+		if kind, err = detectExportFormat(data); err != nil {
+			fmt.Fprintf(stderr, "codybot import: %s: %v\n", path, err)
+			return 1
+		}
+	}
+	var conversations []importedConversation
+	switch kind {
+	case importChatGPT:
+		conversations, err = parseChatGPTExport(data)
+	case importClaude:
+		conversations, err = parseClaudeExport(data)
+	case importAider:
+		conversations, err = parseAiderHistory(data, path)
+	default:
+		fmt.Fprintf(stderr, "codybot import: unknown --format %q; use auto, chatgpt, claude, or aider\n", kind)
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "codybot import: %s: %v\n", path, err)
+		return 1
+	}
+
+	imported, err := importedSources()
+	if err != nil {
+		fmt.Fprintf(stderr, "codybot import: %v\n", err)
+		return 1
+	}
+	added, skipped := 0, 0
+	for _, c := range conversations {
+		if *match != "" && !strings.Contains(strings.ToLower(c.title), strings.ToLower(*match)) {
+			continue
+		}
+		if len(c.messages) == 0 {
+			continue
+		}
+		if id, ok := imported[c.source]; ok {
+			fmt.Fprintf(stdout, "%s\talready imported\t%s\n", id, c.title)
+			skipped++
+			continue
+		}
+		id := importSessionID(c.created)
+		line := fmt.Sprintf("%s\t%d messages\t%s", id, len(c.messages), c.title)
+		if c.dropped > 0 {
+			line += fmt.Sprintf(" (%d messages without text skipped)", c.dropped)
+		}
+		fmt.Fprintln(stdout, line)
+		added++
+		if *dryRun {
+			continue
+		}
+		err := saveSession(sessionFile{
+			ID:           id,
+			Title:        c.title,
+			ImportedFrom: c.source,
+			CreatedAt:    c.created,
+			Model:        c.model,
+			Messages:     c.messages,
+		})
+		if err != nil {
+			fmt.Fprintf(stderr, "codybot import: %s: %v\n", id, err)
+			return 1
+		}
+	}
+	verb := "Imported"
+	if *dryRun {
+		verb = "Would import"
+	}
+	fmt.Fprintf(stdout, "%s %d %s conversations", verb, added, kind)
+	if skipped > 0 {
+		fmt.Fprintf(stdout, "; %d were imported before", skipped)
+	}
+	fmt.Fprintln(stdout, ". codybot --resume <id> continues one.")
+	return 0
+}
+
+// readExport reads an export file. Both ChatGPT and claude.ai hand out a
+// zip; conversations.json is read from it.
+func readExport(path string) ([]byte, error) {
+	if !strings.EqualFold(filepath.Ext(path), ".zip") {
+		return os.ReadFile(path)
+	}
+	archive, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+	for _, f := range archive.File {
+		if filepath.Base(f.Name) != "conversations.json" {
+			continue
+		}
+		r, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return io.ReadAll(r)
+	}
+	return nil, fmt.Errorf("%s has no conversations.json", path)
+}
+
+// detectExportFormat tells the formats apart by their shape.
+func detectExportFormat(data []byte) (string, error) {
+	text := strings.TrimSpace(string(data))
+	if strings.HasPrefix(text, "[") || strings.HasPrefix(text, "{") {
+		var probe []map[string]json.RawMessage
+		if err := json.Unmarshal(data, &probe); err != nil {
+			var single map[string]json.RawMessage
+			if json.Unmarshal(data, &single) != nil {
+				return "", fmt.Errorf("not a conversation export: %w", err)
+			}
+			probe = []map[string]json.RawMessage{single}
+		}
+		for _, c := range probe {
+			if _, ok := c["mapping"]; ok {
+				return importChatGPT, nil
+			}
+			if _, ok := c["chat_messages"]; ok {
+				return importClaude, nil
+			}
+		}
+		return "", errors.New("JSON without ChatGPT's mapping or claude.ai's chat_messages; pass --format")
+	}
+	if aiderSessionStart.MatchString(text) || strings.Contains(text, "\n#### ") || strings.HasPrefix(text, "#### ") {
+		return importAider, nil
+	}
+	return "", errors.New("unrecognized export; pass --format chatgpt, claude, or aider")
+}
+
+// decodeConversations accepts an array of conversations or a single one.
+func decodeConversations[T any](data []byte) ([]T, error) {
+	var list []T
+	if err := json.Unmarshal(data, &list); err == nil {
+		return list, nil
+	}
+	var single T
+	if err := json.Unmarshal(data, &single); err != nil {
+		return nil, err
+	}
+	return []T{single}, nil
+}
+
+type chatGPTConversation struct {
+	ID          string                 `json:"id"`
+	Title       string                 `json:"title"`
+	CreateTime  float64                `json:"create_time"`
+	CurrentNode string                 `json:"current_node"`
+	Mapping     map[string]chatGPTNode `json:"mapping"`
+}
+
+type chatGPTNode struct {
+	Parent  string `json:"parent"`
+	Message *struct {
+		Author struct {
+			Role string `json:"role"`
+		} `json:"author"`
+		Content struct {
+			ContentType string            `json:"content_type"`
+			Parts       []json.RawMessage `json:"parts"`
+			Text        string            `json:"text"`
+		} `json:"content"`
+		Metadata struct {
+			ModelSlug string `json:"model_slug"`
+		} `json:"metadata"`
+	} `json:"message"`
+}
+
+// parseChatGPTExport reads conversations.json from a ChatGPT data export.
+// Each conversation is a tree of edits and regenerations; the branch that
+// ends at current_node is the one that was on screen.
+func parseChatGPTExport(data []byte) ([]importedConversation, error) {
+	list, err := decodeConversations[chatGPTConversation](data)
+	if err != nil {
+		return nil, err
+	}
+	var out []importedConversation
+	for _, c := range list {
+		ic := importedConversation{source: importChatGPT + ":" + c.ID, title: c.Title, created: unixSeconds(c.CreateTime)}
+		var branch []chatGPTNode
+		seen := map[string]bool{}
+		for id := c.CurrentNode; id != "" && !seen[id]; id = c.Mapping[id].Parent {
+			seen[id] = true
+			branch = append(branch, c.Mapping[id])
+		}
+		for i := len(branch) - 1; i >= 0; i-- {
+			msg := branch[i].Message
+			if msg == nil {
+				continue
+			}
+			var parts []string
+			for _, raw := range msg.Content.Parts {
+				var part string
+				if json.Unmarshal(raw, &part) == nil && strings.TrimSpace(part) != "" {
+					parts = append(parts, part)
+				}
+			}
+			if msg.Content.Text != "" {
+				parts = append(parts, msg.Content.Text)
+			}
+			text := strings.Join(parts, "\n\n")
+			role := msg.Author.Role
+			if (role != "user" && role != "assistant") || strings.TrimSpace(text) == "" {
+				// ChatGPT keeps an empty system message at the root.
+				if role != "system" || strings.TrimSpace(text) != "" {
+					ic.dropped++
+				}
+				continue
+			}
+			if role == "assistant" && msg.Metadata.ModelSlug != "" {
+				ic.model = msg.Metadata.ModelSlug
+			}
+			ic.messages = appendTurn(ic.messages, role, text)
+		}
+		out = append(out, ic)
+	}
+	return out, nil
+}
+
+type claudeConversation struct {
+	UUID         string    `json:"uuid"`
+	Name         string    `json:"name"`
+	CreatedAt    time.Time `json:"created_at"`
+	ChatMessages []struct {
+		Sender  string `json:"sender"`
+		Text    string `json:"text"`
+		Content []struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		} `json:"content"`
+	} `json:"chat_messages"`
+}
+
+// parseClaudeExport reads conversations.json from a claude.ai data export.
+func parseClaudeExport(data []byte) ([]importedConversation, error) {
+	list, err := decodeConversations[claudeConversation](data)
+	if err != nil {
+		return nil, err
+	}
+	var out []importedConversation
+	for _, c := range list {
+		ic := importedConversation{source: importClaude + ":" + c.UUID, title: c.Name, created: c.CreatedAt}
+		for _, msg := range c.ChatMessages {
+			text := msg.Text
+			if len(msg.Content) > 0 {
+				var parts []string
+				for _, part := range msg.Content {
+					if part.Type == "text" && strings.TrimSpace(part.Text) != "" {
+						parts = append(parts, part.Text)
+					}
+				}
+				text = strings.Join(parts, "\n\n")
+			}
+			role := "user"
+			if msg.Sender == "assistant" {
+				role = "assistant"
+			}
+			if strings.TrimSpace(text) == "" {
+				ic.dropped++
+				continue
+			}
+			ic.messages = appendTurn(ic.messages, role, text)
+		}
+		if ic.title == "" {
+			ic.title = firstPrompt(ic.messages)
+		}
+		out = append(out, ic)
+	}
+	return out, nil
+}
+
+var aiderSessionStart = regexp.MustCompile(`(?m)^# aider chat started at (.+)$`)
+
+// parseAiderHistory reads aider's .aider.chat.history.md. Each "# aider chat
+// started at" heading begins a conversation. Prompts are the lines prefixed
+// with "#### ", the "> " lines are aider's own output, such as commands and
+// files added, and everything else is the model's answer.
+func parseAiderHistory(data []byte, path string) ([]importedConversation, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		abs = path
+	}
+	project := filepath.Base(filepath.Dir(abs))
+	var out []importedConversation
+	var current *importedConversation
+	var role string
+	var buf []string
+	flush := func() {
+		text := strings.TrimSpace(strings.Join(buf, "\n"))
+		buf = nil
+		if current != nil && text != "" {
+			current.messages = appendTurn(current.messages, role, text)
+		}
+	}
+	start := func(started time.Time, label string) {
+		flush()
+		out = append(out, importedConversation{
+			source:  fmt.Sprintf("%s:%s@%s", importAider, abs, label),
+			title:   fmt.Sprintf("aider: %s %s", project, label),
+			created: started,
+		})
+		current = &out[len(out)-1]
+	}
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if match := aiderSessionStart.FindStringSubmatch(line); match != nil {
+			label := strings.TrimSpace(match[1])
+			started, _ := time.ParseInLocation("2006-01-02 15:04:05", label, time.Local)
+			start(started, label)
+			continue
+		}
+		if current == nil {
+			// A history that does not start with a heading, such as one
+			// cut from the middle.
+			info, _ := os.Stat(path)
+			started := time.Now()
+			if info != nil {
+				started = info.ModTime()
+			}
+			start(started, started.Format("2006-01-02 15:04:05"))
+		}
+		switch {
+		case strings.HasPrefix(line, "#### ") || line == "####":
+			if role != "user" {
+				flush()
+				role = "user"
+			}
+			buf = append(buf, strings.TrimPrefix(strings.TrimPrefix(line, "####"), " "))
+		case strings.HasPrefix(line, "> ") || line == ">":
+			// aider's output ends the prompt without starting an answer.
+			if role == "user" {
+				flush()
+				role = ""
+			}
+		default:
+			if role != "assistant" {
+				flush()
+				role = "assistant"
+			}
+			buf = append(buf, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	flush()
+	for i := range out {
+		if first := firstPrompt(out[i].messages); first != "" {
+			out[i].title = fmt.Sprintf("aider: %s: %s", project, first)
+		}
+	}
+	return out, nil
+}
+
+// appendTurn adds a message, joining it to the previous one when both have
+// the same role, since the chat API expects users and the assistant to take
+// turns.
+func appendTurn(messages []message, role, text string) []message {
+	if n := len(messages); n > 0 && messages[n-1].Role == role {
+		messages[n-1].Content += "\n\n" + text
+		return messages
+	}
+	return append(messages, message{Role: role, Content: text})
+}
+
+func unixSeconds(seconds float64) time.Time {
+	if seconds <= 0 {
+		return time.Now()
+	}
+	whole, frac := math.Modf(seconds)
+	return time.Unix(int64(whole), int64(frac*1e9))
+}
+
+// importedSources maps the source of every imported session to its ID, so
+// importing a newer export skips the conversations already brought over.
+func importedSources() (map[string]string, error) {
+	paths, err := listSessionFiles()
+	if err != nil {
+		return nil, err
+	}
+	sources := map[string]string{}
+	for _, path := range paths {
+		session, err := loadSession(path)
+		if err == nil && session.ImportedFrom != "" {
+			sources[session.ImportedFrom] = session.ID
+		}
+	}
+	return sources, nil
+}
+
+// importSessionID names an imported session after when the conversation
+// started, so it sorts among the others by age.
+func importSessionID(created time.Time) string {
+	base := created.Local().Format("20060102-150405")
+	id := base
+	for n := 2; fileExists(filepath.Join(sessionsDir(), id+".json")); n++ {
+		id = fmt.Sprintf("%s-%d", base, n)
+	}
+	return id
+}
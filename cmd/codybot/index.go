@@ -0,0 +1,629 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+const (
+	indexVersion = 1
+	// Files are embedded in chunks of minChunkLines to maxChunkLines lines:
+	// small enough that a match points at the code, large enough to carry
+	// context.
+	minChunkLines     = 20
+	maxChunkLines     = 80
+	maxIndexFileBytes = 512 << 10
+	maxIndexFiles     = 20000
+	// embedBatch is how many chunks go in one embeddings request.
+	embedBatch            = 64
+	defaultSearchResults  = 5
+	maxSearchResults      = 20
+	indexRefreshTimeout   = 2 * time.Minute
+	defaultEmbeddingModel = "text-embedding-3-small"
+	defaultOllamaEmbedder = "nomic-embed-text"
+)
+
+// The semantic index is the workspace's text files, cut into chunks and
+// embedded with --embedding-model, so semantic_search finds code by what it
+// does rather than by name. It lives under ~/.codybot/index, one file per
+// workspace, and is built by codybot index. Chunks are keyed by the hash of
+// their text, so an update only embeds what changed.
+
+type semanticIndex struct {
+	Version   int                    `json:"version"`
+	Model     string                 `json:"model"`
+	UpdatedAt time.Time              `json:"updated_at"`
+	Files     map[string]indexedFile `json:"files"`
+}
+
+// indexedFile is one file of the index, by the hash of the content its
+// chunks were cut from.
+type indexedFile struct {
+	Hash   string       `json:"hash"`
+	Chunks []indexChunk `json:"chunks"`
+}
+
+type indexChunk struct {
+	// Start and End are the chunk's 1-based, inclusive lines.
+	Start  int       `json:"start"`
+	End    int       `json:"end"`
+	Hash   string    `json:"hash"`
+	Vector embedding `json:"vector"`
+}
+
+// embedding is stored as base64 little-endian float32s, a quarter of the
+// size of a JSON array of numbers.
+type embedding []float32
+
+func (e embedding) MarshalJSON() ([]byte, error) {
+	buf := make([]byte, 4*len(e))
+	for i, v := range e {
+		binary.LittleEndian.PutUint32(buf[4*i:], math.Float32bits(v))
+	}
+	return json.Marshal(base64.StdEncoding.EncodeToString(buf))
+}
+
+func (e *embedding) UnmarshalJSON(data []byte) error {
+	var encoded string
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return err
+	}
+	buf, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(buf)%4 != 0 {
+		return errors.New("malformed embedding")
+	}
+	*e = make(embedding, len(buf)/4)
+	for i := range *e {
+		(*e)[i] = math.Float32frombits(binary.LittleEndian.Uint32(buf[4*i:]))
+	}
+	return nil
+}
+
+// indexMu serializes updates to index files, so the tabs of one process
+// never write over each other's changes.
+var indexMu sync.Mutex
+
+func indexPath(root string) string {
+	sum := sha256.Sum256([]byte(root))
+	return filepath.Join(codybotHome(), "index", slugify(filepath.Base(root))+"-"+hex.EncodeToString(sum[:6])+".json")
+}
+
+// embeddingModel is --embedding-model, or the provider's usual one.
+func (cfg config) embeddingModel() string {
+	switch {
+	case cfg.EmbeddingModel != "":
+		return cfg.EmbeddingModel
+	case cfg.Provider == providerOllama:
+		return defaultOllamaEmbedder
+	case cfg.Provider == providerOpenRouter:
+		return "openai/" + defaultEmbeddingModel
+	}
+	return defaultEmbeddingModel
+}
+
+// loadIndex returns the workspace's index, or nil when it has none.
+func loadIndex(root string) (*semanticIndex, error) {
+	data, err := os.ReadFile(indexPath(root))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var ix semanticIndex
+	if err := json.Unmarshal(data, &ix); err != nil {
+		return nil, fmt.Errorf("%s: %w; run codybot index to rebuild it", indexPath(root), err)
+	}
+	if ix.Version > indexVersion {
+		return nil, fmt.Errorf("%s was written by a newer codybot", indexPath(root))
+	}
+	if ix.Files == nil {
+		ix.Files = map[string]indexedFile{}
+	}
+	return &ix, nil
+}
+
+func saveIndex(root string, ix *semanticIndex) error {
+	ix.Version = indexVersion
+	ix.UpdatedAt = time.Now().UTC()
+	data, err := json.Marshal(ix)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(indexPath(root), data, 0o600)
+}
+
+func hasIndex() bool {
+	root, err := workspaceRoot()
+	return err == nil && fileExists(indexPath(root))
+}
+
+// indexableFiles lists the workspace files worth indexing, relative to root
+// with forward slashes: the directories @ completion skips and lock files are
+// left out.
+func indexableFiles(root string) []string {
+	var files []string
+	errFull := errors.New("index full")
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if path != root && skippedDirs[d.Name()] {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || lockFiles[d.Name()] {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return nil
+		}
+		files = append(files, filepath.ToSlash(rel))
+		if len(files) >= maxIndexFiles {
+			return errFull
+		}
+		return nil
+	})
+	return files
+}
+
+// readIndexable returns the text of a file to index, or false for one that
+// is gone, binary, or too large to be worth embedding.
+func readIndexable(root, file string) (string, bool) {
+	path := filepath.Join(root, filepath.FromSlash(file))
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() || info.Size() > maxIndexFileBytes {
+		return "", false
+	}
+	data, err := os.ReadFile(path)
+	if err != nil || len(bytes.TrimSpace(data)) == 0 || bytes.IndexByte(data, 0) >= 0 || !utf8.Valid(data) {
+		return "", false
+	}
+	return string(data), true
+}
+
+// pendingChunk is a chunk cut from a file's current content, waiting for its
+// vector.
+type pendingChunk struct {
+	file  string
+	index int
+	text  string
+}
+
+// indexStats counts what an update did.
+type indexStats struct {
+	files, embedded, reused, removed int
+}
+
+// updateIndex brings the entries for files up to date with the disk: files
+// that are gone or no longer indexable are dropped, unchanged files are
+// skipped, and of a changed file only the chunks whose text changed are
+// embedded again.
+func updateIndex(ctx context.Context, cfg config, root string, ix *semanticIndex, files []string, report func(string)) (indexStats, error) {
+	var stats indexStats
+	var pending []pendingChunk
+	for _, file := range files {
+		content, ok := readIndexable(root, file)
+		if !ok {
+			if _, indexed := ix.Files[file]; indexed {
+				delete(ix.Files, file)
+				stats.removed++
+			}
+			continue
+		}
+		stats.files++
+		hash := textHash(content)
+		old, indexed := ix.Files[file]
+		if indexed && old.Hash == hash {
+			stats.reused += len(old.Chunks)
+			continue
+		}
+		known := map[string]embedding{}
+		for _, c := range old.Chunks {
+			known[c.Hash] = c.Vector
+		}
+		entry := indexedFile{Hash: hash}
+		for i, c := range chunkLines(content) {
+			chunk := indexChunk{Start: c.start, End: c.end, Hash: textHash(file + "\x00" + c.text)}
+			if vector, ok := known[chunk.Hash]; ok {
+				chunk.Vector = vector
+				stats.reused++
+			} else {
+				// The path goes in so names count too; line numbers stay
+				// out, so chunks an edit only moved keep their vectors.
+				pending = append(pending, pendingChunk{file: file, index: i, text: file + "\n" + c.text})
+			}
+			entry.Chunks = append(entry.Chunks, chunk)
+		}
+		ix.Files[file] = entry
+	}
+
+	var masker *redactor
+	if !cfg.NoRedact {
+		r, err := loadRedactor()
+		if err != nil {
+			return stats, fmt.Errorf("redaction policy: %w", err)
+		}
+		masker = r
+	}
+	for start := 0; start < len(pending); start += embedBatch {
+		batch := pending[start:min(start+embedBatch, len(pending))]
+		if report != nil && len(pending) > embedBatch {
+			report(fmt.Sprintf("Embedding chunks %d-%d of %d…", start+1, start+len(batch), len(pending)))
+		}
+		texts := make([]string, len(batch))
+		for i, p := range batch {
+			texts[i] = p.text
+			if masker != nil {
+				texts[i], _ = masker.redact(p.text)
+			}
+		}
+		vectors, err := embedTexts(ctx, cfg, texts)
+		if err != nil {
+			// Files whose chunks are not all embedded are dropped, so the
+			// next update embeds them again instead of skipping them as
+			// unchanged.
+			for _, p := range pending[start:] {
+				delete(ix.Files, p.file)
+			}
+			return stats, err
+		}
+		for i, p := range batch {
+			ix.Files[p.file].Chunks[p.index].Vector = vectors[i]
+		}
+		stats.embedded += len(batch)
+	}
+	return stats, nil
+}
+
+func textHash(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:16])
+}
+
+type lineChunk struct {
+	start, end int
+	text       string
+}
+
+// chunkLines cuts text into pieces at the first blank line after
+// minChunkLines lines, or at maxChunkLines. Cutting at blank lines keeps
+// functions and paragraphs together, and means a chunk's boundaries depend
+// on its own text: an edit changes the chunks around it, while those after
+// realign at the next blank line and keep their hashes.
+func chunkLines(text string) []lineChunk {
+	lines := strings.Split(strings.TrimRight(text, "\n"), "\n")
+	var chunks []lineChunk
+	start := 0
+	for i, line := range lines {
+		n := i - start + 1
+		if i < len(lines)-1 && n < maxChunkLines && (n < minChunkLines || strings.TrimSpace(line) != "") {
+			continue
+		}
+		if piece := strings.Join(lines[start:i+1], "\n"); strings.TrimSpace(piece) != "" {
+			chunks = append(chunks, lineChunk{start: start + 1, end: i + 1, text: piece})
+		}
+		start = i + 1
+	}
+	return chunks
+}
+
+// embedTexts gets one vector per text from the endpoint: /api/embed for
+// Ollama, /embeddings for OpenAI-compatible APIs.
+func embedTexts(ctx context.Context, cfg config, texts []string) ([]embedding, error) {
+	model := cfg.embeddingModel()
+	if cfg.Provider == providerOllama {
+		resp, err := postOllama(ctx, cfg, "/api/embed", map[string]any{"model": model, "input": texts})
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		var result struct {
+			Embeddings []embedding `json:"embeddings"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			return nil, fmt.Errorf("unexpected response from /api/embed: %w", err)
+		}
+		return checkEmbeddings(result.Embeddings, len(texts))
+	}
+
+	data, err := json.Marshal(map[string]any{"model": model, "input": texts})
+	if err != nil {
+		return nil, err
+	}
+	url := strings.TrimRight(cfg.BaseURL, "/") + "/embeddings"
+	client, err := httpClientFor(cfg)
+	if err != nil {
+		return nil, err
+	}
+	for reauthorized := false; ; reauthorized = true {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if err := cfg.authorize(req); err != nil {
+			return nil, err
+		}
+		setOpenRouterHeaders(req, cfg)
+		setRequestExtras(req, cfg)
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode >= 300 {
+			if resp.StatusCode == http.StatusUnauthorized && !reauthorized {
+				if _, changed, err := cfg.refreshCredentials(ctx); err == nil && changed {
+					continue
+				}
+			}
+			body, _ := truncateRunes(strings.TrimSpace(string(body)), 8192)
+			return nil, &apiError{StatusCode: resp.StatusCode, Status: resp.Status, Body: body}
+		}
+		var result struct {
+			Data []struct {
+				Index     int       `json:"index"`
+				Embedding []float32 `json:"embedding"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(body, &result); err != nil {
+			return nil, fmt.Errorf("unexpected response from %s: %w", url, err)
+		}
+		vectors := make([]embedding, len(texts))
+		for _, d := range result.Data {
+			if d.Index >= 0 && d.Index < len(vectors) {
+				vectors[d.Index] = d.Embedding
+			}
+		}
+		return checkEmbeddings(vectors, len(texts))
+	}
+}
+
+func checkEmbeddings(vectors []embedding, want int) ([]embedding, error) {
+	if len(vectors) != want {
+		return nil, fmt.Errorf("the endpoint returned %d embeddings for %d inputs", len(vectors), want)
+	}
+	for _, v := range vectors {
+		if len(v) == 0 {
+			return nil, errors.New("the endpoint returned an empty embedding")
+		}
+	}
+	return vectors, nil
+}
+
+// indexRefreshMsg reports a background update of the index after an edit.
+type indexRefreshMsg struct {
+	err error
+}
+
+// refreshIndex re-embeds, in the background, the chunks of paths that an
+// edit changed, so semantic_search keeps up during long refactors without
+// another codybot index run. Files changed by shell commands wait for that
+// run. Without an index, or with one built by another embedding model, it
+// does nothing.
+func (m *model) refreshIndex(paths []string) tea.Cmd {
+	if m.cfg.Safe || len(paths) == 0 || !hasIndex() {
+		return nil
+	}
+	cfg := m.cfg
+	return func() tea.Msg {
+		indexMu.Lock()
+		defer indexMu.Unlock()
+		root, err := workspaceRoot()
+		if err != nil {
+			return indexRefreshMsg{err: err}
+		}
+		ix, err := loadIndex(root)
+		if err != nil || ix == nil || ix.Model != cfg.embeddingModel() {
+			return indexRefreshMsg{err: err}
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), indexRefreshTimeout)
+		defer cancel()
+		_, err = updateIndex(ctx, cfg, root, ix, workspaceFiles(root, paths), nil)
+		if saveErr := saveIndex(root, ix); err == nil {
+			err = saveErr
+		}
+		return indexRefreshMsg{err: err}
+	}
+}
+
+// indexMatch is one chunk found by a search.
+type indexMatch struct {
+	file       string
+	start, end int
+	score      float64
+}
+
+// searchIndex ranks the index's chunks by cosine similarity to query.
+func searchIndex(ctx context.Context, cfg config, ix *semanticIndex, query string, limit int) ([]indexMatch, error) {
+	vectors, err := embedTexts(ctx, cfg, []string{query})
+	if err != nil {
+		return nil, err
+	}
+	q := vectors[0]
+	var matches []indexMatch
+	for file, entry := range ix.Files {
+		for _, c := range entry.Chunks {
+			if len(c.Vector) != len(q) {
+				continue
+			}
+			matches = append(matches, indexMatch{file: file, start: c.Start, end: c.End, score: cosine(q, c.Vector)})
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].score != matches[j].score {
+			return matches[i].score > matches[j].score
+		}
+		if matches[i].file != matches[j].file {
+			return matches[i].file < matches[j].file
+		}
+		return matches[i].start < matches[j].start
+	})
+	return matches[:min(limit, len(matches))], nil
+}
+
+func cosine(a, b embedding) float64 {
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(na*nb)
+}
+
+// indexSearcher runs the semantic_search tool, with the config it needs to
+// embed the query.
+type indexSearcher struct {
+	cfg config
+}
+
+func (s indexSearcher) toolSemanticSearch(ctx context.Context, _ *toolEnv, raw json.RawMessage) (string, error) {
+	var args struct {
+		Query string `json:"query"`
+		Limit int    `json:"limit"`
+	}
+	if err := decodeArgs(raw, &args); err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(args.Query) == "" {
+		return "", errors.New("query is required")
+	}
+	limit := args.Limit
+	if limit <= 0 {
+		limit = defaultSearchResults
+	}
+	limit = min(limit, maxSearchResults)
+	root, err := workspaceRoot()
+	if err != nil {
+		return "", err
+	}
+	ix, err := loadIndex(root)
+	if err != nil {
+		return "", err
+	}
+	if ix == nil || len(ix.Files) == 0 {
+		return "", errors.New("this workspace has no semantic index; the user can build one with codybot index")
+	}
+	if ix.Model != s.cfg.embeddingModel() {
+		return "", fmt.Errorf("the index was built with %s, not %s; the user can rebuild it with codybot index", ix.Model, s.cfg.embeddingModel())
+	}
+	matches, err := searchIndex(ctx, s.cfg, ix, args.Query, limit)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	for _, match := range matches {
+		content, ok := readIndexable(root, match.file)
+		if !ok {
+			continue
+		}
+		lines := strings.Split(content, "\n")
+		end := min(match.end, len(lines))
+		if match.start > end {
+			continue
+		}
+		fmt.Fprintf(&b, "%s:%d-%d (similarity %.2f)\n%s\n\n", match.file, match.start, end, match.score, strings.Join(lines[match.start-1:end], "\n"))
+	}
+	if b.Len() == 0 {
+		return "No matches.", nil
+	}
+	return strings.TrimRight(b.String(), "\n"), nil
+}
+
+// runIndexCommand builds or updates the workspace's semantic index.
+func runIndexCommand(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("index", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var cfg config
+	registerConfigFlags(fs, &cfg)
+	rebuild := fs.Bool("rebuild", false, "Embed every file again instead of only those that changed")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintln(stderr, "usage: codybot index [--rebuild] [flags]")
+		return 2
+	}
+	cfg = cfg.normalized()
+	root, err := enterWorkspace(cfg)
+	if err == nil {
+		err = requireTrust(cfg, root)
+	}
+	if err == nil {
+		err = checkRequestExtras(cfg)
+	}
+	if err == nil {
+		err = cfg.attachTokenSource()
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "codybot index: %v\n", err)
+		return 1
+	}
+
+	indexMu.Lock()
+	defer indexMu.Unlock()
+	ix, err := loadIndex(root)
+	if err != nil && !*rebuild {
+		fmt.Fprintf(stderr, "codybot index: %v\n", err)
+		return 1
+	}
+	model := cfg.embeddingModel()
+	if ix == nil || *rebuild || ix.Model != model {
+		ix = &semanticIndex{Model: model, Files: map[string]indexedFile{}}
+	}
+	files := indexableFiles(root)
+	listed := map[string]bool{}
+	for _, file := range files {
+		listed[file] = true
+	}
+	removed := 0
+	for file := range ix.Files {
+		if !listed[file] {
+			delete(ix.Files, file)
+			removed++
+		}
+	}
+	stats, err := updateIndex(context.Background(), cfg, root, ix, files, func(line string) { fmt.Fprintln(stderr, line) })
+	if saveErr := saveIndex(root, ix); err == nil {
+		err = saveErr
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "codybot index: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(stdout, "Indexed %d files with %s: %d chunks embedded, %d unchanged, %d files removed\n", stats.files, model, stats.embedded, stats.reused, removed+stats.removed)
+	return 0
+}
@@ -0,0 +1,462 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+const (
+	journalFileName       = ".codybot/journal.json"
+	maxJournalCheckpoints = 50
+)
+
+type fileVersion struct {
+	Path    string
+	Before  []byte
+	After   []byte
+	Existed bool
+	At      time.Time
+	Patch   string
+}
+
+type checkpoint struct {
+	ID      int
+	Session string
+	Turn    int
+	Prompt  string
+	At      time.Time
+	Changes []fileVersion
+	Open    bool `json:",omitempty"`
+	// Kept is set once the user chose to leave the checkpoint's edits
+	// uncommitted, so startup stops raising them.
+	Kept bool `json:",omitempty"`
+}
+
+// editJournal records every agent file write, grouped into one checkpoint per
+// user turn. When path is set it is saved after every change so /undo works
+// across restarts and without git.
+type editJournal struct {
+	mu          sync.Mutex
+	checkpoints []*checkpoint
+	applied     int
+	nextID      int
+	session     string
+	path        string
+	// turns numbers the turns of every tab sharing the journal, so two
+	// tabs' edits never land in one checkpoint.
+	turns int
+}
+
+type journalFile struct {
+	Applied     int           `json:"applied"`
+	NextID      int           `json:"next_id"`
+	Checkpoints []*checkpoint `json:"checkpoints"`
+}
+
+func newEditJournal() *editJournal {
+	return &editJournal{nextID: 1, session: strconv.FormatInt(time.Now().UnixNano(), 36)}
+}
+
+// openEditJournal loads the journal saved at path, starting empty when there
+// is none yet.
+func openEditJournal(path string) (*editJournal, error) {
+	j := newEditJournal()
+	j.path = path
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return j, nil
+	}
+	if err != nil {
+		return j, err
+	}
+	var saved journalFile
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return j, fmt.Errorf("%s: %w", path, err)
+	}
+	j.checkpoints = saved.Checkpoints
+	j.applied = min(max(saved.Applied, 0), len(saved.Checkpoints))
+	j.nextID = max(saved.NextID, 1)
+	return j, nil
+}
+
+// save writes the journal atomically, dropping the oldest checkpoints beyond
+// maxJournalCheckpoints. Callers hold j.mu.
+func (j *editJournal) save() error {
+	if j.path == "" {
+		return nil
+	}
+	if drop := len(j.checkpoints) - maxJournalCheckpoints; drop > 0 {
+		j.checkpoints = j.checkpoints[drop:]
+		j.applied = max(j.applied-drop, 0)
+	}
+	data, err := json.Marshal(journalFile{Applied: j.applied, NextID: j.nextID, Checkpoints: j.checkpoints})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(j.path), 0o755); err != nil {
+		return err
+	}
+	tmp := j.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, j.path)
+}
+
+func (j *editJournal) writeFile(turn int, prompt, path string, content []byte) error {
+	before, err := os.ReadFile(path)
+	existed := err == nil
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := writeFileAtomic(path, content, 0o644); err != nil {
+		return err
+	}
+	version := fileVersion{Path: path, Before: before, After: content, Existed: existed, At: time.Now()}
+	version.Patch = unifiedDiff(path, before, content, existed)
+	if err := j.record(turn, prompt, version); err != nil {
+		return fmt.Errorf("wrote %s but could not save the edit journal: %w", path, err)
+	}
+	return nil
+}
+
+// nextTurn numbers a new turn.
+func (j *editJournal) nextTurn() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.turns++
+	return j.turns
+}
+
+// lastTurn is the number nextTurn gave out last.
+func (j *editJournal) lastTurn() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.turns
+}
+
+func (j *editJournal) record(turn int, prompt string, version fileVersion) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.checkpoints = j.checkpoints[:j.applied]
+	var cp *checkpoint
+	if n := len(j.checkpoints); n > 0 && j.checkpoints[n-1].Session == j.session && j.checkpoints[n-1].Turn == turn {
+		cp = j.checkpoints[n-1]
+	} else {
+		cp = &checkpoint{ID: j.nextID, Session: j.session, Turn: turn, Prompt: prompt, At: version.At, Open: true}
+		j.nextID++
+		j.checkpoints = append(j.checkpoints, cp)
+		j.applied = len(j.checkpoints)
+	}
+	cp.Changes = append(cp.Changes, version)
+	return j.save()
+}
+
+func (j *editJournal) undo() (*checkpoint, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.applied == 0 {
+		return nil, errors.New("nothing to undo")
+	}
+	cp := j.checkpoints[j.applied-1]
+	if err := checkConflicts(cp.Changes, true); err != nil {
+		return nil, err
+	}
+	if err := j.apply("undo", cp, checkpointTargets(cp.Changes, true)); err != nil {
+		return nil, err
+	}
+	j.applied--
+	if err := j.save(); err != nil {
+		return cp, fmt.Errorf("undid checkpoint #%d but could not save the edit journal: %w", cp.ID, err)
+	}
+	j.clearPending()
+	return cp, nil
+}
+
+func (j *editJournal) redo() (*checkpoint, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.applied >= len(j.checkpoints) {
+		return nil, errors.New("nothing to redo")
+	}
+	cp := j.checkpoints[j.applied]
+	if err := checkConflicts(cp.Changes, false); err != nil {
+		return nil, err
+	}
+	if err := j.apply("redo", cp, checkpointTargets(cp.Changes, false)); err != nil {
+		return nil, err
+	}
+	j.applied++
+	if err := j.save(); err != nil {
+		return cp, fmt.Errorf("redid checkpoint #%d but could not save the edit journal: %w", cp.ID, err)
+	}
+	j.clearPending()
+	return cp, nil
+}
+
+// checkConflicts refuses to touch files that were edited outside the journal
+// since the checkpoint was recorded. Undo expects each path to still hold its
+// last written version; redo expects the version from before the first write.
+func checkConflicts(changes []fileVersion, undo bool) error {
+	var conflicts []string
+	checked := map[string]bool{}
+	for i := range changes {
+		path := changes[i].Path
+		if checked[path] {
+			continue
+		}
+		checked[path] = true
+
+		want, exists := changes[i].Before, changes[i].Existed
+		if undo {
+			want, exists = changes[lastVersionIndex(changes, path)].After, true
+		}
+		current, err := os.ReadFile(path)
+		switch {
+		case err != nil && !errors.Is(err, os.ErrNotExist):
+			return err
+		case err != nil && exists, err == nil && !exists, err == nil && !bytes.Equal(current, want):
+			conflicts = append(conflicts, path)
+		}
+	}
+	if len(conflicts) > 0 {
+		return fmt.Errorf("files changed outside codybot since the checkpoint: %s", strings.Join(conflicts, ", "))
+	}
+	return nil
+}
+
+func lastVersionIndex(changes []fileVersion, path string) int {
+	for i := len(changes) - 1; i >= 0; i-- {
+		if changes[i].Path == path {
+			return i
+		}
+	}
+	return -1
+}
+
+func (j *editJournal) snapshot() ([]checkpoint, int) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	out := make([]checkpoint, len(j.checkpoints))
+	for i, cp := range j.checkpoints {
+		out[i] = *cp
+	}
+	return out, j.applied
+}
+
+func (j *editJournal) jumpTo(applied int) error {
+	for {
+		_, current := j.snapshot()
+		switch {
+		case current > applied:
+			if _, err := j.undo(); err != nil {
+				return err
+			}
+		case current < applied:
+			if _, err := j.redo(); err != nil {
+				return err
+			}
+		default:
+			return nil
+		}
+	}
+}
+
+func (cp checkpoint) files() []string {
+	var files []string
+	seen := map[string]bool{}
+	for _, v := range cp.Changes {
+		if !seen[v.Path] {
+			seen[v.Path] = true
+			files = append(files, v.Path)
+		}
+	}
+	return files
+}
+
+func (m *model) cmdUndo(string) tea.Cmd {
+	if m.streaming {
+		m.notice = "Wait for the current response to finish before undoing"
+		return nil
+	}
+	cp, err := m.journal.undo()
+	if err != nil {
+		m.lastErr = err
+		return nil
+	}
+	m.lastErr = nil
+	m.notice = fmt.Sprintf("Undid checkpoint #%d (%s)", cp.ID, strings.Join(cp.files(), ", "))
+	return m.filesChanged(cp.files())
+}
+
+func (m *model) cmdRedo(string) tea.Cmd {
+	if m.streaming {
+		m.notice = "Wait for the current response to finish before redoing"
+		return nil
+	}
+	cp, err := m.journal.redo()
+	if err != nil {
+		m.lastErr = err
+		return nil
+	}
+	m.lastErr = nil
+	m.notice = fmt.Sprintf("Redid checkpoint #%d (%s)", cp.ID, strings.Join(cp.files(), ", "))
+	return m.filesChanged(cp.files())
+}
+
+func (m *model) cmdTimeline(string) tea.Cmd {
+	checkpoints, applied := m.journal.snapshot()
+	if len(checkpoints) == 0 {
+		m.notice = "No file changes recorded yet"
+		return nil
+	}
+	m.state = stateTimeline
+	m.timelineCursor = max(applied-1, 0)
+	return nil
+}
+
+func (m model) updateTimeline(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	checkpoints, applied := m.journal.snapshot()
+	var cmd tea.Cmd
+	switch msg.String() {
+	case "up", "k":
+		m.timelineCursor = min(m.timelineCursor+1, len(checkpoints)-1)
+	case "down", "j":
+		m.timelineCursor = max(m.timelineCursor-1, -1)
+	case "enter":
+		target := m.timelineCursor + 1
+		if err := m.journal.jumpTo(target); err != nil {
+			m.lastErr = err
+		} else {
+			m.lastErr = nil
+			var files []string
+			for _, cp := range checkpoints[min(applied, target):max(applied, target)] {
+				files = append(files, cp.files()...)
+			}
+			cmd = m.filesChanged(files)
+			m.notice = fmt.Sprintf("Restored files to checkpoint %d of %d", target, len(checkpoints))
+		}
+	case "u":
+		if cp, err := m.journal.undo(); err != nil {
+			m.lastErr = err
+		} else {
+			cmd = m.filesChanged(cp.files())
+		}
+		_, applied = m.journal.snapshot()
+		m.timelineCursor = applied - 1
+	case "r":
+		if cp, err := m.journal.redo(); err != nil {
+			m.lastErr = err
+		} else {
+			cmd = m.filesChanged(cp.files())
+		}
+		_, applied = m.journal.snapshot()
+		m.timelineCursor = applied - 1
+	case "d":
+		m.timelineDiff = !m.timelineDiff
+	case "esc", "q":
+		m.state = stateChat
+	case "ctrl+c":
+		return m, tea.Quit
+	}
+	return m, cmd
+}
+
+func (m model) viewTimeline() string {
+	checkpoints, applied := m.journal.snapshot()
+	var b strings.Builder
+	b.WriteString(headerStyle.Render("codybot edit timeline"))
+	b.WriteString("\n\n")
+
+	marker := "  "
+	if m.timelineCursor == -1 {
+		marker = "> "
+	}
+	b.WriteString(marker + subtleStyle.Render("(original files, before any agent edits)") + "\n")
+	for i := len(checkpoints) - 1; i >= 0; i-- {
+		cp := checkpoints[i]
+		marker := "  "
+		if i == m.timelineCursor {
+			marker = "> "
+		}
+		state := "●"
+		if i >= applied {
+			state = "○"
+		}
+		prompt, _ := truncateRunes(strings.ReplaceAll(cp.Prompt, "\n", " "), 60)
+		line := fmt.Sprintf("%s%s #%d %s  %q", marker, state, cp.ID, cp.At.Format("15:04:05"), prompt)
+		if i == m.timelineCursor {
+			line = timelineSelectedStyle.Render(line)
+		}
+		b.WriteString(line + "\n")
+		for _, v := range cp.Changes {
+			added, removed := lineDelta(v.Before, v.After)
+			label := "modified"
+			if !v.Existed {
+				label = "created"
+			}
+			b.WriteString(subtleStyle.Render(fmt.Sprintf("      %s %s %s (+%d/-%d)", v.At.Format("15:04:05"), label, v.Path, added, removed)))
+			b.WriteString("\n")
+			if m.timelineDiff && i == m.timelineCursor {
+				b.WriteString(renderPatch(v.Patch, "        "))
+			}
+		}
+	}
+	if m.lastErr != nil {
+		b.WriteString("\nError: " + m.lastErr.Error() + "\n")
+	}
+	b.WriteString("\n" + subtleStyle.Render("↑/↓ select • Enter restore to selection • u undo • r redo • d diff • Esc back"))
+	return b.String()
+}
+
+func lineDelta(before, after []byte) (int, int) {
+	counts := map[string]int{}
+	for _, line := range strings.Split(string(before), "\n") {
+		counts[line]++
+	}
+	added := 0
+	for _, line := range strings.Split(string(after), "\n") {
+		if counts[line] > 0 {
+			counts[line]--
+			continue
+		}
+		added++
+	}
+	removed := 0
+	for _, n := range counts {
+		removed += n
+	}
+	if len(before) == 0 {
+		removed = 0
+	}
+	return added, removed
+}
+
+func renderPatch(patch, indent string) string {
+	var b strings.Builder
+	for _, line := range strings.Split(strings.TrimRight(patch, "\n"), "\n") {
+		switch {
+		case strings.HasPrefix(line, "+++"), strings.HasPrefix(line, "---"):
+			line = subtleStyle.Render(line)
+		case strings.HasPrefix(line, "+"):
+			line = diffAddStyle.Render(line)
+		case strings.HasPrefix(line, "-"):
+			line = diffRemoveStyle.Render(line)
+		case strings.HasPrefix(line, "@@"):
+			line = diffHunkStyle.Render(line)
+		}
+		b.WriteString(indent + line + "\n")
+	}
+	return b.String()
+}
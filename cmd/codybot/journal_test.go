@@ -0,0 +1,215 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// journalFixture is a journal with two checkpoints: the first creates a.txt,
+// the second rewrites it and creates b.txt.
+func journalFixture(t *testing.T) (*editJournal, string) {
+	t.Helper()
+	dir := t.TempDir()
+	j, err := openEditJournal(filepath.Join(dir, journalFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b := filepath.Join(dir, "a.txt"), filepath.Join(dir, "b.txt")
+	writes := []struct {
+		turn    int
+		path    string
+		content string
+	}{
+		{1, a, "one"},
+		{2, a, "two"},
+		{2, b, "b"},
+	}
+	for _, w := range writes {
+		if err := j.writeFile(w.turn, "prompt", w.path, []byte(w.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return j, dir
+}
+
+// wantFiles checks each file's content, "" meaning it must not exist.
+func wantFiles(t *testing.T, dir string, want map[string]string) {
+	t.Helper()
+	for name, content := range want {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		switch {
+		case content == "" && err == nil:
+			t.Errorf("%s exists with %q, want it gone", name, data)
+		case content != "" && err != nil:
+			t.Errorf("%s: %v, want %q", name, err, content)
+		case content != "" && string(data) != content:
+			t.Errorf("%s = %q, want %q", name, data, content)
+		}
+	}
+}
+
+func TestJournalUndoConflicts(t *testing.T) {
+	tests := []struct {
+		name     string
+		tamper   func(dir string) error
+		conflict string
+		files    map[string]string
+	}{
+		{
+			name:  "clean",
+			files: map[string]string{"a.txt": "one", "b.txt": ""},
+		},
+		{
+			name:     "edited since",
+			tamper:   func(dir string) error { return os.WriteFile(filepath.Join(dir, "a.txt"), []byte("mine"), 0o644) },
+			conflict: "a.txt",
+			files:    map[string]string{"a.txt": "mine", "b.txt": "b"},
+		},
+		{
+			name:     "deleted since",
+			tamper:   func(dir string) error { return os.Remove(filepath.Join(dir, "b.txt")) },
+			conflict: "b.txt",
+			files:    map[string]string{"a.txt": "two", "b.txt": ""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			j, dir := journalFixture(t)
+			if tt.tamper != nil {
+				if err := tt.tamper(dir); err != nil {
+					t.Fatal(err)
+				}
+			}
+			_, err := j.undo()
+			if tt.conflict == "" && err != nil {
+				t.Fatalf("undo: %v", err)
+			}
+			if tt.conflict != "" && (err == nil || !strings.Contains(err.Error(), tt.conflict)) {
+				t.Fatalf("undo error = %v, want a conflict on %s", err, tt.conflict)
+			}
+			if _, applied := j.snapshot(); tt.conflict != "" && applied != 2 {
+				t.Errorf("applied = %d after a refused undo, want 2", applied)
+			}
+			wantFiles(t, dir, tt.files)
+		})
+	}
+}
+
+func TestJournalRedoConflicts(t *testing.T) {
+	tests := []struct {
+		name     string
+		tamper   func(dir string) error
+		conflict string
+		files    map[string]string
+	}{
+		{
+			name:  "clean",
+			files: map[string]string{"a.txt": "two", "b.txt": "b"},
+		},
+		{
+			name:     "edited since",
+			tamper:   func(dir string) error { return os.WriteFile(filepath.Join(dir, "a.txt"), []byte("mine"), 0o644) },
+			conflict: "a.txt",
+			files:    map[string]string{"a.txt": "mine", "b.txt": ""},
+		},
+		{
+			name:     "created since",
+			tamper:   func(dir string) error { return os.WriteFile(filepath.Join(dir, "b.txt"), []byte("mine"), 0o644) },
+			conflict: "b.txt",
+			files:    map[string]string{"a.txt": "one", "b.txt": "mine"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			j, dir := journalFixture(t)
+			if _, err := j.undo(); err != nil {
+				t.Fatal(err)
+			}
+			if tt.tamper != nil {
+				if err := tt.tamper(dir); err != nil {
+					t.Fatal(err)
+				}
+			}
+			_, err := j.redo()
+			if tt.conflict == "" && err != nil {
+				t.Fatalf("redo: %v", err)
+			}
+			if tt.conflict != "" && (err == nil || !strings.Contains(err.Error(), tt.conflict)) {
+				t.Fatalf("redo error = %v, want a conflict on %s", err, tt.conflict)
+			}
+			wantFiles(t, dir, tt.files)
+		})
+	}
+}
+
+func TestJournalJumpTo(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    []int
+		applied int
+		wantErr bool
+		files   map[string]string
+	}{
+		{name: "stay", path: []int{2}, applied: 2, files: map[string]string{"a.txt": "two", "b.txt": "b"}},
+		{name: "back one", path: []int{1}, applied: 1, files: map[string]string{"a.txt": "one", "b.txt": ""}},
+		{name: "back to start", path: []int{0}, applied: 0, files: map[string]string{"a.txt": "", "b.txt": ""}},
+		{name: "back and forward", path: []int{0, 2}, applied: 2, files: map[string]string{"a.txt": "two", "b.txt": "b"}},
+		{name: "back then partway", path: []int{0, 1}, applied: 1, files: map[string]string{"a.txt": "one", "b.txt": ""}},
+		{name: "past the end", path: []int{3}, applied: 2, wantErr: true, files: map[string]string{"a.txt": "two", "b.txt": "b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			j, dir := journalFixture(t)
+			var err error
+			for _, applied := range tt.path {
+				if err = j.jumpTo(applied); err != nil {
+					break
+				}
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("jumpTo error = %v, want error %v", err, tt.wantErr)
+			}
+			if _, applied := j.snapshot(); applied != tt.applied {
+				t.Errorf("applied = %d, want %d", applied, tt.applied)
+			}
+			wantFiles(t, dir, tt.files)
+		})
+	}
+}
+
+// TestJournalJumpToConflict checks that a jump stops at the first
+// checkpoint whose files changed, leaving the later ones undone.
+func TestJournalJumpToConflict(t *testing.T) {
+	j, dir := journalFixture(t)
+	if err := j.jumpTo(0); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "b.txt"), []byte("mine"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.jumpTo(2); err == nil || !strings.Contains(err.Error(), "b.txt") {
+		t.Fatalf("jumpTo error = %v, want a conflict on b.txt", err)
+	}
+	if _, applied := j.snapshot(); applied != 1 {
+		t.Errorf("applied = %d, want 1", applied)
+	}
+	wantFiles(t, dir, map[string]string{"a.txt": "one", "b.txt": "mine"})
+}
+
+// TestJournalReopen checks that undo survives a restart.
+func TestJournalReopen(t *testing.T) {
+	j, dir := journalFixture(t)
+	reopened, err := openEditJournal(j.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, applied := reopened.snapshot(); applied != 2 {
+		t.Fatalf("applied = %d after reopening, want 2", applied)
+	}
+	if _, err := reopened.undo(); err != nil {
+		t.Fatal(err)
+	}
+	wantFiles(t, dir, map[string]string{"a.txt": "one", "b.txt": ""})
+}
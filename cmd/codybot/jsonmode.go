@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+const maxSchemaErrors = 10
+
+type responseFormat struct {
+	Type       string            `json:"type"`
+	JSONSchema *jsonSchemaFormat `json:"json_schema,omitempty"`
+}
+
+type jsonSchemaFormat struct {
+	Name   string          `json:"name"`
+	Schema json.RawMessage `json:"schema"`
+}
+
+// jsonRequest is a /json turn: the answer must be JSON matching schema.
+type jsonRequest struct {
+	name   string
+	schema json.RawMessage
+}
+
+// parseJSONArgs splits /json arguments into the schema, given inline as a
+// JSON object or as a path to a file holding one, and the prompt after it.
+func parseJSONArgs(args string) (*jsonRequest, string, error) {
+	req := &jsonRequest{name: "inline schema"}
+	var rest string
+	if strings.HasPrefix(args, "{") {
+		dec := json.NewDecoder(strings.NewReader(args))
+		if err := dec.Decode(&req.schema); err != nil {
+			return nil, "", fmt.Errorf("inline schema: %w", err)
+		}
+		rest = args[dec.InputOffset():]
+	} else {
+		path, after, _ := strings.Cut(args, " ")
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, "", err
+		}
+		req.name, req.schema, rest = filepath.Base(path), data, after
+	}
+	var schema map[string]any
+	if err := json.Unmarshal(req.schema, &schema); err != nil {
+		return nil, "", fmt.Errorf("%s is not a JSON object: %w", req.name, err)
+	}
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, req.schema); err != nil {
+		return nil, "", err
+	}
+	req.schema = compact.Bytes()
+	return req, strings.TrimSpace(rest), nil
+}
+
+// validateJSON checks value against the commonly used subset of JSON Schema:
+// type, enum, const, properties, required, additionalProperties, items,
+// length and range bounds, anyOf, oneOf, and allOf. Other keywords, like
+// $ref and pattern, are not checked.
+func validateJSON(schema map[string]any, value any, path string, errs *[]string) {
+	if len(*errs) >= maxSchemaErrors {
+		return
+	}
+	fail := func(format string, args ...any) {
+		if len(*errs) < maxSchemaErrors {
+			*errs = append(*errs, path+": "+fmt.Sprintf(format, args...))
+		}
+	}
+	if types, ok := schemaTypes(schema["type"]); ok && !matchesType(types, value) {
+		fail("expected %s, got %s", strings.Join(types, " or "), jsonType(value))
+		return
+	}
+	if enum, ok := schema["enum"].([]any); ok && !containsJSON(enum, value) {
+		fail("not one of the allowed values")
+	}
+	if c, ok := schema["const"]; ok && !containsJSON([]any{c}, value) {
+		fail("does not equal the required constant")
+	}
+	for _, sub := range schemaList(schema["allOf"]) {
+		validateJSON(sub, value, path, errs)
+	}
+	if anyOf := schemaList(schema["anyOf"]); len(anyOf) > 0 && countMatches(anyOf, value) == 0 {
+		fail("matches none of the anyOf schemas")
+	}
+	if oneOf := schemaList(schema["oneOf"]); len(oneOf) > 0 {
+		if n := countMatches(oneOf, value); n != 1 {
+			fail("matches %d of the oneOf schemas instead of exactly one", n)
+		}
+	}
+	switch v := value.(type) {
+	case map[string]any:
+		props, _ := schema["properties"].(map[string]any)
+		if required, ok := schema["required"].([]any); ok {
+			for _, name := range required {
+				if key, ok := name.(string); ok {
+					if _, present := v[key]; !present {
+						fail("missing required property %q", key)
+					}
+				}
+			}
+		}
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if sub, ok := props[key].(map[string]any); ok {
+				validateJSON(sub, v[key], path+"."+key, errs)
+				continue
+			}
+			switch extra := schema["additionalProperties"].(type) {
+			case bool:
+				if !extra {
+					fail("unexpected property %q", key)
+				}
+			case map[string]any:
+				validateJSON(extra, v[key], path+"."+key, errs)
+			}
+		}
+	case []any:
+		if n, ok := schema["minItems"].(float64); ok && float64(len(v)) < n {
+			fail("has %d items, fewer than %v", len(v), n)
+		}
+		if n, ok := schema["maxItems"].(float64); ok && float64(len(v)) > n {
+			fail("has %d items, more than %v", len(v), n)
+		}
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, item := range v {
+				validateJSON(items, item, fmt.Sprintf("%s[%d]", path, i), errs)
+			}
+		}
+	case string:
+		n := float64(utf8.RuneCountInString(v))
+		if min, ok := schema["minLength"].(float64); ok && n < min {
+			fail("is shorter than %v characters", min)
+		}
+		if max, ok := schema["maxLength"].(float64); ok && n > max {
+			fail("is longer than %v characters", max)
+		}
+	case float64:
+		if min, ok := schema["minimum"].(float64); ok && v < min {
+			fail("%v is below the minimum %v", v, min)
+		}
+		if max, ok := schema["maximum"].(float64); ok && v > max {
+			fail("%v is above the maximum %v", v, max)
+		}
+	}
+}
+
+func schemaTypes(raw any) ([]string, bool) {
+	switch t := raw.(type) {
+	case string:
+		return []string{t}, true
+	case []any:
+		var types []string
+		for _, v := range t {
+			if s, ok := v.(string); ok {
+				types = append(types, s)
+			}
+		}
+		return types, len(types) > 0
+	}
+	return nil, false
+}
+
+func schemaList(raw any) []map[string]any {
+	list, _ := raw.([]any)
+	var out []map[string]any
+	for _, v := range list {
+		if s, ok := v.(map[string]any); ok {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func countMatches(schemas []map[string]any, value any) int {
+	n := 0
+	for _, s := range schemas {
+		var errs []string
+		validateJSON(s, value, "", &errs)
+		if len(errs) == 0 {
+			n++
+		}
+	}
+	return n
+}
+
+func jsonType(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	}
+	return "object"
+}
+
+func matchesType(types []string, value any) bool {
+	actual := jsonType(value)
+	for _, t := range types {
+		if t == actual || (t == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+func containsJSON(values []any, value any) bool {
+	want, _ := json.Marshal(value)
+	for _, v := range values {
+		if got, _ := json.Marshal(v); bytes.Equal(got, want) {
+			return true
+		}
+	}
+	return false
+}
+
+// extractJSON finds the JSON value in an answer, allowing for the code fence
+// models add when the endpoint ignores response_format.
+func extractJSON(response string) string {
+	text := strings.TrimSpace(response)
+	if strings.HasPrefix(text, "```") {
+		text = text[strings.IndexByte(text+"\n", '\n'):]
+		if end := strings.LastIndex(text, "```"); end >= 0 {
+			text = text[:end]
+		}
+	}
+	return strings.TrimSpace(text)
+}
+
+// finishJSON pretty-prints the answer to a /json turn in place and reports
+// whether it matches the schema.
+func (m *model) finishJSON(response string) {
+	req := m.jsonTurn
+	m.jsonTurn = nil
+	if req == nil {
+		return
+	}
+	raw := extractJSON(response)
+	var value any
+	if err := json.Unmarshal([]byte(raw), &value); err != nil {
+		m.lastErr = fmt.Errorf("json: the answer is not valid JSON: %w", err)
+		return
+	}
+	var pretty bytes.Buffer
+	json.Indent(&pretty, []byte(raw), "", "  ")
+	if last := m.transcript.last(); last != nil && last.kind == blockAssistant {
+		last.text = pretty.String()
+		m.transcript.invalidate()
+	}
+	m.lastJSON = pretty.String()
+	var schema map[string]any
+	json.Unmarshal(req.schema, &schema)
+	var errs []string
+	validateJSON(schema, value, "$", &errs)
+	if len(errs) > 0 {
+		m.appendNote(fmt.Sprintf("The answer does not match %s:\n  %s", req.name, strings.Join(errs, "\n  ")))
+		m.notice = fmt.Sprintf("JSON answer fails %d schema checks • /json copy copies it anyway", len(errs))
+		return
+	}
+	m.refreshTranscript()
+	m.notice = fmt.Sprintf("JSON answer matches %s • /json copy copies it", req.name)
+}
+
+func (m *model) cmdJSON(args string) tea.Cmd {
+	if args == "copy" {
+		if m.lastJSON == "" {
+			m.notice = "No JSON answer yet; /json <schema> <prompt> asks for one"
+			return nil
+		}
+		copyText(m.lastJSON)
+		m.notice = fmt.Sprintf("Copied the JSON answer (%d chars)", len(m.lastJSON))
+		return nil
+	}
+	if m.streaming {
+		m.notice = "Wait for the current response to finish"
+		return nil
+	}
+	req, prompt, err := parseJSONArgs(args)
+	if err == nil && prompt == "" {
+		err = errors.New("a prompt is required after the schema")
+	}
+	if args == "" || err != nil {
+		m.notice = "Usage: /json <schema.json|{inline schema}> <prompt> | /json copy"
+		m.lastErr = err
+		return nil
+	}
+	m.jsonTurn = req
+	content := fmt.Sprintf("%s\n\nAnswer with only a JSON value that matches this JSON Schema, without code fences or commentary:\n%s", prompt, req.schema)
+	return m.send(fmt.Sprintf("[json: %s] %s", req.name, prompt), content)
+}
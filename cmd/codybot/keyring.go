@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+const keyringService = "codybot"
+
+var errSecretNotFound = errors.New("secret not found")
+
+// keyringGet reads a secret from the OS keyring (macOS Keychain or the Secret
+// Service on Linux) and falls back to a 0600 file under ~/.codybot when no
+// keyring tool is available.
+func keyringGet(account string) (string, error) {
+	switch {
+	case runtime.GOOS == "darwin" && hasCommand("security"):
+		out, err := exec.Command("security", "find-generic-password", "-s", keyringService, "-a", account, "-w").Output()
+		if err != nil {
+			return "", errSecretNotFound
+		}
+		return strings.TrimRight(string(out), "\n"), nil
+	case runtime.GOOS == "linux" && hasCommand("secret-tool"):
+		out, err := exec.Command("secret-tool", "lookup", "service", keyringService, "account", account).Output()
+		if err != nil || len(out) == 0 {
+			return "", errSecretNotFound
+		}
+		return string(out), nil
+	}
+	data, err := os.ReadFile(credentialFile(account))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", errSecretNotFound
+	}
+	return string(data), err
+}
+
+func keyringSet(account, secret string) error {
+	switch {
+	case runtime.GOOS == "darwin" && hasCommand("security"):
+		return exec.Command("security", "add-generic-password", "-U", "-s", keyringService, "-a", account, "-w", secret).Run()
+	case runtime.GOOS == "linux" && hasCommand("secret-tool"):
+		cmd := exec.Command("secret-tool", "store", "--label=codybot "+account, "service", keyringService, "account", account)
+		cmd.Stdin = bytes.NewBufferString(secret)
+		return cmd.Run()
+	}
+	path := credentialFile(account)
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(secret), 0o600)
+}
+
+func keyringDelete(account string) error {
+	switch {
+	case runtime.GOOS == "darwin" && hasCommand("security"):
+		return exec.Command("security", "delete-generic-password", "-s", keyringService, "-a", account).Run()
+	case runtime.GOOS == "linux" && hasCommand("secret-tool"):
+		return exec.Command("secret-tool", "clear", "service", keyringService, "account", account).Run()
+	}
+	err := os.Remove(credentialFile(account))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func credentialFile(account string) string {
+	safe := strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == ':' {
+			return '_'
+		}
+		return r
+	}, account)
+	return filepath.Join(codybotHome(), "credentials", safe)
+}
+
+func hasCommand(name string) bool {
+	_, err := exec.LookPath(name)
+	return err == nil
+}
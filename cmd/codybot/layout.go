@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/ansi"
+)
+
+const (
+	minTermWidth  = 30
+	minTermHeight = 8
+	// compactHeight is the height below which the header and status bar share
+	// one line and the input box shrinks to a single row.
+	compactHeight = 18
+)
+
+func (m model) tooSmall() bool {
+	return m.width > 0 && (m.width < minTermWidth || m.height < minTermHeight)
+}
+
+func (m model) compact() bool {
+	return m.height < compactHeight
+}
+
+func (m model) viewTooSmall() string {
+	msg := fmt.Sprintf("Window too small\n%dx%d, need %dx%d", m.width, m.height, minTermWidth, minTermHeight)
+	return lipgloss.Place(m.width, m.height, lipgloss.Center, lipgloss.Center, subtleStyle.Render(msg))
+}
+
+// applySize lays the chat out for a new terminal size. The transcript keeps
+// its scroll position across the re-wrap: a view following the bottom stays
+// there, and otherwise the same message stays at the top.
+func (m model) applySize(width, height int) model {
+	// Selection positions refer to the old wrapping.
+	m.closeSelection()
+	following := m.viewport.Height == 0 || m.viewport.AtBottom()
+	index, within := m.transcript.anchor(m.viewport.YOffset, m.viewport.Width)
+
+	m.width = width
+	m.height = height
+	contentWidth := max(width-4, 1)
+	m.input.SetWidth(contentWidth)
+	inputLines, chromeHeight := 3, 2
+	if m.compact() {
+		inputLines, chromeHeight = 1, 1
+	}
+	m.input.SetHeight(inputLines)
+	if m.state == stateSystem {
+		m.systemEditor.SetWidth(max(width-2, 10))
+		m.systemEditor.SetHeight(max(height-4, 3))
+	}
+	m.compare.resize(width, height)
+
+	chipsHeight := 0
+	if len(m.attachments) > 0 {
+		chipsHeight = 1
+	}
+	available := height - chromeHeight - (inputLines + 2) - chipsHeight - 2
+	m.viewport = newChatViewport(contentWidth, max(available, 1))
+	m.contentVersion++
+	m.setViewportContent(m.viewportContent())
+	if following {
+		m.viewport.GotoBottom()
+	} else {
+		m.viewport.SetYOffset(m.transcript.lineAt(index, within, contentWidth))
+	}
+	return m
+}
+
+// fitLine cuts a status or header line to the window so it never wraps and
+// pushes the layout down.
+func (m model) fitLine(line string) string {
+	if m.width <= 0 {
+		return line
+	}
+	return ansi.Truncate(line, m.width, "…")
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// leftover is a finished checkpoint from an earlier session whose files are
+// still uncommitted: agent edits nobody committed or reverted.
+type leftover struct {
+	checkpoint checkpoint
+	dirty      []string
+}
+
+// uncommittedFiles lists the files in the workspace that differ from HEAD
+// or are untracked, relative to the workspace like the journal's paths.
+func uncommittedFiles() (map[string]bool, error) {
+	changed, err := runGit("", "diff", "--name-only", "--relative", "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	untracked, err := runGit("", "ls-files", "--others", "--exclude-standard")
+	if err != nil {
+		return nil, err
+	}
+	files := map[string]bool{}
+	for _, name := range strings.Split(changed+"\n"+untracked, "\n") {
+		if name != "" {
+			files[filepath.FromSlash(name)] = true
+		}
+	}
+	return files, nil
+}
+
+// hasEarlierCheckpoints reports whether an earlier session left applied
+// checkpoints that have not been settled, so startup only asks git when
+// there could be leftovers.
+func (j *editJournal) hasEarlierCheckpoints() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, cp := range j.checkpoints[:j.applied] {
+		if cp.Session != j.session && !cp.Open && !cp.Kept {
+			return true
+		}
+	}
+	return false
+}
+
+// leftovers returns the earlier sessions' applied checkpoints that changed a
+// file still in uncommitted, oldest first. Checkpoints left open by a
+// cut-off turn are /recover's, and kept ones were already looked at.
+func (j *editJournal) leftovers(uncommitted map[string]bool) []leftover {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	var out []leftover
+	for _, cp := range j.checkpoints[:j.applied] {
+		if cp.Session == j.session || cp.Open || cp.Kept {
+			continue
+		}
+		var dirty []string
+		for _, path := range cp.files() {
+			if uncommitted[filepath.Clean(path)] {
+				dirty = append(dirty, path)
+			}
+		}
+		if len(dirty) > 0 {
+			out = append(out, leftover{checkpoint: *cp, dirty: dirty})
+		}
+	}
+	return out
+}
+
+// findLeftovers checks the journal against git. Outside a git repository
+// nothing counts as uncommitted, so there are no leftovers.
+func (j *editJournal) findLeftovers() []leftover {
+	if !j.hasEarlierCheckpoints() {
+		return nil
+	}
+	uncommitted, err := uncommittedFiles()
+	if err != nil {
+		return nil
+	}
+	return j.leftovers(uncommitted)
+}
+
+// keepLeftovers marks checkpoints as looked at, so startup stops raising
+// them.
+func (j *editJournal) keepLeftovers(ids map[int]bool) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, cp := range j.checkpoints {
+		if ids[cp.ID] {
+			cp.Kept = true
+		}
+	}
+	return j.save()
+}
+
+func leftoverFiles(leftovers []leftover) []string {
+	var files []string
+	seen := map[string]bool{}
+	for _, l := range leftovers {
+		for _, path := range l.dirty {
+			if !seen[path] {
+				seen[path] = true
+				files = append(files, path)
+			}
+		}
+	}
+	return files
+}
+
+// leftoverReport lists the leftover checkpoints with the request behind
+// each and the files it left uncommitted.
+func leftoverReport(leftovers []leftover) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "An earlier session left uncommitted edits to %d file(s):", len(leftoverFiles(leftovers)))
+	for _, l := range leftovers {
+		cp := l.checkpoint
+		prompt, _ := truncateRunes(strings.ReplaceAll(cp.Prompt, "\n", " "), 60)
+		fmt.Fprintf(&b, "\n  #%d %s  %q", cp.ID, cp.At.Local().Format("Jan 2 15:04"), prompt)
+		for _, path := range l.dirty {
+			added, removed := 0, 0
+			for _, v := range cp.Changes {
+				if v.Path == path {
+					a, r := lineDelta(v.Before, v.After)
+					added, removed = added+a, removed+r
+				}
+			}
+			fmt.Fprintf(&b, "\n      %s (+%d/-%d)", path, added, removed)
+		}
+	}
+	b.WriteString("\n/leftovers diff reviews them, /leftovers commit drafts a commit, /leftovers revert undoes them, /leftovers keep leaves them be.")
+	return b.String()
+}
+
+// leftoverDiff shows each file's change from before the first leftover edit
+// to what is on disk now, which includes any edits made by hand since.
+func leftoverDiff(leftovers []leftover) string {
+	var b strings.Builder
+	done := map[string]bool{}
+	for _, l := range leftovers {
+		for _, path := range l.dirty {
+			if done[path] {
+				continue
+			}
+			done[path] = true
+			first := l.checkpoint.Changes[firstVersionIndex(l.checkpoint.Changes, path)]
+			current, err := os.ReadFile(path)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				fmt.Fprintf(&b, "%s: %v\n", path, err)
+				continue
+			}
+			if err != nil {
+				fmt.Fprintf(&b, "%s was deleted since\n", path)
+				continue
+			}
+			b.WriteString(renderPatch(unifiedDiff(path, first.Before, current, first.Existed), ""))
+		}
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+func firstVersionIndex(changes []fileVersion, path string) int {
+	for i, v := range changes {
+		if v.Path == path {
+			return i
+		}
+	}
+	return -1
+}
+
+// revertLeftovers undoes the leftover checkpoints from the newest down.
+// It stops at a checkpoint that is not a leftover, or has a file that was
+// committed since: undoing those would change committed work too.
+func (j *editJournal) revertLeftovers(leftovers []leftover) (int, error) {
+	ids := map[int]bool{}
+	for _, l := range leftovers {
+		if len(l.dirty) == len(l.checkpoint.files()) {
+			ids[l.checkpoint.ID] = true
+		}
+	}
+	reverted := 0
+	for {
+		checkpoints, applied := j.snapshot()
+		if applied == 0 || !ids[checkpoints[applied-1].ID] {
+			return reverted, nil
+		}
+		if _, err := j.undo(); err != nil {
+			return reverted, err
+		}
+		reverted++
+	}
+}
+
+// cmdLeftovers settles the edits earlier sessions left uncommitted, which
+// startup reports: review them, commit them, revert them, or keep them
+// uncommitted without being asked again.
+func (m *model) cmdLeftovers(args string) tea.Cmd {
+	if m.streaming {
+		m.notice = "Wait for the current response to finish first"
+		return nil
+	}
+	leftovers := m.journal.findLeftovers()
+	if len(leftovers) == 0 {
+		m.notice = "No uncommitted edits from earlier sessions"
+		return nil
+	}
+	files := leftoverFiles(leftovers)
+	switch args {
+	case "", "show":
+		m.lastErr = nil
+		m.appendNote(leftoverReport(leftovers))
+	case "diff":
+		m.lastErr = nil
+		m.appendNote(leftoverDiff(leftovers))
+	case "commit":
+		if _, err := runGit("", append([]string{"add", "-A", "--"}, files...)...); err != nil {
+			m.lastErr = fmt.Errorf("leftovers: %w", err)
+			return nil
+		}
+		var prompts []string
+		for _, l := range leftovers {
+			prompt, _, _ := strings.Cut(strings.TrimSpace(l.checkpoint.Prompt), "\n")
+			prompt, _ = truncateRunes(prompt, 200)
+			prompts = append(prompts, fmt.Sprintf("%q", prompt))
+		}
+		return m.cmdCommit("an earlier codybot session made these changes for " + strings.Join(prompts, ", "))
+	case "revert":
+		reverted, err := m.journal.revertLeftovers(leftovers)
+		cmd := m.filesChanged(files)
+		if err != nil {
+			m.lastErr = fmt.Errorf("reverted %d checkpoint(s), then: %w", reverted, err)
+			return cmd
+		}
+		m.lastErr = nil
+		if left := len(leftovers) - reverted; left > 0 {
+			m.notice = fmt.Sprintf("Reverted %d checkpoint(s); %d sit under committed work • /timeline restores them one by one", reverted, left)
+			return cmd
+		}
+		m.notice = fmt.Sprintf("Reverted %d checkpoint(s) • /redo brings the latest back", reverted)
+		return cmd
+	case "keep":
+		ids := map[int]bool{}
+		for _, l := range leftovers {
+			ids[l.checkpoint.ID] = true
+		}
+		if err := m.journal.keepLeftovers(ids); err != nil {
+			m.lastErr = err
+			return nil
+		}
+		m.lastErr = nil
+		m.notice = fmt.Sprintf("Keeping %d uncommitted file(s); they will not be raised again", len(files))
+	default:
+		m.notice = "Usage: /leftovers [show|diff|commit|revert|keep]"
+	}
+	return nil
+}
@@ -0,0 +1,699 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf16"
+)
+
+const (
+	// lspTimeout bounds each request, including the first one, which waits
+	// for the server to load the project.
+	lspTimeout = 60 * time.Second
+	// lspDiagnosticsWait is how long diagnostics waits for the server to
+	// publish results for a file it was just sent.
+	lspDiagnosticsWait = 5 * time.Second
+	maxLSPLocations    = 50
+	maxLSPDiagnostics  = 100
+)
+
+// lspServers are the language servers --lsp auto looks for, by the marker
+// file that identifies the project's language. The first one whose marker
+// exists and whose binary is installed wins.
+var lspServers = []struct {
+	marker  string
+	command []string
+}{
+	{"go.mod", []string{"gopls"}},
+	{"Cargo.toml", []string{"rust-analyzer"}},
+	{"pyproject.toml", []string{"pyright-langserver", "--stdio"}},
+	{"setup.py", []string{"pyright-langserver", "--stdio"}},
+	{"requirements.txt", []string{"pyright-langserver", "--stdio"}},
+	{"tsconfig.json", []string{"typescript-language-server", "--stdio"}},
+	{"package.json", []string{"typescript-language-server", "--stdio"}},
+	{"compile_commands.json", []string{"clangd"}},
+	{"CMakeLists.txt", []string{"clangd"}},
+}
+
+var lspLanguages = map[string]string{
+	".go": "go", ".rs": "rust", ".py": "python",
+	".ts": "typescript", ".tsx": "typescriptreact", ".js": "javascript", ".jsx": "javascriptreact",
+	".c": "c", ".h": "c", ".cc": "cpp", ".cpp": "cpp", ".hpp": "cpp",
+}
+
+// lspToolNames are the tools the language server backs.
+var lspToolNames = map[string]bool{"go_to_definition": true, "find_references": true, "diagnostics": true}
+
+// lspCommand resolves --lsp to the command line of a language server, or nil
+// when the tools are off or auto finds no server for the project.
+func lspCommand(setting string) []string {
+	switch setting {
+	case "", "off":
+		return nil
+	case "auto":
+		for _, server := range lspServers {
+			if !fileExists(server.marker) {
+				continue
+			}
+			if _, err := exec.LookPath(server.command[0]); err == nil {
+				return server.command
+			}
+		}
+		return nil
+	}
+	return strings.Fields(setting)
+}
+
+// lspClient speaks JSON-RPC to a language server over its stdio. The server
+// starts on the first tool call, so sessions that never ask pay nothing, and
+// starts again on the next call if it exits.
+type lspClient struct {
+	command []string
+	// startMu serializes starting the server, and docMu sending it
+	// documents. Neither is held by the goroutine reading the server, so a
+	// server blocked on its output cannot deadlock a write.
+	startMu sync.Mutex
+	docMu   sync.Mutex
+
+	mu      sync.Mutex
+	root    string
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	ready   bool
+	nextID  int
+	pending map[int]chan lspResponse
+	// docs holds the text last sent for each open document, by URI.
+	docs map[string]*lspDocument
+	// diagnostics are the latest published for each URI; published is
+	// closed and replaced whenever a new set arrives.
+	diagnostics map[string][]lspDiagnostic
+	published   chan struct{}
+
+	writeMu sync.Mutex
+}
+
+type lspDocument struct {
+	version int
+	text    string
+}
+
+type lspResponse struct {
+	result json.RawMessage
+	err    error
+}
+
+type lspMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  any             `json:"params,omitempty"`
+}
+
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspLocation struct {
+	URI   string   `json:"uri"`
+	Range lspRange `json:"range"`
+	// LocationLink fields, which some servers answer definition with.
+	TargetURI            string   `json:"targetUri"`
+	TargetSelectionRange lspRange `json:"targetSelectionRange"`
+}
+
+type lspDiagnostic struct {
+	Range    lspRange `json:"range"`
+	Severity int      `json:"severity"`
+	Source   string   `json:"source"`
+	Message  string   `json:"message"`
+}
+
+func newLSPClient(command []string) *lspClient {
+	return &lspClient{command: command}
+}
+
+// ensure launches the server and initializes it, unless it is running.
+func (c *lspClient) ensure(ctx context.Context) error {
+	c.startMu.Lock()
+	defer c.startMu.Unlock()
+	c.mu.Lock()
+	ready := c.ready
+	c.mu.Unlock()
+	if ready {
+		return nil
+	}
+	root, err := workspaceRoot()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(c.command[0], c.command[1:]...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("start language server: %w", err)
+	}
+	c.mu.Lock()
+	c.root, c.cmd, c.stdin = root, cmd, stdin
+	c.pending = map[int]chan lspResponse{}
+	c.docs = map[string]*lspDocument{}
+	c.diagnostics = map[string][]lspDiagnostic{}
+	c.published = make(chan struct{})
+	c.ready = true
+	c.mu.Unlock()
+	go c.read(cmd, bufio.NewReader(stdout))
+
+	rootURI := fileURI(root)
+	params := map[string]any{
+		"processId": os.Getpid(),
+		"rootUri":   rootURI,
+		"workspaceFolders": []map[string]string{
+			{"uri": rootURI, "name": filepath.Base(root)},
+		},
+		"capabilities": map[string]any{
+			"textDocument": map[string]any{
+				"definition":         map[string]any{"linkSupport": true},
+				"references":         map[string]any{},
+				"publishDiagnostics": map[string]any{},
+				"synchronization":    map[string]any{},
+			},
+			"workspace": map[string]any{"configuration": true, "workspaceFolders": true},
+		},
+	}
+	_, err = c.call(ctx, "initialize", params)
+	if err == nil {
+		err = c.notify("initialized", map[string]any{})
+	}
+	if err != nil {
+		c.mu.Lock()
+		c.stop()
+		c.mu.Unlock()
+		return fmt.Errorf("initialize %s: %w", c.command[0], err)
+	}
+	return nil
+}
+
+// read dispatches the server's messages until it exits, then fails whatever
+// is still waiting so the next call starts a fresh server.
+func (c *lspClient) read(cmd *exec.Cmd, r *bufio.Reader) {
+	for {
+		body, err := readLSPMessage(r)
+		if err != nil {
+			break
+		}
+		var msg struct {
+			ID     json.RawMessage `json:"id"`
+			Method string          `json:"method"`
+			Params json.RawMessage `json:"params"`
+			Result json.RawMessage `json:"result"`
+			Error  *struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if json.Unmarshal(body, &msg) != nil {
+			continue
+		}
+		switch {
+		case msg.Method != "" && msg.ID != nil:
+			c.answer(msg.ID, msg.Method, msg.Params)
+		case msg.Method == "textDocument/publishDiagnostics":
+			var params struct {
+				URI         string          `json:"uri"`
+				Diagnostics []lspDiagnostic `json:"diagnostics"`
+			}
+			if json.Unmarshal(msg.Params, &params) == nil {
+				c.mu.Lock()
+				if c.cmd == cmd {
+					c.diagnostics[params.URI] = params.Diagnostics
+					close(c.published)
+					c.published = make(chan struct{})
+				}
+				c.mu.Unlock()
+			}
+		case msg.Method == "":
+			id, err := strconv.Atoi(string(msg.ID))
+			if err != nil {
+				continue
+			}
+			resp := lspResponse{result: msg.Result}
+			if msg.Error != nil {
+				resp.err = errors.New(msg.Error.Message)
+			}
+			c.mu.Lock()
+			ch := c.pending[id]
+			delete(c.pending, id)
+			c.mu.Unlock()
+			if ch != nil {
+				ch <- resp
+			}
+		}
+	}
+	cmd.Wait()
+	c.mu.Lock()
+	if c.cmd == cmd {
+		c.stop()
+	}
+	c.mu.Unlock()
+}
+
+// answer replies to the requests servers send their clients. Settings come
+// back empty so the server uses its defaults; everything else is accepted.
+func (c *lspClient) answer(id json.RawMessage, method string, params json.RawMessage) {
+	var result any
+	if method == "workspace/configuration" {
+		var p struct {
+			Items []json.RawMessage `json:"items"`
+		}
+		json.Unmarshal(params, &p)
+		result = make([]any, len(p.Items))
+	}
+	c.write(map[string]any{"jsonrpc": "2.0", "id": id, "result": result})
+}
+
+// stop drops the running server, failing its pending calls. c.mu must be
+// held.
+func (c *lspClient) stop() {
+	if !c.ready {
+		return
+	}
+	c.ready = false
+	c.stdin.Close()
+	c.cmd.Process.Kill()
+	for id, ch := range c.pending {
+		ch <- lspResponse{err: fmt.Errorf("language server %s exited", c.command[0])}
+		delete(c.pending, id)
+	}
+	c.cmd = nil
+}
+
+// close shuts the server down politely, then kills it if it lingers.
+func (c *lspClient) close() {
+	c.mu.Lock()
+	ready := c.ready
+	c.mu.Unlock()
+	if !ready {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	c.call(ctx, "shutdown", nil)
+	c.notify("exit", nil)
+	c.mu.Lock()
+	c.stop()
+	c.mu.Unlock()
+}
+
+func (c *lspClient) call(ctx context.Context, method string, params any) (json.RawMessage, error) {
+	c.mu.Lock()
+	if !c.ready {
+		c.mu.Unlock()
+		return nil, fmt.Errorf("language server %s is not running", c.command[0])
+	}
+	c.nextID++
+	id := c.nextID
+	ch := make(chan lspResponse, 1)
+	c.pending[id] = ch
+	c.mu.Unlock()
+	if err := c.write(lspMessage{JSONRPC: "2.0", ID: json.RawMessage(strconv.Itoa(id)), Method: method, Params: params}); err != nil {
+		return nil, err
+	}
+	select {
+	case resp := <-ch:
+		return resp.result, resp.err
+	case <-ctx.Done():
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+		return nil, fmt.Errorf("%s: %w", method, ctx.Err())
+	}
+}
+
+func (c *lspClient) notify(method string, params any) error {
+	return c.write(lspMessage{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+func (c *lspClient) write(msg any) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	stdin := c.stdin
+	c.mu.Unlock()
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_, err = fmt.Fprintf(stdin, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	return err
+}
+
+func readLSPMessage(r *bufio.Reader) ([]byte, error) {
+	length := -1
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		if name, value, ok := strings.Cut(line, ":"); ok && strings.EqualFold(name, "Content-Length") {
+			length, _ = strconv.Atoi(strings.TrimSpace(value))
+		}
+	}
+	if length < 0 {
+		return nil, errors.New("language server message without Content-Length")
+	}
+	body := make([]byte, length)
+	_, err := io.ReadFull(r, body)
+	return body, err
+}
+
+// sync starts the server if needed and sends it the file's current text, so
+// answers reflect the agent's latest edits. It returns the file's URI and
+// lines, and a channel closed on the next diagnostics published after the
+// text was sent; changed is false when the server already had this text.
+func (c *lspClient) sync(ctx context.Context, path string) (uri string, lines []string, published chan struct{}, changed bool, err error) {
+	rel, err := resolveWorkspacePath(path)
+	if err != nil {
+		return "", nil, nil, false, err
+	}
+	data, err := os.ReadFile(rel)
+	if err != nil {
+		return "", nil, nil, false, err
+	}
+	text := string(data)
+	if err := c.ensure(ctx); err != nil {
+		return "", nil, nil, false, err
+	}
+	c.docMu.Lock()
+	defer c.docMu.Unlock()
+	var method string
+	var params map[string]any
+	c.mu.Lock()
+	uri = fileURI(filepath.Join(c.root, rel))
+	published = c.published
+	doc := c.docs[uri]
+	switch {
+	case doc == nil:
+		c.docs[uri] = &lspDocument{version: 1, text: text}
+		language := lspLanguages[filepath.Ext(rel)]
+		if language == "" {
+			language = "plaintext"
+		}
+		method, params = "textDocument/didOpen", map[string]any{
+			"textDocument": map[string]any{"uri": uri, "languageId": language, "version": 1, "text": text},
+		}
+	case doc.text != text:
+		doc.version++
+		doc.text = text
+		method, params = "textDocument/didChange", map[string]any{
+			"textDocument":   map[string]any{"uri": uri, "version": doc.version},
+			"contentChanges": []map[string]string{{"text": text}},
+		}
+	}
+	c.mu.Unlock()
+	if method != "" {
+		if err := c.notify(method, params); err != nil {
+			return "", nil, nil, false, err
+		}
+	}
+	return uri, strings.Split(text, "\n"), published, method != "", nil
+}
+
+// lspSymbolPosition finds symbol on a 1-based line and returns its LSP position,
+// whose character offset counts UTF-16 code units.
+func lspSymbolPosition(lines []string, path string, line int, symbol string) (lspPosition, error) {
+	if line < 1 || line > len(lines) {
+		return lspPosition{}, fmt.Errorf("%s has %d lines, no line %d", path, len(lines), line)
+	}
+	text := lines[line-1]
+	col := strings.Index(text, symbol)
+	if symbol == "" || col < 0 {
+		return lspPosition{}, fmt.Errorf("symbol %q is not on line %d of %s, which reads: %s", symbol, line, path, strings.TrimSpace(text))
+	}
+	return lspPosition{Line: line - 1, Character: len(utf16.Encode([]rune(text[:col])))}, nil
+}
+
+type lspSymbolArgs struct {
+	Path   string `json:"path"`
+	Line   int    `json:"line"`
+	Symbol string `json:"symbol"`
+}
+
+func (c *lspClient) symbolRequest(ctx context.Context, raw json.RawMessage, method string, extra map[string]any) ([]lspLocation, error) {
+	var args lspSymbolArgs
+	if err := decodeArgs(raw, &args); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, lspTimeout)
+	defer cancel()
+	uri, lines, _, _, err := c.sync(ctx, args.Path)
+	if err != nil {
+		return nil, err
+	}
+	pos, err := lspSymbolPosition(lines, args.Path, args.Line, args.Symbol)
+	if err != nil {
+		return nil, err
+	}
+	params := map[string]any{"textDocument": map[string]string{"uri": uri}, "position": pos}
+	for key, value := range extra {
+		params[key] = value
+	}
+	result, err := c.call(ctx, method, params)
+	if err != nil {
+		return nil, err
+	}
+	var locations []lspLocation
+	if len(result) > 0 && result[0] == '[' {
+		err = json.Unmarshal(result, &locations)
+	} else if string(result) != "null" && len(result) > 0 {
+		var one lspLocation
+		err = json.Unmarshal(result, &one)
+		locations = []lspLocation{one}
+	}
+	return locations, err
+}
+
+func (c *lspClient) toolDefinition(ctx context.Context, _ *toolEnv, raw json.RawMessage) (string, error) {
+	locations, err := c.symbolRequest(ctx, raw, "textDocument/definition", nil)
+	if err != nil {
+		return "", err
+	}
+	if len(locations) == 0 {
+		return "No definition found.", nil
+	}
+	return c.formatLocations(locations), nil
+}
+
+func (c *lspClient) toolReferences(ctx context.Context, _ *toolEnv, raw json.RawMessage) (string, error) {
+	locations, err := c.symbolRequest(ctx, raw, "textDocument/references", map[string]any{
+		"context": map[string]bool{"includeDeclaration": true},
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(locations) == 0 {
+		return "No references found.", nil
+	}
+	return fmt.Sprintf("%d references:\n%s", len(locations), c.formatLocations(locations)), nil
+}
+
+func (c *lspClient) toolDiagnostics(ctx context.Context, _ *toolEnv, raw json.RawMessage) (string, error) {
+	var args struct {
+		Path string `json:"path"`
+	}
+	if err := decodeArgs(raw, &args); err != nil {
+		return "", err
+	}
+	if args.Path == "" {
+		return c.formatDiagnostics(""), nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, lspTimeout)
+	defer cancel()
+	uri, _, published, changed, err := c.sync(ctx, args.Path)
+	if err != nil {
+		return "", err
+	}
+	c.mu.Lock()
+	_, known := c.diagnostics[uri]
+	c.mu.Unlock()
+	if changed || !known {
+		// Servers publish asynchronously after analyzing the new text, and
+		// silently when nothing changed, so wait a bounded time for it.
+		timer := time.NewTimer(lspDiagnosticsWait)
+		defer timer.Stop()
+	wait:
+		for {
+			select {
+			case <-published:
+				c.mu.Lock()
+				_, known = c.diagnostics[uri]
+				published = c.published
+				c.mu.Unlock()
+				if known {
+					break wait
+				}
+			case <-timer.C:
+				break wait
+			case <-ctx.Done():
+				return "", ctx.Err()
+			}
+		}
+	}
+	return c.formatDiagnostics(uri), nil
+}
+
+// formatDiagnostics lists the diagnostics for uri, or for every file the
+// server reported on when uri is empty, errors first.
+func (c *lspClient) formatDiagnostics(uri string) string {
+	c.mu.Lock()
+	var all []lspDiagnostic
+	var paths []string
+	for u, diags := range c.diagnostics {
+		if uri != "" && u != uri {
+			continue
+		}
+		for _, d := range diags {
+			all = append(all, d)
+			paths = append(paths, c.displayPath(u))
+		}
+	}
+	c.mu.Unlock()
+	if len(all) == 0 {
+		if uri == "" {
+			return "No diagnostics reported. Pass a path to check a file."
+		}
+		return "No diagnostics."
+	}
+	order := make([]int, len(all))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		da, db := all[order[a]], all[order[b]]
+		if lspSeverity(da.Severity) != lspSeverity(db.Severity) {
+			return da.Severity != 0 && (db.Severity == 0 || da.Severity < db.Severity)
+		}
+		if paths[order[a]] != paths[order[b]] {
+			return paths[order[a]] < paths[order[b]]
+		}
+		return da.Range.Start.Line < db.Range.Start.Line
+	})
+	var b strings.Builder
+	for i, idx := range order {
+		if i == maxLSPDiagnostics {
+			fmt.Fprintf(&b, "... and %d more\n", len(order)-i)
+			break
+		}
+		d := all[idx]
+		source := ""
+		if d.Source != "" {
+			source = " (" + d.Source + ")"
+		}
+		fmt.Fprintf(&b, "%s:%d:%d: %s: %s%s\n", paths[idx], d.Range.Start.Line+1, d.Range.Start.Character+1, lspSeverity(d.Severity), strings.TrimSpace(d.Message), source)
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+func lspSeverity(severity int) string {
+	switch severity {
+	case 1:
+		return "error"
+	case 2:
+		return "warning"
+	case 3:
+		return "info"
+	case 4:
+		return "hint"
+	}
+	return "error"
+}
+
+// formatLocations lists locations as path:line:col with the line's text, so
+// the model rarely needs a read_file to see what it found.
+func (c *lspClient) formatLocations(locations []lspLocation) string {
+	var b strings.Builder
+	files := map[string][]string{}
+	for i, loc := range locations {
+		if i == maxLSPLocations {
+			fmt.Fprintf(&b, "... and %d more\n", len(locations)-i)
+			break
+		}
+		uri, rng := loc.URI, loc.Range
+		if loc.TargetURI != "" {
+			uri, rng = loc.TargetURI, loc.TargetSelectionRange
+		}
+		path := uriPath(uri)
+		lines, ok := files[path]
+		if !ok {
+			if data, err := os.ReadFile(path); err == nil {
+				lines = strings.Split(string(data), "\n")
+			}
+			files[path] = lines
+		}
+		text := ""
+		if rng.Start.Line < len(lines) {
+			text, _ = truncateRunes(strings.TrimSpace(lines[rng.Start.Line]), 200)
+		}
+		fmt.Fprintf(&b, "%s:%d:%d: %s\n", c.displayPath(uri), rng.Start.Line+1, rng.Start.Character+1, text)
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// displayPath is a URI's path relative to the workspace, or absolute for
+// files outside it such as the standard library.
+func (c *lspClient) displayPath(uri string) string {
+	path := uriPath(uri)
+	if c.root != "" && within(c.root, path) {
+		if rel, err := filepath.Rel(c.root, path); err == nil {
+			return filepath.ToSlash(rel)
+		}
+	}
+	return path
+}
+
+func fileURI(path string) string {
+	path = filepath.ToSlash(path)
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return (&url.URL{Scheme: "file", Path: path}).String()
+}
+
+func uriPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	path := u.Path
+	if runtime.GOOS == "windows" {
+		path = strings.TrimPrefix(path, "/")
+	}
+	return filepath.FromSlash(path)
+}
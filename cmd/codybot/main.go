@@ -5,14 +5,17 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/charmbracelet/bubbles/spinner"
 	"github.com/charmbracelet/bubbles/textarea"
@@ -24,13 +27,21 @@ import (
 const (
 	defaultBaseURL = "http://localhost:11434/v1"
 	defaultModel   = "qwen3-coder"
+
+	defaultTemperature = 0.2
 )
 
 type appState int
 
 const (
 	stateSetup appState = iota
+	stateTrust
 	stateChat
+	stateTimeline
+	stateDashboard
+	statePreview
+	stateSystem
+	stateCompare
 )
 
 type config struct {
@@ -38,19 +49,160 @@ type config struct {
 	Model     string
 	APIKey    string
 	AgentPath string
+	NoTools   bool
+	// Safe starts without tools, persistence, or settings from the
+	// environment; see safeMode.
+	Safe bool
+
+	APIKeyCommand string
+
+	NoPromptCheck bool
+	NoTips        bool
+	NoMouse       bool
+	NoRedact      bool
+	// CacheTTL is how long runAgentLoop reuses the answer to an identical
+	// request; zero, or NoCache, turns the response cache off.
+	CacheTTL time.Duration
+	NoCache  bool
+	// HistoryPolicy names the historyPolicies entry that cuts the history
+	// once it outgrows the context window.
+	HistoryPolicy string
+	Output        string
+
+	// RecordCommand records push-to-talk audio to {file}; the recording is
+	// sent to TranscribeURL, the endpoint's own by default.
+	RecordCommand    string
+	TranscribeURL    string
+	TranscribeModel  string
+	TranscribeAPIKey string
+	// Resume is the ID of a saved session to continue.
+	Resume string
+
+	Workspace string
+	Trust     bool
+
+	TestCommand string
+	// Sandbox runs the shell and test tools in a container: none, docker,
+	// or podman.
+	Sandbox        string
+	SandboxImage   string
+	SandboxNetwork bool
+	// CommandPolicy decides whether session tool commands need approval:
+	// auto, ask, or strict.
+	CommandPolicy string
+	// LSP is off, auto, or the command line of a language server.
+	LSP          string
+	TestAttempts int
+	CheckModel   string
+	// CompareModel is the model /compare runs against --model.
+	CompareModel string
+	// EmbeddingModel embeds the semantic index and the queries against it;
+	// empty means the provider's usual one.
+	EmbeddingModel string
+
+	Temperature float64
+	Seed        *int
+	Profile     string
+
+	// ReplyLanguage is the natural language of explanations and answers;
+	// CommentLanguage that of code comments and identifiers. Either may
+	// carry a style, e.g. "English, imperative mood".
+	ReplyLanguage   string
+	CommentLanguage string
+
+	ContextWindow int
+
+	Provider  string
+	KeepAlive string
+	NumCtx    int
+	// Headers and QueryParams are sent with every request to the endpoint,
+	// as "Name: value" and "name=value".
+	Headers     []string
+	QueryParams []string
+
+	FallbackBaseURL  string
+	FallbackModel    string
+	FallbackAPIKey   string
+	FallbackProvider string
+	WarmStandby      bool
+	// FallbackHeaders and FallbackQueryParams replace Headers and
+	// QueryParams on a fallback endpoint of its own.
+	FallbackHeaders     []string
+	FallbackQueryParams []string
+
+	Proxy              string
+	CABundle           string
+	InsecureSkipVerify bool
+
+	// DialTimeout and TLSTimeout bound connecting to an endpoint, and
+	// IdleTimeout is how long an idle connection is kept for the next
+	// request; 0 closes it.
+	DialTimeout time.Duration
+	TLSTimeout  time.Duration
+	IdleTimeout time.Duration
+
+	Offline  bool
+	ReadOnly bool
+	// Web is the address of the read-only live view, if any.
+	Web           string
+	ControlSocket string
+
+	Theme string
+
+	Multiplexer   string
+	PaneDirection string
+	PaneViewer    string
+
+	OAuthDeviceURL string
+	OAuthTokenURL  string
+	OAuthClientID  string
+	OAuthScope     string
+
+	InputPrice  float64
+	OutputPrice float64
+
+	Images string
+
+	OpenRouterModels      string
+	OpenRouterOrder       string
+	OpenRouterIgnore      string
+	OpenRouterSort        string
+	OpenRouterNoFallbacks bool
+	OpenRouterDenyData    bool
+	OpenRouterReferer     string
+	OpenRouterTitle       string
+
+	tokens *tokenSource
+	// apiKeyEnv names the variable a rejected key is reloaded from when it
+	// is not the primary endpoint's.
+	apiKeyEnv string
+	// schema asks for an answer matching this JSON Schema, set for /json turns.
+	schema json.RawMessage
 }
 
 type message struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role       string     `json:"role"`
+	Content    string     `json:"content"`
+	ToolCalls  []toolCall `json:"tool_calls,omitempty"`
+	ToolCallID string     `json:"tool_call_id,omitempty"`
+	Name       string     `json:"name,omitempty"`
+	// Meta is kept in sessions and stripped by apiMessages before sending.
+	Meta *messageMeta `json:"meta,omitempty"`
 }
 
 type chatCompletionRequest struct {
 	Model       string    `json:"model"`
 	Messages    []message `json:"messages"`
 	Stream      bool      `json:"stream"`
-	Temperature float64   `json:"temperature,omitempty"`
+	Temperature *float64  `json:"temperature,omitempty"`
+	Seed        *int      `json:"seed,omitempty"`
 	Tools       []Tool    `json:"tools,omitempty"`
+
+	ResponseFormat *responseFormat `json:"response_format,omitempty"`
+
+	// OpenRouter extensions.
+	Models   []string            `json:"models,omitempty"`
+	Provider *openRouterProvider `json:"provider,omitempty"`
 }
 
 type Tool struct {
@@ -76,10 +228,26 @@ type FunctionProperty struct {
 }
 
 type streamResponse struct {
+	Model string `json:"model"`
+	Error *struct {
+		Message string `json:"message"`
+		Code    any    `json:"code"`
+	} `json:"error"`
 	Choices []struct {
 		Delta struct {
-			Content string `json:"content"`
-			Role    string `json:"role"`
+			Content   string `json:"content"`
+			Role      string `json:"role"`
+			ToolCalls []struct {
+				Index    int              `json:"index"`
+				ID       string           `json:"id"`
+				Type     string           `json:"type"`
+				Function toolCallFunction `json:"function"`
+			} `json:"tool_calls"`
+			// Reasoning models stream their thinking in one of these
+			// before the answer: OpenRouter uses reasoning, DeepSeek and
+			// vLLM reasoning_content.
+			Reasoning        string `json:"reasoning"`
+			ReasoningContent string `json:"reasoning_content"`
 		} `json:"delta"`
 		FinishReason string `json:"finish_reason"`
 	} `json:"choices"`
@@ -87,78 +255,538 @@ type streamResponse struct {
 
 type streamMsg struct {
 	token string
-	done  bool
-	err   error
+	// chunks is how many provider deltas token holds once batched.
+	chunks    int
+	toolCalls []toolCall
+	info      string
+	// trimmed is how many messages the history policy left out of the
+	// request, reported with the notice saying so.
+	trimmed int
+	done    bool
+	// model is the model that produced the answer, reported with done.
+	model string
+	// hint is a progress line from the reasoning a model streams before it
+	// answers.
+	hint string
+	err  error
+}
+
+type apiError struct {
+	StatusCode int
+	Status     string
+	Body       string
+	RetryAfter time.Duration
+}
+
+func (e *apiError) Error() string {
+	if e.StatusCode == http.StatusTooManyRequests {
+		hint := "rate limited"
+		if e.RetryAfter > 0 {
+			hint += "; retry after " + formatSeconds(e.RetryAfter)
+		}
+		return fmt.Sprintf("API error: %s (%s) - %s", e.Status, hint, e.Body)
+	}
+	return fmt.Sprintf("API error: %s - %s", e.Status, e.Body)
 }
 
 type model struct {
-	state appState
+	state     appState
+	workspace string
 
 	cfg          config
+	baseCfg      config
+	profile      *profile
 	system       message
 	history      []message
 	agentContent string
+	// agentFiles are the instruction files merged into agentContent.
+	agentFiles []agentFile
 
-	viewport   viewport.Model
+	viewport viewport.Model
+	// scrollLock holds the transcript where the user scrolled up to, instead
+	// of following new output.
+	scrollLock bool
 	input      textarea.Model
 	spinner    spinner.Model
-	transcript string
+	transcript *transcript
+	images     string
 
 	streaming            bool
-	streamCh             chan streamMsg
+	spinning             bool
+	streamCh             <-chan streamMsg
+	compacting           bool
+	endpointDown         bool
 	currentResponse      *strings.Builder
 	currentResponseMutex *sync.Mutex
 	lastErr              error
+	notice               string
+	stats                streamStats
+
+	attachments []attachment
+	tee         *teeFile
+	find        findState
+	selection   selectState
+	palette     paletteState
+	// systemEditor is the /system overlay, and systemOverride the prompt it
+	// saved for this session, which replaces the assembled one.
+	systemEditor   textarea.Model
+	systemOverride string
+	// voice is the push-to-talk recording in progress, if any.
+	voice        *voiceRecording
+	transcribing bool
+	// trimmed is how many messages the history policy left out of the
+	// latest request.
+	trimmed  int
+	mention  mentionState
+	mentions *mentionIndex
+	tips     *tipState
+	editor   editorContext
+	// pendingHookContext is post-response hook output for the next prompt.
+	pendingHookContext string
+	// hooksNoted is the hash of the unapproved project hooks file last
+	// shown to the user, which /hooks approve approves.
+	hooksNoted string
+	usage      turnUsage
+	dashboard  *dashboard
+	// compare is the /compare run on screen, if any.
+	compare *comparison
+
+	tools *toolRegistry
+	// approval holds a round of tool calls whose session tool commands
+	// wait for the user under --command-policy ask or strict.
+	approval       *commandApproval
+	journal        *editJournal
+	tests          *testLoop
+	turn           int
+	toolRounds     int
+	timelineCursor int
+	timelineDiff   bool
+	turnPrompt     string
+	promptFlagged  string
+	editing        editState
+	commit         commitFlow
+	lastFailure    *toolFailure
+	pins           *pinSet
+	toolStats      *toolStats
+	hint           string
+	jsonTurn       *jsonRequest
+	// cancelStream stops the running request; cancelled is set once the user
+	// stops the turn.
+	cancelStream context.CancelFunc
+	cancelled    bool
+	lastJSON     string
+	citations    citations
+
+	preview *stagedEdits
+	// answerEdits are the file changes in the last answer, offered for
+	// review with Ctrl+Y.
+	answerEdits *stagedEdits
+	// web is the --web live view.
+	web           *webMirror
+	previewCursor int
+	previewScroll int
+	// promptQueue holds the remaining turns of a multi-turn saved prompt.
+	promptQueue []string
+	// steering holds guidance typed while a turn runs; steerNow is set when
+	// Alt+Enter stopped the turn to send it (see steering.go).
+	steering []string
+	steerNow bool
+
+	control        *controlServer
+	controlMessage string
+
+	sessionID      string
+	sessionCreated time.Time
+	sessionTitle   string
+	forkedFrom     string
+	git            gitState
+	// titleSet is true once the user names the session with /title.
+	titleSet bool
+
+	pullCh  chan pullMsg
+	standby *providerHealth
 
 	width  int
 	height int
+	// tabBar lists the TUI's tabs in the header when there is more than one.
+	tabBar string
+
+	render         *renderCache
+	contentVersion int
+}
+
+type subcommand struct {
+	name string
+	help string
+	// args are the words the subcommand takes first, like its own
+	// subcommands; shell completion offers them.
+	args []string
+	run  func(args []string, stdout, stderr io.Writer) int
+}
+
+// subcommands are dispatched on the first argument; without one codybot
+// starts the TUI.
+func subcommands() []subcommand {
+	return []subcommand{
+		{name: "chat", help: "Start the interactive UI (the default)", run: runChatCommand},
+		{name: "sessions", help: "List, show, and migrate saved sessions", args: []string{"list", "show", "migrate"}, run: runSessionsCommand},
+		{name: "import", help: "Convert ChatGPT, Claude, or aider conversations into sessions", run: runImportCommand},
+		{name: "migrate", help: "Upgrade a dependency file by file", run: runMigrateCommand},
+		{name: "deprecations", help: "Migrate code off deprecated APIs", run: runDeprecationsCommand},
+		{name: "auth", help: "Log in, log out, or store API keys", args: []string{"login", "logout", "status", "set-key"}, run: runAuthCommand},
+		{name: "index", help: "Build or update the semantic index behind the semantic_search tool", run: runIndexCommand},
+		{name: "tools", help: "Document the agent's tools and their policy status", args: []string{"list"}, run: runToolsCommand},
+		{name: "run", help: "Run a task file headlessly", run: runTaskCommand},
+		{name: "serve", help: "Serve the agent over HTTP", run: runServeCommand},
+		{name: "completion", help: "Print a shell completion script", args: completionShells, run: runCompletionCommand},
+		{name: "stats", help: "Print weekly usage and per-model costs from the usage database", run: runStatsCommand},
+		{name: "config", help: "Show the resolved settings and where each comes from", run: runConfigCommand},
+		{name: "doctor", help: "Check the endpoint, key, models, agents.md, and tool prerequisites", run: runDoctorCommand},
+		{name: "help", help: "Show help for codybot or a subcommand", run: runHelpCommand},
+		{name: completeCommand, run: runCompleteCommand},
+		{name: renderFrameCommand, run: runRenderFrameCommand},
+	}
 }
 
 func main() {
-	cfg := parseConfig()
+	args := os.Args[1:]
+	if len(args) > 0 {
+		for _, sub := range subcommands() {
+			if sub.name == args[0] {
+				os.Exit(sub.run(args[1:], os.Stdout, os.Stderr))
+			}
+		}
+	}
+	os.Exit(runChatCommand(args, os.Stdout, os.Stderr))
+}
 
-	agentExists := fileExists(cfg.AgentPath)
-	agentContent := ""
-	if agentExists {
-		data, err := os.ReadFile(cfg.AgentPath)
-		if err == nil {
-			agentContent = string(data)
+// runChatCommand starts the TUI. It is also what codybot runs when the first
+// argument is not a subcommand, so "codybot --model x" and "codybot chat
+// --model x" are the same.
+func runChatCommand(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("codybot", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var cfg config
+	if safeRequested(args) {
+		// Register with the environment hidden, so only defaults and the
+		// flags on the command line apply.
+		env := getenv
+		getenv = func(string) string { return "" }
+		registerTUIFlags(fs, &cfg)
+		getenv = env
+	} else {
+		registerTUIFlags(fs, &cfg)
+	}
+	fs.Usage = func() { writeUsage(stderr, fs) }
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(stderr, "codybot: unknown command %q; run codybot help for the list\n", fs.Arg(0))
+		return 2
+	}
+	cfg = cfg.normalized()
+	if cfg.Safe {
+		cfg = cfg.safeMode()
+	}
+	if err := checkHistoryPolicy(cfg.HistoryPolicy); err != nil {
+		fmt.Fprintf(stderr, "codybot: %v\n", err)
+		return 2
+	}
+	if err := checkSandbox(cfg.Sandbox); err != nil {
+		fmt.Fprintf(stderr, "codybot: %v\n", err)
+		return 2
+	}
+	if err := checkRequestExtras(cfg); err != nil {
+		fmt.Fprintf(stderr, "codybot: %v\n", err)
+		return 2
+	}
+	if err := checkCommandPolicy(cfg.CommandPolicy); err != nil {
+		fmt.Fprintf(stderr, "codybot: %v\n", err)
+		return 2
+	}
+	if err := cfg.attachTokenSource(); err != nil {
+		fmt.Fprintf(stderr, "codybot: %v\n", err)
+		return 1
+	}
+	root, err := enterWorkspace(cfg)
+	if err != nil {
+		fmt.Fprintf(stderr, "codybot: %v\n", err)
+		return 1
+	}
+	// Safe mode neither reads nor writes the trust store: without tools
+	// there is nothing to trust the workspace with.
+	trusted := cfg.Safe || isTrusted(root)
+	if !trusted && cfg.Trust {
+		if err := trustDir(root); err != nil {
+			fmt.Fprintf(stderr, "codybot: %v\n", err)
+			return 1
 		}
+		trusted = true
+	}
+
+	t, err := loadTheme(cfg.Theme)
+	if err != nil {
+		fmt.Fprintf(stderr, "codybot: --theme: %v\n", err)
+		return 1
+	}
+	applyTheme(t)
+
+	cfg.AgentPath = resolveAgentPath(cfg.AgentPath)
+	var agentFiles []agentFile
+	if !cfg.Safe {
+		agentFiles = loadAgentFiles(cfg.AgentPath)
 	}
+	agentExists := cfg.Safe || hasProjectAgents(agentFiles)
+	agentContent := mergeAgentFiles(agentFiles, cfg.AgentPath)
 
 	initialState := stateChat
 	if !agentExists {
 		initialState = stateSetup
 	}
+	if !trusted {
+		initialState = stateTrust
+	}
 
-	program := tea.NewProgram(
-		newModel(cfg, agentContent, initialState),
-		tea.WithAltScreen(),
-	)
-	if _, err := program.Run(); err != nil {
-		fmt.Fprintf(os.Stderr, "codybot error: %v\n", err)
-		os.Exit(1)
+	m := newModel(cfg, agentContent, initialState)
+	m.workspace = root
+	m.agentFiles = agentFiles
+	if cfg.Output != "" {
+		tee, err := openTee(cfg.Output, false)
+		if err != nil {
+			fmt.Fprintf(stderr, "codybot: --output: %v\n", err)
+			return 1
+		}
+		m.tee = tee
+	}
+	if cfg.Profile != "" {
+		p, err := loadProfile(cfg.AgentPath, cfg.Profile)
+		if err != nil {
+			fmt.Fprintf(stderr, "codybot: %v\n", err)
+			return 1
+		}
+		m.applyProfile(p)
 	}
+	if cfg.Resume != "" {
+		if err := m.resumeSession(cfg.Resume); err != nil {
+			fmt.Fprintf(stderr, "codybot: --resume: %v\n", err)
+			return 1
+		}
+	}
+	if fallback, ok := cfg.fallbackConfig(); ok && cfg.WarmStandby {
+		m.standby = &providerHealth{}
+		go watchStandby(context.Background(), fallback, m.standby)
+	}
+
+	if cfg.ControlSocket != "" {
+		control, err := listenControl(cfg.ControlSocket)
+		if err != nil {
+			fmt.Fprintf(stderr, "codybot: --control-socket: %v\n", err)
+			return 1
+		}
+		m.control = control
+	}
+	if cfg.Web != "" {
+		if m.control == nil {
+			m.control = newControlHub()
+		}
+		web, err := startWebMirror(cfg.Web, m.control)
+		if err != nil {
+			fmt.Fprintf(stderr, "codybot: --web: %v\n", err)
+			return 1
+		}
+		defer web.close()
+		m.web = web
+		m.transcript.add(blockNote, "Live view (read-only): "+web.url)
+	}
+
+	options := []tea.ProgramOption{tea.WithAltScreen()}
+	if !cfg.NoMouse {
+		options = append(options, tea.WithMouseCellMotion())
+	}
+	program := tea.NewProgram(newTabSet(m), options...)
+	if m.control != nil {
+		go m.control.serve(program.Send)
+	}
+	final, err := program.Run()
+	m.control.close()
+	if final, ok := final.(tabSet); ok {
+		final.closeAll()
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "codybot error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// registerTUIFlags adds the flags of the interactive UI, which are the
+// shared config flags plus --output and --resume.
+func registerTUIFlags(fs *flag.FlagSet, cfg *config) {
+	registerConfigFlags(fs, cfg)
+	fs.StringVar(&cfg.Output, "output", "", "Mirror the assistant's streamed output into this file")
+	fs.StringVar(&cfg.Output, "o", "", "Shorthand for --output")
+	fs.StringVar(&cfg.Resume, "resume", "", "Continue the saved session with this ID, e.g. one made by /fork")
+	fs.StringVar(&cfg.RecordCommand, "record-command", envOrDefault("CODYBOT_RECORD_COMMAND", ""), "Command that records WAV audio to {file} until interrupted, for Ctrl+R voice input (default: rec, arecord, or sox)")
+	fs.StringVar(&cfg.TranscribeURL, "transcribe-url", envOrDefault("CODYBOT_TRANSCRIBE_URL", ""), "OpenAI-compatible /v1/audio/transcriptions endpoint for voice input (default: the endpoint's own)")
+	fs.StringVar(&cfg.TranscribeModel, "transcribe-model", envOrDefault("CODYBOT_TRANSCRIBE_MODEL", defaultTranscribeModel), "Speech-to-text model for voice input")
+	fs.StringVar(&cfg.TranscribeAPIKey, "transcribe-api-key", envOrDefault("CODYBOT_TRANSCRIBE_API_KEY", ""), "API key for --transcribe-url (default: the endpoint's key, sent only to its own host)")
+	fs.BoolVar(&cfg.NoMouse, "no-mouse", false, "Leave the mouse to the terminal: no wheel scrolling, but its selection works without Shift")
+	fs.BoolVar(&cfg.Safe, "safe", false, "Start in safe mode: no tools, nothing saved, and no settings from the environment, agents.md, or profiles")
+}
+
+func registerConfigFlags(fs *flag.FlagSet, cfg *config) {
+	fs.StringVar(&cfg.BaseURL, "base-url", envOrDefault("OPENAI_BASE_URL", defaultBaseURL), "Base URL for an OpenAI-compatible API")
+	fs.StringVar(&cfg.Model, "model", envOrDefault("CODYBOT_MODEL", defaultModel), "Model name")
+	fs.StringVar(&cfg.APIKey, "api-key", envOrDefault("OPENAI_API_KEY", ""), "API key for the endpoint")
+	fs.StringVar(&cfg.APIKeyCommand, "api-key-command", envOrDefault("CODYBOT_API_KEY_COMMAND", ""), "Shell command that prints the API key; run again when the endpoint rejects the key")
+	fs.StringVar(&cfg.AgentPath, "agents", envOrDefault("CODYBOT_AGENTS", "agents.md"), "Path to agents.md")
+	fs.Float64Var(&cfg.Temperature, "temperature", envFloatOrDefault("CODYBOT_TEMPERATURE", defaultTemperature), "Sampling temperature")
+	fs.StringVar(&cfg.Profile, "profile", envOrDefault("CODYBOT_PROFILE", ""), "Profile to load from agents/<name>.md next to agents.md")
+	fs.StringVar(&cfg.ReplyLanguage, "reply-language", envOrDefault("CODYBOT_REPLY_LANGUAGE", ""), "Natural language for explanations and answers, e.g. Spanish (default: the language of the prompt)")
+	fs.StringVar(&cfg.CommentLanguage, "comment-language", envOrDefault("CODYBOT_COMMENT_LANGUAGE", ""), "Language and style for code comments and identifiers, e.g. English (default: whatever the surrounding code uses)")
+	fs.StringVar(&cfg.TestCommand, "test-command", envOrDefault("CODYBOT_TEST_COMMAND", ""), "Command that runs the project's tests; enables the run_tests tool")
+	fs.StringVar(&cfg.Sandbox, "sandbox", envOrDefault("CODYBOT_SANDBOX", sandboxNone), "Run the shell and test tools in a per-project container: none, docker, or podman")
+	fs.StringVar(&cfg.SandboxImage, "sandbox-image", envOrDefault("CODYBOT_SANDBOX_IMAGE", defaultSandboxImage), "Container image for --sandbox; it needs sh and the project's toolchain")
+	fs.BoolVar(&cfg.SandboxNetwork, "sandbox-network", false, "Give the --sandbox container network access (default: none)")
+	fs.StringVar(&cfg.CommandPolicy, "command-policy", envOrDefault("CODYBOT_COMMAND_POLICY", commandsAsk), "Session tool commands: auto runs them, ask shows each with its risk for approval, strict also blocks destructive ones")
+	fs.StringVar(&cfg.CompareModel, "compare-model", envOrDefault("CODYBOT_COMPARE_MODEL", ""), "Second model /compare streams the same prompt to, side by side with --model")
+	fs.StringVar(&cfg.CheckModel, "check-model", envOrDefault("CODYBOT_CHECK_MODEL", ""), "Cheap model that checks each answer against the tool results it used")
+	fs.StringVar(&cfg.EmbeddingModel, "embedding-model", envOrDefault("CODYBOT_EMBEDDING_MODEL", ""), "Model that embeds the semantic index built by codybot index (default: text-embedding-3-small, or nomic-embed-text for ollama)")
+	fs.StringVar(&cfg.LSP, "lsp", envOrDefault("CODYBOT_LSP", ""), "Language server for the go_to_definition, find_references, and diagnostics tools: auto (detect from the project), off, or a command line")
+	fs.IntVar(&cfg.TestAttempts, "test-attempts", envIntOrDefault("CODYBOT_TEST_ATTEMPTS", defaultTestAttempts), "Maximum run_tests attempts per prompt")
+	fs.BoolVar(&cfg.NoTools, "no-tools", false, "Disable tool calling for models that do not support it")
+	fs.StringVar(&cfg.Workspace, "workspace", envOrDefault("CODYBOT_WORKSPACE", ""), "Workspace root that file tools are confined to (default: current directory)")
+	fs.BoolVar(&cfg.Trust, "trust", false, "Trust the workspace without prompting and remember it")
+	fs.BoolVar(&cfg.NoPromptCheck, "no-prompt-check", false, "Send prompts without checking for garbled or truncated text")
+	fs.BoolVar(&cfg.NoTips, "no-tips", false, "Do not show usage tips in the status line")
+	fs.StringVar(&cfg.HistoryPolicy, "history-policy", envOrDefault("CODYBOT_HISTORY_POLICY", defaultHistoryPolicy), "How to cut history that outgrows the context window: pinned, window, importance, or summary")
+	fs.IntVar(&cfg.ContextWindow, "context-window", envIntOrDefault("CODYBOT_CONTEXT_WINDOW", defaultContextWindow), "Model context window in tokens, used for the context fill indicator")
+	fs.StringVar(&cfg.Provider, "provider", envOrDefault("CODYBOT_PROVIDER", providerOpenAI), "API flavor: openai (any OpenAI-compatible endpoint), ollama (native /api/chat), or openrouter")
+	fs.StringVar(&cfg.KeepAlive, "keep-alive", envOrDefault("CODYBOT_KEEP_ALIVE", ""), "Ollama keep_alive duration for the loaded model (ollama provider)")
+	fs.IntVar(&cfg.NumCtx, "num-ctx", envIntOrDefault("CODYBOT_NUM_CTX", 0), "Ollama num_ctx context length (ollama provider)")
+	fs.Var(newListFlag(&cfg.Headers, "CODYBOT_HEADERS", maskHeader), "header", "Extra `Name: value` header for every request to the endpoint; repeat for more")
+	fs.Var(newListFlag(&cfg.QueryParams, "CODYBOT_QUERY_PARAMS", nil), "query-param", "Extra `name=value` query parameter for every request to the endpoint; repeat for more")
+	fs.StringVar(&cfg.FallbackBaseURL, "fallback-base-url", envOrDefault("CODYBOT_FALLBACK_BASE_URL", ""), "Base URL to fail over to when the primary endpoint errors before responding")
+	fs.StringVar(&cfg.FallbackModel, "fallback-model", envOrDefault("CODYBOT_FALLBACK_MODEL", ""), "Model to use on the fallback endpoint (defaults to --model)")
+	fs.StringVar(&cfg.FallbackAPIKey, "fallback-api-key", envOrDefault("CODYBOT_FALLBACK_API_KEY", ""), "API key for the fallback endpoint")
+	fs.StringVar(&cfg.FallbackProvider, "fallback-provider", envOrDefault("CODYBOT_FALLBACK_PROVIDER", ""), "API flavor of the fallback endpoint (defaults to --provider)")
+	fs.Var(newListFlag(&cfg.FallbackHeaders, "CODYBOT_FALLBACK_HEADERS", maskHeader), "fallback-header", "Extra `Name: value` header for the fallback endpoint in place of --header; repeat for more")
+	fs.Var(newListFlag(&cfg.FallbackQueryParams, "CODYBOT_FALLBACK_QUERY_PARAMS", nil), "fallback-query-param", "Extra `name=value` query parameter for the fallback endpoint in place of --query-param; repeat for more")
+	fs.StringVar(&cfg.Proxy, "proxy", envOrDefault("CODYBOT_PROXY", ""), "HTTP(S) proxy URL (defaults to HTTPS_PROXY/HTTP_PROXY/NO_PROXY)")
+	fs.StringVar(&cfg.CABundle, "ca-bundle", envOrDefault("CODYBOT_CA_BUNDLE", ""), "PEM file of extra CA certificates to trust")
+	fs.BoolVar(&cfg.InsecureSkipVerify, "insecure-skip-verify", false, "Skip TLS certificate verification (self-signed gateways; insecure)")
+	fs.DurationVar(&cfg.DialTimeout, "dial-timeout", envDurationOrDefault("CODYBOT_DIAL_TIMEOUT", defaultDialTimeout), "Give up connecting to an endpoint after this long")
+	fs.DurationVar(&cfg.TLSTimeout, "tls-timeout", envDurationOrDefault("CODYBOT_TLS_TIMEOUT", defaultTLSTimeout), "Give up on a TLS handshake after this long")
+	fs.DurationVar(&cfg.IdleTimeout, "idle-timeout", envDurationOrDefault("CODYBOT_IDLE_TIMEOUT", defaultIdleTimeout), "Keep idle connections open this long for the next request, skipping the connection and TLS handshake (0 closes them)")
+	fs.StringVar(&cfg.ControlSocket, "control-socket", envOrDefault("CODYBOT_CONTROL_SOCKET", ""), "Unix socket path for the JSON control API (editors and scripts)")
+	fs.StringVar(&cfg.Web, "web", envOrDefault("CODYBOT_WEB", ""), "Serve a read-only live view of the session on this address, e.g. 127.0.0.1:8787")
+	fs.StringVar(&cfg.Theme, "theme", envOrDefault("CODYBOT_THEME", "auto"), "Color theme: auto, dark, light, solarized, or a theme YAML file")
+	fs.StringVar(&cfg.Multiplexer, "multiplexer", envOrDefault("CODYBOT_MULTIPLEXER", multiplexerAuto), "Terminal multiplexer /pane opens panes in: auto, tmux, or zellij")
+	fs.StringVar(&cfg.PaneDirection, "pane-direction", envOrDefault("CODYBOT_PANE_DIRECTION", "right"), "Where /pane splits: right or down")
+	fs.StringVar(&cfg.PaneViewer, "pane-viewer", envOrDefault("CODYBOT_PANE_VIEWER", defaultPaneViewer), "Command /pane runs on the file it shows")
+	fs.BoolVar(&cfg.Offline, "offline", false, "Allow network connections only to the inference endpoint and disable network tools")
+	fs.BoolVar(&cfg.NoRedact, "no-redact", false, "Send file contents, command output, and prompts to the model without masking secrets (logs are still masked)")
+	fs.DurationVar(&cfg.CacheTTL, "cache-ttl", envDurationOrDefault("CODYBOT_CACHE_TTL", 0), "Answer identical requests from run, serve, and other headless commands from a local cache for this long, e.g. 24h (default: off)")
+	fs.BoolVar(&cfg.NoCache, "no-cache", false, "Send every request to the endpoint even when --cache-ttl or CODYBOT_CACHE_TTL is set")
+	fs.BoolVar(&cfg.ReadOnly, "read-only", false, "Disable every tool that writes files or runs commands, for Q&A on checkouts that must not change")
+	fs.StringVar(&cfg.OAuthDeviceURL, "oauth-device-url", envOrDefault("CODYBOT_OAUTH_DEVICE_URL", ""), "OAuth device authorization endpoint (device-code login instead of --api-key)")
+	fs.StringVar(&cfg.OAuthTokenURL, "oauth-token-url", envOrDefault("CODYBOT_OAUTH_TOKEN_URL", ""), "OAuth token endpoint")
+	fs.StringVar(&cfg.OAuthClientID, "oauth-client-id", envOrDefault("CODYBOT_OAUTH_CLIENT_ID", ""), "OAuth client ID; enables device-code auth")
+	fs.StringVar(&cfg.OAuthScope, "oauth-scope", envOrDefault("CODYBOT_OAUTH_SCOPE", ""), "OAuth scopes to request")
+	fs.Float64Var(&cfg.InputPrice, "input-price", envFloatOrDefault("CODYBOT_INPUT_PRICE", 0), "USD per million prompt tokens, for spend on /dashboard")
+	fs.Float64Var(&cfg.OutputPrice, "output-price", envFloatOrDefault("CODYBOT_OUTPUT_PRICE", 0), "USD per million completion tokens, for spend on /dashboard")
+	fs.StringVar(&cfg.Images, "images", envOrDefault("CODYBOT_IMAGES", imagesAuto), "Inline images from tool results: auto, kitty, iterm2, sixel, or off")
+	fs.StringVar(&cfg.OpenRouterModels, "openrouter-models", envOrDefault("CODYBOT_OPENROUTER_MODELS", ""), "Comma-separated models OpenRouter falls back to after --model (openrouter provider)")
+	fs.StringVar(&cfg.OpenRouterOrder, "openrouter-order", envOrDefault("CODYBOT_OPENROUTER_ORDER", ""), "Comma-separated upstream providers to try in order, e.g. anthropic,together (openrouter provider)")
+	fs.StringVar(&cfg.OpenRouterIgnore, "openrouter-ignore", envOrDefault("CODYBOT_OPENROUTER_IGNORE", ""), "Comma-separated upstream providers never to use (openrouter provider)")
+	fs.StringVar(&cfg.OpenRouterSort, "openrouter-sort", envOrDefault("CODYBOT_OPENROUTER_SORT", ""), "Prefer the cheapest, fastest, or lowest-latency upstream: price, throughput, or latency (openrouter provider)")
+	fs.BoolVar(&cfg.OpenRouterNoFallbacks, "openrouter-no-fallbacks", false, "Only use the upstreams in --openrouter-order (openrouter provider)")
+	fs.BoolVar(&cfg.OpenRouterDenyData, "openrouter-deny-data-collection", false, "Skip upstreams that store or train on prompts (openrouter provider)")
+	fs.StringVar(&cfg.OpenRouterReferer, "openrouter-referer", envOrDefault("CODYBOT_OPENROUTER_REFERER", defaultOpenRouterReferer), "HTTP-Referer sent to OpenRouter for app attribution")
+	fs.StringVar(&cfg.OpenRouterTitle, "openrouter-title", envOrDefault("CODYBOT_OPENROUTER_TITLE", defaultOpenRouterTitle), "X-Title sent to OpenRouter for app attribution")
+	fs.BoolVar(&cfg.WarmStandby, "warm-standby", false, "Probe the fallback endpoint periodically so failover does not pay connection or model-load cost")
 }
 
-func parseConfig() config {
-	cfg := config{}
-	flag.StringVar(&cfg.BaseURL, "base-url", envOrDefault("OPENAI_BASE_URL", defaultBaseURL), "Base URL for an OpenAI-compatible API")
-	flag.StringVar(&cfg.Model, "model", envOrDefault("CODYBOT_MODEL", defaultModel), "Model name")
-	flag.StringVar(&cfg.APIKey, "api-key", envOrDefault("OPENAI_API_KEY", ""), "API key for the endpoint")
-	flag.StringVar(&cfg.AgentPath, "agents", envOrDefault("CODYBOT_AGENTS", "agents.md"), "Path to agents.md")
-	flag.Parse()
+func (cfg config) normalized() config {
+	if cfg.NumCtx > 0 && cfg.ContextWindow == defaultContextWindow {
+		cfg.ContextWindow = cfg.NumCtx
+	}
+	if cfg.Provider == providerOpenRouter {
+		if cfg.BaseURL == defaultBaseURL {
+			cfg.BaseURL = openRouterBaseURL
+		}
+		if cfg.APIKey == "" {
+			cfg.APIKey = envOrDefault("OPENROUTER_API_KEY", "")
+		}
+	}
 	return cfg
 }
 
+// getenv reads the environment variables flags default to; codybot config
+// swaps it out to tell the built-in defaults apart.
+var getenv = os.Getenv
+
 func envOrDefault(key, fallback string) string {
-	if value := strings.TrimSpace(os.Getenv(key)); value != "" {
+	if value := strings.TrimSpace(getenv(key)); value != "" {
 		return value
 	}
 	return fallback
 }
 
+func envIntOrDefault(key string, fallback int) int {
+	if value := strings.TrimSpace(getenv(key)); value != "" {
+		if n, err := strconv.Atoi(value); err == nil {
+			return n
+		}
+	}
+	return fallback
+}
+
+func envFloatOrDefault(key string, fallback float64) float64 {
+	if value := strings.TrimSpace(getenv(key)); value != "" {
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f
+		}
+	}
+	return fallback
+}
+
+func envDurationOrDefault(key string, fallback time.Duration) time.Duration {
+	if value := strings.TrimSpace(getenv(key)); value != "" {
+		if d, err := time.ParseDuration(value); err == nil {
+			return d
+		}
+	}
+	return fallback
+}
+
 func newModel(cfg config, agentContent string, state appState) model {
+	journal := newEditJournal()
+	var journalErr error
+	if !cfg.Safe {
+		journal, journalErr = openEditJournal(journalFileName)
+	}
+	m := newSessionModel(cfg, agentContent, state, journal)
+	if journalErr != nil {
+		m.notice = fmt.Sprintf("Edit journal not loaded: %s", journalErr)
+	}
+	if report := journal.recoveryReport(); report != "" {
+		m.transcript.add(blockNote, report)
+		m.notice = "A previous change was interrupted • /recover to resolve it"
+	} else if leftovers := journal.findLeftovers(); len(leftovers) > 0 {
+		m.transcript.add(blockNote, leftoverReport(leftovers))
+		m.notice = "An earlier session left uncommitted edits • /leftovers to review, commit, or revert them"
+	}
+	return m
+}
+
+// newSessionModel builds a session around an edit journal that is already
+// open; the tabs of one TUI share theirs.
+func newSessionModel(cfg config, agentContent string, state appState, journal *editJournal) model {
 	ta := textarea.New()
 	ta.Placeholder = "Describe what you want to build..."
 	ta.Prompt = "> "
@@ -168,39 +796,81 @@ func newModel(cfg config, agentContent string, state appState) model {
 
 	spin := spinner.New()
 	spin.Spinner = spinner.Dot
-	spin.Style = lipgloss.NewStyle().Foreground(lipgloss.Color("69"))
+	spin.Style = spinnerStyle
 	var mutex sync.Mutex
 	m := model{
 		state:                state,
 		cfg:                  cfg,
+		baseCfg:              cfg,
 		agentContent:         agentContent,
 		input:                ta,
-		viewport:             viewport.New(0, 0),
+		viewport:             newChatViewport(0, 0),
 		spinner:              spin,
+		transcript:           newTranscript(),
+		mentions:             &mentionIndex{},
+		tips:                 newTipState(cfg.NoTips),
+		images:               imageProtocol(cfg.Images),
 		currentResponse:      &strings.Builder{},
 		currentResponseMutex: &mutex,
+		tests:                newTestLoop(cfg.TestCommand, cfg.TestAttempts),
+		render:               &renderCache{},
+		pins:                 &pinSet{},
+		toolStats:            newToolStats(),
+		sessionID:            newSessionID(),
+		sessionCreated:       time.Now(),
+	}
+	m.journal = journal
+	if cfg.Safe {
+		m.transcript.add(blockNote, safeModeNote)
+	}
+	if !cfg.NoTools {
+		m.tools = newToolRegistry(cfg)
 	}
 	m.system = message{
 		Role:    "system",
-		Content: buildSystemPrompt(agentContent),
+		Content: buildSystemPrompt(cfg, agentContent),
 	}
 	m.history = []message{m.system}
+	m.rotateTip()
 	return m
 }
 
-func buildSystemPrompt(agentContent string) string {
-	base := "You are Codybot, a CLI coding agent. Be concise and practical. Ask clarifying questions only when required."
+func buildSystemPrompt(cfg config, agentContent string) string {
+	base := "You are Codybot, a CLI coding agent. Be concise and practical. Ask clarifying questions only when required. Tool results that start with a [n] marker are citable sources: when a statement relies on one, cite it inline with that marker."
+	base += languageSection(cfg)
+	base += memorySectionPrompt(cfg)
 	if strings.TrimSpace(agentContent) == "" {
 		return base
 	}
 	return fmt.Sprintf("%s\n\nProject instructions (agents.md):\n%s", base, agentContent)
 }
 
+// languageSection tells the model which language to explain in and which to
+// write code in. They are set separately so a team can discuss changes in its
+// own language while the code keeps the project's.
+func languageSection(cfg config) string {
+	reply, comments := strings.TrimSpace(cfg.ReplyLanguage), strings.TrimSpace(cfg.CommentLanguage)
+	var b strings.Builder
+	if reply != "" {
+		fmt.Fprintf(&b, "\n\nWrite explanations, answers, and questions to the user in %s, whatever language the user writes in.", reply)
+	}
+	switch {
+	case comments != "":
+		fmt.Fprintf(&b, "\n\nWrite code comments, docstrings, and new identifiers in %s, even when explaining in another language. Quoted code, commands, and error messages stay verbatim.", comments)
+	case reply != "":
+		b.WriteString(" Code comments and identifiers keep the language the surrounding code already uses.")
+	}
+	return b.String()
+}
+
 func (m model) Init() tea.Cmd {
-	if m.state == stateSetup {
+	switch m.state {
+	case stateTrust:
 		return nil
+	case stateSetup:
+		return pollGit(0)
 	}
-	return tea.Batch(m.spinner.Tick, textarea.Blink)
+	return tea.Batch(m.spinner.Tick, textarea.Blink, pollGit(0))
 }
 
 func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
@@ -209,8 +879,37 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		if m.state == stateSetup {
 			return m.updateSetup(msg)
 		}
+		if m.state == stateTrust {
+			return m.updateTrust(msg)
+		}
+		if m.state == stateTimeline {
+			return m.updateTimeline(msg)
+		}
+		if m.state == stateDashboard {
+			return m.updateDashboard(msg)
+		}
+		if m.state == statePreview {
+			return m.updatePreview(msg)
+		}
+		if m.state == stateSystem {
+			return m.updateSystem(msg)
+		}
+		if m.state == stateCompare {
+			return m.updateCompare(msg)
+		}
+		if m.approval != nil {
+			return m.updateApproval(msg)
+		}
+		if m.palette.active {
+			return m.updatePalette(msg)
+		}
+		m.countPaste(msg)
+		if msg.String() == "pgup" {
+			m.count(usageScrollUp)
+		}
 		handled, cmd := m.updateChatKeys(msg)
 		if handled {
+			m.updateMentions()
 			return m, cmd
 		}
 	case tea.WindowSizeMsg:
@@ -218,12 +917,56 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		return m, nil
 	case streamMsg:
 		return m.handleStreamMsg(msg)
+	case toolResultsMsg:
+		return m.handleToolResults(msg)
+	case commandRiskMsg:
+		return m.handleCommandRiskMsg(msg)
+	case pullMsg:
+		return m.handlePullMsg(msg)
+	case noticeMsg:
+		return m.handleNoticeMsg(msg)
+	case compactMsg:
+		return m.handleCompactMsg(msg)
+	case indexRefreshMsg:
+		if msg.err != nil {
+			m.notice = "Semantic index not updated: " + msg.err.Error() + " • codybot index catches up"
+		}
+		return m, nil
+	case commitDraftMsg:
+		return m.handleCommitDraftMsg(msg)
+	case commitDoneMsg:
+		return m.handleCommitDoneMsg(msg)
+	case selfCheckMsg:
+		return m.handleSelfCheckMsg(msg)
+	case sessionTitleMsg:
+		return m.handleSessionTitleMsg(msg)
+	case gitStateMsg:
+		return m.handleGitStateMsg(msg)
+	case grantExpiredMsg:
+		return m.handleGrantExpiredMsg(msg)
+	case hooksMsg:
+		return m.handleHooksMsg(msg)
+	case compareMsg:
+		return m.handleCompareMsg(msg)
+	case taskDoneMsg:
+		return m.handleTaskDoneMsg(msg)
+	case systemEditedMsg:
+		return m.handleSystemEditedMsg(msg)
+	case worktreeMsg:
+		return m.handleWorktreeMsg(msg)
+	case voiceStoppedMsg:
+		return m.handleVoiceStoppedMsg(msg)
+	case voiceTranscribedMsg:
+		return m.handleVoiceTranscribedMsg(msg)
+	case controlRequestMsg:
+		return m.handleControlRequest(msg)
 	case spinner.TickMsg:
 		if m.streaming {
 			var cmd tea.Cmd
 			m.spinner, cmd = m.spinner.Update(msg)
 			return m, cmd
 		}
+		m.spinning = false
 		return m, nil
 	}
 
@@ -232,10 +975,20 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		var cmd tea.Cmd
 		m.input, cmd = m.input.Update(msg)
 		cmds = append(cmds, cmd)
+		m.updateMentions()
+		if mouse, ok := msg.(tea.MouseMsg); ok && mouse.Button == tea.MouseButtonWheelUp {
+			m.count(usageScrollUp)
+		}
 		m.viewport, cmd = m.viewport.Update(msg)
+		m.updateScrollLock()
 		cmds = append(cmds, cmd)
 		return m, tea.Batch(cmds...)
 	}
+	if m.state == stateSystem {
+		var cmd tea.Cmd
+		m.systemEditor, cmd = m.systemEditor.Update(msg)
+		return m, cmd
+	}
 
 	return m, nil
 }
@@ -245,10 +998,9 @@ func (m model) updateSetup(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	case "y", "Y":
 		if err := writeAgentsTemplate(m.cfg.AgentPath); err != nil {
 			m.lastErr = err
-		} else if data, err := os.ReadFile(m.cfg.AgentPath); err == nil {
-			m.agentContent = string(data)
-			m.system = message{Role: "system", Content: buildSystemPrompt(m.agentContent)}
-			m.history = []message{m.system}
+		} else {
+			m.history = nil
+			m.reloadAgents()
 		}
 		m.state = stateChat
 		return m, tea.Batch(m.spinner.Tick, textarea.Blink)
@@ -262,64 +1014,311 @@ func (m model) updateSetup(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 }
 
 func (m *model) updateChatKeys(msg tea.KeyMsg) (bool, tea.Cmd) {
+	if m.find.active {
+		if handled, cmd := m.updateFindKeys(msg); handled {
+			return true, cmd
+		}
+	}
+	if m.selection.active {
+		if handled, cmd := m.updateSelectKeys(msg); handled {
+			return true, cmd
+		}
+	}
+	if m.commit.reviewing {
+		if handled, cmd := m.updateCommitKeys(msg); handled {
+			return true, cmd
+		}
+	}
+	if m.editing.block != nil && msg.String() == "esc" {
+		if m.editing.confirmed != "" {
+			m.editing.confirmed = ""
+			m.notice = fmt.Sprintf("Editing message #%d • Enter resends it • Esc cancels", m.editing.block.id)
+			return true, nil
+		}
+		m.cancelEdit()
+		return true, nil
+	}
+	if m.promptFlagged != "" && msg.String() == "esc" {
+		m.promptFlagged, m.notice = "", ""
+		return true, nil
+	}
+	if m.mention.active() {
+		if handled, cmd := m.updateMentionKeys(msg); handled {
+			return true, cmd
+		}
+	}
+	if m.voice != nil && msg.String() == "esc" {
+		m.stopVoice(true)
+		return true, nil
+	}
+	if m.updateScrollKeys(msg) {
+		return true, nil
+	}
 	switch msg.String() {
+	case "ctrl+r":
+		return true, m.cmdVoice("")
+	case "ctrl+f":
+		return true, m.cmdFind("")
+	case "ctrl+s":
+		return true, m.cmdSelect("")
 	case "ctrl+c", "esc":
+		if m.streaming {
+			m.cancelTurn()
+			return true, nil
+		}
 		return true, tea.Quit
+	case "ctrl+k":
+		m.openPalette()
+		return true, nil
 	case "ctrl+l":
-		m.transcript = ""
-		m.currentResponseMutex.Lock()
-		m.currentResponse.Reset()
-		m.currentResponseMutex.Unlock()
-		m.history = []message{m.system}
-		m.viewport.SetContent(m.transcript)
+		m.clearConversation()
+		return true, nil
+	case "ctrl+x":
+		if m.lastFailure == nil {
+			return false, nil
+		}
+		return true, m.cmdExplain("")
+	case "ctrl+y":
+		if m.answerEdits == nil || m.streaming {
+			return false, nil
+		}
+		return true, m.cmdApply("")
+	case "alt+enter":
+		if !m.streaming {
+			return false, nil
+		}
+		m.interruptWithSteering()
 		return true, nil
 	case "enter":
 		if m.streaming {
+			m.queueSteering()
+			return true, nil
+		}
+		if m.compacting {
 			return true, nil
 		}
 		text := strings.TrimSpace(m.input.Value())
 		if text == "" {
 			return true, nil
 		}
+		if strings.HasPrefix(text, "/") {
+			m.input.Reset()
+			return true, m.runSlashCommand(text)
+		}
+		if !m.checkPrompt(text) {
+			return true, nil
+		}
+		if err := m.attachMentions(text); err != nil {
+			m.lastErr = err
+			return true, nil
+		}
+		if m.editing.block != nil {
+			resent, cmd := m.resend(text)
+			if resent {
+				m.input.Reset()
+				m.promptFlagged = ""
+			}
+			return true, cmd
+		}
 		m.input.Reset()
-		m.appendTranscript(fmt.Sprintf("You: %s\n\nAssistant: ", text))
-		m.history = append(m.history, message{Role: "user", Content: text})
-		m.streaming = true
-		m.lastErr = nil
-		m.currentResponseMutex.Lock()
-		m.currentResponse.Reset()
-		m.currentResponseMutex.Unlock()
-		m.streamCh = make(chan streamMsg)
-		go streamCompletion(context.Background(), m.cfg, m.history, m.streamCh)
-		return true, tea.Batch(waitStream(m.streamCh), m.spinner.Tick)
+		m.promptFlagged = ""
+		return true, m.send(text, text)
 	}
 	return false, nil
 }
 
+// send starts a turn: text is shown in the transcript and prompt is sent,
+// with any pending attachments and editor context added.
+func (m *model) send(text, prompt string) tea.Cmd {
+	m.scrollLock = false
+	content := prompt + m.nextEditorContext() + m.pendingHookContext
+	m.pendingHookContext = ""
+	user := m.transcript.add(blockUser, text)
+	if len(m.attachments) > 0 {
+		content += attachmentContext(m.attachments)
+		user.chips = renderChips(m.attachments)
+		m.attachments = nil
+		*m = m.applySize(m.width, m.height)
+	}
+	m.history = append(m.history, message{Role: "user", Content: content, Meta: &messageMeta{At: time.Now(), Tokens: estimateTokens(content)}})
+	user.history = len(m.history) - 1
+	user.meta = m.history[user.history].Meta
+	userID := m.control.nextMessageID()
+	m.control.publish(m.sessionID, "message.start", userID, messageStartData{Role: "user"})
+	m.control.publish(m.sessionID, "message.end", userID, messageEndData{Role: "user", Content: text, FinishReason: "stop"})
+	m.addBlock(blockAssistant, "")
+	m.notice = ""
+	m.lastErr = nil
+	m.turn = m.journal.nextTurn()
+	m.toolRounds = 0
+	m.usage = turnUsage{}
+	m.citations = citations{}
+	m.lastFailure = nil
+	m.answerEdits = nil
+	m.cancelled = false
+	m.pins.nextTurn()
+	m.tests.reset()
+	m.turnPrompt = text
+	m.tips.prompts++
+	m.rotateTip()
+	if cmd := m.startPreHooks(); cmd != nil {
+		return cmd
+	}
+	return m.startStream()
+}
+
+// clearConversation starts a new session with an empty transcript.
+func (m *model) clearConversation() {
+	m.count(usageClear)
+	m.closeFind()
+	m.trimmed = 0
+	m.transcript.reset()
+	m.currentResponseMutex.Lock()
+	m.currentResponse.Reset()
+	m.currentResponseMutex.Unlock()
+	m.history = []message{m.system}
+	m.sessionID, m.sessionCreated = newSessionID(), time.Now()
+	m.sessionTitle, m.titleSet = "", false
+	m.forkedFrom = ""
+	m.toolStats = newToolStats()
+	m.answerEdits = nil
+	m.editor.last = ""
+	m.tools.dropCustomTools()
+	m.preview = nil
+	m.promptQueue = nil
+	m.steering, m.steerNow = nil, false
+	m.lastFailure = nil
+	m.jsonTurn, m.lastJSON = nil, ""
+	m.editing = editState{}
+	m.pendingHookContext = ""
+	m.scrollLock = false
+	m.setViewportContent("")
+}
+
+func (m *model) startStream() tea.Cmd {
+	m.streaming = true
+	m.stats.begin()
+	m.hint = ""
+	m.trimmed = 0
+	history := m.withToolHints(m.withPins(m.history))
+	m.usage.input += historyTokens(history)
+	cfg := m.cfg
+	if m.jsonTurn != nil {
+		cfg.schema = m.jsonTurn.schema
+	}
+	m.currentResponseMutex.Lock()
+	m.currentResponse.Reset()
+	m.currentResponseMutex.Unlock()
+	if err := m.tee.beginResponse(); err != nil {
+		m.lastErr = fmt.Errorf("tee %s: %w", m.tee.path, err)
+	}
+	ch := make(chan streamMsg)
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancelStream = cancel
+	go streamWithFailover(ctx, cfg, history, m.tools.definitions(), ch)
+	m.streamCh = batchStream(ch, streamBatchInterval)
+	m.controlMessage = m.control.nextMessageID()
+	m.control.publish(m.sessionID, "message.start", m.controlMessage, messageStartData{Role: "assistant", Model: m.cfg.Model})
+	if m.spinning {
+		return waitStream(m.streamCh)
+	}
+	m.spinning = true
+	return tea.Batch(waitStream(m.streamCh), m.spinner.Tick)
+}
+
 func (m model) handleStreamMsg(msg streamMsg) (tea.Model, tea.Cmd) {
+	if msg.err != nil && m.cancelled {
+		return m.keepPartial()
+	}
 	if msg.err != nil {
+		err := classifyError(m.cfg, msg.err)
+		m.stats.finish()
 		m.streaming = false
-		m.lastErr = msg.err
-		m.appendTranscript(fmt.Sprintf("\n\n[error] %s\n\n", msg.err.Error()))
-		return m, nil
+		m.lastErr = err
+		m.journal.closeTurn()
+		m.transcript.dropEmpty(blockAssistant)
+		m.addBlock(blockError, err.Error())
+		if endpointUnreachable(err) {
+			m.markEndpointDown()
+		}
+		m.control.publish(m.sessionID, "error", m.controlMessage, errorData{Message: err.Error()})
+		m.finishPreview()
+		m.jsonTurn = nil
+		m.promptQueue = nil
+		return m, m.steerAfterStop()
 	}
 
 	if msg.done {
-		m.streaming = false
-		m.appendTranscript("\n\n")
+		m.stats.finish()
+		m.endpointDown = false
 		m.currentResponseMutex.Lock()
-		if response := m.currentResponse.String(); strings.TrimSpace(response) != "" {
-			m.history = append(m.history, message{Role: "assistant", Content: response})
-		}
+		response := m.currentResponse.String()
 		m.currentResponseMutex.Unlock()
-		return m, nil
+		m.usage.output += estimateTokens(response)
+		calls := msg.toolCalls
+		if m.tools == nil {
+			calls = nil
+		}
+		m.publishEnd(response, calls)
+		meta := m.answerMeta(response, msg.model)
+		m.usage.rounds = append(m.usage.rounds, usageRound{model: meta.Model, latencyMS: meta.LatencyMS, durationMS: meta.DurationMS})
+		if last := m.transcript.last(); last != nil && last.kind == blockAssistant {
+			last.setMeta(meta)
+		}
+		if len(msg.toolCalls) > 0 && m.tools != nil {
+			m.history = append(m.history, message{Role: "assistant", Content: response, ToolCalls: msg.toolCalls, Meta: meta})
+			m.transcript.dropEmpty(blockAssistant)
+			for _, call := range msg.toolCalls {
+				m.appendToBlock(blockTool, "[tool] "+call.summary()+"\n")
+			}
+			env := toolEnv{journal: m.journal, tests: m.tests, sandbox: m.tools.sandbox, turn: m.turn, prompt: m.turnPrompt, staged: m.stagingFor()}
+			if cmd := m.requestApproval(env, msg.toolCalls); cmd != nil {
+				return m, cmd
+			}
+			return m, runTools(m.tools, env, msg.toolCalls)
+		}
+		m.streaming = false
+		if footnotes := m.citations.footnotes(response); footnotes != "" {
+			m.appendNote(footnotes)
+		}
+		m.finishJSON(response)
+		m.offerAnswerEdits(response)
+		if strings.TrimSpace(response) != "" {
+			m.history = append(m.history, message{Role: "assistant", Content: response, Meta: meta})
+		}
+		m.journal.closeTurn()
+		m.persistSession()
+		m.recordActivity()
+		m.finishPreview()
+		next := m.sendSteering()
+		if next == nil {
+			next = m.nextQueuedPrompt()
+		}
+		return m, tea.Batch(m.selfCheck(response), m.startPostHooks(), next, m.nameSession())
+	}
+
+	if msg.hint != "" {
+		m.hint = msg.hint
+	}
+
+	if msg.trimmed > 0 {
+		m.trimmed = msg.trimmed
+	}
+	if msg.info != "" {
+		m.notice = msg.info
+		m.control.publish(m.sessionID, "notice", m.controlMessage, noticeData{Text: msg.info})
 	}
 
 	if msg.token != "" {
-		m.appendTranscript(msg.token)
+		m.stats.observe(msg.token, msg.chunks)
+		m.appendToBlock(blockAssistant, msg.token)
+		if err := m.tee.write(msg.token); err != nil {
+			m.lastErr = fmt.Errorf("tee %s: %w", m.tee.path, err)
+		}
 		m.currentResponseMutex.Lock()
 		m.currentResponse.WriteString(msg.token)
 		m.currentResponseMutex.Unlock()
+		m.control.publish(m.sessionID, "token.delta", m.controlMessage, tokenDeltaData{Text: msg.token})
 	}
 
 	if m.streaming {
@@ -328,15 +1327,83 @@ func (m model) handleStreamMsg(msg streamMsg) (tea.Model, tea.Cmd) {
 	return m, nil
 }
 
-func (m *model) appendTranscript(text string) {
-	m.transcript += text
-	m.viewport.SetContent(m.transcript)
-	m.viewport.GotoBottom()
+func (m model) handleToolResults(msg toolResultsMsg) (tea.Model, tea.Cmd) {
+	var images []string
+	var waits []tea.Cmd
+	for _, result := range msg.results {
+		images = append(images, result.images...)
+		if result.task != nil {
+			waits = append(waits, waitTask(result.task))
+		}
+		m.toolStats.record(result)
+		m.usage.tools = append(m.usage.tools, usageTool{name: result.call.Function.Name, duration: result.duration, failed: result.err != nil})
+		toolMsg := result.message()
+		if result.source != "" {
+			n := m.citations.add(result.source)
+			toolMsg.Content = fmt.Sprintf("[%d] %s\n%s", n, result.source, toolMsg.Content)
+		}
+		m.history = append(m.history, toolMsg)
+		if last := m.transcript.last(); last != nil && last.kind == blockTool && toolMsg.Meta != nil {
+			last.setMeta(toolMsg.Meta)
+		}
+		data := toolResultData{ID: result.call.ID, Name: result.call.Function.Name, Output: result.output}
+		if result.err != nil {
+			data.Error = result.err.Error()
+		}
+		m.control.publish(m.sessionID, "tool.result", m.controlMessage, data)
+		if output, failed := result.failure(); failed {
+			m.lastFailure = &toolFailure{call: result.call, output: output}
+		}
+		if result.err != nil {
+			m.appendToBlock(blockTool, "  ✗ "+result.err.Error()+"\n")
+			continue
+		}
+		firstLine, _, _ := strings.Cut(strings.TrimSpace(result.output), "\n")
+		summary, _ := truncateRunes(firstLine, 80)
+		m.appendToBlock(blockTool, fmt.Sprintf("  ✓ %s\n", summary))
+	}
+	for _, path := range images {
+		m.addImageBlock(path)
+	}
+	m.warnSlowTools()
+	waits = append(waits, m.filesChanged(m.journal.turnFiles(m.turn)))
+	if m.cancelled {
+		m.stopTurn("Stopped after the tool calls")
+		return m, tea.Batch(append(waits, m.steerAfterStop())...)
+	}
+	m.toolRounds++
+	if m.toolRounds >= maxToolRounds {
+		m.streaming = false
+		m.lastErr = fmt.Errorf("stopped after %d tool rounds", maxToolRounds)
+		m.journal.closeTurn()
+		m.finishPreview()
+		m.jsonTurn = nil
+		m.promptQueue = nil
+		return m, tea.Batch(append(waits, m.steerAfterStop())...)
+	}
+	m.injectSteering()
+	return m, tea.Batch(append(waits, m.startStream())...)
 }
 
 func (m model) View() string {
-	if m.state == stateSetup {
+	if m.state != stateSetup && m.state != stateTrust && m.tooSmall() {
+		return m.viewTooSmall()
+	}
+	switch m.state {
+	case stateSetup:
 		return m.viewSetup()
+	case stateTrust:
+		return m.viewTrust()
+	case stateTimeline:
+		return m.viewTimeline()
+	case stateDashboard:
+		return m.viewDashboard()
+	case statePreview:
+		return m.viewPreview()
+	case stateSystem:
+		return m.viewSystem()
+	case stateCompare:
+		return m.viewCompare()
 	}
 	return m.viewChat()
 }
@@ -352,47 +1419,120 @@ func (m model) viewSetup() string {
 }
 
 func (m model) viewChat() string {
-	border := lipgloss.NewStyle().Border(lipgloss.RoundedBorder()).Padding(0, 1)
+	border := borderStyle
 
 	header := headerStyle.Render("codybot")
-	subtitle := subtleStyle.Render(fmt.Sprintf("%s @ %s", m.cfg.Model, m.cfg.BaseURL))
-	headerLine := lipgloss.JoinHorizontal(lipgloss.Left, header, " ", subtitle)
+	if m.tabBar != "" {
+		header += " " + m.tabBar
+	}
+	subtitle := subtleStyle.Render(m.headerText())
+	headerLine := m.fitLine(lipgloss.JoinHorizontal(lipgloss.Left, header, " ", subtitle))
 
 	status := m.statusLine()
-	outputBox := border.Width(m.width).Render(m.viewport.View())
+	outputBox := m.renderOutputBox(border)
+	if m.palette.active {
+		outputBox = m.viewPalette(border)
+	}
+	if m.approval != nil {
+		outputBox = m.viewApproval(border)
+	}
 	inputBox := border.Width(m.width).Render(m.input.View())
 
-	return lipgloss.JoinVertical(lipgloss.Left, headerLine, status, outputBox, inputBox)
+	rows := []string{headerLine, status}
+	if m.compact() {
+		rows = []string{m.fitLine(header + " " + subtleStyle.Render(m.statusText()+" • "+m.sandboxMode()))}
+	}
+	if m.mention.active() {
+		rows[len(rows)-1] = m.mentionLine()
+	}
+	rows = append(rows, outputBox)
+	if len(m.attachments) > 0 {
+		rows = append(rows, renderChips(m.attachments))
+	}
+	return lipgloss.JoinVertical(lipgloss.Left, append(rows, inputBox)...)
 }
 
 func (m model) statusLine() string {
+	help := "Enter to send • Ctrl+K for actions • Ctrl+L to clear • Esc to quit"
+	if tip := m.tipText(); tip != "" {
+		help = tip
+	}
+	if m.streaming {
+		help = "Esc to stop • Enter queues guidance • Alt+Enter stops and sends it"
+	}
+	if m.lastFailure != nil && !m.streaming {
+		help = "Ctrl+X explains the failed tool call • " + help
+	}
+	if m.answerEdits != nil && !m.streaming {
+		help = "Ctrl+Y applies the answer's edits • " + help
+	}
+	if m.editing.block != nil && !m.streaming {
+		help = "Enter resends the edited message • Esc cancels"
+	}
+	if m.voice != nil {
+		help = "Ctrl+R stops and transcribes • Esc discards"
+	}
+	if m.approval != nil {
+		help = "y runs the commands • n declines them"
+	}
+	return m.fitLine(lipgloss.JoinHorizontal(lipgloss.Left, subtleStyle.Render(m.statusText()), "  ", subtleStyle.Render(help)))
+}
+
+func (m model) statusText() string {
 	status := "Ready"
+	if m.notice != "" {
+		status = m.notice
+	}
+	if stats := m.stats.summary(); stats != "" && m.notice == "" {
+		status = fmt.Sprintf("Ready • last: %s", stats)
+	}
 	if m.streaming {
-		status = fmt.Sprintf("%s Streaming from %s", m.spinner.View(), m.cfg.Model)
+		status = fmt.Sprintf("%s Streaming from %s • %s", m.spinner.View(), m.cfg.Model, m.stats.summary())
+		if hint := m.waitHint(); hint != "" {
+			status += " • " + hint
+		}
+		if n := len(m.steering); n > 0 {
+			status += fmt.Sprintf(" • %d guidance queued", n)
+		}
+		if m.notice != "" {
+			status += " • " + m.notice
+		}
 	}
-	if m.lastErr != nil {
+	if m.scrollLock {
+		status += " • scroll lock, End follows"
+	}
+	status += fmt.Sprintf(" • ctx %d%%", contextFill(m.history, m.cfg.ContextWindow))
+	if m.trimmed > 0 {
+		status += fmt.Sprintf(" • %d msgs trimmed (%s)", m.trimmed, m.cfg.HistoryPolicy)
+	}
+	if tests := m.tests.status(); tests != "" {
+		status += " • " + tests
+	}
+	if m.tools != nil {
+		if n := m.tools.tasks.unfinished(); n > 0 {
+			status += fmt.Sprintf(" • tasks: %d running", n)
+		}
+	}
+	if standby := m.standby.summary(); standby != "" {
+		status += " • " + standby
+	}
+	if quota := lookupRateLimits(m.cfg).summary(); quota != "" {
+		status += " • " + quota
+	}
+	if m.tee != nil {
+		status += " • tee " + m.tee.path
+	}
+	if m.endpointDown {
+		status += " • model unreachable"
+	}
+	var reqErr *requestError
+	switch {
+	case errors.As(m.lastErr, &reqErr):
+		status = "Error: " + reqErr.hint()
+	case m.lastErr != nil:
 		status = fmt.Sprintf("Error: %s", m.lastErr.Error())
 	}
-	help := "Enter to send • Ctrl+L to clear • Esc to quit"
-	return lipgloss.JoinHorizontal(lipgloss.Left, subtleStyle.Render(status), "  ", subtleStyle.Render(help))
-}
-
-func (m model) applySize(width, height int) model {
-	m.width = width
-	m.height = height
-	contentWidth := max(20, width-4)
-	m.input.SetWidth(contentWidth)
-	m.input.SetHeight(3)
-
-	headerHeight := 1
-	statusHeight := 1
-	inputHeight := m.input.Height() + 2
-	available := height - headerHeight - statusHeight - inputHeight - 2
-	available = max(available, 5)
-	m.viewport = viewport.New(contentWidth, available)
-	m.viewport.SetContent(m.transcript)
-	m.viewport.GotoBottom()
-	return m
+	return status
 }
 
 func waitStream(ch <-chan streamMsg) tea.Cmd {
@@ -401,13 +1541,90 @@ func waitStream(ch <-chan streamMsg) tea.Cmd {
 	}
 }
 
-func streamCompletion(ctx context.Context, cfg config, history []message, ch chan<- streamMsg) {
+// streamProvider sends history, already masked by streamWithFailover, to
+// cfg's endpoint.
+func streamProvider(ctx context.Context, cfg config, history []message, tools []Tool, ch chan<- streamMsg) {
+	if cfg.Provider == providerOllama {
+		streamOllamaChat(ctx, cfg, history, tools, ch)
+		return
+	}
+	streamCompletion(ctx, cfg, history, tools, ch)
+}
+
+// sendCompletion posts the request, first waiting out an exhausted rate
+// limit window and then retrying 429 responses that say when to come back.
+// With a fallback configured a 429 is returned at once so failover can
+// take the request instead of waiting.
+func sendCompletion(ctx context.Context, cfg config, client *http.Client, url string, data []byte, tokens int, ch chan<- streamMsg) (*http.Response, error) {
+	limits := rateLimitsFor(cfg)
+	_, hasFallback := cfg.fallbackConfig()
+	reauthorized := false
+	for attempt := 1; ; attempt++ {
+		if wait, reason := limits.delay(tokens, time.Now()); wait > 0 {
+			ch <- streamMsg{info: fmt.Sprintf("Pausing %s before sending: %s", formatSeconds(wait), reason)}
+			if err := sleepContext(ctx, wait); err != nil {
+				return nil, err
+			}
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if err := cfg.authorize(req); err != nil {
+			return nil, err
+		}
+		setOpenRouterHeaders(req, cfg)
+		setRequestExtras(req, cfg)
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		limits.observe(resp.Header, time.Now())
+		if resp.StatusCode < 300 {
+			return resp, nil
+		}
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 8192))
+		resp.Body.Close()
+		apiErr := &apiError{StatusCode: resp.StatusCode, Status: resp.Status, Body: strings.TrimSpace(string(body))}
+		if resp.StatusCode == http.StatusUnauthorized && !reauthorized {
+			reauthorized = true
+			if cfg.retryUnauthorized(ctx, ch) {
+				attempt--
+				continue
+			}
+		}
+		if resp.StatusCode != http.StatusTooManyRequests {
+			return nil, apiErr
+		}
+		apiErr.RetryAfter = retryAfter(resp.Header, time.Now())
+		wait := apiErr.RetryAfter
+		if wait == 0 {
+			wait = time.Duration(1<<attempt) * time.Second
+		}
+		if hasFallback || attempt > maxRateLimitRetries || wait > maxRateLimitWait {
+			return nil, apiErr
+		}
+		ch <- streamMsg{info: fmt.Sprintf("Rate limited by %s; retrying in %s (%d/%d)", cfg.Model, formatSeconds(wait), attempt, maxRateLimitRetries)}
+		if err := sleepContext(ctx, wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func streamCompletion(ctx context.Context, cfg config, history []message, tools []Tool, ch chan<- streamMsg) {
 	url := strings.TrimRight(cfg.BaseURL, "/") + "/chat/completions"
 	payload := chatCompletionRequest{
 		Model:       cfg.Model,
-		Messages:    history,
+		Messages:    apiMessages(history),
 		Stream:      true,
-		Temperature: 0.2,
+		Temperature: &cfg.Temperature,
+		Seed:        cfg.Seed,
+		Tools:       tools,
+	}
+	payload.Models, payload.Provider = cfg.openRouterRouting()
+	if len(cfg.schema) > 0 {
+		payload.ResponseFormat = &responseFormat{Type: "json_schema", JSONSchema: &jsonSchemaFormat{Name: "response", Schema: cfg.schema}}
 	}
 
 	data, err := json.Marshal(payload)
@@ -416,36 +1633,28 @@ func streamCompletion(ctx context.Context, cfg config, history []message, ch cha
 		return
 	}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	client, err := httpClientFor(cfg)
 	if err != nil {
 		ch <- streamMsg{err: err}
 		return
 	}
-	req.Header.Set("Content-Type", "application/json")
-	if strings.TrimSpace(cfg.APIKey) != "" {
-		req.Header.Set("Authorization", "Bearer "+cfg.APIKey)
-	}
-
-	client := &http.Client{Timeout: 0}
-	resp, err := client.Do(req)
+	resp, err := sendCompletion(ctx, cfg, client, url, data, historyTokens(history), ch)
 	if err != nil {
 		ch <- streamMsg{err: err}
 		return
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode >= 300 {
-		body, _ := io.ReadAll(io.LimitReader(resp.Body, 8192))
-		ch <- streamMsg{err: fmt.Errorf("API error: %s - %s", resp.Status, strings.TrimSpace(string(body)))}
-		return
-	}
-
+	var calls []toolCall
+	var thinking reasoningHint
+	routed := false
+	served := cfg.Model
 	reader := bufio.NewReader(resp.Body)
 	for {
 		line, err := reader.ReadString('\n')
 		if err != nil {
 			if errorsIsEOF(err) {
-				ch <- streamMsg{done: true}
+				ch <- streamMsg{done: true, toolCalls: calls, model: served}
 				return
 			}
 			ch <- streamMsg{err: err}
@@ -459,7 +1668,7 @@ func streamCompletion(ctx context.Context, cfg config, history []message, ch cha
 
 		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
 		if data == "[DONE]" {
-			ch <- streamMsg{done: true}
+			ch <- streamMsg{done: true, toolCalls: calls, model: served}
 			return
 		}
 
@@ -467,13 +1676,40 @@ func streamCompletion(ctx context.Context, cfg config, history []message, ch cha
 		if err := json.Unmarshal([]byte(data), &payload); err != nil {
 			continue
 		}
+		if payload.Error != nil {
+			ch <- streamMsg{err: fmt.Errorf("API error mid-stream: %s (code %v)", payload.Error.Message, payload.Error.Code)}
+			return
+		}
+		// OpenRouter reports the model that actually served the request,
+		// which differs from --model when a fallback was used.
+		if !routed && payload.Model != "" && cfg.Provider == providerOpenRouter {
+			routed = true
+			served = payload.Model
+			if !strings.HasPrefix(payload.Model, cfg.Model) {
+				ch <- streamMsg{info: "Routed to " + payload.Model}
+			}
+		}
 
 		for _, choice := range payload.Choices {
+			if hint := thinking.add(choice.Delta.Reasoning + choice.Delta.ReasoningContent); hint != "" && choice.Delta.Content == "" {
+				ch <- streamMsg{hint: hint}
+			}
 			if choice.Delta.Content != "" {
 				ch <- streamMsg{token: choice.Delta.Content}
 			}
+			for _, delta := range choice.Delta.ToolCalls {
+				for len(calls) <= delta.Index {
+					calls = append(calls, toolCall{Type: "function"})
+				}
+				call := &calls[delta.Index]
+				if delta.ID != "" {
+					call.ID = delta.ID
+				}
+				call.Function.Name += delta.Function.Name
+				call.Function.Arguments += delta.Function.Arguments
+			}
 			if choice.FinishReason != "" {
-				ch <- streamMsg{done: true}
+				ch <- streamMsg{done: true, toolCalls: calls, model: served}
 				return
 			}
 		}
@@ -516,8 +1752,3 @@ func fileExists(path string) bool {
 func errorsIsEOF(err error) bool {
 	return err == io.EOF || strings.Contains(err.Error(), "closed network connection")
 }
-
-var (
-	headerStyle = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("212"))
-	subtleStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("241"))
-)
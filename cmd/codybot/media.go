@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	imagesAuto   = "auto"
+	imagesKitty  = "kitty"
+	imagesITerm  = "iterm2"
+	imagesSixel  = "sixel"
+	imagesOff    = "off"
+	maxImageRefs = 4
+	maxImageRows = 20
+
+	// Typical cell size in pixels, used to size sixel output and to estimate
+	// how many rows an image occupies.
+	cellWidthPx  = 10
+	cellHeightPx = 20
+)
+
+var imagePathPattern = regexp.MustCompile(`[\w./~-]+\.(?i:png|jpe?g|gif)\b`)
+
+func isImagePath(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".png", ".jpg", ".jpeg", ".gif":
+		return true
+	}
+	return false
+}
+
+// imageRefs finds existing image files mentioned in tool output, such as a
+// plot a script just saved or a screenshot read back with read_file.
+func imageRefs(output string) []string {
+	var refs []string
+	seen := map[string]bool{}
+	for _, match := range imagePathPattern.FindAllString(output, -1) {
+		if seen[match] || len(refs) == maxImageRefs {
+			continue
+		}
+		seen[match] = true
+		if info, err := os.Stat(match); err == nil && !info.IsDir() {
+			refs = append(refs, match)
+		}
+	}
+	return refs
+}
+
+// describeImage is what read_file returns for an image instead of raw bytes.
+func describeImage(path string, data []byte) string {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Sprintf("Image file %s (%s, could not decode: %v)", path, formatBytes(int64(len(data))), err)
+	}
+	return fmt.Sprintf("Image file %s (%s, %dx%d, %s)", path, format, cfg.Width, cfg.Height, formatBytes(int64(len(data))))
+}
+
+// imageProtocol resolves --images auto from the terminal environment. Sixel
+// support cannot be detected without querying the terminal, so it is opt-in.
+func imageProtocol(setting string) string {
+	if setting != imagesAuto && setting != "" {
+		return setting
+	}
+	switch {
+	case os.Getenv("KITTY_WINDOW_ID") != "" || strings.Contains(os.Getenv("TERM"), "kitty") || os.Getenv("TERM_PROGRAM") == "ghostty":
+		return imagesKitty
+	case os.Getenv("TERM_PROGRAM") == "iTerm.app" || os.Getenv("TERM_PROGRAM") == "WezTerm":
+		return imagesITerm
+	}
+	return imagesOff
+}
+
+// renderImage draws path inline with the given protocol, at most cols cells
+// wide, followed by blank lines reserving the rows it covers. It always
+// starts with a caption carrying a file:// link so terminals without graphics
+// still get something clickable.
+func renderImage(path, protocol string, cols int) string {
+	caption := imageCaption(path)
+	if protocol == imagesOff || protocol == "" {
+		return caption
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return caption
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return caption
+	}
+	cols, rows := imageCells(img.Bounds(), cols)
+	var graphic string
+	switch protocol {
+	case imagesKitty:
+		graphic = kittyImage(img, cols, rows)
+	case imagesITerm:
+		graphic = fmt.Sprintf("\x1b]1337;File=inline=1;size=%d;width=%d;height=%d;preserveAspectRatio=1:%s\a",
+			len(data), cols, rows, base64.StdEncoding.EncodeToString(data))
+	case imagesSixel:
+		graphic = sixelImage(img, cols*cellWidthPx)
+	default:
+		return caption
+	}
+	return caption + "\n" + graphic + strings.Repeat("\n", rows-1)
+}
+
+func imageCaption(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		abs = path
+	}
+	link := fmt.Sprintf("\x1b]8;;file://%s\x1b\\%s\x1b]8;;\x1b\\", abs, path)
+	return subtleStyle.Render("[image] ") + link
+}
+
+func imageCells(bounds image.Rectangle, maxCols int) (int, int) {
+	w, h := bounds.Dx(), bounds.Dy()
+	cols := max(min(maxCols, (w+cellWidthPx-1)/cellWidthPx), 1)
+	rows := max(h*cols*cellWidthPx/max(w, 1)/cellHeightPx, 1)
+	if rows > maxImageRows {
+		cols = max(cols*maxImageRows/rows, 1)
+		rows = maxImageRows
+	}
+	return cols, rows
+}
+
+// kittyImage transmits the image as PNG in 4096-byte chunks and places it
+// over cols×rows cells without moving the cursor, so the surrounding layout
+// stays line-based.
+func kittyImage(img image.Image, cols, rows int) string {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return ""
+	}
+	payload := base64.StdEncoding.EncodeToString(buf.Bytes())
+	var b strings.Builder
+	for first := true; payload != ""; first = false {
+		chunk := payload[:min(4096, len(payload))]
+		payload = payload[len(chunk):]
+		more := 0
+		if payload != "" {
+			more = 1
+		}
+		if first {
+			fmt.Fprintf(&b, "\x1b_Ga=T,f=100,q=2,C=1,c=%d,r=%d,m=%d;%s\x1b\\", cols, rows, more, chunk)
+		} else {
+			fmt.Fprintf(&b, "\x1b_Gm=%d;%s\x1b\\", more, chunk)
+		}
+	}
+	return b.String()
+}
+
+// sixelImage scales the image to width pixels and encodes it with a fixed
+// 6×6×6 color cube, which keeps the encoder simple at some cost in fidelity.
+func sixelImage(img image.Image, width int) string {
+	bounds := img.Bounds()
+	width = max(min(width, bounds.Dx()), 1)
+	height := max(bounds.Dy()*width/max(bounds.Dx(), 1), 1)
+	index := make([]int, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := color.NRGBAModel.Convert(img.At(bounds.Min.X+x*bounds.Dx()/width, bounds.Min.Y+y*bounds.Dy()/height)).(color.NRGBA)
+			index[y*width+x] = int(c.R)*6/256*36 + int(c.G)*6/256*6 + int(c.B)*6/256
+		}
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "\x1bPq\"1;1;%d;%d", width, height)
+	for i := 0; i < 216; i++ {
+		fmt.Fprintf(&b, "#%d;2;%d;%d;%d", i, i/36*100/5, i/6%6*100/5, i%6*100/5)
+	}
+	for band := 0; band < height; band += 6 {
+		used := map[int]bool{}
+		for y := band; y < min(band+6, height); y++ {
+			for x := 0; x < width; x++ {
+				used[index[y*width+x]] = true
+			}
+		}
+		for c := 0; c < 216; c++ {
+			if !used[c] {
+				continue
+			}
+			fmt.Fprintf(&b, "#%d", c)
+			run, last := 0, byte(0)
+			flush := func() {
+				switch {
+				case run > 3:
+					fmt.Fprintf(&b, "!%d%c", run, last)
+				case run > 0:
+					b.WriteString(strings.Repeat(string(last), run))
+				}
+			}
+			for x := 0; x < width; x++ {
+				bits := 0
+				for dy := 0; dy < 6 && band+dy < height; dy++ {
+					if index[(band+dy)*width+x] == c {
+						bits |= 1 << dy
+					}
+				}
+				ch := byte(63 + bits)
+				if ch == last && run > 0 {
+					run++
+					continue
+				}
+				flush()
+				run, last = 1, ch
+			}
+			flush()
+			b.WriteByte('$')
+		}
+		b.WriteByte('-')
+	}
+	b.WriteString("\x1b\\")
+	return b.String()
+}
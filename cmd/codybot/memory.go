@@ -0,0 +1,348 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+const (
+	// maxMemoryTokens caps what the memory adds to every system prompt, and
+	// maxMemorySummaryTokens the share of it a summary of older facts takes.
+	maxMemoryTokens        = 1500
+	maxMemorySummaryTokens = 400
+	maxFactRunes           = 500
+
+	globalMemoryScope = "All projects"
+)
+
+// The memory is ~/.codybot/memory.md: facts the user or the model chose to
+// keep across sessions, such as conventions and decisions. It is plain
+// markdown the user may edit, one "- fact" per line under a "## scope"
+// heading naming the workspace root, or "All projects".
+
+func memoryPath() string {
+	return filepath.Join(codybotHome(), "memory.md")
+}
+
+// memorySection is one "## scope" heading of the memory file and its facts,
+// oldest first.
+type memorySection struct {
+	scope string
+	facts []string
+}
+
+func loadMemory() ([]memorySection, error) {
+	data, err := os.ReadFile(memoryPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var sections []memorySection
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if scope, ok := strings.CutPrefix(line, "## "); ok {
+			sections = append(sections, memorySection{scope: strings.TrimSpace(scope)})
+			continue
+		}
+		if fact, ok := strings.CutPrefix(line, "- "); ok && len(sections) > 0 {
+			last := &sections[len(sections)-1]
+			last.facts = append(last.facts, strings.TrimSpace(fact))
+		}
+	}
+	return sections, nil
+}
+
+func saveMemory(sections []memorySection) error {
+	var b strings.Builder
+	b.WriteString("# Codybot memory\n\nFacts kept across sessions. Edit freely: one \"- fact\" per line, under the workspace it applies to or \"All projects\".\n")
+	for _, s := range sections {
+		if len(s.facts) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "\n## %s\n\n", s.scope)
+		for _, fact := range s.facts {
+			fmt.Fprintf(&b, "- %s\n", fact)
+		}
+	}
+	return writeFileAtomic(memoryPath(), []byte(b.String()), 0o600)
+}
+
+// rememberFact adds fact to the workspace's section, or to every project's
+// when global is set. It reports false for a fact already in that section.
+func rememberFact(fact string, global bool) (bool, error) {
+	fact = strings.Join(strings.Fields(fact), " ")
+	if fact == "" {
+		return false, errors.New("nothing to remember")
+	}
+	if _, truncated := truncateRunes(fact, maxFactRunes); truncated {
+		return false, fmt.Errorf("facts are limited to %d characters; keep it to one convention or decision", maxFactRunes)
+	}
+	scope := globalMemoryScope
+	if !global {
+		root, err := workspaceRoot()
+		if err != nil {
+			return false, err
+		}
+		scope = root
+	}
+	sections, err := loadMemory()
+	if err != nil {
+		return false, err
+	}
+	i := 0
+	for i < len(sections) && sections[i].scope != scope {
+		i++
+	}
+	if i == len(sections) {
+		sections = append(sections, memorySection{scope: scope})
+	}
+	for _, known := range sections[i].facts {
+		if strings.EqualFold(known, fact) {
+			return false, nil
+		}
+	}
+	sections[i].facts = append(sections[i].facts, fact)
+	return true, saveMemory(sections)
+}
+
+// workspaceFacts returns the facts that apply to the workspace: those kept
+// for every project, then its own.
+func workspaceFacts() ([]string, error) {
+	sections, err := loadMemory()
+	if err != nil {
+		return nil, err
+	}
+	root, _ := workspaceRoot()
+	var facts []string
+	for _, scope := range []string{globalMemoryScope, root} {
+		for _, s := range sections {
+			if s.scope == scope {
+				facts = append(facts, s.facts...)
+			}
+		}
+	}
+	return facts, nil
+}
+
+// splitMemory divides facts that outgrow maxMemoryTokens into the older
+// ones, which go into a summary, and the newest, which are sent as they are
+// since later facts tend to refine earlier ones.
+func splitMemory(facts []string) (older, newer []string) {
+	total := 0
+	for _, fact := range facts {
+		total += estimateTokens(fact)
+	}
+	if total <= maxMemoryTokens {
+		return nil, facts
+	}
+	budget, first := maxMemoryTokens-maxMemorySummaryTokens, len(facts)
+	for first > 0 && estimateTokens(facts[first-1]) <= budget {
+		first--
+		budget -= estimateTokens(facts[first])
+	}
+	return facts[:first], facts[first:]
+}
+
+// memorySectionPrompt is the memory as a system prompt section: the summary
+// of the older facts, then the newest. Until summarizeMemory has written a
+// summary for the current older facts, the model is told how many were left
+// out instead.
+func memorySectionPrompt(cfg config) string {
+	if cfg.Safe {
+		return ""
+	}
+	facts, err := workspaceFacts()
+	if err != nil || len(facts) == 0 {
+		return ""
+	}
+	older, newer := splitMemory(facts)
+	var b strings.Builder
+	b.WriteString("\n\nRemembered from earlier sessions (conventions and decisions to follow unless the user says otherwise):")
+	if len(older) > 0 {
+		if summary, ok := memorySummary(older); ok {
+			fmt.Fprintf(&b, "\nIn short, from %d older facts:\n%s\nNewer facts, which take precedence:", len(older), summary)
+		} else {
+			fmt.Fprintf(&b, "\n(%d older facts left out for length.)", len(older))
+		}
+	}
+	for _, fact := range newer {
+		b.WriteString("\n- " + fact)
+	}
+	return b.String()
+}
+
+const memorySummaryPrompt = `These facts were kept across sessions of work on one project: conventions, decisions and their reasons, and the user's preferences. Condense them into a few short "- " lines. Keep every rule still in force; where a later fact contradicts an earlier one, keep the later. Reply with the lines only.`
+
+// memorySummaries holds the summary of each workspace's older facts, by
+// workspace root. They live beside the memory rather than in it, so the file
+// stays the user's own words.
+type memorySummaries map[string]memorySummaryEntry
+
+type memorySummaryEntry struct {
+	// Hash is that of the facts the summary was written from.
+	Hash    string `json:"hash"`
+	Summary string `json:"summary"`
+}
+
+func memorySummariesPath() string {
+	return filepath.Join(codybotHome(), "memory-summaries.json")
+}
+
+func loadMemorySummaries() memorySummaries {
+	summaries := memorySummaries{}
+	if data, err := os.ReadFile(memorySummariesPath()); err == nil {
+		json.Unmarshal(data, &summaries)
+	}
+	return summaries
+}
+
+func factsHash(facts []string) string {
+	sum := sha256.Sum256([]byte(strings.Join(facts, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+// memorySummary returns the summary written from exactly these facts. After
+// a fact is added or the file edited, the older facts change and the old
+// summary no longer applies.
+func memorySummary(older []string) (string, bool) {
+	root, err := workspaceRoot()
+	if err != nil {
+		return "", false
+	}
+	s, ok := loadMemorySummaries()[root]
+	return s.Summary, ok && s.Hash == factsHash(older) && s.Summary != ""
+}
+
+// summarizeMemory has the model condense the workspace's older facts once
+// they outgrow the prompt's share, so later sessions get their gist rather
+// than losing them. It does nothing while the facts fit or the summary is
+// current.
+func summarizeMemory(ctx context.Context, cfg config) error {
+	if cfg.Safe {
+		return nil
+	}
+	facts, err := workspaceFacts()
+	if err != nil {
+		return err
+	}
+	older, _ := splitMemory(facts)
+	if len(older) == 0 {
+		return nil
+	}
+	if _, ok := memorySummary(older); ok {
+		return nil
+	}
+	root, err := workspaceRoot()
+	if err != nil {
+		return err
+	}
+	request := []message{
+		{Role: "system", Content: memorySummaryPrompt},
+		{Role: "user", Content: "- " + strings.Join(older, "\n- ")},
+	}
+	cfg.schema = nil
+	_, summary, err := runAgentLoop(ctx, cfg, nil, nil, request, nil)
+	if err != nil {
+		return err
+	}
+	summary = strings.TrimSpace(summary)
+	if summary == "" {
+		return errors.New("the model returned an empty summary")
+	}
+	summary, _ = truncateRunes(summary, maxMemorySummaryTokens*4)
+	summaries := loadMemorySummaries()
+	summaries[root] = memorySummaryEntry{Hash: factsHash(older), Summary: summary}
+	data, err := json.MarshalIndent(summaries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(memorySummariesPath(), data, 0o600)
+}
+
+// memorizer runs the memorize tool, with the config it needs to summarize
+// the memory once it grows.
+type memorizer struct {
+	cfg config
+}
+
+func (z memorizer) toolMemorize(ctx context.Context, _ *toolEnv, raw json.RawMessage) (string, error) {
+	var args struct {
+		Fact string `json:"fact"`
+	}
+	if err := decodeArgs(raw, &args); err != nil {
+		return "", err
+	}
+	added, err := rememberFact(args.Fact, false)
+	if err != nil {
+		return "", err
+	}
+	if !added {
+		return "Already remembered.", nil
+	}
+	// The fact is kept either way; without a summary, later sessions are
+	// told how many older facts were left out.
+	_ = summarizeMemory(ctx, z.cfg)
+	return "Remembered; future sessions will see it.", nil
+}
+
+// cmdRemember keeps a fact for future sessions, or lists the facts that
+// apply to this workspace.
+func (m *model) cmdRemember(args string) tea.Cmd {
+	if m.cfg.Safe {
+		m.notice = "Safe mode: nothing is saved, so /remember is off"
+		return nil
+	}
+	fact, global := args, false
+	if rest, ok := strings.CutPrefix(args, "--global"); ok && (rest == "" || rest[0] == ' ') {
+		fact, global = strings.TrimSpace(rest), true
+	}
+	if fact == "" {
+		facts, err := workspaceFacts()
+		if err != nil {
+			m.lastErr = err
+			return nil
+		}
+		if len(facts) == 0 {
+			m.notice = "Nothing remembered for this workspace; /remember <fact> keeps one"
+			return nil
+		}
+		var b strings.Builder
+		fmt.Fprintf(&b, "Remembered facts, added to new sessions' system prompt (edit %s to change them):\n", displayPath(memoryPath()))
+		for _, fact := range facts {
+			fmt.Fprintf(&b, "  - %s\n", fact)
+		}
+		m.appendNote(b.String())
+		return nil
+	}
+	added, err := rememberFact(fact, global)
+	if err != nil {
+		m.lastErr = err
+		return nil
+	}
+	m.lastErr = nil
+	switch {
+	case !added:
+		m.notice = "Already remembered"
+		return nil
+	case global:
+		m.notice = "Remembered for every project, from the next session"
+	default:
+		m.notice = "Remembered for this workspace, from the next session"
+	}
+	cfg := m.cfg
+	return func() tea.Msg {
+		_ = summarizeMemory(context.Background(), cfg)
+		return nil
+	}
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+const (
+	// maxMentionFiles bounds the workspace walk behind @ completion, so a
+	// huge tree cannot stall typing.
+	maxMentionFiles = 20000
+	// mentionIndexTTL is how long the file list is reused before the next
+	// completion walks the workspace again.
+	mentionIndexTTL    = 10 * time.Second
+	maxMentionMatches  = 8
+	mentionTrailingSet = ",.;:!?)]}'\""
+)
+
+// mentionPattern finds @path tokens: an @ at the start of the prompt or after
+// whitespace, so e-mail addresses are left alone.
+var mentionPattern = regexp.MustCompile(`(^|\s)@(\S+)`)
+
+// mentionIndex caches the workspace's file paths for @ completion.
+type mentionIndex struct {
+	files  []string
+	loaded time.Time
+}
+
+// list returns the workspace's files, relative to the root and with forward
+// slashes, walking it again once the cached list is older than the TTL.
+func (ix *mentionIndex) list() []string {
+	if ix.files != nil && time.Since(ix.loaded) < mentionIndexTTL {
+		return ix.files
+	}
+	root, err := workspaceRoot()
+	if err != nil {
+		return nil
+	}
+	files := []string{}
+	errFull := errors.New("mention index full")
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if path != root && skippedDirs[d.Name()] {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return nil
+		}
+		files = append(files, filepath.ToSlash(rel))
+		if len(files) >= maxMentionFiles {
+			return errFull
+		}
+		return nil
+	})
+	ix.files, ix.loaded = files, time.Now()
+	return files
+}
+
+// refresh updates the cached list for the files an edit touched instead of
+// walking the workspace again: files the edit created are added and files it
+// removed are dropped, so completions stay accurate during long refactors.
+// Changes made by shell commands are picked up by the next full walk.
+func (ix *mentionIndex) refresh(paths []string) {
+	if ix.files == nil || len(paths) == 0 {
+		return
+	}
+	root, err := workspaceRoot()
+	if err != nil {
+		return
+	}
+	changed := map[string]bool{}
+	for _, path := range workspaceFiles(root, paths) {
+		changed[path] = true
+	}
+	kept := ix.files[:0]
+	for _, file := range ix.files {
+		if !changed[file] {
+			kept = append(kept, file)
+		}
+	}
+	for file := range changed {
+		if info, err := os.Stat(filepath.Join(root, filepath.FromSlash(file))); err == nil && info.Mode().IsRegular() {
+			kept = append(kept, file)
+		}
+	}
+	ix.files = kept
+}
+
+// workspaceFiles turns the paths an edit touched, relative or absolute, into
+// paths relative to root with forward slashes, dropping those outside it.
+func workspaceFiles(root string, paths []string) []string {
+	var files []string
+	for _, path := range paths {
+		if filepath.IsAbs(path) {
+			rel, err := filepath.Rel(root, path)
+			if err != nil || !within(root, path) {
+				continue
+			}
+			path = rel
+		}
+		files = append(files, filepath.ToSlash(filepath.Clean(path)))
+	}
+	return files
+}
+
+// filesChanged updates what caches the workspace's files after an edit,
+// /undo, or /redo changed paths: the @ completion list at once, and the
+// semantic index in the background.
+func (m *model) filesChanged(paths []string) tea.Cmd {
+	m.mentions.refresh(paths)
+	return m.refreshIndex(paths)
+}
+
+// mentionState is the @ completion under the input: the files matching the
+// token being typed and the one Tab would insert.
+type mentionState struct {
+	query   string
+	matches []string
+	cursor  int
+	// dismissed is the token Esc closed the completion on; it stays closed
+	// until the token changes.
+	dismissed string
+}
+
+func (s mentionState) active() bool {
+	return len(s.matches) > 0
+}
+
+// mentionToken returns the @ token at the end of the input, without the @.
+func mentionToken(value string) (string, bool) {
+	start := strings.LastIndexAny(value, " \t\n")
+	token := value[start+1:]
+	if !strings.HasPrefix(token, "@") {
+		return "", false
+	}
+	return token[1:], true
+}
+
+// matchMentions ranks files by how well they fuzzily match query, preferring
+// shorter paths among equal scores.
+func matchMentions(files []string, query string) []string {
+	type scored struct {
+		path  string
+		score int
+	}
+	var found []scored
+	for _, path := range files {
+		if score, ok := fuzzyScore(query, path); ok {
+			found = append(found, scored{path, score})
+		}
+	}
+	sort.SliceStable(found, func(i, j int) bool {
+		if found[i].score != found[j].score {
+			return found[i].score > found[j].score
+		}
+		return len(found[i].path) < len(found[j].path)
+	})
+	matches := make([]string, 0, min(len(found), maxMentionMatches))
+	for _, f := range found[:min(len(found), maxMentionMatches)] {
+		matches = append(matches, f.path)
+	}
+	return matches
+}
+
+// updateMentions refreshes the completion after the input changed.
+func (m *model) updateMentions() {
+	query, ok := mentionToken(m.input.Value())
+	if !ok || m.streaming || m.mentions == nil {
+		m.mention = mentionState{}
+		return
+	}
+	if query == m.mention.query && (m.mention.active() || m.mention.dismissed == query) {
+		return
+	}
+	m.mention = mentionState{query: query, matches: matchMentions(m.mentions.list(), query)}
+	if len(m.mention.matches) == 1 && m.mention.matches[0] == query {
+		// Already complete.
+		m.mention.matches = nil
+	}
+}
+
+// updateMentionKeys handles Tab to insert the selected file, Ctrl+N and
+// Ctrl+P to move through the matches, and Esc to close them.
+func (m *model) updateMentionKeys(msg tea.KeyMsg) (bool, tea.Cmd) {
+	switch msg.String() {
+	case "tab":
+		value := m.input.Value()
+		path := m.mention.matches[m.mention.cursor]
+		m.input.SetValue(value[:len(value)-len(m.mention.query)] + path + " ")
+		m.input.CursorEnd()
+		m.mention = mentionState{}
+		return true, nil
+	case "ctrl+n", "down":
+		m.mention.cursor = (m.mention.cursor + 1) % len(m.mention.matches)
+		return true, nil
+	case "ctrl+p", "up":
+		m.mention.cursor = (m.mention.cursor - 1 + len(m.mention.matches)) % len(m.mention.matches)
+		return true, nil
+	case "esc":
+		m.mention = mentionState{query: m.mention.query, dismissed: m.mention.query}
+		return true, nil
+	}
+	return false, nil
+}
+
+// mentionLine replaces the status line while completing, listing the
+// matches with the selected one highlighted.
+func (m model) mentionLine() string {
+	parts := make([]string, len(m.mention.matches))
+	for i, path := range m.mention.matches {
+		if i == m.mention.cursor {
+			parts[i] = timelineSelectedStyle.Render("▸ " + path)
+		} else {
+			parts[i] = subtleStyle.Render(path)
+		}
+	}
+	return m.fitLine(subtleStyle.Render("@ Tab inserts • Ctrl+N/P moves • ") + strings.Join(parts, "  "))
+}
+
+// mentionedPaths returns the workspace files a prompt mentions with @, in
+// order and without repeats. Tokens that are not files, such as @someone,
+// are ordinary text.
+func mentionedPaths(text string) []string {
+	var paths []string
+	seen := map[string]bool{}
+	for _, match := range mentionPattern.FindAllStringSubmatch(text, -1) {
+		for _, candidate := range []string{match[2], strings.TrimRight(match[2], mentionTrailingSet)} {
+			rel, err := resolveWorkspacePath(filepath.FromSlash(candidate))
+			if err != nil {
+				continue
+			}
+			info, err := os.Stat(rel)
+			if err != nil || !info.Mode().IsRegular() {
+				continue
+			}
+			if !seen[rel] {
+				seen[rel] = true
+				paths = append(paths, rel)
+			}
+			break
+		}
+	}
+	return paths
+}
+
+// attachMentions attaches the files text mentions, under the same size
+// limits and binary check as /attach. Nothing is attached if one fails, so
+// the user can fix the prompt and send it again.
+func (m *model) attachMentions(text string) error {
+	var added []attachment
+	for _, rel := range mentionedPaths(text) {
+		if m.hasAttachment(rel) {
+			continue
+		}
+		if len(m.attachments)+len(added) >= maxPendingAttachment {
+			return fmt.Errorf("at most %d files can be attached per message; @%s is one too many", maxPendingAttachment, filepath.ToSlash(rel))
+		}
+		att, err := loadAttachment(rel)
+		if err != nil {
+			return fmt.Errorf("@%s: %w", filepath.ToSlash(rel), err)
+		}
+		// The model needs the path, not just the base name, to act on it.
+		att.Name = filepath.ToSlash(rel)
+		added = append(added, att)
+	}
+	m.attachments = append(m.attachments, added...)
+	return nil
+}
+
+func (m *model) hasAttachment(path string) bool {
+	for _, att := range m.attachments {
+		if filepath.Clean(att.Path) == path {
+			return true
+		}
+	}
+	return false
+}
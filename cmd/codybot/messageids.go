@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+func (m *model) cmdIDs(args string) tea.Cmd {
+	show := m.transcript.hideIDs
+	switch args {
+	case "on":
+		show = true
+	case "off":
+		show = false
+	case "":
+	default:
+		m.notice = "Usage: /ids [on|off]"
+		return nil
+	}
+	m.transcript.setHideIDs(!show)
+	m.refreshTranscript()
+	m.notice = "Message numbers hidden; commands still take #n"
+	if show {
+		m.notice = "Showing message numbers for /copy, /quote, /rewind-to, and /export"
+	}
+	return nil
+}
+
+func (t *transcript) setHideIDs(hide bool) {
+	t.hideIDs = hide
+	for _, b := range t.blocks {
+		b.hideID, b.cache = hide, ""
+	}
+	t.invalidate()
+}
+
+// blockRange resolves "#a..#b", or a single "#n", to the blocks numbered
+// from a through b, in transcript order.
+func (m *model) blockRange(args string) ([]*block, error) {
+	from, to, isRange := strings.Cut(args, "..")
+	if !isRange {
+		to = from
+	}
+	first, err := strconv.Atoi(strings.TrimPrefix(strings.TrimSpace(from), "#"))
+	if err != nil {
+		return nil, fmt.Errorf("expected a message number or range like #10..#20, got %q", args)
+	}
+	last, err := strconv.Atoi(strings.TrimPrefix(strings.TrimSpace(to), "#"))
+	if err != nil {
+		return nil, fmt.Errorf("expected a message number or range like #10..#20, got %q", args)
+	}
+	if first > last {
+		first, last = last, first
+	}
+	var blocks []*block
+	for _, b := range m.transcript.blocks {
+		if b.id >= first && b.id <= last {
+			blocks = append(blocks, b)
+		}
+	}
+	if len(blocks) == 0 {
+		return nil, fmt.Errorf("no messages in #%d..#%d", first, last)
+	}
+	return blocks, nil
+}
+
+// exportBlocks renders transcript blocks as markdown, headed by their
+// numbers so an excerpt can be matched back to the conversation.
+func exportBlocks(blocks []*block) string {
+	var b strings.Builder
+	for _, blk := range blocks {
+		text := blk.plain()
+		if text == "" {
+			continue
+		}
+		fmt.Fprintf(&b, "## #%d %s\n\n", blk.id, blk.label())
+		if blk.kind == blockTool {
+			b.WriteString("```\n" + text + "\n```\n\n")
+		} else {
+			b.WriteString(text + "\n\n")
+		}
+	}
+	return b.String()
+}
+
+// cmdQuote puts a message into the input as a markdown quote, to reply to
+// it in the next prompt.
+func (m *model) cmdQuote(args string) tea.Cmd {
+	b, err := m.blockArg(strings.TrimSpace(args), blockAssistant)
+	if err != nil {
+		m.lastErr = err
+		return nil
+	}
+	text := b.plain()
+	if text == "" {
+		m.notice = fmt.Sprintf("Message #%d is empty", b.id)
+		return nil
+	}
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight("> "+line, " ")
+	}
+	quote := strings.Join(lines, "\n") + "\n\n"
+	m.lastErr = nil
+	m.input.SetValue(quote + m.input.Value())
+	m.input.CursorEnd()
+	m.notice = fmt.Sprintf("Quoted message #%d", b.id)
+	return nil
+}
+
+// cmdRewindTo drops every message after the turn that contains #n, from the
+// transcript and from what the model sees. A turn is kept whole, so a tool
+// call never loses its result. File edits are left alone: /undo and
+// /timeline revert those.
+func (m *model) cmdRewindTo(args string) tea.Cmd {
+	if m.streaming {
+		m.notice = "Wait for the current response to finish before rewinding"
+		return nil
+	}
+	args = strings.TrimSpace(args)
+	if args == "" {
+		m.notice = "Usage: /rewind-to #n"
+		return nil
+	}
+	target, err := m.blockArg(args, blockUser)
+	if err != nil {
+		m.lastErr = err
+		return nil
+	}
+	blocks := m.transcript.blocks
+	at := 0
+	for i, b := range blocks {
+		if b == target {
+			at = i
+		}
+	}
+	next := -1
+	for i := at + 1; i < len(blocks); i++ {
+		if blocks[i].kind == blockUser {
+			next = i
+			break
+		}
+	}
+	if next < 0 {
+		m.notice = fmt.Sprintf("Nothing after message #%d's turn to rewind", target.id)
+		return nil
+	}
+	cut := blocks[next].history
+	if cut < 0 {
+		m.lastErr = fmt.Errorf("message #%d was compacted into a summary; rewind to a later message", target.id)
+		return nil
+	}
+	if cut >= len(m.history) {
+		m.lastErr = fmt.Errorf("message #%d no longer matches the conversation", blocks[next].id)
+		return nil
+	}
+	removed := len(blocks) - next
+	m.history = m.history[:cut]
+	m.transcript.truncate(blocks[next-1])
+	m.lastErr = nil
+	m.citations = citations{}
+	m.tests.reset()
+	m.refreshTranscript()
+	m.persistSession()
+	m.notice = fmt.Sprintf("Rewound to message #%d (%d messages removed) • file edits are kept; /undo reverts them", target.id, removed)
+	return nil
+}
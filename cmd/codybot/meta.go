@@ -0,0 +1,103 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// messageMeta records when and how a message was produced, for auditing a
+// session afterwards. It is saved with the session but never sent to the
+// model.
+type messageMeta struct {
+	At    time.Time `json:"at"`
+	Model string    `json:"model,omitempty"`
+	// LatencyMS is the time to the first token of an answer.
+	LatencyMS int64 `json:"latency_ms,omitempty"`
+	// DurationMS is how long an answer streamed or a tool ran.
+	DurationMS int64 `json:"duration_ms,omitempty"`
+	// Tokens estimates the message itself, and PromptTokens the context an
+	// answer was generated from.
+	Tokens       int `json:"tokens,omitempty"`
+	PromptTokens int `json:"prompt_tokens,omitempty"`
+	// Truncated marks an answer the user stopped before it finished.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+func (meta *messageMeta) line() string {
+	parts := []string{meta.At.Local().Format("15:04:05")}
+	if meta.Model != "" {
+		parts = append(parts, meta.Model)
+	}
+	if meta.LatencyMS > 0 {
+		parts = append(parts, "first token "+formatSeconds(time.Duration(meta.LatencyMS)*time.Millisecond))
+	}
+	if meta.DurationMS > 0 {
+		parts = append(parts, formatSeconds(time.Duration(meta.DurationMS)*time.Millisecond))
+	}
+	if meta.Tokens > 0 {
+		parts = append(parts, formatCount(meta.Tokens)+" tok")
+	}
+	if meta.PromptTokens > 0 {
+		parts = append(parts, formatCount(meta.PromptTokens)+" tok context")
+	}
+	if meta.Truncated {
+		parts = append(parts, "stopped")
+	}
+	return "⏱ " + strings.Join(parts, " • ")
+}
+
+// apiMessages drops the metadata from the history before it is sent.
+func apiMessages(history []message) []message {
+	out := make([]message, len(history))
+	for i, msg := range history {
+		msg.Meta = nil
+		out[i] = msg
+	}
+	return out
+}
+
+func (m *model) cmdMeta(args string) tea.Cmd {
+	show := !m.transcript.showMeta
+	switch args {
+	case "on":
+		show = true
+	case "off":
+		show = false
+	case "":
+	default:
+		m.notice = "Usage: /meta [on|off]"
+		return nil
+	}
+	m.transcript.setShowMeta(show)
+	m.refreshTranscript()
+	m.notice = "Message metadata hidden"
+	if show {
+		m.notice = "Showing time, model, latency, and tokens per message"
+	}
+	return nil
+}
+
+func (t *transcript) setShowMeta(show bool) {
+	t.showMeta = show
+	for _, b := range t.blocks {
+		b.showMeta = show
+	}
+	t.invalidate()
+}
+
+// answerMeta describes the answer that just finished streaming.
+func (m *model) answerMeta(response, model string) *messageMeta {
+	if model == "" {
+		model = m.cfg.Model
+	}
+	return &messageMeta{
+		At:           time.Now(),
+		Model:        model,
+		LatencyMS:    m.stats.ttft().Milliseconds(),
+		DurationMS:   m.stats.elapsed().Milliseconds(),
+		Tokens:       estimateTokens(response),
+		PromptTokens: historyTokens(m.history),
+	}
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	maxGuideChars     = 20000
+	maxUsageLines     = 40
+	maxInventoryBytes = 1 << 20
+)
+
+var skippedDirs = map[string]bool{
+	".git":         true,
+	".codybot":     true,
+	"vendor":       true,
+	"node_modules": true,
+	"dist":         true,
+	"build":        true,
+}
+
+var lockFiles = map[string]bool{
+	"go.sum":            true,
+	"package-lock.json": true,
+	"yarn.lock":         true,
+	"pnpm-lock.yaml":    true,
+	"Cargo.lock":        true,
+}
+
+type libraryVersion struct {
+	Name    string
+	Version string
+}
+
+func parseLibraryVersion(spec string) (libraryVersion, error) {
+	at := strings.LastIndex(spec, "@")
+	if at <= 0 || at == len(spec)-1 {
+		return libraryVersion{}, fmt.Errorf("expected name@version, got %q", spec)
+	}
+	return libraryVersion{Name: spec[:at], Version: spec[at+1:]}, nil
+}
+
+func (l libraryVersion) String() string {
+	return l.Name + "@" + l.Version
+}
+
+type usageFile struct {
+	Path  string
+	Lines []string
+}
+
+// inventoryUsages finds every text file under root that mentions the library,
+// with the matching lines and their numbers.
+func inventoryUsages(root, name string) ([]usageFile, error) {
+	var files []usageFile
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != root && skippedDirs[d.Name()] {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if lockFiles[d.Name()] {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil || info.Size() > maxInventoryBytes {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil || bytes.IndexByte(data, 0) >= 0 || !bytes.Contains(data, []byte(name)) {
+			return nil
+		}
+		usage := usageFile{Path: path}
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		scanner.Buffer(make([]byte, 0, 64*1024), maxInventoryBytes)
+		for n := 1; scanner.Scan(); n++ {
+			if strings.Contains(scanner.Text(), name) {
+				usage.Lines = append(usage.Lines, fmt.Sprintf("%d: %s", n, strings.TrimSpace(scanner.Text())))
+			}
+		}
+		files = append(files, usage)
+		return nil
+	})
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files, err
+}
+
+// loadMigrationGuide reads the guide from an explicit path or URL, or looks
+// for a changelog shipped with the target version in the Go module cache,
+// node_modules, or vendor.
+func loadMigrationGuide(ctx context.Context, cfg config, source string, to libraryVersion) (string, string, error) {
+	if source != "" {
+		text, err := readGuide(ctx, cfg, source)
+		return text, source, err
+	}
+	for _, candidate := range guideCandidates(to) {
+		if text, err := os.ReadFile(candidate); err == nil {
+			return string(text), candidate, nil
+		}
+	}
+	return "", "", errors.New("no migration guide found; pass --guide <path|url>")
+}
+
+func readGuide(ctx context.Context, cfg config, source string) (string, error) {
+	if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+		data, err := os.ReadFile(source)
+		return string(data), err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+	if err != nil {
+		return "", err
+	}
+	client, err := httpClientFor(cfg)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return "", fmt.Errorf("fetch %s: %s", source, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 4*maxGuideChars))
+	return string(data), err
+}
+
+func guideCandidates(to libraryVersion) []string {
+	modCache := os.Getenv("GOMODCACHE")
+	if modCache == "" {
+		if gopath := os.Getenv("GOPATH"); gopath != "" {
+			modCache = filepath.Join(gopath, "pkg", "mod")
+		} else if home, err := os.UserHomeDir(); err == nil {
+			modCache = filepath.Join(home, "go", "pkg", "mod")
+		}
+	}
+	dirs := []string{
+		filepath.Join(modCache, escapeModulePath(to.Name)+"@"+to.Version),
+		filepath.Join("node_modules", to.Name),
+		filepath.Join("vendor", to.Name),
+	}
+	var candidates []string
+	for _, dir := range dirs {
+		for _, name := range []string{"MIGRATION.md", "UPGRADING.md", "CHANGELOG.md"} {
+			candidates = append(candidates, filepath.Join(dir, name))
+		}
+	}
+	return candidates
+}
+
+// escapeModulePath applies the Go module cache's case encoding, where each
+// upper-case letter is stored as '!' followed by its lower-case form.
+func escapeModulePath(path string) string {
+	var b strings.Builder
+	for _, r := range path {
+		if unicode.IsUpper(r) {
+			b.WriteByte('!')
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func migrationPrompt(from, to libraryVersion, guide string, file usageFile) string {
+	lines := file.Lines
+	if len(lines) > maxUsageLines {
+		lines = append(lines[:maxUsageLines:maxUsageLines], fmt.Sprintf("... %d more", len(file.Lines)-maxUsageLines))
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "Upgrade %s from %s to %s.\n\n", file.Path, from, to)
+	fmt.Fprintf(&b, "Usages found:\n%s\n\n", strings.Join(lines, "\n"))
+	if guide != "" {
+		fmt.Fprintf(&b, "Migration guide / changelog:\n%s\n\n", guide)
+	}
+	b.WriteString("Read the file, apply only the changes needed for the new version with write_file, and reply with a one-line summary. If nothing needs to change, say so without writing.")
+	return b.String()
+}
+
+func runMigrateCommand(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var cfg config
+	registerConfigFlags(fs, &cfg)
+	fromSpec := fs.String("from", "", "Current library version, e.g. github.com/foo/bar@v1")
+	toSpec := fs.String("to", "", "Target library version, e.g. github.com/foo/bar@v2")
+	guideSource := fs.String("guide", "", "Path or URL of the changelog or migration guide")
+	batchSize := fs.Int("batch-size", 5, "Files upgraded between test runs")
+	inventoryOnly := fs.Bool("inventory", false, "List usages and exit without changing files")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	cfg = cfg.normalized()
+	root, err := enterWorkspace(cfg)
+	if err == nil {
+		err = requireTrust(cfg, root)
+	}
+	if err == nil {
+		err = checkSandbox(cfg.Sandbox)
+	}
+	if err == nil {
+		err = checkRequestExtras(cfg)
+	}
+	if err == nil {
+		err = checkCommandPolicy(cfg.CommandPolicy)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "codybot migrate: %v\n", err)
+		return 1
+	}
+	if err := cfg.attachTokenSource(); err != nil {
+		fmt.Fprintf(stderr, "codybot migrate: %v\n", err)
+		return 1
+	}
+
+	from, err := parseLibraryVersion(*fromSpec)
+	if err != nil {
+		fmt.Fprintf(stderr, "codybot migrate: --from: %v\n", err)
+		return 2
+	}
+	to, err := parseLibraryVersion(*toSpec)
+	if err != nil {
+		fmt.Fprintf(stderr, "codybot migrate: --to: %v\n", err)
+		return 2
+	}
+
+	files, err := inventoryUsages(".", from.Name)
+	if err != nil {
+		fmt.Fprintf(stderr, "codybot migrate: %v\n", err)
+		return 1
+	}
+	total := 0
+	for _, f := range files {
+		total += len(f.Lines)
+	}
+	fmt.Fprintf(stdout, "Found %d usages of %s in %d files\n", total, from.Name, len(files))
+	for _, f := range files {
+		fmt.Fprintf(stdout, "  %s (%d)\n", f.Path, len(f.Lines))
+	}
+	if *inventoryOnly || len(files) == 0 {
+		return 0
+	}
+
+	ctx := context.Background()
+	guide, guidePath, err := loadMigrationGuide(ctx, cfg, *guideSource, to)
+	if err != nil {
+		fmt.Fprintf(stderr, "warning: %v; continuing without one\n", err)
+	} else {
+		fmt.Fprintf(stdout, "Using migration guide %s\n", guidePath)
+		guide, _ = truncateRunes(guide, maxGuideChars)
+	}
+
+	registry := newToolRegistry(cfg)
+	defer registry.close()
+	journal, err := openEditJournal(journalFileName)
+	if err != nil {
+		fmt.Fprintf(stderr, "warning: edit journal not loaded: %v\n", err)
+	}
+	if report := journal.recoveryReport(); report != "" {
+		fmt.Fprintf(stderr, "codybot migrate: %s\nStart codybot and resolve it with /recover first.\n", report)
+		return 1
+	}
+	system := message{Role: "system", Content: buildSystemPrompt(cfg, "") + "\n\nYou are performing a dependency upgrade one file at a time. Keep edits minimal and behavior-preserving."}
+	size := max(*batchSize, 1)
+	for start := 0; start < len(files); start += size {
+		batch := files[start:min(start+size, len(files))]
+		for i, file := range batch {
+			fmt.Fprintf(stdout, "==> [%d/%d] %s\n", start+i+1, len(files), file.Path)
+			env := &toolEnv{journal: journal, tests: newTestLoop(cfg.TestCommand, cfg.TestAttempts), sandbox: registry.sandbox, turn: start + i + 1, prompt: "migrate " + file.Path}
+			history := []message{system, {Role: "user", Content: migrationPrompt(from, to, guide, file)}}
+			_, answer, err := runAgentLoop(ctx, cfg, registry, env, history, func(ev agentEvent) {
+				if ev.call != nil {
+					fmt.Fprintf(stdout, "    [tool] %s\n", ev.call.summary())
+				}
+			})
+			journal.closeTurn()
+			if err != nil {
+				fmt.Fprintf(stderr, "codybot migrate: %s: %v\n", file.Path, err)
+				return 1
+			}
+			fmt.Fprintf(stdout, "    %s\n", strings.TrimSpace(answer))
+		}
+		if cfg.TestCommand == "" {
+			continue
+		}
+		fmt.Fprintf(stdout, "==> running %s\n", cfg.TestCommand)
+		if output, err := registry.sandbox.run(ctx, cfg.TestCommand); err != nil {
+			fmt.Fprintf(stderr, "tests failed after batch ending at %s: %v\n%s\n", batch[len(batch)-1].Path, err, tailLines(output, 40))
+			fmt.Fprintln(stderr, "Migration stopped; review the changes above before continuing.")
+			return 1
+		}
+	}
+
+	checkpoints, _ := journal.snapshot()
+	changed := map[string]bool{}
+	for _, cp := range checkpoints {
+		if cp.Session != journal.session {
+			continue
+		}
+		for _, path := range cp.files() {
+			changed[path] = true
+		}
+	}
+	fmt.Fprintf(stdout, "Migration finished: %d of %d files changed\n", len(changed), len(files))
+	return 0
+}
+
+func tailLines(text string, n int) string {
+	lines := strings.Split(strings.TrimRight(text, "\n"), "\n")
+	return strings.Join(lines[max(0, len(lines)-n):], "\n")
+}
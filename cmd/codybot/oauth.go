@@ -0,0 +1,392 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+const tokenRefreshSkew = time.Minute
+
+type oauthConfig struct {
+	DeviceURL string
+	TokenURL  string
+	ClientID  string
+	Scope     string
+}
+
+func (o oauthConfig) account() string {
+	return "oauth:" + o.ClientID + "@" + o.TokenURL
+}
+
+func (o oauthConfig) validate() error {
+	var missing []string
+	if o.DeviceURL == "" {
+		missing = append(missing, "--oauth-device-url")
+	}
+	if o.TokenURL == "" {
+		missing = append(missing, "--oauth-token-url")
+	}
+	if o.ClientID == "" {
+		missing = append(missing, "--oauth-client-id")
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("device-code auth needs %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+type oauthToken struct {
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	TokenType    string    `json:"token_type,omitempty"`
+	Expiry       time.Time `json:"expiry,omitempty"`
+}
+
+func (t oauthToken) valid() bool {
+	return t.AccessToken != "" && (t.Expiry.IsZero() || time.Until(t.Expiry) > tokenRefreshSkew)
+}
+
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	Error        string `json:"error"`
+	ErrorDesc    string `json:"error_description"`
+}
+
+type deviceAuthResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+// tokenSource hands out a current access token, refreshing it with the stored
+// refresh token shortly before it expires and saving the result back to the
+// keyring. It is shared by pointer through config copies.
+type tokenSource struct {
+	mu    sync.Mutex
+	oauth oauthConfig
+	cfg   config
+	token oauthToken
+}
+
+func newTokenSource(cfg config) (*tokenSource, error) {
+	oauth := cfg.oauth()
+	if err := oauth.validate(); err != nil {
+		return nil, err
+	}
+	ts := &tokenSource{oauth: oauth, cfg: cfg}
+	if err := ts.load(); err != nil {
+		return nil, err
+	}
+	return ts, nil
+}
+
+func (ts *tokenSource) load() error {
+	raw, err := keyringGet(ts.oauth.account())
+	if errors.Is(err, errSecretNotFound) {
+		return errors.New("not logged in; run codybot auth login")
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(raw), &ts.token)
+}
+
+func (ts *tokenSource) accessToken(ctx context.Context) (string, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.token.valid() {
+		return ts.token.AccessToken, nil
+	}
+	if ts.token.RefreshToken == "" {
+		return "", errors.New("access token expired and no refresh token is stored; run codybot auth login")
+	}
+	token, err := requestToken(ctx, ts.cfg, ts.oauth.TokenURL, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {ts.token.RefreshToken},
+		"client_id":     {ts.oauth.ClientID},
+	})
+	if err != nil {
+		return "", fmt.Errorf("refresh token: %w", err)
+	}
+	if token.RefreshToken == "" {
+		token.RefreshToken = ts.token.RefreshToken
+	}
+	ts.token = token
+	if err := storeToken(ts.oauth, token); err != nil {
+		return "", err
+	}
+	return token.AccessToken, nil
+}
+
+// attachTokenSource switches the config to device-code auth when an OAuth
+// client is configured, loading the stored token from the keyring.
+func (cfg *config) attachTokenSource() error {
+	if cfg.OAuthClientID == "" {
+		return nil
+	}
+	ts, err := newTokenSource(*cfg)
+	if err != nil {
+		return err
+	}
+	cfg.tokens = ts
+	return nil
+}
+
+// authorize sets the Authorization header from the OAuth access token when
+// device-code auth is configured, otherwise from the API key, running
+// --api-key-command first when no key is set.
+func (cfg config) authorize(req *http.Request) error {
+	if cfg.tokens != nil {
+		token, err := cfg.tokens.accessToken(req.Context())
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		return nil
+	}
+	key := cfg.apiKey()
+	if key == "" && cfg.APIKeyCommand != "" {
+		if _, _, err := cfg.refreshCredentials(req.Context()); err != nil {
+			return err
+		}
+		key = cfg.apiKey()
+	}
+	if strings.TrimSpace(key) != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	return nil
+}
+
+func (cfg config) oauth() oauthConfig {
+	return oauthConfig{DeviceURL: cfg.OAuthDeviceURL, TokenURL: cfg.OAuthTokenURL, ClientID: cfg.OAuthClientID, Scope: cfg.OAuthScope}
+}
+
+func storeToken(oauth oauthConfig, token oauthToken) error {
+	data, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	return keyringSet(oauth.account(), string(data))
+}
+
+func postForm(ctx context.Context, cfg config, endpoint string, form url.Values) ([]byte, int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	client, err := httpClientFor(cfg)
+	if err != nil {
+		return nil, 0, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	return body, resp.StatusCode, err
+}
+
+func requestToken(ctx context.Context, cfg config, tokenURL string, form url.Values) (oauthToken, error) {
+	body, status, err := postForm(ctx, cfg, tokenURL, form)
+	if err != nil {
+		return oauthToken{}, err
+	}
+	var resp tokenResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return oauthToken{}, fmt.Errorf("token endpoint returned %d: %s", status, strings.TrimSpace(string(body)))
+	}
+	if resp.Error != "" {
+		return oauthToken{}, &oauthError{Code: resp.Error, Description: resp.ErrorDesc}
+	}
+	if resp.AccessToken == "" {
+		return oauthToken{}, fmt.Errorf("token endpoint returned %d without an access token", status)
+	}
+	token := oauthToken{AccessToken: resp.AccessToken, RefreshToken: resp.RefreshToken, TokenType: resp.TokenType}
+	if resp.ExpiresIn > 0 {
+		token.Expiry = time.Now().Add(time.Duration(resp.ExpiresIn) * time.Second)
+	}
+	return token, nil
+}
+
+type oauthError struct {
+	Code        string
+	Description string
+}
+
+func (e *oauthError) Error() string {
+	if e.Description != "" {
+		return e.Code + ": " + e.Description
+	}
+	return e.Code
+}
+
+// deviceLogin runs the RFC 8628 device authorization grant: it shows the
+// verification URL and user code, opens the browser when possible, and polls
+// the token endpoint until the user approves, denies, or the code expires.
+func deviceLogin(ctx context.Context, cfg config, out io.Writer) (oauthToken, error) {
+	oauth := cfg.oauth()
+	form := url.Values{"client_id": {oauth.ClientID}}
+	if oauth.Scope != "" {
+		form.Set("scope", oauth.Scope)
+	}
+	body, status, err := postForm(ctx, cfg, oauth.DeviceURL, form)
+	if err != nil {
+		return oauthToken{}, err
+	}
+	var device deviceAuthResponse
+	if err := json.Unmarshal(body, &device); err != nil || device.DeviceCode == "" {
+		return oauthToken{}, fmt.Errorf("device authorization returned %d: %s", status, strings.TrimSpace(string(body)))
+	}
+
+	verifyURL := device.VerificationURIComplete
+	if verifyURL == "" {
+		verifyURL = device.VerificationURI
+	}
+	fmt.Fprintf(out, "Open %s and enter code %s\n", device.VerificationURI, device.UserCode)
+	if openBrowser(verifyURL) == nil {
+		fmt.Fprintln(out, "(opened in your browser)")
+	}
+
+	interval := time.Duration(max(device.Interval, 5)) * time.Second
+	deadline := time.Now().Add(time.Duration(max(device.ExpiresIn, 60)) * time.Second)
+	for time.Now().Before(deadline) {
+		select {
+		case <-ctx.Done():
+			return oauthToken{}, ctx.Err()
+		case <-time.After(interval):
+		}
+		token, err := requestToken(ctx, cfg, oauth.TokenURL, url.Values{
+			"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
+			"device_code": {device.DeviceCode},
+			"client_id":   {oauth.ClientID},
+		})
+		var oerr *oauthError
+		switch {
+		case err == nil:
+			return token, nil
+		case errors.As(err, &oerr) && oerr.Code == "authorization_pending":
+			continue
+		case errors.As(err, &oerr) && oerr.Code == "slow_down":
+			interval += 5 * time.Second
+			continue
+		default:
+			return oauthToken{}, err
+		}
+	}
+	return oauthToken{}, errors.New("device code expired before it was approved")
+}
+
+func openBrowser(target string) error {
+	switch runtime.GOOS {
+	case "darwin":
+		return exec.Command("open", target).Start()
+	case "windows":
+		return exec.Command("rundll32", "url.dll,FileProtocolHandler", target).Start()
+	}
+	return exec.Command("xdg-open", target).Start()
+}
+
+func runAuthCommand(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprintln(stderr, "usage: codybot auth <login|logout|status|set-key> [flags]")
+		return 2
+	}
+	fs := flag.NewFlagSet("auth "+args[0], flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var cfg config
+	registerConfigFlags(fs, &cfg)
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	if args[0] == "set-key" {
+		return runSetKey(cfg.normalized(), os.Stdin, stdout, stderr)
+	}
+	oauth := cfg.oauth()
+	if err := oauth.validate(); err != nil {
+		fmt.Fprintf(stderr, "codybot auth: %v\n", err)
+		return 2
+	}
+
+	switch args[0] {
+	case "login":
+		token, err := deviceLogin(context.Background(), cfg, stdout)
+		if err != nil {
+			fmt.Fprintf(stderr, "codybot auth login: %v\n", err)
+			return 1
+		}
+		if err := storeToken(oauth, token); err != nil {
+			fmt.Fprintf(stderr, "codybot auth login: store token: %v\n", err)
+			return 1
+		}
+		fmt.Fprintln(stdout, "Logged in.")
+	case "logout":
+		if err := keyringDelete(oauth.account()); err != nil {
+			fmt.Fprintf(stderr, "codybot auth logout: %v\n", err)
+			return 1
+		}
+		fmt.Fprintln(stdout, "Logged out.")
+	case "status":
+		ts, err := newTokenSource(cfg)
+		if err != nil {
+			fmt.Fprintf(stdout, "%v\n", err)
+			return 1
+		}
+		switch {
+		case ts.token.Expiry.IsZero():
+			fmt.Fprintln(stdout, "Logged in (token does not expire).")
+		case ts.token.valid():
+			fmt.Fprintf(stdout, "Logged in; token expires %s.\n", ts.token.Expiry.Format(time.RFC1123))
+		case ts.token.RefreshToken != "":
+			fmt.Fprintln(stdout, "Token expired; it will be refreshed on the next request.")
+		default:
+			fmt.Fprintln(stdout, "Token expired; run codybot auth login.")
+			return 1
+		}
+	default:
+		fmt.Fprintf(stderr, "unknown auth command %q\n", args[0])
+		return 2
+	}
+	return 0
+}
+
+// runSetKey stores the API key read from stdin in the keyring for the
+// endpoint, where a running codybot finds it after the old key is rejected.
+func runSetKey(cfg config, stdin io.Reader, stdout, stderr io.Writer) int {
+	data, err := io.ReadAll(io.LimitReader(stdin, 64*1024))
+	if err != nil {
+		fmt.Fprintf(stderr, "codybot auth set-key: %v\n", err)
+		return 1
+	}
+	key := strings.TrimSpace(string(data))
+	if key == "" {
+		fmt.Fprintln(stderr, "codybot auth set-key: pipe the key on stdin")
+		return 2
+	}
+	if err := keyringSet(apiKeyAccount(cfg.BaseURL), key); err != nil {
+		fmt.Fprintf(stderr, "codybot auth set-key: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(stdout, "Stored the key for %s.\n", cfg.BaseURL)
+	return 0
+}
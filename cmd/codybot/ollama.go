@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+const (
+	providerOpenAI = "openai"
+	providerOllama = "ollama"
+)
+
+type ollamaMessage struct {
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	ToolCalls []ollamaToolCall `json:"tool_calls,omitempty"`
+	ToolName  string           `json:"tool_name,omitempty"`
+	// Thinking is the reasoning of thinking models, streamed before the
+	// answer.
+	Thinking string `json:"thinking,omitempty"`
+}
+
+type ollamaToolCall struct {
+	Function struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	} `json:"function"`
+}
+
+type ollamaChatRequest struct {
+	Model     string          `json:"model"`
+	Messages  []ollamaMessage `json:"messages"`
+	Stream    bool            `json:"stream"`
+	Tools     []Tool          `json:"tools,omitempty"`
+	KeepAlive string          `json:"keep_alive,omitempty"`
+	Options   map[string]any  `json:"options,omitempty"`
+	Format    json.RawMessage `json:"format,omitempty"`
+}
+
+type ollamaChatResponse struct {
+	Message    ollamaMessage `json:"message"`
+	Done       bool          `json:"done"`
+	DoneReason string        `json:"done_reason"`
+	Error      string        `json:"error"`
+}
+
+type ollamaGenerateRequest struct {
+	Model     string `json:"model"`
+	Prompt    string `json:"prompt"`
+	Stream    bool   `json:"stream"`
+	KeepAlive string `json:"keep_alive,omitempty"`
+}
+
+type ollamaPullProgress struct {
+	Status    string `json:"status"`
+	Completed int64  `json:"completed"`
+	Total     int64  `json:"total"`
+	Error     string `json:"error"`
+}
+
+type pullMsg struct {
+	status string
+	done   bool
+	err    error
+}
+
+// ollamaBaseURL accepts either the native root or the /v1 compatibility URL
+// so users can switch providers without editing --base-url.
+func ollamaBaseURL(cfg config) string {
+	base := strings.TrimRight(cfg.BaseURL, "/")
+	return strings.TrimSuffix(base, "/v1")
+}
+
+func ollamaOptions(cfg config) map[string]any {
+	options := map[string]any{"temperature": cfg.Temperature}
+	if cfg.NumCtx > 0 {
+		options["num_ctx"] = cfg.NumCtx
+	}
+	if cfg.Seed != nil {
+		options["seed"] = *cfg.Seed
+	}
+	return options
+}
+
+func toOllamaMessages(history []message) []ollamaMessage {
+	out := make([]ollamaMessage, 0, len(history))
+	for _, msg := range history {
+		om := ollamaMessage{Role: msg.Role, Content: msg.Content}
+		if msg.Role == "tool" {
+			om.ToolName = msg.Name
+		}
+		for _, call := range msg.ToolCalls {
+			var oc ollamaToolCall
+			oc.Function.Name = call.Function.Name
+			oc.Function.Arguments = json.RawMessage(call.Function.Arguments)
+			if !json.Valid(oc.Function.Arguments) {
+				oc.Function.Arguments = json.RawMessage("{}")
+			}
+			om.ToolCalls = append(om.ToolCalls, oc)
+		}
+		out = append(out, om)
+	}
+	return out
+}
+
+func postOllama(ctx context.Context, cfg config, path string, payload any) (*http.Response, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	client, err := httpClientFor(cfg)
+	if err != nil {
+		return nil, err
+	}
+	for reauthorized := false; ; reauthorized = true {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, ollamaBaseURL(cfg)+path, bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if err := cfg.authorize(req); err != nil {
+			return nil, err
+		}
+		setRequestExtras(req, cfg)
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode < 300 {
+			return resp, nil
+		}
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 8192))
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusUnauthorized && !reauthorized {
+			if _, changed, err := cfg.refreshCredentials(ctx); err == nil && changed {
+				continue
+			}
+		}
+		return nil, &apiError{StatusCode: resp.StatusCode, Status: resp.Status, Body: strings.TrimSpace(string(body))}
+	}
+}
+
+func streamOllamaChat(ctx context.Context, cfg config, history []message, tools []Tool, ch chan<- streamMsg) {
+	resp, err := postOllama(ctx, cfg, "/api/chat", ollamaChatRequest{
+		Model:     cfg.Model,
+		Messages:  toOllamaMessages(history),
+		Stream:    true,
+		Tools:     tools,
+		KeepAlive: cfg.KeepAlive,
+		Options:   ollamaOptions(cfg),
+		Format:    cfg.schema,
+	})
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			err = fmt.Errorf("%w (run /pull to download %s)", err, cfg.Model)
+		}
+		ch <- streamMsg{err: err}
+		return
+	}
+	defer resp.Body.Close()
+
+	var calls []toolCall
+	var thinking reasoningHint
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4<<20)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var chunk ollamaChatResponse
+		if err := json.Unmarshal(line, &chunk); err != nil {
+			continue
+		}
+		if chunk.Error != "" {
+			ch <- streamMsg{err: fmt.Errorf("ollama: %s", chunk.Error)}
+			return
+		}
+		if hint := thinking.add(chunk.Message.Thinking); hint != "" {
+			ch <- streamMsg{hint: hint}
+		}
+		if chunk.Message.Content != "" {
+			ch <- streamMsg{token: chunk.Message.Content}
+		}
+		for i, oc := range chunk.Message.ToolCalls {
+			calls = append(calls, toolCall{
+				ID:   fmt.Sprintf("call_%d_%d", time.Now().UnixNano(), i),
+				Type: "function",
+				Function: toolCallFunction{
+					Name:      oc.Function.Name,
+					Arguments: string(oc.Function.Arguments),
+				},
+			})
+		}
+		if chunk.Done {
+			ch <- streamMsg{done: true, toolCalls: calls, model: cfg.Model}
+			return
+		}
+	}
+	if err := scanner.Err(); err != nil && !errorsIsEOF(err) {
+		ch <- streamMsg{err: err}
+		return
+	}
+	ch <- streamMsg{done: true, toolCalls: calls, model: cfg.Model}
+}
+
+func pullOllamaModel(ctx context.Context, cfg config, name string, ch chan<- pullMsg) {
+	resp, err := postOllama(ctx, cfg, "/api/pull", map[string]any{"model": name, "stream": true})
+	if err != nil {
+		ch <- pullMsg{err: err}
+		return
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var progress ollamaPullProgress
+		if err := json.Unmarshal(scanner.Bytes(), &progress); err != nil {
+			continue
+		}
+		if progress.Error != "" {
+			ch <- pullMsg{err: fmt.Errorf("pull %s: %s", name, progress.Error)}
+			return
+		}
+		status := progress.Status
+		if progress.Total > 0 {
+			status = fmt.Sprintf("%s %d%% (%s/%s)", status, progress.Completed*100/progress.Total, formatBytes(progress.Completed), formatBytes(progress.Total))
+		}
+		if progress.Status == "success" {
+			ch <- pullMsg{status: fmt.Sprintf("Pulled %s", name), done: true}
+			return
+		}
+		ch <- pullMsg{status: fmt.Sprintf("Pulling %s: %s", name, status)}
+	}
+	if err := scanner.Err(); err != nil {
+		ch <- pullMsg{err: err}
+		return
+	}
+	ch <- pullMsg{status: fmt.Sprintf("Pulled %s", name), done: true}
+}
+
+// setOllamaKeepAlive issues an empty /api/generate call, which loads the model
+// and applies keep_alive without producing output. A keep_alive of "0"
+// unloads the model immediately.
+func setOllamaKeepAlive(ctx context.Context, cfg config, keepAlive string) error {
+	resp, err := postOllama(ctx, cfg, "/api/generate", ollamaGenerateRequest{Model: cfg.Model, KeepAlive: keepAlive})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func waitPull(ch <-chan pullMsg) tea.Cmd {
+	return func() tea.Msg {
+		return <-ch
+	}
+}
+
+func (m model) handlePullMsg(msg pullMsg) (tea.Model, tea.Cmd) {
+	if msg.err != nil {
+		m.pullCh = nil
+		m.lastErr = msg.err
+		return m, nil
+	}
+	m.notice = msg.status
+	if msg.done {
+		m.pullCh = nil
+		return m, nil
+	}
+	return m, waitPull(m.pullCh)
+}
+
+func (m *model) cmdPull(args string) tea.Cmd {
+	if m.cfg.Provider != providerOllama {
+		m.notice = "/pull needs --provider ollama"
+		return nil
+	}
+	if m.pullCh != nil {
+		m.notice = "A pull is already running"
+		return nil
+	}
+	name := args
+	if name == "" {
+		name = m.cfg.Model
+	}
+	m.lastErr = nil
+	m.notice = fmt.Sprintf("Pulling %s...", name)
+	m.pullCh = make(chan pullMsg)
+	go pullOllamaModel(context.Background(), m.cfg, name, m.pullCh)
+	return waitPull(m.pullCh)
+}
+
+func (m *model) cmdKeepAlive(args string) tea.Cmd {
+	if m.cfg.Provider != providerOllama {
+		m.notice = "/keep-alive needs --provider ollama"
+		return nil
+	}
+	if args == "" {
+		m.notice = "Usage: /keep-alive <duration|0|-1>  (0 unloads, -1 keeps loaded)"
+		return nil
+	}
+	m.cfg.KeepAlive = args
+	cfg := m.cfg
+	return func() tea.Msg {
+		if err := setOllamaKeepAlive(context.Background(), cfg, args); err != nil {
+			return noticeMsg{err: err}
+		}
+		return noticeMsg{text: fmt.Sprintf("keep_alive for %s set to %s", cfg.Model, args)}
+	}
+}
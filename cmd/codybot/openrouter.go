@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+const (
+	providerOpenRouter       = "openrouter"
+	openRouterBaseURL        = "https://openrouter.ai/api/v1"
+	defaultOpenRouterReferer = "https://github.com/foundev/codybot"
+	defaultOpenRouterTitle   = "codybot"
+)
+
+// openRouterProvider is OpenRouter's per-request routing preferences object.
+type openRouterProvider struct {
+	Order          []string `json:"order,omitempty"`
+	Ignore         []string `json:"ignore,omitempty"`
+	AllowFallbacks *bool    `json:"allow_fallbacks,omitempty"`
+	Sort           string   `json:"sort,omitempty"`
+	DataCollection string   `json:"data_collection,omitempty"`
+}
+
+// openRouterRouting returns the fallback model list and provider preferences
+// to send, or nils when none are configured.
+func (cfg config) openRouterRouting() ([]string, *openRouterProvider) {
+	if cfg.Provider != providerOpenRouter {
+		return nil, nil
+	}
+	var models []string
+	if fallbacks := splitList(cfg.OpenRouterModels); len(fallbacks) > 0 {
+		models = append([]string{cfg.Model}, fallbacks...)
+	}
+	prefs := &openRouterProvider{
+		Order:  splitList(cfg.OpenRouterOrder),
+		Ignore: splitList(cfg.OpenRouterIgnore),
+		Sort:   cfg.OpenRouterSort,
+	}
+	if cfg.OpenRouterNoFallbacks {
+		allow := false
+		prefs.AllowFallbacks = &allow
+	}
+	if cfg.OpenRouterDenyData {
+		prefs.DataCollection = "deny"
+	}
+	if prefs.Order == nil && prefs.Ignore == nil && prefs.Sort == "" && prefs.AllowFallbacks == nil && prefs.DataCollection == "" {
+		prefs = nil
+	}
+	return models, prefs
+}
+
+// setOpenRouterHeaders adds the app attribution headers OpenRouter uses for
+// rankings and rate limiting of unattributed traffic.
+func setOpenRouterHeaders(req *http.Request, cfg config) {
+	if cfg.Provider != providerOpenRouter {
+		return
+	}
+	if cfg.OpenRouterReferer != "" {
+		req.Header.Set("HTTP-Referer", cfg.OpenRouterReferer)
+	}
+	if cfg.OpenRouterTitle != "" {
+		req.Header.Set("X-Title", cfg.OpenRouterTitle)
+	}
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+const (
+	maxSessionsListed = 20
+	maxSessionMatches = 20
+	maxShownMessage   = 2000
+)
+
+// endpointUnreachable reports whether err means the model cannot be reached
+// at all, as opposed to rejecting this one request: transport failures and
+// server errors, but not rate limits.
+func endpointUnreachable(err error) bool {
+	if !shouldFailover(err) {
+		return false
+	}
+	var apiErr *apiError
+	return !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusTooManyRequests
+}
+
+// markEndpointDown tells the user, once per outage, what still works without
+// the model.
+func (m *model) markEndpointDown() {
+	if m.endpointDown {
+		return
+	}
+	m.endpointDown = true
+	m.appendNote(fmt.Sprintf("%s is unreachable. These work without it:\n"+
+		"  /sessions         browse and search saved sessions\n"+
+		"  /export [path]    save this conversation as markdown\n"+
+		"  /timeline         review diffs and restore checkpoints\n"+
+		"  /find <text>      search the transcript\n"+
+		"  /compact          falls back to a local summary\n"+
+		"Send again once it is back.", m.cfg.Model))
+}
+
+// localSummary stands in for the model's summary when /compact cannot reach
+// it: the task, later requests, files written, and the last answer.
+func localSummary(history []message) string {
+	var b strings.Builder
+	var requests []string
+	written := map[string]bool{}
+	var paths []string
+	last := ""
+	for _, msg := range history {
+		switch msg.Role {
+		case "user":
+			requests = append(requests, msg.Content)
+		case "assistant":
+			if strings.TrimSpace(msg.Content) != "" {
+				last = msg.Content
+			}
+			for _, call := range msg.ToolCalls {
+				if path := writtenPath(call); path != "" && !written[path] {
+					written[path] = true
+					paths = append(paths, path)
+				}
+			}
+		}
+	}
+	if len(requests) > 0 {
+		task, _ := truncateRunes(strings.TrimSpace(requests[0]), 1000)
+		b.WriteString("Task: " + task + "\n")
+	}
+	if len(requests) > 1 {
+		b.WriteString("Later requests:\n")
+		for _, request := range requests[1:] {
+			first, _, _ := strings.Cut(strings.TrimSpace(request), "\n")
+			first, _ = truncateRunes(first, 160)
+			b.WriteString("- " + first + "\n")
+		}
+	}
+	if len(paths) > 0 {
+		b.WriteString("Files written: " + strings.Join(paths, ", ") + "\n")
+	}
+	if last != "" {
+		last, _ = truncateRunes(strings.TrimSpace(last), 2000)
+		b.WriteString("Last answer:\n" + last + "\n")
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// exportMarkdown renders the conversation for /export, leaving out the
+// system prompt.
+func exportMarkdown(history []message) string {
+	var b strings.Builder
+	for _, msg := range history {
+		switch msg.Role {
+		case "user":
+			b.WriteString("## User\n\n" + strings.TrimSpace(msg.Content) + "\n\n")
+		case "assistant":
+			b.WriteString("## Assistant\n\n")
+			if content := strings.TrimSpace(msg.Content); content != "" {
+				b.WriteString(content + "\n\n")
+			}
+			for _, call := range msg.ToolCalls {
+				b.WriteString("- tool call: `" + call.summary() + "`\n")
+			}
+			if len(msg.ToolCalls) > 0 {
+				b.WriteString("\n")
+			}
+		case "tool":
+			b.WriteString("### Result of " + msg.Name + "\n\n```\n" + strings.TrimRight(msg.Content, "\n") + "\n```\n\n")
+		}
+	}
+	return b.String()
+}
+
+func (m *model) cmdExport(args string) tea.Cmd {
+	if len(m.history) <= 1 {
+		m.notice = "Nothing to export yet"
+		return nil
+	}
+	content, what := exportMarkdown(m.history), fmt.Sprintf("%d messages", len(m.history)-1)
+	if strings.HasPrefix(args, "#") {
+		span, rest, _ := strings.Cut(args, " ")
+		blocks, err := m.blockRange(span)
+		if err != nil {
+			m.lastErr = err
+			return nil
+		}
+		content = exportBlocks(blocks)
+		what = fmt.Sprintf("messages #%d..#%d", blocks[0].id, blocks[len(blocks)-1].id)
+		args = strings.TrimSpace(rest)
+	}
+	path := filepath.Clean(args)
+	if args == "" {
+		path = m.exportName(".md")
+	} else if info, err := os.Stat(path); err == nil && info.IsDir() {
+		path = filepath.Join(path, m.exportName(".md"))
+	}
+	if err := writeFileAtomic(path, []byte(content), 0o644); err != nil {
+		m.lastErr = fmt.Errorf("export: %w", err)
+		return nil
+	}
+	m.lastErr = nil
+	m.notice = fmt.Sprintf("Exported %s to %s", what, path)
+	return nil
+}
+
+func firstPrompt(messages []message) string {
+	for _, msg := range messages {
+		if msg.Role == "user" {
+			first, _, _ := strings.Cut(strings.TrimSpace(msg.Content), "\n")
+			first, _ = truncateRunes(first, 60)
+			return first
+		}
+	}
+	return ""
+}
+
+// formatSession renders a saved session's prompts and answers for reading.
+func formatSession(session sessionFile) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Session %s (%s, %s): %s\n", session.ID, session.Model, session.CreatedAt.Format("2006-01-02 15:04"), sessionLabel(session))
+	if session.ForkedFrom != "" {
+		fmt.Fprintf(&b, "Forked from %s\n", session.ForkedFrom)
+	}
+	if session.ImportedFrom != "" {
+		fmt.Fprintf(&b, "Imported from %s\n", session.ImportedFrom)
+	}
+	for _, msg := range session.Messages {
+		if msg.Role != "user" && msg.Role != "assistant" || strings.TrimSpace(msg.Content) == "" {
+			continue
+		}
+		content, cut := truncateRunes(strings.TrimSpace(msg.Content), maxShownMessage)
+		if cut {
+			content += " …"
+		}
+		fmt.Fprintf(&b, "\n[%s]\n%s\n", msg.Role, content)
+	}
+	return b.String()
+}
+
+func (m *model) cmdSessions(args string) tea.Cmd {
+	sub, rest, _ := strings.Cut(args, " ")
+	rest = strings.TrimSpace(rest)
+	paths, err := listSessionFiles()
+	if err != nil {
+		m.lastErr = err
+		return nil
+	}
+	// Session IDs are timestamps, so the newest sort last.
+	for i, j := 0, len(paths)-1; i < j; i, j = i+1, j-1 {
+		paths[i], paths[j] = paths[j], paths[i]
+	}
+	switch sub {
+	case "", "list":
+		if len(paths) == 0 {
+			m.notice = "No saved sessions in " + sessionsDir()
+			return nil
+		}
+		var b strings.Builder
+		b.WriteString("Saved sessions, newest first:\n")
+		for _, path := range paths[:min(len(paths), maxSessionsListed)] {
+			session, err := loadSession(path)
+			if err != nil {
+				fmt.Fprintf(&b, "  %-16s (unreadable: %v)\n", filepath.Base(path), err)
+				continue
+			}
+			fmt.Fprintf(&b, "  %-16s %-20s %3d msgs  %s\n", session.ID, session.Model, len(session.Messages)-1, sessionLabel(session))
+		}
+		if len(paths) > maxSessionsListed {
+			fmt.Fprintf(&b, "  … %d older\n", len(paths)-maxSessionsListed)
+		}
+		b.WriteString("/sessions show <id> prints one; /sessions search <text> searches them all.")
+		m.appendNote(b.String())
+	case "search":
+		if rest == "" {
+			m.notice = "Usage: /sessions search <text>"
+			return nil
+		}
+		needle := strings.ToLower(rest)
+		var b strings.Builder
+		matches := 0
+		for _, path := range paths {
+			session, err := loadSession(path)
+			if err != nil {
+				continue
+			}
+			if strings.Contains(strings.ToLower(session.Title), needle) && matches < maxSessionMatches {
+				fmt.Fprintf(&b, "  %-16s %-9s %s\n", session.ID, "title", session.Title)
+				matches++
+			}
+			for _, msg := range session.Messages {
+				if msg.Role == "system" || matches >= maxSessionMatches {
+					continue
+				}
+				content := strings.ToLower(msg.Content)
+				at := strings.Index(content, needle)
+				if at < 0 {
+					continue
+				}
+				snippet := msg.Content[max(at-40, 0):min(at+len(needle)+60, len(msg.Content))]
+				fmt.Fprintf(&b, "  %-16s %-9s …%s…\n", session.ID, msg.Role, strings.Join(strings.Fields(strings.ToValidUTF8(snippet, "")), " "))
+				matches++
+			}
+		}
+		if matches == 0 {
+			m.notice = fmt.Sprintf("No saved session mentions %q", rest)
+			return nil
+		}
+		m.appendNote(fmt.Sprintf("Sessions mentioning %q:\n%s", rest, strings.TrimRight(b.String(), "\n")))
+	case "show":
+		if rest == "" {
+			m.notice = "Usage: /sessions show <id>"
+			return nil
+		}
+		session, err := loadSession(filepath.Join(sessionsDir(), filepath.Base(rest)+".json"))
+		if errors.Is(err, os.ErrNotExist) {
+			err = fmt.Errorf("no saved session %q; /sessions lists them", rest)
+		}
+		if err != nil {
+			m.lastErr = err
+			return nil
+		}
+		m.appendNote(formatSession(session))
+	default:
+		m.notice = "Usage: /sessions [list|search <text>|show <id>]"
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+type paletteState struct {
+	active bool
+	query  string
+	cursor int
+}
+
+// paletteAction is one entry in the Ctrl+K palette. Slash commands are listed
+// automatically, so new commands show up without touching this file.
+type paletteAction struct {
+	title string
+	hint  string
+	run   func(m *model) tea.Cmd
+}
+
+func paletteActions() []paletteAction {
+	actions := []paletteAction{
+		{title: "Clear conversation", hint: "Ctrl+L", run: func(m *model) tea.Cmd {
+			m.clearConversation()
+			return nil
+		}},
+		{title: "Find in transcript", hint: "Ctrl+F", run: func(m *model) tea.Cmd { return m.cmdFind("") }},
+		{title: "Quit", hint: "Ctrl+C", run: func(*model) tea.Cmd { return tea.Quit }},
+	}
+	for _, cmd := range slashCommands() {
+		actions = append(actions, paletteAction{title: cmd.help, hint: cmd.usage, run: commandAction(cmd)})
+	}
+	return actions
+}
+
+// commandAction runs a command that needs no arguments straight away and
+// otherwise pre-fills the input so the user can finish typing them.
+func commandAction(cmd slashCommand) func(m *model) tea.Cmd {
+	return func(m *model) tea.Cmd {
+		if strings.Contains(cmd.usage, "<") {
+			m.input.SetValue("/" + cmd.name + " ")
+			m.input.CursorEnd()
+			return nil
+		}
+		return cmd.run(m, "")
+	}
+}
+
+// fuzzyScore reports whether every rune of query appears in text in order,
+// scoring consecutive runs and word starts higher.
+func fuzzyScore(query, text string) (int, bool) {
+	if query == "" {
+		return 0, true
+	}
+	q := []rune(strings.ToLower(query))
+	t := []rune(strings.ToLower(text))
+	score, qi, prev := 0, 0, -2
+	for ti := 0; ti < len(t) && qi < len(q); ti++ {
+		if t[ti] != q[qi] {
+			continue
+		}
+		score++
+		if ti == prev+1 {
+			score += 3
+		}
+		if ti == 0 || !unicode.IsLetter(t[ti-1]) {
+			score += 2
+		}
+		prev = ti
+		qi++
+	}
+	return score - len(t)/16, qi == len(q)
+}
+
+func (p paletteState) matches() []paletteAction {
+	type scored struct {
+		action paletteAction
+		score  int
+	}
+	var found []scored
+	for _, action := range paletteActions() {
+		if score, ok := fuzzyScore(p.query, action.title+" "+action.hint); ok {
+			found = append(found, scored{action, score})
+		}
+	}
+	sort.SliceStable(found, func(i, j int) bool { return found[i].score > found[j].score })
+	actions := make([]paletteAction, len(found))
+	for i, f := range found {
+		actions[i] = f.action
+	}
+	return actions
+}
+
+func (m *model) openPalette() {
+	m.closeFind()
+	m.palette = paletteState{active: true}
+	m.input.Blur()
+}
+
+func (m *model) closePalette() {
+	m.palette = paletteState{}
+	m.input.Focus()
+}
+
+func (m model) updatePalette(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.Type {
+	case tea.KeyEsc, tea.KeyCtrlK, tea.KeyCtrlC:
+		m.closePalette()
+		return m, nil
+	case tea.KeyEnter:
+		matches, cursor := m.palette.matches(), m.palette.cursor
+		m.closePalette()
+		if len(matches) == 0 {
+			return m, nil
+		}
+		cmd := matches[min(cursor, len(matches)-1)].run(&m)
+		return m, cmd
+	case tea.KeyUp, tea.KeyCtrlP:
+		m.palette.cursor = max(m.palette.cursor-1, 0)
+	case tea.KeyDown, tea.KeyCtrlN, tea.KeyTab:
+		m.palette.cursor = min(m.palette.cursor+1, max(len(m.palette.matches())-1, 0))
+	case tea.KeyBackspace:
+		if r := []rune(m.palette.query); len(r) > 0 {
+			m.palette.query = string(r[:len(r)-1])
+			m.palette.cursor = 0
+		}
+	case tea.KeyRunes, tea.KeySpace:
+		m.palette.query += string(msg.Runes)
+		m.palette.cursor = 0
+	}
+	return m, nil
+}
+
+func (m model) viewPalette(border lipgloss.Style) string {
+	matches := m.palette.matches()
+	rows := max(m.viewport.Height-2, 1)
+	start := max(m.palette.cursor-rows+1, 0)
+	lines := []string{"> " + m.palette.query + "█", ""}
+	if len(matches) == 0 {
+		lines = append(lines, subtleStyle.Render("No matching actions"))
+	}
+	for i := start; i < len(matches) && i < start+rows; i++ {
+		title, _ := truncateRunes(matches[i].title, max(m.viewport.Width-30, 10))
+		line := "  " + title
+		if i == m.palette.cursor {
+			line = timelineSelectedStyle.Render("▸ " + title)
+		}
+		lines = append(lines, line+"  "+subtleStyle.Render(matches[i].hint))
+	}
+	for len(lines) < m.viewport.Height {
+		lines = append(lines, "")
+	}
+	return border.Width(m.width).Render(strings.Join(lines[:m.viewport.Height], "\n"))
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+const (
+	multiplexerAuto   = "auto"
+	multiplexerTmux   = "tmux"
+	multiplexerZellij = "zellij"
+
+	defaultPaneViewer = "less -R"
+	paneDir           = ".codybot/panes"
+)
+
+// detectMultiplexer resolves --multiplexer auto from the variables tmux and
+// zellij set inside their sessions.
+func detectMultiplexer(setting string) (string, error) {
+	switch setting {
+	case multiplexerTmux, multiplexerZellij:
+		return setting, nil
+	case "", multiplexerAuto:
+		switch {
+		case os.Getenv("TMUX") != "":
+			return multiplexerTmux, nil
+		case os.Getenv("ZELLIJ") != "":
+			return multiplexerZellij, nil
+		}
+		return "", errors.New("not running inside tmux or zellij (set --multiplexer to choose one)")
+	}
+	return "", fmt.Errorf("unknown multiplexer %q; use auto, tmux, or zellij", setting)
+}
+
+// paneCommand builds the CLI call that opens a new pane running command,
+// split to the right or below the current one.
+func paneCommand(cfg config, title, command string) (*exec.Cmd, error) {
+	mux, err := detectMultiplexer(cfg.Multiplexer)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	down := cfg.PaneDirection == "down"
+	if mux == multiplexerTmux {
+		split := "-h"
+		if down {
+			split = "-v"
+		}
+		return exec.Command("tmux", "split-window", split, "-c", dir, command), nil
+	}
+	direction := "right"
+	if down {
+		direction = "down"
+	}
+	return exec.Command("zellij", "run", "--close-on-exit", "--direction", direction, "--cwd", dir, "--name", title, "--", "sh", "-c", command), nil
+}
+
+// openPane shows path in a new pane with the configured viewer.
+func (m *model) openPane(title, path string) {
+	viewer := m.cfg.PaneViewer
+	if viewer == "" {
+		viewer = defaultPaneViewer
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		m.lastErr = err
+		return
+	}
+	cmd, err := paneCommand(m.cfg, title, viewer+" "+shellQuote(abs))
+	if err != nil {
+		m.lastErr = fmt.Errorf("pane: %w", err)
+		return
+	}
+	if output, err := cmd.CombinedOutput(); err != nil {
+		m.lastErr = fmt.Errorf("pane: %s: %w: %s", cmd.Args[0], err, strings.TrimSpace(string(output)))
+		return
+	}
+	m.lastErr = nil
+	m.notice = fmt.Sprintf("Opened %s in a %s pane", title, cmd.Args[0])
+}
+
+// writePaneFile saves an artifact that only exists in memory so the viewer
+// in the other pane can read it.
+func writePaneFile(name, content string) (string, error) {
+	path := filepath.Join(paneDir, name)
+	if err := writeFileAtomic(path, []byte(content), 0o600); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// checkpointPatch concatenates the patches of checkpoint id, or of the latest
+// applied checkpoint when id is 0.
+func (j *editJournal) checkpointPatch(id int) (*checkpoint, string) {
+	checkpoints, applied := j.snapshot()
+	for i := len(checkpoints) - 1; i >= 0; i-- {
+		cp := checkpoints[i]
+		if (id == 0 && i < applied) || (id != 0 && cp.ID == id) {
+			var b strings.Builder
+			for _, change := range cp.Changes {
+				b.WriteString(change.Patch)
+			}
+			return &cp, b.String()
+		}
+	}
+	return nil, ""
+}
+
+func (m *model) cmdPane(args string) tea.Cmd {
+	kind, rest, _ := strings.Cut(args, " ")
+	rest = strings.TrimSpace(rest)
+	switch kind {
+	case "diff":
+		id := 0
+		if rest != "" {
+			n, err := strconv.Atoi(strings.TrimPrefix(rest, "#"))
+			if err != nil {
+				m.notice = "Usage: /pane diff [checkpoint]"
+				return nil
+			}
+			id = n
+		}
+		cp, patch := m.journal.checkpointPatch(id)
+		if cp == nil || patch == "" {
+			m.notice = "No agent edits to show • /timeline lists the checkpoints"
+			return nil
+		}
+		path, err := writePaneFile(fmt.Sprintf("checkpoint-%d.diff", cp.ID), patch)
+		if err != nil {
+			m.lastErr = err
+			return nil
+		}
+		m.openPane(fmt.Sprintf("checkpoint #%d", cp.ID), path)
+	case "file":
+		if rest == "" {
+			m.notice = "Usage: /pane file <path>"
+			return nil
+		}
+		path, err := resolveWorkspacePath(rest)
+		if err != nil {
+			m.lastErr = err
+			return nil
+		}
+		if _, err := os.Stat(path); err != nil {
+			m.lastErr = err
+			return nil
+		}
+		m.openPane(rest, path)
+	case "tests":
+		output := m.tests.lastOutput()
+		if output == "" {
+			m.notice = "No test run yet this session"
+			return nil
+		}
+		path, err := writePaneFile("tests.txt", output)
+		if err != nil {
+			m.lastErr = err
+			return nil
+		}
+		m.openPane("test output", path)
+	default:
+		m.notice = "Usage: /pane diff [checkpoint] | file <path> | tests"
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+const maxPinBytes = 100_000
+
+// pinnedFile is a file the user keeps in every request. current is what the
+// latest request carried and previous what the turn before it carried, so the
+// model is shown what changed in between.
+type pinnedFile struct {
+	path     string
+	current  []byte
+	previous []byte
+	missing  bool
+	// sent and hasPrevious are unset until a request and a later turn.
+	sent            bool
+	hasPrevious     bool
+	previousExisted bool
+}
+
+// pinSet holds the pinned files. It lives behind a pointer so the copies of
+// the model Bubble Tea passes around share it.
+type pinSet struct {
+	files []*pinnedFile
+}
+
+func (p *pinSet) find(path string) int {
+	for i, f := range p.files {
+		if f.path == path {
+			return i
+		}
+	}
+	return -1
+}
+
+// nextTurn makes what the last turn sent the baseline for the next diff.
+func (p *pinSet) nextTurn() {
+	for _, f := range p.files {
+		if f.sent {
+			f.previous, f.previousExisted, f.hasPrevious = f.current, !f.missing, true
+		}
+	}
+}
+
+// refresh rereads every pinned file from disk.
+func (p *pinSet) refresh() {
+	for _, f := range p.files {
+		data, err := os.ReadFile(f.path)
+		f.missing = err != nil
+		f.current = data
+		f.sent = true
+	}
+}
+
+// context renders the pinned files for the system message: each file's
+// current content, preceded by a diff when it changed since the previous
+// turn.
+func (p *pinSet) context() string {
+	if len(p.files) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("\n\nPinned files. The user keeps these in view; they are reread from disk for every request, so this is their current content:\n")
+	for _, f := range p.files {
+		if f.missing {
+			fmt.Fprintf(&b, "\n### %s\n(the file no longer exists)\n", f.path)
+			continue
+		}
+		status := ""
+		if f.hasPrevious && !bytes.Equal(f.previous, f.current) {
+			status = " (changed since the previous turn)"
+		}
+		fmt.Fprintf(&b, "\n### %s%s\n", f.path, status)
+		if status != "" {
+			b.WriteString("```diff\n" + unifiedDiff(f.path, f.previous, f.current, f.previousExisted) + "```\n")
+		}
+		b.WriteString("```\n" + string(f.current))
+		if !bytes.HasSuffix(f.current, []byte("\n")) {
+			b.WriteString("\n")
+		}
+		b.WriteString("```\n")
+	}
+	return b.String()
+}
+
+// withPins returns the history to send, with the pinned files refreshed and
+// appended to a copy of the system message, which context eviction always
+// keeps.
+func (m *model) withPins(history []message) []message {
+	if m.pins == nil || len(m.pins.files) == 0 || len(history) == 0 || history[0].Role != "system" {
+		return history
+	}
+	m.pins.refresh()
+	out := append([]message(nil), history...)
+	out[0].Content += m.pins.context()
+	return out
+}
+
+func (m *model) cmdPin(args string) tea.Cmd {
+	if args == "" {
+		return m.cmdPins("")
+	}
+	path, err := resolveWorkspacePath(args)
+	if err != nil {
+		m.lastErr = err
+		return nil
+	}
+	if m.pins.find(path) >= 0 {
+		m.notice = path + " is already pinned"
+		return nil
+	}
+	info, err := os.Stat(path)
+	switch {
+	case err != nil:
+		m.lastErr = err
+		return nil
+	case info.IsDir():
+		m.lastErr = fmt.Errorf("%s is a directory; pin its files one at a time", path)
+		return nil
+	case info.Size() > maxPinBytes:
+		m.lastErr = fmt.Errorf("%s is %s; pinned files are limited to %s because they are sent with every request", path, formatBytes(info.Size()), formatBytes(maxPinBytes))
+		return nil
+	}
+	m.pins.files = append(m.pins.files, &pinnedFile{path: path})
+	m.lastErr = nil
+	m.notice = fmt.Sprintf("Pinned %s (~%s tokens per request)", path, formatCount(int(info.Size())/4))
+	return nil
+}
+
+func (m *model) cmdUnpin(args string) tea.Cmd {
+	switch args {
+	case "":
+		m.notice = "Usage: /unpin <path>|all"
+		return nil
+	case "all":
+		n := len(m.pins.files)
+		m.pins.files = nil
+		m.notice = fmt.Sprintf("Unpinned %d files", n)
+		return nil
+	}
+	path, err := resolveWorkspacePath(args)
+	if err != nil {
+		m.lastErr = err
+		return nil
+	}
+	i := m.pins.find(path)
+	if i < 0 {
+		m.notice = path + " is not pinned"
+		return nil
+	}
+	m.pins.files = append(m.pins.files[:i], m.pins.files[i+1:]...)
+	m.notice = "Unpinned " + path
+	return nil
+}
+
+func (m *model) cmdPins(string) tea.Cmd {
+	if len(m.pins.files) == 0 {
+		m.notice = "No pinned files; /pin <path> keeps one in every request"
+		return nil
+	}
+	var b strings.Builder
+	b.WriteString("Pinned files, sent with every request:\n")
+	total := 0
+	for _, f := range m.pins.files {
+		data, err := os.ReadFile(f.path)
+		if errors.Is(err, os.ErrNotExist) {
+			fmt.Fprintf(&b, "  %-40s deleted\n", f.path)
+			continue
+		}
+		tokens := estimateTokens(string(data))
+		total += tokens
+		state := ""
+		if f.sent && !bytes.Equal(data, f.current) {
+			state = "  changed since the last request"
+		}
+		fmt.Fprintf(&b, "  %-40s %8s  ~%s tok%s\n", f.path, formatBytes(int64(len(data))), formatCount(tokens), state)
+	}
+	fmt.Fprintf(&b, "~%s tokens in total • /unpin <path>|all removes them.", formatCount(total))
+	m.appendNote(b.String())
+	return nil
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+const refactorPreviewPrompt = `Refactor request: %s
+
+You are in preview mode. Make the complete change across the repository with write_file, writing the full new content of every file you touch; read each file before changing it. Nothing is applied yet: your writes are staged for the user to review as one patch series, and read_file shows your staged version. Do not stop after a sample: cover every occurrence. Finish with a short summary of what changed and why.`
+
+type stagedFile struct {
+	path    string
+	before  []byte
+	existed bool
+	after   []byte
+}
+
+// stagedEdits collects the writes of a /refactor-preview turn instead of
+// applying them. Reads of a staged path see the staged content, so the agent
+// can build on its own edits.
+type stagedEdits struct {
+	mu          sync.Mutex
+	description string
+	files       []*stagedFile
+	// running is set while the agent is still producing edits.
+	running bool
+	// fromAnswer marks edits taken from an answer's diffs and fenced file
+	// blocks rather than a /refactor-preview turn.
+	fromAnswer bool
+}
+
+func (s *stagedEdits) title() string {
+	if s.fromAnswer {
+		return "Edits from the answer"
+	}
+	return "Refactor preview"
+}
+
+func (s *stagedEdits) write(path string, content []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, f := range s.files {
+		if f.path == path {
+			f.after = content
+			return nil
+		}
+	}
+	before, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	s.files = append(s.files, &stagedFile{path: path, before: before, existed: err == nil, after: content})
+	return nil
+}
+
+func (s *stagedEdits) read(path string) ([]byte, bool) {
+	if s == nil {
+		return nil, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, f := range s.files {
+		if f.path == path {
+			return f.after, true
+		}
+	}
+	return nil, false
+}
+
+// changed returns the staged files whose content differs from the original.
+func (s *stagedEdits) changed() []*stagedFile {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var files []*stagedFile
+	for _, f := range s.files {
+		if !f.existed || !bytes.Equal(f.before, f.after) {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+// apply writes every staged file through the journal as one checkpoint. It
+// refuses when any file changed on disk after it was staged.
+func (s *stagedEdits) apply(journal *editJournal, turn int, prompt string) error {
+	files := s.changed()
+	var conflicts []string
+	for _, f := range files {
+		current, err := os.ReadFile(f.path)
+		if (err == nil) != f.existed || !bytes.Equal(current, f.before) {
+			conflicts = append(conflicts, f.path)
+		}
+	}
+	if len(conflicts) > 0 {
+		return fmt.Errorf("changed on disk since the preview: %s; discard with x and preview again", strings.Join(conflicts, ", "))
+	}
+	for _, f := range files {
+		if err := journal.writeFile(turn, prompt, f.path, f.after); err != nil {
+			return err
+		}
+	}
+	journal.closeTurn()
+	return nil
+}
+
+func (m *model) cmdRefactorPreview(args string) tea.Cmd {
+	if args == "" {
+		if m.preview != nil && !m.preview.running && len(m.preview.changed()) > 0 {
+			m.state = statePreview
+			return nil
+		}
+		m.notice = "Usage: /refactor-preview <description of the change>"
+		return nil
+	}
+	if m.streaming {
+		m.notice = "Wait for the current response to finish before starting a preview"
+		return nil
+	}
+	if m.tools == nil {
+		m.notice = "Tools are disabled, so the agent cannot propose edits"
+		return nil
+	}
+	if m.cfg.ReadOnly {
+		m.notice = "--read-only leaves out write_file, so the agent cannot propose edits"
+		return nil
+	}
+	m.preview = &stagedEdits{description: args, running: true}
+	return m.send("/refactor-preview "+args, fmt.Sprintf(refactorPreviewPrompt, args))
+}
+
+// stagingFor returns where write_file should go for the running turn: the
+// preview being built, or nil to write to disk.
+func (m *model) stagingFor() *stagedEdits {
+	if m.preview != nil && m.preview.running {
+		return m.preview
+	}
+	return nil
+}
+
+// finishPreview runs when a preview turn ends and opens the review screen if
+// the agent staged any changes.
+func (m *model) finishPreview() {
+	if m.preview == nil || !m.preview.running {
+		return
+	}
+	m.preview.running = false
+	if len(m.preview.changed()) == 0 {
+		m.preview = nil
+		m.notice = "The agent proposed no edits"
+		return
+	}
+	m.state = statePreview
+	m.previewCursor, m.previewScroll = 0, 0
+}
+
+func (m model) updatePreview(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	files := m.preview.changed()
+	page := max(m.height/2, 1)
+	switch msg.String() {
+	case "up", "k":
+		m.previewCursor, m.previewScroll = max(m.previewCursor-1, 0), 0
+	case "down", "j", "tab":
+		m.previewCursor, m.previewScroll = min(m.previewCursor+1, len(files)-1), 0
+	case "pgdown", "ctrl+d", " ":
+		if m.previewCursor < len(files) {
+			f := files[m.previewCursor]
+			lines := strings.Count(unifiedDiff(f.path, f.before, f.after, f.existed), "\n")
+			m.previewScroll = min(m.previewScroll+page, lines)
+		}
+	case "pgup", "ctrl+u", "b":
+		m.previewScroll = max(m.previewScroll-page, 0)
+	case "a":
+		m.turn = m.journal.nextTurn()
+		prompt := "refactor: " + m.preview.description
+		if m.preview.fromAnswer {
+			prompt = "apply: " + m.preview.description
+		}
+		if err := m.preview.apply(m.journal, m.turn, prompt); err != nil {
+			m.lastErr = err
+			return m, nil
+		}
+		added, removed := previewStats(files)
+		m.appendNote(fmt.Sprintf("Applied %s %q: %d files, +%d/-%d. /undo reverts it.", strings.ToLower(m.preview.title()), m.preview.description, len(files), added, removed))
+		m.preview = nil
+		m.lastErr = nil
+		m.notice = fmt.Sprintf("Applied %d files", len(files))
+		m.state = stateChat
+	case "x":
+		m.notice = "Discarded the " + strings.ToLower(m.preview.title())
+		m.preview = nil
+		m.lastErr = nil
+		m.state = stateChat
+	case "esc", "q":
+		m.lastErr = nil
+		m.notice = "Preview kept; /refactor-preview reopens it"
+		if m.preview.fromAnswer {
+			m.preview, m.answerEdits = nil, m.preview
+			m.notice = "Edits kept; Ctrl+Y reopens them"
+		}
+		m.state = stateChat
+	case "ctrl+c":
+		return m, tea.Quit
+	}
+	return m, nil
+}
+
+func previewStats(files []*stagedFile) (int, int) {
+	added, removed := 0, 0
+	for _, f := range files {
+		a, r := lineDelta(f.before, f.after)
+		added += a
+		removed += r
+	}
+	return added, removed
+}
+
+func (m model) viewPreview() string {
+	files := m.preview.changed()
+	added, removed := previewStats(files)
+	var b strings.Builder
+	b.WriteString(m.fitLine(headerStyle.Render(m.preview.title() + ": " + m.preview.description)))
+	b.WriteString("\n")
+	b.WriteString(subtleStyle.Render(fmt.Sprintf("%d files • +%d/-%d • nothing is applied until you press a", len(files), added, removed)))
+	b.WriteString("\n\n")
+	for i, f := range files {
+		a, r := lineDelta(f.before, f.after)
+		label := "modified"
+		if !f.existed {
+			label = "created "
+		}
+		line := fmt.Sprintf("  %s %s (+%d/-%d)", label, f.path, a, r)
+		if i == m.previewCursor {
+			line = timelineSelectedStyle.Render("> " + line[2:])
+		}
+		b.WriteString(m.fitLine(line) + "\n")
+	}
+	b.WriteString("\n")
+
+	footer := "\n" + subtleStyle.Render("↑/↓ file • PgUp/PgDn scroll diff • a apply all • x discard • Esc back to chat")
+	if m.lastErr != nil {
+		footer = "\n" + errorStyle.Render("Error: "+m.lastErr.Error()) + footer
+	}
+	if m.previewCursor < len(files) {
+		f := files[m.previewCursor]
+		patch := renderPatch(unifiedDiff(f.path, f.before, f.after, f.existed), "  ")
+		lines := strings.Split(strings.TrimRight(patch, "\n"), "\n")
+		room := m.height - strings.Count(b.String(), "\n") - strings.Count(footer, "\n") - 1
+		if room > 0 {
+			scroll := min(m.previewScroll, max(len(lines)-room, 0))
+			end := min(scroll+room, len(lines))
+			for _, line := range lines[scroll:end] {
+				b.WriteString(m.fitLine(line) + "\n")
+			}
+			if end < len(lines) {
+				b.WriteString(subtleStyle.Render(fmt.Sprintf("  … %d more lines", len(lines)-end)))
+			}
+		}
+	}
+	b.WriteString(footer)
+	return b.String()
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+type profile struct {
+	Name        string
+	Path        string
+	Content     string
+	Model       string
+	Temperature float64
+	HasTemp     bool
+	// CheckModel overrides --check-model; "off" disables the self-check.
+	CheckModel string
+	// ReplyLanguage and CommentLanguage override the language flags.
+	ReplyLanguage   string
+	CommentLanguage string
+}
+
+func profilesDir(agentPath string) string {
+	return filepath.Join(filepath.Dir(agentPath), "agents")
+}
+
+func listProfiles(agentPath string) ([]string, error) {
+	entries, err := os.ReadDir(profilesDir(agentPath))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.EqualFold(filepath.Ext(entry.Name()), ".md") {
+			names = append(names, strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name())))
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func loadProfile(agentPath, name string) (*profile, error) {
+	path := filepath.Join(profilesDir(agentPath), name+".md")
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("no profile %q (expected %s)", name, path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	p := &profile{Name: name, Path: path}
+	settings, body := splitFrontMatter(string(data))
+	p.Content = body
+	for key, value := range settings {
+		switch key {
+		case "model":
+			p.Model = value
+		case "temperature":
+			t, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s: temperature: %w", path, err)
+			}
+			p.Temperature, p.HasTemp = t, true
+		case "check-model":
+			p.CheckModel = value
+		case "reply-language":
+			p.ReplyLanguage = value
+		case "comment-language":
+			p.CommentLanguage = value
+		}
+	}
+	return p, nil
+}
+
+// splitFrontMatter separates an optional leading "---" block of key: value
+// lines from the markdown body.
+func splitFrontMatter(text string) (map[string]string, string) {
+	settings := map[string]string{}
+	if !strings.HasPrefix(text, "---\n") && !strings.HasPrefix(text, "---\r\n") {
+		return settings, text
+	}
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	scanner.Scan()
+	consumed := len(scanner.Text()) + 1
+	for scanner.Scan() {
+		line := scanner.Text()
+		consumed += len(line) + 1
+		if strings.TrimSpace(line) == "---" {
+			return settings, strings.TrimLeft(text[min(consumed, len(text)):], "\r\n")
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		settings[strings.ToLower(strings.TrimSpace(key))] = strings.Trim(strings.TrimSpace(value), `"'`)
+	}
+	return map[string]string{}, text
+}
+
+func (p *profile) promptSection() string {
+	if p == nil || strings.TrimSpace(p.Content) == "" {
+		return ""
+	}
+	return fmt.Sprintf("\n\nActive profile (%s):\n%s", p.Name, p.Content)
+}
+
+// applyProfile switches persona settings on top of the startup config, so
+// switching back to no profile restores the original model, temperature,
+// check model, and languages.
+func (m *model) applyProfile(p *profile) {
+	m.profile = p
+	// A prompt edited with /system was written for the old persona.
+	m.systemOverride = ""
+	m.cfg.Model = m.baseCfg.Model
+	m.cfg.Temperature = m.baseCfg.Temperature
+	m.cfg.CheckModel = m.baseCfg.CheckModel
+	m.cfg.ReplyLanguage = m.baseCfg.ReplyLanguage
+	m.cfg.CommentLanguage = m.baseCfg.CommentLanguage
+	if p != nil {
+		if p.Model != "" {
+			m.cfg.Model = p.Model
+		}
+		if p.HasTemp {
+			m.cfg.Temperature = p.Temperature
+		}
+		if p.ReplyLanguage != "" {
+			m.cfg.ReplyLanguage = p.ReplyLanguage
+		}
+		if p.CommentLanguage != "" {
+			m.cfg.CommentLanguage = p.CommentLanguage
+		}
+		switch p.CheckModel {
+		case "":
+		case "off", "none":
+			m.cfg.CheckModel = ""
+		default:
+			m.cfg.CheckModel = p.CheckModel
+		}
+	}
+	m.refreshSystemPrompt()
+}
+
+func (m *model) refreshSystemPrompt() {
+	m.system = message{Role: "system", Content: buildSystemPrompt(m.cfg, m.agentContent) + m.profile.promptSection()}
+	if m.systemOverride != "" {
+		m.system.Content = m.systemOverride
+	}
+	if len(m.history) > 0 && m.history[0].Role == "system" {
+		m.history[0] = m.system
+	} else {
+		m.history = append([]message{m.system}, m.history...)
+	}
+}
+
+func (m *model) cmdProfile(args string) tea.Cmd {
+	if m.streaming {
+		m.notice = "Wait for the current response to finish before switching profiles"
+		return nil
+	}
+	if args == "" {
+		names, err := listProfiles(m.cfg.AgentPath)
+		if err != nil {
+			m.lastErr = err
+			return nil
+		}
+		if len(names) == 0 {
+			m.notice = fmt.Sprintf("No profiles in %s", profilesDir(m.cfg.AgentPath))
+			return nil
+		}
+		var b strings.Builder
+		b.WriteString("Profiles:\n")
+		for _, name := range names {
+			marker := "  "
+			if m.profile != nil && m.profile.Name == name {
+				marker = "* "
+			}
+			b.WriteString(marker + name + "\n")
+		}
+		b.WriteString("Switch with /profile <name>, or /profile none.")
+		m.appendNote(b.String())
+		return nil
+	}
+	if args == "none" {
+		m.applyProfile(nil)
+		m.notice = "Profile cleared"
+		return nil
+	}
+	p, err := loadProfile(m.cfg.AgentPath, args)
+	if err != nil {
+		m.lastErr = err
+		return nil
+	}
+	m.lastErr = nil
+	m.applyProfile(p)
+	m.notice = fmt.Sprintf("Profile %s (model %s, temperature %.2g)", p.Name, m.cfg.Model, m.cfg.Temperature)
+	return nil
+}
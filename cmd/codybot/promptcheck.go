@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// mojibake matches UTF-8 text that was decoded as Latin-1 or Windows-1252
+// somewhere along a copy and paste, e.g. "â€™" for "’" or "Ã©" for "é".
+var mojibake = regexp.MustCompile(`â€[\x{0080}-\x{00BF}\x{2018}-\x{201E}\x{2022}\x{2026}\x{2122}\x{0153}\x{0161}]|Ã[\x{0080}-\x{00BF}]`)
+
+var danglingWords = map[string]bool{
+	"a": true, "an": true, "and": true, "the": true, "to": true, "of": true,
+	"or": true, "with": true, "for": true, "in": true, "that": true,
+}
+
+// promptIssues runs cheap local checks for input that was mangled on the way
+// into the prompt box: broken encodings, pasted terminal escapes, cut-off
+// code blocks, and text that stops mid-sentence. It never calls the model.
+func promptIssues(text string) []string {
+	var issues []string
+	if n := strings.Count(text, "�"); n > 0 {
+		issues = append(issues, fmt.Sprintf("%d replacement character(s) (�)", n))
+	}
+	if matches := mojibake.FindAllString(text, -1); len(matches) > 0 {
+		issues = append(issues, fmt.Sprintf("mis-decoded characters like %q", matches[0]))
+	}
+	if stripANSI(text) != text {
+		issues = append(issues, "terminal escape codes")
+	} else if strings.ContainsFunc(text, func(r rune) bool {
+		return unicode.IsControl(r) && r != '\n' && r != '\t' && r != '\r'
+	}) {
+		issues = append(issues, "control characters")
+	}
+	if word := mixedScriptWord(text); word != "" {
+		issues = append(issues, fmt.Sprintf("%q mixes alphabets", word))
+	}
+	if strings.Count(text, "```")%2 == 1 {
+		issues = append(issues, "an unclosed ``` code block")
+	}
+	if reason := truncatedEnding(text); reason != "" {
+		issues = append(issues, reason)
+	}
+	if half := len(text) / 2; len(text) >= 200 && len(text)%2 == 0 && text[:half] == text[half:] {
+		issues = append(issues, "the same text pasted twice")
+	}
+	return issues
+}
+
+// mixedScriptWord returns the first word that mixes Latin with Cyrillic or
+// Greek letters, a sign of a wrong keyboard layout or look-alike characters.
+func mixedScriptWord(text string) string {
+	for _, word := range strings.FieldsFunc(text, func(r rune) bool { return !unicode.IsLetter(r) }) {
+		latin, other := false, false
+		for _, r := range word {
+			switch {
+			case unicode.Is(unicode.Latin, r):
+				latin = true
+			case unicode.Is(unicode.Cyrillic, r), unicode.Is(unicode.Greek, r):
+				other = true
+			}
+		}
+		if latin && other {
+			return word
+		}
+	}
+	return ""
+}
+
+func truncatedEnding(text string) string {
+	text = strings.TrimSpace(text)
+	if len(text) < 40 {
+		return ""
+	}
+	switch text[len(text)-1] {
+	case ',', '(', '[', '{', '-':
+		return fmt.Sprintf("text ending in %q", text[len(text)-1:])
+	}
+	fields := strings.Fields(text)
+	if last := strings.ToLower(fields[len(fields)-1]); danglingWords[last] {
+		return fmt.Sprintf("text ending mid-sentence (%q)", last)
+	}
+	return ""
+}
+
+// checkPrompt reports whether text may be sent. Flagged text is held back
+// once; pressing Enter again on the unchanged text sends it anyway.
+func (m *model) checkPrompt(text string) bool {
+	if m.cfg.NoPromptCheck || text == m.promptFlagged {
+		return true
+	}
+	issues := promptIssues(text)
+	if len(issues) == 0 {
+		return true
+	}
+	m.promptFlagged = text
+	m.notice = "Prompt may be garbled: " + strings.Join(issues, ", ") + " • Enter to send anyway, Esc to keep editing"
+	return false
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+var (
+	promptNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+	promptVarPattern  = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+	// promptTurnBreak separates the turns of a multi-turn template.
+	promptTurnBreak = regexp.MustCompile(`(?m)^---[ \t]*$`)
+)
+
+// Saved prompts are markdown files in ~/.codybot/prompts. {{name}}
+// placeholders are filled from name=value arguments; {{file}} and
+// {{selection}} default to the focused editor buffer.
+
+func promptsDir() string {
+	return filepath.Join(codybotHome(), "prompts")
+}
+
+func promptPath(name string) string {
+	return filepath.Join(promptsDir(), name+".md")
+}
+
+func listPrompts() ([]string, error) {
+	entries, err := os.ReadDir(promptsDir())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if name, ok := strings.CutSuffix(entry.Name(), ".md"); ok && !entry.IsDir() {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func loadPrompt(name string) (string, error) {
+	data, err := os.ReadFile(promptPath(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("no saved prompt %q; /prompt lists them", name)
+	}
+	return string(data), err
+}
+
+func promptVars(template string) []string {
+	seen := map[string]bool{}
+	var names []string
+	for _, match := range promptVarPattern.FindAllStringSubmatch(template, -1) {
+		if !seen[match[1]] {
+			seen[match[1]] = true
+			names = append(names, match[1])
+		}
+	}
+	return names
+}
+
+// expandPrompt fills the placeholders in template and splits it into turns.
+func expandPrompt(template string, values map[string]string) ([]string, error) {
+	var missing []string
+	text := promptVarPattern.ReplaceAllStringFunc(template, func(match string) string {
+		name := promptVarPattern.FindStringSubmatch(match)[1]
+		value, ok := values[name]
+		if !ok {
+			missing = append(missing, name)
+		}
+		return value
+	})
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("no value for %s; pass %s=...", strings.Join(missing, ", "), missing[0])
+	}
+	var turns []string
+	for _, turn := range promptTurnBreak.Split(text, -1) {
+		if turn = strings.TrimSpace(turn); turn != "" {
+			turns = append(turns, turn)
+		}
+	}
+	if len(turns) == 0 {
+		return nil, errors.New("the prompt is empty")
+	}
+	return turns, nil
+}
+
+// editorPromptValues returns {{file}} and {{selection}} from the focused
+// editor buffer, when an editor plugin reports one.
+func editorPromptValues() map[string]string {
+	values := map[string]string{}
+	states, err := loadEditorStates(".")
+	if err != nil {
+		return values
+	}
+	for _, state := range states {
+		for _, file := range state.Files {
+			if !file.Active {
+				continue
+			}
+			values["file"] = file.Path
+			if file.Selection != nil {
+				if data, err := os.ReadFile(file.Path); err == nil {
+					lines := strings.Split(string(data), "\n")
+					start := max(file.Selection.Start.Line, 1)
+					end := min(file.Selection.End.Line, len(lines))
+					if start <= end {
+						values["selection"] = strings.Join(lines[start-1:end], "\n")
+					}
+				}
+			}
+			return values
+		}
+	}
+	return values
+}
+
+func (m *model) cmdPrompt(args string) tea.Cmd {
+	sub, rest, _ := strings.Cut(args, " ")
+	rest = strings.TrimSpace(rest)
+	name, body, _ := strings.Cut(rest, " ")
+	body = strings.TrimSpace(body)
+	switch sub {
+	case "", "list":
+		names, err := listPrompts()
+		if err != nil {
+			m.lastErr = err
+			return nil
+		}
+		if len(names) == 0 {
+			m.notice = "No saved prompts; /prompt save <name> [text] saves one"
+			return nil
+		}
+		var b strings.Builder
+		b.WriteString("Saved prompts:\n")
+		for _, name := range names {
+			template, _ := loadPrompt(name)
+			first, _, _ := strings.Cut(strings.TrimSpace(template), "\n")
+			first, _ = truncateRunes(first, 60)
+			line := fmt.Sprintf("  %-20s %s", name, first)
+			if vars := promptVars(template); len(vars) > 0 {
+				line += "  {{" + strings.Join(vars, "}} {{") + "}}"
+			}
+			b.WriteString(line + "\n")
+		}
+		b.WriteString("Run one with /prompt use <name> [var=value ...].")
+		m.appendNote(b.String())
+	case "save":
+		if !promptNamePattern.MatchString(name) {
+			m.notice = "Usage: /prompt save <name> [text]; names are lowercase letters, digits, - and _"
+			return nil
+		}
+		if body == "" {
+			body = m.turnPrompt
+		}
+		if body == "" {
+			m.notice = "Nothing to save: give the text, or send a prompt first to save it"
+			return nil
+		}
+		_, err := os.Stat(promptPath(name))
+		existed := err == nil
+		if err := writeFileAtomic(promptPath(name), []byte(body+"\n"), 0o644); err != nil {
+			m.lastErr = err
+			return nil
+		}
+		m.lastErr = nil
+		m.notice = fmt.Sprintf("Saved prompt %s to %s", name, promptPath(name))
+		if existed {
+			m.notice = fmt.Sprintf("Updated prompt %s", name)
+		}
+	case "use":
+		if m.streaming || m.compacting {
+			m.notice = "Wait for the current response to finish"
+			return nil
+		}
+		template, err := loadPrompt(name)
+		if err != nil {
+			m.lastErr = err
+			return nil
+		}
+		values := editorPromptValues()
+		for _, field := range strings.Fields(body) {
+			key, value, ok := strings.Cut(field, "=")
+			if !ok {
+				m.notice = fmt.Sprintf("Expected var=value, got %q", field)
+				return nil
+			}
+			values[key] = value
+		}
+		turns, err := expandPrompt(template, values)
+		if err != nil {
+			m.lastErr = fmt.Errorf("prompt %s: %w", name, err)
+			return nil
+		}
+		m.promptQueue = turns[1:]
+		return m.send(turns[0], turns[0])
+	case "rm":
+		if err := os.Remove(promptPath(name)); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				err = fmt.Errorf("no saved prompt %q", name)
+			}
+			m.lastErr = err
+			return nil
+		}
+		m.lastErr = nil
+		m.notice = "Removed prompt " + name
+	default:
+		m.notice = "Usage: /prompt [list|save <name> [text]|use <name> [var=value ...]|rm <name>]"
+	}
+	return nil
+}
+
+// nextQueuedPrompt sends the next turn of a multi-turn template once the
+// previous answer is complete.
+func (m *model) nextQueuedPrompt() tea.Cmd {
+	if len(m.promptQueue) == 0 || m.state != stateChat {
+		return nil
+	}
+	next := m.promptQueue[0]
+	m.promptQueue = m.promptQueue[1:]
+	return m.send(next, next)
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	maxRateLimitWait    = 60 * time.Second
+	maxRateLimitRetries = 3
+)
+
+var rateLimitStates sync.Map
+
+// quota is one x-ratelimit-* family (requests or tokens) as last reported by
+// the server.
+type quota struct {
+	known     bool
+	limit     int
+	remaining int
+	reset     time.Time
+}
+
+// rateLimits tracks the provider's advertised limits for one endpoint and
+// model, so requests can wait for a reset instead of being rejected.
+type rateLimits struct {
+	mu       sync.Mutex
+	requests quota
+	tokens   quota
+}
+
+func rateLimitKey(cfg config) string {
+	return cfg.BaseURL + "|" + cfg.Model
+}
+
+func rateLimitsFor(cfg config) *rateLimits {
+	limits, _ := rateLimitStates.LoadOrStore(rateLimitKey(cfg), &rateLimits{})
+	return limits.(*rateLimits)
+}
+
+// lookupRateLimits returns the tracked limits without creating an entry, for
+// display.
+func lookupRateLimits(cfg config) *rateLimits {
+	if limits, ok := rateLimitStates.Load(rateLimitKey(cfg)); ok {
+		return limits.(*rateLimits)
+	}
+	return nil
+}
+
+// observe reads OpenAI-style x-ratelimit-{limit,remaining,reset}-{requests,
+// tokens} headers, and the unsuffixed form some gateways use for requests.
+func (r *rateLimits) observe(h http.Header, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests.update(h, "requests", now)
+	r.tokens.update(h, "tokens", now)
+	if !r.requests.known {
+		r.requests.update(h, "", now)
+	}
+}
+
+func (q *quota) update(h http.Header, kind string, now time.Time) {
+	suffix := ""
+	if kind != "" {
+		suffix = "-" + kind
+	}
+	remaining, err := strconv.Atoi(strings.TrimSpace(h.Get("x-ratelimit-remaining" + suffix)))
+	if err != nil {
+		return
+	}
+	q.known, q.remaining = true, remaining
+	if limit, err := strconv.Atoi(strings.TrimSpace(h.Get("x-ratelimit-limit" + suffix))); err == nil {
+		q.limit = limit
+	}
+	if reset, ok := parseRateLimitReset(h.Get("x-ratelimit-reset"+suffix), now); ok {
+		q.reset = reset
+	}
+}
+
+// parseRateLimitReset accepts the formats providers use for reset times: a
+// Go-style duration ("6m0s", "20ms"), seconds from now, a Unix timestamp in
+// seconds or milliseconds, or RFC 3339.
+func parseRateLimitReset(value string, now time.Time) (time.Time, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, false
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(d), true
+	}
+	if n, err := strconv.ParseFloat(value, 64); err == nil {
+		switch {
+		case n > 1e12:
+			return time.UnixMilli(int64(n)), true
+		case n > 1e9:
+			return time.Unix(int64(n), 0), true
+		}
+		return now.Add(time.Duration(n * float64(time.Second))), true
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+// retryAfter reads how long a 429 or 503 response asks the client to wait,
+// or 0 when the server does not say.
+func retryAfter(h http.Header, now time.Time) time.Duration {
+	if ms, err := strconv.Atoi(strings.TrimSpace(h.Get("retry-after-ms"))); err == nil && ms > 0 {
+		return time.Duration(ms) * time.Millisecond
+	}
+	value := strings.TrimSpace(h.Get("Retry-After"))
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds > 0 {
+		return time.Duration(seconds * float64(time.Second))
+	}
+	if t, err := http.ParseTime(value); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+// delay returns how long to wait before sending a request of about tokens
+// prompt tokens, and why. Waits longer than maxRateLimitWait are not taken:
+// the request goes out and the server's answer is reported instead.
+func (r *rateLimits) delay(tokens int, now time.Time) (time.Duration, string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var wait time.Duration
+	var reason string
+	if q := r.requests; q.known && q.remaining <= 0 && q.reset.After(now) {
+		wait, reason = q.reset.Sub(now), "no requests left in this window"
+	}
+	if q := r.tokens; q.known && q.remaining < tokens && q.reset.After(now) && q.reset.Sub(now) > wait {
+		wait, reason = q.reset.Sub(now), fmt.Sprintf("%s tokens left, request needs ~%s", formatCount(q.remaining), formatCount(tokens))
+	}
+	if wait > maxRateLimitWait {
+		return 0, ""
+	}
+	return wait, reason
+}
+
+// summary is the remaining quota for the status bar, or "" before any
+// response has reported limits.
+func (r *rateLimits) summary() string {
+	if r == nil {
+		return ""
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	var parts []string
+	for _, q := range []struct {
+		quota
+		unit string
+	}{{r.requests, "req"}, {r.tokens, "tok"}} {
+		if !q.known {
+			continue
+		}
+		remaining := q.remaining
+		if !q.reset.IsZero() && !q.reset.After(now) && q.limit > 0 {
+			remaining = q.limit
+		}
+		part := formatCount(remaining)
+		if q.limit > 0 {
+			part += "/" + formatCount(q.limit)
+		}
+		parts = append(parts, part+" "+q.unit)
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "quota " + strings.Join(parts, ", ")
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+const (
+	fileApplied = "applied"
+	filePending = "not applied"
+	fileChanged = "modified"
+)
+
+// fileTarget is one file's transition in a multi-file apply.
+type fileTarget struct {
+	Path       string `json:"path"`
+	From       []byte `json:"from"`
+	FromExists bool   `json:"from_exists"`
+	To         []byte `json:"to"`
+	ToExists   bool   `json:"to_exists"`
+}
+
+// pendingApply is written before an undo or redo touches any file and
+// removed once every file is written and the journal is saved. Finding it on
+// startup means the apply was interrupted part-way.
+type pendingApply struct {
+	Op         string       `json:"op"`
+	Checkpoint int          `json:"checkpoint"`
+	StartedAt  time.Time    `json:"started_at"`
+	Files      []fileTarget `json:"files"`
+}
+
+// checkpointTargets collapses a checkpoint's writes into one transition per
+// path, from the state after the checkpoint to the state before it (undo) or
+// the reverse (redo).
+func checkpointTargets(changes []fileVersion, undo bool) []fileTarget {
+	var targets []fileTarget
+	seen := map[string]bool{}
+	for _, v := range changes {
+		if seen[v.Path] {
+			continue
+		}
+		seen[v.Path] = true
+		last := changes[lastVersionIndex(changes, v.Path)]
+		t := fileTarget{Path: v.Path, From: v.Before, FromExists: v.Existed, To: last.After, ToExists: true}
+		if undo {
+			t.From, t.FromExists, t.To, t.ToExists = t.To, t.ToExists, t.From, t.FromExists
+		}
+		targets = append(targets, t)
+	}
+	return targets
+}
+
+func (t fileTarget) state() string {
+	current, err := os.ReadFile(t.Path)
+	exists := err == nil
+	switch {
+	case exists == t.ToExists && (!exists || bytes.Equal(current, t.To)):
+		return fileApplied
+	case exists == t.FromExists && (!exists || bytes.Equal(current, t.From)):
+		return filePending
+	}
+	return fileChanged
+}
+
+func setFileState(path string, content []byte, exists bool) error {
+	if !exists {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	return writeFileAtomic(path, content, 0o644)
+}
+
+// writeFileAtomic writes through a temporary file and a rename, so a crash
+// leaves either the old or the new content, never a torn file.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".codybot-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (j *editJournal) pendingPath() string {
+	if j.path == "" {
+		return ""
+	}
+	return filepath.Join(filepath.Dir(j.path), "pending.json")
+}
+
+// apply moves every target to its new state, recording the intent first when
+// the journal is persisted. Callers hold j.mu.
+func (j *editJournal) apply(op string, cp *checkpoint, targets []fileTarget) error {
+	pending := j.pendingPath()
+	if pending != "" {
+		data, err := json.Marshal(pendingApply{Op: op, Checkpoint: cp.ID, StartedAt: time.Now(), Files: targets})
+		if err != nil {
+			return err
+		}
+		if err := writeFileAtomic(pending, data, 0o600); err != nil {
+			return fmt.Errorf("record %s intent: %w", op, err)
+		}
+	}
+	for _, t := range targets {
+		if err := setFileState(t.Path, t.To, t.ToExists); err != nil {
+			return fmt.Errorf("%s %s: %w (run /recover to finish or roll back)", op, t.Path, err)
+		}
+	}
+	return nil
+}
+
+func (j *editJournal) clearPending() {
+	if pending := j.pendingPath(); pending != "" {
+		os.Remove(pending)
+	}
+}
+
+func (j *editJournal) loadPending() (*pendingApply, error) {
+	pending := j.pendingPath()
+	if pending == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(pending)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var p pendingApply
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("%s: %w", pending, err)
+	}
+	return &p, nil
+}
+
+// closeTurn marks this session's checkpoints as finished. A checkpoint left
+// open by an earlier session belongs to a turn that was cut off.
+func (j *editJournal) closeTurn() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	changed := false
+	for _, cp := range j.checkpoints {
+		if cp.Open && cp.Session == j.session {
+			cp.Open, changed = false, true
+		}
+	}
+	if changed {
+		j.save()
+	}
+}
+
+func (j *editJournal) keepTurn(cp *checkpoint) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	cp.Open = false
+	return j.save()
+}
+
+func (j *editJournal) interruptedTurn() *checkpoint {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.applied == 0 {
+		return nil
+	}
+	if cp := j.checkpoints[j.applied-1]; cp.Open && cp.Session != j.session {
+		return cp
+	}
+	return nil
+}
+
+// recoveryReport describes any half-finished change left by a previous run,
+// or returns "" when the tree is consistent with the journal.
+func (j *editJournal) recoveryReport() string {
+	var b strings.Builder
+	pending, err := j.loadPending()
+	if err != nil {
+		return "Could not read the pending apply record: " + err.Error()
+	}
+	if pending != nil {
+		fmt.Fprintf(&b, "The %s of checkpoint #%d started %s was interrupted:", pending.Op, pending.Checkpoint, pending.StartedAt.Local().Format("Jan 2 15:04"))
+		for _, t := range pending.Files {
+			fmt.Fprintf(&b, "\n  %-11s %s", t.state(), t.Path)
+		}
+		b.WriteString("\n/recover complete finishes it, /recover revert rolls it back, /recover show lists it again.")
+		return b.String()
+	}
+	if cp := j.interruptedTurn(); cp != nil {
+		prompt, _ := truncateRunes(strings.ReplaceAll(cp.Prompt, "\n", " "), 60)
+		fmt.Fprintf(&b, "The agent turn for %q was cut off after changing %d file(s):", prompt, len(cp.files()))
+		for _, path := range cp.files() {
+			added, removed := 0, 0
+			for _, v := range cp.Changes {
+				if v.Path == path {
+					a, r := lineDelta(v.Before, v.After)
+					added, removed = added+a, removed+r
+				}
+			}
+			fmt.Fprintf(&b, "\n  %s (+%d/-%d)", path, added, removed)
+		}
+		b.WriteString("\n/recover revert undoes these edits, /recover keep accepts them, /timeline shows the patches.")
+		return b.String()
+	}
+	return ""
+}
+
+// completePending finishes an interrupted apply and updates the journal as
+// the original operation would have.
+func (j *editJournal) completePending(p *pendingApply) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	var changed []string
+	for _, t := range p.Files {
+		if t.state() == fileChanged {
+			changed = append(changed, t.Path)
+		}
+	}
+	if len(changed) > 0 {
+		return fmt.Errorf("files changed since the interrupted %s: %s", p.Op, strings.Join(changed, ", "))
+	}
+	for _, t := range p.Files {
+		if err := setFileState(t.Path, t.To, t.ToExists); err != nil {
+			return err
+		}
+	}
+	switch {
+	case p.Op == "undo" && j.applied > 0 && j.checkpoints[j.applied-1].ID == p.Checkpoint:
+		j.applied--
+	case p.Op == "redo" && j.applied < len(j.checkpoints) && j.checkpoints[j.applied].ID == p.Checkpoint:
+		j.applied++
+	}
+	if err := j.save(); err != nil {
+		return err
+	}
+	j.clearPending()
+	return nil
+}
+
+// revertPending puts back every file the interrupted apply already wrote.
+func (j *editJournal) revertPending(p *pendingApply) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, t := range p.Files {
+		if t.state() != fileApplied {
+			continue
+		}
+		if err := setFileState(t.Path, t.From, t.FromExists); err != nil {
+			return err
+		}
+	}
+	j.clearPending()
+	return nil
+}
+
+func (m *model) cmdRecover(args string) tea.Cmd {
+	if m.streaming {
+		m.notice = "Wait for the current response to finish before recovering"
+		return nil
+	}
+	pending, err := m.journal.loadPending()
+	if err != nil {
+		m.lastErr = err
+		return nil
+	}
+	interrupted := m.journal.interruptedTurn()
+	if pending == nil && interrupted == nil {
+		m.notice = "Nothing to recover"
+		return nil
+	}
+	switch args {
+	case "", "show":
+		m.appendNote(m.journal.recoveryReport())
+		return nil
+	case "complete":
+		if pending == nil {
+			m.notice = "A cut-off agent turn cannot be completed; use /recover keep or /recover revert"
+			return nil
+		}
+		err = m.journal.completePending(pending)
+	case "revert":
+		if pending != nil {
+			err = m.journal.revertPending(pending)
+		} else {
+			m.journal.keepTurn(interrupted)
+			_, err = m.journal.undo()
+		}
+	case "keep":
+		if pending != nil {
+			m.notice = "An interrupted apply must be completed or reverted"
+			return nil
+		}
+		err = m.journal.keepTurn(interrupted)
+	default:
+		m.notice = "Usage: /recover [show|complete|revert|keep]"
+		return nil
+	}
+	if err != nil {
+		m.lastErr = err
+		return nil
+	}
+	m.lastErr = nil
+	m.notice = "Recovered: " + args
+	return nil
+}
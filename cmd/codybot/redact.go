@@ -0,0 +1,286 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// redactedPrefix starts the placeholder a masked secret is replaced with,
+// e.g. [REDACTED:aws-access-key].
+const redactedPrefix = "[REDACTED:"
+
+const (
+	// minRandomToken and minRandomEntropy tune the entropy heuristic: a run
+	// of key-like characters at least this long, with at least this many
+	// bits of Shannon entropy per character, is taken for a generated key.
+	// Random base64 scores about 5; identifiers and words stay near 4.
+	minRandomToken   = 32
+	minRandomEntropy = 4.3
+)
+
+// redactPolicy is the "redact" object of a policy file:
+//
+//	{"redact": {"patterns": ["corp_[a-z0-9]{24}", "internal-token: (\\S+)"], "entropy": true}}
+//
+// Patterns are added to the built-in ones. When a pattern has a group, only
+// the group is masked.
+type redactPolicy struct {
+	Patterns []string `json:"patterns"`
+	Entropy  *bool    `json:"entropy"`
+}
+
+type redactRule struct {
+	name string
+	re   *regexp.Regexp
+}
+
+// builtinRedactRules find well-known credential formats. Rules for values
+// that are only recognizable by their name mask just the value.
+var builtinRedactRules = []redactRule{
+	{"private-key", regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY(?: BLOCK)?-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY(?: BLOCK)?-----`)},
+	{"aws-access-key", regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{"aws-secret-key", regexp.MustCompile(`(?i)aws_?secret_?access_?key["']?\s*[:=]\s*["']?([A-Za-z0-9/+=]{40})`)},
+	{"github-token", regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{36,}\b|\bgithub_pat_[A-Za-z0-9_]{22,}`)},
+	{"api-key", regexp.MustCompile(`\bsk-[A-Za-z0-9_-]{20,}`)},
+	{"slack-token", regexp.MustCompile(`\bxox[abprs]-[A-Za-z0-9-]{10,}`)},
+	{"google-api-key", regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}\b`)},
+	{"stripe-key", regexp.MustCompile(`\b[rs]k_(?:live|test)_[0-9A-Za-z]{20,}\b`)},
+	{"jwt", regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{10,}\.eyJ[A-Za-z0-9_-]{10,}\.[A-Za-z0-9_-]{10,}`)},
+	{"bearer-token", regexp.MustCompile(`(?i)\bbearer\s+([A-Za-z0-9._~+/-]{16,}=*)`)},
+	{"url-password", regexp.MustCompile(`[a-z][a-z0-9+.-]*://[^/\s:@]+:([^/\s@]+)@`)},
+	// Quoted literals assigned to secret-sounding names; code such as
+	// token := getToken() is left alone.
+	{"secret", regexp.MustCompile(`(?i)(?:api[_-]?key|secret|token|passw(?:or)?d|credential)[\w-]*["']?\s*[:=]\s*["']([^"'\s]{8,})["']`)},
+	// .env and shell assignments.
+	{"secret", regexp.MustCompile(`(?m)^\s*(?:export\s+)?[A-Z0-9_]*(?:API_KEY|SECRET|TOKEN|PASSWORD|PASSWD)[A-Z0-9_]*\s*=\s*([^\s"'#]{8,})`)},
+}
+
+var (
+	randomToken = regexp.MustCompile(`[A-Za-z0-9+/_=-]{32,}`)
+	hexToken    = regexp.MustCompile(`^[0-9a-fA-F-]+$`)
+	// hashPrefixes mark checksums, as in go.sum and package-lock.json,
+	// which look random but are not secret.
+	hashPrefixes = []string{"h1:", "sha1-", "sha256-", "sha384-", "sha512-", "sha256:", "sha512:"}
+)
+
+// redactor masks secrets in text before it leaves the machine or is logged.
+type redactor struct {
+	rules   []redactRule
+	entropy bool
+}
+
+// loadRedactor builds the redactor from the built-in rules and the policy
+// files' redact sections. The entropy heuristic stays on unless a file turns
+// it off and neither turns it on. On error the built-in rules still apply.
+func loadRedactor() (*redactor, error) {
+	r := &redactor{rules: builtinRedactRules, entropy: true}
+	files, err := readPolicyFiles()
+	if err != nil {
+		return r, err
+	}
+	on, off := false, false
+	for _, file := range files {
+		for _, pattern := range file.Redact.Patterns {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return r, fmt.Errorf("redact pattern %q: %w", pattern, err)
+			}
+			r.rules = append(r.rules[:len(r.rules):len(r.rules)], redactRule{"custom", re})
+		}
+		if file.Redact.Entropy != nil {
+			on = on || *file.Redact.Entropy
+			off = off || !*file.Redact.Entropy
+		}
+	}
+	r.entropy = on || !off
+	return r, nil
+}
+
+// logRedactor masks what codybot writes to disk. Logs are masked even with
+// --no-redact, which only concerns what the model sees, and a broken policy
+// falls back to the built-in rules rather than stopping the write.
+func logRedactor() *redactor {
+	r, _ := loadRedactor()
+	return r
+}
+
+// redact returns text with every secret masked, and the kinds it found.
+func (r *redactor) redact(text string) (string, []string) {
+	var found []string
+	for _, rule := range r.rules {
+		var n int
+		text, n = maskMatches(text, rule.re, rule.name)
+		for ; n > 0; n-- {
+			found = append(found, rule.name)
+		}
+	}
+	if r.entropy {
+		var n int
+		text, n = maskRandomTokens(text)
+		for ; n > 0; n-- {
+			found = append(found, "high-entropy")
+		}
+	}
+	return text, found
+}
+
+// maskMatches replaces each match of re, or its first group when it has
+// one, with a placeholder naming the rule.
+func maskMatches(text string, re *regexp.Regexp, name string) (string, int) {
+	matches := re.FindAllStringSubmatchIndex(text, -1)
+	if matches == nil {
+		return text, 0
+	}
+	var b strings.Builder
+	last, n := 0, 0
+	for _, match := range matches {
+		start, end := match[0], match[1]
+		if len(match) >= 4 && match[2] >= 0 {
+			start, end = match[2], match[3]
+		}
+		if start == end || strings.Contains(text[start:end], redactedPrefix) {
+			continue
+		}
+		b.WriteString(text[last:start])
+		b.WriteString(redactedPrefix + name + "]")
+		last = end
+		n++
+	}
+	b.WriteString(text[last:])
+	return b.String(), n
+}
+
+// maskRandomTokens masks long strings that look generated, such as keys in
+// formats no rule knows.
+func maskRandomTokens(text string) (string, int) {
+	var b strings.Builder
+	last, n := 0, 0
+	for _, loc := range randomToken.FindAllStringIndex(text, -1) {
+		token := text[loc[0]:loc[1]]
+		if !looksRandom(token) || hashed(text[:loc[0]], token) {
+			continue
+		}
+		b.WriteString(text[last:loc[0]])
+		b.WriteString(redactedPrefix + "high-entropy]")
+		last = loc[1]
+		n++
+	}
+	if n == 0 {
+		return text, 0
+	}
+	b.WriteString(text[last:])
+	return b.String(), n
+}
+
+// hashed reports a token that follows a hash prefix, or starts with one, as
+// the dashed prefixes are part of the token's own characters.
+func hashed(before, token string) bool {
+	for _, prefix := range hashPrefixes {
+		if strings.HasSuffix(before, prefix) || strings.HasPrefix(token, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// looksRandom reports a token mixing upper case, lower case, and digits with
+// high entropy. Hex strings are left alone: they are commit and checksum
+// hashes far more often than keys.
+func looksRandom(token string) bool {
+	if len(token) < minRandomToken || hexToken.MatchString(token) {
+		return false
+	}
+	var upper, lower, digit bool
+	for _, c := range token {
+		switch {
+		case c >= 'A' && c <= 'Z':
+			upper = true
+		case c >= 'a' && c <= 'z':
+			lower = true
+		case c >= '0' && c <= '9':
+			digit = true
+		}
+	}
+	return upper && lower && digit && shannonEntropy(token) >= minRandomEntropy
+}
+
+func shannonEntropy(s string) float64 {
+	counts := map[rune]int{}
+	for _, c := range s {
+		counts[c]++
+	}
+	total := float64(len(s))
+	var bits float64
+	for _, n := range counts {
+		p := float64(n) / total
+		bits -= p * math.Log2(p)
+	}
+	return bits
+}
+
+// messages masks the content and tool call arguments of history, and
+// returns the kinds found in its last message, which is the one new to the
+// provider.
+func (r *redactor) messages(history []message) ([]message, []string) {
+	out := make([]message, len(history))
+	var latest []string
+	for i, msg := range history {
+		var found []string
+		msg.Content, found = r.redact(msg.Content)
+		if len(msg.ToolCalls) > 0 {
+			calls := make([]toolCall, len(msg.ToolCalls))
+			for j, call := range msg.ToolCalls {
+				var more []string
+				call.Function.Arguments, more = r.redact(call.Function.Arguments)
+				found = append(found, more...)
+				calls[j] = call
+			}
+			msg.ToolCalls = calls
+		}
+		out[i] = msg
+		if i == len(history)-1 {
+			latest = found
+		}
+	}
+	return out, latest
+}
+
+// redactHistory masks secrets in what is about to be sent to the provider,
+// noting on ch what was masked in the newest message. A policy that cannot
+// be loaded stops the request.
+func (cfg config) redactHistory(history []message, ch chan<- streamMsg) ([]message, error) {
+	if cfg.NoRedact {
+		return history, nil
+	}
+	r, err := loadRedactor()
+	if err != nil {
+		return nil, fmt.Errorf("redaction policy: %w", err)
+	}
+	history, found := r.messages(history)
+	if len(found) > 0 {
+		ch <- streamMsg{info: fmt.Sprintf("Masked %s before sending", describeRedactions(found))}
+	}
+	return history, nil
+}
+
+// describeRedactions summarizes found as "2 secrets (aws-access-key,
+// github-token)".
+func describeRedactions(found []string) string {
+	kinds := map[string]bool{}
+	for _, kind := range found {
+		kinds[kind] = true
+	}
+	names := make([]string, 0, len(kinds))
+	for kind := range kinds {
+		names = append(names, kind)
+	}
+	sort.Strings(names)
+	noun := "secrets"
+	if len(found) == 1 {
+		noun = "secret"
+	}
+	return fmt.Sprintf("%d %s (%s)", len(found), noun, strings.Join(names, ", "))
+}
@@ -0,0 +1,44 @@
+package main
+
+import "github.com/charmbracelet/lipgloss"
+
+// renderCache memoizes the bordered transcript box. Spinner ticks, cursor
+// blinks, and keystrokes re-run View many times per second while the
+// transcript is unchanged, and re-wrapping a large viewport each time is the
+// dominant cost. It is shared by pointer so copies of the model reuse it.
+type renderCache struct {
+	key outputKey
+	box string
+}
+
+type outputKey struct {
+	version  int
+	yOffset  int
+	width    int
+	vpWidth  int
+	vpHeight int
+}
+
+func (m *model) setViewportContent(content string) {
+	m.viewport.SetContent(content)
+	m.contentVersion++
+}
+
+func (m model) renderOutputBox(border lipgloss.Style) string {
+	key := outputKey{
+		version:  m.contentVersion,
+		yOffset:  m.viewport.YOffset,
+		width:    m.width,
+		vpWidth:  m.viewport.Width,
+		vpHeight: m.viewport.Height,
+	}
+	if m.render != nil && m.render.box != "" && m.render.key == key {
+		return m.render.box
+	}
+	box := border.Width(m.width).Render(m.viewport.View())
+	if m.render != nil {
+		m.render.key = key
+		m.render.box = box
+	}
+	return box
+}
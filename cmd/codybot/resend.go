@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// editState is an earlier prompt being edited in the input box, to be sent
+// again in place of the original.
+type editState struct {
+	block *block
+	// confirmed is the text the user was warned about; Enter on it again
+	// resends.
+	confirmed string
+}
+
+// cmdEdit puts one of your messages, the latest by default, back into the
+// input to change and resend it.
+func (m *model) cmdEdit(args string) tea.Cmd {
+	b, err := m.blockArg(strings.TrimSpace(args), blockUser)
+	if err != nil {
+		m.lastErr = err
+		return nil
+	}
+	m.startEdit(b)
+	return nil
+}
+
+func (m *model) startEdit(b *block) {
+	switch {
+	case m.streaming || m.compacting:
+		m.notice = "Wait for the current response to finish before editing"
+		return
+	case b.kind != blockUser:
+		m.notice = fmt.Sprintf("Message #%d is not yours; only your messages can be edited", b.id)
+		return
+	case b.history < 0:
+		m.lastErr = fmt.Errorf("message #%d was compacted into a summary and cannot be edited", b.id)
+		return
+	}
+	m.lastErr = nil
+	m.editing = editState{block: b}
+	m.input.SetValue(b.plain())
+	m.input.CursorEnd()
+	m.input.Focus()
+	m.notice = fmt.Sprintf("Editing message #%d • Enter resends it • Esc cancels", b.id)
+	if b.chips != "" {
+		m.notice += " • attachments are not resent; /attach them again"
+	}
+}
+
+func (m *model) cancelEdit() {
+	m.editing = editState{}
+	m.input.Reset()
+	m.notice = ""
+}
+
+// resend replaces the edited message and everything after it with text,
+// once the user confirmed what that drops.
+func (m *model) resend(text string) (bool, tea.Cmd) {
+	b := m.editing.block
+	at := -1
+	for i, candidate := range m.transcript.blocks {
+		if candidate == b {
+			at = i
+		}
+	}
+	if at < 0 || b.history >= len(m.history) {
+		m.editing = editState{}
+		m.lastErr = fmt.Errorf("message #%d is no longer in the conversation", b.id)
+		return false, nil
+	}
+	if m.editing.confirmed != text {
+		m.editing.confirmed = text
+		dropped := len(m.transcript.blocks) - at - 1
+		m.notice = fmt.Sprintf("Resending replaces #%d and drops the %d message(s) after it; file edits are kept • Enter to resend, Esc to keep editing", b.id, dropped)
+		if dropped == 0 {
+			m.notice = fmt.Sprintf("Resending replaces #%d • Enter to resend, Esc to keep editing", b.id)
+		}
+		return false, nil
+	}
+	m.history = m.history[:b.history]
+	m.transcript.truncateBefore(b)
+	m.editing = editState{}
+	m.citations = citations{}
+	m.refreshTranscript()
+	return true, m.send(text, text)
+}
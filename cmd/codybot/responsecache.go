@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// cachedResponse is one answer in the response cache, stored under the hash
+// of the request that produced it. The request itself is not stored.
+type cachedResponse struct {
+	SavedAt   time.Time  `json:"saved_at"`
+	Model     string     `json:"model"`
+	Content   string     `json:"content"`
+	ToolCalls []toolCall `json:"tool_calls,omitempty"`
+}
+
+// responseCacheKey hashes everything that shapes an answer: the endpoint,
+// the model and its sampling settings, the tools offered, and the messages.
+func responseCacheKey(cfg config, history []message, tools []Tool) string {
+	data, _ := json.Marshal(struct {
+		BaseURL       string          `json:"base_url"`
+		Provider      string          `json:"provider"`
+		Model         string          `json:"model"`
+		Temperature   float64         `json:"temperature"`
+		Seed          *int            `json:"seed,omitempty"`
+		Schema        json.RawMessage `json:"schema,omitempty"`
+		HistoryPolicy string          `json:"history_policy"`
+		ContextWindow int             `json:"context_window"`
+		Tools         []Tool          `json:"tools,omitempty"`
+		Messages      []message       `json:"messages"`
+	}{cfg.BaseURL, cfg.Provider, cfg.Model, cfg.Temperature, cfg.Seed, cfg.schema, cfg.HistoryPolicy, cfg.ContextWindow, tools, apiMessages(history)})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func responseCachePath(key string) string {
+	return filepath.Join(codybotHome(), "cache", "responses", key[:2], key+".json")
+}
+
+// lookupResponse returns the cached answer for key if it is younger than
+// ttl. Expired entries are removed as they are found.
+func lookupResponse(key string, ttl time.Duration) (cachedResponse, bool) {
+	path := responseCachePath(key)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cachedResponse{}, false
+	}
+	var cached cachedResponse
+	if json.Unmarshal(data, &cached) != nil || time.Since(cached.SavedAt) >= ttl {
+		os.Remove(path)
+		return cachedResponse{}, false
+	}
+	return cached, true
+}
+
+func storeResponse(key string, cached cachedResponse) {
+	if data, err := json.Marshal(cached); err == nil {
+		writeFileAtomic(responseCachePath(key), data, 0o600)
+	}
+}
+
+// streamCached answers from the response cache when --cache-ttl is set and
+// an identical request was answered within it; otherwise it streams as
+// usual and caches a complete answer. Failed and cancelled streams are
+// never cached.
+func streamCached(ctx context.Context, cfg config, history []message, tools []Tool, ch chan<- streamMsg) {
+	if cfg.CacheTTL <= 0 || cfg.NoCache {
+		streamWithFailover(ctx, cfg, history, tools, ch)
+		return
+	}
+	key := responseCacheKey(cfg, history, tools)
+	if cached, ok := lookupResponse(key, cfg.CacheTTL); ok {
+		ch <- streamMsg{info: fmt.Sprintf("Answered from the response cache (saved %s ago)", time.Since(cached.SavedAt).Round(time.Second))}
+		if cached.Content != "" {
+			ch <- streamMsg{token: cached.Content}
+		}
+		ch <- streamMsg{done: true, toolCalls: cached.ToolCalls, model: cached.Model}
+		return
+	}
+
+	upstream := make(chan streamMsg)
+	go streamWithFailover(ctx, cfg, history, tools, upstream)
+	var content strings.Builder
+	for {
+		msg := <-upstream
+		content.WriteString(msg.token)
+		if msg.done && msg.err == nil && ctx.Err() == nil {
+			storeResponse(key, cachedResponse{SavedAt: time.Now(), Model: msg.model, Content: content.String(), ToolCalls: msg.toolCalls})
+		}
+		ch <- msg
+		if msg.done || msg.err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,446 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	defaultRunsDir    = ".codybot/runs"
+	maxCheckOutput    = 60
+	defaultTaskSeed   = 0
+	defaultCheckLimit = 10 * time.Minute
+)
+
+// taskFile is a declarative agent run: everything that shapes the result is
+// in the file, so running it again against the same revision and model
+// repeats the run.
+type taskFile struct {
+	Name        string      `yaml:"name"`
+	Prompt      string      `yaml:"prompt"`
+	Model       string      `yaml:"model"`
+	Temperature *float64    `yaml:"temperature"`
+	Seed        *int        `yaml:"seed"`
+	Tools       []string    `yaml:"tools"`
+	Context     []string    `yaml:"context"`
+	Budget      taskBudget  `yaml:"budget"`
+	Checks      []taskCheck `yaml:"checks"`
+}
+
+type taskBudget struct {
+	ToolCalls    int    `yaml:"tool_calls"`
+	OutputTokens int    `yaml:"output_tokens"`
+	Timeout      string `yaml:"timeout"`
+}
+
+type taskCheck struct {
+	Name    string `yaml:"name"`
+	Run     string `yaml:"run"`
+	Timeout string `yaml:"timeout"`
+}
+
+type checkResult struct {
+	Name     string  `json:"name"`
+	Command  string  `json:"command"`
+	Passed   bool    `json:"passed"`
+	Duration float64 `json:"duration_seconds"`
+	Output   string  `json:"output,omitempty"`
+}
+
+type runReport struct {
+	Task         string        `json:"task"`
+	Status       string        `json:"status"`
+	Error        string        `json:"error,omitempty"`
+	Revision     string        `json:"revision,omitempty"`
+	Provider     string        `json:"provider"`
+	Model        string        `json:"model"`
+	Temperature  float64       `json:"temperature"`
+	Seed         *int          `json:"seed,omitempty"`
+	Tools        []string      `json:"tools"`
+	StartedAt    time.Time     `json:"started_at"`
+	Duration     float64       `json:"duration_seconds"`
+	ToolCalls    int           `json:"tool_calls"`
+	InputTokens  int           `json:"input_tokens"`
+	OutputTokens int           `json:"output_tokens"`
+	Cost         float64       `json:"cost,omitempty"`
+	Files        []string      `json:"files"`
+	Checks       []checkResult `json:"checks"`
+	Answer       string        `json:"answer"`
+}
+
+// runLogEntry is one line of log.jsonl.
+type runLogEntry struct {
+	At     time.Time `json:"at"`
+	Type   string    `json:"type"`
+	Text   string    `json:"text,omitempty"`
+	Tool   string    `json:"tool,omitempty"`
+	Args   string    `json:"args,omitempty"`
+	Error  string    `json:"error,omitempty"`
+	Passed *bool     `json:"passed,omitempty"`
+}
+
+func loadTaskFile(path string) (taskFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return taskFile{}, err
+	}
+	var task taskFile
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&task); err != nil {
+		return taskFile{}, fmt.Errorf("%s: %w", path, err)
+	}
+	if strings.TrimSpace(task.Prompt) == "" {
+		return taskFile{}, fmt.Errorf("%s: prompt is required", path)
+	}
+	if task.Name == "" {
+		task.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	for i, check := range task.Checks {
+		if strings.TrimSpace(check.Run) == "" {
+			return taskFile{}, fmt.Errorf("%s: checks[%d]: run is required", path, i)
+		}
+		if check.Name == "" {
+			task.Checks[i].Name = check.Run
+		}
+		if _, err := parseTaskDuration(check.Timeout, defaultCheckLimit); err != nil {
+			return taskFile{}, fmt.Errorf("%s: checks[%d]: timeout: %w", path, i, err)
+		}
+	}
+	if _, err := parseTaskDuration(task.Budget.Timeout, 0); err != nil {
+		return taskFile{}, fmt.Errorf("%s: budget.timeout: %w", path, err)
+	}
+	return task, nil
+}
+
+func parseTaskDuration(value string, fallback time.Duration) (time.Duration, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	return time.ParseDuration(value)
+}
+
+// apply overrides the command-line config with the task's settings. A task
+// that does not pin the temperature and seed runs greedy with seed 0, so the
+// defaults are reproducible too.
+func (task taskFile) apply(cfg config) config {
+	if task.Model != "" {
+		cfg.Model = task.Model
+	}
+	cfg.Temperature = 0
+	if task.Temperature != nil {
+		cfg.Temperature = *task.Temperature
+	}
+	seed := defaultTaskSeed
+	if task.Seed != nil {
+		seed = *task.Seed
+	}
+	cfg.Seed = &seed
+	return cfg
+}
+
+// runDir picks a fresh output directory named after the task and start time.
+func runDir(base, name string, started time.Time) string {
+	return filepath.Join(base, slugify(name)+"-"+started.Format("20060102-150405"))
+}
+
+// runLog appends JSON lines to log.jsonl, buffering streamed tokens into a
+// single assistant entry per response. Secrets are masked on the way out.
+type runLog struct {
+	enc      *json.Encoder
+	pending  strings.Builder
+	redactor *redactor
+}
+
+func (l *runLog) write(entry runLogEntry) {
+	l.flush()
+	entry.At = time.Now().UTC()
+	entry.Text, _ = l.redactor.redact(entry.Text)
+	entry.Args, _ = l.redactor.redact(entry.Args)
+	entry.Error, _ = l.redactor.redact(entry.Error)
+	l.enc.Encode(entry)
+}
+
+func (l *runLog) flush() {
+	if l.pending.Len() == 0 {
+		return
+	}
+	text, _ := l.redactor.redact(l.pending.String())
+	l.pending.Reset()
+	l.enc.Encode(runLogEntry{At: time.Now().UTC(), Type: "assistant", Text: text})
+}
+
+func runTaskCommand(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var cfg config
+	registerConfigFlags(fs, &cfg)
+	outBase := fs.String("out", defaultRunsDir, "Directory that receives one subdirectory per run")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(stderr, "usage: codybot run [flags] <task.yaml>")
+		return 2
+	}
+	// The task path is relative to where codybot was started, not --workspace.
+	taskPath, err := filepath.Abs(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(stderr, "codybot run: %v\n", err)
+		return 2
+	}
+	root, err := enterWorkspace(cfg)
+	if err == nil {
+		err = requireTrust(cfg, root)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "codybot run: %v\n", err)
+		return 1
+	}
+	task, err := loadTaskFile(taskPath)
+	if err != nil {
+		fmt.Fprintf(stderr, "codybot run: %v\n", err)
+		return 2
+	}
+	cfg = task.apply(cfg.normalized())
+	if err := checkHistoryPolicy(cfg.HistoryPolicy); err != nil {
+		fmt.Fprintf(stderr, "codybot run: %v\n", err)
+		return 2
+	}
+	if err := checkSandbox(cfg.Sandbox); err != nil {
+		fmt.Fprintf(stderr, "codybot run: %v\n", err)
+		return 2
+	}
+	if err := checkRequestExtras(cfg); err != nil {
+		fmt.Fprintf(stderr, "codybot run: %v\n", err)
+		return 2
+	}
+	if err := checkCommandPolicy(cfg.CommandPolicy); err != nil {
+		fmt.Fprintf(stderr, "codybot run: %v\n", err)
+		return 2
+	}
+	if err := cfg.attachTokenSource(); err != nil {
+		fmt.Fprintf(stderr, "codybot run: %v\n", err)
+		return 1
+	}
+
+	var registry *toolRegistry
+	if task.Tools == nil || len(task.Tools) > 0 {
+		registry = newToolRegistry(cfg)
+		if task.Tools != nil {
+			if err := registry.restrict(task.Tools, "the tools list in "+filepath.Base(taskPath)); err != nil {
+				fmt.Fprintf(stderr, "codybot run: %s: tools: %v\n", taskPath, err)
+				return 2
+			}
+		}
+	}
+	defer registry.close()
+	var attached []attachment
+	for _, path := range task.Context {
+		att, err := loadAttachment(path)
+		if err != nil {
+			fmt.Fprintf(stderr, "codybot run: %s: context: %v\n", taskPath, err)
+			return 1
+		}
+		attached = append(attached, att)
+	}
+
+	journal, err := openEditJournal(journalFileName)
+	if err != nil {
+		fmt.Fprintf(stderr, "warning: edit journal not loaded: %v\n", err)
+	}
+	if report := journal.recoveryReport(); report != "" {
+		fmt.Fprintf(stderr, "codybot run: %s\nStart codybot and resolve it with /recover first.\n", report)
+		return 1
+	}
+
+	started := time.Now()
+	dir := runDir(*outBase, task.Name, started)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		fmt.Fprintf(stderr, "codybot run: %v\n", err)
+		return 1
+	}
+	if data, err := os.ReadFile(taskPath); err == nil {
+		os.WriteFile(filepath.Join(dir, "task.yaml"), data, 0o644)
+	}
+	logFile, err := os.Create(filepath.Join(dir, "log.jsonl"))
+	if err != nil {
+		fmt.Fprintf(stderr, "codybot run: %v\n", err)
+		return 1
+	}
+	defer logFile.Close()
+	log := &runLog{enc: json.NewEncoder(logFile), redactor: logRedactor()}
+
+	report := runReport{
+		Task:        task.Name,
+		Provider:    cfg.Provider,
+		Model:       cfg.Model,
+		Temperature: cfg.Temperature,
+		Seed:        cfg.Seed,
+		Tools:       []string{},
+		StartedAt:   started.UTC(),
+		Files:       []string{},
+		Checks:      []checkResult{},
+	}
+	if registry != nil {
+		report.Tools = registry.names()
+	}
+	if out, err := runShellCommand(context.Background(), ".", "git rev-parse HEAD"); err == nil {
+		report.Revision = strings.TrimSpace(out)
+	}
+	fmt.Fprintf(stdout, "==> %s (%s, temperature %g, seed %d)\n", task.Name, cfg.Model, cfg.Temperature, *cfg.Seed)
+
+	timeout, _ := parseTaskDuration(task.Budget.Timeout, 0)
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	if timeout > 0 {
+		var stop context.CancelFunc
+		ctx, stop = context.WithTimeoutCause(ctx, timeout, fmt.Errorf("budget exceeded: timeout %s", timeout))
+		defer stop()
+	}
+
+	cfg.AgentPath = resolveAgentPath(cfg.AgentPath)
+	agentContent := mergeAgentFiles(loadAgentFiles(cfg.AgentPath), cfg.AgentPath)
+	prompt := task.Prompt + attachmentContext(attached)
+	history := []message{
+		{Role: "system", Content: buildSystemPrompt(cfg, agentContent)},
+		{Role: "user", Content: prompt},
+	}
+	log.write(runLogEntry{Type: "user", Text: prompt})
+	env := &toolEnv{journal: journal, tests: newTestLoop(cfg.TestCommand, cfg.TestAttempts), sandbox: registry.commandSandbox(), turn: 1, prompt: task.Name}
+	output := 0
+	history, answer, runErr := runAgentLoop(ctx, cfg, registry, env, history, func(ev agentEvent) {
+		switch {
+		case ev.token != "":
+			log.pending.WriteString(ev.token)
+			output += estimateTokens(ev.token)
+			if task.Budget.OutputTokens > 0 && output > task.Budget.OutputTokens {
+				cancel(fmt.Errorf("budget exceeded: %d output tokens", task.Budget.OutputTokens))
+			}
+		case ev.info != "":
+			log.write(runLogEntry{Type: "info", Text: ev.info})
+		case ev.call != nil:
+			report.ToolCalls++
+			log.write(runLogEntry{Type: "tool_call", Tool: ev.call.Function.Name, Args: ev.call.Function.Arguments})
+			fmt.Fprintf(stdout, "    [tool] %s\n", ev.call.summary())
+			if task.Budget.ToolCalls > 0 && report.ToolCalls > task.Budget.ToolCalls {
+				cancel(fmt.Errorf("budget exceeded: %d tool calls", task.Budget.ToolCalls))
+			}
+		case ev.result != nil:
+			entry := runLogEntry{Type: "tool_result", Tool: ev.result.call.Function.Name, Text: ev.result.output}
+			if ev.result.err != nil {
+				entry.Error = ev.result.err.Error()
+			}
+			log.write(entry)
+		}
+	})
+	log.flush()
+	journal.closeTurn()
+	if cause := context.Cause(ctx); runErr != nil && cause != nil && !errors.Is(cause, context.Canceled) {
+		runErr = cause
+	}
+	report.Answer = strings.TrimSpace(answer)
+	for i, msg := range history {
+		if msg.Role == "assistant" {
+			report.InputTokens += historyTokens(history[:i])
+			report.OutputTokens += estimateTokens(msg.Content)
+		}
+	}
+	report.Cost = cfg.cost(turnUsage{input: report.InputTokens, output: report.OutputTokens})
+
+	patch, files := journal.sessionDiff()
+	report.Files = append(report.Files, files...)
+	if err := os.WriteFile(filepath.Join(dir, "diff.patch"), []byte(patch), 0o644); err != nil {
+		fmt.Fprintf(stderr, "codybot run: %v\n", err)
+	}
+
+	report.Status = "passed"
+	if runErr != nil {
+		report.Status, report.Error = "error", runErr.Error()
+		log.write(runLogEntry{Type: "error", Error: runErr.Error()})
+		fmt.Fprintf(stderr, "codybot run: %v\n", runErr)
+	} else {
+		for _, check := range task.Checks {
+			result := runTaskCheck(env, check)
+			report.Checks = append(report.Checks, result)
+			log.write(runLogEntry{Type: "check", Text: result.Name, Passed: &result.Passed})
+			mark := "ok  "
+			if !result.Passed {
+				mark = "FAIL"
+				report.Status = "failed"
+			}
+			fmt.Fprintf(stdout, "    [%s] %s (%s)\n", mark, result.Name, formatSeconds(time.Duration(result.Duration*float64(time.Second))))
+		}
+	}
+	report.Duration = time.Since(started).Seconds()
+
+	data, _ := json.MarshalIndent(report, "", "  ")
+	if err := os.WriteFile(filepath.Join(dir, "report.json"), append(data, '\n'), 0o644); err != nil {
+		fmt.Fprintf(stderr, "codybot run: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(stdout, "%s: %d file(s) changed, %d tool call(s), results in %s\n", report.Status, len(report.Files), report.ToolCalls, dir)
+	if report.Status != "passed" {
+		return 1
+	}
+	return 0
+}
+
+// runTaskCheck runs a check like a tool, so it runs in the sandbox too: it
+// exercises what the agent changed.
+func runTaskCheck(env *toolEnv, check taskCheck) checkResult {
+	timeout, _ := parseTaskDuration(check.Timeout, defaultCheckLimit)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	start := time.Now()
+	out, err := env.runCommand(ctx, check.Run)
+	result := checkResult{Name: check.Name, Command: check.Run, Passed: err == nil, Duration: time.Since(start).Seconds()}
+	if err != nil {
+		result.Output = tailLines(out, maxCheckOutput)
+		if ctx.Err() != nil {
+			result.Output += fmt.Sprintf("\n(timed out after %s)", timeout)
+		}
+	}
+	return result
+}
+
+// sessionDiff combines every edit this journal session made into one patch,
+// from each file's first recorded content to its last.
+func (j *editJournal) sessionDiff() (string, []string) {
+	checkpoints, _ := j.snapshot()
+	first := map[string]fileVersion{}
+	last := map[string]fileVersion{}
+	for _, cp := range checkpoints {
+		if cp.Session != j.session {
+			continue
+		}
+		for _, v := range cp.Changes {
+			if _, ok := first[v.Path]; !ok {
+				first[v.Path] = v
+			}
+			last[v.Path] = v
+		}
+	}
+	paths := make([]string, 0, len(first))
+	for path := range first {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	var b strings.Builder
+	for _, path := range paths {
+		b.WriteString(unifiedDiff(path, first[path].Before, last[path].After, first[path].Existed))
+	}
+	return b.String(), paths
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// safeModeNote opens the transcript in safe mode, so it is clear why tools,
+// agents.md, and sessions are missing.
+const safeModeNote = "Safe mode: tools are off, and nothing is saved (no sessions, edit journal, or activity log). Environment settings, agents.md, profiles, and the trust store are not read; flags on the command line still apply. Restart without --safe to get them back."
+
+// safeRequested reports whether args ask for safe mode. It runs before the
+// flags are registered, because the environment must be hidden from their
+// defaults.
+func safeRequested(args []string) bool {
+	for _, arg := range args {
+		if arg == "--" {
+			return false
+		}
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !strings.HasPrefix(arg, "-") || name != "safe" {
+			continue
+		}
+		if !hasValue {
+			return true
+		}
+		on, err := strconv.ParseBool(value)
+		return err == nil && on
+	}
+	return false
+}
+
+// safeMode turns off everything that runs code or reads state beyond the
+// chat itself: a known-good setup for telling a codybot bug from a broken
+// plugin, policy, or saved file.
+func (cfg config) safeMode() config {
+	cfg.NoTools = true
+	cfg.TestCommand = ""
+	cfg.LSP = "off"
+	cfg.Profile = ""
+	cfg.Resume = ""
+	cfg.Output = ""
+	cfg.ControlSocket = ""
+	cfg.Web = ""
+	cfg.Trust = false
+	cfg.WarmStandby = false
+	cfg.CacheTTL = 0
+	return cfg
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	sandboxNone   = "none"
+	sandboxDocker = "docker"
+	sandboxPodman = "podman"
+
+	defaultSandboxImage = "debian:stable-slim"
+	// sandboxMarker is set in the environment of every command run in the
+	// container, so a cancelled command's whole process tree can be found
+	// and killed: the container runtime leaves it running when its exec
+	// client is killed.
+	sandboxMarker = "CODYBOT_EXEC"
+)
+
+var sandboxNames = []string{sandboxNone, sandboxDocker, sandboxPodman}
+
+func checkSandbox(name string) error {
+	switch name {
+	case "", sandboxNone:
+		return nil
+	case sandboxDocker, sandboxPodman:
+		if runtime.GOOS == "windows" {
+			return fmt.Errorf("--sandbox %s: not supported on Windows; run codybot inside WSL instead", name)
+		}
+		return nil
+	}
+	return fmt.Errorf("--sandbox: unknown backend %q; use one of %s", name, strings.Join(sandboxNames, ", "))
+}
+
+// sandbox runs the shell and test tools in a container of the project: the
+// workspace is mounted at its own path, nothing else of the host is, and
+// there is no network unless --sandbox-network. The container is started on
+// the first command and kept for the rest, so build caches inside it last
+// the session. A nil sandbox runs commands on the host.
+type sandbox struct {
+	runtime string
+	image   string
+	network bool
+	root    string
+	name    string
+
+	mu sync.Mutex
+	// started is set when this process started the container, and so stops
+	// it on close; a container another codybot in the same workspace
+	// started is left to that one.
+	started bool
+}
+
+func newSandbox(cfg config) *sandbox {
+	if cfg.Sandbox != sandboxDocker && cfg.Sandbox != sandboxPodman {
+		return nil
+	}
+	root, err := workspaceRoot()
+	if err != nil {
+		root = "."
+	}
+	image := cfg.SandboxImage
+	if image == "" {
+		image = defaultSandboxImage
+	}
+	// The name covers everything the container was created with, so a
+	// different image or network setting never reuses it.
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%s\x00%t\x00%s", root, image, cfg.SandboxNetwork, strings.Join(protectedDirs, ":ro,"))))
+	name := "codybot-" + slugify(filepath.Base(root)) + "-" + hex.EncodeToString(sum[:4])
+	return &sandbox{runtime: cfg.Sandbox, image: image, network: cfg.SandboxNetwork, root: root, name: name}
+}
+
+// run runs command through sh in the container, from the working directory,
+// and returns the combined output like runShellCommand.
+func (s *sandbox) run(ctx context.Context, command string) (string, error) {
+	if s == nil {
+		return runShellCommand(ctx, ".", command)
+	}
+	if err := s.start(ctx); err != nil {
+		return "", fmt.Errorf("sandbox: %w", err)
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	id := strconv.FormatInt(time.Now().UnixNano(), 36)
+	cmd := exec.CommandContext(ctx, s.runtime, "exec", "-w", dir, "-e", sandboxMarker+"="+id, s.name, "sh", "-c", command)
+	cmd.WaitDelay = shellWaitDelay
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	err = cmd.Run()
+	if ctx.Err() != nil {
+		s.kill(id)
+	}
+	return out.String(), err
+}
+
+// runCommand runs a shell or test tool's command, in the sandbox when there
+// is one.
+func (env *toolEnv) runCommand(ctx context.Context, command string) (string, error) {
+	if env == nil {
+		return runShellCommand(ctx, ".", command)
+	}
+	return env.sandbox.run(ctx, command)
+}
+
+// start starts the project's container unless it is already running.
+func (s *sandbox) start(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.isRunning(ctx) {
+		return nil
+	}
+	args := []string{"run", "--detach", "--rm", "--init", "--name", s.name,
+		"--label", "codybot.workspace=" + s.root,
+		"--volume", s.root + ":" + s.root, "--workdir", s.root,
+		"--env", "HOME=/tmp", "--entrypoint", "tail"}
+	// The workspace is writable, but not what the host runs without asking:
+	// git's hooks (run at /commit) and codybot's own hooks and policy. Both
+	// directories must exist, or the container could create them.
+	if err := os.MkdirAll(filepath.Join(s.root, ".codybot"), 0o755); err != nil {
+		return err
+	}
+	if _, err := os.Lstat(filepath.Join(s.root, ".git")); err != nil {
+		return fmt.Errorf("--sandbox: %s has no .git, which the container could create with hooks that run on the host; run git init there or drop --sandbox", s.root)
+	}
+	for _, dir := range protectedDirs {
+		path := filepath.Join(s.root, dir)
+		args = append(args, "--volume", path+":"+path+":ro")
+	}
+	if !s.network {
+		args = append(args, "--network", "none")
+	}
+	// Files the tools create belong to the user, not to root.
+	if s.runtime == sandboxPodman {
+		args = append(args, "--userns=keep-id")
+	} else {
+		args = append(args, "--user", fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid()))
+	}
+	args = append(args, s.image, "-f", "/dev/null")
+	output, err := exec.CommandContext(ctx, s.runtime, args...).CombinedOutput()
+	if err != nil {
+		// Another codybot in this workspace may have won the race.
+		if s.isRunning(ctx) {
+			return nil
+		}
+		if errors.Is(err, exec.ErrNotFound) {
+			return fmt.Errorf("%s is not installed; install it or drop --sandbox", s.runtime)
+		}
+		return fmt.Errorf("%s run %s: %w: %s", s.runtime, s.image, err, lastLine(string(output)))
+	}
+	s.started = true
+	return nil
+}
+
+func (s *sandbox) isRunning(ctx context.Context) bool {
+	out, err := exec.CommandContext(ctx, s.runtime, "container", "inspect", "--format", "{{.State.Running}}", s.name).Output()
+	return err == nil && strings.TrimSpace(string(out)) == "true"
+}
+
+// kill stops what is left of a cancelled command: every process in the
+// container carrying its marker.
+func (s *sandbox) kill(id string) {
+	script := fmt.Sprintf(`for p in /proc/[0-9]*; do tr '\0' '\n' 2>/dev/null < "$p/environ" | grep -qx '%s=%s' && kill -9 "${p#/proc/}" 2>/dev/null; done; true`, sandboxMarker, id)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	exec.CommandContext(ctx, s.runtime, "exec", s.name, "sh", "-c", script).Run()
+}
+
+// close stops the container if this process started it; --rm removes it.
+func (s *sandbox) close() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.started {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	exec.CommandContext(ctx, s.runtime, "stop", "--time", "2", s.name).Run()
+	s.started = false
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// chatViewportKeys are the only keys the transcript scrolls on. The
+// viewport's defaults include letters, space, and the arrows, which belong
+// to the input box.
+func chatViewportKeys() viewport.KeyMap {
+	off := key.NewBinding(key.WithDisabled())
+	return viewport.KeyMap{
+		PageDown:     key.NewBinding(key.WithKeys("pgdown")),
+		PageUp:       key.NewBinding(key.WithKeys("pgup")),
+		HalfPageUp:   off,
+		HalfPageDown: off,
+		Up:           off,
+		Down:         off,
+		Left:         off,
+		Right:        off,
+	}
+}
+
+func newChatViewport(width, height int) viewport.Model {
+	vp := viewport.New(width, height)
+	vp.KeyMap = chatViewportKeys()
+	return vp
+}
+
+// updateScrollLock locks the transcript where the user scrolled to, so new
+// output stops pulling it to the bottom, and unlocks it once they are back
+// at the bottom.
+func (m *model) updateScrollLock() {
+	m.scrollLock = !m.viewport.AtBottom()
+}
+
+// followOutput unlocks the transcript and shows its end.
+func (m *model) followOutput() {
+	m.scrollLock = false
+	m.viewport.GotoBottom()
+}
+
+// updateScrollKeys jumps to either end of the transcript: Ctrl+Home and
+// Ctrl+End always, Home and End while the input is empty, since otherwise
+// they move within its line.
+func (m *model) updateScrollKeys(msg tea.KeyMsg) bool {
+	empty := m.input.Value() == ""
+	switch msg.String() {
+	case "ctrl+home":
+	case "home":
+		if !empty {
+			return false
+		}
+	case "ctrl+end":
+		m.followOutput()
+		return true
+	case "end":
+		if !empty {
+			return false
+		}
+		m.followOutput()
+		return true
+	default:
+		return false
+	}
+	m.count(usageScrollUp)
+	m.viewport.GotoTop()
+	m.updateScrollLock()
+	return true
+}
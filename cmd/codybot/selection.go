@@ -0,0 +1,358 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/x/ansi"
+)
+
+// Selection mode moves a cursor over the rendered transcript and copies from
+// it without the terminal's own selection, which picks up the pane borders
+// and hard-breaks wrapped lines.
+
+type textPos struct {
+	line, col int
+}
+
+func (p textPos) before(q textPos) bool {
+	return p.line < q.line || p.line == q.line && p.col < q.col
+}
+
+type selectState struct {
+	active    bool
+	selecting bool
+	cursor    textPos
+	anchor    textPos
+	lines     []plainLine
+}
+
+// plainLine is one rendered transcript line without styling. A soft line
+// continues the previous one after a wrap; join is the text the wrap
+// replaced, usually a space, so copying restores the original line.
+type plainLine struct {
+	text []rune
+	soft bool
+	join string
+	// block is the message the line belongs to, nil between messages.
+	block *block
+}
+
+// plainLines returns the transcript at width as unstyled lines, matching the
+// viewport line for line.
+func (t *transcript) plainLines(width int) []plainLine {
+	var lines []plainLine
+	for _, b := range t.blocks {
+		rendered := strings.Split(strings.TrimSuffix(stripANSI(b.render(width)), "\n\n"), "\n")
+		block := b.wrappedLines(width)
+		if len(block) != len(rendered) {
+			block = block[:0]
+			for _, line := range rendered {
+				block = append(block, plainLine{text: []rune(line)})
+			}
+		}
+		for i := range block {
+			block[i].block = b
+		}
+		lines = append(lines, block...)
+		lines = append(lines, plainLine{})
+	}
+	return append(lines, plainLine{})
+}
+
+// wrappedLines wraps each line of the block on its own to learn which
+// rendered lines are continuations.
+func (b *block) wrappedLines(width int) []plainLine {
+	if b.kind == blockImage && !b.folded || width <= 0 {
+		return nil
+	}
+	var lines []plainLine
+	for _, logical := range strings.Split(b.body(), "\n") {
+		original := stripANSI(logical)
+		parts := strings.Split(stripANSI(ansi.Wrap(logical, width, "")), "\n")
+		pos := 0
+		for i, part := range parts {
+			line := plainLine{text: []rune(part), soft: i > 0}
+			if idx := strings.Index(original[pos:], part); idx >= 0 {
+				line.join = original[pos : pos+idx]
+				pos += idx + len(part)
+			} else if i > 0 {
+				line.join = " "
+			}
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func (m *model) cmdSelect(string) tea.Cmd {
+	m.openSelection(textPos{line: m.viewport.YOffset})
+	return nil
+}
+
+func (m *model) openSelection(at textPos) {
+	m.closeFind()
+	lines := m.transcript.plainLines(m.viewport.Width)
+	at.line = min(max(at.line, 0), len(lines)-1)
+	m.selection = selectState{active: true, cursor: at, lines: lines}
+	m.input.Blur()
+	m.refreshSelection()
+}
+
+func (m *model) closeSelection() {
+	if !m.selection.active {
+		return
+	}
+	m.selection = selectState{}
+	m.input.Focus()
+	m.setViewportContent(m.viewportContent())
+}
+
+func (m *model) refreshSelection() {
+	s := &m.selection
+	s.cursor.line = min(max(s.cursor.line, 0), len(s.lines)-1)
+	s.cursor.col = min(max(s.cursor.col, 0), max(len(s.lines[s.cursor.line].text)-1, 0))
+	m.setViewportContent(m.viewportContent())
+	if s.cursor.line < m.viewport.YOffset {
+		m.viewport.SetYOffset(s.cursor.line)
+	} else if s.cursor.line >= m.viewport.YOffset+m.viewport.Height {
+		m.viewport.SetYOffset(s.cursor.line - m.viewport.Height + 1)
+	}
+	m.notice = "Select: h/j/k/l w/b 0/$ g/G move • v start selection • y copy • e edit your message • Esc cancel"
+	if s.selecting {
+		m.notice = fmt.Sprintf("Selecting %d chars • y copy • v restart • Esc cancel", len([]rune(m.selectedText())))
+	}
+}
+
+func (m *model) updateSelectKeys(msg tea.KeyMsg) (bool, tea.Cmd) {
+	s := &m.selection
+	line := func() []rune { return s.lines[s.cursor.line].text }
+	switch msg.String() {
+	case "h", "left":
+		s.cursor.col--
+	case "l", "right":
+		s.cursor.col++
+	case "j", "down":
+		s.cursor.line++
+	case "k", "up":
+		s.cursor.line--
+	case "0", "home":
+		s.cursor.col = 0
+	case "$", "end":
+		s.cursor.col = len(line())
+	case "w":
+		s.cursor = m.nextWord(s.cursor)
+	case "b":
+		s.cursor = m.prevWord(s.cursor)
+	case "ctrl+d", "pgdown":
+		s.cursor.line += max(m.viewport.Height/2, 1)
+	case "ctrl+u", "pgup":
+		s.cursor.line -= max(m.viewport.Height/2, 1)
+	case "g":
+		s.cursor = textPos{}
+	case "G":
+		s.cursor = textPos{line: len(s.lines) - 1}
+	case "v":
+		s.selecting = true
+		s.anchor = s.cursor
+	case "y", "enter":
+		text := m.selectedText()
+		if !s.selecting {
+			text = m.lineText(s.cursor.line)
+		}
+		m.closeSelection()
+		copyText(text)
+		m.notice = fmt.Sprintf("Copied %d chars", len([]rune(text)))
+		return true, nil
+	case "e":
+		b := s.lines[s.cursor.line].block
+		if b == nil || b.kind != blockUser {
+			m.notice = "Move the cursor onto one of your messages to edit it"
+			return true, nil
+		}
+		m.closeSelection()
+		m.startEdit(b)
+		return true, nil
+	case "esc", "q":
+		m.closeSelection()
+		m.notice = ""
+		return true, nil
+	case "ctrl+c":
+		return false, nil
+	default:
+		return true, nil
+	}
+	m.refreshSelection()
+	return true, nil
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+func (m *model) runeAt(p textPos) (rune, bool) {
+	text := m.selection.lines[p.line].text
+	if p.col >= len(text) {
+		return ' ', false
+	}
+	return text[p.col], true
+}
+
+func (m *model) step(p textPos, dir int) (textPos, bool) {
+	p.col += dir
+	switch {
+	case p.col < 0:
+		if p.line == 0 {
+			return p, false
+		}
+		p.line--
+		p.col = max(len(m.selection.lines[p.line].text)-1, 0)
+	case p.col >= max(len(m.selection.lines[p.line].text), 1):
+		if p.line == len(m.selection.lines)-1 {
+			return p, false
+		}
+		p.line, p.col = p.line+1, 0
+	}
+	return p, true
+}
+
+// nextWord moves to the start of the next word, like vi's w.
+func (m *model) nextWord(p textPos) textPos {
+	r, _ := m.runeAt(p)
+	inWord := isWordRune(r)
+	for {
+		next, ok := m.step(p, 1)
+		if !ok {
+			return p
+		}
+		crossed := next.line != p.line
+		p = next
+		r, _ := m.runeAt(p)
+		if isWordRune(r) && (!inWord || crossed) {
+			return p
+		}
+		inWord = isWordRune(r)
+	}
+}
+
+// prevWord moves to the start of the current or previous word, like vi's b.
+func (m *model) prevWord(p textPos) textPos {
+	for {
+		prev, ok := m.step(p, -1)
+		if !ok {
+			return p
+		}
+		p = prev
+		if r, _ := m.runeAt(p); !isWordRune(r) {
+			continue
+		}
+		for {
+			prev, ok := m.step(p, -1)
+			if !ok || prev.line != p.line {
+				return p
+			}
+			if r, _ := m.runeAt(prev); !isWordRune(r) {
+				return p
+			}
+			p = prev
+		}
+	}
+}
+
+func (m *model) selectionRange() (textPos, textPos) {
+	s := m.selection
+	if s.cursor.before(s.anchor) {
+		return s.cursor, s.anchor
+	}
+	return s.anchor, s.cursor
+}
+
+// selectedText joins the selected lines, undoing soft wraps so wrapped
+// paragraphs copy as one line.
+func (m *model) selectedText() string {
+	from, to := m.selectionRange()
+	var b strings.Builder
+	for i := from.line; i <= to.line; i++ {
+		text := m.selection.lines[i].text
+		start, end := 0, len(text)
+		if i == from.line {
+			start = min(from.col, len(text))
+		}
+		if i == to.line {
+			end = min(to.col+1, len(text))
+		}
+		if i > from.line {
+			if line := m.selection.lines[i]; line.soft {
+				b.WriteString(line.join)
+			} else {
+				b.WriteString("\n")
+			}
+		}
+		if start < end {
+			b.WriteString(string(text[start:end]))
+		}
+	}
+	return b.String()
+}
+
+// lineText is the whole logical line under the cursor, for y without a
+// selection.
+func (m *model) lineText(index int) string {
+	start := index
+	for start > 0 && m.selection.lines[start].soft {
+		start--
+	}
+	end := index
+	for end+1 < len(m.selection.lines) && m.selection.lines[end+1].soft {
+		end++
+	}
+	var b strings.Builder
+	for i := start; i <= end; i++ {
+		if i > start {
+			b.WriteString(m.selection.lines[i].join)
+		}
+		b.WriteString(string(m.selection.lines[i].text))
+	}
+	return b.String()
+}
+
+// selectionContent renders lines with the selection and cursor drawn over
+// the plain text. Lines outside the selection keep their styling.
+func (m model) selectionContent(rendered string) string {
+	s := m.selection
+	lines := strings.Split(rendered, "\n")
+	from, to := s.cursor, s.cursor
+	if s.selecting {
+		from, to = m.selectionRange()
+	}
+	for i := from.line; i <= to.line && i < len(lines) && i < len(s.lines); i++ {
+		text := s.lines[i].text
+		start, end := 0, len(text)
+		if i == from.line {
+			start = min(from.col, len(text))
+		}
+		if i == to.line {
+			end = min(to.col+1, len(text))
+		}
+		var b strings.Builder
+		b.WriteString(string(text[:start]))
+		for j := start; j < max(end, start+1); j++ {
+			r := ' '
+			if j < len(text) {
+				r = text[j]
+			}
+			style := selectionStyle
+			if i == s.cursor.line && j == s.cursor.col {
+				style = selectionCursorStyle
+			}
+			b.WriteString(style.Render(string(r)))
+		}
+		if end < len(text) {
+			b.WriteString(string(text[end:]))
+		}
+		lines[i] = b.String()
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// maxCheckEvidence caps each tool result quoted to the check model, which is
+// meant to be small and cheap.
+const maxCheckEvidence = 4000
+
+const selfCheckPrompt = `You verify an assistant's answer against the tool results it was based on. List every claim in the answer that the tool results do not support or that contradicts them, one per line starting with "- ", quoting the claim briefly and saying what the tool results show instead. Ignore opinions, suggestions, and general knowledge. If every factual claim is supported, reply with exactly OK.`
+
+type selfCheckMsg struct {
+	answer *block
+	model  string
+	report string
+	err    error
+}
+
+// selfCheck asks the check model whether the answer that just finished is
+// backed by this turn's tool results. It returns nil when checking is off or
+// the turn used no tools, since there is nothing to check against.
+func (m *model) selfCheck(answer string) tea.Cmd {
+	checkModel := m.cfg.CheckModel
+	if checkModel == "" || strings.TrimSpace(answer) == "" {
+		return nil
+	}
+	var evidence strings.Builder
+	for i := len(m.history) - 1; i > 0 && m.history[i].Role != "user"; i-- {
+		if msg := m.history[i]; msg.Role == "tool" {
+			content, truncated := truncateRunes(msg.Content, maxCheckEvidence)
+			if truncated {
+				content += "\n(truncated)"
+			}
+			evidence.WriteString(fmt.Sprintf("--- %s result ---\n%s\n", msg.Name, content))
+		}
+	}
+	if evidence.Len() == 0 {
+		return nil
+	}
+	var target *block
+	for i := len(m.transcript.blocks) - 1; i >= 0; i-- {
+		if b := m.transcript.blocks[i]; b.kind == blockAssistant {
+			target = b
+			break
+		}
+	}
+	cfg := m.cfg
+	cfg.Model, cfg.Temperature = checkModel, 0
+	request := []message{
+		{Role: "system", Content: selfCheckPrompt},
+		{Role: "user", Content: "Tool results:\n" + evidence.String() + "\nAnswer to verify:\n" + answer},
+	}
+	return func() tea.Msg {
+		_, report, err := runAgentLoop(context.Background(), cfg, nil, nil, request, nil)
+		return selfCheckMsg{answer: target, model: checkModel, report: strings.TrimSpace(report), err: err}
+	}
+}
+
+func (m model) handleSelfCheckMsg(msg selfCheckMsg) (tea.Model, tea.Cmd) {
+	switch {
+	case msg.err != nil:
+		m.notice = fmt.Sprintf("Self-check with %s failed: %v", msg.model, msg.err)
+	case msg.report == "" || strings.EqualFold(strings.Trim(msg.report, ". "), "ok"):
+		m.notice = fmt.Sprintf("Self-check (%s): answer matches the tool results", msg.model)
+	default:
+		label := "Self-check"
+		if msg.answer != nil {
+			label = fmt.Sprintf("Self-check of #%d", msg.answer.id)
+		}
+		m.transcript.insertAfter(msg.answer, blockNote, fmt.Sprintf("%s (%s) found claims the tool results do not support:\n%s", label, msg.model, msg.report))
+		m.refreshTranscript()
+		m.notice = "Self-check flagged possible unsupported claims"
+	}
+	return m, nil
+}
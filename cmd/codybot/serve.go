@@ -0,0 +1,479 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultServeAddr   = "127.0.0.1:8765"
+	serveObserverQueue = 256
+)
+
+// serveEvent is the envelope for every event in the serve protocol. Seq
+// increases by one per event within a session, so observers can detect
+// events they missed.
+type serveEvent struct {
+	Type    string    `json:"type"`
+	Session string    `json:"session"`
+	Message string    `json:"message,omitempty"`
+	Seq     int       `json:"seq"`
+	Time    time.Time `json:"time"`
+	Data    any       `json:"data,omitempty"`
+}
+
+type messageStartData struct {
+	Role  string `json:"role"`
+	Model string `json:"model,omitempty"`
+}
+
+type tokenDeltaData struct {
+	Text string `json:"text"`
+}
+
+type toolCallData struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+type toolResultData struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Output string `json:"output,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+type messageEndData struct {
+	Role         string     `json:"role"`
+	Content      string     `json:"content"`
+	ToolCalls    []toolCall `json:"tool_calls,omitempty"`
+	FinishReason string     `json:"finish_reason"`
+}
+
+type errorData struct {
+	Message    string `json:"message"`
+	StatusCode int    `json:"status_code,omitempty"`
+}
+
+type noticeData struct {
+	Text string `json:"text"`
+}
+
+// serveSession is one conversation held in memory by the server. A session
+// runs one turn at a time; other clients can watch it through /events.
+type serveSession struct {
+	id string
+
+	mu        sync.Mutex
+	history   []message
+	busy      bool
+	seq       int
+	messages  int
+	observers map[chan serveEvent]struct{}
+}
+
+func (s *serveSession) event(typ, messageID string, data any) serveEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seq++
+	return serveEvent{Type: typ, Session: s.id, Message: messageID, Seq: s.seq, Time: time.Now().UTC(), Data: data}
+}
+
+func (s *serveSession) nextMessageID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages++
+	return "m" + strconv.Itoa(s.messages)
+}
+
+// broadcast hands ev to every observer without blocking the turn; an observer
+// whose queue is full misses the event and sees a gap in seq.
+func (s *serveSession) broadcast(ev serveEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for ch := range s.observers {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+type server struct {
+	cfg      config
+	registry *toolRegistry
+	journal  *editJournal
+	system   message
+	// token is the bearer token every request must carry, and hosts the
+	// Host header names it may arrive under; anyIP also accepts every IP
+	// address, for a server listening on all interfaces.
+	token string
+	hosts map[string]bool
+	anyIP bool
+
+	mu       sync.Mutex
+	sessions map[string]*serveSession
+	// turns numbers agent turns across all sessions, since they share one
+	// edit journal.
+	turns int
+}
+
+func newServer(cfg config, journal *editJournal, agentContent string) *server {
+	s := &server{
+		cfg:      cfg,
+		journal:  journal,
+		system:   message{Role: "system", Content: buildSystemPrompt(cfg, agentContent)},
+		sessions: map[string]*serveSession{},
+	}
+	if !cfg.NoTools {
+		s.registry = newToolRegistry(cfg)
+	}
+	return s
+}
+
+func (s *server) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/sessions", s.handleCreateSession)
+	mux.HandleFunc("GET /v1/sessions/{id}", s.handleGetSession)
+	mux.HandleFunc("POST /v1/sessions/{id}/messages", s.handleMessage)
+	mux.HandleFunc("GET /v1/sessions/{id}/events", s.handleEvents)
+	return s.guard(mux)
+}
+
+// listen binds addr and sets the token and the host names requests are
+// accepted under: the address listened on and loopback names, or when
+// listening on every interface, the machine's name and any IP address. A
+// web page can point a name it controls at 127.0.0.1, but its requests
+// still carry that name, so checking Host stops it driving the agent.
+func (s *server) listen(addr string) (net.Listener, error) {
+	secret := make([]byte, 16)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s.token = hex.EncodeToString(secret)
+	s.hosts = map[string]bool{"localhost": true}
+	host, _, _ := net.SplitHostPort(ln.Addr().String())
+	s.hosts[host] = true
+	if requested, _, err := net.SplitHostPort(addr); err == nil && requested != "" {
+		s.hosts[strings.ToLower(requested)] = true
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsUnspecified() {
+		s.anyIP = true
+		if name, err := os.Hostname(); err == nil {
+			s.hosts[strings.ToLower(name)] = true
+		}
+	}
+	return ln, nil
+}
+
+// allowedHost reports whether a request's Host header names this server.
+func (s *server) allowedHost(hostport string) bool {
+	host, _, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host = hostport
+	}
+	host = strings.ToLower(strings.TrimSuffix(strings.Trim(host, "[]"), "."))
+	if s.hosts[host] {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && (ip.IsLoopback() || s.anyIP)
+}
+
+// guard refuses requests under another Host name, then those without the
+// token, given as "Authorization: Bearer <token>" or, for EventSource
+// clients that cannot set headers, ?token=.
+func (s *server) guard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.allowedHost(r.Host) {
+			writeError(w, http.StatusForbidden, "host %q is not this server", r.Host)
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			token = r.URL.Query().Get("token")
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, "missing or wrong token; use the one codybot serve printed")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *server) session(id string) *serveSession {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sessions[id]
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, format string, args ...any) {
+	writeJSON(w, status, errorData{Message: fmt.Sprintf(format, args...)})
+}
+
+// handleCreateSession starts a session under a random id, so holding the
+// token is not enough to read or follow another client's session without
+// being given its id.
+func (s *server) handleCreateSession(w http.ResponseWriter, _ *http.Request) {
+	sess := &serveSession{history: []message{s.system}, observers: map[chan serveEvent]struct{}{}}
+	s.mu.Lock()
+	for sess.id == "" || s.sessions[sess.id] != nil {
+		id := make([]byte, 16)
+		if _, err := rand.Read(id); err != nil {
+			s.mu.Unlock()
+			writeError(w, http.StatusInternalServerError, "session id: %v", err)
+			return
+		}
+		sess.id = hex.EncodeToString(id)
+	}
+	s.sessions[sess.id] = sess
+	s.mu.Unlock()
+	writeJSON(w, http.StatusCreated, map[string]string{"id": sess.id})
+}
+
+func (s *server) handleGetSession(w http.ResponseWriter, r *http.Request) {
+	sess := s.session(r.PathValue("id"))
+	if sess == nil {
+		writeError(w, http.StatusNotFound, "no session %q", r.PathValue("id"))
+		return
+	}
+	sess.mu.Lock()
+	messages := append([]message(nil), sess.history[1:]...)
+	busy := sess.busy
+	sess.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]any{"id": sess.id, "busy": busy, "messages": messages})
+}
+
+func startSSE(w http.ResponseWriter) (http.Flusher, bool) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming unsupported")
+		return nil, false
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	return flusher, true
+}
+
+func writeSSE(w io.Writer, ev serveEvent) error {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.Seq, ev.Type, data)
+	return err
+}
+
+// handleMessage runs one agent turn and streams its events back as SSE. The
+// response ends after the turn's last message.end, or after an error event.
+func (s *server) handleMessage(w http.ResponseWriter, r *http.Request) {
+	sess := s.session(r.PathValue("id"))
+	if sess == nil {
+		writeError(w, http.StatusNotFound, "no session %q", r.PathValue("id"))
+		return
+	}
+	var body struct {
+		Content string `json:"content"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Content == "" {
+		writeError(w, http.StatusBadRequest, `expected {"content": "..."}`)
+		return
+	}
+	sess.mu.Lock()
+	if sess.busy {
+		sess.mu.Unlock()
+		writeError(w, http.StatusConflict, "session %s is already running a turn", sess.id)
+		return
+	}
+	sess.busy = true
+	history := append(sess.history, message{Role: "user", Content: body.Content})
+	sess.mu.Unlock()
+	defer func() {
+		sess.mu.Lock()
+		sess.busy = false
+		sess.mu.Unlock()
+	}()
+
+	s.mu.Lock()
+	s.turns++
+	turn := s.turns
+	s.mu.Unlock()
+
+	flusher, ok := startSSE(w)
+	if !ok {
+		return
+	}
+	emit := func(typ, messageID string, data any) {
+		ev := sess.event(typ, messageID, data)
+		writeSSE(w, ev)
+		flusher.Flush()
+		sess.broadcast(ev)
+	}
+
+	userID := sess.nextMessageID()
+	emit("message.start", userID, messageStartData{Role: "user"})
+	emit("message.end", userID, messageEndData{Role: "user", Content: body.Content, FinishReason: "stop"})
+
+	var messageID string
+	env := &toolEnv{journal: s.journal, tests: newTestLoop(s.cfg.TestCommand, s.cfg.TestAttempts), sandbox: s.registry.commandSandbox(), turn: turn, prompt: body.Content}
+	history, _, err := runAgentLoop(r.Context(), s.cfg, s.registry, env, history, func(ev agentEvent) {
+		switch {
+		case ev.start:
+			messageID = sess.nextMessageID()
+			emit("message.start", messageID, messageStartData{Role: "assistant", Model: s.cfg.Model})
+		case ev.token != "":
+			emit("token.delta", messageID, tokenDeltaData{Text: ev.token})
+		case ev.info != "":
+			emit("notice", messageID, noticeData{Text: ev.info})
+		case ev.end != nil:
+			finish := "stop"
+			if len(ev.end.ToolCalls) > 0 {
+				finish = "tool_calls"
+			}
+			emit("message.end", messageID, messageEndData{Role: "assistant", Content: ev.end.Content, ToolCalls: ev.end.ToolCalls, FinishReason: finish})
+		case ev.call != nil:
+			emit("tool.call", messageID, toolCallData{ID: ev.call.ID, Name: ev.call.Function.Name, Arguments: ev.call.Function.Arguments})
+		case ev.result != nil:
+			data := toolResultData{ID: ev.result.call.ID, Name: ev.result.call.Function.Name, Output: ev.result.output}
+			if ev.result.err != nil {
+				data.Error = ev.result.err.Error()
+			}
+			emit("tool.result", messageID, data)
+		}
+	})
+	s.journal.closeTurn()
+	sess.mu.Lock()
+	sess.history = history
+	sess.mu.Unlock()
+	if err != nil {
+		data := errorData{Message: err.Error()}
+		var apiErr *apiError
+		if errors.As(err, &apiErr) {
+			data.StatusCode = apiErr.StatusCode
+		}
+		emit("error", messageID, data)
+	}
+}
+
+// handleEvents streams every event of a session to an observer until the
+// client disconnects.
+func (s *server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	sess := s.session(r.PathValue("id"))
+	if sess == nil {
+		writeError(w, http.StatusNotFound, "no session %q", r.PathValue("id"))
+		return
+	}
+	flusher, ok := startSSE(w)
+	if !ok {
+		return
+	}
+	ch := make(chan serveEvent, serveObserverQueue)
+	sess.mu.Lock()
+	sess.observers[ch] = struct{}{}
+	sess.mu.Unlock()
+	defer func() {
+		sess.mu.Lock()
+		delete(sess.observers, ch)
+		sess.mu.Unlock()
+	}()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case ev := <-ch:
+			if err := writeSSE(w, ev); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func runServeCommand(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var cfg config
+	registerConfigFlags(fs, &cfg)
+	addr := fs.String("addr", envOrDefault("CODYBOT_SERVE_ADDR", defaultServeAddr), "Address to listen on")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	cfg = cfg.normalized()
+	if err := checkHistoryPolicy(cfg.HistoryPolicy); err != nil {
+		fmt.Fprintf(stderr, "codybot serve: %v\n", err)
+		return 2
+	}
+	if err := checkSandbox(cfg.Sandbox); err != nil {
+		fmt.Fprintf(stderr, "codybot serve: %v\n", err)
+		return 2
+	}
+	if err := checkRequestExtras(cfg); err != nil {
+		fmt.Fprintf(stderr, "codybot serve: %v\n", err)
+		return 2
+	}
+	if err := checkCommandPolicy(cfg.CommandPolicy); err != nil {
+		fmt.Fprintf(stderr, "codybot serve: %v\n", err)
+		return 2
+	}
+	root, err := enterWorkspace(cfg)
+	if err == nil {
+		err = requireTrust(cfg, root)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "codybot serve: %v\n", err)
+		return 1
+	}
+	if err := cfg.attachTokenSource(); err != nil {
+		fmt.Fprintf(stderr, "codybot serve: %v\n", err)
+		return 1
+	}
+	journal, err := openEditJournal(journalFileName)
+	if err != nil {
+		fmt.Fprintf(stderr, "warning: edit journal not loaded: %v\n", err)
+	}
+	if report := journal.recoveryReport(); report != "" {
+		fmt.Fprintf(stderr, "codybot serve: %s\nStart codybot and resolve it with /recover first.\n", report)
+		return 1
+	}
+	cfg.AgentPath = resolveAgentPath(cfg.AgentPath)
+	srv := newServer(cfg, journal, mergeAgentFiles(loadAgentFiles(cfg.AgentPath), cfg.AgentPath))
+	ln, err := srv.listen(*addr)
+	if err != nil {
+		fmt.Fprintf(stderr, "codybot serve: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(stdout, "codybot serving %s on http://%s\nAuthorization: Bearer %s\n", cfg.Model, ln.Addr(), srv.token)
+	httpServer := &http.Server{Handler: srv.routes(), ReadHeaderTimeout: 10 * time.Second}
+	if err := httpServer.Serve(ln); err != nil {
+		fmt.Fprintf(stderr, "codybot serve: %v\n", err)
+		return 1
+	}
+	return 0
+}
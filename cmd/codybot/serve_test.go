@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServeGuard(t *testing.T) {
+	tests := []struct {
+		name   string
+		listen string
+		host   string
+		auth   string
+		query  string
+		status int
+	}{
+		{name: "bearer token", listen: "127.0.0.1:0", host: "127.0.0.1:8765", auth: "Bearer TOKEN", status: http.StatusCreated},
+		{name: "query token", listen: "127.0.0.1:0", host: "localhost:8765", query: "?token=TOKEN", status: http.StatusCreated},
+		{name: "ipv6 loopback", listen: "127.0.0.1:0", host: "[::1]:8765", auth: "Bearer TOKEN", status: http.StatusCreated},
+		{name: "no token", listen: "127.0.0.1:0", host: "127.0.0.1:8765", status: http.StatusUnauthorized},
+		{name: "wrong token", listen: "127.0.0.1:0", host: "127.0.0.1:8765", auth: "Bearer nope", status: http.StatusUnauthorized},
+		{name: "token in the wrong scheme", listen: "127.0.0.1:0", host: "127.0.0.1:8765", auth: "Basic TOKEN", status: http.StatusUnauthorized},
+		{name: "rebound name", listen: "127.0.0.1:0", host: "attacker.example:8765", auth: "Bearer TOKEN", status: http.StatusForbidden},
+		{name: "other address", listen: "127.0.0.1:0", host: "192.168.1.5:8765", auth: "Bearer TOKEN", status: http.StatusForbidden},
+		{name: "every interface takes addresses", listen: "0.0.0.0:0", host: "192.168.1.5:8765", auth: "Bearer TOKEN", status: http.StatusCreated},
+		{name: "every interface still refuses names", listen: "0.0.0.0:0", host: "attacker.example", auth: "Bearer TOKEN", status: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &server{cfg: config{NoTools: true}, sessions: map[string]*serveSession{}}
+			ln, err := s.listen(tt.listen)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ln.Close()
+			if len(s.token) != 32 {
+				t.Fatalf("token %q is not 16 random bytes", s.token)
+			}
+			// TOKEN stands for the token the server made.
+			req := httptest.NewRequest(http.MethodPost, "/v1/sessions"+strings.ReplaceAll(tt.query, "TOKEN", s.token), nil)
+			req.Host = tt.host
+			if tt.auth != "" {
+				req.Header.Set("Authorization", strings.ReplaceAll(tt.auth, "TOKEN", s.token))
+			}
+			rec := httptest.NewRecorder()
+			s.routes().ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Errorf("status %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+		})
+	}
+}
+
+func TestServeSessionIDs(t *testing.T) {
+	s := &server{sessions: map[string]*serveSession{}}
+	for range 100 {
+		rec := httptest.NewRecorder()
+		s.handleCreateSession(rec, httptest.NewRequest(http.MethodPost, "/v1/sessions", nil))
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("status %d: %s", rec.Code, rec.Body)
+		}
+	}
+	for id := range s.sessions {
+		if len(id) != 32 || strings.Trim(id, "0123456789abcdef") != "" {
+			t.Errorf("session id %q is not 16 random bytes in hex", id)
+		}
+	}
+	if len(s.sessions) != 100 {
+		t.Errorf("%d sessions for 100 requests", len(s.sessions))
+	}
+}
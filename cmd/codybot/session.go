@@ -0,0 +1,322 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+const sessionSchemaVersion = 1
+
+type sessionFile struct {
+	Version int    `json:"version"`
+	ID      string `json:"id"`
+	Title   string `json:"title,omitempty"`
+	// ForkedFrom is the session /fork copied this one from.
+	ForkedFrom string `json:"forked_from,omitempty"`
+	// ImportedFrom names the conversation codybot import converted, as
+	// <format>:<id>.
+	ImportedFrom string    `json:"imported_from,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+	Model        string    `json:"model"`
+	BaseURL      string    `json:"base_url"`
+	Messages     []message `json:"messages"`
+}
+
+// sessionMigrations upgrade a decoded session document from the version in
+// the key to the next one. Add an entry here whenever sessionFile changes in
+// a way older readers cannot express, and bump sessionSchemaVersion.
+var sessionMigrations = map[int]func(doc map[string]any) error{
+	0: migrateSessionV0,
+}
+
+// migrateSessionV0 upgrades the unversioned format, a bare JSON array of
+// role/content messages, into the v1 envelope.
+func migrateSessionV0(doc map[string]any) error {
+	if _, ok := doc["messages"]; !ok {
+		return errors.New("v0 session has no messages")
+	}
+	if _, ok := doc["created_at"]; !ok {
+		doc["created_at"] = time.Time{}.Format(time.RFC3339)
+	}
+	return nil
+}
+
+// codybotHome is where sessions, credentials, and other per-user state
+// live: ~/.codybot, or %AppData%\codybot on Windows unless an older
+// ~/.codybot is already there.
+func codybotHome() string {
+	if home := strings.TrimSpace(os.Getenv("CODYBOT_HOME")); home != "" {
+		return home
+	}
+	dir, err := os.UserHomeDir()
+	if err != nil {
+		return ".codybot"
+	}
+	legacy := filepath.Join(dir, ".codybot")
+	if runtime.GOOS == "windows" {
+		if _, err := os.Stat(legacy); err != nil {
+			if config, err := os.UserConfigDir(); err == nil {
+				return filepath.Join(config, "codybot")
+			}
+		}
+	}
+	return legacy
+}
+
+func sessionsDir() string {
+	return filepath.Join(codybotHome(), "sessions")
+}
+
+// newSessionID names a new session by its start time. Tabs, /clear, and
+// /fork can start several in one second, and a session is only saved after
+// its first response, so a random suffix keeps unsaved ones apart too.
+func newSessionID() string {
+	stamp := time.Now().Format("20060102-150405")
+	for {
+		suffix := make([]byte, 3)
+		_, _ = rand.Read(suffix)
+		id := stamp + "-" + hex.EncodeToString(suffix)
+		if !fileExists(filepath.Join(sessionsDir(), id+".json")) {
+			return id
+		}
+	}
+}
+
+func saveSession(s sessionFile) error {
+	if err := os.MkdirAll(sessionsDir(), 0o755); err != nil {
+		return err
+	}
+	s.Version = sessionSchemaVersion
+	s.UpdatedAt = time.Now()
+	s.Messages, _ = logRedactor().messages(s.Messages)
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(sessionsDir(), s.ID+".json")
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func loadSession(path string) (sessionFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return sessionFile{}, err
+	}
+	session, _, err := decodeSession(data)
+	if err != nil {
+		return sessionFile{}, fmt.Errorf("%s: %w", filepath.Base(path), err)
+	}
+	if session.ID == "" {
+		session.ID = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	return session, nil
+}
+
+// decodeSession migrates raw session JSON to the current schema and reports
+// the version it was stored with.
+func decodeSession(data []byte) (sessionFile, int, error) {
+	doc, version, err := sessionDocument(data)
+	if err != nil {
+		return sessionFile{}, 0, err
+	}
+	if version > sessionSchemaVersion {
+		return sessionFile{}, version, fmt.Errorf("session schema v%d is newer than this codybot supports (v%d); upgrade codybot", version, sessionSchemaVersion)
+	}
+	for v := version; v < sessionSchemaVersion; v++ {
+		migrate, ok := sessionMigrations[v]
+		if !ok {
+			return sessionFile{}, version, fmt.Errorf("no migration from session schema v%d", v)
+		}
+		if err := migrate(doc); err != nil {
+			return sessionFile{}, version, fmt.Errorf("migrate v%d: %w", v, err)
+		}
+		doc["version"] = v + 1
+	}
+
+	normalized, err := json.Marshal(doc)
+	if err != nil {
+		return sessionFile{}, version, err
+	}
+	var session sessionFile
+	if err := json.Unmarshal(normalized, &session); err != nil {
+		return sessionFile{}, version, err
+	}
+	return session, version, nil
+}
+
+func sessionDocument(data []byte) (map[string]any, int, error) {
+	trimmed := strings.TrimSpace(string(data))
+	if strings.HasPrefix(trimmed, "[") {
+		var messages []any
+		if err := json.Unmarshal(data, &messages); err != nil {
+			return nil, 0, err
+		}
+		return map[string]any{"messages": messages}, 0, nil
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, 0, err
+	}
+	version := 0
+	if v, ok := doc["version"].(float64); ok {
+		version = int(v)
+	}
+	return doc, version, nil
+}
+
+func listSessionFiles() ([]string, error) {
+	entries, err := os.ReadDir(sessionsDir())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		paths = append(paths, filepath.Join(sessionsDir(), entry.Name()))
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+func (m *model) persistSession() {
+	if len(m.history) <= 1 || m.cfg.Safe {
+		return
+	}
+	err := saveSession(sessionFile{
+		ID:         m.sessionID,
+		Title:      m.sessionTitle,
+		ForkedFrom: m.forkedFrom,
+		CreatedAt:  m.sessionCreated,
+		Model:      m.cfg.Model,
+		BaseURL:    m.cfg.BaseURL,
+		Messages:   m.history,
+	})
+	if err != nil {
+		m.notice = fmt.Sprintf("Session not saved: %s", err)
+	}
+}
+
+func runSessionsCommand(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprintln(stderr, "usage: codybot sessions <migrate|list|show <id>>")
+		return 2
+	}
+	switch args[0] {
+	case "migrate":
+		return runSessionsMigrate(args[1:], stdout, stderr)
+	case "list":
+		return runSessionsList(stdout, stderr)
+	case "show":
+		return runSessionsShow(args[1:], stdout, stderr)
+	}
+	fmt.Fprintf(stderr, "unknown sessions command %q\n", args[0])
+	return 2
+}
+
+func runSessionsList(stdout, stderr io.Writer) int {
+	paths, err := listSessionFiles()
+	if err != nil {
+		fmt.Fprintf(stderr, "codybot: %v\n", err)
+		return 1
+	}
+	for _, path := range paths {
+		session, err := loadSession(path)
+		if err != nil {
+			fmt.Fprintf(stdout, "%s\t(unreadable: %v)\n", filepath.Base(path), err)
+			continue
+		}
+		fmt.Fprintf(stdout, "%s\t%s\t%d messages\t%s\n", session.ID, session.Model, len(session.Messages), sessionLabel(session))
+	}
+	return 0
+}
+
+func runSessionsShow(args []string, stdout, stderr io.Writer) int {
+	if len(args) != 1 {
+		fmt.Fprintln(stderr, "usage: codybot sessions show <id>")
+		return 2
+	}
+	session, err := loadSession(filepath.Join(sessionsDir(), filepath.Base(args[0])+".json"))
+	if err != nil {
+		fmt.Fprintf(stderr, "codybot: %v\n", err)
+		return 1
+	}
+	fmt.Fprint(stdout, formatSession(session))
+	return 0
+}
+
+func runSessionsMigrate(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("sessions migrate", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	dryRun := fs.Bool("dry-run", false, "Report what would change without writing")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	paths, err := listSessionFiles()
+	if err != nil {
+		fmt.Fprintf(stderr, "codybot: %v\n", err)
+		return 1
+	}
+	failed := 0
+	migrated := 0
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Fprintf(stderr, "%s: %v\n", path, err)
+			failed++
+			continue
+		}
+		session, version, err := decodeSession(data)
+		if err != nil {
+			fmt.Fprintf(stderr, "%s: %v\n", path, err)
+			failed++
+			continue
+		}
+		if version == sessionSchemaVersion {
+			continue
+		}
+		fmt.Fprintf(stdout, "%s: v%d -> v%d\n", filepath.Base(path), version, sessionSchemaVersion)
+		migrated++
+		if *dryRun {
+			continue
+		}
+		if session.ID == "" {
+			session.ID = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		}
+		if err := os.WriteFile(path+fmt.Sprintf(".v%d.bak", version), data, 0o600); err != nil {
+			fmt.Fprintf(stderr, "%s: backup failed: %v\n", path, err)
+			failed++
+			continue
+		}
+		if err := saveSession(session); err != nil {
+			fmt.Fprintf(stderr, "%s: %v\n", path, err)
+			failed++
+		}
+	}
+	fmt.Fprintf(stdout, "%d session(s) migrated, %d failed\n", migrated, failed)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestDecodeSession(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		version  int
+		id       string
+		messages int
+		err      string
+	}{
+		{
+			name:     "v0 bare array",
+			data:     `[{"role": "user", "content": "hi"}, {"role": "assistant", "content": "hello"}]`,
+			messages: 2,
+		},
+		{
+			name:     "v0 object",
+			data:     `{"id": "old", "messages": [{"role": "user", "content": "hi"}]}`,
+			id:       "old",
+			messages: 1,
+		},
+		{
+			name:     "v0 empty array",
+			data:     ` []`,
+			messages: 0,
+		},
+		{
+			name:    "v0 without messages",
+			data:    `{"id": "old"}`,
+			err:     "v0 session has no messages",
+			version: 0,
+		},
+		{
+			name:     "current",
+			data:     `{"version": 1, "id": "new", "created_at": "2024-05-01T10:00:00Z", "messages": [{"role": "user", "content": "hi"}]}`,
+			version:  1,
+			id:       "new",
+			messages: 1,
+		},
+		{
+			name:    "newer than supported",
+			data:    `{"version": 2, "id": "future", "messages": []}`,
+			version: 2,
+			err:     "newer than this codybot supports",
+		},
+		{
+			name: "not json",
+			data: `{"version": 1,`,
+			err:  "unexpected end",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session, version, err := decodeSession([]byte(tt.data))
+			if version != tt.version {
+				t.Errorf("version = %d, want %d", version, tt.version)
+			}
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("decodeSession error = %v, want one mentioning %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if session.Version != sessionSchemaVersion {
+				t.Errorf("migrated to v%d, want v%d", session.Version, sessionSchemaVersion)
+			}
+			if session.ID != tt.id {
+				t.Errorf("id = %q, want %q", session.ID, tt.id)
+			}
+			if len(session.Messages) != tt.messages {
+				t.Errorf("%d messages, want %d", len(session.Messages), tt.messages)
+			}
+		})
+	}
+}
+
+// TestSessionsMigrate runs codybot sessions migrate over one session of
+// each kind, then again to check that migrated files are left alone.
+func TestSessionsMigrate(t *testing.T) {
+	files := map[string]string{
+		"20240101-090000.json": `[{"role": "user", "content": "hi"}]`,
+		"20240102-090000.json": `{"messages": [{"role": "user", "content": "hi"}]}`,
+		"20240103-090000.json": `{"version": 1, "id": "20240103-090000", "messages": []}`,
+		"20240104-090000.json": `{"version": 9, "messages": []}`,
+	}
+	tests := []struct {
+		name   string
+		args   []string
+		code   int
+		out    []string
+		errOut string
+		wrote  bool
+	}{
+		{
+			name:   "dry run",
+			args:   []string{"--dry-run"},
+			code:   1,
+			out:    []string{"20240101-090000.json: v0 -> v1", "20240102-090000.json: v0 -> v1", "2 session(s) migrated, 1 failed"},
+			errOut: "session schema v9 is newer",
+		},
+		{
+			name:   "migrate",
+			code:   1,
+			out:    []string{"2 session(s) migrated, 1 failed"},
+			errOut: "session schema v9 is newer",
+			wrote:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CODYBOT_HOME", t.TempDir())
+			if err := os.MkdirAll(sessionsDir(), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			for name, data := range files {
+				if err := os.WriteFile(filepath.Join(sessionsDir(), name), []byte(data), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var stdout, stderr bytes.Buffer
+			if code := runSessionsMigrate(tt.args, &stdout, &stderr); code != tt.code {
+				t.Errorf("exit code %d, want %d; stderr: %s", code, tt.code, stderr.String())
+			}
+			for _, want := range tt.out {
+				if !strings.Contains(stdout.String(), want) {
+					t.Errorf("output %q lacks %q", stdout.String(), want)
+				}
+			}
+			if !strings.Contains(stderr.String(), tt.errOut) {
+				t.Errorf("stderr %q lacks %q", stderr.String(), tt.errOut)
+			}
+
+			for _, id := range []string{"20240101-090000", "20240102-090000"} {
+				path := filepath.Join(sessionsDir(), id+".json")
+				backup := fileExists(path + ".v0.bak")
+				if backup != tt.wrote {
+					t.Errorf("%s: backup written = %v, want %v", id, backup, tt.wrote)
+				}
+				data, err := os.ReadFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				session, version, err := decodeSession(data)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if want := map[bool]int{true: sessionSchemaVersion}[tt.wrote]; version != want {
+					t.Errorf("%s: stored as v%d, want v%d", id, version, want)
+				}
+				if tt.wrote && (session.ID != id || len(session.Messages) != 1) {
+					t.Errorf("%s: migrated to id %q with %d messages", id, session.ID, len(session.Messages))
+				}
+			}
+			if !tt.wrote {
+				return
+			}
+			stdout.Reset()
+			stderr.Reset()
+			runSessionsMigrate(nil, &stdout, &stderr)
+			if !strings.Contains(stdout.String(), "0 session(s) migrated, 1 failed") {
+				t.Errorf("second run: %q", stdout.String())
+			}
+		})
+	}
+}
+
+func TestLoadSessionID(t *testing.T) {
+	t.Setenv("CODYBOT_HOME", t.TempDir())
+	path := filepath.Join(t.TempDir(), "20240101-090000.json")
+	if err := os.WriteFile(path, []byte(`[{"role": "user", "content": "hi"}]`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	session, err := loadSession(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.ID != "20240101-090000" {
+		t.Errorf("id = %q, want the file name", session.ID)
+	}
+}
+
+// TestNewSessionID saves a session under every id it is given, so a repeat
+// would mean an existing session was overwritten.
+func TestNewSessionID(t *testing.T) {
+	t.Setenv("CODYBOT_HOME", t.TempDir())
+	format := regexp.MustCompile(`^\d{8}-\d{6}-[0-9a-f]{6}$`)
+	seen := map[string]bool{}
+	for range 200 {
+		id := newSessionID()
+		if !format.MatchString(id) {
+			t.Fatalf("id %q does not match %s", id, format)
+		}
+		if seen[id] {
+			t.Fatalf("id %q given out twice", id)
+		}
+		seen[id] = true
+		if err := saveSession(sessionFile{ID: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const shellWaitDelay = 2 * time.Second
+
+// shellCommand runs command through the platform shell: sh on Unix, and
+// PowerShell on Windows, preferring PowerShell 7 (pwsh) when installed.
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		shell := "powershell.exe"
+		if hasCommand("pwsh") {
+			shell = "pwsh"
+		}
+		return exec.CommandContext(ctx, shell, "-NoProfile", "-NonInteractive", "-Command", command)
+	}
+	return exec.CommandContext(ctx, "sh", "-c", command)
+}
+
+// runShellCommand runs command through the user's shell and returns the
+// combined stdout and stderr. A non-zero exit is reported as an error with the
+// output still returned, since test and build failures are the interesting
+// case.
+func runShellCommand(ctx context.Context, dir, command string) (string, error) {
+	cmd := shellCommand(ctx, command)
+	cmd.Dir = dir
+	// A cancelled command's children can hold the output pipe open; stop
+	// waiting for them shortly after the shell itself is killed.
+	cmd.WaitDelay = shellWaitDelay
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	err := cmd.Run()
+	return out.String(), err
+}
+
+// shellQuote quotes s as one argument for shellCommand's shell. Both sh and
+// PowerShell take single-quoted strings literally; they differ in how a
+// quote inside one is escaped.
+func shellQuote(s string) string {
+	if runtime.GOOS == "windows" {
+		return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+	}
+	if s == "" {
+		return "''"
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const defaultContextWindow = 8192
+
+type streamStats struct {
+	start      time.Time
+	firstToken time.Time
+	end        time.Time
+	chunks     int
+}
+
+func (s *streamStats) begin() {
+	*s = streamStats{start: time.Now()}
+}
+
+// observe records a streamed message holding chunks provider deltas; a
+// message that was not batched counts as one.
+func (s *streamStats) observe(token string, chunks int) {
+	if token == "" {
+		return
+	}
+	if s.firstToken.IsZero() {
+		s.firstToken = time.Now()
+	}
+	s.chunks += max(chunks, 1)
+}
+
+func (s *streamStats) finish() {
+	if s.end.IsZero() {
+		s.end = time.Now()
+	}
+}
+
+func (s streamStats) elapsed() time.Duration {
+	if !s.end.IsZero() {
+		return s.end.Sub(s.start)
+	}
+	return time.Since(s.start)
+}
+
+func (s streamStats) ttft() time.Duration {
+	if s.firstToken.IsZero() {
+		return 0
+	}
+	return s.firstToken.Sub(s.start)
+}
+
+// tokensPerSecond treats each streamed chunk as one token, which matches how
+// OpenAI-compatible servers (including Ollama) emit deltas closely enough for
+// a progress indicator.
+func (s streamStats) tokensPerSecond() float64 {
+	if s.firstToken.IsZero() || s.chunks < 2 {
+		return 0
+	}
+	end := s.end
+	if end.IsZero() {
+		end = time.Now()
+	}
+	window := end.Sub(s.firstToken).Seconds()
+	if window <= 0 {
+		return 0
+	}
+	return float64(s.chunks-1) / window
+}
+
+func (s streamStats) summary() string {
+	if s.start.IsZero() {
+		return ""
+	}
+	parts := []string{}
+	if s.firstToken.IsZero() {
+		parts = append(parts, fmt.Sprintf("waiting %s", formatSeconds(s.elapsed())))
+	} else {
+		parts = append(parts, "TTFT "+formatSeconds(s.ttft()))
+		if tps := s.tokensPerSecond(); tps > 0 {
+			parts = append(parts, fmt.Sprintf("%.1f tok/s", tps))
+		}
+		parts = append(parts, formatSeconds(s.elapsed()))
+	}
+	return strings.Join(parts, " • ")
+}
+
+func formatSeconds(d time.Duration) string {
+	return fmt.Sprintf("%.1fs", d.Seconds())
+}
+
+func estimateTokens(text string) int {
+	return (len(text) + 3) / 4
+}
+
+func historyTokens(history []message) int {
+	total := 0
+	for _, msg := range history {
+		total += estimateTokens(msg.Content) + 4
+		for _, call := range msg.ToolCalls {
+			total += estimateTokens(call.Function.Name + call.Function.Arguments)
+		}
+	}
+	return total
+}
+
+func contextFill(history []message, window int) int {
+	if window <= 0 {
+		return 0
+	}
+	return historyTokens(history) * 100 / window
+}
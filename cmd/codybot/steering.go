@@ -0,0 +1,98 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Steering is guidance typed while the model is working. Enter queues it
+// instead of waiting for the turn to end: it goes in after the next round of
+// tool calls, so the agent changes course mid-task, or as the next prompt if
+// the answer finishes first. Alt+Enter stops the response, keeping what it
+// wrote, and sends the guidance at once.
+
+// queueSteering takes the input as guidance for the running turn.
+func (m *model) queueSteering() {
+	text := strings.TrimSpace(m.input.Value())
+	if text == "" {
+		return
+	}
+	if strings.HasPrefix(text, "/") {
+		m.notice = "Commands wait until the response finishes"
+		return
+	}
+	m.input.Reset()
+	m.steering = append(m.steering, text)
+	m.notice = "Guidance queued for the next step • Alt+Enter stops and sends it now"
+}
+
+// interruptWithSteering stops the response and sends the queued guidance,
+// plus whatever is typed, once the stop completes.
+func (m *model) interruptWithSteering() {
+	m.queueSteering()
+	if len(m.steering) == 0 {
+		return
+	}
+	m.steerNow = true
+	m.cancelTurn()
+}
+
+func (m *model) takeSteering() string {
+	text := strings.Join(m.steering, "\n\n")
+	m.steering = nil
+	return text
+}
+
+// injectSteering adds the queued guidance to the turn after its tool results,
+// so the next request carries it. It reports false when nothing is queued.
+func (m *model) injectSteering() bool {
+	if len(m.steering) == 0 {
+		return false
+	}
+	text := m.takeSteering()
+	content := "Guidance from the user while you were working; follow it from here on:\n" + text
+	user := m.transcript.add(blockUser, text)
+	m.history = append(m.history, message{Role: "user", Content: content, Meta: &messageMeta{At: time.Now(), Tokens: estimateTokens(content)}})
+	user.history = len(m.history) - 1
+	user.meta = m.history[user.history].Meta
+	userID := m.control.nextMessageID()
+	m.control.publish(m.sessionID, "message.start", userID, messageStartData{Role: "user"})
+	m.control.publish(m.sessionID, "message.end", userID, messageEndData{Role: "user", Content: text, FinishReason: "stop"})
+	m.notice = "Guidance sent"
+	return true
+}
+
+// sendSteering starts a turn with the queued guidance, ahead of any saved
+// prompts still queued, or returns nil when there is none.
+func (m *model) sendSteering() tea.Cmd {
+	if len(m.steering) == 0 || m.state != stateChat {
+		return nil
+	}
+	text := m.takeSteering()
+	return m.send(text, text)
+}
+
+// steerAfterStop follows a stopped turn: Alt+Enter sends the guidance now,
+// while after Esc or an error it goes back in the input, ahead of anything
+// typed since, so it is not lost.
+func (m *model) steerAfterStop() tea.Cmd {
+	if m.steerNow {
+		m.steerNow = false
+		return m.sendSteering()
+	}
+	if len(m.steering) == 0 {
+		return nil
+	}
+	text := m.takeSteering()
+	if typed := strings.TrimSpace(m.input.Value()); typed != "" {
+		text += "\n\n" + typed
+	}
+	m.input.SetValue(text)
+	if m.notice != "" {
+		m.notice += " • "
+	}
+	m.notice += "Queued guidance is back in the input"
+	return nil
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/textarea"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// agentsSectionHeader starts the agents.md part of the assembled system
+// prompt; /system writes back what follows it.
+const agentsSectionHeader = "Project instructions (agents.md):\n"
+
+// systemEditedMsg brings back the prompt from $EDITOR.
+type systemEditedMsg struct {
+	text string
+	err  error
+}
+
+// cmdSystem opens the system prompt in an editor overlay. Saving replaces
+// it for this session only, until /system reset or a profile switch; writing
+// back also stores its agents.md section in agents.md for later sessions.
+func (m *model) cmdSystem(args string) tea.Cmd {
+	if m.streaming {
+		m.notice = "Wait for the current response to finish before editing the system prompt"
+		return nil
+	}
+	switch strings.TrimSpace(args) {
+	case "":
+	case "reset":
+		if m.systemOverride == "" {
+			m.notice = "The system prompt is not edited"
+			return nil
+		}
+		m.systemOverride = ""
+		m.refreshSystemPrompt()
+		m.persistSession()
+		m.notice = "System prompt reset to agents.md and the active profile"
+		return nil
+	default:
+		m.notice = "Usage: /system [reset]"
+		return nil
+	}
+	ta := textarea.New()
+	ta.ShowLineNumbers = false
+	ta.CharLimit = 0
+	ta.SetWidth(max(m.width-2, 10))
+	ta.SetHeight(max(m.height-4, 3))
+	ta.SetValue(m.system.Content)
+	ta.Focus()
+	m.systemEditor = ta
+	m.input.Blur()
+	m.state = stateSystem
+	return textarea.Blink
+}
+
+func (m model) updateSystem(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "esc":
+		m.closeSystemEditor()
+		m.notice = "System prompt unchanged"
+		return m, nil
+	case "ctrl+s":
+		m.applySystemPrompt(m.systemEditor.Value())
+		m.closeSystemEditor()
+		return m, nil
+	case "ctrl+w":
+		text := m.systemEditor.Value()
+		if err := m.writeBackAgents(text); err != nil {
+			m.lastErr = err
+			return m, nil
+		}
+		m.applySystemPrompt(text)
+		m.closeSystemEditor()
+		m.notice = "System prompt applied and saved to " + m.cfg.AgentPath
+		return m, nil
+	case "ctrl+e":
+		return m, m.editSystemExternally()
+	case "ctrl+c":
+		return m, tea.Quit
+	}
+	var cmd tea.Cmd
+	m.systemEditor, cmd = m.systemEditor.Update(msg)
+	return m, cmd
+}
+
+func (m *model) closeSystemEditor() {
+	m.state = stateChat
+	m.systemEditor.Blur()
+	m.input.Focus()
+}
+
+// applySystemPrompt makes text the live session's system prompt. Text equal
+// to the assembled prompt clears the override instead.
+func (m *model) applySystemPrompt(text string) {
+	text = strings.TrimSpace(text)
+	m.systemOverride = ""
+	m.refreshSystemPrompt()
+	if text == "" || text == strings.TrimSpace(m.system.Content) {
+		m.notice = "System prompt unchanged"
+		return
+	}
+	m.systemOverride = text
+	m.refreshSystemPrompt()
+	m.persistSession()
+	m.notice = fmt.Sprintf("System prompt updated for this session (%d chars) • /system reset restores it", len(text))
+}
+
+// writeBackAgents saves the agents.md section of an edited prompt to
+// agents.md. The rest of the prompt is built in, or comes from the profile,
+// so it is not written back.
+func (m *model) writeBackAgents(text string) error {
+	_, section, ok := strings.Cut(text, agentsSectionHeader)
+	if !ok {
+		return fmt.Errorf("the prompt has no %q section to write back; add one, or save with Ctrl+S for this session only", strings.TrimSpace(agentsSectionHeader))
+	}
+	if m.profile != nil {
+		section, _, _ = strings.Cut(section, m.profile.promptSection())
+	}
+	if inheritsAgents(m.agentFiles, m.cfg.AgentPath) {
+		// Only agents.md's own part is written; the files it inherits from
+		// are edited where they are.
+		marker := agentFileMarker(m.cfg.AgentPath)
+		if _, section, ok = strings.Cut(section, marker); !ok {
+			return fmt.Errorf("the prompt has no %q line to write back after; restore it, or save with Ctrl+S for this session only", strings.TrimSpace(marker))
+		}
+	}
+	section = strings.TrimSpace(section) + "\n"
+	if err := writeFileAtomic(m.cfg.AgentPath, []byte(section), 0o644); err != nil {
+		return fmt.Errorf("write %s: %w", m.cfg.AgentPath, err)
+	}
+	m.agentFiles = loadAgentFiles(m.cfg.AgentPath)
+	m.agentContent = mergeAgentFiles(m.agentFiles, m.cfg.AgentPath)
+	return nil
+}
+
+// editSystemExternally opens the overlay's text in $VISUAL or $EDITOR and
+// brings the result back into the overlay for review.
+func (m *model) editSystemExternally() tea.Cmd {
+	editor := strings.TrimSpace(os.Getenv("VISUAL"))
+	if editor == "" {
+		editor = strings.TrimSpace(os.Getenv("EDITOR"))
+	}
+	if editor == "" {
+		m.lastErr = errors.New("set $EDITOR or $VISUAL to edit the system prompt outside codybot")
+		return nil
+	}
+	tmp, err := os.CreateTemp("", "codybot-system-*.md")
+	if err != nil {
+		m.lastErr = err
+		return nil
+	}
+	_, err = tmp.WriteString(m.systemEditor.Value())
+	tmp.Close()
+	if err != nil {
+		os.Remove(tmp.Name())
+		m.lastErr = err
+		return nil
+	}
+	cmd := shellCommand(context.Background(), editor+" "+shellQuote(tmp.Name()))
+	return tea.ExecProcess(cmd, func(err error) tea.Msg {
+		defer os.Remove(tmp.Name())
+		if err != nil {
+			return systemEditedMsg{err: err}
+		}
+		data, err := os.ReadFile(tmp.Name())
+		return systemEditedMsg{text: string(data), err: err}
+	})
+}
+
+func (m model) handleSystemEditedMsg(msg systemEditedMsg) (tea.Model, tea.Cmd) {
+	if msg.err != nil {
+		m.lastErr = fmt.Errorf("editor: %w", msg.err)
+		return m, nil
+	}
+	m.lastErr = nil
+	m.systemEditor.SetValue(strings.TrimRight(msg.text, "\n"))
+	return m, nil
+}
+
+func (m model) viewSystem() string {
+	title := m.fitLine(headerStyle.Render("System prompt") + " " + subtleStyle.Render(fmt.Sprintf("%d chars", len(m.systemEditor.Value()))))
+	footer := "Ctrl+S applies to this session • Ctrl+W also saves the agents.md section • Ctrl+E opens $EDITOR • Esc cancels"
+	rows := []string{title, m.systemEditor.View()}
+	if m.lastErr != nil {
+		rows = append(rows, m.fitLine(errorStyle.Render("Error: "+m.lastErr.Error())))
+	}
+	return strings.Join(append(rows, m.fitLine(subtleStyle.Render(footer))), "\n")
+}
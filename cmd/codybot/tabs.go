@@ -0,0 +1,325 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/textarea"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// maxTabLabelRunes keeps a tab's title short enough for several to share
+// the header.
+const maxTabLabelRunes = 16
+
+// tabSet is the TUI's root model: chat sessions side by side, each with its
+// own history, profile, and streaming state. They share the workspace and
+// its edit journal. The first tab is the one codybot started with; it keeps
+// --output, --control-socket, and --web, and cannot be closed.
+type tabSet struct {
+	tabs   []tab
+	active int
+	nextID int
+	width  int
+	height int
+}
+
+type tab struct {
+	id    int
+	model model
+}
+
+// tabMsg carries a message back to the tab whose command produced it, so a
+// tab in the background keeps streaming while another is shown.
+type tabMsg struct {
+	tab int
+	msg tea.Msg
+}
+
+// tabRequestMsg is /tab asking the tab set to act.
+type tabRequestMsg struct {
+	args string
+}
+
+func newTabSet(first model) tabSet {
+	return tabSet{tabs: []tab{{id: 1, model: first}}, nextID: 2}
+}
+
+// wrapTabCmd tags what cmd returns with the tab it came from. Bubble Tea's
+// own messages, like quitting or running an editor, are for the program and
+// pass through; a batch is unpacked so each of its commands is tagged.
+func wrapTabCmd(id int, cmd tea.Cmd) tea.Cmd {
+	if cmd == nil {
+		return nil
+	}
+	return func() tea.Msg {
+		msg := cmd()
+		switch msg := msg.(type) {
+		case nil:
+			return nil
+		case tea.BatchMsg:
+			cmds := make(tea.BatchMsg, len(msg))
+			for i, c := range msg {
+				cmds[i] = wrapTabCmd(id, c)
+			}
+			return cmds
+		}
+		if reflect.TypeOf(msg).PkgPath() == reflect.TypeOf(tea.QuitMsg{}).PkgPath() {
+			return msg
+		}
+		return tabMsg{tab: id, msg: msg}
+	}
+}
+
+// tabKeySequences are the escape sequences terminals send for Ctrl+Tab and
+// Ctrl+Shift+Tab, which Bubble Tea reports as unknown CSI sequences.
+var tabKeySequences = map[string]int{
+	unknownCSI("27;5;9~"): 1,
+	unknownCSI("9;5u"):    1,
+	unknownCSI("27;6;9~"): -1,
+	unknownCSI("9;6u"):    -1,
+}
+
+// unknownCSI is how Bubble Tea prints an unknown CSI sequence, without the
+// leading ESC [.
+func unknownCSI(seq string) string {
+	return fmt.Sprintf("?CSI%+v?", []byte(seq))
+}
+
+// tabStep says which way a message moves between tabs: Ctrl+Tab or
+// Ctrl+PgDown forward, Ctrl+Shift+Tab or Ctrl+PgUp back, 0 for neither.
+// Many terminals send Ctrl+Tab as a plain Tab, hence the second pair.
+func tabStep(msg tea.Msg) int {
+	if key, ok := msg.(tea.KeyMsg); ok {
+		switch key.String() {
+		case "ctrl+pgdown":
+			return 1
+		case "ctrl+pgup":
+			return -1
+		}
+		return 0
+	}
+	if s, ok := msg.(fmt.Stringer); ok {
+		return tabKeySequences[s.String()]
+	}
+	return 0
+}
+
+func (t tabSet) Init() tea.Cmd {
+	return wrapTabCmd(t.tabs[0].id, t.tabs[0].model.Init())
+}
+
+func (t tabSet) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tabMsg:
+		i := t.index(msg.tab)
+		if i < 0 {
+			// The tab was closed while its command ran.
+			return t, nil
+		}
+		switch inner := msg.msg.(type) {
+		case tabRequestMsg:
+			return t.handleRequest(inner)
+		case gitStateMsg:
+			// Only the first tab polls git; every tab shows the result.
+			for j := range t.tabs {
+				t.tabs[j].model.git = gitState(inner)
+			}
+		}
+		return t, t.update(i, msg.msg)
+	case tea.WindowSizeMsg:
+		t.width, t.height = msg.Width, msg.Height
+		var cmds []tea.Cmd
+		for i := range t.tabs {
+			cmds = append(cmds, t.update(i, msg))
+		}
+		return t, tea.Batch(cmds...)
+	case controlRequestMsg:
+		return t, t.update(0, msg)
+	}
+	if state := t.tabs[t.active].model.state; state != stateSetup && state != stateTrust {
+		if key, ok := msg.(tea.KeyMsg); ok && key.String() == "ctrl+t" {
+			return t.open()
+		}
+		if step := tabStep(msg); step != 0 && len(t.tabs) > 1 {
+			return t.show((t.active + step + len(t.tabs)) % len(t.tabs)), nil
+		}
+	}
+	// Keys and whatever else the program sends, such as an editor's exit,
+	// belong to the tab on screen.
+	return t, t.update(t.active, msg)
+}
+
+func (t *tabSet) update(i int, msg tea.Msg) tea.Cmd {
+	next, cmd := t.tabs[i].model.Update(msg)
+	t.tabs[i].model = next.(model)
+	return wrapTabCmd(t.tabs[i].id, cmd)
+}
+
+func (t tabSet) index(id int) int {
+	for i, tab := range t.tabs {
+		if tab.id == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// open starts a new session in a tab after the current one, with the
+// current tab's profile.
+func (t tabSet) open() (tea.Model, tea.Cmd) {
+	from := t.tabs[t.active].model
+	m := newSessionModel(from.baseCfg, from.agentContent, stateChat, from.journal)
+	m.workspace = from.workspace
+	m.agentFiles = from.agentFiles
+	m.standby = from.standby
+	m.git = from.git
+	if from.tools == nil {
+		// Declining trust, --safe, or --no-tools holds for every tab.
+		m.tools = nil
+	}
+	if from.profile != nil {
+		m.applyProfile(from.profile)
+	}
+	m = m.applySize(t.width, t.height)
+	id := t.nextID
+	t.nextID++
+	t.active++
+	t.tabs = append(t.tabs[:t.active], append([]tab{{id: id, model: m}}, t.tabs[t.active:]...)...)
+	return t, wrapTabCmd(id, textarea.Blink)
+}
+
+func (t tabSet) show(i int) tabSet {
+	t.active = i
+	t.tabs[i].model.notice = ""
+	return t
+}
+
+// close closes the tab at i, which must not be the first.
+func (t tabSet) close(i int) (tabSet, error) {
+	m := t.tabs[i].model
+	if i == 0 {
+		return t, fmt.Errorf("the first tab cannot be closed; Esc quits codybot")
+	}
+	if m.streaming || m.compacting || m.approval != nil {
+		return t, fmt.Errorf("tab %d is busy; stop its turn with Esc first", i+1)
+	}
+	m.tools.close()
+	m.voice.close()
+	t.tabs = append(t.tabs[:i:i], t.tabs[i+1:]...)
+	if t.active >= i {
+		t.active = max(t.active-1, 0)
+	}
+	return t, nil
+}
+
+// handleRequest runs /tab in the tab on screen.
+func (t tabSet) handleRequest(req tabRequestMsg) (tea.Model, tea.Cmd) {
+	switch args := req.args; args {
+	case "":
+		t.tabs[t.active].model.appendNote(t.list())
+	case "new":
+		return t.open()
+	case "next":
+		return t.show((t.active + 1) % len(t.tabs)), nil
+	case "prev":
+		return t.show((t.active + len(t.tabs) - 1) % len(t.tabs)), nil
+	case "close":
+		closed := t.active
+		next, err := t.close(closed)
+		if err != nil {
+			t.tabs[t.active].model.lastErr = err
+			return t, nil
+		}
+		next.tabs[next.active].model.notice = fmt.Sprintf("Closed tab %d", closed+1)
+		return next, nil
+	default:
+		n, err := strconv.Atoi(args)
+		if err != nil || n < 1 || n > len(t.tabs) {
+			t.tabs[t.active].model.notice = fmt.Sprintf("Usage: /tab [new|next|prev|close|1-%d]", len(t.tabs))
+			return t, nil
+		}
+		return t.show(n - 1), nil
+	}
+	return t, nil
+}
+
+// label names a tab after its session, marking one that is answering or
+// waiting for approval.
+func (tb tab) label(n int) string {
+	name := tb.model.taskName()
+	if name == "" {
+		name = "new chat"
+	}
+	name, truncated := truncateRunes(strings.TrimSuffix(name, "…"), maxTabLabelRunes)
+	if truncated {
+		name += "…"
+	}
+	label := fmt.Sprintf("%d %s", n, name)
+	switch {
+	case tb.model.approval != nil:
+		label += " ?"
+	case tb.model.streaming:
+		label += " …"
+	}
+	return label
+}
+
+func (t tabSet) bar() string {
+	labels := make([]string, len(t.tabs))
+	for i, tb := range t.tabs {
+		if i == t.active {
+			labels[i] = headerStyle.Render("[" + tb.label(i+1) + "]")
+			continue
+		}
+		labels[i] = subtleStyle.Render(tb.label(i + 1))
+	}
+	return strings.Join(labels, " ")
+}
+
+// list is /tab's note: every tab with its model and state.
+func (t tabSet) list() string {
+	var b strings.Builder
+	b.WriteString("Tabs:")
+	for i, tb := range t.tabs {
+		state := "idle"
+		switch {
+		case tb.model.approval != nil:
+			state = "waiting for approval"
+		case tb.model.streaming:
+			state = "answering"
+		}
+		marker := " "
+		if i == t.active {
+			marker = "*"
+		}
+		fmt.Fprintf(&b, "\n %s %s  (%s, %s)", marker, tb.label(i+1), tb.model.cfg.Model, state)
+	}
+	b.WriteString("\nCtrl+T opens a tab • Ctrl+Tab or Ctrl+PgDown switches • /tab close closes this one")
+	return b.String()
+}
+
+func (t tabSet) View() string {
+	m := t.tabs[t.active].model
+	if len(t.tabs) > 1 {
+		m.tabBar = t.bar()
+	}
+	return m.View()
+}
+
+// cmdTab lists, opens, switches, and closes tabs; the tab set does the work.
+func (m *model) cmdTab(args string) tea.Cmd {
+	return func() tea.Msg {
+		return tabRequestMsg{args: strings.ToLower(args)}
+	}
+}
+
+// closeAll releases what every tab holds once the TUI exits.
+func (t tabSet) closeAll() {
+	for _, tb := range t.tabs {
+		tb.model.tools.close()
+		tb.model.voice.close()
+	}
+}
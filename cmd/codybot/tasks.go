@@ -0,0 +1,362 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+const (
+	// maxRunningTasks bounds the background tasks running at once; the rest
+	// wait their turn in order.
+	maxRunningTasks = 2
+	// maxTaskDuration replaces a tool's usual timeout when it runs as a
+	// task, since only long commands are worth backgrounding.
+	maxTaskDuration = time.Hour
+	maxTaskWait     = 60 * time.Second
+	maxTaskOutput   = 80
+)
+
+// backgroundProperty is the parameter of tools that can run as a task.
+var backgroundProperty = FunctionProperty{Type: "boolean", Description: "Run in the background and return a task ID at once; the conversation continues and check_task reports the result. Use it for commands that take minutes."}
+
+// bgTask is one tool call running, or waiting to run, in the background.
+type bgTask struct {
+	id      int
+	tool    string
+	summary string
+	queued  time.Time
+	run     func(ctx context.Context) (string, error)
+	ctx     context.Context
+	stop    context.CancelFunc
+	// done is closed once the task finishes or is cancelled.
+	done chan struct{}
+
+	// Guarded by taskQueue.mu.
+	started   time.Time
+	finished  time.Time
+	cancelled bool
+	output    string
+	err       error
+}
+
+// taskQueue runs tool calls asynchronously so a long build or test suite
+// does not hold up the conversation. Tasks start in the order they were
+// queued, at most maxRunningTasks at a time, and live for the session only.
+type taskQueue struct {
+	mu      sync.Mutex
+	next    int
+	tasks   []*bgTask
+	running int
+}
+
+func newTaskQueue() *taskQueue {
+	return &taskQueue{next: 1}
+}
+
+// start queues run as a new task and returns it at once.
+func (q *taskQueue) start(call toolCall, run func(ctx context.Context) (string, error)) *bgTask {
+	ctx, stop := context.WithTimeout(context.Background(), maxTaskDuration)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	t := &bgTask{id: q.next, tool: call.Function.Name, summary: call.summary(), queued: time.Now(), run: run, ctx: ctx, stop: stop, done: make(chan struct{})}
+	q.next++
+	q.tasks = append(q.tasks, t)
+	q.dispatch()
+	return t
+}
+
+// dispatch starts the oldest queued tasks while there are free slots. q.mu
+// must be held.
+func (q *taskQueue) dispatch() {
+	for _, t := range q.tasks {
+		if q.running >= maxRunningTasks {
+			return
+		}
+		if !t.started.IsZero() || !t.finished.IsZero() {
+			continue
+		}
+		t.started = time.Now()
+		q.running++
+		go func() {
+			output, err := t.run(t.ctx)
+			q.mu.Lock()
+			defer q.mu.Unlock()
+			q.running--
+			if t.cancelled {
+				err = errors.New("cancelled")
+			}
+			q.finish(t, output, err)
+			q.dispatch()
+		}()
+	}
+}
+
+// finish records t's result. q.mu must be held.
+func (q *taskQueue) finish(t *bgTask, output string, err error) {
+	output, _ = truncateRunes(output, maxToolOutputSize)
+	t.finished, t.output, t.err = time.Now(), output, err
+	t.stop()
+	close(t.done)
+}
+
+// cancel stops t, or drops it from the queue if it has not started,
+// reporting false when it had already finished.
+func (q *taskQueue) cancel(t *bgTask) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !t.finished.IsZero() {
+		return false
+	}
+	t.cancelled = true
+	if t.started.IsZero() {
+		q.finish(t, "", errors.New("cancelled before it started"))
+		return true
+	}
+	t.stop()
+	return true
+}
+
+func (q *taskQueue) get(id int) *bgTask {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, t := range q.tasks {
+		if t.id == id {
+			return t
+		}
+	}
+	return nil
+}
+
+// unfinished counts the tasks running or queued.
+func (q *taskQueue) unfinished() int {
+	if q == nil {
+		return 0
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n := 0
+	for _, t := range q.tasks {
+		if t.finished.IsZero() {
+			n++
+		}
+	}
+	return n
+}
+
+// cancelAll stops every unfinished task, when the session ends.
+func (q *taskQueue) cancelAll() {
+	q.mu.Lock()
+	tasks := append([]*bgTask(nil), q.tasks...)
+	q.mu.Unlock()
+	for _, t := range tasks {
+		q.cancel(t)
+	}
+}
+
+// state describes t in a word or two. q.mu must be held.
+func (t *bgTask) state() string {
+	switch {
+	case t.started.IsZero() && t.finished.IsZero():
+		return "queued"
+	case t.finished.IsZero():
+		return "running for " + time.Since(t.started).Round(time.Second).String()
+	case t.cancelled:
+		return "cancelled after " + t.finished.Sub(t.queued).Round(time.Second).String()
+	case t.err != nil:
+		return "failed after " + t.finished.Sub(t.queued).Round(time.Second).String()
+	}
+	return "finished in " + t.finished.Sub(t.queued).Round(time.Second).String()
+}
+
+// line is t's row in a task listing. q.mu must be held.
+func (t *bgTask) line() string {
+	return fmt.Sprintf("#%d %s: %s", t.id, t.summary, t.state())
+}
+
+// report is t's state and, once it is done, its output. q.mu must be held.
+func (t *bgTask) report(lines int) string {
+	var b strings.Builder
+	b.WriteString("Task " + t.line())
+	if t.err != nil {
+		fmt.Fprintf(&b, "\nerror: %v", t.err)
+	}
+	if out := strings.TrimSpace(t.output); out != "" {
+		fmt.Fprintf(&b, "\n\n%s", tailLines(out, lines))
+	}
+	return b.String()
+}
+
+// wantsBackground reports whether a call's arguments ask to run as a task.
+func wantsBackground(args json.RawMessage) bool {
+	var opts struct {
+		Background bool `json:"background"`
+	}
+	return json.Unmarshal(args, &opts) == nil && opts.Background
+}
+
+// startTask runs a call of spec as a background task, answering the model
+// with the task's ID instead of the result.
+func (r *toolRegistry) startTask(env *toolEnv, spec toolSpec, call toolCall, args json.RawMessage) toolResult {
+	if env != nil && env.staged != nil {
+		return toolResult{call: call, err: errors.New("background tasks cannot run while previewing a refactor: the staged edits are not on disk yet")}
+	}
+	taskEnv := toolEnv{background: true}
+	if env != nil {
+		taskEnv = *env
+		taskEnv.background = true
+	}
+	task := r.tasks.start(call, func(ctx context.Context) (string, error) {
+		return spec.run(ctx, &taskEnv, args)
+	})
+	output := fmt.Sprintf("Started task #%d in the background. Carry on with other work; call check_task with id %d for its result instead of running the command again.", task.id, task.id)
+	return toolResult{call: call, output: output, task: task, at: task.queued}
+}
+
+// toolCheckTask reports a background task, optionally waiting a little for
+// it to finish, or lists them all without an id.
+func (r *toolRegistry) toolCheckTask(ctx context.Context, _ *toolEnv, raw json.RawMessage) (string, error) {
+	var args struct {
+		ID   int `json:"id"`
+		Wait int `json:"wait_seconds"`
+	}
+	if err := decodeArgs(raw, &args); err != nil {
+		return "", err
+	}
+	if args.ID == 0 {
+		r.tasks.mu.Lock()
+		defer r.tasks.mu.Unlock()
+		if len(r.tasks.tasks) == 0 {
+			return "No background tasks this session.", nil
+		}
+		lines := make([]string, len(r.tasks.tasks))
+		for i, t := range r.tasks.tasks {
+			lines[i] = t.line()
+		}
+		return strings.Join(lines, "\n"), nil
+	}
+	t := r.tasks.get(args.ID)
+	if t == nil {
+		return "", fmt.Errorf("no task #%d", args.ID)
+	}
+	if wait := min(time.Duration(args.Wait)*time.Second, maxTaskWait); wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-t.done:
+		case <-timer.C:
+		case <-ctx.Done():
+		}
+	}
+	r.tasks.mu.Lock()
+	defer r.tasks.mu.Unlock()
+	report := t.report(maxTaskOutput)
+	if t.finished.IsZero() {
+		report += "\n\nStill running; check again later rather than waiting on it."
+	}
+	return report, nil
+}
+
+// taskDoneMsg tells the UI a background task finished.
+type taskDoneMsg struct {
+	task *bgTask
+}
+
+func waitTask(t *bgTask) tea.Cmd {
+	return func() tea.Msg {
+		<-t.done
+		return taskDoneMsg{task: t}
+	}
+}
+
+func (m model) handleTaskDoneMsg(msg taskDoneMsg) (tea.Model, tea.Cmd) {
+	if m.tools == nil {
+		return m, nil
+	}
+	m.tools.tasks.mu.Lock()
+	line, failed, cancelled := msg.task.line(), msg.task.err != nil, msg.task.cancelled
+	var ran time.Duration
+	if !msg.task.started.IsZero() {
+		ran = msg.task.finished.Sub(msg.task.started)
+	}
+	m.tools.tasks.mu.Unlock()
+	m.toolStats.addTime(msg.task.tool, ran, failed && !cancelled)
+	mark := "✓"
+	switch {
+	case cancelled:
+		mark = "■"
+	case failed:
+		mark = "✗"
+	}
+	m.appendNote(fmt.Sprintf("%s Task %s. /tasks %d shows its output; the model reads it with check_task.", mark, line, msg.task.id))
+	m.notice = fmt.Sprintf("Task #%d finished", msg.task.id)
+	m.control.publish(m.sessionID, "notice", "", noticeData{Text: "Task " + line})
+	m.warnSlowTools()
+	return m, nil
+}
+
+func (m *model) cmdTasks(args string) tea.Cmd {
+	if m.tools == nil {
+		m.notice = "Tools are disabled (--no-tools), so there are no background tasks"
+		return nil
+	}
+	q := m.tools.tasks
+	fields := strings.Fields(args)
+	switch {
+	case len(fields) == 0:
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		if len(q.tasks) == 0 {
+			m.notice = "No background tasks yet; the model starts them by calling run_tests or a /tool with background set"
+			return nil
+		}
+		var b strings.Builder
+		b.WriteString("Background tasks:\n")
+		for _, t := range q.tasks {
+			b.WriteString("  " + t.line() + "\n")
+		}
+		b.WriteString("/tasks <id> shows one's output; /tasks cancel <id> stops it.")
+		m.appendNote(b.String())
+	case len(fields) == 2 && fields[0] == "cancel":
+		t := m.taskArg(fields[1])
+		if t == nil {
+			return nil
+		}
+		if !q.cancel(t) {
+			m.notice = fmt.Sprintf("Task #%d already finished", t.id)
+			return nil
+		}
+		m.notice = fmt.Sprintf("Cancelling task #%d", t.id)
+	case len(fields) == 1:
+		t := m.taskArg(fields[0])
+		if t == nil {
+			return nil
+		}
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		m.appendNote(t.report(maxTaskOutput))
+	default:
+		m.notice = "Usage: /tasks [<id>|cancel <id>]"
+	}
+	return nil
+}
+
+func (m *model) taskArg(arg string) *bgTask {
+	id, err := strconv.Atoi(strings.TrimPrefix(arg, "#"))
+	if err != nil {
+		m.notice = "Usage: /tasks [<id>|cancel <id>]"
+		return nil
+	}
+	t := m.tools.tasks.get(id)
+	if t == nil {
+		m.notice = fmt.Sprintf("No task #%d • /tasks lists them", id)
+	}
+	return t
+}
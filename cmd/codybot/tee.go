@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// teeFile mirrors assistant output into a file as tokens arrive, so a long
+// generated document survives a crash and can be tailed while it streams.
+type teeFile struct {
+	path    string
+	file    *os.File
+	written int64
+}
+
+func openTee(path string, appendMode bool) (*teeFile, error) {
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if appendMode {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, err
+		}
+	}
+	f, err := os.OpenFile(path, flags, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	t := &teeFile{path: path, file: f}
+	if info, err := f.Stat(); err == nil {
+		t.written = info.Size()
+	}
+	return t, nil
+}
+
+// beginResponse separates consecutive answers with a blank line.
+func (t *teeFile) beginResponse() error {
+	if t == nil || t.written == 0 {
+		return nil
+	}
+	return t.write("\n\n")
+}
+
+func (t *teeFile) write(text string) error {
+	if t == nil {
+		return nil
+	}
+	n, err := t.file.WriteString(text)
+	t.written += int64(n)
+	return err
+}
+
+func (t *teeFile) close() error {
+	if t == nil {
+		return nil
+	}
+	return t.file.Close()
+}
+
+func (m *model) cmdTee(args string) tea.Cmd {
+	switch args {
+	case "":
+		if m.tee == nil {
+			m.notice = "Not mirroring output; /tee <path> starts"
+		} else {
+			m.notice = fmt.Sprintf("Mirroring output to %s (%s)", m.tee.path, formatBytes(m.tee.written))
+		}
+		return nil
+	case "off":
+		if m.tee == nil {
+			m.notice = "Not mirroring output"
+			return nil
+		}
+		m.notice = fmt.Sprintf("Stopped mirroring to %s (%s)", m.tee.path, formatBytes(m.tee.written))
+		if err := m.tee.close(); err != nil {
+			m.lastErr = err
+		}
+		m.tee = nil
+		return nil
+	}
+	appendMode := false
+	if rest, ok := strings.CutPrefix(args, "-a "); ok {
+		appendMode, args = true, strings.TrimSpace(rest)
+	}
+	t, err := openTee(args, appendMode)
+	if err != nil {
+		m.lastErr = err
+		return nil
+	}
+	m.tee.close()
+	m.tee = t
+	m.lastErr = nil
+	m.notice = "Mirroring assistant output to " + t.path
+	return nil
+}
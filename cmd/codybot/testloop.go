@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTestAttempts = 5
+	testTimeout         = 10 * time.Minute
+	maxFailureLines     = 30
+)
+
+// testLoop tracks run_tests attempts within one user turn so the model can
+// iterate toward green without looping forever.
+type testLoop struct {
+	mu       sync.Mutex
+	command  string
+	limit    int
+	attempts int
+	running  bool
+	passed   bool
+	failures int
+	// output is the latest run's full output, for /pane tests.
+	output string
+}
+
+func newTestLoop(command string, limit int) *testLoop {
+	if limit <= 0 {
+		limit = defaultTestAttempts
+	}
+	return &testLoop{command: command, limit: limit}
+}
+
+func (t *testLoop) reset() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.attempts, t.running, t.passed, t.failures = 0, false, false, 0
+}
+
+func (t *testLoop) lastOutput() string {
+	if t == nil {
+		return ""
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.output
+}
+
+func (t *testLoop) status() string {
+	if t == nil {
+		return ""
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch {
+	case t.attempts == 0:
+		return ""
+	case t.running:
+		return fmt.Sprintf("tests: running attempt %d/%d", t.attempts, t.limit)
+	case t.passed:
+		return fmt.Sprintf("tests: ✓ passed on attempt %d/%d", t.attempts, t.limit)
+	}
+	return fmt.Sprintf("tests: ✗ %d failing, attempt %d/%d", t.failures, t.attempts, t.limit)
+}
+
+var failurePatterns = []*regexp.Regexp{
+	regexp.MustCompile(`^\s*--- FAIL: .+`),
+	regexp.MustCompile(`^FAIL\s+\S+`),
+	regexp.MustCompile(`^\s*panic: .+`),
+	regexp.MustCompile(`^\S+\.go:\d+:(\d+:)? .+`),
+	regexp.MustCompile(`^FAILED .+`),
+	regexp.MustCompile(`^\s*(✕|×|●) .+`),
+	regexp.MustCompile(`^(ERROR|error)(\[\w+\])?: .+`),
+	regexp.MustCompile(`^\s*\d+\) .+`),
+}
+
+// parseTestFailures pulls the lines that identify failing tests or compile
+// errors out of common runners' output (go test, pytest, jest, cargo).
+func parseTestFailures(output string) []string {
+	var failures []string
+	seen := map[string]bool{}
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimRight(line, "\r")
+		for _, pattern := range failurePatterns {
+			if pattern.MatchString(line) && !seen[line] {
+				seen[line] = true
+				failures = append(failures, strings.TrimSpace(line))
+				break
+			}
+		}
+	}
+	return failures
+}
+
+func toolRunTests(ctx context.Context, env *toolEnv, _ json.RawMessage) (string, error) {
+	loop := env.tests
+	if loop == nil || loop.command == "" {
+		return "", errors.New("no test command configured (--test-command)")
+	}
+	if env.staged != nil {
+		return "", errors.New("tests cannot run while previewing a refactor: the staged edits are not on disk yet")
+	}
+	loop.mu.Lock()
+	if loop.attempts >= loop.limit {
+		loop.mu.Unlock()
+		return "", fmt.Errorf("test attempt limit (%d) reached; stop editing and report the remaining failures to the user", loop.limit)
+	}
+	loop.attempts++
+	loop.running = true
+	attempt := loop.attempts
+	loop.mu.Unlock()
+
+	timeout := testTimeout
+	if env.background {
+		timeout = maxTaskDuration
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	output, err := env.runCommand(ctx, loop.command)
+	failures := parseTestFailures(output)
+
+	loop.mu.Lock()
+	loop.running = false
+	loop.output = output
+	loop.passed = err == nil
+	loop.failures = len(failures)
+	if err != nil && loop.failures == 0 {
+		loop.failures = 1
+	}
+	loop.mu.Unlock()
+
+	if err == nil {
+		return fmt.Sprintf("Tests passed (attempt %d/%d).\n\n%s", attempt, loop.limit, tailLines(output, 20)), nil
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "Tests failed (attempt %d/%d): %v\n", attempt, loop.limit, err)
+	if len(failures) > 0 {
+		b.WriteString("\nFailures:\n")
+		for _, failure := range failures[:min(len(failures), maxFailureLines)] {
+			b.WriteString("- " + failure + "\n")
+		}
+	}
+	b.WriteString("\nOutput tail:\n")
+	b.WriteString(tailLines(output, 60))
+	if attempt >= loop.limit {
+		b.WriteString("\n\nThis was the last allowed attempt; report the remaining failures instead of editing further.")
+	}
+	return b.String(), nil
+}
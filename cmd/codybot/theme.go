@@ -0,0 +1,200 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"gopkg.in/yaml.v3"
+)
+
+// theme holds every color the TUI draws with. Values are ANSI 256 color
+// numbers ("212") or hex ("#d33682"); an empty value leaves the terminal's
+// default.
+type theme struct {
+	Name string `yaml:"-"`
+	// Base names the preset a theme file starts from; its own keys override
+	// that preset's.
+	Base string `yaml:"base"`
+
+	Accent    string `yaml:"accent"`
+	Subtle    string `yaml:"subtle"`
+	User      string `yaml:"user"`
+	Assistant string `yaml:"assistant"`
+	Tool      string `yaml:"tool"`
+	Error     string `yaml:"error"`
+	Border    string `yaml:"border"`
+	Spinner   string `yaml:"spinner"`
+	// Highlight marks diff hunk headers and dashboard bars.
+	Highlight   string `yaml:"highlight"`
+	DiffAdd     string `yaml:"diff_add"`
+	DiffRemove  string `yaml:"diff_remove"`
+	Selection   string `yaml:"selection"`
+	Match       string `yaml:"match"`
+	MatchText   string `yaml:"match_text"`
+	Current     string `yaml:"current"`
+	CurrentText string `yaml:"current_text"`
+	Chip        string `yaml:"chip"`
+	ChipText    string `yaml:"chip_text"`
+}
+
+var themePresets = map[string]theme{
+	"dark": {
+		Accent: "212", Subtle: "241", User: "111", Assistant: "212", Tool: "108", Error: "167",
+		Border: "", Spinner: "69", Highlight: "69", DiffAdd: "108", DiffRemove: "167",
+		Selection: "238", Match: "58", MatchText: "230", Current: "214", CurrentText: "16",
+		Chip: "62", ChipText: "230",
+	},
+	"light": {
+		Accent: "90", Subtle: "243", User: "25", Assistant: "90", Tool: "28", Error: "160",
+		Border: "250", Spinner: "25", Highlight: "25", DiffAdd: "28", DiffRemove: "160",
+		Selection: "254", Match: "229", MatchText: "16", Current: "214", CurrentText: "16",
+		Chip: "153", ChipText: "16",
+	},
+	// Solarized's accents read on both its dark and light backgrounds; the
+	// selection assumes the dark one.
+	"solarized": {
+		Accent: "#d33682", Subtle: "#657b83", User: "#268bd2", Assistant: "#6c71c4", Tool: "#859900", Error: "#dc322f",
+		Border: "#586e75", Spinner: "#2aa198", Highlight: "#268bd2", DiffAdd: "#859900", DiffRemove: "#dc322f",
+		Selection: "#073642", Match: "#b58900", MatchText: "#002b36", Current: "#cb4b16", CurrentText: "#fdf6e3",
+		Chip: "#268bd2", ChipText: "#fdf6e3",
+	},
+}
+
+var (
+	headerStyle           lipgloss.Style
+	subtleStyle           lipgloss.Style
+	userStyle             lipgloss.Style
+	assistantStyle        lipgloss.Style
+	toolStyle             lipgloss.Style
+	errorStyle            lipgloss.Style
+	borderStyle           lipgloss.Style
+	spinnerStyle          lipgloss.Style
+	chipStyle             lipgloss.Style
+	dashboardHeading      lipgloss.Style
+	dashboardBar          lipgloss.Style
+	findMatchStyle        lipgloss.Style
+	findCurrentStyle      lipgloss.Style
+	timelineSelectedStyle lipgloss.Style
+	diffAddStyle          lipgloss.Style
+	diffRemoveStyle       lipgloss.Style
+	diffHunkStyle         lipgloss.Style
+	selectionStyle        lipgloss.Style
+	selectionCursorStyle  lipgloss.Style
+	activeTheme           string
+)
+
+func fg(color string) lipgloss.Style {
+	return lipgloss.NewStyle().Foreground(lipgloss.Color(color))
+}
+
+// applyTheme rebuilds the shared styles from t.
+func applyTheme(t theme) {
+	activeTheme = t.Name
+	headerStyle = fg(t.Accent).Bold(true)
+	subtleStyle = fg(t.Subtle)
+	userStyle = fg(t.User).Bold(true)
+	assistantStyle = fg(t.Assistant).Bold(true)
+	toolStyle = fg(t.Tool)
+	errorStyle = fg(t.Error)
+	borderStyle = lipgloss.NewStyle().Border(lipgloss.RoundedBorder()).BorderForeground(lipgloss.Color(t.Border)).Padding(0, 1)
+	spinnerStyle = fg(t.Spinner)
+	chipStyle = fg(t.ChipText).Background(lipgloss.Color(t.Chip)).Padding(0, 1).MarginRight(1)
+	dashboardHeading = fg(t.Accent).Bold(true)
+	dashboardBar = fg(t.Highlight)
+	findMatchStyle = fg(t.MatchText).Background(lipgloss.Color(t.Match))
+	findCurrentStyle = fg(t.CurrentText).Background(lipgloss.Color(t.Current)).Bold(true)
+	timelineSelectedStyle = fg(t.Accent).Bold(true)
+	diffAddStyle = fg(t.DiffAdd)
+	diffRemoveStyle = fg(t.DiffRemove)
+	diffHunkStyle = fg(t.Highlight)
+	selectionStyle = lipgloss.NewStyle().Background(lipgloss.Color(t.Selection))
+	selectionCursorStyle = lipgloss.NewStyle().Reverse(true)
+}
+
+// darkBackground asks the terminal once, before the TUI takes over its input;
+// /theme auto reuses the answer.
+var darkBackground = sync.OnceValue(lipgloss.HasDarkBackground)
+
+func themesDir() string {
+	return filepath.Join(codybotHome(), "themes")
+}
+
+// loadTheme resolves --theme: auto picks dark or light from the terminal
+// background, a preset name selects it, and anything else is a YAML file,
+// given as a path or as a name in ~/.codybot/themes.
+func loadTheme(name string) (theme, error) {
+	if name == "" || name == "auto" {
+		name = "light"
+		if darkBackground() {
+			name = "dark"
+		}
+	}
+	if preset, ok := themePresets[name]; ok {
+		preset.Name = name
+		return preset, nil
+	}
+	path := name
+	if !strings.ContainsRune(name, filepath.Separator) && filepath.Ext(name) == "" {
+		path = filepath.Join(themesDir(), name+".yaml")
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return theme{}, fmt.Errorf("unknown theme %q; use auto, %s, or a YAML file", name, strings.Join(themeNames(), ", "))
+	}
+	if err != nil {
+		return theme{}, err
+	}
+	var header struct {
+		Base string `yaml:"base"`
+	}
+	if err := yaml.Unmarshal(data, &header); err != nil {
+		return theme{}, fmt.Errorf("theme %s: %w", path, err)
+	}
+	if header.Base == "" {
+		header.Base = "dark"
+	}
+	t, ok := themePresets[header.Base]
+	if !ok {
+		return theme{}, fmt.Errorf("theme %s: unknown base %q", path, header.Base)
+	}
+	if err := yaml.Unmarshal(data, &t); err != nil {
+		return theme{}, fmt.Errorf("theme %s: %w", path, err)
+	}
+	t.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	return t, nil
+}
+
+func themeNames() []string {
+	names := make([]string, 0, len(themePresets))
+	for name := range themePresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (m *model) cmdTheme(args string) tea.Cmd {
+	if args == "" {
+		m.notice = fmt.Sprintf("Theme %s • /theme <%s|auto|file> switches", activeTheme, strings.Join(themeNames(), "|"))
+		return nil
+	}
+	t, err := loadTheme(args)
+	if err != nil {
+		m.lastErr = err
+		return nil
+	}
+	applyTheme(t)
+	m.spinner.Style = spinnerStyle
+	m.transcript.invalidate()
+	m.refreshTranscript()
+	m.lastErr = nil
+	m.notice = "Theme " + t.Name
+	return nil
+}
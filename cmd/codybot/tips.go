@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+const (
+	// tipTurns is how many prompts a tip stays up before the next eligible
+	// one replaces it.
+	tipTurns = 3
+	// pasteTipLines is how long a paste must be to count as pasting a file.
+	pasteTipLines = 10
+)
+
+// usageEvent names a counter in tipState. The counters live in the process
+// only: nothing is saved or sent anywhere.
+type usageEvent string
+
+const (
+	usageFilePaste usageEvent = "file-paste"
+	usageClear     usageEvent = "clear"
+	usageCancel    usageEvent = "cancel"
+	usageScrollUp  usageEvent = "scroll-up"
+)
+
+// tip is one feature worth pointing out once its trigger shows the user
+// doing the thing the long way.
+type tip struct {
+	id   string
+	text string
+	when func(m *model) bool
+}
+
+func (m *model) usageCount(event usageEvent) int {
+	return m.tips.counts[event]
+}
+
+var tips = []tip{
+	{id: "mention", text: "type @path to attach a file instead of pasting it; Tab completes the path", when: func(m *model) bool { return m.usageCount(usageFilePaste) >= 3 }},
+	{id: "fork", text: "/fork copies the conversation into a new session, so you can try another approach without clearing this one", when: func(m *model) bool { return m.usageCount(usageClear) >= 3 }},
+	{id: "continue", text: "/continue resumes an answer stopped with Esc from where it stopped", when: func(m *model) bool { return m.usageCount(usageCancel) >= 2 }},
+	{id: "find", text: "Ctrl+F searches the transcript instead of scrolling back through it", when: func(m *model) bool { return m.usageCount(usageScrollUp) >= 10 }},
+	{id: "compact", text: "/compact replaces older turns with a summary to free up context", when: func(m *model) bool { return contextFill(m.history, m.cfg.ContextWindow) >= 60 }},
+	{id: "palette", text: "Ctrl+K lists every action and command", when: func(m *model) bool { return m.tips.prompts == 0 }},
+}
+
+// tipState picks the tip shown in the status line from in-process usage
+// counters. Each tip is shown at most once per session, for tipTurns prompts.
+type tipState struct {
+	off     bool
+	counts  map[usageEvent]int
+	shown   map[string]bool
+	current *tip
+	// prompts counts the prompts sent; since is the count the current tip
+	// appeared at.
+	prompts int
+	since   int
+}
+
+func newTipState(off bool) *tipState {
+	return &tipState{off: off, counts: map[usageEvent]int{}, shown: map[string]bool{}}
+}
+
+// count records one use. A tip it triggers replaces the current one at
+// once, while the habit it is about is fresh.
+func (m *model) count(event usageEvent) {
+	m.tips.counts[event]++
+	if next := m.nextTip(); next != nil && !m.tips.off {
+		m.showTip(next)
+	}
+}
+
+// rotateTip retires the current tip once it has been up for tipTurns
+// prompts, and shows the next eligible one.
+func (m *model) rotateTip() {
+	t := m.tips
+	if t.off {
+		t.current = nil
+		return
+	}
+	if t.current != nil && t.prompts-t.since < tipTurns {
+		return
+	}
+	t.current = nil
+	if next := m.nextTip(); next != nil {
+		m.showTip(next)
+	}
+}
+
+// nextTip is the first tip not yet shown whose trigger holds, or nil.
+func (m *model) nextTip() *tip {
+	for i := range tips {
+		if !m.tips.shown[tips[i].id] && tips[i].when(m) {
+			return &tips[i]
+		}
+	}
+	return nil
+}
+
+func (m *model) showTip(next *tip) {
+	m.tips.current, m.tips.since = next, m.tips.prompts
+	m.tips.shown[next.id] = true
+}
+
+// tipText is the tip for the status line, or "".
+func (m model) tipText() string {
+	if m.tips.off || m.tips.current == nil {
+		return ""
+	}
+	return "Tip: " + m.tips.current.text
+}
+
+// countPaste counts a paste long enough to be a file's contents.
+func (m *model) countPaste(msg tea.KeyMsg) {
+	if msg.Paste && strings.Count(string(msg.Runes), "\n")+1 >= pasteTipLines {
+		m.count(usageFilePaste)
+	}
+}
+
+func (m *model) cmdTips(args string) tea.Cmd {
+	switch strings.TrimSpace(args) {
+	case "off":
+		m.tips.off = true
+		m.rotateTip()
+		m.notice = "Tips off for this session (--no-tips turns them off at startup)"
+	case "on":
+		m.tips.off = false
+		m.rotateTip()
+		m.notice = "Tips on"
+	case "":
+		var b strings.Builder
+		state := "on"
+		if m.tips.off {
+			state = "off"
+		}
+		fmt.Fprintf(&b, "Tips are %s. Each appears once, when what you do suggests it; usage is counted in memory only.\n", state)
+		for _, t := range tips {
+			mark := " "
+			if m.tips.shown[t.id] {
+				mark = "✓"
+			}
+			fmt.Fprintf(&b, "  %s %s\n", mark, t.text)
+		}
+		m.appendNote(strings.TrimRight(b.String(), "\n"))
+	default:
+		m.notice = "Usage: /tips [on|off]"
+	}
+	return nil
+}
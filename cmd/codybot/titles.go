@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+const (
+	titlePrompt   = `Write a title of at most six words for the conversation below, naming its task the way a commit subject would. Reply with only the title: no quotes, no trailing period.`
+	maxTitleRunes = 60
+	maxSlugRunes  = 40
+)
+
+type sessionTitleMsg struct {
+	session string
+	title   string
+	err     error
+}
+
+// cleanTitle turns a model's reply into a one-line title.
+func cleanTitle(reply string) string {
+	title := strings.TrimSpace(firstLine(strings.TrimSpace(reply)))
+	title = strings.TrimPrefix(title, "Title:")
+	title = strings.Trim(strings.TrimSpace(title), "\"'`*#. ")
+	title, truncated := truncateRunes(title, maxTitleRunes)
+	if truncated {
+		title += "…"
+	}
+	return title
+}
+
+// sessionLabel is how a saved session is listed: its title, or for sessions
+// saved before titles existed, the start of its first prompt.
+func sessionLabel(s sessionFile) string {
+	if s.Title != "" {
+		return s.Title
+	}
+	return firstPrompt(s.Messages)
+}
+
+// slugify makes a file name fragment from free text.
+func slugify(text string) string {
+	slug := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + 'a' - 'A'
+		}
+		return '-'
+	}, text)
+	for strings.Contains(slug, "--") {
+		slug = strings.ReplaceAll(slug, "--", "-")
+	}
+	slug, _ = truncateRunes(slug, maxSlugRunes)
+	return strings.Trim(slug, "-")
+}
+
+// exportName is the default file name for exporting this session, led by
+// its title so exports are recognizable without opening them.
+func (m *model) exportName(ext string) string {
+	if slug := slugify(m.sessionTitle); slug != "" {
+		return slug + "-" + m.sessionID + ext
+	}
+	return "codybot-" + m.sessionID + ext
+}
+
+// nameSession titles the session after its first answer. The first prompt
+// stands in at once, and the model is asked for a better title in the
+// background; the check model is used when there is one, since it is cheap.
+func (m *model) nameSession() tea.Cmd {
+	if m.sessionTitle != "" || len(m.history) <= 1 {
+		return nil
+	}
+	m.sessionTitle = firstPrompt(m.history)
+	m.persistSession()
+	var transcript strings.Builder
+	for _, msg := range m.history {
+		if (msg.Role == "user" || msg.Role == "assistant") && msg.Content != "" {
+			content, _ := truncateRunes(msg.Content, 1000)
+			fmt.Fprintf(&transcript, "%s: %s\n\n", msg.Role, content)
+		}
+	}
+	cfg := m.cfg
+	if cfg.CheckModel != "" {
+		cfg.Model = cfg.CheckModel
+	}
+	cfg.Temperature = 0
+	request := []message{
+		{Role: "system", Content: titlePrompt},
+		{Role: "user", Content: transcript.String()},
+	}
+	session := m.sessionID
+	return func() tea.Msg {
+		_, reply, err := runAgentLoop(context.Background(), cfg, nil, nil, request, nil)
+		return sessionTitleMsg{session: session, title: cleanTitle(reply), err: err}
+	}
+}
+
+// handleSessionTitleMsg keeps the first-prompt title when the model could not
+// name the session, or when the user renamed or left it meanwhile.
+func (m model) handleSessionTitleMsg(msg sessionTitleMsg) (tea.Model, tea.Cmd) {
+	if msg.err != nil || msg.title == "" || msg.session != m.sessionID || m.titleSet {
+		return m, nil
+	}
+	m.sessionTitle = msg.title
+	m.persistSession()
+	return m, nil
+}
+
+func (m *model) cmdTitle(args string) tea.Cmd {
+	if args == "" {
+		if m.sessionTitle == "" {
+			m.notice = "This session has no title yet; it gets one after the first answer"
+			return nil
+		}
+		m.notice = fmt.Sprintf("Session %s: %s", m.sessionID, m.sessionTitle)
+		return nil
+	}
+	m.sessionTitle, m.titleSet = cleanTitle(args), true
+	m.persistSession()
+	m.notice = "Renamed this session to " + m.sessionTitle
+	return nil
+}
@@ -0,0 +1,536 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+const (
+	maxToolRounds     = 20
+	maxToolOutputSize = 32000
+)
+
+type toolCall struct {
+	ID       string           `json:"id"`
+	Type     string           `json:"type"`
+	Function toolCallFunction `json:"function"`
+}
+
+type toolCallFunction struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+type toolEnv struct {
+	journal *editJournal
+	tests   *testLoop
+	// sandbox, when set, runs the shell and test tools in a container.
+	sandbox *sandbox
+	// risks holds the classifications the approval already made, by call
+	// ID, so --command-policy strict does not ask the model again.
+	risks  map[string]commandRisk
+	turn   int
+	prompt string
+	// staged, when set, receives file writes instead of the disk (see
+	// /refactor-preview).
+	staged *stagedEdits
+	// background is set for calls running as a task, which get
+	// maxTaskDuration instead of the tool's usual timeout.
+	background bool
+}
+
+type toolHandler func(ctx context.Context, env *toolEnv, args json.RawMessage) (string, error)
+
+type toolSpec struct {
+	def      Tool
+	run      toolHandler
+	mutating bool
+	// source returns a citation locator (path#lines, URL) for a successful
+	// call, or "" when the result is not something an answer can cite.
+	source func(args json.RawMessage, output string) string
+	// template is the shell command behind a tool added with /tool add.
+	template string
+	// network marks tools that may reach other hosts; --offline leaves them
+	// out.
+	network bool
+	// background marks long-running tools the model may run as a task.
+	background bool
+}
+
+type toolRegistry struct {
+	specs    map[string]toolSpec
+	offline  bool
+	readOnly bool
+	// disabled names the tools a mode left out, with the flag responsible.
+	disabled map[string]string
+	// lsp backs the code intelligence tools when --lsp finds a server.
+	lsp *lspClient
+	// withheld keeps the specs --read-only and --offline left out, for
+	// /allow to grant until a deadline. commandGrants holds the command
+	// prefixes /allow exempts from the strict command policy, with theirs.
+	// grantMu guards both, which tool calls read while the UI changes them.
+	withheld      map[string]toolSpec
+	grantMu       sync.Mutex
+	grants        map[string]time.Time
+	commandGrants map[string]time.Time
+	// tasks runs the calls the model sends to the background.
+	tasks *taskQueue
+	// sandbox is the --sandbox container, started on first use.
+	sandbox *sandbox
+	// cfg classifies session tool commands under --command-policy strict.
+	cfg config
+}
+
+type toolResult struct {
+	call   toolCall
+	output string
+	source string
+	images []string
+	err    error
+	// task is set when the call started a background task.
+	task *bgTask
+	// at and duration time the call for its message's metadata.
+	at       time.Time
+	duration time.Duration
+}
+
+type toolResultsMsg struct {
+	results []toolResult
+}
+
+func newToolRegistry(cfg config) *toolRegistry {
+	r := &toolRegistry{
+		specs:         map[string]toolSpec{},
+		offline:       cfg.Offline,
+		readOnly:      cfg.ReadOnly,
+		disabled:      map[string]string{},
+		withheld:      map[string]toolSpec{},
+		grants:        map[string]time.Time{},
+		commandGrants: map[string]time.Time{},
+		tasks:         newTaskQueue(),
+		sandbox:       newSandbox(cfg),
+		cfg:           cfg,
+	}
+	r.register(toolSpec{
+		def: functionTool("read_file", "Read a text file. Optionally limit to a 1-based inclusive line range.", map[string]FunctionProperty{
+			"path":       {Type: "string", Description: "File path relative to the working directory"},
+			"start_line": {Type: "integer", Description: "First line to return"},
+			"end_line":   {Type: "integer", Description: "Last line to return"},
+		}, "path"),
+		run:    toolReadFile,
+		source: readFileSource,
+	})
+	r.register(toolSpec{
+		def: functionTool("write_file", "Create or overwrite a file with the given content.", map[string]FunctionProperty{
+			"path":    {Type: "string", Description: "File path relative to the working directory"},
+			"content": {Type: "string", Description: "Full new file content"},
+		}, "path", "content"),
+		run:      toolWriteFile,
+		mutating: true,
+	})
+	r.register(toolSpec{
+		def: functionTool("delete_file", "Delete a file. It is moved to a trash the user can restore it from.", map[string]FunctionProperty{
+			"path": {Type: "string", Description: "File path relative to the working directory"},
+		}, "path"),
+		run:      toolDeleteFile,
+		mutating: true,
+	})
+	r.register(toolSpec{
+		def: functionTool("list_dir", "List the entries of a directory.", map[string]FunctionProperty{
+			"path": {Type: "string", Description: "Directory path, defaults to the working directory"},
+		}),
+		run:    toolListDir,
+		source: listDirSource,
+	})
+	r.register(toolSpec{
+		def: functionTool("fetch_url", "Fetch a web page or text file over HTTP(S), such as documentation or a changelog. HTML is reduced to text. The project's egress policy decides which hosts are reachable.", map[string]FunctionProperty{
+			"url":     {Type: "string", Description: "http or https URL"},
+			"headers": {Type: "object", Description: "Extra request headers, name to value; credentials are only sent if the egress policy allows it"},
+		}, "url"),
+		run:     newFetcher(cfg).toolFetchURL,
+		source:  fetchURLSource,
+		network: true,
+	})
+	r.register(toolSpec{
+		def: functionTool("memorize", "Keep a fact about this project for future sessions: a convention, a decision and its reason, or a preference the user stated. Use it sparingly, for what a later session would otherwise have to rediscover; it is added to every new session's system prompt in this workspace.", map[string]FunctionProperty{
+			"fact": {Type: "string", Description: "One self-contained sentence"},
+		}, "fact"),
+		// It writes to ~/.codybot, never the workspace, so --read-only
+		// leaves it in. Facts for every project are the user's to add, with
+		// /remember --global, so a session cannot steer all the others.
+		run: memorizer{cfg}.toolMemorize,
+	})
+	if hasIndex() {
+		r.register(toolSpec{
+			def: functionTool("semantic_search", "Find code by what it does rather than by name, in the workspace's semantic index. Returns the best-matching chunks of files with their line ranges. Use it to locate where something is handled; use read_file for the surrounding code.", map[string]FunctionProperty{
+				"query": {Type: "string", Description: "What the code does, in plain words, e.g. \"retry with backoff on rate limits\""},
+				"limit": {Type: "integer", Description: "Chunks to return (default 5, at most 20)"},
+			}, "query"),
+			run: indexSearcher{cfg}.toolSemanticSearch,
+		})
+	}
+	if cfg.TestCommand != "" {
+		description := fmt.Sprintf("Run the project's test suite (%s) and get a summary of failures. Call this after editing files and keep fixing until it passes.", cfg.TestCommand)
+		if r.sandbox != nil && !r.sandbox.network {
+			description += " The tests run in a container without network access."
+		}
+		r.register(toolSpec{
+			def: functionTool("run_tests", description, map[string]FunctionProperty{
+				"background": backgroundProperty,
+			}),
+			run:        toolRunTests,
+			background: true,
+			// The test command is arbitrary shell, so --read-only leaves it
+			// out with the tools that write.
+			mutating: true,
+		})
+	}
+	r.register(toolSpec{
+		def: functionTool("check_task", "Get the state and output of a background task, or list every task without an id.", map[string]FunctionProperty{
+			"id":           {Type: "integer", Description: "Task ID returned when the task started"},
+			"wait_seconds": {Type: "integer", Description: "Wait up to this many seconds (at most 60) for the task to finish"},
+		}),
+		run: r.toolCheckTask,
+	})
+	if command := lspCommand(cfg.LSP); command != nil {
+		r.lsp = newLSPClient(command)
+		symbolProps := map[string]FunctionProperty{
+			"path":   {Type: "string", Description: "File path relative to the working directory"},
+			"line":   {Type: "integer", Description: "1-based line the symbol appears on"},
+			"symbol": {Type: "string", Description: "The identifier on that line, exactly as written"},
+		}
+		r.register(toolSpec{
+			def: functionTool("go_to_definition", "Find where the symbol used on a line is defined, using the project's language server. More precise than searching for the name.", symbolProps, "path", "line", "symbol"),
+			run: r.lsp.toolDefinition,
+		})
+		r.register(toolSpec{
+			def: functionTool("find_references", "List every reference to the symbol on a line across the project, using the project's language server. Use it before renaming or changing a signature.", symbolProps, "path", "line", "symbol"),
+			run: r.lsp.toolReferences,
+		})
+		r.register(toolSpec{
+			def: functionTool("diagnostics", "Get the language server's compile errors and warnings for a file, reflecting your latest edits. Without a path, lists those already reported.", map[string]FunctionProperty{
+				"path": {Type: "string", Description: "File path relative to the working directory"},
+			}),
+			run: r.lsp.toolDiagnostics,
+		})
+	}
+	return r
+}
+
+// close cancels the background tasks still running and stops the language
+// server, if one was started.
+func (r *toolRegistry) close() {
+	if r == nil {
+		return
+	}
+	r.tasks.cancelAll()
+	if r.lsp != nil {
+		r.lsp.close()
+	}
+	r.sandbox.close()
+}
+
+// commandSandbox is the registry's sandbox, or nil for no registry: without
+// tools, commands run on the host.
+func (r *toolRegistry) commandSandbox() *sandbox {
+	if r == nil {
+		return nil
+	}
+	return r.sandbox
+}
+
+func functionTool(name, description string, props map[string]FunctionProperty, required ...string) Tool {
+	if required == nil {
+		required = []string{}
+	}
+	return Tool{
+		Type: "function",
+		Function: &FunctionDefinition{
+			Name:        name,
+			Description: description,
+			Parameters: &FunctionParameters{
+				Type:       "object",
+				Properties: props,
+				Required:   required,
+			},
+		},
+	}
+}
+
+func (r *toolRegistry) register(spec toolSpec) {
+	name := spec.def.Function.Name
+	switch {
+	case r.readOnly && spec.mutating:
+		r.disabled[name] = "--read-only"
+		r.withheld[name] = spec
+		return
+	case r.offline && spec.network:
+		r.disabled[name] = "--offline"
+		r.withheld[name] = spec
+		return
+	}
+	r.specs[name] = spec
+}
+
+func (r *toolRegistry) names() []string {
+	names := make([]string, 0, len(r.specs))
+	for name := range r.specs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// restrict drops every tool not in allowed, recording by as the reason, and
+// reports names that are not registered at all.
+func (r *toolRegistry) restrict(allowed []string, by string) error {
+	keep := map[string]bool{}
+	for _, name := range allowed {
+		if _, ok := r.specs[name]; !ok {
+			if flag, ok := r.disabled[name]; ok {
+				return fmt.Errorf("tool %q is disabled by %s", name, flag)
+			}
+			return fmt.Errorf("unknown tool %q (available: %s)", name, strings.Join(r.names(), ", "))
+		}
+		keep[name] = true
+	}
+	for name := range r.specs {
+		if !keep[name] {
+			delete(r.specs, name)
+			r.disabled[name] = by
+		}
+	}
+	return nil
+}
+
+func (r *toolRegistry) definitions() []Tool {
+	if r == nil {
+		return nil
+	}
+	defs := make([]Tool, 0, len(r.specs))
+	for _, name := range r.names() {
+		defs = append(defs, r.specs[name].def)
+	}
+	for _, name := range r.withheldNames() {
+		if spec, ok := r.granted(name); ok {
+			defs = append(defs, spec.def)
+		}
+	}
+	return defs
+}
+
+func (r *toolRegistry) execute(ctx context.Context, env *toolEnv, call toolCall) toolResult {
+	spec, ok := r.specs[call.Function.Name]
+	if !ok {
+		if spec, ok = r.granted(call.Function.Name); ok {
+			appendAudit(auditEntry{At: time.Now(), Event: "use", Tool: call.Function.Name, Overrides: r.disabled[call.Function.Name], Call: call.summary()})
+		}
+	}
+	if !ok {
+		return toolResult{call: call, err: fmt.Errorf("unknown tool %q", call.Function.Name)}
+	}
+	if err := r.checkCommandRisk(ctx, env, call); err != nil {
+		return toolResult{call: call, err: err}
+	}
+	if env != nil && env.staged != nil && spec.mutating && call.Function.Name != "write_file" {
+		return toolResult{call: call, err: fmt.Errorf("%s is not available while previewing a refactor: edits are staged, not on disk", call.Function.Name)}
+	}
+	args := json.RawMessage(call.Function.Arguments)
+	if strings.TrimSpace(call.Function.Arguments) == "" {
+		args = json.RawMessage("{}")
+	}
+	if spec.background && wantsBackground(args) {
+		return r.startTask(env, spec, call, args)
+	}
+	start := time.Now()
+	output, err := spec.run(ctx, env, args)
+	output, _ = truncateRunes(output, maxToolOutputSize)
+	result := toolResult{call: call, output: output, err: err, at: start, duration: time.Since(start)}
+	if err == nil && spec.source != nil {
+		result.source = spec.source(args, output)
+	}
+	if err == nil {
+		result.images = imageRefs(output)
+	}
+	return result
+}
+
+func runTools(registry *toolRegistry, env toolEnv, calls []toolCall) tea.Cmd {
+	return func() tea.Msg {
+		results := make([]toolResult, 0, len(calls))
+		for _, call := range calls {
+			results = append(results, registry.execute(context.Background(), &env, call))
+		}
+		return toolResultsMsg{results: results}
+	}
+}
+
+func (r toolResult) message() message {
+	content := r.output
+	if r.err != nil {
+		content = "error: " + r.err.Error()
+	}
+	msg := message{Role: "tool", Content: content, ToolCallID: r.call.ID, Name: r.call.Function.Name}
+	if !r.at.IsZero() {
+		msg.Meta = &messageMeta{At: r.at, DurationMS: r.duration.Milliseconds(), Tokens: estimateTokens(content)}
+	}
+	return msg
+}
+
+func (c toolCall) summary() string {
+	var args map[string]any
+	if err := json.Unmarshal([]byte(c.Function.Arguments), &args); err != nil {
+		return c.Function.Name
+	}
+	keys := make([]string, 0, len(args))
+	for key := range args {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	parts := []string{c.Function.Name}
+	for _, key := range keys {
+		value := fmt.Sprint(args[key])
+		if strings.Contains(value, "\n") || len(value) > 60 {
+			value = fmt.Sprintf("<%d chars>", len(value))
+		}
+		parts = append(parts, fmt.Sprintf("%s=%s", key, value))
+	}
+	return strings.Join(parts, " ")
+}
+
+func decodeArgs(args json.RawMessage, v any) error {
+	if err := json.Unmarshal(args, v); err != nil {
+		return fmt.Errorf("invalid arguments: %w", err)
+	}
+	return nil
+}
+
+func toolReadFile(_ context.Context, env *toolEnv, raw json.RawMessage) (string, error) {
+	var args struct {
+		Path      string `json:"path"`
+		StartLine int    `json:"start_line"`
+		EndLine   int    `json:"end_line"`
+	}
+	if err := decodeArgs(raw, &args); err != nil {
+		return "", err
+	}
+	if args.Path == "" {
+		return "", errors.New("path is required")
+	}
+	path, err := resolveWorkspacePath(args.Path)
+	if err != nil {
+		return "", err
+	}
+	data, staged := env.staged.read(path)
+	if !staged {
+		data, err = os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+	}
+	if isImagePath(args.Path) {
+		return describeImage(args.Path, data), nil
+	}
+	if args.StartLine <= 0 && args.EndLine <= 0 {
+		return string(data), nil
+	}
+	lines := strings.Split(string(data), "\n")
+	start := max(args.StartLine, 1)
+	end := args.EndLine
+	if end <= 0 || end > len(lines) {
+		end = len(lines)
+	}
+	if start > end {
+		return "", fmt.Errorf("line range %d-%d is outside the file (%d lines)", args.StartLine, args.EndLine, len(lines))
+	}
+	return strings.Join(lines[start-1:end], "\n"), nil
+}
+
+func toolWriteFile(_ context.Context, env *toolEnv, raw json.RawMessage) (string, error) {
+	var args struct {
+		Path    string `json:"path"`
+		Content string `json:"content"`
+	}
+	if err := decodeArgs(raw, &args); err != nil {
+		return "", err
+	}
+	if args.Path == "" {
+		return "", errors.New("path is required")
+	}
+	path, err := resolveWritablePath(args.Path)
+	if err != nil {
+		return "", err
+	}
+	current, ok := env.staged.read(path)
+	if !ok {
+		current, _ = os.ReadFile(path)
+	}
+	// The model only saw masked secrets, so a placeholder it writes back
+	// would replace the real one.
+	if strings.Count(args.Content, redactedPrefix) > bytes.Count(current, []byte(redactedPrefix)) {
+		return "", fmt.Errorf("content contains a %s…] placeholder where a secret was masked; writing it would replace the secret. Leave this file to the user, or ask them to edit that part", redactedPrefix)
+	}
+	content := matchLineEndings(current, []byte(args.Content))
+	if env.staged != nil {
+		if err := env.staged.write(path, content); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("staged %d bytes for %s (preview: not written yet)", len(args.Content), args.Path), nil
+	}
+	if err := env.journal.writeFile(env.turn, env.prompt, path, content); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("wrote %d bytes to %s", len(args.Content), args.Path), nil
+}
+
+// matchLineEndings keeps a CRLF file CRLF when the model writes it back with
+// bare newlines, as models nearly always do, so the diff shows only the real
+// changes instead of every line.
+func matchLineEndings(current, content []byte) []byte {
+	crlf := bytes.Count(current, []byte("\r\n"))
+	if crlf == 0 || crlf*2 < bytes.Count(current, []byte("\n")) || bytes.Contains(content, []byte("\r\n")) {
+		return content
+	}
+	return bytes.ReplaceAll(content, []byte("\n"), []byte("\r\n"))
+}
+
+func toolListDir(_ context.Context, _ *toolEnv, raw json.RawMessage) (string, error) {
+	var args struct {
+		Path string `json:"path"`
+	}
+	if err := decodeArgs(raw, &args); err != nil {
+		return "", err
+	}
+	if args.Path == "" {
+		args.Path = "."
+	}
+	path, err := resolveWorkspacePath(args.Path)
+	if err != nil {
+		return "", err
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() {
+			name += string(filepath.Separator)
+		}
+		b.WriteString(name)
+		b.WriteString("\n")
+	}
+	return b.String(), nil
+}
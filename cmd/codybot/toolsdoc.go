@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// toolInfo documents a tool and whether this configuration lets the agent
+// use it, for audits of what the agent can do.
+type toolInfo struct {
+	Name        string              `json:"name"`
+	Source      string              `json:"source"`
+	Description string              `json:"description"`
+	Parameters  *FunctionParameters `json:"parameters"`
+	// Command is the shell command behind a session tool.
+	Command string `json:"command,omitempty"`
+	// Mutating tools write files or run commands.
+	Mutating   bool   `json:"mutating"`
+	Network    bool   `json:"network"`
+	Enabled    bool   `json:"enabled"`
+	DisabledBy string `json:"disabled_by,omitempty"`
+}
+
+// toolInventory lists every tool codybot knows, enabled or not. active is the
+// registry the agent would get, nil when tools are off entirely for the
+// reason in off.
+func toolInventory(cfg config, active *toolRegistry, off string) []toolInfo {
+	all := cfg
+	all.ReadOnly, all.Offline = false, false
+	if all.TestCommand == "" {
+		all.TestCommand = "--test-command"
+	}
+	if lspCommand(all.LSP) == nil {
+		// A placeholder command registers the tools; nothing starts it.
+		all.LSP = "--lsp"
+	}
+	specs := newToolRegistry(all).specs
+	if active != nil {
+		for _, spec := range active.customTools() {
+			specs[spec.def.Function.Name] = spec
+		}
+	}
+	names := make([]string, 0, len(specs))
+	for name := range specs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var tools []toolInfo
+	for _, name := range names {
+		spec := specs[name]
+		info := toolInfo{
+			Name:        name,
+			Source:      "built-in",
+			Description: spec.def.Function.Description,
+			Parameters:  spec.def.Function.Parameters,
+			Command:     spec.template,
+			Mutating:    spec.mutating || spec.template != "",
+			Network:     spec.network,
+		}
+		if spec.template != "" {
+			info.Source = "session"
+		}
+		var enabled toolSpec
+		if active != nil {
+			enabled, info.Enabled = active.specs[name]
+		}
+		switch {
+		case active == nil:
+			info.DisabledBy = off
+		case info.Enabled:
+			// The registry's own description names the real test command.
+			info.Description = enabled.def.Function.Description
+		case active.disabled[name] != "":
+			info.DisabledBy = active.disabled[name]
+		case name == "run_tests" && cfg.TestCommand == "":
+			info.DisabledBy = "unset --test-command"
+		case lspToolNames[name] && cfg.LSP == "auto":
+			info.DisabledBy = "--lsp auto, which found no language server for this project"
+		case lspToolNames[name]:
+			info.DisabledBy = "unset --lsp"
+		}
+		tools = append(tools, info)
+	}
+	return tools
+}
+
+func (t toolInfo) access() string {
+	access := "read-only"
+	if t.Mutating {
+		access = "writes/runs commands"
+	}
+	if t.Network {
+		access += ", network"
+	}
+	return access
+}
+
+func (t toolInfo) status() string {
+	if t.Enabled {
+		return "enabled"
+	}
+	return "disabled by " + t.DisabledBy
+}
+
+func writeToolsTable(w io.Writer, tools []toolInfo) {
+	fmt.Fprintf(w, "%-17s %-9s %-22s %s\n", "NAME", "SOURCE", "ACCESS", "STATUS")
+	for _, t := range tools {
+		fmt.Fprintf(w, "%-17s %-9s %-22s %s\n", t.Name, t.Source, t.access(), t.status())
+	}
+}
+
+func writeToolsMarkdown(w io.Writer, tools []toolInfo) {
+	fmt.Fprintln(w, "# codybot tools")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "| Tool | Source | Access | Status |")
+	fmt.Fprintln(w, "| --- | --- | --- | --- |")
+	for _, t := range tools {
+		fmt.Fprintf(w, "| `%s` | %s | %s | %s |\n", t.Name, t.Source, t.access(), t.status())
+	}
+	for _, t := range tools {
+		fmt.Fprintf(w, "\n## %s\n\n%s\n", t.Name, t.Description)
+		if t.Command != "" {
+			fmt.Fprintf(w, "\nRuns: `%s`\n", t.Command)
+		}
+		if t.Parameters == nil || len(t.Parameters.Properties) == 0 {
+			fmt.Fprintln(w, "\nNo parameters.")
+			continue
+		}
+		required := map[string]bool{}
+		for _, name := range t.Parameters.Required {
+			required[name] = true
+		}
+		params := make([]string, 0, len(t.Parameters.Properties))
+		for name := range t.Parameters.Properties {
+			params = append(params, name)
+		}
+		sort.Strings(params)
+		fmt.Fprintln(w, "\n| Parameter | Type | Required | Description |")
+		fmt.Fprintln(w, "| --- | --- | --- | --- |")
+		for _, name := range params {
+			p := t.Parameters.Properties[name]
+			yes := "no"
+			if required[name] {
+				yes = "yes"
+			}
+			fmt.Fprintf(w, "| `%s` | %s | %s | %s |\n", name, p.Type, yes, strings.ReplaceAll(p.Description, "|", `\|`))
+		}
+	}
+}
+
+func writeToolsJSON(w io.Writer, tools []toolInfo) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(tools)
+}
+
+func runToolsCommand(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 || args[0] != "list" {
+		fmt.Fprintln(stderr, "usage: codybot tools list [--json|--markdown] [--task <file>]")
+		return 2
+	}
+	fs := flag.NewFlagSet("tools list", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var cfg config
+	registerConfigFlags(fs, &cfg)
+	asJSON := fs.Bool("json", false, "Print the tools as JSON")
+	asMarkdown := fs.Bool("markdown", false, "Print the tools as Markdown documentation")
+	taskPath := fs.String("task", "", "Apply a task file's tools list and model settings")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	cfg = cfg.normalized()
+	root, err := enterWorkspace(cfg)
+	if err != nil {
+		fmt.Fprintf(stderr, "codybot tools: %v\n", err)
+		return 1
+	}
+	var registry *toolRegistry
+	off := ""
+	switch {
+	case cfg.NoTools:
+		off = "--no-tools"
+	case !cfg.Trust && !isTrusted(root):
+		off = "untrusted workspace"
+	default:
+		registry = newToolRegistry(cfg)
+	}
+	if *taskPath != "" {
+		task, err := loadTaskFile(*taskPath)
+		if err != nil {
+			fmt.Fprintf(stderr, "codybot tools: %v\n", err)
+			return 2
+		}
+		cfg = task.apply(cfg)
+		if registry != nil && task.Tools != nil && len(task.Tools) == 0 {
+			registry, off = nil, "the empty tools list in "+filepath.Base(*taskPath)
+		} else if registry != nil {
+			registry = newToolRegistry(cfg)
+			if task.Tools != nil {
+				if err := registry.restrict(task.Tools, "the tools list in "+filepath.Base(*taskPath)); err != nil {
+					fmt.Fprintf(stderr, "codybot tools: %s: tools: %v\n", *taskPath, err)
+					return 2
+				}
+			}
+		}
+	}
+	tools := toolInventory(cfg, registry, off)
+	switch {
+	case *asJSON:
+		if err := writeToolsJSON(stdout, tools); err != nil {
+			fmt.Fprintf(stderr, "codybot tools: %v\n", err)
+			return 1
+		}
+	case *asMarkdown:
+		writeToolsMarkdown(stdout, tools)
+	default:
+		writeToolsTable(stdout, tools)
+	}
+	return 0
+}
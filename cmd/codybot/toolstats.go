@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+const (
+	// A tool dominates once it has taken dominantToolShare of the session's
+	// tool time, over at least dominantToolCalls calls and dominantToolTime.
+	dominantToolShare = 0.6
+	dominantToolCalls = 3
+	dominantToolTime  = 30 * time.Second
+)
+
+// cheaperTools suggests how to spend less time in a tool that dominates.
+var cheaperTools = map[string]string{
+	"read_file":        "read only the lines you need with start_line and end_line, and do not re-read files you already have",
+	"list_dir":         "list the specific directories you need instead of walking the tree",
+	"run_tests":        "fix several failures before re-running, and pass background to keep working while the suite runs",
+	"find_references":  "reuse the references you already have unless the code changed",
+	"go_to_definition": "reuse definitions you already looked up",
+	"diagnostics":      "check only the files you changed, once per batch of edits",
+	"check_task":       "check background tasks less often and do other work in between",
+}
+
+const defaultCheaperTool = "narrow its arguments to the files or directories that matter, reuse earlier results instead of repeating the call, and pass background for long runs"
+
+// toolUsage is one tool's totals for the session.
+type toolUsage struct {
+	name   string
+	calls  int
+	errors int
+	total  time.Duration
+}
+
+// toolStats accounts for the wall time of every tool call in the session.
+type toolStats struct {
+	usage map[string]*toolUsage
+	// warned holds the tools already reported as dominating.
+	warned map[string]bool
+}
+
+func newToolStats() *toolStats {
+	return &toolStats{usage: map[string]*toolUsage{}, warned: map[string]bool{}}
+}
+
+func (s *toolStats) entry(name string) *toolUsage {
+	u, ok := s.usage[name]
+	if !ok {
+		u = &toolUsage{name: name}
+		s.usage[name] = u
+	}
+	return u
+}
+
+// record counts a finished call.
+func (s *toolStats) record(r toolResult) {
+	u := s.entry(r.call.Function.Name)
+	u.calls++
+	u.total += r.duration
+	if r.err != nil {
+		u.errors++
+	}
+}
+
+// addTime charges a background task's run time to its tool, whose call was
+// counted when the task started.
+func (s *toolStats) addTime(name string, d time.Duration, failed bool) {
+	u := s.entry(name)
+	u.total += d
+	if failed {
+		u.errors++
+	}
+}
+
+func (s *toolStats) total() time.Duration {
+	var total time.Duration
+	for _, u := range s.usage {
+		total += u.total
+	}
+	return total
+}
+
+// sorted lists the tools by time spent, most first.
+func (s *toolStats) sorted() []*toolUsage {
+	list := make([]*toolUsage, 0, len(s.usage))
+	for _, u := range s.usage {
+		list = append(list, u)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].total != list[j].total {
+			return list[i].total > list[j].total
+		}
+		return list[i].name < list[j].name
+	})
+	return list
+}
+
+// dominant returns the tool taking most of the session's tool time, if one
+// does by the thresholds above.
+func (s *toolStats) dominant() (*toolUsage, float64) {
+	total := s.total()
+	list := s.sorted()
+	if len(list) == 0 || total < dominantToolTime {
+		return nil, 0
+	}
+	top := list[0]
+	share := float64(top.total) / float64(total)
+	if top.calls < dominantToolCalls || share < dominantToolShare {
+		return nil, 0
+	}
+	return top, share
+}
+
+// hint is the system prompt addition steering the model away from a
+// dominating tool, or "".
+func (s *toolStats) hint() string {
+	top, share := s.dominant()
+	if top == nil {
+		return ""
+	}
+	advice := cheaperTools[top.name]
+	if advice == "" {
+		advice = defaultCheaperTool
+	}
+	return fmt.Sprintf("\n\nTool cost so far: %s has taken %.0f%% of this session's tool time (%d calls, %s). Prefer cheaper steps: %s.", top.name, share*100, top.calls, formatToolTime(top.total), advice)
+}
+
+// warnSlowTools notes in the transcript, once per tool, that a tool has come
+// to dominate the session's tool time.
+func (m *model) warnSlowTools() {
+	top, share := m.toolStats.dominant()
+	if top == nil || m.toolStats.warned[top.name] {
+		return
+	}
+	m.toolStats.warned[top.name] = true
+	m.appendNote(fmt.Sprintf("%s has taken %.0f%% of the tool time this session (%d calls, %s). The model is now told to prefer cheaper steps; /stats has the breakdown.", top.name, share*100, top.calls, formatToolTime(top.total)))
+}
+
+// withToolHints appends the slow-tool hint to a copy of the system message,
+// like withPins.
+func (m *model) withToolHints(history []message) []message {
+	hint := m.toolStats.hint()
+	if hint == "" || len(history) == 0 || history[0].Role != "system" {
+		return history
+	}
+	out := append([]message(nil), history...)
+	out[0].Content += hint
+	return out
+}
+
+func formatToolTime(d time.Duration) string {
+	if d < time.Second {
+		return fmt.Sprintf("%dms", d.Milliseconds())
+	}
+	return d.Round(100 * time.Millisecond).String()
+}
+
+func (m *model) cmdStats(string) tea.Cmd {
+	list := m.toolStats.sorted()
+	if len(list) == 0 {
+		m.notice = "No tool calls yet this session"
+		return nil
+	}
+	total := m.toolStats.total()
+	var b strings.Builder
+	fmt.Fprintf(&b, "Tool time this session: %s\n", formatToolTime(total))
+	fmt.Fprintf(&b, "  %-20s %6s %7s %10s %10s %6s\n", "TOOL", "CALLS", "ERRORS", "TOTAL", "AVERAGE", "SHARE")
+	for _, u := range list {
+		share := 0.0
+		if total > 0 {
+			share = float64(u.total) / float64(total) * 100
+		}
+		fmt.Fprintf(&b, "  %-20s %6d %7d %10s %10s %5.0f%%\n", u.name, u.calls, u.errors, formatToolTime(u.total), formatToolTime(u.total/time.Duration(max(u.calls, 1))), share)
+	}
+	m.appendNote(b.String())
+	return nil
+}
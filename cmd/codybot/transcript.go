@@ -0,0 +1,433 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/x/ansi"
+)
+
+type blockKind int
+
+const (
+	blockUser blockKind = iota
+	blockAssistant
+	blockTool
+	blockNote
+	blockError
+	blockImage
+)
+
+// block is one message in the transcript. Blocks render and wrap themselves
+// and cache the result per width, so streaming only re-renders the last block
+// and a resize re-wraps each block once.
+type block struct {
+	id      int
+	kind    blockKind
+	text    string
+	chips   string
+	history int
+	folded  bool
+	media   string
+	// meta is the message's metadata, shown under it while showMeta is set.
+	meta     *messageMeta
+	showMeta bool
+	// hideID leaves out the #n prefix; see /ids.
+	hideID bool
+
+	cacheWidth int
+	cache      string
+}
+
+type transcript struct {
+	blocks   []*block
+	nextID   int
+	showMeta bool
+	hideIDs  bool
+
+	// prefix holds the rendered blocks[:prefixLen] at width so appending to
+	// the last block does not rebuild the whole history.
+	width     int
+	prefix    string
+	prefixLen int
+}
+
+func newTranscript() *transcript {
+	return &transcript{nextID: 1}
+}
+
+func (t *transcript) add(kind blockKind, text string) *block {
+	b := &block{id: t.nextID, kind: kind, text: text, history: -1, showMeta: t.showMeta, hideID: t.hideIDs}
+	t.nextID++
+	t.blocks = append(t.blocks, b)
+	return b
+}
+
+func (t *transcript) last() *block {
+	if len(t.blocks) == 0 {
+		return nil
+	}
+	return t.blocks[len(t.blocks)-1]
+}
+
+func (t *transcript) byID(id int) *block {
+	for _, b := range t.blocks {
+		if b.id == id {
+			return b
+		}
+	}
+	return nil
+}
+
+func (t *transcript) reset() {
+	*t = transcript{nextID: 1, hideIDs: t.hideIDs}
+}
+
+// truncate drops every block after b.
+func (t *transcript) truncate(b *block) {
+	for i, candidate := range t.blocks {
+		if candidate == b {
+			t.blocks = t.blocks[:i+1]
+			break
+		}
+	}
+	t.invalidate()
+}
+
+// truncateBefore drops b and every block after it.
+func (t *transcript) truncateBefore(b *block) {
+	for i, candidate := range t.blocks {
+		if candidate == b {
+			t.blocks = t.blocks[:i]
+			break
+		}
+	}
+	t.invalidate()
+}
+
+// insertAfter adds a block right behind after, or at the end when after is
+// no longer in the transcript.
+func (t *transcript) insertAfter(after *block, kind blockKind, text string) *block {
+	b := t.add(kind, text)
+	for i, candidate := range t.blocks[:len(t.blocks)-1] {
+		if candidate == after {
+			copy(t.blocks[i+2:], t.blocks[i+1:len(t.blocks)-1])
+			t.blocks[i+1] = b
+			break
+		}
+	}
+	t.invalidate()
+	return b
+}
+
+// dropEmpty removes the last block when it is of kind and has no text yet,
+// like the answer placeholder when the model replies only with tool calls.
+func (t *transcript) dropEmpty(kind blockKind) {
+	if last := t.last(); last != nil && last.kind == kind && strings.TrimSpace(last.text) == "" {
+		t.blocks = t.blocks[:len(t.blocks)-1]
+		t.invalidate()
+	}
+}
+
+func (t *transcript) invalidate() {
+	t.prefix, t.prefixLen = "", 0
+}
+
+func (t *transcript) render(width int) string {
+	if width != t.width {
+		t.width = width
+		t.invalidate()
+	}
+	n := len(t.blocks)
+	if n == 0 {
+		return ""
+	}
+	if t.prefixLen > n-1 {
+		t.invalidate()
+	}
+	if t.prefixLen < n-1 {
+		var b strings.Builder
+		b.WriteString(t.prefix)
+		for _, blk := range t.blocks[t.prefixLen : n-1] {
+			b.WriteString(blk.render(width))
+		}
+		t.prefix, t.prefixLen = b.String(), n-1
+	}
+	return t.prefix + t.blocks[n-1].render(width)
+}
+
+// anchor finds the block shown at line y of the rendering at width and how
+// far into it y is, as a fraction, so a scroll position survives re-wrapping.
+func (t *transcript) anchor(y, width int) (int, float64) {
+	top := 0
+	for i, b := range t.blocks {
+		lines := strings.Count(b.render(width), "\n")
+		if y < top+lines {
+			return i, float64(y-top) / float64(lines)
+		}
+		top += lines
+	}
+	return len(t.blocks), 0
+}
+
+// lineAt is the inverse of anchor at a new width.
+func (t *transcript) lineAt(index int, within float64, width int) int {
+	top := 0
+	for i, b := range t.blocks {
+		lines := strings.Count(b.render(width), "\n")
+		if i == index {
+			return top + int(within*float64(lines))
+		}
+		top += lines
+	}
+	return top
+}
+
+func (b *block) append(text string) {
+	b.text += text
+	b.cache = ""
+}
+
+func (b *block) render(width int) string {
+	if b.cache != "" && b.cacheWidth == width {
+		return b.cache
+	}
+	var out string
+	switch {
+	case b.kind == blockImage && !b.folded:
+		id := fmt.Sprintf("#%d ", b.id)
+		if b.hideID {
+			id = ""
+		}
+		out = subtleStyle.Render(id) + renderImage(b.text, b.media, max(width-4, 1))
+	case width > 0:
+		out = ansi.Wrap(b.body(), width, "")
+	default:
+		out = b.body()
+	}
+	b.cache, b.cacheWidth = out+"\n\n", width
+	return b.cache
+}
+
+func (b *block) label() string {
+	switch b.kind {
+	case blockUser:
+		return "You"
+	case blockAssistant:
+		return "Assistant"
+	case blockTool:
+		return "Tools"
+	case blockError:
+		return "Error"
+	case blockImage:
+		return "Image"
+	}
+	return "Note"
+}
+
+func (b *block) body() string {
+	if b.showMeta && b.meta != nil {
+		return b.content() + "\n" + subtleStyle.Render(b.meta.line())
+	}
+	return b.content()
+}
+
+// setMeta attaches metadata; tool blocks that cover several calls keep the
+// first call's time and add up the durations.
+func (b *block) setMeta(meta *messageMeta) {
+	if b.meta != nil && b.kind == blockTool {
+		merged := *b.meta
+		merged.DurationMS += meta.DurationMS
+		merged.Tokens += meta.Tokens
+		meta = &merged
+	}
+	b.meta = meta
+	b.cache, b.cacheWidth = "", 0
+}
+
+func (b *block) content() string {
+	id := subtleStyle.Render(fmt.Sprintf("#%d ", b.id))
+	if b.hideID {
+		id = ""
+	}
+	text := strings.TrimRight(b.text, "\n")
+	if b.folded {
+		first, _, _ := strings.Cut(stripANSI(text), "\n")
+		first, _ = truncateRunes(first, 60)
+		hidden := strings.Count(text, "\n")
+		return id + subtleStyle.Render(fmt.Sprintf("▸ %s: %s … (%d more lines, /unfold %d)", b.label(), first, hidden, b.id))
+	}
+	switch b.kind {
+	case blockUser:
+		out := id + userStyle.Render("You:") + " " + text
+		if b.chips != "" {
+			out += "\n" + b.chips
+		}
+		return out
+	case blockAssistant:
+		return id + assistantStyle.Render("Assistant:") + " " + text
+	case blockTool:
+		lines := strings.Split(text, "\n")
+		for i, line := range lines {
+			lines[i] = toolStyle.Render(line)
+		}
+		return id + strings.Join(lines, "\n")
+	case blockError:
+		return id + errorStyle.Render("[error] "+text)
+	}
+	return id + subtleStyle.Render(text)
+}
+
+// plain returns the block's text without styling, for copying.
+func (b *block) plain() string {
+	return stripANSI(strings.TrimSpace(b.text))
+}
+
+func (m *model) addBlock(kind blockKind, text string) *block {
+	b := m.transcript.add(kind, text)
+	m.refreshTranscript()
+	return b
+}
+
+// appendToBlock streams text into the last block, starting a new block when
+// the last one is of a different kind.
+func (m *model) appendToBlock(kind blockKind, text string) {
+	last := m.transcript.last()
+	if last == nil || last.kind != kind {
+		m.addBlock(kind, text)
+		return
+	}
+	last.append(text)
+	m.refreshTranscript()
+}
+
+func (m *model) addImageBlock(path string) {
+	b := m.transcript.add(blockImage, path)
+	b.media = m.images
+	m.refreshTranscript()
+}
+
+func (m *model) refreshTranscript() {
+	if m.selection.active {
+		m.selection.lines = m.transcript.plainLines(m.viewport.Width)
+		m.setViewportContent(m.viewportContent())
+		return
+	}
+	if m.find.active {
+		m.refreshFind()
+		return
+	}
+	m.setViewportContent(m.transcriptText())
+	if !m.scrollLock {
+		m.viewport.GotoBottom()
+	}
+}
+
+func (m model) transcriptText() string {
+	return m.transcript.render(m.viewport.Width)
+}
+
+// blockArg resolves "/cmd <n>" to a block, defaulting to the latest block of
+// the given kind when no number is given.
+func (m *model) blockArg(args string, fallback blockKind) (*block, error) {
+	if args == "" {
+		for i := len(m.transcript.blocks) - 1; i >= 0; i-- {
+			if b := m.transcript.blocks[i]; b.kind == fallback {
+				return b, nil
+			}
+		}
+		return nil, fmt.Errorf("no %s message yet", strings.ToLower((&block{kind: fallback}).label()))
+	}
+	id, err := strconv.Atoi(strings.TrimPrefix(args, "#"))
+	if err != nil {
+		return nil, fmt.Errorf("expected a message number, got %q", args)
+	}
+	b := m.transcript.byID(id)
+	if b == nil {
+		return nil, fmt.Errorf("no message #%d", id)
+	}
+	return b, nil
+}
+
+func (m *model) setFolded(args string, folded bool) {
+	if args == "all" {
+		for _, b := range m.transcript.blocks {
+			if b.folded != folded && (!folded || b.kind != blockUser) {
+				b.folded, b.cache = folded, ""
+			}
+		}
+	} else {
+		b, err := m.blockArg(args, blockAssistant)
+		if err != nil {
+			m.lastErr = err
+			return
+		}
+		b.folded, b.cache = folded, ""
+	}
+	m.lastErr = nil
+	m.transcript.invalidate()
+	if m.find.active {
+		m.refreshFind()
+		return
+	}
+	offset := m.viewport.YOffset
+	m.setViewportContent(m.transcriptText())
+	m.viewport.SetYOffset(offset)
+}
+
+func (m *model) cmdFold(args string) tea.Cmd {
+	m.setFolded(args, true)
+	return nil
+}
+
+func (m *model) cmdUnfold(args string) tea.Cmd {
+	m.setFolded(args, false)
+	return nil
+}
+
+// cmdCopy puts a message on the system clipboard using OSC 52, which works
+// over SSH and inside tmux without a clipboard helper.
+func (m *model) cmdCopy(args string) tea.Cmd {
+	b, err := m.blockArg(args, blockAssistant)
+	if err != nil {
+		m.lastErr = err
+		return nil
+	}
+	m.lastErr = nil
+	copyText(b.plain())
+	m.notice = fmt.Sprintf("Copied message #%d (%d chars)", b.id, len(b.plain()))
+	return nil
+}
+
+// cmdReroll discards the latest answer, including its tool calls, and asks
+// the model again with the same prompt.
+func (m *model) cmdReroll(string) tea.Cmd {
+	if m.streaming {
+		m.notice = "Wait for the current response to finish before re-rolling"
+		return nil
+	}
+	var user *block
+	for i := len(m.transcript.blocks) - 1; i >= 0; i-- {
+		if b := m.transcript.blocks[i]; b.kind == blockUser && b.history >= 0 {
+			user = b
+			break
+		}
+	}
+	if user == nil || user.history >= len(m.history) {
+		m.notice = "Nothing to re-roll"
+		return nil
+	}
+	m.history = m.history[:user.history+1]
+	m.transcript.truncate(user)
+	m.scrollLock = false
+	m.lastErr = nil
+	m.turn = m.journal.nextTurn()
+	m.toolRounds = 0
+	m.usage = turnUsage{}
+	m.citations = citations{}
+	m.tests.reset()
+	m.addBlock(blockAssistant, "")
+	return m.startStream()
+}
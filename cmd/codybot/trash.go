@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+const trashDirName = ".codybot/trash"
+
+// trashEntry is one file the agent deleted. The file itself is moved to
+// Stored, under the trash directory of the session that deleted it, so it
+// can be put back with /restore-file.
+type trashEntry struct {
+	ID       int       `json:"id"`
+	Path     string    `json:"path"`
+	Stored   string    `json:"stored"`
+	Session  string    `json:"session"`
+	At       time.Time `json:"at"`
+	Size     int64     `json:"size"`
+	Restored bool      `json:"restored,omitempty"`
+}
+
+// trashMu serializes updates to the trash index between tool calls and
+// slash commands.
+var trashMu sync.Mutex
+
+// trashDir is the workspace's trash, wherever codybot's working directory
+// has moved to since.
+func trashDir() string {
+	root, err := workspaceRoot()
+	if err != nil {
+		return trashDirName
+	}
+	return filepath.Join(root, trashDirName)
+}
+
+func trashIndexPath() string {
+	return filepath.Join(trashDir(), "index.json")
+}
+
+func loadTrash() ([]trashEntry, error) {
+	data, err := os.ReadFile(trashIndexPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []trashEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("%s: %w", trashIndexPath(), err)
+	}
+	return entries, nil
+}
+
+func saveTrash(entries []trashEntry) error {
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(trashIndexPath(), data, 0o600)
+}
+
+// moveToTrash moves path into the session's trash directory instead of
+// unlinking it.
+func moveToTrash(session, path string) (trashEntry, error) {
+	trashMu.Lock()
+	defer trashMu.Unlock()
+
+	info, err := os.Lstat(path)
+	if err != nil {
+		return trashEntry{}, err
+	}
+	if info.IsDir() {
+		return trashEntry{}, fmt.Errorf("%s is a directory; delete its files one at a time", path)
+	}
+	entries, err := loadTrash()
+	if err != nil {
+		return trashEntry{}, err
+	}
+	entry := trashEntry{ID: 1, Path: path, Session: session, At: time.Now(), Size: info.Size()}
+	if n := len(entries); n > 0 {
+		entry.ID = entries[n-1].ID + 1
+	}
+	entry.Stored = filepath.Join(session, strconv.Itoa(entry.ID)+"-"+filepath.Base(path))
+	stored := filepath.Join(trashDir(), entry.Stored)
+	if err := os.MkdirAll(filepath.Dir(stored), 0o755); err != nil {
+		return trashEntry{}, err
+	}
+	if err := os.Rename(path, stored); err != nil {
+		return trashEntry{}, err
+	}
+	if err := saveTrash(append(entries, entry)); err != nil {
+		if os.Rename(stored, path) == nil {
+			return trashEntry{}, fmt.Errorf("could not record the deletion, so %s was left in place: %w", path, err)
+		}
+		return trashEntry{}, fmt.Errorf("moved %s to %s but could not record it: %w", path, stored, err)
+	}
+	return entry, nil
+}
+
+// restoreFromTrash moves a trashed file back, refusing to overwrite a file
+// that has since been created at the same path.
+func restoreFromTrash(id int) (trashEntry, error) {
+	trashMu.Lock()
+	defer trashMu.Unlock()
+
+	entries, err := loadTrash()
+	if err != nil {
+		return trashEntry{}, err
+	}
+	for i := range entries {
+		entry := &entries[i]
+		if entry.ID != id {
+			continue
+		}
+		if entry.Restored {
+			return trashEntry{}, fmt.Errorf("trash #%d was already restored to %s", id, entry.Path)
+		}
+		if _, err := os.Lstat(entry.Path); err == nil {
+			return trashEntry{}, fmt.Errorf("%s exists again; move it aside before restoring trash #%d", entry.Path, id)
+		}
+		if err := os.MkdirAll(filepath.Dir(entry.Path), 0o755); err != nil {
+			return trashEntry{}, err
+		}
+		if err := os.Rename(filepath.Join(trashDir(), entry.Stored), entry.Path); err != nil {
+			return trashEntry{}, err
+		}
+		entry.Restored = true
+		if err := saveTrash(entries); err != nil {
+			return *entry, fmt.Errorf("restored %s but could not update the trash index: %w", entry.Path, err)
+		}
+		return *entry, nil
+	}
+	return trashEntry{}, fmt.Errorf("no trash entry #%d", id)
+}
+
+func toolDeleteFile(_ context.Context, env *toolEnv, raw json.RawMessage) (string, error) {
+	var args struct {
+		Path string `json:"path"`
+	}
+	if err := decodeArgs(raw, &args); err != nil {
+		return "", err
+	}
+	if args.Path == "" {
+		return "", errors.New("path is required")
+	}
+	// The trash is under .codybot, so this also keeps the agent from
+	// emptying it.
+	path, err := resolveWritablePath(args.Path)
+	if err != nil {
+		return "", err
+	}
+	entry, err := moveToTrash(env.journal.session, path)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("deleted %s (moved to the trash as #%d; the user can restore it)", args.Path, entry.ID), nil
+}
+
+func (m *model) cmdTrash(args string) tea.Cmd {
+	if args != "" && args != "all" {
+		m.notice = "Usage: /trash [all]"
+		return nil
+	}
+	trashMu.Lock()
+	entries, err := loadTrash()
+	trashMu.Unlock()
+	if err != nil {
+		m.lastErr = err
+		return nil
+	}
+	var b strings.Builder
+	listed := 0
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		if entry.Restored || (args != "all" && entry.Session != m.journal.session) {
+			continue
+		}
+		if listed == 0 {
+			b.WriteString("Files the agent deleted, newest first:\n")
+		}
+		listed++
+		fmt.Fprintf(&b, "  #%-4d %s  %8s  %s\n", entry.ID, entry.At.Local().Format("Jan 2 15:04"), formatBytes(entry.Size), entry.Path)
+	}
+	if listed == 0 {
+		m.notice = "The trash is empty for this session • /trash all lists earlier sessions"
+		if args == "all" {
+			m.notice = "The trash is empty"
+		}
+		return nil
+	}
+	b.WriteString("/restore-file <#|path> puts one back.")
+	m.appendNote(b.String())
+	return nil
+}
+
+func (m *model) cmdRestoreFile(args string) tea.Cmd {
+	if args == "" {
+		m.notice = "Usage: /restore-file <#|path>"
+		return nil
+	}
+	id, err := strconv.Atoi(strings.TrimPrefix(args, "#"))
+	if err != nil {
+		// A path restores its most recent deletion.
+		path, perr := resolveWorkspacePath(args)
+		if perr != nil {
+			m.lastErr = perr
+			return nil
+		}
+		trashMu.Lock()
+		entries, lerr := loadTrash()
+		trashMu.Unlock()
+		if lerr != nil {
+			m.lastErr = lerr
+			return nil
+		}
+		for _, entry := range entries {
+			if entry.Path == path && !entry.Restored {
+				id = entry.ID
+			}
+		}
+		if id == 0 {
+			m.notice = "Nothing in the trash for " + args
+			return nil
+		}
+	}
+	entry, err := restoreFromTrash(id)
+	if err != nil {
+		m.lastErr = err
+		return nil
+	}
+	m.lastErr = nil
+	m.notice = fmt.Sprintf("Restored %s", entry.Path)
+	return nil
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// usageSchema is the usage database: one row per finished turn, with the
+// model rounds and tool calls it took. It only grows; codybot stats reads it.
+const usageSchema = `
+CREATE TABLE IF NOT EXISTS turns (
+	id INTEGER PRIMARY KEY,
+	at INTEGER NOT NULL,
+	session TEXT NOT NULL,
+	repo TEXT NOT NULL,
+	model TEXT NOT NULL,
+	input_tokens INTEGER NOT NULL,
+	output_tokens INTEGER NOT NULL,
+	cost REAL NOT NULL
+);
+CREATE TABLE IF NOT EXISTS rounds (
+	turn INTEGER NOT NULL REFERENCES turns(id),
+	model TEXT NOT NULL,
+	latency_ms INTEGER NOT NULL,
+	duration_ms INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS tool_calls (
+	turn INTEGER NOT NULL REFERENCES turns(id),
+	tool TEXT NOT NULL,
+	duration_ms INTEGER NOT NULL,
+	failed INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS turns_at ON turns(at);
+`
+
+// usageRound is one model response within a turn.
+type usageRound struct {
+	model      string
+	latencyMS  int64
+	durationMS int64
+}
+
+// usageTool is one tool call within a turn.
+type usageTool struct {
+	name     string
+	duration time.Duration
+	failed   bool
+}
+
+func usageDBPath() string {
+	return filepath.Join(codybotHome(), "usage.db")
+}
+
+func openUsageDB() (*sql.DB, error) {
+	path := usageDBPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite", path+"?_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(usageSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return db, nil
+}
+
+// recordUsage stores a finished turn with its rounds and tool calls.
+func recordUsage(rec activityRecord, usage turnUsage) error {
+	db, err := openUsageDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec(`INSERT INTO turns (at, session, repo, model, input_tokens, output_tokens, cost) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		rec.At.UnixMilli(), rec.Session, rec.Repo, rec.Model, rec.InputTokens, rec.OutputTokens, rec.Cost)
+	if err != nil {
+		return err
+	}
+	turn, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	for _, r := range usage.rounds {
+		if _, err := tx.Exec(`INSERT INTO rounds (turn, model, latency_ms, duration_ms) VALUES (?, ?, ?, ?)`, turn, r.model, r.latencyMS, r.durationMS); err != nil {
+			return err
+		}
+	}
+	for _, t := range usage.tools {
+		if _, err := tx.Exec(`INSERT INTO tool_calls (turn, tool, duration_ms, failed) VALUES (?, ?, ?, ?)`, turn, t.name, t.duration.Milliseconds(), t.failed); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// runStatsCommand prints weekly usage and a per-model breakdown from the
+// usage database.
+func runStatsCommand(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("stats", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	weeks := fs.Int("weeks", 8, "How many weeks back to summarize")
+	repoOnly := fs.Bool("repo", false, "Only count turns in the current repository")
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+	if *weeks < 1 {
+		fmt.Fprintln(stderr, "codybot stats: --weeks must be at least 1")
+		return 2
+	}
+	if _, err := os.Stat(usageDBPath()); err != nil {
+		fmt.Fprintf(stdout, "No usage recorded yet; it is saved to %s after each turn.\n", usageDBPath())
+		return 0
+	}
+	db, err := openUsageDB()
+	if err != nil {
+		fmt.Fprintf(stderr, "codybot stats: %v\n", err)
+		return 1
+	}
+	defer db.Close()
+	since := time.Now().AddDate(0, 0, -7*(*weeks)).UnixMilli()
+	repo := ""
+	if *repoOnly {
+		repo = repoRoot()
+	}
+	found, err := printWeeklyUsage(stdout, db, since, repo)
+	if err != nil {
+		fmt.Fprintf(stderr, "codybot stats: %v\n", err)
+		return 1
+	}
+	if !found {
+		fmt.Fprintf(stdout, "No turns in the last %d weeks.\n", *weeks)
+		return 0
+	}
+	fmt.Fprintln(stdout)
+	if err := printModelUsage(stdout, db, since, repo); err != nil {
+		fmt.Fprintf(stderr, "codybot stats: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// usageFilter selects the turns since a time, in one repository when repo is
+// set.
+const usageFilter = `t.at >= ? AND (? = '' OR t.repo = ?)`
+
+// printWeeklyUsage prints a row per week and reports whether there was any.
+func printWeeklyUsage(w io.Writer, db *sql.DB, since int64, repo string) (bool, error) {
+	rows, err := db.Query(`
+SELECT date(t.at / 1000, 'unixepoch', 'localtime', '-6 days', 'weekday 1') AS week,
+	COUNT(*), COUNT(DISTINCT t.session), SUM(t.input_tokens), SUM(t.output_tokens), SUM(t.cost),
+	COALESCE(SUM((SELECT COUNT(*) FROM tool_calls c WHERE c.turn = t.id)), 0),
+	COALESCE(SUM((SELECT COUNT(*) FROM tool_calls c WHERE c.turn = t.id AND c.failed)), 0)
+FROM turns t WHERE `+usageFilter+`
+GROUP BY week ORDER BY week`, since, repo, repo)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "WEEK OF\tSESSIONS\tTURNS\tINPUT\tOUTPUT\tCOST\tTOOL CALLS\tFAILED")
+	n := 0
+	for rows.Next() {
+		var week string
+		var turns, sessions, input, output, calls, failed int
+		var cost float64
+		if err := rows.Scan(&week, &turns, &sessions, &input, &output, &cost, &calls, &failed); err != nil {
+			return false, err
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%s\t%s\t%s\t%d\t%d\n", week, sessions, turns, formatCount(input), formatCount(output), formatCost(cost), calls, failed)
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return false, err
+	}
+	if n == 0 {
+		return false, nil
+	}
+	return true, tw.Flush()
+}
+
+// printModelUsage breaks the period down by the model each turn was sent
+// to, with the average first-token latency and duration of its responses.
+func printModelUsage(w io.Writer, db *sql.DB, since int64, repo string) error {
+	rows, err := db.Query(`
+WITH period AS (SELECT * FROM turns t WHERE `+usageFilter+`)
+SELECT p.model, COUNT(*), SUM(p.input_tokens), SUM(p.output_tokens), SUM(p.cost),
+	(SELECT COALESCE(AVG(r.latency_ms), 0) FROM rounds r JOIN period q ON q.id = r.turn WHERE q.model = p.model),
+	(SELECT COALESCE(AVG(r.duration_ms), 0) FROM rounds r JOIN period q ON q.id = r.turn WHERE q.model = p.model)
+FROM period p
+GROUP BY p.model ORDER BY 5 DESC, 2 DESC`, since, repo, repo)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "MODEL\tTURNS\tINPUT\tOUTPUT\tCOST\tFIRST TOKEN\tDURATION")
+	for rows.Next() {
+		var model string
+		var turns, input, output int
+		var cost, latency, duration float64
+		if err := rows.Scan(&model, &turns, &input, &output, &cost, &latency, &duration); err != nil {
+			return err
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\t%s\t%s\n", model, turns, formatCount(input), formatCount(output), formatCost(cost),
+			formatSeconds(time.Duration(latency)*time.Millisecond), formatSeconds(time.Duration(duration)*time.Millisecond))
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return tw.Flush()
+}
+
+// formatCost shows a cost in dollars, or "-" when no prices are set.
+func formatCost(cost float64) string {
+	if cost == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("$%.2f", cost)
+}
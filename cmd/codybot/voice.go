@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+const (
+	defaultTranscribeModel = "whisper-1"
+	transcribeTimeout      = 2 * time.Minute
+	// minRecordingBytes is a WAV header and a moment of audio; anything
+	// shorter was stopped before the recorder captured anything.
+	minRecordingBytes = 1024
+)
+
+// recorders are tried in order when --record-command is not set. Each
+// records 16 kHz mono WAV, which every Whisper endpoint accepts, to {file}
+// until interrupted.
+var recorders = []struct {
+	name    string
+	command string
+}{
+	{"rec", "rec -q -c 1 -r 16000 -b 16 {file}"},
+	{"arecord", "arecord -q -f S16_LE -r 16000 -c 1 {file}"},
+	{"sox", "sox -q -d -c 1 -r 16000 -b 16 {file}"},
+}
+
+// voiceRecording is a push-to-talk recording in progress.
+type voiceRecording struct {
+	cmd    *exec.Cmd
+	path   string
+	stderr *bytes.Buffer
+	// stopping is set when the user stopped the recording, so the
+	// recorder's exit status after the interrupt is not an error; discard
+	// throws the audio away instead of transcribing it.
+	stopping bool
+	discard  bool
+}
+
+type voiceStoppedMsg struct {
+	rec *voiceRecording
+	err error
+}
+
+type voiceTranscribedMsg struct {
+	text string
+	err  error
+}
+
+// recordCommand is the recorder command line, with {file} for the output.
+func (cfg config) recordCommand() (string, error) {
+	if command := strings.TrimSpace(cfg.RecordCommand); command != "" {
+		if !strings.Contains(command, "{file}") {
+			command += " {file}"
+		}
+		return command, nil
+	}
+	for _, r := range recorders {
+		if hasCommand(r.name) {
+			return r.command, nil
+		}
+	}
+	return "", errors.New("no audio recorder found: install sox or arecord, or set --record-command to a command that records WAV to {file} until interrupted")
+}
+
+// transcribeURL is --transcribe-url, or the endpoint's own
+// /audio/transcriptions.
+func (cfg config) transcribeURL() string {
+	if cfg.TranscribeURL != "" {
+		return cfg.TranscribeURL
+	}
+	return strings.TrimRight(cfg.BaseURL, "/") + "/audio/transcriptions"
+}
+
+// cmdVoice starts push-to-talk, or stops it and transcribes what was said.
+func (m *model) cmdVoice(string) tea.Cmd {
+	if m.voice != nil {
+		m.stopVoice(false)
+		return nil
+	}
+	if m.transcribing {
+		m.notice = "Still transcribing the last recording"
+		return nil
+	}
+	command, err := m.cfg.recordCommand()
+	if err != nil {
+		m.lastErr = err
+		return nil
+	}
+	tmp, err := os.CreateTemp("", "codybot-voice-*.wav")
+	if err != nil {
+		m.lastErr = err
+		return nil
+	}
+	tmp.Close()
+	command = strings.ReplaceAll(command, "{file}", shellQuote(tmp.Name()))
+	if runtime.GOOS != "windows" {
+		// exec makes the recorder the process that gets the interrupt.
+		command = "exec " + command
+	}
+	rec := &voiceRecording{cmd: shellCommand(context.Background(), command), path: tmp.Name(), stderr: &bytes.Buffer{}}
+	rec.cmd.Stderr = rec.stderr
+	if err := rec.cmd.Start(); err != nil {
+		os.Remove(tmp.Name())
+		m.lastErr = fmt.Errorf("start recorder: %w", err)
+		return nil
+	}
+	m.voice = rec
+	m.lastErr = nil
+	m.notice = "● Recording"
+	return func() tea.Msg {
+		return voiceStoppedMsg{rec: rec, err: rec.cmd.Wait()}
+	}
+}
+
+// stopVoice ends the recording; the recorder's exit brings a
+// voiceStoppedMsg that transcribes the audio unless it is discarded.
+func (m *model) stopVoice(discard bool) {
+	rec := m.voice
+	rec.stopping, rec.discard = true, discard
+	if runtime.GOOS == "windows" || rec.cmd.Process.Signal(os.Interrupt) != nil {
+		rec.cmd.Process.Kill()
+	}
+	m.notice = "Transcribing…"
+	if discard {
+		m.notice = "Recording discarded"
+	}
+}
+
+// close stops a recording that is still running when codybot exits.
+func (rec *voiceRecording) close() {
+	if rec == nil {
+		return
+	}
+	rec.cmd.Process.Kill()
+	os.Remove(rec.path)
+}
+
+func (m model) handleVoiceStoppedMsg(msg voiceStoppedMsg) (tea.Model, tea.Cmd) {
+	rec := msg.rec
+	if m.voice == rec {
+		m.voice = nil
+	}
+	if rec.discard {
+		os.Remove(rec.path)
+		return m, nil
+	}
+	if !rec.stopping {
+		os.Remove(rec.path)
+		m.notice = ""
+		detail := strings.TrimSpace(rec.stderr.String())
+		if msg.err != nil && detail != "" {
+			m.lastErr = fmt.Errorf("recorder stopped: %v: %s", msg.err, detail)
+		} else {
+			m.lastErr = fmt.Errorf("recorder stopped before Ctrl+R: %v", msg.err)
+		}
+		return m, nil
+	}
+	if info, err := os.Stat(rec.path); err != nil || info.Size() < minRecordingBytes {
+		os.Remove(rec.path)
+		m.notice = "Nothing was recorded"
+		return m, nil
+	}
+	m.transcribing = true
+	cfg := m.cfg
+	return m, func() tea.Msg {
+		defer os.Remove(rec.path)
+		ctx, cancel := context.WithTimeout(context.Background(), transcribeTimeout)
+		defer cancel()
+		text, err := transcribe(ctx, cfg, rec.path)
+		return voiceTranscribedMsg{text: text, err: err}
+	}
+}
+
+// handleVoiceTranscribedMsg adds what was said to the end of the prompt,
+// for review before sending.
+func (m model) handleVoiceTranscribedMsg(msg voiceTranscribedMsg) (tea.Model, tea.Cmd) {
+	m.transcribing = false
+	if msg.err != nil {
+		m.notice = ""
+		m.lastErr = fmt.Errorf("transcribe: %w", msg.err)
+		return m, nil
+	}
+	text := strings.TrimSpace(msg.text)
+	if text == "" {
+		m.notice = "No speech recognized"
+		return m, nil
+	}
+	value := m.input.Value()
+	if value != "" && !strings.HasSuffix(value, " ") && !strings.HasSuffix(value, "\n") {
+		value += " "
+	}
+	m.input.SetValue(value + text)
+	m.input.CursorEnd()
+	m.notice = fmt.Sprintf("Transcribed %d words • Enter sends", len(strings.Fields(text)))
+	return m, nil
+}
+
+// transcribe sends a recording to the OpenAI-style transcription endpoint.
+// The endpoint's API key is only sent to the endpoint's own host, unless
+// --transcribe-api-key names one for the transcription host.
+func transcribe(ctx context.Context, cfg config, path string) (string, error) {
+	audio, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer audio.Close()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("file", filepath.Base(path))
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(part, audio); err != nil {
+		return "", err
+	}
+	model := cfg.TranscribeModel
+	if model == "" {
+		model = defaultTranscribeModel
+	}
+	form.WriteField("model", model)
+	form.WriteField("response_format", "json")
+	if err := form.Close(); err != nil {
+		return "", err
+	}
+
+	endpoint := cfg.transcribeURL()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, &body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	switch {
+	case cfg.TranscribeAPIKey != "":
+		req.Header.Set("Authorization", "Bearer "+cfg.TranscribeAPIKey)
+	case sameHost(endpoint, cfg.BaseURL):
+		if err := cfg.authorize(req); err != nil {
+			return "", err
+		}
+	}
+	if sameHost(endpoint, cfg.BaseURL) {
+		setRequestExtras(req, cfg)
+	}
+	client, err := httpClientFor(cfg)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode >= 300 {
+		return "", &apiError{StatusCode: resp.StatusCode, Status: resp.Status, Body: strings.TrimSpace(string(data))}
+	}
+	var result struct {
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return "", fmt.Errorf("unexpected response from %s: %w", endpoint, err)
+	}
+	return result.Text, nil
+}
+
+func sameHost(a, b string) bool {
+	ua, errA := url.Parse(a)
+	ub, errB := url.Parse(b)
+	return errA == nil && errB == nil && ua.Host != "" && strings.EqualFold(ua.Host, ub.Host)
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+const (
+	// maxMirrorLog bounds the events replayed to a page that connects late;
+	// token deltas are merged, so this is roughly a few thousand messages.
+	maxMirrorLog      = 20000
+	mirrorHeartbeat   = 15 * time.Second
+	mirrorClientQueue = 1024
+)
+
+// webMirror serves a read-only live view of the session: an HTML page that
+// follows the same events the control socket publishes, over server-sent
+// events. It takes no requests that change anything, and every URL needs the
+// random token printed at startup, so the page can be opened from another
+// device or shown in a demo without handing out control.
+type webMirror struct {
+	url    string
+	token  string
+	server *http.Server
+	events chan serveEvent
+	stop   func()
+
+	mu      sync.Mutex
+	log     []serveEvent
+	clients map[chan serveEvent]struct{}
+}
+
+// startWebMirror listens on addr and subscribes to hub's events.
+func startWebMirror(addr string, hub *controlServer) (*webMirror, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	secret := make([]byte, 16)
+	if _, err := rand.Read(secret); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	w := &webMirror{token: hex.EncodeToString(secret), clients: map[chan serveEvent]struct{}{}}
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	if ip := net.ParseIP(host); ip == nil || ip.IsUnspecified() {
+		// Listening on every interface: name the machine, since that is what
+		// another device has to reach.
+		if name, err := os.Hostname(); err == nil {
+			host = name
+		}
+	}
+	w.url = fmt.Sprintf("http://%s/?token=%s", net.JoinHostPort(host, port), w.token)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", w.handlePage)
+	mux.HandleFunc("GET /events", w.handleEvents)
+	w.server = &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	w.events, w.stop = hub.subscribe()
+	go w.pump()
+	go w.server.Serve(ln)
+	return w, nil
+}
+
+func (w *webMirror) close() {
+	if w == nil {
+		return
+	}
+	w.stop()
+	w.server.Close()
+}
+
+// pump records each event for late joiners and forwards it to open pages.
+func (w *webMirror) pump() {
+	for ev := range w.events {
+		w.mu.Lock()
+		forward := []serveEvent{ev}
+		if len(w.log) > 0 && w.log[0].Session != ev.Session {
+			// A cleared or switched session starts a new page.
+			w.log = nil
+			forward = append([]serveEvent{{Type: "reset", Session: ev.Session, Time: ev.Time}}, ev)
+		}
+		w.record(ev)
+		for ch := range w.clients {
+			for _, ev := range forward {
+				select {
+				case ch <- ev:
+				default:
+				}
+			}
+		}
+		w.mu.Unlock()
+	}
+}
+
+// record appends ev to the replay log, merging a token delta into the one
+// before it. w.mu must be held.
+func (w *webMirror) record(ev serveEvent) {
+	if delta, ok := ev.Data.(tokenDeltaData); ok && len(w.log) > 0 {
+		last := &w.log[len(w.log)-1]
+		if prev, ok := last.Data.(tokenDeltaData); ok && last.Message == ev.Message {
+			last.Data = tokenDeltaData{Text: prev.Text + delta.Text}
+			last.Seq, last.Time = ev.Seq, ev.Time
+			return
+		}
+	}
+	w.log = append(w.log, ev)
+	if len(w.log) > maxMirrorLog {
+		w.log = append(w.log[:0], w.log[len(w.log)-maxMirrorLog:]...)
+	}
+}
+
+func (w *webMirror) authorized(r *http.Request) bool {
+	return subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("token")), []byte(w.token)) == 1
+}
+
+func (w *webMirror) handlePage(rw http.ResponseWriter, r *http.Request) {
+	if !w.authorized(r) {
+		http.Error(rw, "open the URL codybot printed, including its token", http.StatusUnauthorized)
+		return
+	}
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.Header().Set("Content-Security-Policy", "default-src 'none'; script-src 'unsafe-inline'; style-src 'unsafe-inline'; connect-src 'self'")
+	rw.Header().Set("Referrer-Policy", "no-referrer")
+	fmt.Fprint(rw, mirrorPage)
+}
+
+// handleEvents streams a reset, the replay log, then live events, so a page
+// that reconnects redraws from scratch instead of duplicating messages.
+func (w *webMirror) handleEvents(rw http.ResponseWriter, r *http.Request) {
+	if !w.authorized(r) {
+		http.Error(rw, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	flusher, ok := rw.(http.Flusher)
+	if !ok {
+		http.Error(rw, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	rw.Header().Set("Content-Type", "text/event-stream")
+	rw.Header().Set("Cache-Control", "no-cache")
+
+	ch := make(chan serveEvent, mirrorClientQueue)
+	w.mu.Lock()
+	replay := append([]serveEvent{{Type: "reset", Time: time.Now().UTC()}}, w.log...)
+	w.clients[ch] = struct{}{}
+	w.mu.Unlock()
+	defer func() {
+		w.mu.Lock()
+		delete(w.clients, ch)
+		w.mu.Unlock()
+	}()
+
+	write := func(ev serveEvent) bool {
+		data, err := json.Marshal(ev)
+		if err != nil {
+			return true
+		}
+		if _, err := fmt.Fprintf(rw, "data: %s\n\n", data); err != nil {
+			return false
+		}
+		return true
+	}
+	for _, ev := range replay {
+		if !write(ev) {
+			return
+		}
+	}
+	flusher.Flush()
+	heartbeat := time.NewTicker(mirrorHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case ev := <-ch:
+			if !write(ev) {
+				return
+			}
+			flusher.Flush()
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(rw, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func (m *model) cmdWeb(string) tea.Cmd {
+	if m.web == nil {
+		m.notice = "Start codybot with --web <addr> for a live read-only view, e.g. --web 127.0.0.1:8787"
+		return nil
+	}
+	copyText(m.web.url)
+	m.notice = "Copied the live view URL: " + m.web.url
+	return nil
+}
+
+const mirrorPage = `<!doctype html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>codybot live view</title>
+<style>
+  :root { color-scheme: light dark; --fg: #1f2328; --bg: #ffffff; --muted: #6e7781; --card: #f6f8fa; --accent: #0969da; --err: #cf222e; }
+  @media (prefers-color-scheme: dark) { :root { --fg: #e6edf3; --bg: #0d1117; --muted: #8b949e; --card: #161b22; --accent: #58a6ff; --err: #f85149; } }
+  body { margin: 0; font: 15px/1.5 system-ui, sans-serif; color: var(--fg); background: var(--bg); }
+  header { position: sticky; top: 0; padding: .6em 1em; background: var(--card); border-bottom: 1px solid var(--muted); display: flex; gap: 1em; align-items: center; }
+  header b { flex: 1; }
+  #state::before { content: "● "; }
+  #state.live { color: #2da44e; } #state.down { color: var(--err); }
+  main { max-width: 60em; margin: 0 auto; padding: 1em; }
+  .msg { margin: 0 0 1em; padding: .6em .9em; border-radius: 6px; background: var(--card); }
+  .role { font-size: .8em; color: var(--muted); text-transform: uppercase; letter-spacing: .05em; }
+  .user { border-left: 3px solid var(--accent); }
+  .text { white-space: pre-wrap; word-wrap: break-word; font-family: ui-monospace, monospace; font-size: .9em; }
+  .tool { margin-top: .4em; font-size: .85em; color: var(--muted); }
+  .tool pre { white-space: pre-wrap; max-height: 20em; overflow: auto; }
+  .error { color: var(--err); }
+  .notice { color: var(--muted); font-size: .85em; }
+</style>
+</head>
+<body>
+<header><b>codybot live view</b><span id="model"></span><span id="state">connecting</span></header>
+<main id="log"></main>
+<script>
+"use strict";
+const log = document.getElementById("log");
+const state = document.getElementById("state");
+const messages = new Map();
+function atBottom() { return window.innerHeight + window.scrollY >= document.body.scrollHeight - 40; }
+function el(tag, cls, text) { const e = document.createElement(tag); if (cls) e.className = cls; if (text !== undefined) e.textContent = text; return e; }
+function message(id, role) {
+  let m = messages.get(id);
+  if (!m) {
+    const box = el("div", "msg " + (role || ""));
+    box.append(el("div", "role", role || ""), el("div", "text", ""));
+    log.append(box);
+    m = { box: box, text: box.lastChild };
+    messages.set(id, m);
+  }
+  return m;
+}
+function handle(ev) {
+  const d = ev.data || {};
+  switch (ev.type) {
+  case "reset": log.replaceChildren(); messages.clear(); break;
+  case "message.start": message(ev.message, d.role); if (d.model) document.getElementById("model").textContent = d.model; break;
+  case "token.delta": message(ev.message, "assistant").text.textContent += d.text; break;
+  case "message.end": message(ev.message, d.role).text.textContent = d.content || ""; break;
+  case "tool.call": message(ev.message, "assistant").box.append(el("div", "tool", "→ " + d.name + " " + d.arguments)); break;
+  case "tool.result": {
+    const details = el("details", "tool");
+    details.append(el("summary", d.error ? "error" : "", "← " + d.name + (d.error ? ": " + d.error : "")), el("pre", "", d.output || ""));
+    message(ev.message, "assistant").box.append(details);
+    break;
+  }
+  case "error": log.append(el("div", "msg error", d.message)); break;
+  case "notice": log.append(el("div", "notice", d.text)); break;
+  }
+}
+const source = new EventSource("events" + location.search);
+source.onopen = () => { state.textContent = "live"; state.className = "live"; };
+source.onerror = () => { state.textContent = "reconnecting"; state.className = "down"; };
+source.onmessage = (e) => {
+  const follow = atBottom();
+  handle(JSON.parse(e.data));
+  if (follow) window.scrollTo(0, document.body.scrollHeight);
+};
+</script>
+</body>
+</html>
+`
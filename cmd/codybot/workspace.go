@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/textarea"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// The workspace is the directory codybot runs in: --workspace changes into
+// it at startup, and file tools may only touch paths inside it.
+
+func enterWorkspace(cfg config) (string, error) {
+	if cfg.Workspace != "" {
+		if err := os.Chdir(cfg.Workspace); err != nil {
+			return "", fmt.Errorf("--workspace: %w", err)
+		}
+	}
+	return workspaceRoot()
+}
+
+func workspaceRoot() (string, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	if real, err := filepath.EvalSymlinks(dir); err == nil {
+		dir = real
+	}
+	return dir, nil
+}
+
+// resolveWorkspacePath checks that path stays inside the workspace, both
+// lexically and after following symlinks, and returns it relative to the
+// workspace root. Paths that do not exist yet are checked through their
+// closest existing parent.
+func resolveWorkspacePath(path string) (string, error) {
+	rel, _, err := workspacePaths(path)
+	return rel, err
+}
+
+// protectedDirs hold what codybot and git act on without asking: hooks, the
+// policy, the audit log and trash, and git's own hooks. The agent may read
+// them but not change them, so content it reads cannot plant a hook or
+// loosen the policy.
+var protectedDirs = []string{".codybot", ".git"}
+
+// resolveWritablePath is resolveWorkspacePath for paths the agent writes or
+// deletes: it also refuses paths in protectedDirs, whether named directly or
+// reached through a symlink.
+func resolveWritablePath(path string) (string, error) {
+	rel, resolved, err := workspacePaths(path)
+	if err != nil {
+		return "", err
+	}
+	for _, p := range []string{rel, resolved} {
+		first, _, _ := strings.Cut(filepath.ToSlash(p), "/")
+		for _, dir := range protectedDirs {
+			// Compared without case for case-insensitive filesystems.
+			if strings.EqualFold(first, dir) {
+				return "", fmt.Errorf("%s is inside %s, which the agent may not change; edit it yourself", path, dir)
+			}
+		}
+	}
+	return rel, nil
+}
+
+// workspacePaths returns path relative to the workspace root, as given and
+// with symlinks followed.
+func workspacePaths(path string) (string, string, error) {
+	root, err := workspaceRoot()
+	if err != nil {
+		return "", "", err
+	}
+	abs := path
+	if !filepath.IsAbs(abs) {
+		abs = filepath.Join(root, abs)
+	}
+	abs = filepath.Clean(abs)
+	if !within(root, abs) {
+		return "", "", fmt.Errorf("%s is outside the workspace %s", path, root)
+	}
+	existing, rest := abs, ""
+	for {
+		if _, err := os.Lstat(existing); err == nil {
+			break
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			break
+		}
+		rest = filepath.Join(filepath.Base(existing), rest)
+		existing = parent
+	}
+	real, err := filepath.EvalSymlinks(existing)
+	if err != nil {
+		return "", "", err
+	}
+	if !within(root, filepath.Join(real, rest)) {
+		return "", "", fmt.Errorf("%s resolves through a symlink to %s, outside the workspace", path, real)
+	}
+	rel, err := filepath.Rel(root, abs)
+	if err != nil {
+		return "", "", err
+	}
+	resolved, err := filepath.Rel(root, filepath.Join(real, rest))
+	if err != nil {
+		return "", "", err
+	}
+	return rel, resolved, nil
+}
+
+func within(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+type trustStore struct {
+	Trusted map[string]time.Time `json:"trusted"`
+}
+
+func trustStorePath() string {
+	return filepath.Join(codybotHome(), "trusted.json")
+}
+
+func loadTrustStore() (trustStore, error) {
+	store := trustStore{Trusted: map[string]time.Time{}}
+	data, err := os.ReadFile(trustStorePath())
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return store, err
+	}
+	if err := json.Unmarshal(data, &store); err != nil {
+		return store, fmt.Errorf("%s: %w", trustStorePath(), err)
+	}
+	if store.Trusted == nil {
+		store.Trusted = map[string]time.Time{}
+	}
+	return store, nil
+}
+
+// isTrusted reports whether dir or one of its parents was trusted before.
+func isTrusted(dir string) bool {
+	store, err := loadTrustStore()
+	if err != nil {
+		return false
+	}
+	for d := dir; ; d = filepath.Dir(d) {
+		if _, ok := store.Trusted[d]; ok {
+			return true
+		}
+		if filepath.Dir(d) == d {
+			return false
+		}
+	}
+}
+
+func trustDir(dir string) error {
+	store, err := loadTrustStore()
+	if err != nil {
+		return err
+	}
+	store.Trusted[dir] = time.Now().UTC()
+	data, err := json.MarshalIndent(store, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(trustStorePath(), data, 0o600)
+}
+
+// requireTrust is the non-interactive gate for run, migrate, and serve: an
+// untrusted workspace is refused unless --trust is passed, which also
+// records the trust.
+func requireTrust(cfg config, root string) error {
+	if isTrusted(root) {
+		return nil
+	}
+	if !cfg.Trust {
+		return fmt.Errorf("workspace %s is not trusted; start codybot there once to trust it, or pass --trust", root)
+	}
+	return trustDir(root)
+}
+
+func (m model) updateTrust(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	var cmd tea.Cmd
+	switch msg.String() {
+	case "y", "Y":
+		if err := trustDir(m.workspace); err != nil {
+			m.lastErr = err
+			return m, nil
+		}
+		m.notice = "Trusted " + m.workspace
+		cmd = pollGit(0)
+	case "n", "N":
+		m.tools = nil
+		m.notice = "Workspace not trusted: tools are disabled for this session"
+	case "ctrl+c", "esc", "q":
+		return m, tea.Quit
+	default:
+		return m, nil
+	}
+	m.state = stateChat
+	if !hasProjectAgents(m.agentFiles) {
+		m.state = stateSetup
+	}
+	return m, tea.Batch(m.spinner.Tick, textarea.Blink, cmd)
+}
+
+func (m model) viewTrust() string {
+	title := headerStyle.Render("Do you trust the files in this folder?")
+	body := fmt.Sprintf("%s\n\ncodybot's tools read and write files and run commands here. Only trust folders whose contents you trust.", m.workspace)
+	if m.lastErr != nil {
+		body += "\n\n" + errorStyle.Render(m.lastErr.Error())
+	}
+	hint := subtleStyle.Render("y trust and remember • n continue without tools • q quit")
+	return lipgloss.JoinVertical(lipgloss.Left, title, "", body, "", hint)
+}
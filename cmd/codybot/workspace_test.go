@@ -0,0 +1,116 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// workspaceFixture makes a workspace next to a directory outside it, with
+// symlinks from one into the other, and changes into the workspace.
+func workspaceFixture(t *testing.T) (root, outside string) {
+	t.Helper()
+	// The temporary directory may itself be behind a symlink, as on macOS.
+	base, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, outside = filepath.Join(base, "work"), filepath.Join(base, "outside")
+	for _, dir := range []string{filepath.Join(root, "src"), filepath.Join(root, ".git"), outside} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	links := map[string]string{
+		"dangling":   filepath.Join(outside, "missing.txt"),
+		"escape":     outside,
+		"escape.txt": filepath.Join(outside, "secret.txt"),
+		"inner":      filepath.Join(root, "src"),
+		"gitlink":    filepath.Join(root, ".git"),
+		"src/up":     "../..",
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Chdir(root)
+	return root, outside
+}
+
+func TestResolveWorkspacePath(t *testing.T) {
+	root, outside := workspaceFixture(t)
+	tests := []struct {
+		path string
+		want string
+		err  string
+	}{
+		{path: "main.go", want: "main.go"},
+		{path: "src/new/file.go", want: "src/new/file.go"},
+		{path: "./src/../main.go", want: "main.go"},
+		{path: filepath.Join(root, "src", "a.go"), want: "src/a.go"},
+		{path: "inner/a.go", want: "inner/a.go"},
+		{path: ".git/config", want: ".git/config"},
+		{path: "../outside/secret.txt", err: "outside the workspace"},
+		{path: "src/../../outside", err: "outside the workspace"},
+		{path: filepath.Join(outside, "secret.txt"), err: "outside the workspace"},
+		{path: "escape/secret.txt", err: "symlink"},
+		{path: "escape/new/file.txt", err: "symlink"},
+		{path: "escape.txt", err: "symlink"},
+		// A link to a file outside that does not exist yet cannot be
+		// followed, which refuses it too.
+		{path: "dangling", err: "missing.txt"},
+		{path: "src/up/outside/secret.txt", err: "symlink"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, err := resolveWorkspacePath(tt.path)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("resolveWorkspacePath(%q) = %q, %v; want an error mentioning %q", tt.path, got, err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolveWorkspacePath(%q): %v", tt.path, err)
+			}
+			if got != filepath.FromSlash(tt.want) {
+				t.Errorf("resolveWorkspacePath(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveWritablePath(t *testing.T) {
+	workspaceFixture(t)
+	tests := []struct {
+		path string
+		err  string
+	}{
+		{path: "src/a.go"},
+		{path: "inner/a.go"},
+		{path: ".github/workflows/ci.yml"},
+		{path: ".git/hooks/pre-commit", err: ".git"},
+		{path: ".GIT/hooks/pre-commit", err: ".git"},
+		{path: "src/../.git/config", err: ".git"},
+		{path: ".codybot/hooks.json", err: ".codybot"},
+		{path: ".codybot", err: ".codybot"},
+		{path: "gitlink/hooks/pre-commit", err: ".git"},
+		{path: "escape/secret.txt", err: "symlink"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			_, err := resolveWritablePath(tt.path)
+			if tt.err == "" && err != nil {
+				t.Fatalf("resolveWritablePath(%q): %v", tt.path, err)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Fatalf("resolveWritablePath(%q) error = %v, want one mentioning %q", tt.path, err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// worktreeFlags are dropped, along with perProcessFlags, from the arguments
+// a codybot in another worktree inherits: its workspace is the worktree.
+var worktreeFlags = map[string]bool{"workspace": true}
+
+// worktree is one git worktree of the repository.
+type worktree struct {
+	path   string
+	branch string
+}
+
+type worktreeMsg struct {
+	note string
+	// branch is set for a new worktree, whose codybot is then opened, and
+	// with conflicts when a merge stopped on them.
+	branch    string
+	conflicts []string
+	err       error
+}
+
+// listWorktrees returns the repository's worktrees, the main one first.
+func listWorktrees() ([]worktree, error) {
+	out, err := runGit("", "worktree", "list", "--porcelain")
+	if err != nil {
+		return nil, err
+	}
+	var trees []worktree
+	for _, line := range strings.Split(out, "\n") {
+		switch {
+		case strings.HasPrefix(line, "worktree "):
+			trees = append(trees, worktree{path: strings.TrimPrefix(line, "worktree ")})
+		case strings.HasPrefix(line, "branch ") && len(trees) > 0:
+			trees[len(trees)-1].branch = strings.TrimPrefix(line, "branch refs/heads/")
+		}
+	}
+	return trees, nil
+}
+
+// findWorktree looks a worktree up by branch or directory name.
+func findWorktree(name string) (worktree, error) {
+	trees, err := listWorktrees()
+	if err != nil {
+		return worktree{}, err
+	}
+	for _, wt := range trees {
+		if wt.branch == name || filepath.Base(wt.path) == name || wt.path == name {
+			return wt, nil
+		}
+	}
+	return worktree{}, fmt.Errorf("no worktree for %q; /worktree lists them", name)
+}
+
+// currentWorktree is the worktree codybot runs in.
+func currentWorktree() string {
+	top, err := runGit("", "rev-parse", "--show-toplevel")
+	if err != nil {
+		return ""
+	}
+	if real, err := filepath.EvalSymlinks(top); err == nil {
+		return real
+	}
+	return top
+}
+
+// worktreeArgs are the arguments that start a codybot in another worktree:
+// this process's own, minus the flags naming per-process resources or the
+// workspace.
+func worktreeArgs() []string {
+	drop := map[string]bool{}
+	for name := range perProcessFlags {
+		drop[name] = true
+	}
+	for name := range worktreeFlags {
+		drop[name] = true
+	}
+	return inheritedArgs(drop)
+}
+
+// cmdWorktree runs agent tasks side by side: each worktree gets its own
+// codybot in a new tmux window or zellij pane, whose file tools are confined
+// to that worktree, and merge brings a finished branch back.
+func (m *model) cmdWorktree(args string) tea.Cmd {
+	sub, rest, _ := strings.Cut(strings.TrimSpace(args), " ")
+	rest = strings.TrimSpace(rest)
+	switch {
+	case sub == "" || sub == "list":
+		m.listWorktrees()
+		return nil
+	case sub == "new" && rest != "":
+		return m.newWorktree(rest)
+	case sub == "open" && rest != "":
+		wt, err := findWorktree(rest)
+		if err != nil {
+			m.lastErr = err
+			return nil
+		}
+		m.openWorktree(wt)
+		return nil
+	case sub == "merge" && rest != "":
+		return m.mergeWorktree(rest)
+	case sub == "remove" && rest != "":
+		wt, err := findWorktree(rest)
+		if err != nil {
+			m.lastErr = err
+			return nil
+		}
+		if wt.path == currentWorktree() {
+			m.lastErr = errors.New("cannot remove the worktree codybot is running in")
+			return nil
+		}
+		if _, err := runGit("", "worktree", "remove", wt.path); err != nil {
+			m.lastErr = fmt.Errorf("worktree remove: %w", err)
+			return nil
+		}
+		m.lastErr = nil
+		m.notice = fmt.Sprintf("Removed worktree %s; branch %s is kept (git branch -d %s deletes it)", displayPath(wt.path), wt.branch, wt.branch)
+		return nil
+	}
+	m.notice = "Usage: /worktree [list|new <branch>|open <branch>|merge <branch>|remove <branch>]"
+	return nil
+}
+
+func (m *model) listWorktrees() {
+	trees, err := listWorktrees()
+	if err != nil {
+		m.lastErr = fmt.Errorf("worktree: %w", err)
+		return
+	}
+	here := currentWorktree()
+	var b strings.Builder
+	b.WriteString("Worktrees:\n")
+	for _, wt := range trees {
+		branch := wt.branch
+		if branch == "" {
+			branch = "(detached)"
+		}
+		var details []string
+		if wt.path == here {
+			details = append(details, "this session")
+		} else if wt.branch != "" {
+			if ahead, err := runGit("", "rev-list", "--count", "HEAD.."+wt.branch); err == nil && ahead != "0" {
+				details = append(details, ahead+" commits to merge")
+			}
+		}
+		if status, err := runGit("", "-C", wt.path, "status", "--porcelain"); err == nil && status != "" {
+			details = append(details, fmt.Sprintf("%d uncommitted", len(strings.Split(status, "\n"))))
+		}
+		line := fmt.Sprintf("  %s  %s", branch, displayPath(wt.path))
+		if len(details) > 0 {
+			line += " (" + strings.Join(details, ", ") + ")"
+		}
+		b.WriteString(line + "\n")
+	}
+	if len(trees) <= 1 {
+		b.WriteString("/worktree new <branch> starts a second agent on its own branch and checkout.")
+	}
+	m.lastErr = nil
+	m.appendNote(strings.TrimRight(b.String(), "\n"))
+}
+
+// newWorktree checks out a new branch from HEAD next to the main worktree
+// and opens a codybot in it.
+func (m *model) newWorktree(branch string) tea.Cmd {
+	if _, err := runGit("", "check-ref-format", "--branch", branch); err != nil {
+		m.lastErr = fmt.Errorf("%q is not a valid branch name", branch)
+		return nil
+	}
+	trees, err := listWorktrees()
+	if err != nil {
+		m.lastErr = fmt.Errorf("worktree: %w", err)
+		return nil
+	}
+	main := trees[0].path
+	dir := filepath.Join(filepath.Dir(main), filepath.Base(main)+"-"+slugify(branch))
+	if _, err := os.Stat(dir); err == nil {
+		m.lastErr = fmt.Errorf("%s already exists; /worktree open %s opens an existing worktree", dir, branch)
+		return nil
+	}
+	// A worktree of a trusted repository is trusted too, so its agent
+	// starts with tools.
+	trusted := m.tools != nil && isTrusted(m.workspace)
+	m.notice = "Creating worktree " + displayPath(dir) + "…"
+	return func() tea.Msg {
+		if _, err := runGit("", "worktree", "add", "-b", branch, dir, "HEAD"); err != nil {
+			return worktreeMsg{err: fmt.Errorf("worktree add: %w", err)}
+		}
+		if trusted {
+			if err := trustDir(dir); err != nil {
+				return worktreeMsg{err: err}
+			}
+		}
+		return worktreeMsg{note: fmt.Sprintf("Created worktree %s on new branch %s.", displayPath(dir), branch), branch: branch}
+	}
+}
+
+// openWorktree starts a codybot in wt in a new tmux window or zellij pane.
+func (m *model) openWorktree(wt worktree) {
+	if wt.path == currentWorktree() {
+		m.notice = "This session already runs in " + displayPath(wt.path)
+		return
+	}
+	self, err := os.Executable()
+	if err != nil {
+		self = os.Args[0]
+	}
+	title := "codybot " + filepath.Base(wt.path)
+	if wt.branch != "" {
+		title = "codybot " + wt.branch
+	}
+	cmd, err := windowCommand(m.cfg, wt.path, title, append([]string{self}, worktreeArgs()...))
+	if err == nil {
+		if output, runErr := cmd.CombinedOutput(); runErr != nil {
+			err = fmt.Errorf("%s: %w: %s", cmd.Args[0], runErr, strings.TrimSpace(string(output)))
+		}
+	}
+	if err != nil {
+		m.appendNote(fmt.Sprintf("Could not open a codybot in %s: %v. Run codybot there in another terminal instead.", displayPath(wt.path), err))
+		return
+	}
+	where := "tmux window"
+	if cmd.Args[0] == multiplexerZellij {
+		where = "zellij pane"
+	}
+	m.lastErr = nil
+	m.appendNote(fmt.Sprintf("Opened a codybot in %s in a new %s. Its file tools only reach that worktree. Commit there (/commit), then /worktree merge %s here.", displayPath(wt.path), where, wt.branch))
+}
+
+// mergeWorktree merges a worktree's branch into this one. Conflicts are left
+// in place, and a prompt asking the agent to resolve them is drafted.
+func (m *model) mergeWorktree(name string) tea.Cmd {
+	if m.streaming {
+		m.notice = "Wait for the current response to finish before merging"
+		return nil
+	}
+	wt, err := findWorktree(name)
+	if err != nil {
+		m.lastErr = err
+		return nil
+	}
+	if wt.branch == "" {
+		m.lastErr = fmt.Errorf("worktree %s has no branch to merge", displayPath(wt.path))
+		return nil
+	}
+	if wt.path == currentWorktree() {
+		m.lastErr = errors.New("cannot merge the worktree codybot is running in into itself")
+		return nil
+	}
+	m.notice = "Merging " + wt.branch + "…"
+	return func() tea.Msg {
+		if status, err := runGit("", "-C", wt.path, "status", "--porcelain"); err != nil {
+			return worktreeMsg{err: err}
+		} else if status != "" {
+			return worktreeMsg{err: fmt.Errorf("%s has uncommitted changes; commit them there first (/commit in its window)", displayPath(wt.path))}
+		}
+		if status, err := runGit("", "status", "--porcelain", "--untracked-files=no"); err != nil {
+			return worktreeMsg{err: err}
+		} else if status != "" {
+			return worktreeMsg{err: errors.New("this worktree has uncommitted changes; commit or stash them before merging")}
+		}
+		commits, err := runGit("", "log", "--oneline", "--no-decorate", "HEAD.."+wt.branch)
+		if err != nil {
+			return worktreeMsg{err: err}
+		}
+		if commits == "" {
+			return worktreeMsg{note: fmt.Sprintf("%s has nothing to merge: its commits are all here already.", wt.branch)}
+		}
+		stat, _ := runGit("", "diff", "--stat", "--no-color", "HEAD..."+wt.branch)
+		summary := fmt.Sprintf("%s\n\n%s", commits, stat)
+		if _, err := runGit("", "merge", "--no-ff", "--no-edit", wt.branch); err != nil {
+			conflicted, _ := runGit("", "diff", "--name-only", "--diff-filter=U")
+			if conflicted == "" {
+				return worktreeMsg{err: fmt.Errorf("merge %s: %w", wt.branch, err)}
+			}
+			return worktreeMsg{
+				note:      fmt.Sprintf("Merging %s stopped on conflicts:\n%s", wt.branch, summary),
+				branch:    wt.branch,
+				conflicts: strings.Split(conflicted, "\n"),
+			}
+		}
+		return worktreeMsg{note: fmt.Sprintf("Merged %s:\n%s", wt.branch, summary)}
+	}
+}
+
+func (m model) handleWorktreeMsg(msg worktreeMsg) (tea.Model, tea.Cmd) {
+	m.notice = ""
+	if msg.err != nil {
+		m.lastErr = msg.err
+		return m, nil
+	}
+	m.lastErr = nil
+	m.appendNote(msg.note)
+	switch {
+	case len(msg.conflicts) > 0:
+		m.input.SetValue(fmt.Sprintf("Resolve the merge conflicts from merging branch %s into this one, in %s. Keep the intent of both sides, remove every conflict marker, and run the tests. Do not commit; I will review and commit the merge.", msg.branch, strings.Join(msg.conflicts, ", ")))
+		m.input.CursorEnd()
+		m.notice = "Enter asks the agent to resolve the conflicts • git merge --abort undoes the merge"
+	case msg.branch != "":
+		// A new worktree: start its agent.
+		wt, err := findWorktree(msg.branch)
+		if err != nil {
+			m.lastErr = err
+			return m, nil
+		}
+		m.openWorktree(wt)
+	}
+	return m, pollGit(0)
+}